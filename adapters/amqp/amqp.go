@@ -0,0 +1,321 @@
+package amqp
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultExchange   = "amq.topic"
+	defaultRoutingKey = "{{.ContainerName}}.{{.Source}}"
+	defaultRetryCount = 10
+)
+
+func init() {
+	router.AdapterFactories.Register(NewAMQPAdapter, "amqp")
+	router.Capabilities.DescribeAdapter("amqp", []string{
+		"exchange", "routing_key", "user", "password", "confirm", "persistent",
+		"batch_size", "flush_interval", "timeout", "json_schema",
+	}, nil)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+var logger = router.NewLogger("amqp")
+
+func debug(v ...interface{}) {
+	logger.Debugln(v...)
+}
+
+// NewAMQPAdapter returns a configured amqp.Adapter for a route address of
+// the form host:port or host:port/vhost
+func NewAMQPAdapter(route *router.Route) (router.LogAdapter, error) {
+	transport, found := router.AdapterTransports.Lookup(route.AdapterTransport("tcp"))
+	if !found {
+		return nil, errors.New("bad transport: " + route.Adapter)
+	}
+	addr, vhost := route.Address, "/"
+	if i := strings.Index(addr, "/"); i >= 0 {
+		addr, vhost = addr[:i], addr[i+1:]
+	}
+	if addr == "" {
+		return nil, errors.New("amqp: address must be host:port or host:port/vhost: " + route.Address)
+	}
+	port := "5672"
+	if route.AdapterTransport("tcp") == "tls" {
+		port = "5671"
+	}
+	addr = router.DefaultPort(addr, port)
+
+	routingKey, err := template.New("routing_key").Parse(router.RouteOpt(route, "routing_key", "AMQP_ROUTING_KEY", defaultRoutingKey))
+	if err != nil {
+		return nil, errors.New("amqp: invalid value for routing_key: " + err.Error())
+	}
+	confirmStr := router.RouteOpt(route, "confirm", "AMQP_CONFIRM", "true")
+	confirm, err := strconv.ParseBool(confirmStr)
+	if err != nil {
+		return nil, errors.New("amqp: invalid value for confirm: " + confirmStr)
+	}
+	persistentStr := router.RouteOpt(route, "persistent", "AMQP_PERSISTENT", "true")
+	persistent, err := strconv.ParseBool(persistentStr)
+	if err != nil {
+		return nil, errors.New("amqp: invalid value for persistent: " + persistentStr)
+	}
+	batchStr := router.RouteOpt(route, "batch_size", "AMQP_BATCH_SIZE", "100")
+	batchSize, err := strconv.Atoi(batchStr)
+	if err != nil || batchSize < 1 {
+		return nil, errors.New("amqp: invalid value for batch_size: " + batchStr)
+	}
+	flushStr := router.RouteOpt(route, "flush_interval", "AMQP_FLUSH_INTERVAL", "1s")
+	flushInterval, err := time.ParseDuration(flushStr)
+	if err != nil || flushInterval <= 0 {
+		return nil, errors.New("amqp: invalid value for flush_interval: " + flushStr)
+	}
+	timeoutStr := router.RouteOpt(route, "timeout", "AMQP_TIMEOUT", "30s")
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil || timeout < 0 {
+		return nil, errors.New("amqp: invalid value for timeout: " + timeoutStr)
+	}
+
+	schema, err := router.NewRouteSchema(route)
+	if err != nil {
+		return nil, errors.New("amqp: " + err.Error())
+	}
+
+	retryCount, err := strconv.Atoi(getopt("RETRY_COUNT", strconv.Itoa(defaultRetryCount)))
+	if err != nil {
+		retryCount = defaultRetryCount
+	}
+
+	a := &Adapter{
+		route:         route,
+		transport:     transport,
+		addr:          addr,
+		vhost:         vhost,
+		user:          router.RouteOpt(route, "user", "AMQP_USER", "guest"),
+		password:      router.RouteOpt(route, "password", "AMQP_PASSWORD", "guest"),
+		exchange:      router.RouteOpt(route, "exchange", "AMQP_EXCHANGE", defaultExchange),
+		routingKey:    routingKey,
+		confirm:       confirm,
+		persistent:    persistent,
+		schema:        schema,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		timeout:       timeout,
+		retryCount:    retryCount,
+	}
+	// fail the route early on bad addresses and credentials
+	if err := a.connect(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Adapter publishes log output to an AMQP 0-9-1 exchange, such as RabbitMQ's
+type Adapter struct {
+	route         *router.Route
+	transport     router.AdapterTransport
+	addr          string
+	vhost         string
+	user          string
+	password      string
+	exchange      string
+	routingKey    *template.Template
+	confirm       bool
+	persistent    bool
+	schema        *router.RouteSchema
+	batchSize     int
+	flushInterval time.Duration
+	timeout       time.Duration
+	retryCount    int
+	conn          *connection
+	batch         []*publishing
+	batched       []*router.Message
+}
+
+// Message extends router.Message with fields for routing key templates
+type Message struct {
+	*router.Message
+}
+
+// ContainerName returns the message's container name
+func (m *Message) ContainerName() string {
+	return strings.TrimPrefix(m.Message.Container.Name, "/")
+}
+
+// ContainerID returns the message's short container id
+func (m *Message) ContainerID() string {
+	id := m.Message.Container.ID
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// Label returns the value of a container label
+func (m *Message) Label(key string) string {
+	if m.Message.Container.Config == nil {
+		return ""
+	}
+	return m.Message.Container.Config.Labels[key]
+}
+
+func (a *Adapter) connect() error {
+	conn, err := router.Dial(a.transport, a.addr, a.route.Options)
+	if err != nil {
+		return err
+	}
+	a.conn, err = open(conn, a.vhost, a.user, a.password, a.confirm, a.timeout)
+	return err
+}
+
+// Stream publishes log data to the exchange in batches
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	defer a.flush()
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				return
+			}
+			if !a.schema.Valid(message, []byte(message.Data)) {
+				continue
+			}
+			p, err := a.newPublishing(message)
+			if err != nil {
+				router.LogDeliveryError("amqp", err)
+				router.Receipts.Report(a.route, message, err)
+				continue
+			}
+			a.batch = append(a.batch, p)
+			a.batched = append(a.batched, message)
+			if len(a.batch) >= a.batchSize || message.Exiting {
+				a.flush()
+			}
+		case <-ticker.C:
+			a.flush()
+		}
+	}
+}
+
+// newPublishing returns the AMQP message for message, with the container
+// metadata and message fields as headers
+func (a *Adapter) newPublishing(message *router.Message) (*publishing, error) {
+	m := &Message{message}
+	key := new(bytes.Buffer)
+	if err := a.routingKey.Execute(key, m); err != nil {
+		return nil, err
+	}
+	headers := make(map[string]string)
+	for field, value := range message.Fields {
+		headers[field] = value
+	}
+	header(headers, "container_id", m.ContainerID())
+	header(headers, "container_name", m.ContainerName())
+	header(headers, "source", message.Source)
+	if message.Container.Config != nil {
+		header(headers, "image", message.Container.Config.Image)
+		header(headers, "hostname", message.Container.Config.Hostname)
+	}
+	return &publishing{
+		exchange:   a.exchange,
+		routingKey: key.String(),
+		headers:    headers,
+		timestamp:  message.Time,
+		persistent: a.persistent,
+		body:       message.Data,
+	}, nil
+}
+
+// header sets a non-empty header
+func header(headers map[string]string, key, value string) {
+	if value != "" {
+		headers[key] = value
+	}
+}
+
+func (a *Adapter) flush() {
+	if len(a.batch) == 0 {
+		return
+	}
+	err := a.publish(a.batch)
+	if err != nil {
+		logger.Error("dropping messages", "messages", len(a.batch), "category", router.ErrorCategory(err), "error", err)
+	}
+	for _, message := range a.batched {
+		router.Receipts.Report(a.route, message, err)
+	}
+	a.batch, a.batched = nil, nil
+}
+
+// publish sends messages, reconnecting and publishing them again until the
+// broker confirms them all. Only nacked messages are published again after
+// a nack, but messages may be delivered twice when a connection fails.
+func (a *Adapter) publish(messages []*publishing) error {
+	defer router.ObserveWrite(a.route, time.Now())
+	for try := 0; ; try++ {
+		var err error
+		if messages, err = a.publishOnce(messages); err == nil {
+			return nil
+		}
+		if try >= a.retryCount {
+			return err
+		}
+		delay := router.RetryDelay(try)
+		debug("amqp: retrying in", delay, "after:", err)
+		router.WaitRetry(a.route, delay, a.batched...)
+	}
+}
+
+// publishOnce publishes messages and returns those to publish again
+func (a *Adapter) publishOnce(messages []*publishing) ([]*publishing, error) {
+	if a.conn == nil {
+		if err := a.connect(); err != nil {
+			return messages, err
+		}
+	}
+	first := a.conn.published + 1
+	for _, p := range messages {
+		a.conn.publish(p)
+	}
+	err := a.conn.flush()
+	if nacked, ok := err.(nackError); ok {
+		// the connection is still usable
+		var retry []*publishing
+		for _, tag := range nacked {
+			retry = append(retry, messages[tag-first])
+		}
+		return retry, err
+	}
+	if err != nil {
+		a.conn.Close()
+		a.conn = nil
+		return messages, err
+	}
+	return nil, nil
+}
+
+// Close closes the connection to the broker
+func (a *Adapter) Close() error {
+	if a.conn == nil {
+		return nil
+	}
+	err := a.conn.Close()
+	a.conn = nil
+	return err
+}
@@ -0,0 +1,236 @@
+package amqp
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	_ "github.com/gliderlabs/logspout/transports/tcp"
+)
+
+var container = &docker.Container{
+	ID:     "8dfafdbc3a40b1b0bd3a0f5c",
+	Name:   "/container",
+	Config: &docker.Config{Image: "app:1.0", Hostname: "8dfafdbc3a40"},
+}
+
+// fakeBroker accepts AMQP connections publishing with confirms, nacking
+// the message with delivery tag nack
+type fakeBroker struct {
+	sync.Mutex
+	ln        net.Listener
+	vhosts    []string
+	auth      []string
+	published []*publishing
+	nack      uint64
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+// method reads the next method frame
+func method(c *connection) (methodID, *decoder) {
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return methodID{}, &decoder{err: err}
+		}
+		if f.typ == frameMethod {
+			d := &decoder{b: f.payload}
+			return d.method(), d
+		}
+	}
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	c := &connection{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn), frameMax: defaultFrameMax}
+	header := make([]byte, len(protocolHeader))
+	if _, err := c.r.Read(header); err != nil || string(header) != string(protocolHeader) {
+		return
+	}
+	e := new(encoder)
+	e.octet(0)
+	e.octet(9)
+	e.table(map[string]string{"product": "fake"})
+	e.longstr("AMQPLAIN PLAIN")
+	e.longstr("en_US")
+	c.send(0, connectionStart, e)
+	if id, d := method(c); id == connectionStartOk {
+		skipTable(d)
+		d.shortstr()
+		b.Lock()
+		b.auth = append(b.auth, d.longstr())
+		b.Unlock()
+	}
+	e = new(encoder)
+	e.short(0)
+	e.long(4096)
+	e.short(60)
+	c.send(0, connectionTune, e)
+	method(c)
+	if id, d := method(c); id == connectionOpen {
+		b.Lock()
+		b.vhosts = append(b.vhosts, d.shortstr())
+		b.Unlock()
+	}
+	e = new(encoder)
+	e.shortstr("")
+	c.send(0, connectionOpenOk, e)
+	method(c)
+	e = new(encoder)
+	e.longstr("")
+	c.send(publishChannel, channelOpenOk, e)
+	method(c)
+	c.send(publishChannel, confirmSelectOk, new(encoder))
+
+	var tag uint64
+	for {
+		id, d := method(c)
+		if d.err != nil || id != basicPublish {
+			return
+		}
+		p := new(publishing)
+		d.short()
+		p.exchange, p.routingKey = d.shortstr(), d.shortstr()
+		f, err := c.readFrame()
+		if err != nil {
+			return
+		}
+		h := &decoder{b: f.payload}
+		h.short()
+		h.short()
+		size := h.longlong()
+		h.short()
+		h.shortstr()
+		p.headers = make(map[string]string)
+		t := &decoder{b: h.next(int(h.long()))}
+		for len(t.b) > 0 && t.err == nil {
+			key := t.shortstr()
+			t.octet()
+			p.headers[key] = t.longstr()
+		}
+		p.persistent = h.octet() == 2
+		for uint64(len(p.body)) < size {
+			if f, err = c.readFrame(); err != nil {
+				return
+			}
+			p.body += string(f.payload)
+		}
+		tag++
+		b.Lock()
+		reply := basicAck
+		if tag == b.nack {
+			reply = basicNack
+		} else {
+			b.published = append(b.published, p)
+		}
+		b.Unlock()
+		e = new(encoder)
+		e.longlong(tag)
+		e.octet(0)
+		c.send(publishChannel, reply, e)
+	}
+}
+
+func (b *fakeBroker) messages() []*publishing {
+	b.Lock()
+	defer b.Unlock()
+	return append([]*publishing(nil), b.published...)
+}
+
+func TestAMQPAdapter(t *testing.T) {
+	broker := newFakeBroker(t)
+	defer broker.ln.Close()
+	broker.nack = 2
+	route := &router.Route{
+		Adapter: "amqp",
+		Address: broker.ln.Addr().String() + "/logs",
+		Options: map[string]string{
+			"exchange":    "events",
+			"routing_key": "logs.{{.ContainerName}}.{{.Source}}",
+			"user":        "logspout",
+			"password":    "secret",
+			"batch_size":  "2",
+		},
+	}
+	adapter, err := NewAMQPAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adapter.(*Adapter).Close()
+
+	logstream := make(chan *router.Message)
+	done := make(chan struct{})
+	go func() {
+		adapter.Stream(logstream)
+		close(done)
+	}()
+	// a big message is split into body frames
+	big := string(make([]byte, 10000))
+	for _, data := range []string{"hello", big, "bye"} {
+		logstream <- &router.Message{
+			Container: container,
+			Source:    "stdout",
+			Data:      data,
+			Time:      time.Now(),
+			Fields:    map[string]string{"level": "info"},
+		}
+	}
+	close(logstream)
+	<-done
+
+	messages := broker.messages()
+	if len(messages) != 3 {
+		t.Fatalf("expected the nacked message published again got %v messages", len(messages))
+	}
+	p := messages[0]
+	if p.exchange != "events" || p.routingKey != "logs.container.stdout" || p.body != "hello" || !p.persistent {
+		t.Errorf("unexpected message %+v", p)
+	}
+	if p.headers["container_id"] != "8dfafdbc3a40" || p.headers["image"] != "app:1.0" || p.headers["level"] != "info" {
+		t.Errorf("unexpected headers %v", p.headers)
+	}
+	if messages[1].body != big || messages[2].body != "bye" {
+		t.Errorf("expected the messages in order got %q", messages[2].body)
+	}
+	broker.Lock()
+	defer broker.Unlock()
+	if broker.vhosts[0] != "logs" || broker.auth[0] != "\x00logspout\x00secret" {
+		t.Errorf("unexpected vhost %q or credentials %q", broker.vhosts[0], broker.auth[0])
+	}
+}
+
+func TestAMQPInvalidOptions(t *testing.T) {
+	for option, value := range map[string]string{
+		"routing_key":    "{{",
+		"confirm":        "maybe",
+		"batch_size":     "0",
+		"flush_interval": "soon",
+		"timeout":        "-1s",
+	} {
+		route := &router.Route{Adapter: "amqp", Address: "127.0.0.1:1", Options: map[string]string{option: value}}
+		if _, err := NewAMQPAdapter(route); err == nil || err.Error()[:5] != "amqp:" {
+			t.Errorf("%s: expected an amqp error got %v", option, err)
+		}
+	}
+}
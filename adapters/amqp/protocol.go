@@ -0,0 +1,537 @@
+package amqp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// the subset of AMQP 0-9-1 needed to publish with confirms, see
+// https://www.rabbitmq.com/resources/specs/amqp0-9-1.pdf
+const (
+	frameMethod    = 1
+	frameHeader    = 2
+	frameBody      = 3
+	frameHeartbeat = 8
+	frameEnd       = 0xce
+
+	// frames are at most frameMax, minus the frame header and end octet
+	frameOverhead   = 8
+	defaultFrameMax = 128 * 1024
+
+	publishChannel = 1
+)
+
+var protocolHeader = []byte("AMQP\x00\x00\x09\x01")
+
+// class and method ids
+type methodID struct {
+	class, method uint16
+}
+
+var (
+	connectionStart   = methodID{10, 10}
+	connectionStartOk = methodID{10, 11}
+	connectionTune    = methodID{10, 30}
+	connectionTuneOk  = methodID{10, 31}
+	connectionOpen    = methodID{10, 40}
+	connectionOpenOk  = methodID{10, 41}
+	connectionClose   = methodID{10, 50}
+	connectionCloseOk = methodID{10, 51}
+	channelOpen       = methodID{20, 10}
+	channelOpenOk     = methodID{20, 11}
+	channelClose      = methodID{20, 40}
+	channelCloseOk    = methodID{20, 41}
+	basicPublish      = methodID{60, 40}
+	basicAck          = methodID{60, 80}
+	basicNack         = methodID{60, 120}
+	confirmSelect     = methodID{85, 10}
+	confirmSelectOk   = methodID{85, 11}
+)
+
+// basic properties flags, from the highest bit
+const (
+	flagContentType  = 1 << 15
+	flagHeaders      = 1 << 13
+	flagDeliveryMode = 1 << 12
+	flagTimestamp    = 1 << 6
+	flagAppID        = 1 << 3
+)
+
+var errMalformed = errors.New("amqp: malformed frame")
+
+// nackError is the delivery tags of the messages the broker nacked
+type nackError []uint64
+
+func (n nackError) Error() string {
+	return fmt.Sprintf("amqp: broker nacked %v messages", len(n))
+}
+
+// encoder writes AMQP fields
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) octet(v byte) {
+	e.WriteByte(v)
+}
+
+func (e *encoder) short(v uint16) {
+	binary.Write(e, binary.BigEndian, v)
+}
+
+func (e *encoder) long(v uint32) {
+	binary.Write(e, binary.BigEndian, v)
+}
+
+func (e *encoder) longlong(v uint64) {
+	binary.Write(e, binary.BigEndian, v)
+}
+
+func (e *encoder) shortstr(s string) {
+	if len(s) > 255 {
+		s = s[:255]
+	}
+	e.octet(byte(len(s)))
+	e.WriteString(s)
+}
+
+func (e *encoder) longstr(s string) {
+	e.long(uint32(len(s)))
+	e.WriteString(s)
+}
+
+// table writes a field table of string values, sorted by key
+func (e *encoder) table(fields map[string]string) {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	t := new(encoder)
+	for _, key := range keys {
+		t.shortstr(key)
+		t.octet('S')
+		t.longstr(fields[key])
+	}
+	e.long(uint32(t.Len()))
+	e.Write(t.Bytes())
+}
+
+// decoder reads AMQP fields, remembering the first error
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || n > len(d.b) {
+		d.err = errMalformed
+		// enough zeroes for the fixed size fields
+		if n > 8 {
+			return nil
+		}
+		return make([]byte, n)
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) octet() byte {
+	return d.next(1)[0]
+}
+
+func (d *decoder) short() uint16 {
+	return binary.BigEndian.Uint16(d.next(2))
+}
+
+func (d *decoder) long() uint32 {
+	return binary.BigEndian.Uint32(d.next(4))
+}
+
+func (d *decoder) longlong() uint64 {
+	return binary.BigEndian.Uint64(d.next(8))
+}
+
+func (d *decoder) shortstr() string {
+	return string(d.next(int(d.octet())))
+}
+
+func (d *decoder) longstr() string {
+	size := d.long()
+	if d.err == nil && uint64(size) > uint64(len(d.b)) {
+		d.err = errMalformed
+		return ""
+	}
+	return string(d.next(int(size)))
+}
+
+// method reads the class and method id of a method frame
+func (d *decoder) method() methodID {
+	return methodID{d.short(), d.short()}
+}
+
+// frame is a frame read from the broker
+type frame struct {
+	typ     byte
+	channel uint16
+	payload []byte
+}
+
+// connection is an AMQP connection publishing on a single channel
+type connection struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	w        *bufio.Writer
+	frameMax uint32
+	confirm  bool
+	timeout  time.Duration
+	// the delivery tag of the last message published, the tags the broker
+	// hasn't confirmed yet and those it nacked
+	published uint64
+	pending   map[uint64]bool
+	nacked    []uint64
+}
+
+// publishing is a message to publish
+type publishing struct {
+	exchange   string
+	routingKey string
+	headers    map[string]string
+	timestamp  time.Time
+	persistent bool
+	body       string
+}
+
+// open negotiates an AMQP connection on conn and opens the channel to
+// publish on, in confirm mode if confirm is set
+func open(conn net.Conn, vhost, user, password string, confirm bool, timeout time.Duration) (*connection, error) {
+	c := &connection{
+		conn:     conn,
+		r:        bufio.NewReader(conn),
+		w:        bufio.NewWriter(conn),
+		frameMax: defaultFrameMax,
+		confirm:  confirm,
+		timeout:  timeout,
+		pending:  make(map[uint64]bool),
+	}
+	if err := c.handshake(vhost, user, password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *connection) handshake(vhost, user, password string) error {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+		defer c.conn.SetDeadline(time.Time{})
+	}
+	c.w.Write(protocolHeader)
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+
+	d, err := c.expect(0, connectionStart)
+	if err != nil {
+		return err
+	}
+	d.octet() // version
+	d.octet()
+	skipTable(d)
+	mechanisms := d.longstr()
+	if d.err != nil {
+		return d.err
+	}
+	if !strings.Contains(" "+mechanisms+" ", " PLAIN ") {
+		return router.NewDeliveryError(router.ErrorAuth, errors.New("amqp: broker doesn't support PLAIN authentication: "+mechanisms))
+	}
+	e := new(encoder)
+	e.table(map[string]string{"product": "logspout"})
+	e.shortstr("PLAIN")
+	e.longstr("\x00" + user + "\x00" + password)
+	e.shortstr("en_US")
+	if err = c.send(0, connectionStartOk, e); err != nil {
+		return err
+	}
+
+	if d, err = c.expect(0, connectionTune); err != nil {
+		return err
+	}
+	channelMax, frameMax, _ := d.short(), d.long(), d.short()
+	if d.err != nil {
+		return d.err
+	}
+	if frameMax != 0 && frameMax < c.frameMax {
+		c.frameMax = frameMax
+	}
+	e = new(encoder)
+	e.short(channelMax)
+	e.long(c.frameMax)
+	// writes fail on dead connections, so heartbeats aren't needed to notice
+	e.short(0)
+	if err = c.send(0, connectionTuneOk, e); err != nil {
+		return err
+	}
+
+	e = new(encoder)
+	e.shortstr(vhost)
+	e.shortstr("")
+	e.octet(0)
+	if err = c.send(0, connectionOpen, e); err != nil {
+		return err
+	}
+	if _, err = c.expect(0, connectionOpenOk); err != nil {
+		return err
+	}
+
+	e = new(encoder)
+	e.shortstr("")
+	if err = c.send(publishChannel, channelOpen, e); err != nil {
+		return err
+	}
+	if _, err = c.expect(publishChannel, channelOpenOk); err != nil {
+		return err
+	}
+	if c.confirm {
+		e = new(encoder)
+		e.octet(0)
+		if err = c.send(publishChannel, confirmSelect, e); err != nil {
+			return err
+		}
+		if _, err = c.expect(publishChannel, confirmSelectOk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipTable skips a field table
+func skipTable(d *decoder) {
+	d.next(int(d.long()))
+}
+
+// send writes a method frame and flushes it
+func (c *connection) send(channel uint16, id methodID, args *encoder) error {
+	c.method(channel, id, args)
+	return c.w.Flush()
+}
+
+// method buffers a method frame
+func (c *connection) method(channel uint16, id methodID, args *encoder) {
+	e := new(encoder)
+	e.short(id.class)
+	e.short(id.method)
+	e.Write(args.Bytes())
+	c.frame(frameMethod, channel, e.Bytes())
+}
+
+// frame buffers a frame
+func (c *connection) frame(typ byte, channel uint16, payload []byte) {
+	header := make([]byte, 7)
+	header[0] = typ
+	binary.BigEndian.PutUint16(header[1:], channel)
+	binary.BigEndian.PutUint32(header[3:], uint32(len(payload)))
+	c.w.Write(header)
+	c.w.Write(payload)
+	c.w.WriteByte(frameEnd)
+}
+
+// readFrame reads the next frame that isn't a heartbeat
+func (c *connection) readFrame() (*frame, error) {
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(c.r, header); err != nil {
+			return nil, err
+		}
+		size := binary.BigEndian.Uint32(header[3:])
+		if size > c.frameMax {
+			return nil, errMalformed
+		}
+		payload := make([]byte, size+1)
+		if _, err := io.ReadFull(c.r, payload); err != nil {
+			return nil, err
+		}
+		if payload[size] != frameEnd {
+			return nil, errMalformed
+		}
+		if header[0] == frameHeartbeat {
+			continue
+		}
+		return &frame{header[0], binary.BigEndian.Uint16(header[1:]), payload[:size]}, nil
+	}
+}
+
+// expect reads the next method, which must be id, and returns its arguments
+func (c *connection) expect(channel uint16, id methodID) (*decoder, error) {
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		if f.typ != frameMethod {
+			continue
+		}
+		d := &decoder{b: f.payload}
+		got := d.method()
+		if d.err != nil {
+			return nil, d.err
+		}
+		if err := c.closed(f.channel, got, d); err != nil {
+			return nil, err
+		}
+		if got == id && f.channel == channel {
+			return d, nil
+		}
+		if got == basicAck || got == basicNack {
+			c.confirmed(got, d)
+		}
+	}
+}
+
+// closed answers the broker closing the connection or channel, returning
+// its reason as an error
+func (c *connection) closed(channel uint16, id methodID, d *decoder) error {
+	if id != connectionClose && id != channelClose {
+		return nil
+	}
+	code, text := d.short(), d.shortstr()
+	reply := connectionCloseOk
+	if id == channelClose {
+		reply = channelCloseOk
+	}
+	c.send(channel, reply, new(encoder))
+	err := fmt.Errorf("amqp: closed by broker: %v %s", code, text)
+	switch code {
+	case 403, 530:
+		// ACCESS_REFUSED and NOT_ALLOWED, as for an unknown vhost
+		return router.NewDeliveryError(router.ErrorAuth, err)
+	case 320:
+		// CONNECTION_FORCED
+		return router.NewDeliveryError(router.ErrorConnect, err)
+	case 502:
+		// SYNTAX_ERROR
+		return router.NewDeliveryError(router.ErrorSerialization, err)
+	}
+	return err
+}
+
+// confirmed records an ack or nack of one, or with multiple all, of the
+// pending delivery tags up to its tag
+func (c *connection) confirmed(id methodID, d *decoder) {
+	tag, multiple := d.longlong(), d.octet()&1 == 1
+	if d.err != nil {
+		return
+	}
+	for pending := range c.pending {
+		if pending == tag || multiple && pending < tag {
+			delete(c.pending, pending)
+			if id == basicNack {
+				c.nacked = append(c.nacked, pending)
+			}
+		}
+	}
+}
+
+// publish buffers a message
+func (c *connection) publish(p *publishing) {
+	e := new(encoder)
+	e.short(0)
+	e.shortstr(p.exchange)
+	e.shortstr(p.routingKey)
+	e.octet(0)
+	c.method(publishChannel, basicPublish, e)
+
+	header := new(encoder)
+	header.short(basicPublish.class)
+	header.short(0)
+	header.longlong(uint64(len(p.body)))
+	header.short(flagContentType | flagHeaders | flagDeliveryMode | flagTimestamp | flagAppID)
+	header.shortstr("text/plain")
+	header.table(p.headers)
+	if p.persistent {
+		header.octet(2)
+	} else {
+		header.octet(1)
+	}
+	header.longlong(uint64(p.timestamp.Unix()))
+	header.shortstr("logspout")
+	c.frame(frameHeader, publishChannel, header.Bytes())
+
+	max := int(c.frameMax - frameOverhead)
+	body := []byte(p.body)
+	for len(body) > 0 {
+		n := len(body)
+		if n > max {
+			n = max
+		}
+		c.frame(frameBody, publishChannel, body[:n])
+		body = body[n:]
+	}
+	if c.confirm {
+		c.published++
+		c.pending[c.published] = true
+	}
+}
+
+// flush writes the buffered messages and, in confirm mode, waits until the
+// broker confirmed them all. It returns an error if any were nacked.
+func (c *connection) flush() error {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+		defer c.conn.SetDeadline(time.Time{})
+	}
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	if !c.confirm {
+		return nil
+	}
+	for len(c.pending) > 0 {
+		f, err := c.readFrame()
+		if err != nil {
+			return err
+		}
+		if f.typ != frameMethod {
+			// the content of returned messages
+			continue
+		}
+		d := &decoder{b: f.payload}
+		id := d.method()
+		if err := c.closed(f.channel, id, d); err != nil {
+			return err
+		}
+		if id == basicAck || id == basicNack {
+			c.confirmed(id, d)
+		}
+	}
+	if len(c.nacked) > 0 {
+		nacked := c.nacked
+		c.nacked = nil
+		sort.Slice(nacked, func(i, j int) bool { return nacked[i] < nacked[j] })
+		return nackError(nacked)
+	}
+	return nil
+}
+
+// Close closes the connection, telling the broker when it can
+func (c *connection) Close() error {
+	c.conn.SetDeadline(time.Now().Add(time.Second))
+	e := new(encoder)
+	e.short(200)
+	e.shortstr("logspout closing")
+	e.short(0)
+	e.short(0)
+	c.send(0, connectionClose, e)
+	return c.conn.Close()
+}
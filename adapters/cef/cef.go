@@ -0,0 +1,236 @@
+package cef
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	// ArcSight truncates the Name header field, and msg, past these
+	maxNameBytes = 512
+	maxMsgBytes  = 1023
+
+	defaultSeverityPattern = `\b(?:level|severity)=["']?([A-Za-z]+)|\b(TRACE|DEBUG|INFO|NOTICE|WARN|WARNING|ERROR|CRITICAL|FATAL|PANIC)\b`
+)
+
+var (
+	// headerEscaper escapes the characters of header fields
+	headerEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`, "\r", " ", "\n", " ")
+	// valueEscaper escapes the characters of extension values
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "=", `\=`, "\r", `\r`, "\n", `\n`)
+)
+
+func init() {
+	router.AdapterFactories.Register(NewCEFAdapter, "cef")
+	router.Capabilities.DescribeAdapter("cef", []string{
+		"vendor", "product", "device_version", "header", "level_field", "severity_pattern",
+	}, nil)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+var logger = router.NewLogger("cef")
+
+// getHostname returns the name of the Docker host found by the chain of
+// HOSTNAME_SOURCES, by default as mounted at /etc/host_hostname, or else
+// the name logspout's container has
+func getHostname() string {
+	if name := router.Hostnames.Get(); name != "" {
+		return name
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// NewCEFAdapter returns a configured cef.Adapter
+func NewCEFAdapter(route *router.Route) (router.LogAdapter, error) {
+	transport, found := router.AdapterTransports.Lookup(route.AdapterTransport("udp"))
+	if !found {
+		return nil, errors.New("bad transport: " + route.Adapter)
+	}
+	header := router.RouteOpt(route, "header", "CEF_HEADER", "syslog")
+	if header != "syslog" && header != "none" {
+		return nil, errors.New("cef: invalid value for header (must be syslog or none): " + header)
+	}
+	pattern := router.RouteOpt(route, "severity_pattern", "CEF_SEVERITY_PATTERN", defaultSeverityPattern)
+	severityPattern, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.New("cef: invalid value for severity_pattern: " + err.Error())
+	}
+	conn, err := router.Dial(transport, route.Address, route.Options)
+	if err != nil {
+		return nil, err
+	}
+	return &Adapter{
+		route: route,
+		conn:  conn,
+		prefix: "CEF:0|" + headerEscaper.Replace(router.RouteOpt(route, "vendor", "CEF_VENDOR", "gliderlabs")) +
+			"|" + headerEscaper.Replace(router.RouteOpt(route, "product", "CEF_PRODUCT", "logspout")) +
+			"|" + headerEscaper.Replace(router.RouteOpt(route, "device_version", "CEF_DEVICE_VERSION", "1")) + "|",
+		header:          header == "syslog",
+		hostname:        getopt("CEF_HOSTNAME", getHostname()),
+		levelField:      router.RouteOpt(route, "level_field", "CEF_LEVEL_FIELD", "level"),
+		severityPattern: severityPattern,
+	}, nil
+}
+
+// Adapter streams log output to a connection as CEF events
+type Adapter struct {
+	conn            net.Conn
+	route           *router.Route
+	prefix          string
+	header          bool
+	hostname        string
+	levelField      string
+	severityPattern *regexp.Regexp
+}
+
+// Stream sends log data to a connection, one event per line
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	for message := range logstream {
+		buf := router.GetBuffer()
+		a.format(buf, message)
+		start := time.Now()
+		_, err := a.conn.Write(buf.Bytes())
+		router.ObserveWrite(a.route, start)
+		router.Receipts.ReportSize(a.route, message, buf.Len(), err)
+		router.PutBuffer(buf)
+		if err != nil {
+			router.LogDeliveryError("cef", err)
+			if !router.Datagram(a.conn) {
+				return
+			}
+		}
+	}
+}
+
+// Close closes the adapter's connection
+func (a *Adapter) Close() error {
+	return a.conn.Close()
+}
+
+// format writes the CEF event of message to buf, after an RFC 3164 syslog
+// header unless the route's header is none:
+//
+//	<PRI>Jan  2 15:04:05 host CEF:0|Vendor|Product|Version|Class|Name|Severity|Extension
+func (a *Adapter) format(buf *bytes.Buffer, message *router.Message) {
+	severity := a.severity(message)
+	if a.header {
+		buf.WriteString("<" + strconv.Itoa(priority(severity)) + ">")
+		buf.WriteString(message.Time.Format(time.Stamp))
+		buf.WriteString(" " + a.hostname + " ")
+	}
+	buf.WriteString(a.prefix)
+	buf.WriteString(headerEscaper.Replace(message.Source))
+	buf.WriteByte('|')
+	buf.WriteString(headerEscaper.Replace(router.Truncate(firstLine(message.Data), maxNameBytes)))
+	buf.WriteByte('|')
+	buf.WriteString(strconv.Itoa(severity))
+	buf.WriteByte('|')
+
+	extension := []string{
+		"rt", strconv.FormatInt(message.Time.UnixNano()/int64(time.Millisecond), 10),
+		"dvchost", a.hostname,
+	}
+	if c := message.Container; c != nil {
+		extension = append(extension,
+			"cs1Label", "containerName", "cs1", strings.TrimPrefix(c.Name, "/"),
+			"cs2Label", "containerId", "cs2", c.ID)
+		if c.Config != nil {
+			extension = append(extension,
+				"cs3Label", "image", "cs3", c.Config.Image,
+				"shost", c.Config.Hostname)
+		}
+	}
+	extension = append(extension, "msg", router.Truncate(message.Data, maxMsgBytes))
+	for i := 0; i < len(extension); i += 2 {
+		if extension[i+1] == "" {
+			continue
+		}
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(extension[i] + "=" + valueEscaper.Replace(extension[i+1]))
+	}
+	buf.WriteByte('\n')
+}
+
+// severity returns the CEF severity of message, from 0 to 10: the level in
+// its level field, or found in its data by the severity pattern, or else 8
+// for stderr and 3 for other sources
+func (a *Adapter) severity(message *router.Message) int {
+	if severity, ok := parseSeverity(message.Fields[a.levelField]); ok {
+		return severity
+	}
+	if match := a.severityPattern.FindStringSubmatch(message.Data); match != nil {
+		for _, level := range match[1:] {
+			if severity, ok := parseSeverity(level); ok {
+				return severity
+			}
+		}
+	}
+	if message.Source == "stderr" {
+		return 8
+	}
+	return 3
+}
+
+// parseSeverity returns the CEF severity of a level name, or of a number
+// from 0 to 10
+func parseSeverity(level string) (int, bool) {
+	switch strings.ToLower(level) {
+	case "trace", "debug":
+		return 1, true
+	case "info", "information":
+		return 3, true
+	case "notice":
+		return 4, true
+	case "warn", "warning":
+		return 6, true
+	case "error", "err":
+		return 8, true
+	case "critical", "crit", "fatal", "panic", "alert", "emerg":
+		return 10, true
+	}
+	if n, err := strconv.Atoi(level); err == nil && n >= 0 && n <= 10 {
+		return n, true
+	}
+	return 0, false
+}
+
+// priority returns the syslog priority in the user facility of a CEF
+// severity
+func priority(severity int) int {
+	const user = 1 << 3
+	switch {
+	case severity >= 9:
+		return user | 2 // crit
+	case severity >= 7:
+		return user | 3 // err
+	case severity >= 4:
+		return user | 4 // warning
+	}
+	return user | 6 // info
+}
+
+// firstLine returns s up to its first newline
+func firstLine(s string) string {
+	if i := strings.IndexAny(s, "\r\n"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,83 @@
+package cef
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	_ "github.com/gliderlabs/logspout/transports/udp"
+)
+
+var container = &docker.Container{
+	ID:     "8dfafdbc3a40b1b0bd3a0f5c",
+	Name:   "/web",
+	Config: &docker.Config{Image: "acme/web:1.0", Hostname: "8dfafdbc3a40"},
+}
+
+func newTestAdapter(header bool) *Adapter {
+	return &Adapter{
+		prefix:          "CEF:0|Acme \\| Co|logspout|1|",
+		header:          header,
+		hostname:        "docker-1",
+		levelField:      "level",
+		severityPattern: regexp.MustCompile(defaultSeverityPattern),
+	}
+}
+
+func TestCEFFormat(t *testing.T) {
+	message := &router.Message{
+		Container: container,
+		Source:    "stdout",
+		Data:      "login failed for user=root\npath=C:\\app|x",
+		Time:      time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC),
+		Fields:    map[string]string{"level": "warn"},
+	}
+	buf := new(bytes.Buffer)
+	newTestAdapter(false).format(buf, message)
+	expected := `CEF:0|Acme \| Co|logspout|1|stdout|login failed for user=root|6|rt=1500000000000 dvchost=docker-1 ` +
+		`cs1Label=containerName cs1=web cs2Label=containerId cs2=8dfafdbc3a40b1b0bd3a0f5c cs3Label=image cs3=acme/web:1.0 ` +
+		`shost=8dfafdbc3a40 msg=login failed for user\=root\npath\=C:\\app|x` + "\n"
+	if buf.String() != expected {
+		t.Errorf("expected %s got %s", expected, buf)
+	}
+
+	buf.Reset()
+	message.Time = time.Date(2017, 7, 4, 2, 40, 0, 0, time.Local)
+	newTestAdapter(true).format(buf, message)
+	if prefix := "<12>Jul  4 02:40:00 docker-1 CEF:0|"; !bytes.HasPrefix(buf.Bytes(), []byte(prefix)) {
+		t.Errorf("expected the syslog header %q got %s", prefix, buf)
+	}
+}
+
+func TestCEFSeverity(t *testing.T) {
+	a := newTestAdapter(false)
+	for _, test := range []struct {
+		message  *router.Message
+		severity int
+	}{
+		{&router.Message{Source: "stdout", Data: "ERROR connection refused", Fields: map[string]string{"level": "debug"}}, 1},
+		{&router.Message{Source: "stdout", Data: "x", Fields: map[string]string{"level": "7"}}, 7},
+		{&router.Message{Source: "stdout", Data: `time=now level="error" msg=refused`}, 8},
+		{&router.Message{Source: "stdout", Data: "2017-07-14 FATAL out of memory"}, 10},
+		{&router.Message{Source: "stdout", Data: "no error here"}, 3},
+		{&router.Message{Source: "stderr", Data: "no error here"}, 8},
+	} {
+		if severity := a.severity(test.message); severity != test.severity {
+			t.Errorf("%q: expected %v got %v", test.message.Data, test.severity, severity)
+		}
+	}
+}
+
+func TestCEFOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"header": "rfc5424"},
+		{"severity_pattern": "("},
+	} {
+		if _, err := NewCEFAdapter(&router.Route{Adapter: "cef", Address: "localhost:514", Options: options}); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+}
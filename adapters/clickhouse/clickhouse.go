@@ -0,0 +1,344 @@
+package clickhouse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	// batches are kept under the default async_insert_max_data_size, the
+	// data ClickHouse buffers for async inserts before writing them
+	maxBatchBytes  = 8 * 1024 * 1024
+	defaultTimeout = 30 * time.Second
+	timeFormat     = "2006-01-02 15:04:05.000000000"
+
+	defaultRetryCount = 10
+)
+
+// identifier matches the table names inserted into without quoting
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func init() {
+	router.AdapterFactories.Register(NewClickHouseAdapter, "clickhouse")
+	router.Capabilities.DescribeAdapter("clickhouse", []string{
+		"database", "table", "user", "password", "async_insert", "batch_size",
+		"flush_interval", "batch_adaptive", "batch_min_size", "batch_target_latency",
+		"json_schema",
+	}, nil)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+var logger = router.NewLogger("clickhouse")
+
+func debug(v ...interface{}) {
+	logger.Debugln(v...)
+}
+
+// NewClickHouseAdapter returns a configured clickhouse.Adapter for a route
+// address of the form host:port of the HTTP interface of ClickHouse
+func NewClickHouseAdapter(route *router.Route) (router.LogAdapter, error) {
+	transport, found := router.AdapterTransports.Lookup(route.AdapterTransport("tcp"))
+	if !found {
+		return nil, errors.New("bad transport: " + route.Adapter)
+	}
+	if route.Address == "" || strings.Contains(route.Address, "/") {
+		return nil, errors.New("clickhouse: address must be host:port: " + route.Address)
+	}
+	scheme, port := "http", "8123"
+	if route.AdapterTransport("tcp") == "tls" {
+		scheme, port = "https", "8443"
+	}
+	addr := router.DefaultPort(route.Address, port)
+
+	table := router.RouteOpt(route, "table", "CLICKHOUSE_TABLE", "logs")
+	if !identifier.MatchString(table) {
+		return nil, errors.New("clickhouse: invalid value for table: " + table)
+	}
+	database := router.RouteOpt(route, "database", "CLICKHOUSE_DATABASE", "")
+	if database != "" && !identifier.MatchString(database) {
+		return nil, errors.New("clickhouse: invalid value for database: " + database)
+	}
+	asyncStr := router.RouteOpt(route, "async_insert", "CLICKHOUSE_ASYNC_INSERT", "false")
+	asyncInsert, err := strconv.ParseBool(asyncStr)
+	if err != nil {
+		return nil, errors.New("clickhouse: invalid value for async_insert: " + asyncStr)
+	}
+	batchStr := router.RouteOpt(route, "batch_size", "CLICKHOUSE_BATCH_SIZE", "10000")
+	batchSize, err := strconv.Atoi(batchStr)
+	if err != nil || batchSize < 1 {
+		return nil, errors.New("clickhouse: invalid value for batch_size: " + batchStr)
+	}
+	flushStr := router.RouteOpt(route, "flush_interval", "CLICKHOUSE_FLUSH_INTERVAL", "1s")
+	flushInterval, err := time.ParseDuration(flushStr)
+	if err != nil || flushInterval <= 0 {
+		return nil, errors.New("clickhouse: invalid value for flush_interval: " + flushStr)
+	}
+	batching, err := router.NewBatchSizer(route, batchSize)
+	if err != nil {
+		return nil, errors.New("clickhouse: " + err.Error())
+	}
+	schema, err := router.NewRouteSchema(route)
+	if err != nil {
+		return nil, errors.New("clickhouse: " + err.Error())
+	}
+
+	retryCount, err := strconv.Atoi(getopt("RETRY_COUNT", strconv.Itoa(defaultRetryCount)))
+	if err != nil {
+		retryCount = defaultRetryCount
+	}
+
+	// connections go through the route's transport, so the TLS settings
+	// and dial_timeout apply
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return transport.Dial(addr, route.Options)
+	}
+	client := &http.Client{Timeout: defaultTimeout}
+	if scheme == "https" {
+		client.Transport = &http.Transport{DialTLSContext: dial}
+	} else {
+		client.Transport = &http.Transport{DialContext: dial}
+	}
+
+	// columns of the table the rows don't have keep their defaults, and
+	// fields the table has no columns for are skipped
+	query := url.Values{
+		"query":                            {"INSERT INTO " + table + " FORMAT JSONEachRow"},
+		"input_format_skip_unknown_fields": {"1"},
+	}
+	if database != "" {
+		query.Set("database", database)
+	}
+	if asyncInsert {
+		// waiting for the buffered rows to be written keeps receipts and
+		// retries accurate
+		query.Set("async_insert", "1")
+		query.Set("wait_for_async_insert", "1")
+	}
+
+	return &Adapter{
+		route:         route,
+		transport:     transport,
+		addr:          addr,
+		client:        client,
+		url:           scheme + "://" + addr + "/?" + query.Encode(),
+		user:          router.RouteOpt(route, "user", "CLICKHOUSE_USER", ""),
+		password:      router.RouteOpt(route, "password", "CLICKHOUSE_PASSWORD", ""),
+		batching:      batching,
+		schema:        schema,
+		flushInterval: flushInterval,
+		retryCount:    retryCount,
+	}, nil
+}
+
+// Adapter inserts log output into a ClickHouse table through its HTTP
+// interface
+type Adapter struct {
+	route         *router.Route
+	transport     router.AdapterTransport
+	addr          string
+	client        *http.Client
+	url           string
+	user          string
+	password      string
+	batching      *router.BatchSizer
+	schema        *router.RouteSchema
+	flushInterval time.Duration
+	retryCount    int
+	rows          bytes.Buffer
+	batched       []*router.Message
+}
+
+// row is the JSONEachRow row a message is inserted as
+type row struct {
+	Timestamp     string            `json:"timestamp"`
+	ContainerID   string            `json:"container_id"`
+	ContainerName string            `json:"container_name"`
+	Image         string            `json:"image"`
+	Hostname      string            `json:"hostname"`
+	Source        string            `json:"source"`
+	Message       string            `json:"message"`
+	Labels        map[string]string `json:"labels"`
+	Fields        map[string]string `json:"fields"`
+}
+
+// Warm connects to ClickHouse before the first insert
+func (a *Adapter) Warm() error {
+	conn, err := a.transport.Dial(a.addr, a.route.Options)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Stream inserts log data into ClickHouse in batches
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	defer a.flush()
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				return
+			}
+			data, err := a.render(message)
+			if err != nil {
+				router.LogDeliveryError("clickhouse", err)
+				router.Receipts.Report(a.route, message, err)
+				continue
+			}
+			if !a.schema.Valid(message, data) {
+				continue
+			}
+			if len(a.batched) > 0 && a.rows.Len()+len(data) > maxBatchBytes {
+				a.flush()
+			}
+			a.rows.Write(data)
+			a.batched = append(a.batched, message)
+			if len(a.batched) >= a.batching.Size() || message.Exiting {
+				a.flush()
+			}
+		case <-ticker.C:
+			a.flush()
+		}
+	}
+}
+
+// render returns the row of message as a line of JSON
+func (a *Adapter) render(message *router.Message) ([]byte, error) {
+	r := &row{
+		Timestamp:     message.Time.UTC().Format(timeFormat),
+		ContainerID:   message.Container.ID,
+		ContainerName: strings.TrimPrefix(message.Container.Name, "/"),
+		Source:        message.Source,
+		Message:       message.Data,
+		Labels:        map[string]string{},
+		Fields:        message.Fields,
+	}
+	if r.Fields == nil {
+		r.Fields = map[string]string{}
+	}
+	if message.Container.Config != nil {
+		r.Image = message.Container.Config.Image
+		r.Hostname = message.Container.Config.Hostname
+		if message.Container.Config.Labels != nil {
+			r.Labels = message.Container.Config.Labels
+		}
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, router.NewDeliveryError(router.ErrorSerialization, err)
+	}
+	return append(data, '\n'), nil
+}
+
+func (a *Adapter) flush() {
+	if len(a.batched) == 0 {
+		return
+	}
+	start := time.Now()
+	err := a.insert(a.rows.Bytes())
+	a.batching.Observe(len(a.batched), time.Since(start), err)
+	if err != nil {
+		logger.Error("dropping messages", "messages", len(a.batched), "category", router.ErrorCategory(err), "error", err)
+	}
+	for _, message := range a.batched {
+		router.Receipts.Report(a.route, message, err)
+	}
+	a.rows.Reset()
+	a.batched = nil
+}
+
+// insert sends rows in one INSERT, retrying throttled requests, server
+// errors and network errors with backoff
+func (a *Adapter) insert(rows []byte) error {
+	defer router.ObserveWrite(a.route, time.Now())
+	for try := 0; ; try++ {
+		retryable, err := a.post(rows)
+		if err == nil {
+			return nil
+		}
+		if !retryable || try >= a.retryCount {
+			return err
+		}
+		delay := router.RetryDelay(try)
+		debug("clickhouse: retrying in", delay, "after:", err)
+		router.WaitRetry(a.route, delay, a.batched...)
+	}
+}
+
+// post sends one insert request, returning whether it failed in a way
+// worth retrying
+func (a *Adapter) post(rows []byte) (bool, error) {
+	req, err := http.NewRequest("POST", a.url, bytes.NewReader(rows))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("User-Agent", "logspout")
+	if a.user != "" {
+		req.Header.Set("X-ClickHouse-User", a.user)
+		req.Header.Set("X-ClickHouse-Key", a.password)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	// ClickHouse explains failed inserts, e.g. a missing table or a row
+	// that doesn't parse, with the code of the exception
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = router.NewDeliveryError(router.HTTPErrorCategory(resp.StatusCode),
+		fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, bytes.TrimSpace(message)))
+	return retryable(resp), err
+}
+
+// retryable returns whether a failed insert may succeed when sent again.
+// ClickHouse answers most exceptions with 500 Internal Server Error, so
+// the exception code tells the ones about the rows or the table apart.
+func retryable(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		return true
+	}
+	if resp.StatusCode < 500 {
+		return false
+	}
+	switch resp.Header.Get("X-ClickHouse-Exception-Code") {
+	case "16", // NO_SUCH_COLUMN_IN_TABLE
+		"27",  // CANNOT_PARSE_INPUT_ASSERTION_FAILED
+		"60",  // UNKNOWN_TABLE
+		"62",  // SYNTAX_ERROR
+		"81",  // UNKNOWN_DATABASE
+		"117", // INCORRECT_DATA
+		"497", // ACCESS_DENIED
+		"516": // AUTHENTICATION_FAILED
+		return false
+	}
+	return true
+}
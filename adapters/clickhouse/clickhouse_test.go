@@ -0,0 +1,168 @@
+package clickhouse
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	_ "github.com/gliderlabs/logspout/transports/tcp"
+)
+
+var container = &docker.Container{
+	ID:   "8dfafdbc3a40b1b0bd3a0f5c",
+	Name: "/app",
+	Config: &docker.Config{
+		Image:    "app:1.0",
+		Hostname: "8dfafdbc3a40",
+		Labels:   map[string]string{"com.docker.compose.service": "web"},
+	},
+}
+
+// fakeClickHouse records inserted rows, answering the first failed
+// requests with an exception
+type fakeClickHouse struct {
+	sync.Mutex
+	failed  int
+	code    string
+	queries []string
+	users   []string
+	rows    []map[string]interface{}
+}
+
+func (f *fakeClickHouse) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if f.failed > 0 {
+		f.failed--
+		w.Header().Set("X-ClickHouse-Exception-Code", f.code)
+		http.Error(w, "Code: "+f.code+". DB::Exception: failed", http.StatusInternalServerError)
+		return
+	}
+	f.queries = append(f.queries, req.URL.RawQuery)
+	f.users = append(f.users, req.Header.Get("X-ClickHouse-User"))
+	scanner := bufio.NewScanner(req.Body)
+	for scanner.Scan() {
+		row := make(map[string]interface{})
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.rows = append(f.rows, row)
+	}
+}
+
+func newTestAdapter(t *testing.T, server *httptest.Server, options map[string]string) *Adapter {
+	adapter, err := NewClickHouseAdapter(&router.Route{
+		Adapter: "clickhouse",
+		Address: strings.TrimPrefix(server.URL, "http://"),
+		Options: options,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return adapter.(*Adapter)
+}
+
+func TestClickHouseInserts(t *testing.T) {
+	f := new(fakeClickHouse)
+	server := httptest.NewServer(f)
+	defer server.Close()
+	a := newTestAdapter(t, server, map[string]string{
+		"flush_interval": "1h", "batch_size": "3", "table": "container_logs",
+		"database": "ops", "async_insert": "true", "user": "logspout",
+	})
+	logstream := make(chan *router.Message)
+	done := make(chan struct{})
+	go func() {
+		a.Stream(logstream)
+		close(done)
+	}()
+	for i := 0; i < 4; i++ {
+		logstream <- &router.Message{
+			Container: container,
+			Source:    "stdout",
+			Data:      "line " + strconv.Itoa(i),
+			Time:      time.Unix(1500000000, 123456789),
+			Fields:    map[string]string{"level": "info"},
+		}
+	}
+	close(logstream)
+	<-done
+
+	f.Lock()
+	defer f.Unlock()
+	if len(f.queries) != 2 || len(f.rows) != 4 {
+		t.Fatalf("expected 4 rows in 2 inserts got %v in %v", len(f.rows), len(f.queries))
+	}
+	for _, param := range []string{"query=INSERT+INTO+container_logs+FORMAT+JSONEachRow", "database=ops", "async_insert=1", "wait_for_async_insert=1"} {
+		if !strings.Contains(f.queries[0], param) {
+			t.Errorf("expected %s in %s", param, f.queries[0])
+		}
+	}
+	if f.users[0] != "logspout" {
+		t.Errorf("expected the user logspout got %q", f.users[0])
+	}
+	row := f.rows[0]
+	for key, value := range map[string]string{
+		"timestamp":      "2017-07-14 02:40:00.123456789",
+		"container_id":   container.ID,
+		"container_name": "app",
+		"image":          "app:1.0",
+		"source":         "stdout",
+		"message":        "line 0",
+	} {
+		if row[key] != value {
+			t.Errorf("expected %s %q got %v", key, value, row[key])
+		}
+	}
+	if labels, _ := row["labels"].(map[string]interface{}); labels["com.docker.compose.service"] != "web" {
+		t.Errorf("expected the container labels got %v", row["labels"])
+	}
+	if fields, _ := row["fields"].(map[string]interface{}); fields["level"] != "info" {
+		t.Errorf("expected the message fields got %v", row["fields"])
+	}
+}
+
+func TestClickHouseRetries(t *testing.T) {
+	f := &fakeClickHouse{failed: 1, code: "202"} // TOO_MANY_SIMULTANEOUS_QUERIES
+	server := httptest.NewServer(f)
+	defer server.Close()
+	a := newTestAdapter(t, server, map[string]string{})
+	if err := a.insert([]byte(`{"message":"retried"}` + "\n")); err != nil {
+		t.Errorf("expected the insert retried got %v", err)
+	}
+
+	f.failed, f.code = 1, "60" // UNKNOWN_TABLE
+	if err := a.insert([]byte(`{"message":"dropped"}` + "\n")); err == nil || !strings.Contains(err.Error(), "Code: 60") {
+		t.Errorf("expected the exception got %v", err)
+	}
+	f.Lock()
+	defer f.Unlock()
+	if len(f.rows) != 1 || f.failed != 0 {
+		t.Errorf("expected only the first insert retried got %v rows", len(f.rows))
+	}
+}
+
+func TestClickHouseOptions(t *testing.T) {
+	for _, route := range []*router.Route{
+		{Adapter: "clickhouse", Address: ""},
+		{Adapter: "clickhouse", Address: "clickhouse:8123/logs"},
+		{Adapter: "clickhouse", Address: "clickhouse", Options: map[string]string{"table": "logs; DROP TABLE logs"}},
+		{Adapter: "clickhouse", Address: "clickhouse", Options: map[string]string{"database": "ops.logs"}},
+		{Adapter: "clickhouse", Address: "clickhouse", Options: map[string]string{"async_insert": "maybe"}},
+		{Adapter: "clickhouse", Address: "clickhouse", Options: map[string]string{"batch_size": "0"}},
+		{Adapter: "clickhouse", Address: "clickhouse", Options: map[string]string{"flush_interval": "0"}},
+	} {
+		if _, err := NewClickHouseAdapter(route); err == nil {
+			t.Errorf("expected an error for %v %v", route.Address, route.Options)
+		}
+	}
+}
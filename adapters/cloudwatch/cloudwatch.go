@@ -0,0 +1,367 @@
+package cloudwatch
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gliderlabs/logspout/internal/aws"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	// PutLogEvents limits, see
+	// https://docs.aws.amazon.com/AmazonCloudWatchLogs/latest/APIReference/API_PutLogEvents.html
+	maxBatchEvents = 10000
+	maxBatchBytes  = 1048576
+	eventOverhead  = 26
+	maxEventBytes  = 262144 - eventOverhead
+	maxBatchSpan   = 24 * time.Hour
+
+	defaultRetryCount = 10
+	targetPrefix      = "Logs_20140328."
+)
+
+func init() {
+	router.AdapterFactories.Register(NewCloudWatchAdapter, "cloudwatch")
+	router.Capabilities.DescribeAdapter("cloudwatch", []string{
+		"endpoint", "group", "stream", "flush_interval", "batch_adaptive", "batch_min_size", "batch_target_latency",
+	}, nil)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+var logger = router.NewLogger("cloudwatch")
+
+func debug(v ...interface{}) {
+	logger.Debugln(v...)
+}
+
+// NewCloudWatchAdapter returns a configured cloudwatch.Adapter
+func NewCloudWatchAdapter(route *router.Route) (router.LogAdapter, error) {
+	client, err := aws.NewClient("logs", route.Address)
+	if err != nil {
+		return nil, err
+	}
+	if route.Options["endpoint"] != "" {
+		client.Endpoint = route.Options["endpoint"]
+	}
+
+	groupStr := router.RouteOpt(route, "group", "CLOUDWATCH_LOG_GROUP", "{{.ContainerName}}")
+	groupTmpl, err := template.New("group").Parse(groupStr)
+	if err != nil {
+		return nil, err
+	}
+	streamStr := router.RouteOpt(route, "stream", "CLOUDWATCH_LOG_STREAM", "{{.ContainerID}}")
+	streamTmpl, err := template.New("stream").Parse(streamStr)
+	if err != nil {
+		return nil, err
+	}
+
+	flushStr := router.RouteOpt(route, "flush_interval", "CLOUDWATCH_FLUSH_INTERVAL", "1s")
+	flushInterval, err := time.ParseDuration(flushStr)
+	if err != nil || flushInterval <= 0 {
+		return nil, errors.New("cloudwatch: invalid value for flush_interval: " + flushStr)
+	}
+
+	retryCount, err := strconv.Atoi(getopt("RETRY_COUNT", strconv.Itoa(defaultRetryCount)))
+	if err != nil {
+		retryCount = defaultRetryCount
+	}
+	batching, err := router.NewBatchSizer(route, maxBatchEvents)
+	if err != nil {
+		return nil, errors.New("cloudwatch: " + err.Error())
+	}
+
+	return &Adapter{
+		route:         route,
+		client:        client,
+		groupTmpl:     groupTmpl,
+		streamTmpl:    streamTmpl,
+		flushInterval: flushInterval,
+		retryCount:    retryCount,
+		batching:      batching,
+		batches:       make(map[streamKey]*batch),
+		tokens:        make(map[streamKey]string),
+		groups:        make(map[string]bool),
+	}, nil
+}
+
+// Adapter batches log output into CloudWatch Logs streams
+type Adapter struct {
+	route         *router.Route
+	client        *aws.Client
+	groupTmpl     *template.Template
+	streamTmpl    *template.Template
+	flushInterval time.Duration
+	retryCount    int
+	batching      *router.BatchSizer
+	batches       map[streamKey]*batch
+	tokens        map[streamKey]string
+	groups        map[string]bool
+}
+
+type streamKey struct {
+	group  string
+	stream string
+}
+
+type inputLogEvent struct {
+	Message   string `json:"message"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type batch struct {
+	events   []inputLogEvent
+	messages []*router.Message
+	bytes    int
+	first    int64
+	last     int64
+}
+
+// fits returns whether event can be added without exceeding PutLogEvents limits
+func (b *batch) fits(event inputLogEvent) bool {
+	if len(b.events) == 0 {
+		return true
+	}
+	if len(b.events)+1 > maxBatchEvents || b.bytes+len(event.Message)+eventOverhead > maxBatchBytes {
+		return false
+	}
+	first, last := b.first, b.last
+	if event.Timestamp < first {
+		first = event.Timestamp
+	}
+	if event.Timestamp > last {
+		last = event.Timestamp
+	}
+	return time.Duration(last-first)*time.Millisecond <= maxBatchSpan
+}
+
+func (b *batch) add(event inputLogEvent, message *router.Message) {
+	if len(b.events) == 0 || event.Timestamp < b.first {
+		b.first = event.Timestamp
+	}
+	if event.Timestamp > b.last {
+		b.last = event.Timestamp
+	}
+	b.events = append(b.events, event)
+	b.messages = append(b.messages, message)
+	b.bytes += len(event.Message) + eventOverhead
+}
+
+// Message extends router.Message with fields for log group and stream templates
+type Message struct {
+	*router.Message
+}
+
+// ContainerName returns the message's container name
+func (m *Message) ContainerName() string {
+	return strings.TrimPrefix(m.Message.Container.Name, "/")
+}
+
+// ContainerID returns the message's short container id
+func (m *Message) ContainerID() string {
+	id := m.Message.Container.ID
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// Stream sends log data to CloudWatch Logs
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	defer a.flushAll()
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				return
+			}
+			key, err := a.streamKey(message)
+			if err != nil {
+				router.LogDeliveryError("cloudwatch", err)
+				continue
+			}
+			event := inputLogEvent{
+				Message:   router.Truncate(message.Data, maxEventBytes),
+				Timestamp: message.Time.UnixNano() / int64(time.Millisecond),
+			}
+			b, ok := a.batches[key]
+			if !ok {
+				b = new(batch)
+				a.batches[key] = b
+			}
+			if !b.fits(event) || len(b.events) >= a.batching.Size() {
+				a.flush(key)
+				b = new(batch)
+				a.batches[key] = b
+			}
+			b.add(event, message)
+			if message.Exiting {
+				// send the last output of exited containers right away
+				a.flush(key)
+			}
+		case <-ticker.C:
+			a.flushAll()
+		}
+	}
+}
+
+func (a *Adapter) streamKey(message *router.Message) (streamKey, error) {
+	m := &Message{message}
+	group := new(bytes.Buffer)
+	if err := a.groupTmpl.Execute(group, m); err != nil {
+		return streamKey{}, err
+	}
+	stream := new(bytes.Buffer)
+	if err := a.streamTmpl.Execute(stream, m); err != nil {
+		return streamKey{}, err
+	}
+	return streamKey{group: group.String(), stream: stream.String()}, nil
+}
+
+func (a *Adapter) flushAll() {
+	for key := range a.batches {
+		a.flush(key)
+	}
+}
+
+func (a *Adapter) flush(key streamKey) {
+	b := a.batches[key]
+	delete(a.batches, key)
+	if b == nil || len(b.events) == 0 {
+		return
+	}
+	start := time.Now()
+	err := a.put(key, b.events, b.messages)
+	a.batching.Observe(len(b.events), time.Since(start), err)
+	if err != nil {
+		logger.Error("dropping events", "events", len(b.events), "group", key.group, "stream", key.stream,
+			"category", router.ErrorCategory(err), "error", err)
+	}
+	for _, message := range b.messages {
+		router.Receipts.Report(a.route, message, err)
+	}
+}
+
+type putLogEventsInput struct {
+	LogGroupName  string          `json:"logGroupName"`
+	LogStreamName string          `json:"logStreamName"`
+	LogEvents     []inputLogEvent `json:"logEvents"`
+	SequenceToken string          `json:"sequenceToken,omitempty"`
+}
+
+type putLogEventsOutput struct {
+	NextSequenceToken     string                 `json:"nextSequenceToken"`
+	RejectedLogEventsInfo map[string]interface{} `json:"rejectedLogEventsInfo"`
+}
+
+func (a *Adapter) put(key streamKey, events []inputLogEvent, messages []*router.Message) error {
+	defer router.ObserveWrite(a.route, time.Now())
+	// events within a batch must be in chronological order
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp < events[j].Timestamp
+	})
+	created := false
+	for try := 0; ; try++ {
+		out := new(putLogEventsOutput)
+		err := a.client.Call(targetPrefix+"PutLogEvents", &putLogEventsInput{
+			LogGroupName:  key.group,
+			LogStreamName: key.stream,
+			LogEvents:     events,
+			SequenceToken: a.tokens[key],
+		}, out)
+		if err == nil {
+			a.tokens[key] = out.NextSequenceToken
+			if out.RejectedLogEventsInfo != nil {
+				logger.Warn("rejected events", "group", key.group, "stream", key.stream, "rejected", out.RejectedLogEventsInfo)
+			}
+			return nil
+		}
+
+		apiErr, isAPIErr := err.(*aws.Error)
+		switch {
+		case isAPIErr && apiErr.Type == "ResourceNotFoundException" && !created:
+			debug("cloudwatch: creating log stream", key.group, key.stream)
+			if err := a.createStream(key); err != nil {
+				return err
+			}
+			created = true
+			continue
+		case isAPIErr && apiErr.Type == "InvalidSequenceTokenException":
+			a.tokens[key] = expectedSequenceToken(apiErr.Message)
+		case isAPIErr && apiErr.Type == "DataAlreadyAcceptedException":
+			a.tokens[key] = expectedSequenceToken(apiErr.Message)
+			return nil
+		case isAPIErr && !apiErr.Retryable():
+			return err
+		default:
+			// throttling, server errors and network errors
+			delay := router.RetryDelay(try)
+			debug("cloudwatch: retrying in", delay, "after:", err)
+			router.WaitRetry(a.route, delay, messages...)
+		}
+		if try >= a.retryCount {
+			return err
+		}
+	}
+}
+
+type createLogGroupInput struct {
+	LogGroupName string `json:"logGroupName"`
+}
+
+type createLogStreamInput struct {
+	LogGroupName  string `json:"logGroupName"`
+	LogStreamName string `json:"logStreamName"`
+}
+
+func (a *Adapter) createStream(key streamKey) error {
+	if !a.groups[key.group] {
+		err := a.client.Call(targetPrefix+"CreateLogGroup",
+			&createLogGroupInput{LogGroupName: key.group}, nil)
+		if err != nil && !alreadyExists(err) {
+			return err
+		}
+		a.groups[key.group] = true
+	}
+	err := a.client.Call(targetPrefix+"CreateLogStream",
+		&createLogStreamInput{LogGroupName: key.group, LogStreamName: key.stream}, nil)
+	if err != nil && !alreadyExists(err) {
+		return err
+	}
+	delete(a.tokens, key)
+	return nil
+}
+
+func alreadyExists(err error) bool {
+	apiErr, ok := err.(*aws.Error)
+	return ok && apiErr.Type == "ResourceAlreadyExistsException"
+}
+
+// expectedSequenceToken extracts the token from messages like
+// "The given sequenceToken is invalid. The next expected sequenceToken is: 4963..."
+func expectedSequenceToken(message string) string {
+	i := strings.LastIndex(message, "is: ")
+	if i < 0 {
+		return ""
+	}
+	token := strings.TrimSpace(message[i+len("is: "):])
+	if token == "null" {
+		return ""
+	}
+	return token
+}
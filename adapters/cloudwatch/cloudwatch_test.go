@@ -0,0 +1,144 @@
+package cloudwatch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+var container = &docker.Container{
+	ID:   "8dfafdbc3a40b1b0bd3a0f5c",
+	Name: "/container",
+}
+
+type fakeCloudWatch struct {
+	sync.Mutex
+	targets []string
+	events  []inputLogEvent
+	tokens  []string
+	streams map[string]bool
+}
+
+func (f *fakeCloudWatch) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	target := strings.TrimPrefix(req.Header.Get("X-Amz-Target"), targetPrefix)
+	f.targets = append(f.targets, target)
+	switch target {
+	case "CreateLogGroup":
+		w.Write([]byte("{}"))
+	case "CreateLogStream":
+		in := new(createLogStreamInput)
+		json.NewDecoder(req.Body).Decode(in)
+		f.streams[in.LogGroupName+"/"+in.LogStreamName] = true
+		w.Write([]byte("{}"))
+	case "PutLogEvents":
+		in := new(putLogEventsInput)
+		json.NewDecoder(req.Body).Decode(in)
+		if !f.streams[in.LogGroupName+"/"+in.LogStreamName] {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type": "ResourceNotFoundException", "message": "The specified log stream does not exist."}`))
+			return
+		}
+		f.tokens = append(f.tokens, in.SequenceToken)
+		f.events = append(f.events, in.LogEvents...)
+		w.Write([]byte(`{"nextSequenceToken": "token` + strconv.Itoa(len(f.tokens)) + `"}`))
+	}
+}
+
+func TestCloudWatchCreatesStreamAndTracksTokens(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	fake := &fakeCloudWatch{streams: make(map[string]bool)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	route := &router.Route{
+		Adapter: "cloudwatch",
+		Address: "us-east-1",
+		Options: map[string]string{"endpoint": server.URL, "flush_interval": "1h"},
+	}
+	adapter, err := NewCloudWatchAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := adapter.(*Adapter)
+
+	now := time.Now()
+	key := streamKey{group: "container", stream: "8dfafdbc3a40"}
+	for _, data := range []string{"second", "first"} {
+		if err := a.put(key, []inputLogEvent{{Message: data, Timestamp: now.UnixNano() / 1e6}}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expectedTargets := "PutLogEvents,CreateLogGroup,CreateLogStream,PutLogEvents,PutLogEvents"
+	if actual := strings.Join(fake.targets, ","); actual != expectedTargets {
+		t.Errorf("expected calls %s got %s", expectedTargets, actual)
+	}
+	if len(fake.tokens) != 2 || fake.tokens[0] != "" || fake.tokens[1] != "token1" {
+		t.Errorf("expected sequence tokens [ token1] got %v", fake.tokens)
+	}
+}
+
+func TestCloudWatchStreamKey(t *testing.T) {
+	os.Setenv("AWS_REGION", "us-east-1")
+	defer os.Unsetenv("AWS_REGION")
+	route := &router.Route{
+		Adapter: "cloudwatch",
+		Options: map[string]string{"group": "/docker/{{.ContainerName}}"},
+	}
+	adapter, err := NewCloudWatchAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := adapter.(*Adapter).streamKey(&router.Message{Container: container})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := streamKey{group: "/docker/container", stream: "8dfafdbc3a40"}
+	if key != expected {
+		t.Errorf("expected %v got %v", expected, key)
+	}
+}
+
+func TestCloudWatchBatchLimits(t *testing.T) {
+	b := new(batch)
+	big := strings.Repeat("x", maxEventBytes)
+	// four maximum sized events exactly fill a batch
+	for i := 0; i < 4; i++ {
+		event := inputLogEvent{Message: big}
+		if !b.fits(event) {
+			t.Fatalf("expected event %v to fit", i)
+		}
+		b.add(event, nil)
+	}
+	if b.fits(inputLogEvent{Message: big}) {
+		t.Error("expected batch to be full by size")
+	}
+
+	b = new(batch)
+	b.add(inputLogEvent{Timestamp: 0}, nil)
+	if b.fits(inputLogEvent{Timestamp: int64(25 * time.Hour / time.Millisecond)}) {
+		t.Error("expected batch to be full by time span")
+	}
+}
+
+func TestExpectedSequenceToken(t *testing.T) {
+	msg := "The given sequenceToken is invalid. The next expected sequenceToken is: 49590302"
+	if token := expectedSequenceToken(msg); token != "49590302" {
+		t.Errorf("expected 49590302 got %s", token)
+	}
+}
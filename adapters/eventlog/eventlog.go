@@ -0,0 +1,85 @@
+// Package eventlog writes messages to the Windows Event Log. The adapter is
+// only registered in Windows builds.
+package eventlog
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// event types, see https://docs.microsoft.com/en-us/windows/win32/api/winbase/nf-winbase-reporteventw
+const (
+	typeError       = 0x0001
+	typeWarning     = 0x0002
+	typeInformation = 0x0004
+
+	// the longest string ReportEvent accepts
+	maxMessageChars = 31839
+)
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+// parseLevel returns the event type of a level name
+func parseLevel(level string) (uint16, bool) {
+	switch strings.ToLower(level) {
+	case "error", "err", "fatal", "panic", "crit", "critical", "alert", "emerg":
+		return typeError, true
+	case "warning", "warn":
+		return typeWarning, true
+	case "info", "information", "notice", "debug", "trace":
+		return typeInformation, true
+	}
+	return 0, false
+}
+
+// levels maps messages to event types by the level field, then the source
+type levels struct {
+	field   string
+	sources map[string]uint16
+}
+
+// newLevels parses a comma separated list of source=level pairs, which
+// override the default of error for stderr and information otherwise
+func newLevels(field, mapping string) (*levels, error) {
+	l := &levels{
+		field:   field,
+		sources: map[string]uint16{"stderr": typeError},
+	}
+	for _, pair := range strings.Split(mapping, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("eventlog: invalid value for levels: " + mapping)
+		}
+		eventType, ok := parseLevel(strings.TrimSpace(kv[1]))
+		if !ok {
+			return nil, errors.New("eventlog: invalid value for levels: " + mapping)
+		}
+		l.sources[strings.TrimSpace(kv[0])] = eventType
+	}
+	return l, nil
+}
+
+// eventType returns the event type to report message with
+func (l *levels) eventType(message *router.Message) uint16 {
+	if l.field != "" {
+		if eventType, ok := parseLevel(message.Fields[l.field]); ok {
+			return eventType
+		}
+	}
+	if eventType, ok := l.sources[message.Source]; ok {
+		return eventType
+	}
+	return typeInformation
+}
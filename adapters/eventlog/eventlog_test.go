@@ -0,0 +1,36 @@
+package eventlog
+
+import (
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestEventType(t *testing.T) {
+	l, err := newLevels("level", "stdout=warning, app=error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		source, level string
+		expected      uint16
+	}{
+		{"stderr", "", typeError},
+		{"stdout", "", typeWarning},
+		{"app", "", typeError},
+		{"other", "", typeInformation},
+		{"stdout", "INFO", typeInformation},
+		{"stdout", "fatal", typeError},
+		{"stderr", "unknown", typeError},
+	} {
+		message := &router.Message{Source: test.source, Fields: map[string]string{"level": test.level}}
+		if eventType := l.eventType(message); eventType != test.expected {
+			t.Errorf("%s %q: expected %v got %v", test.source, test.level, test.expected, eventType)
+		}
+	}
+	for _, mapping := range []string{"stdout", "stdout=loud"} {
+		if _, err := newLevels("level", mapping); err == nil {
+			t.Errorf("expected error for %q", mapping)
+		}
+	}
+}
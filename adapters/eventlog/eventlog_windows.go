@@ -0,0 +1,125 @@
+//go:build windows
+// +build windows
+
+package eventlog
+
+import (
+	"errors"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+var (
+	advapi32                  = syscall.NewLazyDLL("advapi32.dll")
+	procRegisterEventSource   = advapi32.NewProc("RegisterEventSourceW")
+	procDeregisterEventSource = advapi32.NewProc("DeregisterEventSource")
+	procReportEvent           = advapi32.NewProc("ReportEventW")
+)
+
+func init() {
+	router.AdapterFactories.Register(NewEventLogAdapter, "eventlog")
+	router.Capabilities.DescribeAdapter("eventlog", []string{"source", "event_id", "level_field", "levels"}, nil)
+}
+
+// NewEventLogAdapter returns a configured eventlog.Adapter writing to the
+// event log of the server given as the address, or the local one if empty
+func NewEventLogAdapter(route *router.Route) (router.LogAdapter, error) {
+	source := router.RouteOpt(route, "source", "EVENTLOG_SOURCE", "logspout")
+	idStr := router.RouteOpt(route, "event_id", "EVENTLOG_EVENT_ID", "1")
+	eventID, err := strconv.ParseUint(idStr, 10, 16)
+	if err != nil {
+		return nil, errors.New("eventlog: invalid value for event_id: " + idStr)
+	}
+	levels, err := newLevels(
+		router.RouteOpt(route, "level_field", "EVENTLOG_LEVEL_FIELD", "level"),
+		router.RouteOpt(route, "levels", "EVENTLOG_LEVELS", ""))
+	if err != nil {
+		return nil, err
+	}
+	handle, err := registerEventSource(route.Address, source)
+	if err != nil {
+		return nil, err
+	}
+	return &Adapter{
+		route:   route,
+		handle:  handle,
+		eventID: uint32(eventID),
+		levels:  levels,
+	}, nil
+}
+
+func registerEventSource(server, source string) (syscall.Handle, error) {
+	var serverPtr *uint16
+	if server != "" {
+		var err error
+		if serverPtr, err = syscall.UTF16PtrFromString(`\\` + server); err != nil {
+			return 0, err
+		}
+	}
+	sourcePtr, err := syscall.UTF16PtrFromString(source)
+	if err != nil {
+		return 0, err
+	}
+	handle, _, err := procRegisterEventSource.Call(uintptr(unsafe.Pointer(serverPtr)), uintptr(unsafe.Pointer(sourcePtr)))
+	if handle == 0 {
+		return 0, errors.New("eventlog: register event source " + source + ": " + err.Error())
+	}
+	return syscall.Handle(handle), nil
+}
+
+// Adapter reports log output as events of an event source
+type Adapter struct {
+	route   *router.Route
+	handle  syscall.Handle
+	eventID uint32
+	levels  *levels
+}
+
+// Stream reports log data to the event log
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	for message := range logstream {
+		start := time.Now()
+		err := a.report(a.levels.eventType(message), message.Data)
+		router.ObserveWrite(a.route, start)
+		if err != nil {
+			router.LogDeliveryError("eventlog", err)
+		}
+		router.Receipts.Report(a.route, message, err)
+	}
+}
+
+func (a *Adapter) report(eventType uint16, data string) error {
+	msg, err := syscall.UTF16PtrFromString(router.TruncateRunes(data, maxMessageChars))
+	if err != nil {
+		// messages can't contain NUL
+		return err
+	}
+	args := []*uint16{msg}
+	ok, _, err := procReportEvent.Call(
+		uintptr(a.handle),
+		uintptr(eventType),
+		0,
+		uintptr(a.eventID),
+		0,
+		uintptr(len(args)),
+		0,
+		uintptr(unsafe.Pointer(&args[0])),
+		0)
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
+
+// Close deregisters the event source
+func (a *Adapter) Close() error {
+	ok, _, err := procDeregisterEventSource.Call(uintptr(a.handle))
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
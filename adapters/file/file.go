@@ -0,0 +1,378 @@
+package file
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gliderlabs/logspout/adapters/raw"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	// rotated files are suffixed with the time they were rotated at, which
+	// sorts oldest first
+	rotatedStamp = "20060102T150405.000000000"
+	// files not written to for this long are closed until their next message
+	idleTimeout = 5 * time.Minute
+)
+
+var logger = router.NewLogger("file")
+
+func init() {
+	router.AdapterFactories.Register(NewFileAdapter, "file")
+	router.Capabilities.DescribeAdapter("file", []string{
+		"max_size", "rotate_interval", "compress", "max_files", "max_age", "envelope",
+	}, nil)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+// parseSize parses a size in bytes, with an optional K, M or G suffix for
+// KiB, MiB or GiB
+func parseSize(value string) (int64, error) {
+	multiplier := int64(1)
+	switch strings.ToUpper(value[len(value)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0, errors.New("invalid size")
+	}
+	return size * multiplier, nil
+}
+
+// NewFileAdapter returns an Adapter writing to the files at the path
+// template of the route's address
+func NewFileAdapter(route *router.Route) (router.LogAdapter, error) {
+	if !filepath.IsAbs(route.Address) {
+		return nil, errors.New("file: address must be an absolute path, e.g. file:///var/log/containers/{{.ContainerName}}.log: " + route.Address)
+	}
+	path, err := template.New("path").Parse(route.Address)
+	if err != nil {
+		return nil, errors.New("file: invalid path template: " + err.Error())
+	}
+	format := getopt("FILE_FORMAT", "{{.Data}}\n")
+	override, err := route.TemplateOverride()
+	if err != nil {
+		return nil, err
+	}
+	if override != "" {
+		format = override
+	}
+	tmpl, err := raw.ParseTemplate(format)
+	if err != nil {
+		return nil, errors.New("file: invalid format: " + err.Error())
+	}
+	envelope, err := router.NewEnvelope(route)
+	if err != nil {
+		return nil, errors.New("file: " + err.Error())
+	}
+
+	sizeStr := router.RouteOpt(route, "max_size", "FILE_MAX_SIZE", "100M")
+	maxSize, err := parseSize(sizeStr)
+	if err != nil {
+		return nil, errors.New("file: invalid value for max_size: " + sizeStr)
+	}
+	intervalStr := router.RouteOpt(route, "rotate_interval", "FILE_ROTATE_INTERVAL", "0")
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil || interval < 0 {
+		return nil, errors.New("file: invalid value for rotate_interval: " + intervalStr)
+	}
+	compressStr := router.RouteOpt(route, "compress", "FILE_COMPRESS", "false")
+	compress, err := strconv.ParseBool(compressStr)
+	if err != nil {
+		return nil, errors.New("file: invalid value for compress: " + compressStr)
+	}
+	filesStr := router.RouteOpt(route, "max_files", "FILE_MAX_FILES", "5")
+	maxFiles, err := strconv.Atoi(filesStr)
+	if err != nil || maxFiles < 0 {
+		return nil, errors.New("file: invalid value for max_files: " + filesStr)
+	}
+	ageStr := router.RouteOpt(route, "max_age", "FILE_MAX_AGE", "0")
+	maxAge, err := time.ParseDuration(ageStr)
+	if err != nil || maxAge < 0 {
+		return nil, errors.New("file: invalid value for max_age: " + ageStr)
+	}
+
+	// the directory the path template can't reach out of
+	root := route.Address
+	if i := strings.Index(root, "{{"); i >= 0 {
+		root = root[:i]
+	}
+
+	return &Adapter{
+		route:     route,
+		root:      filepath.Dir(filepath.Clean(root + "x")),
+		path:      path,
+		tmpl:      tmpl,
+		envelope:  envelope,
+		maxSize:   maxSize,
+		interval:  interval,
+		compress:  compress,
+		maxFiles:  maxFiles,
+		maxAge:    maxAge,
+		files:     make(map[string]*logFile),
+		rotations: make(chan rotation, 64),
+		now:       time.Now,
+	}, nil
+}
+
+// Adapter writes log output to local files, rotating them by size and time
+type Adapter struct {
+	route     *router.Route
+	root      string
+	path      *template.Template
+	tmpl      *template.Template
+	envelope  *router.Envelope
+	maxSize   int64
+	interval  time.Duration
+	compress  bool
+	maxFiles  int
+	maxAge    time.Duration
+	files     map[string]*logFile
+	rotations chan rotation
+	now       func() time.Time
+}
+
+// rotation is a file moved aside for compression and pruning
+type rotation struct {
+	name    string
+	rotated string
+}
+
+// logFile is an open file of the adapter
+type logFile struct {
+	file    *os.File
+	size    int64
+	opened  time.Time
+	written time.Time
+}
+
+// Message extends router.Message with fields for path templates
+type Message struct {
+	*router.Message
+}
+
+// ContainerName returns the message's container name
+func (m *Message) ContainerName() string {
+	if m.Message.Container == nil {
+		return ""
+	}
+	return strings.TrimPrefix(m.Message.Container.Name, "/")
+}
+
+// ContainerID returns the message's short container id
+func (m *Message) ContainerID() string {
+	if m.Message.Container == nil {
+		return ""
+	}
+	id := m.Message.Container.ID
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// Stream writes log data to the files of each message's path
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	go a.housekeep()
+	defer close(a.rotations)
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				return
+			}
+			size, err := a.write(message)
+			if err != nil {
+				router.LogDeliveryError("file", err)
+			}
+			router.Receipts.ReportSize(a.route, message, size, err)
+		case <-ticker.C:
+			a.expire()
+		}
+	}
+}
+
+// write writes message to its file, returning the size of the rendered line
+func (a *Adapter) write(message *router.Message) (int, error) {
+	path, err := router.Render(a.path, &Message{message})
+	if err != nil {
+		return 0, router.NewDeliveryError(router.ErrorSerialization, err)
+	}
+	// templates of container names would otherwise leave a double slash
+	name := filepath.Clean(path.String())
+	router.PutBuffer(path)
+	if !strings.HasPrefix(name, a.root+string(filepath.Separator)) {
+		return 0, errors.New("file: path outside the route's directory: " + name)
+	}
+	buf, err := router.Render(a.tmpl, message)
+	if err != nil {
+		return 0, router.NewDeliveryError(router.ErrorSerialization, err)
+	}
+	if buf, err = a.envelope.Wrap(message, buf); err != nil {
+		return 0, err
+	}
+	defer router.PutBuffer(buf)
+	f, err := a.open(name)
+	if err != nil {
+		return buf.Len(), err
+	}
+	now := a.now()
+	if f.size > 0 && (a.maxSize > 0 && f.size+int64(buf.Len()) > a.maxSize ||
+		a.interval > 0 && !now.Truncate(a.interval).Equal(f.opened.Truncate(a.interval))) {
+		if f, err = a.rotate(name, f); err != nil {
+			return buf.Len(), err
+		}
+	}
+	start := time.Now()
+	n, err := f.file.Write(buf.Bytes())
+	router.ObserveWrite(a.route, start)
+	f.size += int64(n)
+	f.written = now
+	return buf.Len(), err
+}
+
+// open returns the open file at name, opening it for appending if needed
+func (a *Adapter) open(name string) (*logFile, error) {
+	if f, ok := a.files[name]; ok {
+		return f, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	f := &logFile{file: file, size: info.Size(), opened: a.now()}
+	// a file kept from before a restart was opened when it was last changed
+	if f.size > 0 {
+		f.opened = info.ModTime()
+	}
+	a.files[name] = f
+	return f, nil
+}
+
+// rotate moves the file at name aside, to be compressed and pruned in the
+// background, and opens a new one
+func (a *Adapter) rotate(name string, f *logFile) (*logFile, error) {
+	f.file.Close()
+	delete(a.files, name)
+	rotated := name + "." + a.now().UTC().Format(rotatedStamp)
+	if err := os.Rename(name, rotated); err != nil {
+		return nil, err
+	}
+	a.rotations <- rotation{name: name, rotated: rotated}
+	return a.open(name)
+}
+
+// housekeep compresses rotated files and prunes those past the retention
+// limits, one rotation at a time, until rotations is closed
+func (a *Adapter) housekeep() {
+	for r := range a.rotations {
+		if a.compress {
+			if err := compressFile(r.rotated); err != nil {
+				logger.Error("compressing failed", "file", r.rotated, "error", err)
+			}
+		}
+		a.prune(r.name)
+	}
+}
+
+// compressFile replaces the file at name with a gzip compressed name.gz
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+// prune removes the oldest rotated files of name beyond max_files, and
+// those older than max_age
+func (a *Adapter) prune(name string) {
+	rotated, err := filepath.Glob(name + ".*")
+	if err != nil {
+		return
+	}
+	sort.Strings(rotated)
+	for i, file := range rotated {
+		expired := a.maxFiles > 0 && i < len(rotated)-a.maxFiles
+		if !expired && a.maxAge > 0 {
+			info, err := os.Stat(file)
+			expired = err == nil && a.now().Sub(info.ModTime()) > a.maxAge
+		}
+		if expired {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				logger.Error("removing failed", "file", file, "error", err)
+			}
+		}
+	}
+}
+
+// expire closes the files idle for longer than idleTimeout, so files of
+// removed containers aren't held open
+func (a *Adapter) expire() {
+	now := a.now()
+	for name, f := range a.files {
+		if now.Sub(f.written) > idleTimeout {
+			f.file.Close()
+			delete(a.files, name)
+		}
+	}
+}
+
+// Close closes the adapter's open files
+func (a *Adapter) Close() error {
+	for name, f := range a.files {
+		f.file.Close()
+		delete(a.files, name)
+	}
+	return nil
+}
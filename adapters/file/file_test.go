@@ -0,0 +1,141 @@
+package file
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+func newTestAdapter(t *testing.T, options map[string]string) (*Adapter, string) {
+	dir, err := ioutil.TempDir("", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	route := &router.Route{ID: t.Name(), Address: dir + "/{{.Container.Name}}.log", Options: options}
+	adapter, err := NewFileAdapter(route)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return adapter.(*Adapter), dir
+}
+
+func message(name, data string) *router.Message {
+	return &router.Message{
+		Container: &docker.Container{ID: "8dfafdbc3a40", Name: "/" + name},
+		Data:      data,
+		Time:      time.Now(),
+	}
+}
+
+func TestFileRotatesBySize(t *testing.T) {
+	a, dir := newTestAdapter(t, map[string]string{"max_size": "64", "max_files": "2", "compress": "true"})
+	defer os.RemoveAll(dir)
+	for i := 0; i < 20; i++ {
+		// each rotation needs its own time stamp
+		now := time.Date(2018, 3, 5, 9, 8, i, 0, time.UTC)
+		a.now = func() time.Time { return now }
+		if _, err := a.write(message("app", strings.Repeat("x", 20))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.Close()
+	close(a.rotations)
+	a.housekeep()
+
+	current, err := ioutil.ReadFile(filepath.Join(dir, "app.log"))
+	if err != nil || len(current) == 0 || len(current) > 64 {
+		t.Errorf("expected the current file within max_size got %v bytes %v", len(current), err)
+	}
+	rotated, _ := filepath.Glob(filepath.Join(dir, "app.log.*"))
+	if len(rotated) != 2 {
+		t.Fatalf("expected the 2 newest rotated files kept got %v", rotated)
+	}
+	if !strings.HasSuffix(rotated[1], "app.log.20180305T090818.000000000.gz") {
+		t.Errorf("expected the newest rotated file stamped got %s", rotated[1])
+	}
+	f, err := os.Open(rotated[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(zr); string(data) != strings.Repeat(strings.Repeat("x", 20)+"\n", 3) {
+		t.Errorf("expected the rotated lines compressed got %q", data)
+	}
+}
+
+func TestFileRotatesByInterval(t *testing.T) {
+	a, dir := newTestAdapter(t, map[string]string{"rotate_interval": "24h"})
+	defer os.RemoveAll(dir)
+	now := time.Date(2018, 3, 5, 23, 59, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+	a.write(message("app", "monday"))
+	a.write(message("web", "monday"))
+	now = now.Add(2 * time.Minute)
+	a.write(message("app", "tuesday"))
+	a.Close()
+	close(a.rotations)
+	a.housekeep()
+
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "app.log")); string(data) != "tuesday\n" {
+		t.Errorf("expected a new file at midnight got %q", data)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "app.log.20180306T000100.000000000")); string(data) != "monday\n" {
+		t.Errorf("expected the previous day's file rotated got %q", data)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "web.log")); string(data) != "monday\n" {
+		t.Errorf("expected files without new lines left alone got %q", data)
+	}
+}
+
+func TestFileStaysInDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	route := &router.Route{Address: dir + "/{{.Data}}.log", Options: map[string]string{}}
+	adapter, err := NewFileAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := adapter.(*Adapter).write(message("app", "../../etc/passwd")); err == nil {
+		t.Error("expected a path outside the directory rejected")
+	}
+}
+
+func TestFileAddress(t *testing.T) {
+	u, err := url.Parse("file:///var/log/containers/{{.Container.Name}}.log?max_size=10M")
+	if err != nil || u.Host+u.Path != "/var/log/containers/{{.Container.Name}}.log" {
+		t.Errorf("expected the path template in the route address got %v %v", u, err)
+	}
+	for _, options := range []map[string]string{
+		{"max_size": "10MB"},
+		{"rotate_interval": "daily"},
+		{"compress": "gzip"},
+		{"max_files": "-1"},
+		{"max_age": "1w"},
+	} {
+		if _, err := NewFileAdapter(&router.Route{Address: "/var/log/app.log", Options: options}); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+	if _, err := NewFileAdapter(&router.Route{Address: "logs/app.log"}); err == nil {
+		t.Error("expected an error for a relative path")
+	}
+	if size, err := parseSize("10M"); err != nil || size != 10<<20 {
+		t.Errorf("expected 10M to be 10MiB got %v %v", size, err)
+	}
+}
@@ -0,0 +1,341 @@
+package firehose
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gliderlabs/logspout/adapters/raw"
+	"github.com/gliderlabs/logspout/internal/aws"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	// PutRecordBatch limits, see
+	// https://docs.aws.amazon.com/firehose/latest/APIReference/API_PutRecordBatch.html
+	maxBatchRecords = 500
+	maxBatchBytes   = 4 * 1024 * 1024
+	maxRecordBytes  = 1000 * 1024
+
+	defaultRetryCount = 10
+	targetPrefix      = "Firehose_20150804."
+)
+
+func init() {
+	router.AdapterFactories.Register(NewFirehoseAdapter, "firehose")
+	router.Capabilities.DescribeAdapter("firehose", []string{
+		"region", "endpoint", "access_key_id", "secret_access_key", "session_token",
+		"batch_size", "flush_interval", "template", "json_schema",
+	}, nil)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+var logger = router.NewLogger("firehose")
+
+func debug(v ...interface{}) {
+	logger.Debugln(v...)
+}
+
+// NewFirehoseAdapter returns a configured firehose.Adapter for a route
+// address naming the delivery stream, e.g. firehose://container-logs
+func NewFirehoseAdapter(route *router.Route) (router.LogAdapter, error) {
+	if route.Address == "" || strings.Contains(route.Address, "/") {
+		return nil, errors.New("firehose: address must be a delivery stream name, e.g. firehose://container-logs: " + route.Address)
+	}
+	client, err := aws.NewClient("firehose", route.Options["region"])
+	if err != nil {
+		return nil, err
+	}
+	if endpoint := router.RouteOpt(route, "endpoint", "FIREHOSE_ENDPOINT", ""); endpoint != "" {
+		client.Endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+	if id := route.Options["access_key_id"]; id != "" {
+		client.Credentials = aws.NewStaticCredentialsProvider(id, route.Options["secret_access_key"], route.Options["session_token"])
+	}
+
+	batchStr := router.RouteOpt(route, "batch_size", "FIREHOSE_BATCH_SIZE", strconv.Itoa(maxBatchRecords))
+	batchSize, err := strconv.Atoi(batchStr)
+	if err != nil || batchSize < 1 || batchSize > maxBatchRecords {
+		return nil, errors.New("firehose: invalid value for batch_size: " + batchStr)
+	}
+	flushStr := router.RouteOpt(route, "flush_interval", "FIREHOSE_FLUSH_INTERVAL", "1s")
+	flushInterval, err := time.ParseDuration(flushStr)
+	if err != nil || flushInterval <= 0 {
+		return nil, errors.New("firehose: invalid value for flush_interval: " + flushStr)
+	}
+	// records are JSON lines unless a template frames them
+	var tmpl *template.Template
+	format := router.RouteOpt(route, "", "FIREHOSE_FORMAT", "")
+	override, err := route.TemplateOverride()
+	if err != nil {
+		return nil, err
+	}
+	if override != "" {
+		format = override
+	}
+	if format != "" {
+		if tmpl, err = raw.ParseTemplate(format); err != nil {
+			return nil, errors.New("firehose: invalid format: " + err.Error())
+		}
+	}
+	schema, err := router.NewRouteSchema(route)
+	if err != nil {
+		return nil, errors.New("firehose: " + err.Error())
+	}
+
+	retryCount, err := strconv.Atoi(getopt("RETRY_COUNT", strconv.Itoa(defaultRetryCount)))
+	if err != nil {
+		retryCount = defaultRetryCount
+	}
+
+	return &Adapter{
+		route:         route,
+		client:        client,
+		stream:        route.Address,
+		tmpl:          tmpl,
+		batchSize:     batchSize,
+		schema:        schema,
+		flushInterval: flushInterval,
+		retryCount:    retryCount,
+	}, nil
+}
+
+// Adapter sends log output to an Amazon Data Firehose delivery stream
+type Adapter struct {
+	route         *router.Route
+	client        *aws.Client
+	stream        string
+	tmpl          *template.Template
+	batchSize     int
+	schema        *router.RouteSchema
+	flushInterval time.Duration
+	retryCount    int
+	batch         []*entry
+	bytes         int
+}
+
+// entry is a record of a PutRecordBatch request, with the log message it
+// is for
+type entry struct {
+	Data    []byte `json:"Data"`
+	message *router.Message
+}
+
+type putRecordBatchInput struct {
+	DeliveryStreamName string   `json:"DeliveryStreamName"`
+	Records            []*entry `json:"Records"`
+}
+
+type putRecordBatchResponseEntry struct {
+	RecordID     string `json:"RecordId"`
+	ErrorCode    string `json:"ErrorCode"`
+	ErrorMessage string `json:"ErrorMessage"`
+}
+
+type putRecordBatchOutput struct {
+	FailedPutCount   int                           `json:"FailedPutCount"`
+	RequestResponses []putRecordBatchResponseEntry `json:"RequestResponses"`
+}
+
+// record is the JSON line a message is sent as without a template
+type record struct {
+	Time          string            `json:"time"`
+	ContainerID   string            `json:"container_id"`
+	ContainerName string            `json:"container_name"`
+	Image         string            `json:"image,omitempty"`
+	Hostname      string            `json:"hostname,omitempty"`
+	Source        string            `json:"source"`
+	Message       string            `json:"message"`
+	Fields        map[string]string `json:"fields,omitempty"`
+}
+
+// Message extends router.Message with fields for record templates
+type Message struct {
+	*router.Message
+}
+
+// ContainerName returns the message's container name
+func (m *Message) ContainerName() string {
+	return strings.TrimPrefix(m.Message.Container.Name, "/")
+}
+
+// ContainerID returns the message's short container id
+func (m *Message) ContainerID() string {
+	id := m.Message.Container.ID
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// Stream sends log data to the delivery stream in batches
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	defer a.flush()
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				return
+			}
+			data, err := a.render(message)
+			if err != nil {
+				router.LogDeliveryError("firehose", err)
+				router.Receipts.Report(a.route, message, err)
+				continue
+			}
+			if !a.schema.Valid(message, data) {
+				continue
+			}
+			if len(a.batch) > 0 && a.bytes+len(data) > maxBatchBytes {
+				a.flush()
+			}
+			a.batch = append(a.batch, &entry{Data: data, message: message})
+			a.bytes += len(data)
+			if len(a.batch) >= a.batchSize || message.Exiting {
+				a.flush()
+			}
+		case <-ticker.C:
+			a.flush()
+		}
+	}
+}
+
+// render returns the record of message: its JSON line, with the data
+// truncated to fit a record, or the route's template rendered
+func (a *Adapter) render(message *router.Message) ([]byte, error) {
+	m := &Message{message}
+	if a.tmpl != nil {
+		buf := new(bytes.Buffer)
+		if err := a.tmpl.Execute(buf, m); err != nil {
+			return nil, router.NewDeliveryError(router.ErrorSerialization, err)
+		}
+		if buf.Len() > maxRecordBytes {
+			return nil, router.NewDeliveryError(router.ErrorSerialization,
+				errors.New("firehose: record of "+strconv.Itoa(buf.Len())+" bytes exceeds the limit of a record"))
+		}
+		return buf.Bytes(), nil
+	}
+	r := &record{
+		Time:          message.Time.UTC().Format(time.RFC3339Nano),
+		ContainerID:   m.ContainerID(),
+		ContainerName: m.ContainerName(),
+		Source:        message.Source,
+		Message:       message.Data,
+		Fields:        message.Fields,
+	}
+	if message.Container.Config != nil {
+		r.Image = message.Container.Config.Image
+		r.Hostname = message.Container.Config.Hostname
+	}
+	for {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return nil, router.NewDeliveryError(router.ErrorSerialization, err)
+		}
+		// the newline frames records that Firehose concatenates into objects
+		data = append(data, '\n')
+		if len(data) <= maxRecordBytes || r.Message == "" {
+			return data, nil
+		}
+		// escaping may make the data longer than the bytes cut, so cut again
+		// until the record fits
+		r.Message = router.Truncate(r.Message, len(r.Message)-(len(data)-maxRecordBytes))
+	}
+}
+
+func (a *Adapter) flush() {
+	if len(a.batch) == 0 {
+		return
+	}
+	errs := a.send(a.batch)
+	failed := 0
+	var err error
+	for i, e := range a.batch {
+		if errs[i] != nil {
+			failed++
+			err = errs[i]
+		}
+		router.Receipts.Report(a.route, e.message, errs[i])
+	}
+	if failed > 0 {
+		logger.Error("dropping messages", "messages", failed, "category", router.ErrorCategory(err), "error", err)
+	}
+	a.batch, a.bytes = nil, 0
+}
+
+// send sends a batch, sending the records that failed on the side of
+// Firehose again with backoff, and returns the error of each message
+func (a *Adapter) send(batch []*entry) []error {
+	defer router.ObserveWrite(a.route, time.Now())
+	errs := make([]error, len(batch))
+	// the index in batch of each record sent
+	pending := make([]int, len(batch))
+	for i := range batch {
+		pending[i] = i
+	}
+	for try := 0; ; try++ {
+		records := make([]*entry, len(pending))
+		for j, i := range pending {
+			records[j] = batch[i]
+			errs[i] = nil
+		}
+		out := new(putRecordBatchOutput)
+		err := a.client.Call(targetPrefix+"PutRecordBatch", &putRecordBatchInput{
+			DeliveryStreamName: a.stream,
+			Records:            records,
+		}, out)
+		if apiErr, ok := err.(*aws.Error); ok && !apiErr.Retryable() {
+			for _, i := range pending {
+				errs[i] = err
+			}
+			return errs
+		}
+		var retry []int
+		if err == nil {
+			// responses are in the order of the records
+			for j, response := range out.RequestResponses {
+				if response.ErrorCode == "" || j >= len(pending) {
+					continue
+				}
+				status := http.StatusInternalServerError
+				if response.ErrorCode == "ServiceUnavailableException" {
+					status = http.StatusServiceUnavailable
+				}
+				errs[pending[j]] = &aws.Error{StatusCode: status, Type: response.ErrorCode, Message: response.ErrorMessage}
+				retry = append(retry, pending[j])
+			}
+		} else {
+			// throttling, server errors and network errors
+			retry = pending
+			for _, i := range pending {
+				errs[i] = err
+			}
+		}
+		if len(retry) == 0 || try >= a.retryCount {
+			return errs
+		}
+		pending = retry
+		delay := router.RetryDelay(try)
+		debug("firehose: retrying", len(pending), "records in", delay, "after:", errs[pending[0]])
+		retried := make([]*router.Message, len(pending))
+		for j, i := range pending {
+			retried[j] = batch[i].message
+		}
+		router.WaitRetry(a.route, delay, retried...)
+	}
+}
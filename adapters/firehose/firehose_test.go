@@ -0,0 +1,154 @@
+package firehose
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+var container = &docker.Container{
+	ID:     "8dfafdbc3a40b1b0bd3a0f5c",
+	Name:   "/container",
+	Config: &docker.Config{Image: "app:1.0", Hostname: "8dfafdbc3a40"},
+}
+
+// fakeFirehose answers PutRecordBatch, failing the records with the data
+// in failures once
+type fakeFirehose struct {
+	sync.Mutex
+	requests []*putRecordBatchInput
+	failures map[string]string
+}
+
+func (f *fakeFirehose) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if req.Header.Get("X-Amz-Target") != "Firehose_20150804.PutRecordBatch" ||
+		req.Header.Get("Content-Type") != "application/x-amz-json-1.1" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type": "InvalidArgumentException", "message": "bad request"}`))
+		return
+	}
+	in := new(putRecordBatchInput)
+	json.NewDecoder(req.Body).Decode(in)
+	f.requests = append(f.requests, in)
+	out := new(putRecordBatchOutput)
+	for _, r := range in.Records {
+		response := putRecordBatchResponseEntry{RecordID: "id"}
+		if code, ok := f.failures[string(r.Data)]; ok {
+			response = putRecordBatchResponseEntry{ErrorCode: code, ErrorMessage: "try again"}
+			out.FailedPutCount++
+			delete(f.failures, string(r.Data))
+		}
+		out.RequestResponses = append(out.RequestResponses, response)
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+func newTestAdapter(t *testing.T, server *httptest.Server, options map[string]string) *Adapter {
+	options["region"] = "us-east-1"
+	options["endpoint"] = server.URL
+	options["access_key_id"] = "AKIDEXAMPLE"
+	options["secret_access_key"] = "secret"
+	adapter, err := NewFirehoseAdapter(&router.Route{Adapter: "firehose", Address: "logs", Options: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return adapter.(*Adapter)
+}
+
+func TestFirehoseBatches(t *testing.T) {
+	f := new(fakeFirehose)
+	server := httptest.NewServer(f)
+	defer server.Close()
+	a := newTestAdapter(t, server, map[string]string{"flush_interval": "1h", "batch_size": "3"})
+	logstream := make(chan *router.Message)
+	done := make(chan struct{})
+	go func() {
+		a.Stream(logstream)
+		close(done)
+	}()
+	for i := 0; i < 4; i++ {
+		logstream <- &router.Message{
+			Container: container,
+			Source:    "stdout",
+			Data:      "line " + strconv.Itoa(i),
+			Time:      time.Unix(1500000000, 0),
+			Fields:    map[string]string{"level": "info"},
+		}
+	}
+	close(logstream)
+	<-done
+
+	f.Lock()
+	defer f.Unlock()
+	if len(f.requests) != 2 || len(f.requests[0].Records) != 3 || len(f.requests[1].Records) != 1 {
+		t.Fatalf("expected batches of 3 and 1 got %v requests", len(f.requests))
+	}
+	if f.requests[0].DeliveryStreamName != "logs" {
+		t.Errorf("unexpected delivery stream %q", f.requests[0].DeliveryStreamName)
+	}
+	expected := `{"time":"2017-07-14T02:40:00Z","container_id":"8dfafdbc3a40","container_name":"container","image":"app:1.0","hostname":"8dfafdbc3a40","source":"stdout","message":"line 0","fields":{"level":"info"}}` + "\n"
+	if data := string(f.requests[0].Records[0].Data); data != expected {
+		t.Errorf("expected %s got %s", expected, data)
+	}
+}
+
+func TestFirehoseRetriesFailedRecords(t *testing.T) {
+	f := &fakeFirehose{failures: map[string]string{"retried\n": "ServiceUnavailableException"}}
+	server := httptest.NewServer(f)
+	defer server.Close()
+	a := newTestAdapter(t, server, map[string]string{"template": base64.StdEncoding.EncodeToString([]byte("{{.Data}}\n"))})
+	var batch []*entry
+	for _, data := range []string{"sent", "retried"} {
+		message := &router.Message{Container: container, Data: data, Time: time.Now()}
+		record, err := a.render(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch = append(batch, &entry{Data: record, message: message})
+	}
+	if errs := a.send(batch); errs[0] != nil || errs[1] != nil {
+		t.Errorf("expected the messages to be sent got %v", errs)
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	if len(f.requests) != 2 || len(f.requests[1].Records) != 1 || string(f.requests[1].Records[0].Data) != "retried\n" {
+		t.Errorf("expected only the failed record to be sent again got %v requests", len(f.requests))
+	}
+}
+
+func TestFirehoseRecordLimit(t *testing.T) {
+	a := &Adapter{}
+	data, err := a.render(&router.Message{Container: container, Data: strings.Repeat("\x01", maxRecordBytes)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > maxRecordBytes || !json.Valid(data) {
+		t.Errorf("expected a valid record within the limit got %v bytes", len(data))
+	}
+}
+
+func TestFirehoseOptions(t *testing.T) {
+	for _, route := range []*router.Route{
+		{Address: "", Options: map[string]string{"region": "us-east-1"}},
+		{Address: "logs/x", Options: map[string]string{"region": "us-east-1"}},
+		{Address: "logs", Options: map[string]string{"region": "us-east-1", "batch_size": "501"}},
+		{Address: "logs", Options: map[string]string{"region": "us-east-1", "flush_interval": "0"}},
+		{Address: "logs", Options: map[string]string{"region": "us-east-1", "template": base64.StdEncoding.EncodeToString([]byte("{{"))}},
+	} {
+		if _, err := NewFirehoseAdapter(route); err == nil {
+			t.Errorf("expected an error for %v %v", route.Address, route.Options)
+		}
+	}
+}
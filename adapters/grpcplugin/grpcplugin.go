@@ -0,0 +1,307 @@
+package grpcplugin
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gliderlabs/logspout/internal/protowire"
+	"github.com/gliderlabs/logspout/router"
+	"golang.org/x/net/http2"
+)
+
+const (
+	sendPath = "/logspout.plugin.v1.Sink/Send"
+	// batches are kept under the 4MB gRPC servers receive by default
+	maxBatchBytes = 4*1024*1024 - 1024
+	maxAckBytes   = 1024 * 1024
+
+	defaultRetryCount = 10
+)
+
+// gRPC status codes, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	codeInvalidArgument   = 3
+	codeDeadlineExceeded  = 4
+	codePermissionDenied  = 7
+	codeResourceExhausted = 8
+	codeAborted           = 10
+	codeUnavailable       = 14
+	codeUnauthenticated   = 16
+)
+
+func init() {
+	router.AdapterFactories.Register(NewPluginAdapter, "grpcplugin")
+	router.Capabilities.DescribeAdapter("grpcplugin", []string{
+		"batch_size", "flush_interval", "timeout",
+		"batch_adaptive", "batch_min_size", "batch_target_latency",
+	}, nil)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+var logger = router.NewLogger("grpcplugin")
+
+func debug(v ...interface{}) {
+	logger.Debugln(v...)
+}
+
+// NewPluginAdapter returns a configured grpcplugin.Adapter for a route
+// address of the form host:port, or the path of a socket with the unix
+// transport, e.g. grpcplugin+unix:///run/sink.sock
+func NewPluginAdapter(route *router.Route) (router.LogAdapter, error) {
+	transportName := route.AdapterTransport("tcp")
+	if transportName != "tcp" && transportName != "unix" {
+		return nil, errors.New("grpcplugin: transport must be tcp or unix: " + route.Adapter)
+	}
+	transport, found := router.AdapterTransports.Lookup(transportName)
+	if !found {
+		return nil, errors.New("bad transport: " + route.Adapter)
+	}
+	if route.Address == "" {
+		return nil, errors.New("grpcplugin: address must be host:port or a socket path")
+	}
+	authority := route.Address
+	if transportName == "unix" {
+		authority = "localhost"
+	} else if _, _, err := net.SplitHostPort(authority); err != nil {
+		return nil, errors.New("grpcplugin: address must be host:port: " + route.Address)
+	}
+
+	batchStr := router.RouteOpt(route, "batch_size", "GRPCPLUGIN_BATCH_SIZE", "100")
+	batchSize, err := strconv.Atoi(batchStr)
+	if err != nil || batchSize < 1 {
+		return nil, errors.New("grpcplugin: invalid value for batch_size: " + batchStr)
+	}
+	flushStr := router.RouteOpt(route, "flush_interval", "GRPCPLUGIN_FLUSH_INTERVAL", "1s")
+	flushInterval, err := time.ParseDuration(flushStr)
+	if err != nil || flushInterval <= 0 {
+		return nil, errors.New("grpcplugin: invalid value for flush_interval: " + flushStr)
+	}
+	timeoutStr := router.RouteOpt(route, "timeout", "GRPCPLUGIN_TIMEOUT", "30s")
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil || timeout <= 0 {
+		return nil, errors.New("grpcplugin: invalid value for timeout: " + timeoutStr)
+	}
+	batching, err := router.NewBatchSizer(route, batchSize)
+	if err != nil {
+		return nil, errors.New("grpcplugin: " + err.Error())
+	}
+	retryCount, err := strconv.Atoi(getopt("RETRY_COUNT", strconv.Itoa(defaultRetryCount)))
+	if err != nil {
+		retryCount = defaultRetryCount
+	}
+
+	client := &http.Client{Timeout: timeout, Transport: &http2.Transport{
+		// plugins run next to logspout and speak cleartext HTTP/2, over
+		// the route's transport so dial_timeout applies
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return transport.Dial(route.Address, route.Options)
+		},
+	}}
+
+	return &Adapter{
+		route:         route,
+		transport:     transport,
+		client:        client,
+		url:           "http://" + authority + sendPath,
+		batching:      batching,
+		flushInterval: flushInterval,
+		retryCount:    retryCount,
+	}, nil
+}
+
+// Adapter forwards batches of messages to an external sink implementing
+// the Sink service of plugin.proto
+type Adapter struct {
+	route         *router.Route
+	transport     router.AdapterTransport
+	client        *http.Client
+	url           string
+	batching      *router.BatchSizer
+	flushInterval time.Duration
+	retryCount    int
+	body          []byte
+	batched       []*router.Message
+}
+
+// Warm connects to the plugin, which keeps logspout from being ready
+// until the plugin listens
+func (a *Adapter) Warm() error {
+	conn, err := a.transport.Dial(a.route.Address, a.route.Options)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Stream sends log data to the plugin in batches
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	defer a.flush()
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				return
+			}
+			encoded := encodeMessage(message)
+			if len(a.batched) > 0 && len(a.body)+len(encoded)+binary.MaxVarintLen64+1 > maxBatchBytes {
+				a.flush()
+			}
+			if len(a.batched) == 0 {
+				a.body = protowire.AppendString(a.body, 1, a.route.ID)
+			}
+			a.body = protowire.AppendBytes(a.body, 2, encoded)
+			a.batched = append(a.batched, message)
+			if len(a.batched) >= a.batching.Size() || message.Exiting {
+				a.flush()
+			}
+		case <-ticker.C:
+			a.flush()
+		}
+	}
+}
+
+func (a *Adapter) flush() {
+	if len(a.batched) == 0 {
+		return
+	}
+	start := time.Now()
+	rejected, err := a.send(a.body)
+	a.batching.Observe(len(a.batched), time.Since(start), err)
+	if err != nil {
+		logger.Error("dropping messages", "messages", len(a.batched), "category", router.ErrorCategory(err), "error", err)
+	} else if len(rejected) > 0 {
+		logger.Warn("rejected messages", "messages", len(rejected))
+	}
+	for i, message := range a.batched {
+		if rejected[i] {
+			router.Receipts.Report(a.route, message, router.NewDeliveryError(router.ErrorSerialization,
+				errors.New("grpcplugin: message rejected by the plugin")))
+			continue
+		}
+		router.Receipts.Report(a.route, message, err)
+	}
+	a.body, a.batched = nil, nil
+}
+
+// send calls Send with batch, retrying errors the plugin marked as
+// temporary, and network errors, with backoff
+func (a *Adapter) send(batch []byte) (map[int]bool, error) {
+	defer router.ObserveWrite(a.route, time.Now())
+	frame := protowire.Frame(batch)
+	for try := 0; ; try++ {
+		rejected, err := a.call(frame)
+		if err == nil {
+			return rejected, nil
+		}
+		if statusErr, ok := err.(*Error); (ok && !statusErr.Retryable()) || try >= a.retryCount {
+			return nil, err
+		}
+		delay := router.RetryDelay(try)
+		debug("grpcplugin: retrying in", delay, "after:", err)
+		router.WaitRetry(a.route, delay, a.batched...)
+	}
+}
+
+// call makes one Send call, returning the rejected indexes of its Ack
+func (a *Adapter) call(frame []byte) (map[int]bool, error) {
+	req, err := http.NewRequest("POST", a.url, bytes.NewReader(frame))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("User-Agent", "logspout")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, router.NewDeliveryError(router.HTTPErrorCategory(resp.StatusCode),
+			fmt.Errorf("grpcplugin: %s returned %s", a.url, resp.Status))
+	}
+	// errors without a response message come in the headers
+	if err = grpcStatus(resp.Header); err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxAckBytes))
+	if err != nil {
+		return nil, err
+	}
+	if err = grpcStatus(resp.Trailer); err != nil {
+		return nil, err
+	}
+	ack, ok := protowire.Unframe(body)
+	if !ok {
+		return nil, errMalformedAck
+	}
+	return decodeAck(ack)
+}
+
+// Error is a gRPC error status returned by a plugin
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("grpcplugin: plugin returned status %d: %s", e.Code, e.Message)
+}
+
+// Category returns the delivery error category of the status
+func (e *Error) Category() string {
+	switch e.Code {
+	case codeUnavailable:
+		return router.ErrorConnect
+	case codeResourceExhausted:
+		return router.ErrorThrottle
+	case codeDeadlineExceeded:
+		return router.ErrorTimeout
+	case codeUnauthenticated, codePermissionDenied:
+		return router.ErrorAuth
+	case codeInvalidArgument:
+		return router.ErrorSerialization
+	}
+	return router.ErrorOther
+}
+
+// Retryable returns whether the batch may be accepted if sent again
+func (e *Error) Retryable() bool {
+	switch e.Code {
+	case codeUnavailable, codeResourceExhausted, codeAborted, codeDeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// grpcStatus returns the error in the grpc-status of headers or trailers
+func grpcStatus(header http.Header) error {
+	status, err := protowire.ParseStatus(header)
+	if err != nil {
+		return errors.New("grpcplugin: " + err.Error() + " from the plugin")
+	}
+	if status == nil {
+		return nil
+	}
+	return &Error{Code: status.Code, Message: status.Message}
+}
@@ -0,0 +1,204 @@
+package grpcplugin
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/internal/protowire"
+	"github.com/gliderlabs/logspout/router"
+	_ "github.com/gliderlabs/logspout/transports/tcp"
+	"golang.org/x/net/http2"
+)
+
+var app = &docker.Container{
+	ID:     "3b6ba57db54a",
+	Name:   "/app",
+	Config: &docker.Config{Image: "acme/app:1.0", Labels: map[string]string{"team": "web"}},
+}
+
+// fakeSink records the data of the messages of each batch, answering the
+// first unavailable calls with UNAVAILABLE and rejecting the indexes in
+// rejected
+type fakeSink struct {
+	sync.Mutex
+	unavailable int
+	rejected    []byte
+	calls       int
+	batches     [][]string
+	containers  []map[uint64][][]byte
+}
+
+func (f *fakeSink) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	f.calls++
+	w.Header().Set("Content-Type", "application/grpc")
+	if req.URL.Path != sendPath || req.Header.Get("Content-Type") != "application/grpc" {
+		w.Header().Set("Grpc-Status", "12")
+		return
+	}
+	if f.unavailable > 0 {
+		f.unavailable--
+		w.Header().Set("Grpc-Status", "14")
+		w.Header().Set("Grpc-Message", "sink%20restarting")
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	var data []string
+	for _, m := range fields(body[protowire.FrameHeader:])[2] {
+		message := fields(m)
+		data = append(data, string(message[3][0]))
+		f.containers = append(f.containers, fields(message[1][0]))
+	}
+	f.batches = append(f.batches, data)
+	w.Header().Set("Trailer", "Grpc-Status")
+	w.Write(protowire.Frame(protowire.AppendBytes(nil, 1, f.rejected)))
+	w.Header().Set("Grpc-Status", "0")
+}
+
+// fields returns the length delimited fields of a protobuf message by number
+func fields(b []byte) map[uint64][][]byte {
+	fields := make(map[uint64][][]byte)
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		if key&7 == 0 {
+			_, n = binary.Uvarint(b)
+			b = b[n:]
+			continue
+		}
+		size, n := binary.Uvarint(b)
+		fields[key>>3] = append(fields[key>>3], b[n:n+int(size)])
+		b = b[n+int(size):]
+	}
+	return fields
+}
+
+// serve serves sink over cleartext HTTP/2 and returns its address
+func serve(t *testing.T, sink http.Handler) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		server := new(http2.Server)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeConn(conn, &http2.ServeConnOpts{Handler: sink})
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func streamAll(t *testing.T, route *router.Route, messages ...*router.Message) {
+	adapter, err := NewPluginAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logstream := make(chan *router.Message)
+	done := make(chan struct{})
+	go func() {
+		adapter.Stream(logstream)
+		close(done)
+	}()
+	for _, message := range messages {
+		logstream <- message
+	}
+	close(logstream)
+	<-done
+}
+
+func TestPluginSendsBatches(t *testing.T) {
+	sink := new(fakeSink)
+	addr, stop := serve(t, sink)
+	defer stop()
+
+	streamAll(t, &router.Route{
+		ID:      "plugin",
+		Adapter: "grpcplugin",
+		Address: addr,
+		Options: map[string]string{"batch_size": "2", "flush_interval": "1h"},
+	},
+		&router.Message{Container: app, Source: "stdout", Data: "one", Time: time.Now()},
+		&router.Message{Container: app, Source: "stdout", Data: "two", Time: time.Now()},
+		&router.Message{Container: app, Source: "stderr", Data: "three", Time: time.Now()},
+	)
+
+	sink.Lock()
+	defer sink.Unlock()
+	if len(sink.batches) != 2 || len(sink.batches[0]) != 2 || sink.batches[1][0] != "three" {
+		t.Fatalf("expected batches of two and one messages got %v", sink.batches)
+	}
+	container := sink.containers[0]
+	if string(container[2][0]) != "app" || string(container[3][0]) != "acme/app:1.0" || len(container[5]) != 1 {
+		t.Errorf("unexpected container %v", container)
+	}
+}
+
+func TestPluginRetriesUnavailable(t *testing.T) {
+	sink := &fakeSink{unavailable: 2}
+	addr, stop := serve(t, sink)
+	defer stop()
+
+	streamAll(t, &router.Route{
+		Adapter: "grpcplugin",
+		Address: addr,
+		Options: map[string]string{},
+	}, &router.Message{Container: app, Source: "stdout", Data: "retried", Time: time.Now()})
+
+	sink.Lock()
+	defer sink.Unlock()
+	if sink.calls != 3 || len(sink.batches) != 1 {
+		t.Errorf("expected the batch retried twice got %v calls and batches %v", sink.calls, sink.batches)
+	}
+}
+
+func TestPluginRejected(t *testing.T) {
+	sink := &fakeSink{rejected: []byte{1}}
+	addr, stop := serve(t, sink)
+	defer stop()
+	adapter, err := NewPluginAdapter(&router.Route{Adapter: "grpcplugin", Address: addr, Options: map[string]string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := protowire.AppendBytes(nil, 2, encodeMessage(&router.Message{Container: app, Data: "ok"}))
+	batch = protowire.AppendBytes(batch, 2, encodeMessage(&router.Message{Container: app, Data: "bad"}))
+	rejected, err := adapter.(*Adapter).send(batch)
+	if err != nil || len(rejected) != 1 || !rejected[1] {
+		t.Errorf("expected message 1 rejected got %v %v", rejected, err)
+	}
+}
+
+func TestPluginStatus(t *testing.T) {
+	header := http.Header{"Grpc-Status": {"3"}, "Grpc-Message": {"bad%20batch"}}
+	err := grpcStatus(header)
+	if statusErr, ok := err.(*Error); !ok || statusErr.Retryable() || statusErr.Message != "bad batch" ||
+		router.ErrorCategory(err) != router.ErrorSerialization {
+		t.Errorf("expected a serialization error got %v", err)
+	}
+	if err := grpcStatus(http.Header{"Grpc-Status": {"0"}}); err != nil {
+		t.Errorf("expected no error for status 0 got %v", err)
+	}
+}
+
+func TestPluginAddress(t *testing.T) {
+	for adapter, address := range map[string]string{
+		"grpcplugin":       "localhost",
+		"grpcplugin+udp":   "localhost:7000",
+		"grpcplugin+unix":  "",
+		"grpcplugin+other": "localhost:7000",
+	} {
+		if _, err := NewPluginAdapter(&router.Route{Adapter: adapter, Address: address, Options: map[string]string{}}); err == nil {
+			t.Errorf("expected an error for %s://%s", adapter, address)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package grpcplugin
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+
+	"github.com/gliderlabs/logspout/internal/protowire"
+	"github.com/gliderlabs/logspout/router"
+)
+
+var errMalformedAck = errors.New("grpcplugin: malformed Ack from the plugin")
+
+// encodeMessage returns message as a Message of plugin.proto:
+//
+//	message Message {
+//		Container container = 1;
+//		string source = 2;
+//		string data = 3;
+//		int64 time_unix_nano = 4;
+//		map<string, string> fields = 5;
+//	}
+//	message Container {
+//		string id = 1;
+//		string name = 2;
+//		string image = 3;
+//		string hostname = 4;
+//		map<string, string> labels = 5;
+//	}
+func encodeMessage(message *router.Message) []byte {
+	var b []byte
+	if c := message.Container; c != nil {
+		var container []byte
+		container = protowire.AppendString(container, 1, c.ID)
+		container = protowire.AppendString(container, 2, strings.TrimPrefix(c.Name, "/"))
+		if c.Config != nil {
+			container = protowire.AppendString(container, 3, c.Config.Image)
+			container = protowire.AppendString(container, 4, c.Config.Hostname)
+			container = protowire.AppendMap(container, 5, c.Config.Labels)
+		}
+		b = protowire.AppendBytes(b, 1, container)
+	}
+	b = protowire.AppendString(b, 2, message.Source)
+	b = protowire.AppendString(b, 3, message.Data)
+	if !message.Time.IsZero() {
+		b = protowire.AppendVarint(b, 4, uint64(message.Time.UnixNano()))
+	}
+	return protowire.AppendMap(b, 5, message.Fields)
+}
+
+// decodeAck returns the rejected indexes of an Ack, which may be packed or
+// not as either is valid for repeated scalars:
+//
+//	message Ack { repeated uint32 rejected = 1; }
+func decodeAck(b []byte) (map[int]bool, error) {
+	rejected := make(map[int]bool)
+	malformed := false
+	err := protowire.Walk(b, func(field int, varint uint64, packed []byte) {
+		if field != 1 {
+			return
+		}
+		if packed == nil {
+			rejected[int(varint)] = true
+		}
+		for len(packed) > 0 {
+			index, n := binary.Uvarint(packed)
+			if n <= 0 {
+				malformed = true
+				return
+			}
+			rejected[int(index)] = true
+			packed = packed[n:]
+		}
+	})
+	if err != nil || malformed {
+		return nil, errMalformedAck
+	}
+	return rejected, nil
+}
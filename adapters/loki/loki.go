@@ -0,0 +1,310 @@
+package loki
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/internal/oauth2"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/klauspost/compress/snappy"
+)
+
+const (
+	// batches are kept to promtail's default size, well under the push
+	// requests Loki accepts, and label values to Loki's default
+	// max_label_value_length
+	maxBatchBytes  = 1024 * 1024
+	maxLabelValue  = 2048
+	entryOverhead  = 32
+	defaultPath    = "/loki/api/v1/push"
+	defaultTimeout = 30 * time.Second
+
+	defaultRetryCount = 10
+	maxRetryAfter     = time.Minute
+)
+
+func init() {
+	router.AdapterFactories.Register(NewLokiAdapter, "loki")
+	router.Capabilities.DescribeAdapter("loki", append([]string{
+		"labels", "tenant", "user", "password", "batch_size", "flush_interval",
+		"batch_adaptive", "batch_min_size", "batch_target_latency",
+	}, oauth2.Options...), nil)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+var logger = router.NewLogger("loki")
+
+func debug(v ...interface{}) {
+	logger.Debugln(v...)
+}
+
+// NewLokiAdapter returns a configured loki.Adapter for a route address of
+// the form host:port, or host:port/path to push to another path than
+// /loki/api/v1/push
+func NewLokiAdapter(route *router.Route) (router.LogAdapter, error) {
+	transport, found := router.AdapterTransports.Lookup(route.AdapterTransport("tcp"))
+	if !found {
+		return nil, errors.New("bad transport: " + route.Adapter)
+	}
+	addr, path := route.Address, defaultPath
+	if i := strings.Index(addr, "/"); i >= 0 {
+		addr, path = addr[:i], addr[i:]
+	}
+	if addr == "" {
+		return nil, errors.New("loki: address must be host:port or host:port/path: " + route.Address)
+	}
+	scheme, port := "http", "3100"
+	if route.AdapterTransport("tcp") == "tls" {
+		scheme, port = "https", "443"
+	}
+	addr = router.DefaultPort(addr, port)
+
+	batchStr := router.RouteOpt(route, "batch_size", "LOKI_BATCH_SIZE", "1000")
+	batchSize, err := strconv.Atoi(batchStr)
+	if err != nil || batchSize < 1 {
+		return nil, errors.New("loki: invalid value for batch_size: " + batchStr)
+	}
+	flushStr := router.RouteOpt(route, "flush_interval", "LOKI_FLUSH_INTERVAL", "1s")
+	flushInterval, err := time.ParseDuration(flushStr)
+	if err != nil || flushInterval <= 0 {
+		return nil, errors.New("loki: invalid value for flush_interval: " + flushStr)
+	}
+	var labels []string
+	if labelsStr := router.RouteOpt(route, "labels", "LOKI_LABELS", ""); labelsStr != "" {
+		for _, key := range strings.Split(labelsStr, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				labels = append(labels, key)
+			}
+		}
+	}
+
+	batching, err := router.NewBatchSizer(route, batchSize)
+	if err != nil {
+		return nil, errors.New("loki: " + err.Error())
+	}
+
+	retryCount, err := strconv.Atoi(getopt("RETRY_COUNT", strconv.Itoa(defaultRetryCount)))
+	if err != nil {
+		retryCount = defaultRetryCount
+	}
+
+	// connections go through the route's transport, so the TLS settings
+	// and dial_timeout apply
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return transport.Dial(addr, route.Options)
+	}
+	client := &http.Client{Timeout: defaultTimeout}
+	if scheme == "https" {
+		client.Transport = &http.Transport{DialTLSContext: dial}
+	} else {
+		client.Transport = &http.Transport{DialContext: dial}
+	}
+	// destinations behind OAuth2 get bearer tokens instead of basic auth
+	config, err := oauth2.RouteConfig(route)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		client.Transport = &oauth2.Transport{Source: oauth2.NewTokenSource(config), Base: client.Transport}
+	}
+
+	return &Adapter{
+		route:         route,
+		transport:     transport,
+		addr:          addr,
+		client:        client,
+		url:           scheme + "://" + addr + path,
+		tenant:        router.RouteOpt(route, "tenant", "LOKI_TENANT", ""),
+		user:          router.RouteOpt(route, "user", "LOKI_USER", ""),
+		password:      router.RouteOpt(route, "password", "LOKI_PASSWORD", ""),
+		labels:        labels,
+		batching:      batching,
+		flushInterval: flushInterval,
+		retryCount:    retryCount,
+		streams:       make(map[string]*stream),
+	}, nil
+}
+
+// Adapter pushes log output to the push API of Grafana Loki
+type Adapter struct {
+	route         *router.Route
+	transport     router.AdapterTransport
+	addr          string
+	client        *http.Client
+	url           string
+	tenant        string
+	user          string
+	password      string
+	labels        []string
+	batching      *router.BatchSizer
+	flushInterval time.Duration
+	retryCount    int
+	streams       map[string]*stream
+	batch         []*stream
+	batched       []*router.Message
+	bytes         int
+}
+
+// Warm connects to Loki, so a route with a bad address or TLS settings
+// keeps logspout from being ready
+func (a *Adapter) Warm() error {
+	conn, err := a.transport.Dial(a.addr, a.route.Options)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Stream pushes log data to Loki in batches, grouping the entries of each
+// batch by stream
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	defer a.flush()
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				return
+			}
+			labels := labelString(a.streamLabels(message))
+			size := len(message.Data) + entryOverhead
+			if len(a.batched) > 0 && a.bytes+size > maxBatchBytes {
+				a.flush()
+			}
+			s, ok := a.streams[labels]
+			if !ok {
+				s = &stream{labels: labels}
+				a.streams[labels] = s
+				a.batch = append(a.batch, s)
+				a.bytes += len(labels)
+			}
+			s.entries = append(s.entries, entry{time: message.Time, line: message.Data})
+			a.batched = append(a.batched, message)
+			a.bytes += size
+			if len(a.batched) >= a.batching.Size() || message.Exiting {
+				a.flush()
+			}
+		case <-ticker.C:
+			a.flush()
+		}
+	}
+}
+
+// streamLabels returns the labels of the stream of message: the container
+// name and stream source, and the container labels listed in the labels
+// option. Other container metadata is left out, as every distinct set of
+// labels makes a stream Loki has to index.
+func (a *Adapter) streamLabels(message *router.Message) map[string]string {
+	labels := map[string]string{
+		"container_name": strings.TrimPrefix(message.Container.Name, "/"),
+		"source":         message.Source,
+	}
+	if message.Container.Config != nil {
+		for _, key := range a.labels {
+			if value := message.Container.Config.Labels[key]; value != "" {
+				labels[labelName(key)] = value
+			}
+		}
+	}
+	for name, value := range labels {
+		if len(value) > maxLabelValue {
+			labels[name] = value[:maxLabelValue]
+		}
+	}
+	return labels
+}
+
+func (a *Adapter) flush() {
+	if len(a.batched) == 0 {
+		return
+	}
+	start := time.Now()
+	err := a.push(a.batch)
+	a.batching.Observe(len(a.batched), time.Since(start), err)
+	if err != nil {
+		logger.Error("dropping messages", "messages", len(a.batched), "category", router.ErrorCategory(err), "error", err)
+	}
+	for _, message := range a.batched {
+		router.Receipts.Report(a.route, message, err)
+	}
+	a.streams = make(map[string]*stream)
+	a.batch, a.batched, a.bytes = nil, nil, 0
+}
+
+// push sends streams as a snappy compressed protobuf push request, retrying
+// throttled requests, server errors and network errors with backoff
+func (a *Adapter) push(streams []*stream) error {
+	defer router.ObserveWrite(a.route, time.Now())
+	body := snappy.Encode(nil, encodePush(streams))
+	for try := 0; ; try++ {
+		retryable, retryAfter, err := a.post(body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || try >= a.retryCount {
+			return err
+		}
+		delay := router.RetryDelay(try)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		debug("loki: retrying in", delay, "after:", err)
+		router.WaitRetry(a.route, delay, a.batched...)
+	}
+}
+
+// post sends one push request, returning whether it failed in a way worth
+// retrying, and how long Loki asked to wait before doing so
+func (a *Adapter) post(body []byte) (bool, time.Duration, error) {
+	req, err := http.NewRequest("POST", a.url, bytes.NewReader(body))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "logspout")
+	if a.tenant != "" {
+		req.Header.Set("X-Scope-OrgID", a.tenant)
+	}
+	if a.user != "" {
+		req.SetBasicAuth(a.user, a.password)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return true, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, 0, nil
+	}
+	// Loki explains rejected pushes, e.g. entries out of order or too old
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = router.NewDeliveryError(router.HTTPErrorCategory(resp.StatusCode),
+		fmt.Errorf("%s returned %s: %s", a.url, resp.Status, bytes.TrimSpace(message)))
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+		if retryAfter > maxRetryAfter {
+			retryAfter = maxRetryAfter
+		}
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, retryAfter, err
+}
@@ -0,0 +1,198 @@
+package loki
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	_ "github.com/gliderlabs/logspout/transports/tcp"
+	"github.com/klauspost/compress/snappy"
+)
+
+var (
+	app = &docker.Container{
+		Name:   "/app",
+		Config: &docker.Config{Labels: map[string]string{"com.docker.compose.service": "web", "build": "1234"}},
+	}
+	db = &docker.Container{Name: "/db", Config: &docker.Config{}}
+)
+
+// fakeLoki records pushed entries as labels and lines, answering the first
+// throttled requests with 429 Too Many Requests
+type fakeLoki struct {
+	sync.Mutex
+	throttled int
+	requests  int
+	headers   []http.Header
+	entries   []string
+}
+
+func (f *fakeLoki) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	f.requests++
+	if req.URL.Path != "/loki/api/v1/push" || req.Header.Get("Content-Type") != "application/x-protobuf" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if f.throttled > 0 {
+		f.throttled--
+		http.Error(w, "ingestion rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	f.headers = append(f.headers, req.Header)
+	compressed, _ := ioutil.ReadAll(req.Body)
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, s := range fields(body)[1] {
+		stream := fields(s)
+		for _, e := range stream[2] {
+			entry := fields(e)
+			f.entries = append(f.entries, string(stream[1][0])+" "+string(entry[2][0]))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// fields returns the length delimited fields of a protobuf message by number
+func fields(b []byte) map[uint64][][]byte {
+	fields := make(map[uint64][][]byte)
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		if key&7 == 0 {
+			_, n = binary.Uvarint(b)
+			b = b[n:]
+			continue
+		}
+		size, n := binary.Uvarint(b)
+		fields[key>>3] = append(fields[key>>3], b[n:n+int(size)])
+		b = b[n+int(size):]
+	}
+	return fields
+}
+
+func streamAll(t *testing.T, route *router.Route, messages ...*router.Message) {
+	adapter, err := NewLokiAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logstream := make(chan *router.Message)
+	done := make(chan struct{})
+	go func() {
+		adapter.Stream(logstream)
+		close(done)
+	}()
+	for _, message := range messages {
+		logstream <- message
+	}
+	close(logstream)
+	<-done
+}
+
+func TestLokiPushesStreams(t *testing.T) {
+	fake := new(fakeLoki)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	now := time.Now()
+	streamAll(t, &router.Route{
+		Adapter: "loki",
+		Address: strings.TrimPrefix(server.URL, "http://"),
+		Options: map[string]string{
+			"labels":         "com.docker.compose.service",
+			"tenant":         "team-a",
+			"user":           "12345",
+			"password":       "secret",
+			"batch_size":     "3",
+			"flush_interval": "1h",
+		},
+	},
+		&router.Message{Container: app, Source: "stdout", Data: "one", Time: now},
+		&router.Message{Container: db, Source: "stdout", Data: "two", Time: now},
+		&router.Message{Container: app, Source: "stdout", Data: "three", Time: now},
+		&router.Message{Container: app, Source: "stderr", Data: "four", Time: now},
+	)
+
+	fake.Lock()
+	defer fake.Unlock()
+	if fake.requests != 2 {
+		t.Fatalf("expected 2 requests got %v", fake.requests)
+	}
+	expected := []string{
+		`{com_docker_compose_service="web", container_name="app", source="stdout"} one`,
+		`{com_docker_compose_service="web", container_name="app", source="stdout"} three`,
+		`{container_name="db", source="stdout"} two`,
+		`{com_docker_compose_service="web", container_name="app", source="stderr"} four`,
+	}
+	if strings.Join(fake.entries, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected entries\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(fake.entries, "\n"))
+	}
+	for _, header := range fake.headers {
+		if header.Get("X-Scope-OrgID") != "team-a" || header.Get("Authorization") != "Basic MTIzNDU6c2VjcmV0" {
+			t.Errorf("expected tenant and basic auth headers got %v", header)
+		}
+	}
+}
+
+func TestLokiRetriesThrottledPushes(t *testing.T) {
+	fake := &fakeLoki{throttled: 2}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	streamAll(t, &router.Route{
+		Adapter: "loki",
+		Address: strings.TrimPrefix(server.URL, "http://"),
+		Options: map[string]string{},
+	}, &router.Message{Container: db, Source: "stdout", Data: "retried", Time: time.Now()})
+
+	fake.Lock()
+	defer fake.Unlock()
+	if fake.requests != 3 || len(fake.entries) != 1 {
+		t.Errorf("expected the push retried twice got %v requests and entries %v", fake.requests, fake.entries)
+	}
+}
+
+func TestLokiRejectedPush(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "entry too far behind", http.StatusBadRequest)
+	}))
+	defer server.Close()
+	adapter, err := NewLokiAdapter(&router.Route{
+		Adapter: "loki",
+		Address: strings.TrimPrefix(server.URL, "http://"),
+		Options: map[string]string{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = adapter.(*Adapter).push([]*stream{{labels: `{source="stdout"}`, entries: []entry{{time.Now(), "old"}}}})
+	if err == nil || router.ErrorCategory(err) != router.ErrorSerialization || !strings.Contains(err.Error(), "entry too far behind") {
+		t.Errorf("expected the rejection without retries got %v", err)
+	}
+}
+
+func TestLokiAddress(t *testing.T) {
+	for address, expected := range map[string]string{
+		"loki":                     "http://loki:3100/loki/api/v1/push",
+		"gateway:8080/tenant/push": "http://gateway:8080/tenant/push",
+	} {
+		a, err := NewLokiAdapter(&router.Route{Adapter: "loki", Address: address, Options: map[string]string{}})
+		if err != nil || a.(*Adapter).url != expected {
+			t.Errorf("%s: expected %s got %v", address, expected, err)
+		}
+	}
+	if _, err := NewLokiAdapter(&router.Route{Adapter: "loki", Address: "loki", Options: map[string]string{"batch_size": "0"}}); err == nil {
+		t.Error("expected an error for batch_size 0")
+	}
+}
@@ -0,0 +1,82 @@
+package loki
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/internal/protowire"
+)
+
+// stream is the entries of a batch with the same labels
+type stream struct {
+	labels  string
+	entries []entry
+}
+
+type entry struct {
+	time time.Time
+	line string
+}
+
+// labelString returns labels in the form Loki parses stream selectors in,
+// e.g. {container_name="app", source="stdout"}, sorted by name
+func labelString(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// labelName returns key as a valid Loki label name, with the characters
+// Loki doesn't allow, like the dots of com.docker.compose.service, replaced
+// with underscores
+func labelName(key string) string {
+	name := []byte(key)
+	for i, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+// encodePush returns streams as a logproto.PushRequest protobuf message:
+//
+//	message PushRequest { repeated StreamAdapter streams = 1; }
+//	message StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	message EntryAdapter { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+func encodePush(streams []*stream) []byte {
+	var req []byte
+	for _, s := range streams {
+		msg := protowire.AppendBytes(nil, 1, []byte(s.labels))
+		for _, e := range s.entries {
+			var ts []byte
+			if seconds := e.time.Unix(); seconds != 0 {
+				ts = protowire.AppendVarint(ts, 1, uint64(seconds))
+			}
+			if nanos := e.time.Nanosecond(); nanos != 0 {
+				ts = protowire.AppendVarint(ts, 2, uint64(nanos))
+			}
+			entry := protowire.AppendBytes(nil, 1, ts)
+			entry = protowire.AppendBytes(entry, 2, []byte(e.line))
+			msg = protowire.AppendBytes(msg, 2, entry)
+		}
+		req = protowire.AppendBytes(req, 1, msg)
+	}
+	return req
+}
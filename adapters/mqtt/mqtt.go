@@ -0,0 +1,338 @@
+package mqtt
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultTopic      = "logspout/{{.ContainerName}}/{{.Source}}"
+	defaultRetryCount = 10
+	maxKeepAlive      = 65535 * time.Second
+)
+
+func init() {
+	router.AdapterFactories.Register(NewMQTTAdapter, "mqtt")
+	router.Capabilities.DescribeAdapter("mqtt", []string{
+		"topic", "qos", "client_id", "user", "password", "keepalive", "will_topic",
+		"batch_size", "flush_interval", "timeout",
+	}, nil)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+var logger = router.NewLogger("mqtt")
+
+func debug(v ...interface{}) {
+	logger.Debugln(v...)
+}
+
+// NewMQTTAdapter returns a configured mqtt.Adapter for a route address of
+// the form host:port
+func NewMQTTAdapter(route *router.Route) (router.LogAdapter, error) {
+	transport, found := router.AdapterTransports.Lookup(route.AdapterTransport("tcp"))
+	if !found {
+		return nil, errors.New("bad transport: " + route.Adapter)
+	}
+	addr := route.Address
+	if addr == "" {
+		return nil, errors.New("mqtt: address must be host:port: " + route.Address)
+	}
+	port := "1883"
+	if route.AdapterTransport("tcp") == "tls" {
+		port = "8883"
+	}
+	addr = router.DefaultPort(addr, port)
+
+	topic, err := template.New("topic").Parse(router.RouteOpt(route, "topic", "MQTT_TOPIC", defaultTopic))
+	if err != nil {
+		return nil, errors.New("mqtt: invalid value for topic: " + err.Error())
+	}
+	var payload *template.Template
+	override, err := route.TemplateOverride()
+	if err != nil {
+		return nil, err
+	}
+	if override != "" {
+		if payload, err = template.New("payload").Parse(override); err != nil {
+			return nil, errors.New("mqtt: invalid value for template: " + err.Error())
+		}
+	}
+	qosStr := router.RouteOpt(route, "qos", "MQTT_QOS", "0")
+	qos, err := strconv.Atoi(qosStr)
+	if err != nil || qos < 0 || qos > 1 {
+		return nil, errors.New("mqtt: invalid value for qos (must be 0 or 1): " + qosStr)
+	}
+	keepAliveStr := router.RouteOpt(route, "keepalive", "MQTT_KEEPALIVE", "60s")
+	keepAlive, err := time.ParseDuration(keepAliveStr)
+	if err != nil || keepAlive < time.Second || keepAlive > maxKeepAlive {
+		return nil, errors.New("mqtt: invalid value for keepalive: " + keepAliveStr)
+	}
+	batchStr := router.RouteOpt(route, "batch_size", "MQTT_BATCH_SIZE", "100")
+	batchSize, err := strconv.Atoi(batchStr)
+	if err != nil || batchSize < 1 {
+		return nil, errors.New("mqtt: invalid value for batch_size: " + batchStr)
+	}
+	flushStr := router.RouteOpt(route, "flush_interval", "MQTT_FLUSH_INTERVAL", "1s")
+	flushInterval, err := time.ParseDuration(flushStr)
+	if err != nil || flushInterval <= 0 {
+		return nil, errors.New("mqtt: invalid value for flush_interval: " + flushStr)
+	}
+	timeoutStr := router.RouteOpt(route, "timeout", "MQTT_TIMEOUT", "30s")
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil || timeout < 0 {
+		return nil, errors.New("mqtt: invalid value for timeout: " + timeoutStr)
+	}
+
+	host, _ := os.Hostname()
+	clientID := "logspout-" + host
+	if route.ID != "" {
+		clientID += "-" + route.ID
+	}
+	var w *will
+	if willTopic := router.RouteOpt(route, "will_topic", "MQTT_WILL_TOPIC", ""); willTopic != "" {
+		w = &will{topic: willTopic, message: "offline"}
+	}
+
+	retryCount, err := strconv.Atoi(getopt("RETRY_COUNT", strconv.Itoa(defaultRetryCount)))
+	if err != nil {
+		retryCount = defaultRetryCount
+	}
+
+	a := &Adapter{
+		route:         route,
+		transport:     transport,
+		addr:          addr,
+		clientID:      router.RouteOpt(route, "client_id", "MQTT_CLIENT_ID", clientID),
+		user:          router.RouteOpt(route, "user", "MQTT_USER", ""),
+		password:      router.RouteOpt(route, "password", "MQTT_PASSWORD", ""),
+		topic:         topic,
+		payload:       payload,
+		qos:           byte(qos),
+		keepAlive:     keepAlive,
+		will:          w,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		timeout:       timeout,
+		retryCount:    retryCount,
+	}
+	// fail the route early on bad addresses and credentials
+	if err := a.connect(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Adapter publishes log output to topics of an MQTT broker
+type Adapter struct {
+	route         *router.Route
+	transport     router.AdapterTransport
+	addr          string
+	clientID      string
+	user          string
+	password      string
+	topic         *template.Template
+	payload       *template.Template
+	qos           byte
+	keepAlive     time.Duration
+	will          *will
+	batchSize     int
+	flushInterval time.Duration
+	timeout       time.Duration
+	retryCount    int
+	conn          *connection
+	lastWrite     time.Time
+	batch         []*publishing
+	batched       []*router.Message
+}
+
+// Message extends router.Message with fields for topic templates
+type Message struct {
+	*router.Message
+}
+
+// ContainerName returns the message's container name
+func (m *Message) ContainerName() string {
+	return strings.TrimPrefix(m.Message.Container.Name, "/")
+}
+
+// ContainerID returns the message's short container id
+func (m *Message) ContainerID() string {
+	id := m.Message.Container.ID
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// Label returns the value of a container label
+func (m *Message) Label(key string) string {
+	if m.Message.Container.Config == nil {
+		return ""
+	}
+	return m.Message.Container.Config.Labels[key]
+}
+
+// connect connects to the broker, publishing the retained "online" status
+// to the will topic if the route has one
+func (a *Adapter) connect() error {
+	conn, err := router.Dial(a.transport, a.addr, a.route.Options)
+	if err != nil {
+		return err
+	}
+	if a.conn, err = open(conn, a.clientID, a.user, a.password, a.keepAlive, a.will, a.timeout); err != nil {
+		return err
+	}
+	a.lastWrite = time.Now()
+	if a.will == nil {
+		return nil
+	}
+	a.conn.publish(&publishing{topic: a.will.topic, payload: []byte("online"), qos: 1, retain: true})
+	if err = a.conn.flush(); err != nil {
+		a.conn.Close()
+		a.conn = nil
+	}
+	return err
+}
+
+// Stream publishes log data to the broker in batches
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	// pings are sent well within the keepalive the broker expects
+	keepAlive := time.NewTicker(a.keepAlive / 2)
+	defer keepAlive.Stop()
+	defer a.flush()
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				return
+			}
+			p, err := a.newPublishing(message)
+			if err != nil {
+				err = router.NewDeliveryError(router.ErrorSerialization, err)
+				router.LogDeliveryError("mqtt", err)
+				router.Receipts.Report(a.route, message, err)
+				continue
+			}
+			a.batch = append(a.batch, p)
+			a.batched = append(a.batched, message)
+			if len(a.batch) >= a.batchSize || message.Exiting {
+				a.flush()
+			}
+		case <-ticker.C:
+			a.flush()
+		case <-keepAlive.C:
+			if a.conn != nil && time.Since(a.lastWrite) >= a.keepAlive/2 {
+				debug("mqtt: sending ping")
+				if err := a.conn.ping(); err != nil {
+					logger.Warn("ping failed, reconnecting", "route", a.route.ID, "error", err)
+					a.conn.conn.Close()
+					a.conn = nil
+				}
+				a.lastWrite = time.Now()
+			}
+		}
+	}
+}
+
+// newPublishing returns the MQTT message for message, published to the
+// topic rendered for it with the message data, or the route's template
+// rendered, as the payload
+func (a *Adapter) newPublishing(message *router.Message) (*publishing, error) {
+	topic := new(bytes.Buffer)
+	if err := a.topic.Execute(topic, &Message{message}); err != nil {
+		return nil, err
+	}
+	if topic.Len() == 0 || strings.ContainsAny(topic.String(), "#+") {
+		return nil, errors.New("mqtt: invalid topic: " + topic.String())
+	}
+	payload := []byte(message.Data)
+	if a.payload != nil {
+		buf := new(bytes.Buffer)
+		if err := a.payload.Execute(buf, message); err != nil {
+			return nil, err
+		}
+		payload = buf.Bytes()
+	}
+	if topic.Len()+len(payload)+4 > maxRemaining {
+		return nil, errors.New("mqtt: message too large")
+	}
+	return &publishing{topic: topic.String(), payload: payload, qos: a.qos}, nil
+}
+
+func (a *Adapter) flush() {
+	if len(a.batch) == 0 {
+		return
+	}
+	err := a.publish(a.batch)
+	if err != nil {
+		logger.Error("dropping messages", "messages", len(a.batch), "category", router.ErrorCategory(err), "error", err)
+	}
+	for _, message := range a.batched {
+		router.Receipts.Report(a.route, message, err)
+	}
+	a.batch, a.batched = nil, nil
+}
+
+// publish sends messages, reconnecting and publishing them again until the
+// broker acknowledged those with QoS 1. Messages may be delivered twice
+// when a connection fails.
+func (a *Adapter) publish(messages []*publishing) error {
+	defer router.ObserveWrite(a.route, time.Now())
+	for try := 0; ; try++ {
+		err := a.publishOnce(messages)
+		if err == nil {
+			return nil
+		}
+		if try >= a.retryCount {
+			return err
+		}
+		delay := router.RetryDelay(try)
+		debug("mqtt: retrying in", delay, "after:", err)
+		router.WaitRetry(a.route, delay, a.batched...)
+	}
+}
+
+func (a *Adapter) publishOnce(messages []*publishing) error {
+	if a.conn == nil {
+		if err := a.connect(); err != nil {
+			return err
+		}
+	}
+	for _, p := range messages {
+		a.conn.publish(p)
+	}
+	if err := a.conn.flush(); err != nil {
+		// the pending messages are published again on a new connection
+		a.conn.conn.Close()
+		a.conn = nil
+		return err
+	}
+	a.lastWrite = time.Now()
+	return nil
+}
+
+// Close disconnects from the broker, which then discards the will
+func (a *Adapter) Close() error {
+	if a.conn == nil {
+		return nil
+	}
+	err := a.conn.Close()
+	a.conn = nil
+	return err
+}
@@ -0,0 +1,201 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	_ "github.com/gliderlabs/logspout/transports/tcp"
+)
+
+var container = &docker.Container{
+	ID:     "8dfafdbc3a40b1b0bd3a0f5c",
+	Name:   "/container",
+	Config: &docker.Config{Image: "app:1.0", Hostname: "8dfafdbc3a40"},
+}
+
+// fakeBroker accepts MQTT connections with the CONNACK return code refuse,
+// recording what they publish as topic and payload
+type fakeBroker struct {
+	sync.Mutex
+	ln        net.Listener
+	refuse    byte
+	connects  []string
+	published []string
+	pings     int
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	c := &connection{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	typ, body, err := c.readPacket()
+	if err != nil || typ>>4 != packetConnect {
+		return
+	}
+	// the client id follows the protocol name, level, flags and keepalive
+	b.Lock()
+	b.connects = append(b.connects, string(body[12:12+binary.BigEndian.Uint16(body[10:])]))
+	refuse := b.refuse
+	b.Unlock()
+	c.packet(packetConnack<<4, []byte{0, refuse})
+	c.w.Flush()
+	for {
+		typ, body, err := c.readPacket()
+		if err != nil {
+			return
+		}
+		switch typ >> 4 {
+		case packetPublish:
+			size := binary.BigEndian.Uint16(body)
+			topic, rest := string(body[2:2+size]), body[2+size:]
+			qos := typ >> 1 & 3
+			payload := rest
+			if qos > 0 {
+				payload = rest[2:]
+			}
+			b.Lock()
+			b.published = append(b.published, topic+" "+string(payload))
+			b.Unlock()
+			if qos > 0 {
+				c.packet(packetPuback<<4, rest[:2])
+				c.w.Flush()
+			}
+		case packetPingreq:
+			b.Lock()
+			b.pings++
+			b.Unlock()
+			c.packet(packetPingresp<<4, nil)
+			c.w.Flush()
+		case packetDisconnect:
+			return
+		}
+	}
+}
+
+func streamAll(t *testing.T, route *router.Route, messages ...*router.Message) {
+	adapter, err := NewMQTTAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logstream := make(chan *router.Message)
+	done := make(chan struct{})
+	go func() {
+		adapter.Stream(logstream)
+		close(done)
+	}()
+	for _, message := range messages {
+		logstream <- message
+	}
+	close(logstream)
+	<-done
+	adapter.(*Adapter).Close()
+}
+
+func TestMQTTPublish(t *testing.T) {
+	b := newFakeBroker(t)
+	defer b.ln.Close()
+
+	streamAll(t, &router.Route{
+		Adapter: "mqtt",
+		Address: b.ln.Addr().String(),
+		Options: map[string]string{
+			"qos":        "1",
+			"client_id":  "edge-1",
+			"will_topic": "gateways/edge-1/status",
+			"topic":      "logs/{{.ContainerName}}/{{.Source}}",
+			"template":   base64.StdEncoding.EncodeToString([]byte("{{.Source}}: {{.Data}}")),
+		},
+	},
+		&router.Message{Container: container, Source: "stdout", Data: "one", Time: time.Now()},
+		&router.Message{Container: container, Source: "stderr", Data: "two", Time: time.Now()},
+	)
+
+	b.Lock()
+	defer b.Unlock()
+	expected := []string{"gateways/edge-1/status online", "logs/container/stdout stdout: one", "logs/container/stderr stderr: two"}
+	if len(b.published) != len(expected) {
+		t.Fatalf("expected %v got %v", expected, b.published)
+	}
+	for i := range expected {
+		if b.published[i] != expected[i] {
+			t.Errorf("expected %q got %q", expected[i], b.published[i])
+		}
+	}
+	if len(b.connects) != 1 || b.connects[0] != "edge-1" {
+		t.Errorf("expected one connection as edge-1 got %v", b.connects)
+	}
+}
+
+func TestMQTTRefused(t *testing.T) {
+	b := newFakeBroker(t)
+	defer b.ln.Close()
+	b.refuse = 4
+
+	_, err := NewMQTTAdapter(&router.Route{Adapter: "mqtt", Address: b.ln.Addr().String(), Options: map[string]string{}})
+	if err == nil || router.ErrorCategory(err) != router.ErrorAuth {
+		t.Errorf("expected an auth error got %v", err)
+	}
+}
+
+func TestMQTTPing(t *testing.T) {
+	b := newFakeBroker(t)
+	defer b.ln.Close()
+	adapter, err := NewMQTTAdapter(&router.Route{Adapter: "mqtt", Address: b.ln.Addr().String(), Options: map[string]string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := adapter.(*Adapter)
+	defer a.Close()
+	if err := a.conn.ping(); err != nil {
+		t.Fatal(err)
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.pings != 1 {
+		t.Errorf("expected a ping got %v", b.pings)
+	}
+}
+
+func TestMQTTOptions(t *testing.T) {
+	b := newFakeBroker(t)
+	defer b.ln.Close()
+	for _, options := range []map[string]string{
+		{"qos": "2"},
+		{"keepalive": "100ms"},
+		{"topic": "{{"},
+		{"batch_size": "0"},
+	} {
+		if _, err := NewMQTTAdapter(&router.Route{Adapter: "mqtt", Address: b.ln.Addr().String(), Options: options}); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+	a := &Adapter{topic: template.Must(template.New("topic").Parse("logs/{{.Data}}"))}
+	if _, err := a.newPublishing(&router.Message{Container: container, Data: "#"}); err == nil {
+		t.Error("expected an error for a topic with a wildcard")
+	}
+}
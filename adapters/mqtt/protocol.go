@@ -0,0 +1,278 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// the subset of MQTT 3.1.1 needed to publish with QoS 0 and 1, see
+// http://docs.oasis-open.org/mqtt/mqtt/v3.1.1/os/mqtt-v3.1.1-os.html
+const (
+	packetConnect    = 1
+	packetConnack    = 2
+	packetPublish    = 3
+	packetPuback     = 4
+	packetPingreq    = 12
+	packetPingresp   = 13
+	packetDisconnect = 14
+
+	protocolLevel = 4
+
+	flagUsername     = 0x80
+	flagPassword     = 0x40
+	flagWillRetain   = 0x20
+	flagWillQoS1     = 0x08
+	flagWill         = 0x04
+	flagCleanSession = 0x02
+
+	flagDup    = 0x08
+	flagRetain = 0x01
+
+	// the largest remaining length of a packet
+	maxRemaining = 268435455
+)
+
+var errMalformed = errors.New("mqtt: malformed packet")
+
+// connackErrors are the reasons a broker refuses connections by return code
+var connackErrors = map[byte]error{
+	1: errors.New("mqtt: broker refused the connection: unacceptable protocol version"),
+	2: router.NewDeliveryError(router.ErrorAuth, errors.New("mqtt: broker refused the connection: client id rejected")),
+	3: router.NewDeliveryError(router.ErrorConnect, errors.New("mqtt: broker refused the connection: server unavailable")),
+	4: router.NewDeliveryError(router.ErrorAuth, errors.New("mqtt: broker refused the connection: bad user name or password")),
+	5: router.NewDeliveryError(router.ErrorAuth, errors.New("mqtt: broker refused the connection: not authorized")),
+}
+
+// will is the message the broker publishes when the connection is lost
+// without a DISCONNECT
+type will struct {
+	topic   string
+	message string
+}
+
+// connection is an MQTT client connection
+type connection struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+	// the packet id of the last QoS 1 message published, and the ids the
+	// broker hasn't acknowledged yet
+	packetID uint16
+	pending  map[uint16]bool
+}
+
+// publishing is a message to publish
+type publishing struct {
+	topic   string
+	payload []byte
+	qos     byte
+	retain  bool
+	// the packet id a QoS 1 message was last published with
+	packetID uint16
+}
+
+// open sends CONNECT on conn and waits for the broker to accept it
+func open(conn net.Conn, clientID, user, password string, keepAlive time.Duration, w *will, timeout time.Duration) (*connection, error) {
+	c := &connection{
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		w:       bufio.NewWriter(conn),
+		timeout: timeout,
+		pending: make(map[uint16]bool),
+	}
+	if err := c.connect(clientID, user, password, keepAlive, w); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *connection) connect(clientID, user, password string, keepAlive time.Duration, w *will) error {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+		defer c.conn.SetDeadline(time.Time{})
+	}
+	flags := byte(flagCleanSession)
+	payload := appendString(nil, clientID)
+	if w != nil {
+		// the will is retained so subscribers joining later see it
+		flags |= flagWill | flagWillQoS1 | flagWillRetain
+		payload = appendString(payload, w.topic)
+		payload = appendString(payload, w.message)
+	}
+	if user != "" {
+		flags |= flagUsername
+		payload = appendString(payload, user)
+		if password != "" {
+			flags |= flagPassword
+			payload = appendString(payload, password)
+		}
+	}
+	header := appendString(nil, "MQTT")
+	header = append(header, protocolLevel, flags)
+	header = append(header, byte(keepAlive/time.Second>>8), byte(keepAlive/time.Second))
+	c.packet(packetConnect<<4, append(header, payload...))
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+
+	typ, body, err := c.readPacket()
+	if err != nil {
+		return err
+	}
+	if typ>>4 != packetConnack || len(body) != 2 {
+		return errMalformed
+	}
+	if body[1] != 0 {
+		if err, ok := connackErrors[body[1]]; ok {
+			return err
+		}
+		return fmt.Errorf("mqtt: broker refused the connection: return code %d", body[1])
+	}
+	return nil
+}
+
+// publish buffers a message, assigning QoS 1 messages a packet id. Messages
+// that were published before are sent again with the DUP flag.
+func (c *connection) publish(p *publishing) {
+	header := byte(packetPublish<<4) | p.qos<<1
+	if p.retain {
+		header |= flagRetain
+	}
+	body := appendString(nil, p.topic)
+	if p.qos > 0 {
+		if p.packetID != 0 {
+			header |= flagDup
+		}
+		c.packetID++
+		if c.packetID == 0 {
+			c.packetID = 1
+		}
+		p.packetID = c.packetID
+		body = append(body, byte(p.packetID>>8), byte(p.packetID))
+		c.pending[p.packetID] = true
+	}
+	c.packet(header, append(body, p.payload...))
+}
+
+// flush writes the buffered messages and waits until the broker
+// acknowledged those published with QoS 1
+func (c *connection) flush() error {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+		defer c.conn.SetDeadline(time.Time{})
+	}
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	for len(c.pending) > 0 {
+		typ, body, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+		if typ>>4 != packetPuback {
+			continue
+		}
+		if len(body) != 2 {
+			return errMalformed
+		}
+		delete(c.pending, binary.BigEndian.Uint16(body))
+	}
+	return nil
+}
+
+// ping sends PINGREQ and waits for the broker's PINGRESP, keeping the
+// connection alive while no messages are published
+func (c *connection) ping() error {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+		defer c.conn.SetDeadline(time.Time{})
+	}
+	c.packet(packetPingreq<<4, nil)
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	for {
+		typ, _, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+		if typ>>4 == packetPingresp {
+			return nil
+		}
+	}
+}
+
+// Close sends DISCONNECT, so the broker discards the will, and closes the
+// connection
+func (c *connection) Close() error {
+	c.conn.SetDeadline(time.Now().Add(time.Second))
+	c.packet(packetDisconnect<<4, nil)
+	c.w.Flush()
+	return c.conn.Close()
+}
+
+// packet buffers a packet
+func (c *connection) packet(header byte, body []byte) {
+	c.w.WriteByte(header)
+	c.w.Write(appendRemaining(nil, len(body)))
+	c.w.Write(body)
+}
+
+// readPacket reads the next packet's fixed header byte and body
+func (c *connection) readPacket() (byte, []byte, error) {
+	header, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	size, multiplier := 0, 1
+	for i := 0; ; i++ {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		size += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errMalformed
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// appendRemaining appends the variable length encoding of a packet's
+// remaining length
+func appendRemaining(b []byte, n int) []byte {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+// appendString appends a UTF-8 string prefixed with its 2 byte length
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
@@ -2,6 +2,7 @@ package multiline
 
 import (
 	"errors"
+	"io"
 	"os"
 	"regexp"
 	"strconv"
@@ -22,6 +23,10 @@ const (
 
 func init() {
 	router.AdapterFactories.Register(NewMultilineAdapter, "multiline")
+	router.ProcessorFactories.Register(NewMultilineProcessor, "multiline")
+	options := []string{"enable_default", "pattern", "separator", "match", "flush_after"}
+	router.Capabilities.DescribeAdapter("multiline", options, nil)
+	router.Capabilities.DescribeProcessor("multiline", options)
 }
 
 // Adapter collects multi-lint log entries and sends them to the next adapter as a single entry
@@ -41,8 +46,49 @@ type Adapter struct {
 
 // NewMultilineAdapter returns a configured multiline.Adapter
 func NewMultilineAdapter(route *router.Route) (a router.LogAdapter, err error) {
+	parts := strings.SplitN(route.Adapter, "+", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("multiline: adapter must have a sub-adapter, eg: multiline+raw+tcp")
+	}
+
+	originalAdapter := route.Adapter
+	route.Adapter = parts[1]
+	factory, found := router.AdapterFactories.Lookup(route.AdapterType())
+	if !found {
+		return nil, errors.New("bad adapter: " + originalAdapter)
+	}
+	subAdapter, err := factory(route)
+	if err != nil {
+		return nil, err
+	}
+	route.Adapter = originalAdapter
+
+	adapter, err := newAdapter(nil)
+	if err != nil {
+		return nil, err
+	}
+	adapter.subAdapter = subAdapter
+	adapter.out = make(chan *router.Message)
+	return adapter, nil
+}
+
+// NewMultilineProcessor returns a multiline.Adapter for use in a route's processor chain.
+// Options are named like the MULTILINE_ environment variables without the prefix,
+// e.g. pattern, and fall back to them when not set
+func NewMultilineProcessor(route *router.Route, options map[string]string) (router.Processor, error) {
+	return newAdapter(options)
+}
+
+func newAdapter(options map[string]string) (*Adapter, error) {
+	getopt := func(name string) string {
+		if value := options[strings.ToLower(name)]; value != "" {
+			return value
+		}
+		return os.Getenv("MULTILINE_" + name)
+	}
+
 	enableByDefault := true
-	enableStr := os.Getenv("MULTILINE_ENABLE_DEFAULT")
+	enableStr := getopt("ENABLE_DEFAULT")
 	if enableStr != "" {
 		var err error
 		enableByDefault, err = strconv.ParseBool(enableStr)
@@ -51,12 +97,12 @@ func NewMultilineAdapter(route *router.Route) (a router.LogAdapter, err error) {
 		}
 	}
 
-	pattern := os.Getenv("MULTILINE_PATTERN")
+	pattern := getopt("PATTERN")
 	if pattern == "" {
 		pattern = `^\s`
 	}
 
-	separator := os.Getenv("MULTILINE_SEPARATOR")
+	separator := getopt("SEPARATOR")
 	if separator == "" {
 		separator = "\n"
 	}
@@ -65,7 +111,7 @@ func NewMultilineAdapter(route *router.Route) (a router.LogAdapter, err error) {
 		return nil, errors.New("multiline: invalid value for MULTILINE_PATTERN (must be regexp): " + pattern)
 	}
 
-	matchType := os.Getenv("MULTILINE_MATCH")
+	matchType := getopt("MATCH")
 	if matchType == "" {
 		matchType = matchNonFirst
 	}
@@ -90,7 +136,7 @@ func NewMultilineAdapter(route *router.Route) (a router.LogAdapter, err error) {
 	}
 
 	flushAfter := 500 * time.Millisecond
-	flushAfterStr := os.Getenv("MULTILINE_FLUSH_AFTER")
+	flushAfterStr := getopt("FLUSH_AFTER")
 	if flushAfterStr != "" {
 		timeoutMS, err := strconv.Atoi(flushAfterStr)
 		if err != nil {
@@ -99,29 +145,9 @@ func NewMultilineAdapter(route *router.Route) (a router.LogAdapter, err error) {
 		flushAfter = time.Duration(timeoutMS) * time.Millisecond
 	}
 
-	parts := strings.SplitN(route.Adapter, "+", 2)
-	if len(parts) != 2 {
-		return nil, errors.New("multiline: adapter must have a sub-adapter, eg: multiline+raw+tcp")
-	}
-
-	originalAdapter := route.Adapter
-	route.Adapter = parts[1]
-	factory, found := router.AdapterFactories.Lookup(route.AdapterType())
-	if !found {
-		return nil, errors.New("bad adapter: " + originalAdapter)
-	}
-	subAdapter, err := factory(route)
-	if err != nil {
-		return nil, err
-	}
-	route.Adapter = originalAdapter
-
-	out := make(chan *router.Message)
 	checkInterval := flushAfter / 2
 
 	return &Adapter{
-		out:             out,
-		subAdapter:      subAdapter,
 		enableByDefault: enableByDefault,
 		pattern:         patternRegexp,
 		separator:       separator,
@@ -142,13 +168,25 @@ func (a *Adapter) Stream(logstream chan *router.Message) {
 		a.subAdapter.Stream(a.out)
 		wg.Done()
 	}()
+	a.Process(logstream, a.out)
+	close(a.out)
+	wg.Wait()
+}
+
+// Close closes the sub adapter if it holds a connection
+func (a *Adapter) Close() error {
+	if closer, ok := a.subAdapter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Process joins multi-line log entries from logstream and sends them to out
+func (a *Adapter) Process(logstream chan *router.Message, out chan *router.Message) {
 	defer func() {
 		for _, message := range a.buffers {
-			a.out <- message
+			out <- message
 		}
-
-		close(a.out)
-		wg.Wait()
 	}()
 
 	for {
@@ -159,7 +197,7 @@ func (a *Adapter) Stream(logstream chan *router.Message) {
 			}
 
 			if !multilineContainer(message.Container, a.enableByDefault) {
-				a.out <- message
+				out <- message
 				continue
 			}
 
@@ -167,10 +205,10 @@ func (a *Adapter) Stream(logstream chan *router.Message) {
 			old, oldExists := a.buffers[cID]
 			if a.isFirstLine(message) {
 				if oldExists {
-					a.out <- old
+					out <- old
 				}
 
-				a.buffers[cID] = message
+				a.buffers[cID] = message.Copy()
 			} else {
 				isLastLine := a.isLastLine(message)
 				
@@ -180,12 +218,12 @@ func (a *Adapter) Stream(logstream chan *router.Message) {
 				}
 
 				if isLastLine {
-					a.out <- message
+					out <- message
 					if oldExists {
 						delete(a.buffers, cID)
 					}
-				} else {
-					a.buffers[cID] = message
+				} else if !oldExists {
+					a.buffers[cID] = message.Copy()
 				}
 			}
 		case <-a.nextCheck:
@@ -193,7 +231,7 @@ func (a *Adapter) Stream(logstream chan *router.Message) {
 
 			for key, message := range a.buffers {
 				if message.Time.Add(a.flushAfter).After(now) {
-					a.out <- message
+					out <- message
 					delete(a.buffers, key)
 				}
 			}
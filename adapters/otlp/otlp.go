@@ -0,0 +1,505 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	collector "github.com/gliderlabs/logspout/internal/otlp"
+	"github.com/gliderlabs/logspout/internal/protowire"
+	"github.com/gliderlabs/logspout/router"
+	"golang.org/x/net/http2"
+)
+
+const (
+	exportPath  = "/opentelemetry.proto.collector.logs.v1.LogsService/Export"
+	defaultPath = "/v1/logs"
+	// batches are kept under the 4MB gRPC servers receive by default
+	maxBatchBytes    = 4*1024*1024 - 64*1024
+	maxResponseBytes = 64 * 1024
+
+	defaultRetryCount = 10
+)
+
+// gRPC status codes, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	codeCancelled         = 1
+	codeInvalidArgument   = 3
+	codeDeadlineExceeded  = 4
+	codePermissionDenied  = 7
+	codeResourceExhausted = 8
+	codeAborted           = 10
+	codeOutOfRange        = 11
+	codeUnavailable       = 14
+	codeDataLoss          = 15
+	codeUnauthenticated   = 16
+)
+
+func init() {
+	router.AdapterFactories.Register(NewOTLPAdapter, "otlp")
+	router.Capabilities.DescribeAdapter("otlp", []string{
+		"protocol", "headers", "labels", "level_field", "resource_attributes",
+		"batch_size", "flush_interval", "timeout",
+		"batch_adaptive", "batch_min_size", "batch_target_latency",
+	}, nil)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+var logger = router.NewLogger("otlp")
+
+func debug(v ...interface{}) {
+	logger.Debugln(v...)
+}
+
+// getHostname returns the name of the Docker host found by the chain of
+// HOSTNAME_SOURCES, by default as mounted at /etc/host_hostname, or else
+// the name logspout's container has
+func getHostname() string {
+	if name := router.Hostnames.Get(); name != "" {
+		return name
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// NewOTLPAdapter returns a configured otlp.Adapter for a route address of
+// the form host:port of an OpenTelemetry Collector, or host:port/path to
+// export over HTTP to another path than /v1/logs
+func NewOTLPAdapter(route *router.Route) (router.LogAdapter, error) {
+	transportName := route.AdapterTransport("tcp")
+	transport, found := router.AdapterTransports.Lookup(transportName)
+	if !found {
+		return nil, errors.New("bad transport: " + route.Adapter)
+	}
+	protocol := router.RouteOpt(route, "protocol", "OTLP_PROTOCOL", "grpc")
+	if protocol != "grpc" && protocol != "http/protobuf" {
+		return nil, errors.New("otlp: invalid value for protocol (must be grpc or http/protobuf): " + protocol)
+	}
+	addr, path := route.Address, defaultPath
+	if i := strings.Index(addr, "/"); i >= 0 {
+		addr, path = addr[:i], addr[i:]
+		if protocol == "grpc" {
+			return nil, errors.New("otlp: address must be host:port with gRPC: " + route.Address)
+		}
+	}
+	if addr == "" {
+		return nil, errors.New("otlp: address must be host:port or host:port/path: " + route.Address)
+	}
+	scheme, port := "http", "4317"
+	if protocol != "grpc" {
+		port = "4318"
+	}
+	if transportName == "tls" {
+		scheme = "https"
+	}
+	addr = router.DefaultPort(addr, port)
+
+	headers, err := collector.ParseHeaders(router.RouteOpt(route, "headers", "OTLP_HEADERS", ""))
+	if err != nil {
+		return nil, errors.New("otlp: invalid value for headers: " + err.Error())
+	}
+	resourceAttributes, err := collector.ParseHeaders(router.RouteOpt(route, "resource_attributes", "OTLP_RESOURCE_ATTRIBUTES",
+		getopt("OTEL_RESOURCE_ATTRIBUTES", "")))
+	if err != nil {
+		return nil, errors.New("otlp: invalid value for resource_attributes: " + err.Error())
+	}
+	batchStr := router.RouteOpt(route, "batch_size", "OTLP_BATCH_SIZE", "512")
+	batchSize, err := strconv.Atoi(batchStr)
+	if err != nil || batchSize < 1 {
+		return nil, errors.New("otlp: invalid value for batch_size: " + batchStr)
+	}
+	flushStr := router.RouteOpt(route, "flush_interval", "OTLP_FLUSH_INTERVAL", "1s")
+	flushInterval, err := time.ParseDuration(flushStr)
+	if err != nil || flushInterval <= 0 {
+		return nil, errors.New("otlp: invalid value for flush_interval: " + flushStr)
+	}
+	timeoutStr := router.RouteOpt(route, "timeout", "OTLP_TIMEOUT", "10s")
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil || timeout <= 0 {
+		return nil, errors.New("otlp: invalid value for timeout: " + timeoutStr)
+	}
+	var labels []string
+	if labelsStr := router.RouteOpt(route, "labels", "OTLP_LABELS", ""); labelsStr != "" {
+		for _, key := range strings.Split(labelsStr, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				labels = append(labels, key)
+			}
+		}
+	}
+	batching, err := router.NewBatchSizer(route, batchSize)
+	if err != nil {
+		return nil, errors.New("otlp: " + err.Error())
+	}
+	retryCount, err := strconv.Atoi(getopt("RETRY_COUNT", strconv.Itoa(defaultRetryCount)))
+	if err != nil {
+		retryCount = defaultRetryCount
+	}
+
+	// connections go through the route's transport, so the TLS settings
+	// and dial_timeout apply
+	options := route.Options
+	client := &http.Client{Timeout: timeout}
+	if protocol == "grpc" {
+		if scheme == "https" && options["tls.alpn"] == "" {
+			// gRPC servers refuse TLS connections not negotiating HTTP/2
+			options = make(map[string]string, len(route.Options)+1)
+			for key, value := range route.Options {
+				options[key] = value
+			}
+			options["tls.alpn"] = "h2"
+		}
+		client.Transport = &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return transport.Dial(addr, options)
+			},
+		}
+		path = exportPath
+	} else {
+		dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return transport.Dial(addr, options)
+		}
+		if scheme == "https" {
+			client.Transport = &http.Transport{DialTLSContext: dial}
+		} else {
+			client.Transport = &http.Transport{DialContext: dial}
+		}
+	}
+
+	resource := []keyValue{{key: "host.name", value: getHostname()}}
+	for _, key := range sortedKeys(resourceAttributes) {
+		resource = append(resource, keyValue{key: key, value: resourceAttributes[key]})
+	}
+	return &Adapter{
+		route:         route,
+		transport:     transport,
+		addr:          addr,
+		options:       options,
+		client:        client,
+		url:           scheme + "://" + addr + path,
+		grpc:          protocol == "grpc",
+		headers:       headers,
+		resource:      resource,
+		labels:        labels,
+		levelField:    router.RouteOpt(route, "level_field", "OTLP_LEVEL_FIELD", "level"),
+		batching:      batching,
+		flushInterval: flushInterval,
+		retryCount:    retryCount,
+		scope:         protowire.AppendString(nil, 1, "logspout"),
+	}, nil
+}
+
+// Adapter exports log output to an OpenTelemetry Collector as OTLP log
+// records, with the metadata of containers as resource attributes
+type Adapter struct {
+	route         *router.Route
+	transport     router.AdapterTransport
+	addr          string
+	options       map[string]string
+	client        *http.Client
+	url           string
+	grpc          bool
+	headers       map[string]string
+	resource      []keyValue
+	labels        []string
+	levelField    string
+	batching      *router.BatchSizer
+	flushInterval time.Duration
+	retryCount    int
+	scope         []byte
+	records       [][]byte
+	bytes         int
+	batched       []*router.Message
+}
+
+// Warm connects to the collector with the options of its connections,
+// negotiating HTTP/2 for gRPC over TLS
+func (a *Adapter) Warm() error {
+	conn, err := a.transport.Dial(a.addr, a.options)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Stream exports log data in batches
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	defer a.flush()
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				return
+			}
+			record := a.record(message).encode()
+			if len(a.batched) > 0 && a.bytes+len(record) > maxBatchBytes {
+				a.flush()
+			}
+			a.records = append(a.records, record)
+			a.bytes += len(record)
+			a.batched = append(a.batched, message)
+			if len(a.batched) >= a.batching.Size() || message.Exiting {
+				a.flush()
+			}
+		case <-ticker.C:
+			a.flush()
+		}
+	}
+}
+
+// record returns the log record of message, with its fields as attributes
+// and its level as the severity. Trace context found by the correlate
+// processor becomes the record's trace and span IDs.
+func (a *Adapter) record(message *router.Message) *logRecord {
+	r := &logRecord{
+		time:         message.Time.UnixNano(),
+		observed:     time.Now().UnixNano(),
+		severityText: message.Fields[a.levelField],
+		body:         message.Data,
+		attributes:   []keyValue{{key: "log.iostream", value: message.Source}},
+		traceID:      message.Fields["trace_id"],
+		spanID:       message.Fields["span_id"],
+	}
+	for _, key := range sortedKeys(message.Fields) {
+		if key == a.levelField {
+			continue
+		}
+		r.attributes = append(r.attributes, keyValue{key: key, value: message.Fields[key]})
+	}
+	return r
+}
+
+// resourceOf returns the resource attributes of the container of message:
+// its service, id, name and image, the labels listed in the labels option,
+// the Docker host's name and the route's resource attributes
+func (a *Adapter) resourceOf(message *router.Message) []keyValue {
+	c := message.Container
+	name := strings.TrimPrefix(c.Name, "/")
+	service := name
+	var attributes []keyValue
+	if c.Config != nil {
+		if compose := c.Config.Labels["com.docker.compose.service"]; compose != "" {
+			service = compose
+		}
+		image, tag := c.Config.Image, ""
+		if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+			image, tag = image[:i], image[i+1:]
+		}
+		attributes = append(attributes, keyValue{key: "container.image.name", value: image})
+		if tag != "" {
+			attributes = append(attributes, keyValue{key: "container.image.tags", values: []string{tag}})
+		}
+		for _, label := range a.labels {
+			if value, ok := c.Config.Labels[label]; ok {
+				attributes = append(attributes, keyValue{key: "container.label." + label, value: value})
+			}
+		}
+	}
+	attributes = append([]keyValue{
+		{key: "service.name", value: service},
+		{key: "container.id", value: c.ID},
+		{key: "container.name", value: name},
+		{key: "container.runtime", value: "docker"},
+	}, attributes...)
+	return append(attributes, a.resource...)
+}
+
+func (a *Adapter) flush() {
+	if len(a.batched) == 0 {
+		return
+	}
+	// records are grouped by container, each a resource
+	var resources [][]keyValue
+	var records [][][]byte
+	index := make(map[string]int)
+	for i, message := range a.batched {
+		j, ok := index[message.Container.ID]
+		if !ok {
+			j = len(resources)
+			index[message.Container.ID] = j
+			resources = append(resources, a.resourceOf(message))
+			records = append(records, nil)
+		}
+		records[j] = append(records[j], a.records[i])
+	}
+	start := time.Now()
+	err := a.export(encodeRequest(resources, records, a.scope))
+	a.batching.Observe(len(a.batched), time.Since(start), err)
+	if err != nil {
+		logger.Error("dropping messages", "messages", len(a.batched), "category", router.ErrorCategory(err), "error", err)
+	}
+	for _, message := range a.batched {
+		router.Receipts.Report(a.route, message, err)
+	}
+	a.records, a.bytes, a.batched = nil, 0, nil
+}
+
+// export sends an ExportLogsServiceRequest, retrying throttled requests,
+// unavailable collectors and network errors with backoff
+func (a *Adapter) export(request []byte) error {
+	defer router.ObserveWrite(a.route, time.Now())
+	body := request
+	if a.grpc {
+		body = protowire.Frame(request)
+	}
+	for try := 0; ; try++ {
+		retryable, err := a.post(body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || try >= a.retryCount {
+			return err
+		}
+		delay := router.RetryDelay(try)
+		debug("otlp: retrying in", delay, "after:", err)
+		router.WaitRetry(a.route, delay, a.batched...)
+	}
+}
+
+// post sends one export request, returning whether it failed in a way worth
+// retrying
+func (a *Adapter) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", a.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for key, value := range a.headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("User-Agent", "logspout")
+	if a.grpc {
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("TE", "trailers")
+	} else {
+		req.Header.Set("Content-Type", "application/x-protobuf")
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if a.grpc {
+		return a.grpcResponse(resp)
+	}
+	response, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return true, err
+	}
+	if resp.StatusCode >= 300 {
+		e := &collector.Error{StatusCode: resp.StatusCode, Status: resp.Status}
+		// collectors explain errors with a google.rpc.Status, or text
+		if resp.Header.Get("Content-Type") == "application/x-protobuf" {
+			e.Message = statusMessage(response)
+		} else {
+			e.Message = string(bytes.TrimSpace(response))
+		}
+		return e.Retryable(), router.NewDeliveryError(router.HTTPErrorCategory(resp.StatusCode), e)
+	}
+	a.partialSuccess(response)
+	return false, nil
+}
+
+// grpcResponse returns the error of an Export call, if it failed
+func (a *Adapter) grpcResponse(resp *http.Response) (bool, error) {
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode >= 500, router.NewDeliveryError(router.HTTPErrorCategory(resp.StatusCode),
+			fmt.Errorf("otlp: %s returned %s", a.url, resp.Status))
+	}
+	// errors without a response message come in the headers
+	if err := grpcStatus(resp.Header); err != nil {
+		return err.(*Error).Retryable(), err
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return true, err
+	}
+	if err := grpcStatus(resp.Trailer); err != nil {
+		return err.(*Error).Retryable(), err
+	}
+	if response, ok := protowire.Unframe(body); ok {
+		a.partialSuccess(response)
+	}
+	return false, nil
+}
+
+// partialSuccess logs the records the collector rejected of an accepted
+// request, which it doesn't tell apart
+func (a *Adapter) partialSuccess(response []byte) {
+	rejected, message, err := decodeResponse(response)
+	if err != nil {
+		debug("otlp:", err)
+		return
+	}
+	if rejected > 0 {
+		logger.Warn("rejected records", "route", a.route.ID, "records", rejected, "error", message)
+	}
+}
+
+// Error is a gRPC error status returned by a collector
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("otlp: collector returned status %d: %s", e.Code, e.Message)
+}
+
+// Category returns the delivery error category of the status
+func (e *Error) Category() string {
+	switch e.Code {
+	case codeUnavailable:
+		return router.ErrorConnect
+	case codeResourceExhausted:
+		return router.ErrorThrottle
+	case codeDeadlineExceeded:
+		return router.ErrorTimeout
+	case codeUnauthenticated, codePermissionDenied:
+		return router.ErrorAuth
+	case codeInvalidArgument:
+		return router.ErrorSerialization
+	}
+	return router.ErrorOther
+}
+
+// Retryable returns whether the request may be accepted if sent again, as
+// the OTLP specification lists
+func (e *Error) Retryable() bool {
+	switch e.Code {
+	case codeCancelled, codeDeadlineExceeded, codeResourceExhausted, codeAborted,
+		codeOutOfRange, codeUnavailable, codeDataLoss:
+		return true
+	}
+	return false
+}
+
+// grpcStatus returns the error in the grpc-status of headers or trailers
+func grpcStatus(header http.Header) error {
+	status, err := protowire.ParseStatus(header)
+	if err != nil {
+		return &Error{Code: codeUnavailable, Message: err.Error()}
+	}
+	if status == nil {
+		return nil
+	}
+	return &Error{Code: status.Code, Message: status.Message}
+}
@@ -0,0 +1,301 @@
+package otlp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/internal/protowire"
+	"github.com/gliderlabs/logspout/router"
+	_ "github.com/gliderlabs/logspout/transports/tcp"
+	_ "github.com/gliderlabs/logspout/transports/tls"
+	"golang.org/x/net/http2"
+)
+
+var app = &docker.Container{
+	ID:   "3b6ba57db54a",
+	Name: "/app_web_1",
+	Config: &docker.Config{Image: "registry:5000/acme/app:1.0", Labels: map[string]string{
+		"com.docker.compose.service": "web",
+		"team":                       "payments",
+	}},
+}
+
+// fakeCollector records the requests exported, answering the first
+// unavailable ones with 503 or UNAVAILABLE
+type fakeCollector struct {
+	sync.Mutex
+	unavailable int
+	rejected    int
+	calls       int
+	requests    [][]byte
+	headers     []http.Header
+}
+
+func (f *fakeCollector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	f.calls++
+	body, _ := ioutil.ReadAll(req.Body)
+	grpc := req.Header.Get("Content-Type") == "application/grpc"
+	if grpc {
+		w.Header().Set("Content-Type", "application/grpc")
+		if req.URL.Path != exportPath {
+			w.Header().Set("Grpc-Status", "12")
+			return
+		}
+	} else if req.URL.Path != defaultPath || req.Header.Get("Content-Type") != "application/x-protobuf" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if f.unavailable > 0 {
+		f.unavailable--
+		if grpc {
+			w.Header().Set("Grpc-Status", "14")
+			w.Header().Set("Grpc-Message", "collector%20restarting")
+		} else {
+			http.Error(w, "collector restarting", http.StatusServiceUnavailable)
+		}
+		return
+	}
+	if grpc {
+		body = body[protowire.FrameHeader:]
+	}
+	f.requests = append(f.requests, body)
+	f.headers = append(f.headers, req.Header)
+	var response []byte
+	if f.rejected > 0 {
+		partial := protowire.AppendVarint(nil, 1, uint64(f.rejected))
+		response = protowire.AppendBytes(nil, 1, protowire.AppendString(partial, 2, "too old"))
+	}
+	if !grpc {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(response)
+		return
+	}
+	w.Header().Set("Trailer", "Grpc-Status")
+	w.Write(protowire.Frame(response))
+	w.Header().Set("Grpc-Status", "0")
+}
+
+// fields returns the length delimited and varint fields of a protobuf
+// message by number, and fixed64 fields as their 8 bytes
+func fields(b []byte) map[uint64][][]byte {
+	fields := make(map[uint64][][]byte)
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			fields[key>>3] = append(fields[key>>3], []byte{byte(v)})
+			b = b[n:]
+		case 1:
+			fields[key>>3] = append(fields[key>>3], b[:8])
+			b = b[8:]
+		default:
+			size, n := binary.Uvarint(b)
+			fields[key>>3] = append(fields[key>>3], b[n:n+int(size)])
+			b = b[n+int(size):]
+		}
+	}
+	return fields
+}
+
+// attributes returns the string attributes of repeated KeyValue fields
+func attributes(kvs [][]byte) map[string]string {
+	attributes := make(map[string]string)
+	for _, kv := range kvs {
+		f := fields(kv)
+		value := fields(f[2][0])
+		if array, ok := value[5]; ok {
+			value = fields(fields(array[0])[1][0])
+		}
+		attributes[string(f[1][0])] = string(value[1][0])
+	}
+	return attributes
+}
+
+// serveGRPC serves collector over cleartext HTTP/2 and returns its address
+func serveGRPC(t *testing.T, collector http.Handler) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		server := new(http2.Server)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeConn(conn, &http2.ServeConnOpts{Handler: collector})
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func streamAll(t *testing.T, route *router.Route, messages ...*router.Message) {
+	adapter, err := NewOTLPAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logstream := make(chan *router.Message)
+	done := make(chan struct{})
+	go func() {
+		adapter.Stream(logstream)
+		close(done)
+	}()
+	for _, message := range messages {
+		logstream <- message
+	}
+	close(logstream)
+	<-done
+}
+
+func TestOTLPExportsHTTPProtobuf(t *testing.T) {
+	collector := new(fakeCollector)
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	other := &docker.Container{ID: "9c2a", Name: "/worker", Config: &docker.Config{Image: "worker"}}
+	streamAll(t, &router.Route{
+		ID:      "otlp",
+		Adapter: "otlp",
+		Address: strings.TrimPrefix(server.URL, "http://"),
+		Options: map[string]string{
+			"protocol":            "http/protobuf",
+			"headers":             "api-key=s3cr3t",
+			"labels":              "team",
+			"resource_attributes": "deployment.environment=prod",
+			"flush_interval":      "1h",
+		},
+	},
+		&router.Message{Container: app, Source: "stdout", Data: "one", Time: time.Unix(0, 42), Fields: map[string]string{
+			"level":    "WARN",
+			"user":     "jane",
+			"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736",
+			"span_id":  "00f067aa0ba902b7",
+		}},
+		&router.Message{Container: other, Source: "stderr", Data: "two", Time: time.Now()},
+		&router.Message{Container: app, Source: "stdout", Data: "three", Time: time.Now()},
+	)
+
+	collector.Lock()
+	defer collector.Unlock()
+	if len(collector.requests) != 1 {
+		t.Fatalf("expected one request got %v", len(collector.requests))
+	}
+	if collector.headers[0].Get("Api-Key") != "s3cr3t" {
+		t.Errorf("expected the headers option sent got %v", collector.headers[0])
+	}
+	resourceLogs := fields(collector.requests[0])[1]
+	if len(resourceLogs) != 2 {
+		t.Fatalf("expected records grouped by container got %v resources", len(resourceLogs))
+	}
+	resource := attributes(fields(fields(resourceLogs[0])[1][0])[1])
+	for key, value := range map[string]string{
+		"service.name":           "web",
+		"container.id":           "3b6ba57db54a",
+		"container.name":         "app_web_1",
+		"container.image.name":   "registry:5000/acme/app",
+		"container.image.tags":   "1.0",
+		"container.label.team":   "payments",
+		"deployment.environment": "prod",
+	} {
+		if resource[key] != value {
+			t.Errorf("expected resource attribute %s=%s got %q", key, value, resource[key])
+		}
+	}
+	scopeLogs := fields(fields(resourceLogs[0])[2][0])
+	if string(fields(scopeLogs[1][0])[1][0]) != "logspout" || len(scopeLogs[2]) != 2 {
+		t.Fatalf("expected two records of the logspout scope got %v", scopeLogs)
+	}
+	record := fields(scopeLogs[2][0])
+	if binary.LittleEndian.Uint64(record[1][0]) != 42 || record[2][0][0] != 13 || string(record[3][0]) != "WARN" {
+		t.Errorf("expected the time and severity of the message got %v", record)
+	}
+	if string(fields(record[5][0])[1][0]) != "one" {
+		t.Errorf("expected the body one got %q", record[5][0])
+	}
+	if hex.EncodeToString(record[9][0]) != "4bf92f3577b34da6a3ce929d0e0e4736" || hex.EncodeToString(record[10][0]) != "00f067aa0ba902b7" {
+		t.Errorf("expected the trace context of the message got %x %x", record[9], record[10])
+	}
+	attrs := attributes(record[6])
+	if attrs["log.iostream"] != "stdout" || attrs["user"] != "jane" || attrs["level"] != "" {
+		t.Errorf("unexpected record attributes %v", attrs)
+	}
+	if resource := attributes(fields(fields(resourceLogs[1])[1][0])[1]); resource["service.name"] != "worker" {
+		t.Errorf("expected the container name as the service got %v", resource)
+	}
+}
+
+func TestOTLPRetriesUnavailable(t *testing.T) {
+	for _, protocol := range []string{"grpc", "http/protobuf"} {
+		collector := &fakeCollector{unavailable: 2, rejected: 1}
+		addr, stop := serveGRPC(t, collector)
+		if protocol != "grpc" {
+			server := httptest.NewServer(collector)
+			addr, stop = strings.TrimPrefix(server.URL, "http://"), server.Close
+		}
+
+		streamAll(t, &router.Route{
+			Adapter: "otlp",
+			Address: addr,
+			Options: map[string]string{"protocol": protocol},
+		}, &router.Message{Container: app, Source: "stdout", Data: "retried", Time: time.Now()})
+		stop()
+
+		collector.Lock()
+		if collector.calls != 3 || len(collector.requests) != 1 {
+			t.Errorf("%s: expected the request retried twice got %v calls and %v requests",
+				protocol, collector.calls, len(collector.requests))
+		}
+		collector.Unlock()
+	}
+}
+
+func TestOTLPStatus(t *testing.T) {
+	err := grpcStatus(http.Header{"Grpc-Status": {"3"}, "Grpc-Message": {"bad%20request"}})
+	if statusErr, ok := err.(*Error); !ok || statusErr.Retryable() || statusErr.Message != "bad request" ||
+		router.ErrorCategory(err) != router.ErrorSerialization {
+		t.Errorf("expected a serialization error got %v", err)
+	}
+	if err := grpcStatus(http.Header{"Grpc-Status": {"15"}}); err == nil || !err.(*Error).Retryable() {
+		t.Errorf("expected DATA_LOSS retried got %v", err)
+	}
+	rejected, message, err := decodeResponse(protowire.AppendBytes(nil, 1, protowire.AppendString(protowire.AppendVarint(nil, 1, 3), 2, "too old")))
+	if rejected != 3 || message != "too old" || err != nil {
+		t.Errorf("expected 3 records rejected got %v %q %v", rejected, message, err)
+	}
+}
+
+func TestOTLPOptions(t *testing.T) {
+	for address, options := range map[string]map[string]string{
+		"localhost/v1/logs": {},
+		"localhost:4318":    {"protocol": "http/json"},
+		"localhost:4317":    {"batch_size": "0"},
+		"":                  {"protocol": "http/protobuf"},
+		"localhost":         {"headers": "nokey"},
+	} {
+		if _, err := NewOTLPAdapter(&router.Route{Adapter: "otlp", Address: address, Options: options}); err == nil {
+			t.Errorf("expected an error for %s with %v", address, options)
+		}
+	}
+	adapter, err := NewOTLPAdapter(&router.Route{Adapter: "otlp+tls", Address: "collector/otlp/logs",
+		Options: map[string]string{"protocol": "http/protobuf"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url := adapter.(*Adapter).url; url != "https://collector:4318/otlp/logs" {
+		t.Errorf("expected the default port and the path of the address got %s", url)
+	}
+}
@@ -0,0 +1,177 @@
+package otlp
+
+import (
+	"encoding/hex"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/gliderlabs/logspout/internal/protowire"
+)
+
+// severities are the SeverityNumbers of level names, the first of the
+// range of each severity
+var severities = map[string]uint64{
+	"trace": 1, "debug": 5,
+	"info": 9, "information": 9, "notice": 10,
+	"warn": 13, "warning": 13,
+	"error": 17, "err": 17,
+	"critical": 21, "crit": 21, "fatal": 21, "panic": 21, "alert": 22, "emerg": 23,
+}
+
+// keyValue is an attribute with a string value, or string array values
+type keyValue struct {
+	key    string
+	value  string
+	values []string
+}
+
+// logRecord is the fields of a LogRecord:
+//
+//	message LogRecord {
+//		fixed64 time_unix_nano = 1;
+//		SeverityNumber severity_number = 2;
+//		string severity_text = 3;
+//		AnyValue body = 5;
+//		repeated KeyValue attributes = 6;
+//		bytes trace_id = 9;
+//		bytes span_id = 10;
+//		fixed64 observed_time_unix_nano = 11;
+//	}
+type logRecord struct {
+	time         int64
+	observed     int64
+	severityText string
+	body         string
+	attributes   []keyValue
+	traceID      string
+	spanID       string
+}
+
+// encode returns the record as a LogRecord
+func (r *logRecord) encode() []byte {
+	var b []byte
+	if r.time != 0 {
+		b = protowire.AppendFixed64(b, 1, uint64(r.time))
+	}
+	if severity, ok := severities[strings.ToLower(r.severityText)]; ok {
+		b = protowire.AppendVarint(b, 2, severity)
+	}
+	b = protowire.AppendString(b, 3, r.severityText)
+	b = protowire.AppendBytes(b, 5, stringValue(r.body))
+	for _, kv := range r.attributes {
+		b = protowire.AppendBytes(b, 6, kv.encode())
+	}
+	// IDs of other lengths aren't valid trace context
+	if id, err := hex.DecodeString(r.traceID); err == nil && len(id) == 16 {
+		b = protowire.AppendBytes(b, 9, id)
+		if id, err := hex.DecodeString(r.spanID); err == nil && len(id) == 8 {
+			b = protowire.AppendBytes(b, 10, id)
+		}
+	}
+	return protowire.AppendFixed64(b, 11, uint64(r.observed))
+}
+
+// encode returns the attribute as a KeyValue:
+//
+//	message KeyValue { string key = 1; AnyValue value = 2; }
+//	message AnyValue {
+//		oneof value { string string_value = 1; ArrayValue array_value = 5; }
+//	}
+//	message ArrayValue { repeated AnyValue values = 1; }
+func (kv keyValue) encode() []byte {
+	b := protowire.AppendString(nil, 1, kv.key)
+	if kv.values == nil {
+		return protowire.AppendBytes(b, 2, stringValue(kv.value))
+	}
+	var array []byte
+	for _, value := range kv.values {
+		array = protowire.AppendBytes(array, 1, stringValue(value))
+	}
+	return protowire.AppendBytes(b, 2, protowire.AppendBytes(nil, 5, array))
+}
+
+// stringValue returns s as an AnyValue. Fields of a oneof are set even if
+// they are empty.
+func stringValue(s string) []byte {
+	return protowire.AppendBytes(nil, 1, []byte(s))
+}
+
+// encodeRequest returns an ExportLogsServiceRequest of the records of each
+// resource, in the order of resources:
+//
+//	message ExportLogsServiceRequest { repeated ResourceLogs resource_logs = 1; }
+//	message ResourceLogs { Resource resource = 1; repeated ScopeLogs scope_logs = 2; }
+//	message Resource { repeated KeyValue attributes = 1; }
+//	message ScopeLogs { InstrumentationScope scope = 1; repeated LogRecord log_records = 2; }
+//	message InstrumentationScope { string name = 1; string version = 2; }
+func encodeRequest(resources [][]keyValue, records [][][]byte, scope []byte) []byte {
+	var b []byte
+	for i, attributes := range resources {
+		var resource []byte
+		for _, kv := range attributes {
+			resource = protowire.AppendBytes(resource, 1, kv.encode())
+		}
+		scopeLogs := protowire.AppendBytes(nil, 1, scope)
+		for _, record := range records[i] {
+			scopeLogs = protowire.AppendBytes(scopeLogs, 2, record)
+		}
+		resourceLogs := protowire.AppendBytes(nil, 1, resource)
+		resourceLogs = protowire.AppendBytes(resourceLogs, 2, scopeLogs)
+		b = protowire.AppendBytes(b, 1, resourceLogs)
+	}
+	return b
+}
+
+// decodeResponse returns the records the collector rejected, and why, from
+// the partial success of an ExportLogsServiceResponse:
+//
+//	message ExportLogsServiceResponse { ExportLogsPartialSuccess partial_success = 1; }
+//	message ExportLogsPartialSuccess {
+//		int64 rejected_log_records = 1;
+//		string error_message = 2;
+//	}
+func decodeResponse(b []byte) (rejected int64, message string, err error) {
+	var partial []byte
+	if err = protowire.Walk(b, func(field int, varint uint64, bytes []byte) {
+		if field == 1 && bytes != nil {
+			partial = bytes
+		}
+	}); err != nil {
+		return 0, "", err
+	}
+	err = protowire.Walk(partial, func(field int, varint uint64, bytes []byte) {
+		switch field {
+		case 1:
+			if varint <= math.MaxInt64 {
+				rejected = int64(varint)
+			}
+		case 2:
+			message = string(bytes)
+		}
+	})
+	return rejected, message, err
+}
+
+// statusMessage returns the message of a google.rpc.Status:
+//
+//	message Status { int32 code = 1; string message = 2; }
+func statusMessage(b []byte) string {
+	var message string
+	protowire.Walk(b, func(field int, varint uint64, bytes []byte) {
+		if field == 2 {
+			message = string(bytes)
+		}
+	})
+	return message
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
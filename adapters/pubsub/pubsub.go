@@ -0,0 +1,273 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gliderlabs/logspout/internal/gcp"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	// publish limits, see https://cloud.google.com/pubsub/quotas#resource_limits
+	maxBatchMessages = 1000
+	maxBatchBytes    = 9 * 1024 * 1024
+	maxAttributes    = 100
+	maxAttributeKey  = 256
+	maxAttributeVal  = 1024
+
+	defaultEndpoint   = "https://pubsub.googleapis.com"
+	defaultRetryCount = 10
+	scope             = "https://www.googleapis.com/auth/pubsub"
+)
+
+func init() {
+	router.AdapterFactories.Register(NewPubSubAdapter, "pubsub")
+	router.Capabilities.DescribeAdapter("pubsub", []string{
+		"endpoint", "batch_size", "flush_interval", "ordering_key", "credentials",
+		"batch_adaptive", "batch_min_size", "batch_target_latency", "json_schema",
+	}, nil)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+var logger = router.NewLogger("pubsub")
+
+func debug(v ...interface{}) {
+	logger.Debugln(v...)
+}
+
+// NewPubSubAdapter returns a configured pubsub.Adapter for a route address
+// of the form project/topic
+func NewPubSubAdapter(route *router.Route) (router.LogAdapter, error) {
+	parts := strings.Split(route.Address, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.New("pubsub: address must be project/topic: " + route.Address)
+	}
+	endpoint := strings.TrimSuffix(router.RouteOpt(route, "endpoint", "PUBSUB_ENDPOINT", defaultEndpoint), "/")
+
+	batchStr := router.RouteOpt(route, "batch_size", "PUBSUB_BATCH_SIZE", "100")
+	batchSize, err := strconv.Atoi(batchStr)
+	if err != nil || batchSize < 1 || batchSize > maxBatchMessages {
+		return nil, errors.New("pubsub: invalid value for batch_size: " + batchStr)
+	}
+	flushStr := router.RouteOpt(route, "flush_interval", "PUBSUB_FLUSH_INTERVAL", "1s")
+	flushInterval, err := time.ParseDuration(flushStr)
+	if err != nil || flushInterval <= 0 {
+		return nil, errors.New("pubsub: invalid value for flush_interval: " + flushStr)
+	}
+	var orderingKey *template.Template
+	if keyStr := router.RouteOpt(route, "ordering_key", "PUBSUB_ORDERING_KEY", ""); keyStr != "" {
+		if orderingKey, err = template.New("ordering_key").Parse(keyStr); err != nil {
+			return nil, errors.New("pubsub: invalid value for ordering_key: " + err.Error())
+		}
+	}
+
+	batching, err := router.NewBatchSizer(route, batchSize)
+	if err != nil {
+		return nil, errors.New("pubsub: " + err.Error())
+	}
+	schema, err := router.NewRouteSchema(route)
+	if err != nil {
+		return nil, errors.New("pubsub: " + err.Error())
+	}
+
+	retryCount, err := strconv.Atoi(getopt("RETRY_COUNT", strconv.Itoa(defaultRetryCount)))
+	if err != nil {
+		retryCount = defaultRetryCount
+	}
+
+	return &Adapter{
+		route:         route,
+		client:        gcp.NewClient(scope, route.Options["credentials"]),
+		url:           endpoint + "/v1/projects/" + parts[0] + "/topics/" + parts[1] + ":publish",
+		orderingKey:   orderingKey,
+		batching:      batching,
+		schema:        schema,
+		flushInterval: flushInterval,
+		retryCount:    retryCount,
+	}, nil
+}
+
+// Adapter publishes log output to a Google Cloud Pub/Sub topic
+type Adapter struct {
+	route         *router.Route
+	client        *gcp.Client
+	url           string
+	orderingKey   *template.Template
+	batching      *router.BatchSizer
+	schema        *router.RouteSchema
+	flushInterval time.Duration
+	retryCount    int
+	batch         []pubsubMessage
+	batched       []*router.Message
+	bytes         int
+}
+
+type pubsubMessage struct {
+	Data        string            `json:"data"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	OrderingKey string            `json:"orderingKey,omitempty"`
+}
+
+type publishRequest struct {
+	Messages []pubsubMessage `json:"messages"`
+}
+
+// Message extends router.Message with fields for ordering key templates
+type Message struct {
+	*router.Message
+}
+
+// ContainerName returns the message's container name
+func (m *Message) ContainerName() string {
+	return strings.TrimPrefix(m.Message.Container.Name, "/")
+}
+
+// ContainerID returns the message's short container id
+func (m *Message) ContainerID() string {
+	id := m.Message.Container.ID
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// Stream publishes log data to the topic in batches
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	defer a.flush()
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				return
+			}
+			if !a.schema.Valid(message, []byte(message.Data)) {
+				continue
+			}
+			m, err := a.newMessage(message)
+			if err != nil {
+				router.LogDeliveryError("pubsub", err)
+				router.Receipts.Report(a.route, message, err)
+				continue
+			}
+			size := messageSize(m)
+			if len(a.batch) > 0 && a.bytes+size > maxBatchBytes {
+				a.flush()
+			}
+			a.batch = append(a.batch, m)
+			a.batched = append(a.batched, message)
+			a.bytes += size
+			if len(a.batch) >= a.batching.Size() || message.Exiting {
+				a.flush()
+			}
+		case <-ticker.C:
+			a.flush()
+		}
+	}
+}
+
+// newMessage returns the Pub/Sub message for message, with the container
+// metadata and message fields as attributes
+func (a *Adapter) newMessage(message *router.Message) (pubsubMessage, error) {
+	m := &Message{message}
+	attributes := make(map[string]string)
+	for key, value := range message.Fields {
+		if len(attributes) >= maxAttributes-5 {
+			break
+		}
+		attribute(attributes, key, value)
+	}
+	attribute(attributes, "container_id", m.ContainerID())
+	attribute(attributes, "container_name", m.ContainerName())
+	attribute(attributes, "source", message.Source)
+	if message.Container.Config != nil {
+		attribute(attributes, "image", message.Container.Config.Image)
+		attribute(attributes, "hostname", message.Container.Config.Hostname)
+	}
+	pm := pubsubMessage{
+		Data:       base64.StdEncoding.EncodeToString([]byte(message.Data)),
+		Attributes: attributes,
+	}
+	if a.orderingKey != nil {
+		key := new(bytes.Buffer)
+		if err := a.orderingKey.Execute(key, m); err != nil {
+			return pm, err
+		}
+		pm.OrderingKey = key.String()
+	}
+	return pm, nil
+}
+
+// attribute sets a non-empty attribute, truncated to the Pub/Sub limits
+func attribute(attributes map[string]string, key, value string) {
+	if key == "" || value == "" {
+		return
+	}
+	if len(key) > maxAttributeKey {
+		key = key[:maxAttributeKey]
+	}
+	if len(value) > maxAttributeVal {
+		value = value[:maxAttributeVal]
+	}
+	attributes[key] = value
+}
+
+// messageSize approximates the bytes a message adds to a publish request
+func messageSize(m pubsubMessage) int {
+	size := len(m.Data) + len(m.OrderingKey) + 64
+	for key, value := range m.Attributes {
+		size += len(key) + len(value) + 8
+	}
+	return size
+}
+
+func (a *Adapter) flush() {
+	if len(a.batch) == 0 {
+		return
+	}
+	start := time.Now()
+	err := a.publish(a.batch)
+	a.batching.Observe(len(a.batch), time.Since(start), err)
+	if err != nil {
+		logger.Error("dropping messages", "messages", len(a.batch), "category", router.ErrorCategory(err), "error", err)
+	}
+	for _, message := range a.batched {
+		router.Receipts.Report(a.route, message, err)
+	}
+	a.batch, a.batched, a.bytes = nil, nil, 0
+}
+
+func (a *Adapter) publish(messages []pubsubMessage) error {
+	defer router.ObserveWrite(a.route, time.Now())
+	for try := 0; ; try++ {
+		err := a.client.Post(a.url, &publishRequest{Messages: messages}, nil)
+		if err == nil {
+			return nil
+		}
+		if apiErr, ok := err.(*gcp.Error); ok && !apiErr.Retryable() {
+			return err
+		}
+		if try >= a.retryCount {
+			return err
+		}
+		// throttling, server errors and network errors
+		delay := router.RetryDelay(try)
+		debug("pubsub: retrying in", delay, "after:", err)
+		router.WaitRetry(a.route, delay, a.batched...)
+	}
+}
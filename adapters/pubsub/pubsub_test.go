@@ -0,0 +1,166 @@
+package pubsub
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+var container = &docker.Container{
+	ID:     "8dfafdbc3a40b1b0bd3a0f5c",
+	Name:   "/container",
+	Config: &docker.Config{Image: "app:1.0", Hostname: "8dfafdbc3a40"},
+}
+
+type fakePubSub struct {
+	sync.Mutex
+	paths    []string
+	auth     []string
+	messages []pubsubMessage
+}
+
+func (f *fakePubSub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	f.paths = append(f.paths, req.URL.Path)
+	if req.URL.Path == "/token" {
+		req.ParseForm()
+		if req.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || req.Form.Get("assertion") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"access_token": "ya29.token", "expires_in": 3600, "token_type": "Bearer"}`))
+		return
+	}
+	f.auth = append(f.auth, req.Header.Get("Authorization"))
+	in := new(publishRequest)
+	json.NewDecoder(req.Body).Decode(in)
+	f.messages = append(f.messages, in.Messages...)
+	w.Write([]byte(`{"messageIds": ["1"]}`))
+}
+
+func writeServiceAccount(t *testing.T, dir, tokenURI string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "logspout@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    tokenURI,
+	})
+	path := filepath.Join(dir, "key.json")
+	if err := ioutil.WriteFile(path, creds, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPubSubPublishesBatches(t *testing.T) {
+	fake := new(fakePubSub)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "pubsub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	route := &router.Route{
+		Adapter: "pubsub",
+		Address: "project/logs",
+		Options: map[string]string{
+			"endpoint":       server.URL,
+			"credentials":    writeServiceAccount(t, dir, server.URL+"/token"),
+			"batch_size":     "2",
+			"flush_interval": "1h",
+			"ordering_key":   "{{.ContainerName}}",
+		},
+	}
+	adapter, err := NewPubSubAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logstream := make(chan *router.Message)
+	done := make(chan struct{})
+	go func() {
+		adapter.Stream(logstream)
+		close(done)
+	}()
+	for _, data := range []string{"one", "two", "three"} {
+		logstream <- &router.Message{
+			Container: container,
+			Source:    "stdout",
+			Data:      data,
+			Time:      time.Now(),
+			Fields:    map[string]string{"level": "info"},
+		}
+	}
+	close(logstream)
+	<-done
+
+	fake.Lock()
+	defer fake.Unlock()
+	expectedPaths := []string{"/token", "/v1/projects/project/topics/logs:publish", "/v1/projects/project/topics/logs:publish"}
+	if len(fake.paths) != len(expectedPaths) {
+		t.Fatalf("expected requests %v got %v", expectedPaths, fake.paths)
+	}
+	for i, path := range expectedPaths {
+		if fake.paths[i] != path {
+			t.Errorf("expected request %v to %s got %s", i, path, fake.paths[i])
+		}
+	}
+	for _, auth := range fake.auth {
+		if auth != "Bearer ya29.token" {
+			t.Errorf("expected bearer token got %q", auth)
+		}
+	}
+	if len(fake.messages) != 3 {
+		t.Fatalf("expected 3 messages got %v", len(fake.messages))
+	}
+	m := fake.messages[2]
+	data, _ := base64.StdEncoding.DecodeString(m.Data)
+	if string(data) != "three" || m.OrderingKey != "container" {
+		t.Errorf("expected data three with ordering key container got %q %q", data, m.OrderingKey)
+	}
+	expected := map[string]string{
+		"container_id":   "8dfafdbc3a40",
+		"container_name": "container",
+		"source":         "stdout",
+		"image":          "app:1.0",
+		"hostname":       "8dfafdbc3a40",
+		"level":          "info",
+	}
+	for key, value := range expected {
+		if m.Attributes[key] != value {
+			t.Errorf("expected attribute %s=%s got %q", key, value, m.Attributes[key])
+		}
+	}
+}
+
+func TestPubSubInvalidAddress(t *testing.T) {
+	for _, address := range []string{"project", "project/", "/topic", "a/b/c"} {
+		if _, err := NewPubSubAdapter(&router.Route{Address: address, Options: map[string]string{}}); err == nil {
+			t.Errorf("expected error for address %s", address)
+		}
+	}
+}
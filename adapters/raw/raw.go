@@ -1,27 +1,25 @@
 package raw
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
-	"log"
 	"net"
-	"os"
-	"reflect"
 	"text/template"
+	"time"
 
 	"github.com/gliderlabs/logspout/router"
 )
 
 func init() {
 	router.AdapterFactories.Register(NewRawAdapter, "raw")
+	router.Capabilities.DescribeAdapter("raw", []string{"json_schema", "envelope"}, funcs)
 }
 
 var funcs = template.FuncMap{
 	"toJSON": func(value interface{}) string {
 		bytes, err := json.Marshal(value)
 		if err != nil {
-			log.Println("error marshalling to JSON: ", err)
+			router.NewLogger("raw").Error("marshalling to JSON failed", "error", err)
 			return "null"
 		}
 		return string(bytes)
@@ -34,48 +32,94 @@ func NewRawAdapter(route *router.Route) (router.LogAdapter, error) {
 	if !found {
 		return nil, errors.New("bad transport: " + route.Adapter)
 	}
-	conn, err := transport.Dial(route.Address, route.Options)
+	conn, err := router.Dial(transport, route.Address, route.Options)
 	if err != nil {
 		return nil, err
 	}
-	tmplStr := "{{.Data}}\n"
-	if os.Getenv("RAW_FORMAT") != "" {
-		tmplStr = os.Getenv("RAW_FORMAT")
+	// compressed streams would be cut into undecodable datagrams
+	if route.Options["compress"] != "" && router.Datagram(conn) {
+		conn.Close()
+		return nil, errors.New("raw: compress needs a stream transport like tcp, tls or unix, e.g. raw+tcp://")
 	}
-	tmpl, err := template.New("raw").Funcs(funcs).Parse(tmplStr)
+	tmpl, err := NewTemplate(route)
 	if err != nil {
 		return nil, err
 	}
+	envelope, err := router.NewEnvelope(route)
+	if err != nil {
+		return nil, errors.New("raw: " + err.Error())
+	}
+	schema, err := router.NewRouteSchema(route)
+	if err != nil {
+		return nil, errors.New("raw: " + err.Error())
+	}
 	return &Adapter{
-		route: route,
-		conn:  conn,
-		tmpl:  tmpl,
+		route:    route,
+		conn:     conn,
+		tmpl:     tmpl,
+		envelope: envelope,
+		schema:   schema,
 	}, nil
 }
 
+// NewTemplate returns the raw template configured for a route
+func NewTemplate(route *router.Route) (*template.Template, error) {
+	tmplStr := router.RouteOpt(route, "", "RAW_FORMAT", "{{.Data}}\n")
+	override, err := route.TemplateOverride()
+	if err != nil {
+		return nil, err
+	}
+	if override != "" {
+		tmplStr = override
+	}
+	return ParseTemplate(tmplStr)
+}
+
+// ParseTemplate parses a raw template with the raw template functions
+func ParseTemplate(tmplStr string) (*template.Template, error) {
+	return template.New("raw").Funcs(funcs).Parse(tmplStr)
+}
+
 // Adapter is a simple adapter that streams log output to a connection without any templating
 type Adapter struct {
-	conn  net.Conn
-	route *router.Route
-	tmpl  *template.Template
+	conn     net.Conn
+	route    *router.Route
+	tmpl     *template.Template
+	envelope *router.Envelope
+	schema   *router.RouteSchema
 }
 
 // Stream sends log data to a connection
 func (a *Adapter) Stream(logstream chan *router.Message) {
 	for message := range logstream {
-		buf := new(bytes.Buffer)
-		err := a.tmpl.Execute(buf, message)
+		buf, err := router.Render(a.tmpl, message)
+		if err == nil {
+			buf, err = a.envelope.Wrap(message, buf)
+		}
 		if err != nil {
-			log.Println("raw:", err)
+			router.LogDeliveryError("raw", err)
 			return
 		}
 		//log.Println("debug:", buf.String())
+		if !a.schema.Valid(message, buf.Bytes()) {
+			router.PutBuffer(buf)
+			continue
+		}
+		start := time.Now()
 		_, err = a.conn.Write(buf.Bytes())
+		router.ObserveWrite(a.route, start)
+		router.Receipts.ReportSize(a.route, message, buf.Len(), err)
+		router.PutBuffer(buf)
 		if err != nil {
-			log.Println("raw:", err)
-			if reflect.TypeOf(a.conn).String() != "*net.UDPConn" {
+			router.LogDeliveryError("raw", err)
+			if !router.Datagram(a.conn) {
 				return
 			}
 		}
 	}
 }
+
+// Close closes the adapter's connection
+func (a *Adapter) Close() error {
+	return a.conn.Close()
+}
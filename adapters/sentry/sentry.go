@@ -0,0 +1,310 @@
+package sentry
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultPattern    = `(?i)\b(error|fatal|panic|exception|traceback)\b`
+	defaultRetryAfter = 60 * time.Second
+	maxMessageLength  = 8192
+	maxSignature      = 200
+)
+
+var (
+	// levels orders the Sentry levels, with the common aliases used in logs
+	levels = map[string]int{
+		"debug": 0, "trace": 0,
+		"info": 1, "notice": 1,
+		"warning": 2, "warn": 2,
+		"error": 3, "err": 3,
+		"fatal": 4, "critical": 4, "crit": 4, "panic": 4, "alert": 4, "emerg": 4,
+	}
+	levelNames = []string{"debug", "info", "warning", "error", "fatal"}
+
+	exceptionType = regexp.MustCompile(`(?:^|\s)((?:[A-Za-z_$][\w$]*\.)*[A-Za-z_$][\w$]*(?:Error|Exception))(?::\s*(.*)|\s|$)`)
+	goPanic       = regexp.MustCompile(`(?m)^panic: (.*)`)
+	variable      = regexp.MustCompile(`0x[0-9a-fA-F]+|[0-9a-fA-F]{8,}|\d+`)
+)
+
+func init() {
+	router.AdapterFactories.Register(NewSentryAdapter, "sentry")
+	router.Capabilities.DescribeAdapter("sentry", []string{
+		"dsn", "key", "pattern", "level", "level_field", "environment", "release",
+	}, nil)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+// NewSentryAdapter returns a configured sentry.Adapter for the DSN in the
+// dsn option, or a route address of the form host/project with the public
+// key in the key option
+func NewSentryAdapter(route *router.Route) (router.LogAdapter, error) {
+	dsn := router.RouteOpt(route, "dsn", "SENTRY_DSN", "")
+	if dsn == "" {
+		key := router.RouteOpt(route, "key", "SENTRY_KEY", "")
+		if route.Address == "" || key == "" {
+			return nil, errors.New("sentry: dsn or an address and key are required")
+		}
+		dsn = "https://" + key + "@" + route.Address
+	}
+	storeURL, key, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	patternStr := router.RouteOpt(route, "pattern", "SENTRY_PATTERN", defaultPattern)
+	pattern, err := regexp.Compile(patternStr)
+	if err != nil {
+		return nil, errors.New("sentry: invalid value for pattern (must be regexp): " + patternStr)
+	}
+	levelStr := router.RouteOpt(route, "level", "SENTRY_LEVEL", "error")
+	level, ok := levels[strings.ToLower(levelStr)]
+	if !ok {
+		return nil, errors.New("sentry: invalid value for level: " + levelStr)
+	}
+	return &Adapter{
+		route:       route,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		url:         storeURL,
+		auth:        "Sentry sentry_version=7, sentry_client=logspout/1.0, sentry_key=" + key,
+		pattern:     pattern,
+		level:       level,
+		levelField:  router.RouteOpt(route, "level_field", "SENTRY_LEVEL_FIELD", "level"),
+		environment: router.RouteOpt(route, "environment", "SENTRY_ENVIRONMENT", ""),
+		release:     router.RouteOpt(route, "release", "SENTRY_RELEASE", ""),
+	}, nil
+}
+
+// parseDSN returns the store endpoint and public key of a Sentry DSN like
+// https://<key>@o1.ingest.sentry.io/<project>
+func parseDSN(dsn string) (string, string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return "", "", errors.New("sentry: invalid value for dsn: " + dsn)
+	}
+	path := strings.Trim(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	project := path[i+1:]
+	if _, err := strconv.Atoi(project); err != nil {
+		return "", "", errors.New("sentry: invalid value for dsn (must end with the project id): " + dsn)
+	}
+	prefix := ""
+	if i >= 0 {
+		prefix = "/" + path[:i]
+	}
+	return u.Scheme + "://" + u.Host + prefix + "/api/" + project + "/store/", u.User.Username(), nil
+}
+
+// Adapter sends error level log lines to Sentry as events
+type Adapter struct {
+	route       *router.Route
+	client      *http.Client
+	url         string
+	auth        string
+	pattern     *regexp.Regexp
+	level       int
+	levelField  string
+	environment string
+	release     string
+	limited     time.Time
+}
+
+type event struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Logger      string            `json:"logger"`
+	Platform    string            `json:"platform"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Message     eventMessage      `json:"message"`
+	Exception   *eventExceptions  `json:"exception,omitempty"`
+	Fingerprint []string          `json:"fingerprint"`
+	Tags        map[string]string `json:"tags"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+type eventMessage struct {
+	Formatted string `json:"formatted"`
+}
+
+type eventExceptions struct {
+	Values []eventException `json:"values"`
+}
+
+type eventException struct {
+	Type  string `json:"type"`
+	Value string `json:"value,omitempty"`
+}
+
+// Stream sends an event for each message at or above the level, or, for
+// messages without a level field, matching the pattern
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	for message := range logstream {
+		level, ok := a.selected(message)
+		if !ok {
+			continue
+		}
+		err := a.send(a.newEvent(message, level))
+		if err != nil {
+			router.LogDeliveryError("sentry", err)
+		}
+		router.Receipts.Report(a.route, message, err)
+	}
+}
+
+// selected returns the event level of message and whether it is sent
+func (a *Adapter) selected(message *router.Message) (int, bool) {
+	if value, ok := message.Fields[a.levelField]; ok {
+		if level, ok := levels[strings.ToLower(value)]; ok {
+			return level, level >= a.level
+		}
+	}
+	if a.pattern.MatchString(message.Data) {
+		// unleveled lines matching the pattern are errors, or the
+		// minimum level when that is higher
+		if a.level > levels["error"] {
+			return a.level, true
+		}
+		return levels["error"], true
+	}
+	return 0, false
+}
+
+// newEvent returns the Sentry event for message, grouped by container and
+// exception signature
+func (a *Adapter) newEvent(message *router.Message, level int) *event {
+	data := message.Data
+	if len(data) > maxMessageLength {
+		data = data[:maxMessageLength]
+	}
+	name := strings.TrimPrefix(message.Container.Name, "/")
+	e := &event{
+		EventID:     eventID(),
+		Timestamp:   message.Time.UTC().Format(time.RFC3339Nano),
+		Level:       levelNames[level],
+		Logger:      name,
+		Platform:    "other",
+		Environment: a.environment,
+		Release:     a.release,
+		Message:     eventMessage{data},
+		Tags: map[string]string{
+			"container_name": name,
+			"container_id":   shortID(message.Container.ID),
+			"source":         message.Source,
+		},
+	}
+	if config := message.Container.Config; config != nil {
+		e.ServerName = config.Hostname
+		e.Tags["image"] = config.Image
+	}
+	if len(message.Fields) > 0 {
+		e.Extra = message.Fields
+	}
+	typ, value, signature := exception(data)
+	if typ != "" {
+		e.Exception = &eventExceptions{[]eventException{{typ, value}}}
+	}
+	e.Fingerprint = []string{name, signature}
+	return e
+}
+
+// exception returns the exception type and value found in data, and the
+// signature grouping it with the same error on other lines, which ignores
+// numbers, addresses and ids
+func exception(data string) (string, string, string) {
+	if m := goPanic.FindStringSubmatch(data); m != nil {
+		return "panic", m[1], "panic: " + normalize(m[1])
+	}
+	if m := exceptionType.FindStringSubmatch(data); m != nil {
+		return m[1], strings.TrimSpace(firstLine(m[2])), m[1]
+	}
+	return "", "", normalize(firstLine(data))
+}
+
+func normalize(s string) string {
+	s = variable.ReplaceAllString(s, "<n>")
+	if len(s) > maxSignature {
+		s = s[:maxSignature]
+	}
+	return s
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+func eventID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// send posts e to Sentry. Events are dropped while Sentry rate limits the
+// project.
+func (a *Adapter) send(e *event) error {
+	if time.Now().Before(a.limited) {
+		return router.NewDeliveryError(router.ErrorThrottle, errors.New("rate limited until "+a.limited.Format(time.RFC3339)))
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", a.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", a.auth)
+	defer router.ObserveWrite(a.route, time.Now())
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter := defaultRetryAfter
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		a.limited = time.Now().Add(retryAfter)
+	}
+	if resp.StatusCode >= 300 {
+		return router.NewDeliveryError(router.HTTPErrorCategory(resp.StatusCode), fmt.Errorf("%s returned %s", a.url, resp.Status))
+	}
+	return nil
+}
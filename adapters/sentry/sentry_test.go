@@ -0,0 +1,174 @@
+package sentry
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+var container = &docker.Container{
+	ID:     "8dfafdbc3a40b1b0bd3a0f5c",
+	Name:   "/api",
+	Config: &docker.Config{Image: "api:1.0", Hostname: "8dfafdbc3a40"},
+}
+
+type fakeSentry struct {
+	sync.Mutex
+	status int
+	paths  []string
+	auth   []string
+	events []*event
+}
+
+func (f *fakeSentry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	f.paths = append(f.paths, req.URL.Path)
+	f.auth = append(f.auth, req.Header.Get("X-Sentry-Auth"))
+	if f.status != 0 {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(f.status)
+		return
+	}
+	e := new(event)
+	json.NewDecoder(req.Body).Decode(e)
+	f.events = append(f.events, e)
+	w.Write([]byte(`{"id": "` + e.EventID + `"}`))
+}
+
+func stream(t *testing.T, options map[string]string, messages ...*router.Message) *fakeSentry {
+	fake := new(fakeSentry)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	if options == nil {
+		options = make(map[string]string)
+	}
+	options["dsn"] = "http://public@" + server.Listener.Addr().String() + "/42"
+	adapter, err := NewSentryAdapter(&router.Route{Adapter: "sentry", Options: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logstream := make(chan *router.Message, len(messages))
+	for _, message := range messages {
+		message.Container = container
+		message.Time = time.Now()
+		logstream <- message
+	}
+	close(logstream)
+	adapter.Stream(logstream)
+	return fake
+}
+
+func TestSentrySendsErrors(t *testing.T) {
+	fake := stream(t, nil,
+		&router.Message{Data: "GET /health 200", Source: "stdout"},
+		&router.Message{Data: "ERROR: connection to 10.0.0.3 refused", Source: "stderr"},
+		&router.Message{Data: `{"level":"info","msg":"no errors"}`, Fields: map[string]string{"level": "info"}},
+		&router.Message{Data: "request failed", Fields: map[string]string{"level": "fatal"}},
+	)
+	if len(fake.events) != 2 {
+		t.Fatalf("expected 2 events got %v", len(fake.events))
+	}
+	if fake.paths[0] != "/api/42/store/" {
+		t.Errorf("unexpected path: %s", fake.paths[0])
+	}
+	if fake.auth[0] != "Sentry sentry_version=7, sentry_client=logspout/1.0, sentry_key=public" {
+		t.Errorf("unexpected auth: %s", fake.auth[0])
+	}
+	e := fake.events[0]
+	if e.Level != "error" || e.Message.Formatted != "ERROR: connection to 10.0.0.3 refused" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	if e.Tags["container_name"] != "api" || e.Tags["image"] != "api:1.0" || e.ServerName != "8dfafdbc3a40" {
+		t.Errorf("unexpected tags: %v", e.Tags)
+	}
+	if e.Fingerprint[0] != "api" || e.Fingerprint[1] != "ERROR: connection to <n>.<n>.<n>.<n> refused" {
+		t.Errorf("unexpected fingerprint: %v", e.Fingerprint)
+	}
+	if fake.events[1].Level != "fatal" {
+		t.Errorf("expected a fatal event got %s", fake.events[1].Level)
+	}
+}
+
+func TestSentryLevel(t *testing.T) {
+	fake := stream(t, map[string]string{"level": "warning", "level_field": "severity"},
+		&router.Message{Data: "disk almost full", Fields: map[string]string{"severity": "WARN"}},
+		&router.Message{Data: "started", Fields: map[string]string{"severity": "info"}},
+	)
+	if len(fake.events) != 1 || fake.events[0].Level != "warning" {
+		t.Fatalf("expected a warning event got %+v", fake.events)
+	}
+}
+
+func TestSentryRateLimit(t *testing.T) {
+	fake := stream(t, nil,
+		&router.Message{Data: "panic: runtime error"},
+		&router.Message{Data: "panic: runtime error"},
+	)
+	if len(fake.events) != 2 {
+		t.Fatalf("expected 2 events got %v", len(fake.events))
+	}
+	fake = new(fakeSentry)
+	fake.status = http.StatusTooManyRequests
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	adapter, err := NewSentryAdapter(&router.Route{Options: map[string]string{
+		"dsn": "http://public@" + server.Listener.Addr().String() + "/42",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := adapter.(*Adapter)
+	message := &router.Message{Data: "panic: oops", Container: container}
+	if err := a.send(a.newEvent(message, levels["fatal"])); err == nil {
+		t.Error("expected an error")
+	}
+	if err := a.send(a.newEvent(message, levels["fatal"])); err == nil {
+		t.Error("expected a rate limit error")
+	}
+	if len(fake.paths) != 1 {
+		t.Errorf("expected 1 request while rate limited got %v", len(fake.paths))
+	}
+}
+
+func TestException(t *testing.T) {
+	tests := []struct {
+		data      string
+		typ       string
+		value     string
+		signature string
+	}{
+		{"panic: runtime error: index out of range [5] with length 3\n\ngoroutine 1 [running]:",
+			"panic", "runtime error: index out of range [5] with length 3", "panic: runtime error: index out of range [<n>] with length <n>"},
+		{"Traceback (most recent call last):\n  File \"app.py\", line 3\nValueError: invalid literal for int()",
+			"ValueError", "invalid literal for int()", "ValueError"},
+		{"Exception in thread \"main\" java.lang.NullPointerException\n\tat Main.main(Main.java:5)",
+			"java.lang.NullPointerException", "", "java.lang.NullPointerException"},
+		{"error: user 1234 not found", "", "", "error: user <n> not found"},
+	}
+	for _, test := range tests {
+		typ, value, signature := exception(test.data)
+		if typ != test.typ || value != test.value || signature != test.signature {
+			t.Errorf("%q: expected %q %q %q got %q %q %q", test.data,
+				test.typ, test.value, test.signature, typ, value, signature)
+		}
+	}
+}
+
+func TestParseDSN(t *testing.T) {
+	storeURL, key, err := parseDSN("https://abc@o1.ingest.sentry.io/sub/5")
+	if err != nil || storeURL != "https://o1.ingest.sentry.io/sub/api/5/store/" || key != "abc" {
+		t.Errorf("unexpected %s %s %v", storeURL, key, err)
+	}
+	for _, dsn := range []string{"https://o1.ingest.sentry.io/5", "https://abc@o1.ingest.sentry.io/", "::"} {
+		if _, _, err := parseDSN(dsn); err == nil {
+			t.Errorf("expected an error for %s", dsn)
+		}
+	}
+}
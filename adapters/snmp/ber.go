@@ -0,0 +1,126 @@
+package snmp
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// BER tags used by SNMPv2c traps, see RFC 3416
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagOID         = 0x06
+	tagSequence    = 0x30
+	tagTimeTicks   = 0x43
+	tagTrapPDU     = 0xa7
+)
+
+// tlv encodes a BER tag, length and value
+func tlv(tag byte, value []byte) []byte {
+	out := []byte{tag}
+	n := len(value)
+	switch {
+	case n < 0x80:
+		out = append(out, byte(n))
+	default:
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		out = append(out, 0x80|byte(len(length)))
+		out = append(out, length...)
+	}
+	return append(out, value...)
+}
+
+func sequence(tag byte, values ...[]byte) []byte {
+	var contents []byte
+	for _, value := range values {
+		contents = append(contents, value...)
+	}
+	return tlv(tag, contents)
+}
+
+// integer encodes i in the fewest two's complement bytes
+func integer(tag byte, i int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(i)}, b...)
+		if (i >= -0x80 && i < 0x80) || len(b) == 8 {
+			break
+		}
+		i >>= 8
+	}
+	return tlv(tag, b)
+}
+
+// unsigned encodes TimeTicks and other unsigned SNMP types
+func unsigned(tag byte, u uint32) []byte {
+	b := []byte{byte(u >> 24), byte(u >> 16), byte(u >> 8), byte(u)}
+	for len(b) > 1 && b[0] == 0 && b[1] < 0x80 {
+		b = b[1:]
+	}
+	if b[0] >= 0x80 {
+		b = append([]byte{0}, b...)
+	}
+	return tlv(tag, b)
+}
+
+func octetString(s string) []byte {
+	return tlv(tagOctetString, []byte(s))
+}
+
+// oid is a parsed object identifier like 1.3.6.1.4.1
+type oid []uint32
+
+// parseOID parses a dotted object identifier, with or without a leading dot
+func parseOID(s string) (oid, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "."), ".")
+	if len(parts) < 2 {
+		return nil, errors.New("invalid OID: " + s)
+	}
+	o := make(oid, len(parts))
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, errors.New("invalid OID: " + s)
+		}
+		o[i] = uint32(n)
+	}
+	if o[0] > 2 || (o[0] < 2 && o[1] >= 40) {
+		return nil, errors.New("invalid OID: " + s)
+	}
+	return o, nil
+}
+
+func (o oid) String() string {
+	parts := make([]string, len(o))
+	for i, n := range o {
+		parts[i] = strconv.FormatUint(uint64(n), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// child returns the OID of a child of o
+func (o oid) child(n uint32) oid {
+	return append(append(oid{}, o...), n)
+}
+
+// encode encodes the OID with the first two arcs combined and the rest in
+// base 128
+func (o oid) encode() []byte {
+	b := base128(o[0]*40 + o[1])
+	for _, n := range o[2:] {
+		b = append(b, base128(n)...)
+	}
+	return tlv(tagOID, b)
+}
+
+func base128(n uint32) []byte {
+	b := []byte{byte(n & 0x7f)}
+	for n >>= 7; n > 0; n >>= 7 {
+		b = append([]byte{byte(n&0x7f) | 0x80}, b...)
+	}
+	return b
+}
@@ -0,0 +1,194 @@
+package snmp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const varbindPrefix = "varbind."
+
+var (
+	sysUpTimeOID   = oid{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	snmpTrapOIDOID = oid{1, 3, 6, 1, 6, 3, 1, 1, 4, 1, 0}
+)
+
+func init() {
+	router.AdapterFactories.Register(NewSNMPAdapter, "snmp")
+	router.Capabilities.DescribeAdapter("snmp", []string{"match", "trap_oid", "community", "varbind.<oid>"}, nil)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+// Message extends router.Message with fields for varbind templates
+type Message struct {
+	*router.Message
+}
+
+// ContainerName returns the message's container name
+func (m *Message) ContainerName() string {
+	return strings.TrimPrefix(m.Message.Container.Name, "/")
+}
+
+type varbind struct {
+	oid  oid
+	tmpl *template.Template
+}
+
+// NewSNMPAdapter returns a configured snmp.Adapter sending SNMPv2c traps for
+// messages matching the match option
+func NewSNMPAdapter(route *router.Route) (router.LogAdapter, error) {
+	matchStr := router.RouteOpt(route, "match", "SNMP_MATCH", "")
+	if matchStr == "" {
+		return nil, errors.New("snmp: match is required")
+	}
+	match, err := regexp.Compile(matchStr)
+	if err != nil {
+		return nil, errors.New("snmp: invalid value for match (must be regexp): " + matchStr)
+	}
+	trapStr := router.RouteOpt(route, "trap_oid", "SNMP_TRAP_OID", "")
+	if trapStr == "" {
+		return nil, errors.New("snmp: trap_oid is required")
+	}
+	trapOID, err := parseOID(trapStr)
+	if err != nil {
+		return nil, errors.New("snmp: invalid value for trap_oid: " + trapStr)
+	}
+	varbinds, err := parseVarbinds(route.Options, trapOID)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, found := router.AdapterTransports.Lookup(route.AdapterTransport("udp"))
+	if !found {
+		return nil, errors.New("bad transport: " + route.Adapter)
+	}
+	conn, err := router.Dial(transport, route.Address, route.Options)
+	if err != nil {
+		return nil, err
+	}
+	return &Adapter{
+		route:     route,
+		conn:      conn,
+		community: router.RouteOpt(route, "community", "SNMP_COMMUNITY", "public"),
+		match:     match,
+		trapOID:   trapOID,
+		varbinds:  varbinds,
+		started:   time.Now(),
+	}, nil
+}
+
+// parseVarbinds returns the varbind.<oid> templates of options, by default
+// the message data, container name and container id as the children 1 to 3
+// of the trap OID
+func parseVarbinds(options map[string]string, trapOID oid) ([]varbind, error) {
+	var keys []string
+	for key := range options {
+		if strings.HasPrefix(key, varbindPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	templates := make(map[string]string)
+	var oids []oid
+	for _, key := range keys {
+		o, err := parseOID(strings.TrimPrefix(key, varbindPrefix))
+		if err != nil {
+			return nil, errors.New("snmp: invalid value for " + key + ": " + err.Error())
+		}
+		oids = append(oids, o)
+		templates[o.String()] = options[key]
+	}
+	if len(oids) == 0 {
+		for i, tmpl := range []string{"{{.Data}}", "{{.ContainerName}}", "{{.Container.ID}}"} {
+			o := trapOID.child(uint32(i + 1))
+			oids = append(oids, o)
+			templates[o.String()] = tmpl
+		}
+	}
+	varbinds := make([]varbind, len(oids))
+	for i, o := range oids {
+		tmpl, err := template.New(o.String()).Parse(templates[o.String()])
+		if err != nil {
+			return nil, errors.New("snmp: invalid value for " + varbindPrefix + o.String() + ": " + err.Error())
+		}
+		varbinds[i] = varbind{o, tmpl}
+	}
+	return varbinds, nil
+}
+
+// Adapter sends matching log lines as SNMPv2c traps
+type Adapter struct {
+	route     *router.Route
+	conn      net.Conn
+	community string
+	match     *regexp.Regexp
+	trapOID   oid
+	varbinds  []varbind
+	started   time.Time
+	requestID int32
+}
+
+// Stream sends a trap for each message matching the pattern
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	for message := range logstream {
+		if !a.match.MatchString(message.Data) {
+			continue
+		}
+		trap, err := a.trap(message)
+		if err == nil {
+			start := time.Now()
+			_, err = a.conn.Write(trap)
+			router.ObserveWrite(a.route, start)
+		}
+		if err != nil {
+			router.LogDeliveryError("snmp", err)
+		}
+		router.Receipts.Report(a.route, message, err)
+	}
+}
+
+// trap encodes an SNMPv2-Trap-PDU for message
+func (a *Adapter) trap(message *router.Message) ([]byte, error) {
+	m := &Message{message}
+	uptime := uint32(time.Since(a.started) / (10 * time.Millisecond))
+	bindings := [][]byte{
+		sequence(tagSequence, sysUpTimeOID.encode(), unsigned(tagTimeTicks, uptime)),
+		sequence(tagSequence, snmpTrapOIDOID.encode(), a.trapOID.encode()),
+	}
+	for _, vb := range a.varbinds {
+		buf := new(bytes.Buffer)
+		if err := vb.tmpl.Execute(buf, m); err != nil {
+			return nil, err
+		}
+		bindings = append(bindings, sequence(tagSequence, vb.oid.encode(), octetString(buf.String())))
+	}
+	pdu := sequence(tagTrapPDU,
+		integer(tagInteger, int64(atomic.AddInt32(&a.requestID, 1))),
+		integer(tagInteger, 0), // error-status
+		integer(tagInteger, 0), // error-index
+		sequence(tagSequence, bindings...),
+	)
+	// version 1 is SNMPv2c
+	return sequence(tagSequence, integer(tagInteger, 1), octetString(a.community), pdu), nil
+}
+
+// Close closes the adapter's connection
+func (a *Adapter) Close() error {
+	return a.conn.Close()
+}
@@ -0,0 +1,108 @@
+package snmp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+
+	_ "github.com/gliderlabs/logspout/transports/udp"
+)
+
+func TestEncoding(t *testing.T) {
+	o, err := parseOID(".1.3.6.1.4.1.311.128")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x06, 0x09, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x81, 0x00}; !bytes.Equal(o.encode(), expected) {
+		t.Errorf("expected OID encoding % x got % x", expected, o.encode())
+	}
+	for i, expected := range map[int64][]byte{
+		0:    {0x02, 0x01, 0x00},
+		127:  {0x02, 0x01, 0x7f},
+		128:  {0x02, 0x02, 0x00, 0x80},
+		-129: {0x02, 0x02, 0xff, 0x7f},
+	} {
+		if got := integer(tagInteger, i); !bytes.Equal(got, expected) {
+			t.Errorf("expected %v encoded as % x got % x", i, expected, got)
+		}
+	}
+	if got := unsigned(tagTimeTicks, 0xffffffff); !bytes.Equal(got, []byte{0x43, 0x05, 0x00, 0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("unexpected TimeTicks encoding % x", got)
+	}
+	long := octetString(string(make([]byte, 300)))
+	if !bytes.Equal(long[:4], []byte{0x04, 0x82, 0x01, 0x2c}) {
+		t.Errorf("unexpected long length encoding % x", long[:4])
+	}
+	for _, invalid := range []string{"1", "1.x.3", "3.1", "1.40"} {
+		if _, err := parseOID(invalid); err == nil {
+			t.Errorf("expected error for OID %s", invalid)
+		}
+	}
+}
+
+func TestSNMPSendsMatchingTraps(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	route := &router.Route{
+		Adapter: "snmp",
+		Address: conn.LocalAddr().String(),
+		Options: map[string]string{
+			"match":     "CRITICAL",
+			"trap_oid":  "1.3.6.1.4.1.99999.1",
+			"community": "noc",
+		},
+	}
+	adapter, err := NewSNMPAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logstream := make(chan *router.Message)
+	go adapter.Stream(logstream)
+	container := &docker.Container{ID: "8dfafdbc3a40", Name: "/db"}
+	logstream <- &router.Message{Container: container, Data: "all good"}
+	logstream <- &router.Message{Container: container, Data: "CRITICAL disk full"}
+	close(logstream)
+
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trap := buf[:n]
+	if trap[0] != tagSequence {
+		t.Errorf("expected trap to be a sequence got % x", trap[:1])
+	}
+	for _, expected := range [][]byte{
+		append(integer(tagInteger, 1), octetString("noc")...),
+		snmpTrapOIDOID.encode(),
+		append(oid{1, 3, 6, 1, 4, 1, 99999, 1, 1}.encode(), octetString("CRITICAL disk full")...),
+		append(oid{1, 3, 6, 1, 4, 1, 99999, 1, 2}.encode(), octetString("db")...),
+	} {
+		if !bytes.Contains(trap, expected) {
+			t.Errorf("expected trap to contain % x", expected)
+		}
+	}
+	if bytes.Contains(trap, []byte("all good")) {
+		t.Error("expected non-matching message not to be sent")
+	}
+}
+
+func TestSNMPRequiredOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"trap_oid": "1.3.6.1.4.1.99999.1"},
+		{"match": "CRITICAL"},
+		{"match": "CRITICAL", "trap_oid": "1.3.6.1.4.1.99999.1", "varbind.x": "{{.Data}}"},
+	} {
+		if _, err := NewSNMPAdapter(&router.Route{Adapter: "snmp", Address: "127.0.0.1:162", Options: options}); err == nil {
+			t.Errorf("expected error for %v", options)
+		}
+	}
+}
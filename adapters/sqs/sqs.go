@@ -0,0 +1,400 @@
+package sqs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gliderlabs/logspout/internal/aws"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	// SendMessageBatch limits, see
+	// https://docs.aws.amazon.com/AWSSimpleQueueService/latest/APIReference/API_SendMessageBatch.html
+	maxBatchEntries = 10
+	maxBatchBytes   = 262144
+	maxAttributes   = 10
+	maxAttributeKey = 256
+
+	defaultRetryCount = 10
+	targetPrefix      = "AmazonSQS."
+)
+
+func init() {
+	router.AdapterFactories.Register(NewSQSAdapter, "sqs")
+	router.Capabilities.DescribeAdapter("sqs", []string{
+		"region", "endpoint", "access_key_id", "secret_access_key", "session_token",
+		"batch_size", "flush_interval", "group_id", "json_schema",
+	}, nil)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+var logger = router.NewLogger("sqs")
+
+func debug(v ...interface{}) {
+	logger.Debugln(v...)
+}
+
+// NewSQSAdapter returns a configured sqs.Adapter for a route address of the
+// form of a queue URL without the scheme, e.g.
+// sqs.us-east-1.amazonaws.com/123456789012/logs
+func NewSQSAdapter(route *router.Route) (router.LogAdapter, error) {
+	parts := strings.SplitN(route.Address, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || !strings.Contains(parts[1], "/") {
+		return nil, errors.New("sqs: address must be a queue URL like sqs.<region>.amazonaws.com/<account>/<queue>: " + route.Address)
+	}
+	region := route.Options["region"]
+	if region == "" {
+		region = hostRegion(parts[0])
+	}
+	client, err := aws.NewClient("sqs", region)
+	if err != nil {
+		return nil, err
+	}
+	client.JSONVersion = "1.0"
+	client.Endpoint = "https://" + parts[0]
+	if endpoint := router.RouteOpt(route, "endpoint", "SQS_ENDPOINT", ""); endpoint != "" {
+		client.Endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+	if id := route.Options["access_key_id"]; id != "" {
+		client.Credentials = aws.NewStaticCredentialsProvider(id, route.Options["secret_access_key"], route.Options["session_token"])
+	}
+
+	batchStr := router.RouteOpt(route, "batch_size", "SQS_BATCH_SIZE", strconv.Itoa(maxBatchEntries))
+	batchSize, err := strconv.Atoi(batchStr)
+	if err != nil || batchSize < 1 || batchSize > maxBatchEntries {
+		return nil, errors.New("sqs: invalid value for batch_size: " + batchStr)
+	}
+	flushStr := router.RouteOpt(route, "flush_interval", "SQS_FLUSH_INTERVAL", "1s")
+	flushInterval, err := time.ParseDuration(flushStr)
+	if err != nil || flushInterval <= 0 {
+		return nil, errors.New("sqs: invalid value for flush_interval: " + flushStr)
+	}
+	// FIFO queues need a message group, by default one per container so
+	// each container's messages stay in order
+	var groupID *template.Template
+	if strings.HasSuffix(parts[1], ".fifo") {
+		groupStr := router.RouteOpt(route, "group_id", "SQS_GROUP_ID", "{{.ContainerName}}")
+		if groupID, err = template.New("group_id").Parse(groupStr); err != nil {
+			return nil, errors.New("sqs: invalid value for group_id: " + err.Error())
+		}
+	}
+	schema, err := router.NewRouteSchema(route)
+	if err != nil {
+		return nil, errors.New("sqs: " + err.Error())
+	}
+
+	retryCount, err := strconv.Atoi(getopt("RETRY_COUNT", strconv.Itoa(defaultRetryCount)))
+	if err != nil {
+		retryCount = defaultRetryCount
+	}
+
+	return &Adapter{
+		route:         route,
+		client:        client,
+		queueURL:      "https://" + route.Address,
+		groupID:       groupID,
+		batchSize:     batchSize,
+		schema:        schema,
+		flushInterval: flushInterval,
+		retryCount:    retryCount,
+	}, nil
+}
+
+// hostRegion returns the region of queue URL hosts like
+// sqs.us-east-1.amazonaws.com or the legacy us-east-1.queue.amazonaws.com
+func hostRegion(host string) string {
+	labels := strings.Split(host, ".")
+	switch {
+	case len(labels) >= 4 && labels[0] == "sqs":
+		return labels[1]
+	case len(labels) >= 4 && labels[1] == "queue":
+		return labels[0]
+	}
+	return ""
+}
+
+// Adapter sends log output to an Amazon SQS queue
+type Adapter struct {
+	route         *router.Route
+	client        *aws.Client
+	queueURL      string
+	groupID       *template.Template
+	batchSize     int
+	schema        *router.RouteSchema
+	flushInterval time.Duration
+	retryCount    int
+	batch         []*entry
+	bytes         int
+}
+
+// entry is a message of a SendMessageBatch request, with the log message it
+// is for
+type entry struct {
+	ID                     string               `json:"Id"`
+	MessageBody            string               `json:"MessageBody"`
+	MessageAttributes      map[string]attribute `json:"MessageAttributes,omitempty"`
+	MessageGroupID         string               `json:"MessageGroupId,omitempty"`
+	MessageDeduplicationID string               `json:"MessageDeduplicationId,omitempty"`
+	message                *router.Message
+}
+
+type attribute struct {
+	DataType    string `json:"DataType"`
+	StringValue string `json:"StringValue"`
+}
+
+type sendMessageBatchInput struct {
+	QueueURL string   `json:"QueueUrl"`
+	Entries  []*entry `json:"Entries"`
+}
+
+type batchResultError struct {
+	ID          string `json:"Id"`
+	Code        string `json:"Code"`
+	Message     string `json:"Message"`
+	SenderFault bool   `json:"SenderFault"`
+}
+
+type sendMessageBatchOutput struct {
+	Failed []batchResultError `json:"Failed"`
+}
+
+// Message extends router.Message with fields for group id templates
+type Message struct {
+	*router.Message
+}
+
+// ContainerName returns the message's container name
+func (m *Message) ContainerName() string {
+	return strings.TrimPrefix(m.Message.Container.Name, "/")
+}
+
+// ContainerID returns the message's short container id
+func (m *Message) ContainerID() string {
+	id := m.Message.Container.ID
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// Stream sends log data to the queue in batches
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	defer a.flush()
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				return
+			}
+			if !a.schema.Valid(message, []byte(message.Data)) {
+				continue
+			}
+			e, err := a.newEntry(message)
+			if err != nil {
+				router.LogDeliveryError("sqs", err)
+				router.Receipts.Report(a.route, message, err)
+				continue
+			}
+			size := e.size()
+			if len(a.batch) > 0 && a.bytes+size > maxBatchBytes {
+				a.flush()
+			}
+			a.batch = append(a.batch, e)
+			a.bytes += size
+			if len(a.batch) >= a.batchSize || message.Exiting {
+				a.flush()
+			}
+		case <-ticker.C:
+			a.flush()
+		}
+	}
+}
+
+// newEntry returns the SQS message for message, with the container metadata
+// and as many message fields as fit as attributes. Bodies too large for a
+// batch are truncated.
+func (a *Adapter) newEntry(message *router.Message) (*entry, error) {
+	m := &Message{message}
+	attributes := make(map[string]attribute)
+	setAttribute(attributes, "container_id", m.ContainerID())
+	setAttribute(attributes, "container_name", m.ContainerName())
+	setAttribute(attributes, "source", message.Source)
+	if message.Container.Config != nil {
+		setAttribute(attributes, "image", message.Container.Config.Image)
+		setAttribute(attributes, "hostname", message.Container.Config.Hostname)
+	}
+	keys := make([]string, 0, len(message.Fields))
+	for key := range message.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if len(attributes) >= maxAttributes {
+			break
+		}
+		setAttribute(attributes, key, message.Fields[key])
+	}
+	e := &entry{MessageAttributes: attributes, message: message}
+	if a.groupID != nil {
+		group := new(bytes.Buffer)
+		if err := a.groupID.Execute(group, m); err != nil {
+			return nil, err
+		}
+		e.MessageGroupID = group.String()
+		// the same message sent again within 5 minutes, e.g. when a batch
+		// is retried, is only delivered once
+		sum := sha256.Sum256([]byte(message.Container.ID + "\x00" + message.Source + "\x00" +
+			strconv.FormatInt(message.Time.UnixNano(), 10) + "\x00" + message.Data))
+		e.MessageDeduplicationID = hex.EncodeToString(sum[:])
+	}
+	e.MessageBody = router.Truncate(validChars(message.Data), maxBatchBytes-e.size())
+	if e.MessageBody == "" {
+		// SQS rejects empty messages
+		e.MessageBody = " "
+	}
+	return e, nil
+}
+
+// setAttribute sets a non-empty string attribute, with the characters SQS
+// doesn't allow in attribute names replaced by _
+func setAttribute(attributes map[string]attribute, key, value string) {
+	key = strings.Trim(strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, key), ".")
+	lower := strings.ToLower(key)
+	if key == "" || value == "" || strings.HasPrefix(lower, "aws.") || strings.HasPrefix(lower, "amazon.") {
+		return
+	}
+	if len(key) > maxAttributeKey {
+		key = key[:maxAttributeKey]
+	}
+	attributes[strings.Replace(key, "..", "._", -1)] = attribute{DataType: "String", StringValue: validChars(value)}
+}
+
+// size returns the bytes an entry counts towards the batch size limit
+func (e *entry) size() int {
+	size := len(e.MessageBody)
+	for key, value := range e.MessageAttributes {
+		size += len(key) + len(value.DataType) + len(value.StringValue)
+	}
+	return size
+}
+
+// validChars replaces the characters SQS doesn't allow in messages, like
+// the control characters of terminal escape sequences, with U+FFFD
+func validChars(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+			return r
+		case r < 0x20 || r > 0xd7ff && r < 0xe000 || r == 0xfffe || r == 0xffff:
+			return utf8.RuneError
+		}
+		return r
+	}, s)
+}
+
+func (a *Adapter) flush() {
+	if len(a.batch) == 0 {
+		return
+	}
+	errs := a.send(a.batch)
+	failed := 0
+	var err error
+	for i, e := range a.batch {
+		if errs[i] != nil {
+			failed++
+			err = errs[i]
+		}
+		router.Receipts.Report(a.route, e.message, errs[i])
+	}
+	if failed > 0 {
+		logger.Error("dropping messages", "messages", failed, "category", router.ErrorCategory(err), "error", err)
+	}
+	a.batch, a.bytes = nil, 0
+}
+
+// send sends a batch, sending the messages that failed on the side of SQS
+// again with backoff, and returns the error of each message
+func (a *Adapter) send(batch []*entry) []error {
+	defer router.ObserveWrite(a.route, time.Now())
+	errs := make([]error, len(batch))
+	pending := make(map[string]int, len(batch))
+	entries := make([]*entry, len(batch))
+	for i, e := range batch {
+		e.ID = strconv.Itoa(i)
+		pending[e.ID] = i
+		entries[i] = e
+	}
+	for try := 0; ; try++ {
+		for _, e := range entries {
+			errs[pending[e.ID]] = nil
+		}
+		out := new(sendMessageBatchOutput)
+		err := a.client.Call(targetPrefix+"SendMessageBatch", &sendMessageBatchInput{
+			QueueURL: a.queueURL,
+			Entries:  entries,
+		}, out)
+		if apiErr, ok := err.(*aws.Error); ok && !apiErr.Retryable() {
+			for _, e := range entries {
+				errs[pending[e.ID]] = err
+			}
+			return errs
+		}
+		var retry []*entry
+		if err == nil {
+			for _, failure := range out.Failed {
+				i, ok := pending[failure.ID]
+				if !ok {
+					continue
+				}
+				errs[i] = &aws.Error{Type: failure.Code, Message: failure.Message}
+				if failure.SenderFault {
+					errs[i] = router.NewDeliveryError(router.ErrorSerialization, errs[i])
+					continue
+				}
+				retry = append(retry, batch[i])
+			}
+		} else {
+			// throttling, server errors and network errors
+			retry = entries
+			for _, e := range entries {
+				errs[pending[e.ID]] = err
+			}
+		}
+		if len(retry) == 0 || try >= a.retryCount {
+			return errs
+		}
+		entries = retry
+		delay := router.RetryDelay(try)
+		debug("sqs: retrying", len(entries), "messages in", delay, "after:", errs[pending[entries[0].ID]])
+		retried := make([]*router.Message, len(entries))
+		for i, e := range entries {
+			retried[i] = e.message
+		}
+		router.WaitRetry(a.route, delay, retried...)
+	}
+}
@@ -0,0 +1,194 @@
+package sqs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+var container = &docker.Container{
+	ID:     "8dfafdbc3a40b1b0bd3a0f5c",
+	Name:   "/container",
+	Config: &docker.Config{Image: "app:1.0", Hostname: "8dfafdbc3a40"},
+}
+
+// fakeSQS answers SendMessageBatch, failing the entries with the bodies in
+// failures once
+type fakeSQS struct {
+	sync.Mutex
+	requests []*sendMessageBatchInput
+	failures map[string]batchResultError
+}
+
+func (f *fakeSQS) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if req.Header.Get("X-Amz-Target") != "AmazonSQS.SendMessageBatch" ||
+		req.Header.Get("Content-Type") != "application/x-amz-json-1.0" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type": "com.amazonaws.sqs#InvalidAction", "message": "bad request"}`))
+		return
+	}
+	in := new(sendMessageBatchInput)
+	json.NewDecoder(req.Body).Decode(in)
+	f.requests = append(f.requests, in)
+	out := new(sendMessageBatchOutput)
+	for _, e := range in.Entries {
+		if failure, ok := f.failures[e.MessageBody]; ok {
+			failure.ID = e.ID
+			out.Failed = append(out.Failed, failure)
+			delete(f.failures, e.MessageBody)
+		}
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+func newTestAdapter(t *testing.T, server *httptest.Server, address string, options map[string]string) *Adapter {
+	options["endpoint"] = server.URL
+	options["access_key_id"] = "AKIDEXAMPLE"
+	options["secret_access_key"] = "secret"
+	adapter, err := NewSQSAdapter(&router.Route{Adapter: "sqs", Address: address, Options: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return adapter.(*Adapter)
+}
+
+func TestSQSBatches(t *testing.T) {
+	f := new(fakeSQS)
+	server := httptest.NewServer(f)
+	defer server.Close()
+	a := newTestAdapter(t, server, "sqs.us-east-1.amazonaws.com/123456789012/logs", map[string]string{"flush_interval": "1h"})
+	logstream := make(chan *router.Message)
+	done := make(chan struct{})
+	go func() {
+		a.Stream(logstream)
+		close(done)
+	}()
+	for i := 0; i < 12; i++ {
+		logstream <- &router.Message{
+			Container: container,
+			Source:    "stdout",
+			Data:      "line " + strconv.Itoa(i) + "\x1b[0m",
+			Time:      time.Now(),
+			Fields:    map[string]string{"level": "info"},
+		}
+	}
+	close(logstream)
+	<-done
+
+	f.Lock()
+	defer f.Unlock()
+	if len(f.requests) != 2 || len(f.requests[0].Entries) != 10 || len(f.requests[1].Entries) != 2 {
+		t.Fatalf("expected batches of 10 and 2 got %v requests", len(f.requests))
+	}
+	in := f.requests[0]
+	if in.QueueURL != "https://sqs.us-east-1.amazonaws.com/123456789012/logs" {
+		t.Errorf("unexpected queue URL %q", in.QueueURL)
+	}
+	e := in.Entries[0]
+	if e.ID != "0" || e.MessageBody != "line 0�[0m" || e.MessageGroupID != "" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	for key, expected := range map[string]string{
+		"container_id":   "8dfafdbc3a40",
+		"container_name": "container",
+		"image":          "app:1.0",
+		"hostname":       "8dfafdbc3a40",
+		"source":         "stdout",
+		"level":          "info",
+	} {
+		if value := e.MessageAttributes[key]; value.DataType != "String" || value.StringValue != expected {
+			t.Errorf("expected attribute %s=%s got %+v", key, expected, value)
+		}
+	}
+}
+
+func TestSQSRetriesServerFailures(t *testing.T) {
+	f := &fakeSQS{failures: map[string]batchResultError{
+		"retried":  {Code: "InternalError", Message: "try again"},
+		"rejected": {Code: "InvalidMessageContents", Message: "invalid", SenderFault: true},
+	}}
+	server := httptest.NewServer(f)
+	defer server.Close()
+	a := newTestAdapter(t, server, "sqs.us-east-1.amazonaws.com/123456789012/logs", map[string]string{})
+	var batch []*entry
+	for _, data := range []string{"sent", "retried", "rejected"} {
+		e, err := a.newEntry(&router.Message{Container: container, Data: data, Time: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch = append(batch, e)
+	}
+	errs := a.send(batch)
+	if errs[0] != nil || errs[1] != nil {
+		t.Errorf("expected the first two messages to be sent got %v", errs)
+	}
+	if errs[2] == nil || router.ErrorCategory(errs[2]) != router.ErrorSerialization {
+		t.Errorf("expected a serialization error got %v", errs[2])
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	if len(f.requests) != 2 || len(f.requests[1].Entries) != 1 || f.requests[1].Entries[0].MessageBody != "retried" {
+		t.Errorf("expected only the server failure to be sent again got %v requests", len(f.requests))
+	}
+}
+
+func TestSQSFIFO(t *testing.T) {
+	server := httptest.NewServer(new(fakeSQS))
+	defer server.Close()
+	a := newTestAdapter(t, server, "sqs.eu-west-1.amazonaws.com/123456789012/logs.fifo", map[string]string{})
+	if a.client.Region != "eu-west-1" {
+		t.Errorf("expected the region of the queue URL got %q", a.client.Region)
+	}
+	message := &router.Message{Container: container, Data: "line", Time: time.Now()}
+	e, err := a.newEntry(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := a.newEntry(message)
+	if e.MessageGroupID != "container" || len(e.MessageDeduplicationID) != 64 || again.MessageDeduplicationID != e.MessageDeduplicationID {
+		t.Errorf("unexpected FIFO entry %+v", e)
+	}
+}
+
+func TestSQSOptions(t *testing.T) {
+	for _, route := range []*router.Route{
+		{Address: "sqs.us-east-1.amazonaws.com", Options: map[string]string{}},
+		{Address: "sqs.us-east-1.amazonaws.com/123456789012/logs", Options: map[string]string{"batch_size": "11"}},
+		{Address: "sqs.us-east-1.amazonaws.com/123456789012/logs", Options: map[string]string{"flush_interval": "0"}},
+		{Address: "sqs.us-east-1.amazonaws.com/123456789012/logs.fifo", Options: map[string]string{"group_id": "{{"}},
+	} {
+		if _, err := NewSQSAdapter(route); err == nil {
+			t.Errorf("expected an error for %v %v", route.Address, route.Options)
+		}
+	}
+	for host, expected := range map[string]string{
+		"sqs.us-east-1.amazonaws.com":     "us-east-1",
+		"sqs.cn-north-1.amazonaws.com.cn": "cn-north-1",
+		"ap-south-1.queue.amazonaws.com":  "ap-south-1",
+		"localhost:4566":                  "",
+	} {
+		if region := hostRegion(host); region != expected {
+			t.Errorf("expected %q for %s got %q", expected, host, region)
+		}
+	}
+}
+
+func TestSetAttribute(t *testing.T) {
+	attributes := make(map[string]attribute)
+	setAttribute(attributes, "com.docker/service", "web")
+	setAttribute(attributes, "AWS.trace", "1")
+	setAttribute(attributes, "empty", "")
+	if len(attributes) != 1 || attributes["com.docker_service"].StringValue != "web" {
+		t.Errorf("unexpected attributes %v", attributes)
+	}
+}
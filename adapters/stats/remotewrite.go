@@ -0,0 +1,149 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/internal/protowire"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/klauspost/compress/snappy"
+)
+
+const defaultTimeout = 30 * time.Second
+
+// remoteWrite sends the counts as cumulative counters to a Prometheus
+// remote write endpoint
+type remoteWrite struct {
+	client   *http.Client
+	url      string
+	user     string
+	password string
+	prefix   string
+	host     string
+}
+
+func newRemoteWrite(route *router.Route, prefix string) (*remoteWrite, error) {
+	transport, found := router.AdapterTransports.Lookup(route.AdapterTransport("tcp"))
+	if !found {
+		return nil, errors.New("bad transport: " + route.Adapter)
+	}
+	addr, path := route.Address, "/api/v1/write"
+	if i := strings.Index(addr, "/"); i >= 0 {
+		addr, path = addr[:i], addr[i:]
+	}
+	if addr == "" {
+		return nil, errors.New("stats: address must be host:port or host:port/path: " + route.Address)
+	}
+	scheme, port := "http", "9090"
+	if route.AdapterTransport("tcp") == "tls" {
+		scheme, port = "https", "443"
+	}
+	addr = router.DefaultPort(addr, port)
+	// connections go through the route's transport, so the TLS settings
+	// and dial_timeout apply
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return transport.Dial(addr, route.Options)
+	}
+	client := &http.Client{Timeout: defaultTimeout}
+	if scheme == "https" {
+		client.Transport = &http.Transport{DialTLSContext: dial}
+	} else {
+		client.Transport = &http.Transport{DialContext: dial}
+	}
+	host, _ := os.Hostname()
+	return &remoteWrite{
+		client:   client,
+		url:      scheme + "://" + addr + path,
+		user:     router.RouteOpt(route, "user", "STATS_USER", ""),
+		password: router.RouteOpt(route, "password", "STATS_PASSWORD", ""),
+		prefix:   tagName(prefix),
+		host:     host,
+	}, nil
+}
+
+// send sends the total messages and bytes of each series. Counts that
+// fail to be sent are included in the totals sent next.
+func (r *remoteWrite) send(series []*series, now time.Time) error {
+	body := snappy.Encode(nil, r.encode(series, now))
+	req, err := http.NewRequest("POST", r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "logspout")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if r.user != "" {
+		req.SetBasicAuth(r.user, r.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return router.NewDeliveryError(router.HTTPErrorCategory(resp.StatusCode),
+		fmt.Errorf("%s returned %s: %s", r.url, resp.Status, bytes.TrimSpace(message)))
+}
+
+// encode returns the series as a prometheus.WriteRequest protobuf message
+// with a <prefix>_messages_total and <prefix>_bytes_total time series each:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func (r *remoteWrite) encode(series []*series, now time.Time) []byte {
+	var req []byte
+	ms := now.UnixNano() / int64(time.Millisecond)
+	for _, s := range series {
+		for _, counter := range []struct {
+			name  string
+			value uint64
+		}{
+			{r.prefix + "_messages_total", s.messages},
+			{r.prefix + "_bytes_total", s.bytes},
+		} {
+			// labels must be sorted by name, and __name__ sorts first
+			ts := appendLabel(nil, "__name__", counter.name)
+			host := r.host != ""
+			for _, tag := range s.tags {
+				if host && tag[0] > "host" {
+					ts = appendLabel(ts, "host", r.host)
+					host = false
+				}
+				if tag[1] != "" {
+					ts = appendLabel(ts, tag[0], tag[1])
+				}
+			}
+			if host {
+				ts = appendLabel(ts, "host", r.host)
+			}
+			sample := protowire.AppendFixed64(nil, 1, math.Float64bits(float64(counter.value)))
+			sample = protowire.AppendVarint(sample, 2, uint64(ms))
+			ts = protowire.AppendBytes(ts, 2, sample)
+			req = protowire.AppendBytes(req, 1, ts)
+		}
+	}
+	return req
+}
+
+func appendLabel(b []byte, name, value string) []byte {
+	label := protowire.AppendBytes(nil, 1, []byte(name))
+	label = protowire.AppendBytes(label, 2, []byte(value))
+	return protowire.AppendBytes(b, 1, label)
+}
@@ -0,0 +1,292 @@
+package stats
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	// the largest statsd datagram unlikely to be fragmented
+	maxDatagram = 1432
+	// series of containers that haven't logged for this long are no
+	// longer sent
+	expireAfter = time.Hour
+)
+
+func init() {
+	router.AdapterFactories.Register(NewStatsAdapter, "stats")
+	router.Capabilities.DescribeAdapter("stats", []string{
+		"protocol", "interval", "prefix", "labels", "user", "password",
+	}, nil)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+var logger = router.NewLogger("stats")
+
+func debug(v ...interface{}) {
+	logger.Debugln(v...)
+}
+
+// sink sends the message and byte counts of each series
+type sink interface {
+	send(series []*series, now time.Time) error
+}
+
+// NewStatsAdapter returns a configured stats.Adapter, sending to a statsd
+// server at host:port, or with protocol=remote_write to the Prometheus
+// remote write endpoint at host:port/path
+func NewStatsAdapter(route *router.Route) (router.LogAdapter, error) {
+	protocol := router.RouteOpt(route, "protocol", "STATS_PROTOCOL", "statsd")
+	intervalStr := router.RouteOpt(route, "interval", "STATS_INTERVAL", "10s")
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil || interval <= 0 {
+		return nil, errors.New("stats: invalid value for interval: " + intervalStr)
+	}
+	var labels []string
+	if labelsStr := router.RouteOpt(route, "labels", "STATS_LABELS", ""); labelsStr != "" {
+		for _, key := range strings.Split(labelsStr, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				labels = append(labels, key)
+			}
+		}
+	}
+	prefix := router.RouteOpt(route, "prefix", "STATS_PREFIX", "logspout")
+
+	a := &Adapter{
+		route:    route,
+		interval: interval,
+		labels:   labels,
+		series:   make(map[string]*series),
+	}
+	switch protocol {
+	case "statsd":
+		a.sink, err = newStatsd(route, prefix)
+	case "remote_write":
+		a.sink, err = newRemoteWrite(route, prefix)
+	default:
+		return nil, errors.New("stats: invalid value for protocol (must be statsd or remote_write): " + protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Adapter counts the messages and bytes each container logs, sending the
+// counts instead of the messages
+type Adapter struct {
+	route    *router.Route
+	sink     sink
+	interval time.Duration
+	labels   []string
+	series   map[string]*series
+}
+
+// series counts the messages of a container's stream
+type series struct {
+	// the tags of the series, sorted by name
+	tags     [][2]string
+	messages uint64
+	bytes    uint64
+	// the counts last sent to statsd
+	sentMessages uint64
+	sentBytes    uint64
+	last         time.Time
+}
+
+// Stream counts log data, sending the counts every interval
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	defer a.flush(time.Now())
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				return
+			}
+			a.count(message, time.Now())
+			router.Receipts.Report(a.route, message, nil)
+		case now := <-ticker.C:
+			a.flush(now)
+		}
+	}
+}
+
+func (a *Adapter) count(message *router.Message, now time.Time) {
+	tags := a.tags(message)
+	var key strings.Builder
+	for _, tag := range tags {
+		key.WriteString(tag[1])
+		key.WriteByte(0)
+	}
+	s, ok := a.series[key.String()]
+	if !ok {
+		s = &series{tags: tags}
+		a.series[key.String()] = s
+	}
+	s.messages++
+	s.bytes += uint64(len(message.Data))
+	s.last = now
+}
+
+// tags returns the container name, image and stream source of message, and
+// the container labels listed in the labels option
+func (a *Adapter) tags(message *router.Message) [][2]string {
+	tags := [][2]string{
+		{"container_name", strings.TrimPrefix(message.Container.Name, "/")},
+		{"source", message.Source},
+	}
+	if config := message.Container.Config; config != nil {
+		tags = append(tags, [2]string{"image", config.Image})
+		for _, key := range a.labels {
+			tags = append(tags, [2]string{tagName(key), config.Labels[key]})
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i][0] < tags[j][0] })
+	return tags
+}
+
+// tagName returns key with the characters that aren't letters, digits or _
+// replaced with _, as Prometheus label names must be
+func tagName(key string) string {
+	name := []byte(key)
+	for i, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+// flush sends the counts and forgets the series that expired
+func (a *Adapter) flush(now time.Time) {
+	if len(a.series) == 0 {
+		return
+	}
+	series := make([]*series, 0, len(a.series))
+	for _, s := range a.series {
+		series = append(series, s)
+	}
+	start := time.Now()
+	err := a.sink.send(series, now)
+	router.ObserveWrite(a.route, start)
+	if err != nil {
+		router.LogDeliveryError("stats", err)
+		return
+	}
+	for key, s := range a.series {
+		if now.Sub(s.last) > expireAfter {
+			delete(a.series, key)
+		}
+	}
+}
+
+// statsd sends the counts as statsd counters, with DogStatsD tags
+type statsd struct {
+	route     *router.Route
+	transport router.AdapterTransport
+	addr      string
+	prefix    string
+	conn      net.Conn
+	datagrams bool
+}
+
+func newStatsd(route *router.Route, prefix string) (*statsd, error) {
+	name := route.AdapterTransport("udp")
+	transport, found := router.AdapterTransports.Lookup(name)
+	if !found {
+		return nil, errors.New("bad transport: " + route.Adapter)
+	}
+	addr := router.DefaultPort(route.Address, "8125")
+	return &statsd{
+		route:     route,
+		transport: transport,
+		addr:      addr,
+		prefix:    prefix,
+		datagrams: name == "udp",
+	}, nil
+}
+
+// send sends the messages and bytes counted since the last send, as
+// lines like logspout.messages:12|c|#container_name:app,source:stdout
+func (s *statsd) send(series []*series, now time.Time) error {
+	var lines []string
+	for _, c := range series {
+		if c.messages == c.sentMessages {
+			continue
+		}
+		var tags strings.Builder
+		for i, tag := range c.tags {
+			if i > 0 {
+				tags.WriteByte(',')
+			}
+			tags.WriteString(tag[0] + ":" + statsdValue(tag[1]))
+		}
+		lines = append(lines,
+			s.prefix+".messages:"+strconv.FormatUint(c.messages-c.sentMessages, 10)+"|c|#"+tags.String(),
+			s.prefix+".bytes:"+strconv.FormatUint(c.bytes-c.sentBytes, 10)+"|c|#"+tags.String())
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	if err := s.write(lines); err != nil {
+		// the counts are sent with the next ones
+		if s.conn != nil {
+			s.conn.Close()
+			s.conn = nil
+		}
+		return err
+	}
+	for _, c := range series {
+		c.sentMessages, c.sentBytes = c.messages, c.bytes
+	}
+	return nil
+}
+
+// write sends lines over the connection, in datagrams of at most
+// maxDatagram bytes over udp
+func (s *statsd) write(lines []string) error {
+	if s.conn == nil {
+		conn, err := router.Dial(s.transport, s.addr, s.route.Options)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	var packet []byte
+	for _, line := range lines {
+		if s.datagrams && len(packet) > 0 && len(packet)+len(line)+1 > maxDatagram {
+			if _, err := s.conn.Write(packet[:len(packet)-1]); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		packet = append(append(packet, line...), '\n')
+	}
+	if s.datagrams {
+		packet = packet[:len(packet)-1]
+	}
+	_, err := s.conn.Write(packet)
+	return err
+}
+
+// statsdValue returns a tag value without the characters separating tags
+// and metrics
+func statsdValue(value string) string {
+	return strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_").Replace(value)
+}
@@ -0,0 +1,167 @@
+package stats
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	_ "github.com/gliderlabs/logspout/transports/tcp"
+	_ "github.com/gliderlabs/logspout/transports/udp"
+	"github.com/klauspost/compress/snappy"
+)
+
+var container = &docker.Container{
+	ID:   "8dfafdbc3a40b1b0bd3a0f5c",
+	Name: "/app",
+	Config: &docker.Config{
+		Image:  "app:1.0",
+		Labels: map[string]string{"com.example.team": "a,b"},
+	},
+}
+
+func TestStatsd(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	adapter, err := NewStatsAdapter(&router.Route{
+		Adapter: "stats",
+		Address: conn.LocalAddr().String(),
+		Options: map[string]string{"labels": "com.example.team", "interval": "1h"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := adapter.(*Adapter)
+	for _, data := range []string{"one", "three"} {
+		a.count(&router.Message{Container: container, Source: "stdout", Data: data}, time.Now())
+	}
+	a.flush(time.Now())
+
+	buf := make([]byte, maxDatagram)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := "|c|#com_example_team:a_b,container_name:app,image:app:1.0,source:stdout"
+	expected := "logspout.messages:2" + tags + "\nlogspout.bytes:8" + tags
+	if string(buf[:n]) != expected {
+		t.Errorf("expected %q got %q", expected, buf[:n])
+	}
+
+	// only the counts since the last flush are sent
+	a.count(&router.Message{Container: container, Source: "stdout", Data: "four"}, time.Now())
+	a.flush(time.Now())
+	n, _, err = conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(buf[:n]), "logspout.messages:1|c|") {
+		t.Errorf("expected a delta got %q", buf[:n])
+	}
+}
+
+func TestRemoteWrite(t *testing.T) {
+	var mu sync.Mutex
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/push" || req.Header.Get("Content-Encoding") != "snappy" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if user, password, _ := req.BasicAuth(); user != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		compressed, _ := ioutil.ReadAll(req.Body)
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	adapter, err := NewStatsAdapter(&router.Route{
+		Adapter: "stats",
+		Address: strings.TrimPrefix(server.URL, "http://") + "/push",
+		Options: map[string]string{"protocol": "remote_write", "user": "user", "password": "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := adapter.(*Adapter)
+	a.count(&router.Message{Container: container, Source: "stderr", Data: "line"}, time.Now())
+	a.flush(time.Now())
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 request got %v", len(bodies))
+	}
+	for _, expected := range [][]byte{
+		appendLabel(nil, "__name__", "logspout_messages_total"),
+		appendLabel(nil, "__name__", "logspout_bytes_total"),
+		appendLabel(nil, "container_name", "app"),
+		appendLabel(nil, "source", "stderr"),
+	} {
+		if !bytes.Contains(bodies[0], expected) {
+			t.Errorf("expected the request to contain %q", expected)
+		}
+	}
+}
+
+func TestRemoteWriteError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("out of order sample"))
+	}))
+	defer server.Close()
+	adapter, err := NewStatsAdapter(&router.Route{
+		Adapter: "stats",
+		Address: strings.TrimPrefix(server.URL, "http://"),
+		Options: map[string]string{"protocol": "remote_write"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := adapter.(*Adapter)
+	a.count(&router.Message{Container: container, Source: "stdout", Data: "line"}, time.Now())
+	var s []*series
+	for _, v := range a.series {
+		s = append(s, v)
+	}
+	err = a.sink.send(s, time.Now())
+	if err == nil || router.ErrorCategory(err) != router.ErrorSerialization {
+		t.Errorf("expected a serialization error got %v", err)
+	}
+}
+
+func TestStatsOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"protocol": "graphite"},
+		{"interval": "0"},
+		{"interval": "soon"},
+	} {
+		if _, err := NewStatsAdapter(&router.Route{Adapter: "stats", Address: "localhost", Options: options}); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+	if name := tagName("com.docker/service-1"); name != "com_docker_service_1" {
+		t.Errorf("unexpected tag name %q", name)
+	}
+}
@@ -0,0 +1,77 @@
+package syslog
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// clock renders the timestamps of a route's messages in its layout and
+// timezone. Without a timezone, timestamps are rendered in the timezone of
+// the message's time, the local one that follows TZ for the time a line was
+// read, so the nil clock renders RFC 3339 timestamps.
+type clock struct {
+	layout   string
+	location *time.Location
+	// year adds the year to RFC 3164 timestamps
+	year bool
+}
+
+// RFC 3164 timestamps, with the day padded with a space. Go formats month
+// names in English whatever the locale of the host.
+const (
+	stampRFC3164     = "Jan _2 15:04:05"
+	stampRFC3164Year = "Jan _2 2006 15:04:05"
+)
+
+// newClock returns the clock configured by the timestamp_format, timezone
+// and rfc3164_year options of a route, or SYSLOG_TIMESTAMP_FORMAT,
+// SYSLOG_TIMEZONE and SYSLOG_RFC3164_YEAR
+func newClock(route *router.Route) (*clock, error) {
+	c := &clock{layout: router.RouteOpt(route, "timestamp_format", "SYSLOG_TIMESTAMP_FORMAT", time.RFC3339)}
+	// a layout without any element of the reference time renders as is
+	if time.Unix(0, 0).Format(c.layout) == c.layout {
+		return nil, fmt.Errorf("syslog: invalid value for timestamp_format (must be a Go time layout): %s", c.layout)
+	}
+	timezone := router.RouteOpt(route, "timezone", "SYSLOG_TIMEZONE", "")
+	if timezone != "" {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("syslog: invalid value for timezone: %s", err)
+		}
+		c.location = location
+	}
+	year := router.RouteOpt(route, "rfc3164_year", "SYSLOG_RFC3164_YEAR", "false")
+	var err error
+	if c.year, err = strconv.ParseBool(year); err != nil {
+		return nil, fmt.Errorf("syslog: invalid value for rfc3164_year (must be true or false): %s", year)
+	}
+	return c, nil
+}
+
+// in returns t in the clock's timezone
+func (c *clock) in(t time.Time) time.Time {
+	if c == nil || c.location == nil {
+		return t
+	}
+	return t.In(c.location)
+}
+
+// format renders t in the clock's layout and timezone
+func (c *clock) format(t time.Time) string {
+	if c == nil {
+		return t.Format(time.RFC3339)
+	}
+	return c.in(t).Format(c.layout)
+}
+
+// stamp renders t as an RFC 3164 timestamp in the clock's timezone, e.g.
+// "Jan  2 15:04:05", or "Jan  2 2006 15:04:05" with the year
+func (c *clock) stamp(t time.Time) string {
+	if c != nil && c.year {
+		return c.in(t).Format(stampRFC3164Year)
+	}
+	return c.in(t).Format(stampRFC3164)
+}
@@ -0,0 +1,75 @@
+package syslog
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// the ways a route handles newlines within rendered messages, which would
+// otherwise end the message early on stream transports
+const (
+	newlineKeep   = "keep"
+	newlineEscape = "escape"
+	newlineSpace  = "space"
+	newlineOctet  = "octet"
+)
+
+// getNewline returns how a route handles newlines within messages, set with
+// the newline option or SYSLOG_NEWLINE. Datagrams carry one message each,
+// so octet counting keeps their newlines, while RELP splits its messages at
+// newlines, so octet counting escapes them.
+func getNewline(route *router.Route, datagram bool) (string, error) {
+	newline := router.RouteOpt(route, "newline", "SYSLOG_NEWLINE", newlineKeep)
+	switch newline {
+	case newlineKeep, newlineEscape, newlineSpace:
+		return newline, nil
+	case newlineOctet:
+		switch {
+		case datagram:
+			return newlineKeep, nil
+		case route.AdapterTransport("udp") == "relp":
+			return newlineEscape, nil
+		}
+		return newline, nil
+	}
+	return "", fmt.Errorf("syslog: invalid value for newline (must be keep, escape, space or octet): %s", newline)
+}
+
+// frameNewlines returns buf, a rendered message ending in the newline that
+// frames it, with the newlines within the message handled as newline says:
+// escaped as \n, replaced by spaces, or the message sent with the octet
+// counting framing of RFC 6587 instead of the newline. Messages without
+// newlines are returned as they are, so octet counted messages are only
+// sent to receivers that tell the framings apart when needed.
+func frameNewlines(buf *bytes.Buffer, newline string) *bytes.Buffer {
+	body := buf.Bytes()
+	trailer := bytes.HasSuffix(body, []byte("\n"))
+	if trailer {
+		body = body[:len(body)-1]
+	}
+	if newline == newlineKeep || bytes.IndexByte(body, '\n') < 0 {
+		return buf
+	}
+	out := router.GetBuffer()
+	switch newline {
+	case newlineEscape:
+		body = bytes.Replace(body, []byte("\r"), []byte(`\r`), -1)
+		out.Write(bytes.Replace(body, []byte("\n"), []byte(`\n`), -1))
+	case newlineSpace:
+		body = bytes.Replace(body, []byte("\r\n"), []byte(" "), -1)
+		out.Write(bytes.Replace(body, []byte("\n"), []byte(" "), -1))
+	case newlineOctet:
+		out.WriteString(strconv.Itoa(len(body)))
+		out.WriteByte(' ')
+		out.Write(body)
+		trailer = false
+	}
+	if trailer {
+		out.WriteByte('\n')
+	}
+	router.PutBuffer(buf)
+	return out
+}
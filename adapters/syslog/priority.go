@@ -0,0 +1,45 @@
+package syslog
+
+// Priority is a syslog facility combined with a severity. It mirrors
+// log/syslog, which isn't available on Windows.
+type Priority int
+
+// severities
+const (
+	logEmerg Priority = iota
+	logAlert
+	logCrit
+	logErr
+	logWarning
+	logNotice
+	logInfo
+	logDebug
+)
+
+// facilities
+const (
+	logKern Priority = iota << 3
+	logUser
+	logMail
+	logDaemon
+	logAuth
+	logSyslog
+	logLpr
+	logNews
+	logUucp
+	logCron
+	logAuthpriv
+	logFtp
+	_ // unused
+	_ // unused
+	_ // unused
+	_ // unused
+	logLocal0
+	logLocal1
+	logLocal2
+	logLocal3
+	logLocal4
+	logLocal5
+	logLocal6
+	logLocal7
+)
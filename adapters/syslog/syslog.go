@@ -4,13 +4,13 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"hash/fnv"
 	"log"
-	"log/syslog"
 	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"text/template"
 	"time"
@@ -24,6 +24,7 @@ var (
 	hostname         string
 	retryCount       uint
 	econnResetErrStr string
+	errQueueFull     = router.NewDeliveryError(router.ErrorDropped, errors.New("syslog: send queue full"))
 )
 
 var funcs = template.FuncMap{
@@ -36,6 +37,11 @@ func init() {
 	hostname, _ = os.Hostname()
 	econnResetErrStr = fmt.Sprintf("write: %s", syscall.ECONNRESET.Error())
 	router.AdapterFactories.Register(NewSyslogAdapter, "syslog")
+	router.Capabilities.DescribeAdapter("syslog", []string{
+		"format", "facility", "tag", "append_tag", "structured_data", "heartbeat", "heartbeat_mode",
+		"idle_timeout", "batch_size", "flush_interval", "queue_size", "conns",
+		"timestamp_format", "timezone", "rfc3164_year", "newline", "envelope",
+	}, funcs)
 	setRetryCount()
 }
 
@@ -56,20 +62,20 @@ func getopt(name, dfault string) string {
 	return value
 }
 
+var logger = router.NewLogger("syslog")
+
 func debug(v ...interface{}) {
-	if os.Getenv("DEBUG") != "" {
-		log.Println(v...)
-	}
+	logger.Debugln(v...)
 }
 
+// getHostname returns the name of the Docker host found by the chain of
+// HOSTNAME_SOURCES, by default as mounted at /etc/host_hostname, or else
+// the SYSLOG_HOSTNAME template
 func getHostname() string {
-	content, err := ioutil.ReadFile("/etc/host_hostname")
-	if err == nil && len(content) > 0 {
-		hostname = strings.TrimRight(string(content), "\r\n")
-	} else {
-		hostname = getopt("SYSLOG_HOSTNAME", "{{.Container.Config.Hostname}}")
+	if name := router.Hostnames.Get(); name != "" {
+		return name
 	}
-	return hostname
+	return getopt("SYSLOG_HOSTNAME", "{{.Container.Config.Hostname}}")
 }
 
 // NewSyslogAdapter returnas a configured syslog.Adapter
@@ -78,85 +84,525 @@ func NewSyslogAdapter(route *router.Route) (router.LogAdapter, error) {
 	if !found {
 		return nil, errors.New("bad transport: " + route.Adapter)
 	}
-	conn, err := transport.Dial(route.Address, route.Options)
+	conn, err := router.Dial(transport, route.Address, route.Options)
 	if err != nil {
 		return nil, err
 	}
+	tmpl, err := NewTemplate(route)
+	if err != nil {
+		return nil, err
+	}
+	heartbeat, err := getDurationOpt(route, "heartbeat", "SYSLOG_HEARTBEAT", "0")
+	if err != nil {
+		return nil, err
+	}
+	idleTimeout, err := getDurationOpt(route, "idle_timeout", "SYSLOG_IDLE_TIMEOUT", "0")
+	if err != nil {
+		return nil, err
+	}
+	batchSize, err := getIntOpt(route, "batch_size", "SYSLOG_BATCH_SIZE", "1")
+	if err != nil {
+		return nil, err
+	}
+	flushInterval, err := getDurationOpt(route, "flush_interval", "SYSLOG_FLUSH_INTERVAL", "1s")
+	if err != nil {
+		return nil, err
+	}
+	queueSize, err := getIntOpt(route, "queue_size", "SYSLOG_QUEUE_SIZE", "1024")
+	if err != nil {
+		return nil, err
+	}
+	conns, err := getIntOpt(route, "conns", "SYSLOG_CONNS", "1")
+	if err != nil {
+		return nil, err
+	}
+	if router.Datagram(conn) {
+		// each datagram must carry exactly one syslog frame
+		batchSize = 1
+	}
+	heartbeatMode := router.RouteOpt(route, "heartbeat_mode", "SYSLOG_HEARTBEAT_MODE", "syslog")
+	if heartbeatMode != "syslog" && heartbeatMode != "noop" {
+		return nil, errors.New("unsupported syslog heartbeat mode: " + heartbeatMode)
+	}
+	format, err := getFormat(route)
+	if err != nil {
+		return nil, err
+	}
+	newline, err := getNewline(route, router.Datagram(conn))
+	if err != nil {
+		return nil, err
+	}
+	clock, err := newClock(route)
+	if err != nil {
+		return nil, err
+	}
+	envelope, err := router.NewEnvelope(route)
+	if err != nil {
+		return nil, errors.New("syslog: " + err.Error())
+	}
+	a := &Adapter{
+		route:         route,
+		conn:          conn,
+		tmpl:          tmpl,
+		transport:     transport,
+		format:        format,
+		newline:       newline,
+		envelope:      envelope,
+		clock:         clock,
+		heartbeat:     heartbeat,
+		heartbeatMode: heartbeatMode,
+		idleTimeout:   idleTimeout,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		batch:         new(bytes.Buffer),
+		queueSize:     queueSize,
+		lastWrite:     time.Now(),
+	}
+	if conns > 1 {
+		if err := a.connect(conns); err != nil {
+			a.Close()
+			return nil, err
+		}
+	}
+	return a, nil
+}
 
-	format := getopt("SYSLOG_FORMAT", "rfc5424")
-	priority := getopt("SYSLOG_PRIORITY", "{{.Priority}}")
-	pid := getopt("SYSLOG_PID", "{{.Container.State.Pid}}")
-	hostname = getHostname()
+// connect opens the connections of a pool of conns workers, the adapter
+// itself being the first
+func (a *Adapter) connect(conns int) error {
+	a.workers = []*Adapter{a}
+	for len(a.workers) < conns {
+		conn, err := router.Dial(a.transport, a.route.Address, a.route.Options)
+		if err != nil {
+			return err
+		}
+		worker := *a
+		worker.conn = conn
+		worker.workers = nil
+		worker.batch = new(bytes.Buffer)
+		worker.batched = nil
+		a.workers = append(a.workers, &worker)
+	}
+	return nil
+}
 
-	tag := getopt("SYSLOG_TAG", "{{.ContainerName}}"+route.Options["append_tag"])
-	structuredData := getopt("SYSLOG_STRUCTURED_DATA", "")
-	if route.Options["structured_data"] != "" {
-		structuredData = route.Options["structured_data"]
+// getDurationOpt returns a duration from a route option, falling back to an env var
+func getDurationOpt(route *router.Route, option, env, dfault string) (time.Duration, error) {
+	value := router.RouteOpt(route, option, env, dfault)
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("syslog: invalid value for %s: %s", option, value)
 	}
-	data := getopt("SYSLOG_DATA", "{{.Data}}")
-	timestamp := getopt("SYSLOG_TIMESTAMP", "{{.Timestamp}}")
+	return d, nil
+}
 
+// getIntOpt returns a positive integer from a route option, falling back to an env var
+func getIntOpt(route *router.Route, option, env, dfault string) (int, error) {
+	value := router.RouteOpt(route, option, env, dfault)
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 1 {
+		return 0, fmt.Errorf("syslog: invalid value for %s: %s", option, value)
+	}
+	return i, nil
+}
+
+// facilities are the syslog facilities by name
+var facilities = map[string]Priority{
+	"kern":     logKern,
+	"user":     logUser,
+	"mail":     logMail,
+	"daemon":   logDaemon,
+	"auth":     logAuth,
+	"syslog":   logSyslog,
+	"lpr":      logLpr,
+	"news":     logNews,
+	"uucp":     logUucp,
+	"cron":     logCron,
+	"authpriv": logAuthpriv,
+	"ftp":      logFtp,
+	"local0":   logLocal0,
+	"local1":   logLocal1,
+	"local2":   logLocal2,
+	"local3":   logLocal3,
+	"local4":   logLocal4,
+	"local5":   logLocal5,
+	"local6":   logLocal6,
+	"local7":   logLocal7,
+}
+
+// severities are the syslog severities by name
+var severities = map[string]Priority{
+	"emerg":   logEmerg,
+	"alert":   logAlert,
+	"crit":    logCrit,
+	"err":     logErr,
+	"warning": logWarning,
+	"notice":  logNotice,
+	"info":    logInfo,
+	"debug":   logDebug,
+}
+
+// the container labels overriding the facility of a route and the severity
+// of container output
+const (
+	facilityLabel = "logspout.syslog.facility"
+	severityLabel = "logspout.syslog.severity"
+)
+
+// maximum tag lengths, the TAG of RFC 3164 and APP-NAME of RFC 5424
+const (
+	maxTagRFC3164 = 32
+	maxTagRFC5424 = 48
+)
+
+// getFormat returns the syslog format of a route
+func getFormat(route *router.Route) (string, error) {
+	format := router.RouteOpt(route, "format", "SYSLOG_FORMAT", "rfc5424")
+	if format != "rfc5424" && format != "rfc3164" {
+		return "", errors.New("unsupported syslog format: " + format)
+	}
+	return format, nil
+}
+
+// NewTemplate returns the syslog template configured for a route
+func NewTemplate(route *router.Route) (*template.Template, error) {
+	override, err := route.TemplateOverride()
+	if err != nil {
+		return nil, err
+	}
+	if override != "" {
+		return ParseTemplate(override)
+	}
+
+	format, err := getFormat(route)
+	if err != nil {
+		return nil, err
+	}
+	priority := "{{.Priority}}"
+	facility := router.RouteOpt(route, "facility", "SYSLOG_FACILITY", "")
+	if facility != "" {
+		if _, ok := facilities[facility]; !ok {
+			return nil, errors.New("syslog: invalid value for facility: " + facility)
+		}
+		priority = fmt.Sprintf("{{.PriorityFor %q}}", facility)
+	}
+	priority = router.RouteOpt(route, "", "SYSLOG_PRIORITY", priority)
+	pid := router.RouteOpt(route, "", "SYSLOG_PID", "{{.Container.State.Pid}}")
+
+	tag := router.RouteOpt(route, "tag", "SYSLOG_TAG", "{{.ContainerName}}"+route.Options["append_tag"])
+	structuredData := router.RouteOpt(route, "structured_data", "SYSLOG_STRUCTURED_DATA", "")
+	data := router.RouteOpt(route, "", "SYSLOG_DATA", "{{.Data}}")
+
+	if structuredData != "" {
+		structuredData = fmt.Sprintf("[%s]", structuredData)
+	}
+	fields, err := router.StaticFields(route)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) > 0 && format == "rfc5424" {
+		element, err := fieldsElement(fields)
+		if err != nil {
+			return nil, err
+		}
+		// quoted so the values aren't parsed as template actions
+		structuredData += fmt.Sprintf("{{%q}}", element)
+	}
 	if structuredData == "" {
 		structuredData = "-"
-	} else {
-		structuredData = fmt.Sprintf("[%s]", structuredData)
 	}
 
+	host := getHostname()
 	var tmplStr string
+	var maxTag int
 	switch format {
 	case "rfc5424":
-		tmplStr = fmt.Sprintf("<%s>1 %s %s %s %s - %s %s\n",
-			priority, timestamp, hostname, tag, pid, structuredData, data)
+		timestamp := router.RouteOpt(route, "", "SYSLOG_TIMESTAMP", "{{.Timestamp}}")
+		tmplStr = fmt.Sprintf("<%s>1 %s %s {{tag .}} %s - %s %s\n",
+			priority, timestamp, host, pid, structuredData, data)
+		maxTag = maxTagRFC5424
 	case "rfc3164":
-		tmplStr = fmt.Sprintf("<%s>%s %s %s[%s]: %s\n",
-			priority, timestamp, hostname, tag, pid, data)
-	default:
-		return nil, errors.New("unsupported syslog format: " + format)
+		timestamp := router.RouteOpt(route, "", "SYSLOG_TIMESTAMP", "{{.TimestampRFC3164}}")
+		tmplStr = fmt.Sprintf("<%s>%s %s {{tag .}}[%s]: %s\n",
+			priority, timestamp, host, pid, data)
+		maxTag = maxTagRFC3164
 	}
-	tmpl, err := template.New("syslog").Funcs(funcs).Parse(tmplStr)
+	tagTmpl, err := ParseTemplate(tag)
 	if err != nil {
 		return nil, err
 	}
-	return &Adapter{
-		route:     route,
-		conn:      conn,
-		tmpl:      tmpl,
-		transport: transport,
-	}, nil
+	// the tag is rendered on its own so it can be limited to the length the
+	// format allows
+	renderTag := func(data interface{}) (string, error) {
+		buf, err := router.Render(tagTmpl, data)
+		if err != nil {
+			return "", err
+		}
+		tag := buf.String()
+		router.PutBuffer(buf)
+		tag = router.Truncate(tag, maxTag)
+		if tag == "" && format == "rfc5424" {
+			tag = "-"
+		}
+		return tag, nil
+	}
+	return template.New("syslog").Funcs(funcs).Funcs(template.FuncMap{"tag": renderTag}).Parse(tmplStr)
+}
+
+// fieldsSDID is the SD-ID of the structured data element holding the static
+// fields of a route, under the example enterprise number of RFC 5612
+const fieldsSDID = "fields@32473"
+
+// fieldsElement returns the structured data element with the add_field
+// options of a route as its parameters
+func fieldsElement(fields []router.StaticField) (string, error) {
+	element := "[" + fieldsSDID
+	for _, field := range fields {
+		if len(field.Key) > 32 || strings.IndexFunc(field.Key, func(r rune) bool {
+			return r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"'
+		}) >= 0 {
+			return "", errors.New("syslog: invalid structured data parameter name in add_field: " + field.Key)
+		}
+		element += fmt.Sprintf(` %s="%s"`, field.Key, sdEscaper.Replace(field.Value))
+	}
+	return element + "]", nil
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// ParseTemplate parses a syslog template with the syslog template functions
+func ParseTemplate(tmplStr string) (*template.Template, error) {
+	return template.New("syslog").Funcs(funcs).Parse(tmplStr)
 }
 
 // Adapter streams log output to a connection in the Syslog format
 type Adapter struct {
-	conn      net.Conn
-	route     *router.Route
-	tmpl      *template.Template
-	transport router.AdapterTransport
+	conn          net.Conn
+	route         *router.Route
+	tmpl          *template.Template
+	transport     router.AdapterTransport
+	format        string
+	newline       string
+	envelope      *router.Envelope
+	clock         *clock
+	heartbeat     time.Duration
+	heartbeatMode string
+	idleTimeout   time.Duration
+	batchSize     int
+	flushInterval time.Duration
+	batch         *bytes.Buffer
+	batched       []*frame
+	queueSize     int
+	dropped       int
+	renderErrors  int
+	lastWrite     time.Time
+	// workers send over their own connections when the route has more
+	// than one, each container's messages always going to the same worker
+	workers []*Adapter
 }
 
-// Stream sends log data to a connection
+// Stream sends log data to a connection, or spread over the connections of
+// the pool by container
 func (a *Adapter) Stream(logstream chan *router.Message) {
+	workers := a.workers
+	if len(workers) == 0 {
+		workers = []*Adapter{a}
+	}
+	// frames are written, and the connection re-established, by separate
+	// goroutines so a slow dial or DNS lookup doesn't stall the route
+	queues := make([]chan *frame, len(workers))
+	var sent sync.WaitGroup
+	for i, worker := range workers {
+		queues[i] = make(chan *frame, a.queueSize)
+		sent.Add(1)
+		go func(worker *Adapter, queue chan *frame) {
+			worker.send(queue)
+			sent.Done()
+		}(worker, queues[i])
+	}
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		sent.Wait()
+	}()
+	// messages are rendered one at a time, so one is reused for all of them
+	m := &Message{clock: a.clock}
 	for message := range logstream {
-		m := &Message{message}
-		buf, err := m.Render(a.tmpl)
+		m.Message = message
+		buf, err := router.Render(a.tmpl, m)
+		if err == nil {
+			buf, err = a.envelope.Wrap(message, buf)
+		}
 		if err != nil {
-			log.Println("syslog:", err)
-			return
+			// a message the template can't render fails on its own, the
+			// connection is kept for the messages after it
+			a.renderFailed(message, err)
+			continue
 		}
-		if _, err = a.conn.Write(buf); err != nil {
-			log.Println("syslog:", err)
-			switch a.conn.(type) {
-			case *net.UDPConn:
-				continue
-			default:
-				if err = a.retry(buf, err); err != nil {
-					log.Panicf("syslog retry err: %+v", err)
-					return
+		buf = frameNewlines(buf, a.newline)
+		select {
+		case queues[worker(message, len(queues))] <- &frame{buf, message}:
+		default:
+			a.dropped++
+			if a.dropped%1000 == 1 {
+				logger.Warn("send queue full", "route", a.route.ID, "dropped", a.dropped)
+			}
+			router.Receipts.Report(a.route, message, errQueueFull)
+			router.PutBuffer(buf)
+		}
+	}
+}
+
+// renderFailed reports a message the route's template failed to render,
+// counting it as syslog.render_errors and sending it to the dead letter route
+func (a *Adapter) renderFailed(message *router.Message, err error) {
+	err = router.NewDeliveryError(router.ErrorSerialization, err)
+	router.Counters.Add(a.route, "syslog.render_errors", 1)
+	a.renderErrors++
+	if !router.DeadLetter(a.route, message, err) && a.renderErrors%1000 == 1 {
+		logger.Warn("rendering failed", "route", a.route.ID, "errors", a.renderErrors, "error", err)
+	}
+	router.Receipts.Report(a.route, message, err)
+}
+
+// frame is a rendered message, with a buffer from the router's pool that is
+// returned to it once written
+type frame struct {
+	buf     *bytes.Buffer
+	message *router.Message
+}
+
+// worker returns which of n workers sends message, keeping each container's
+// messages in order on one connection
+func worker(message *router.Message, n int) int {
+	if n == 1 || message.Container == nil {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(message.Container.ID))
+	return int(h.Sum32() % uint32(n))
+}
+
+// send writes queued frames to the connection until the queue is closed
+func (a *Adapter) send(queue chan *frame) {
+	var heartbeat <-chan time.Time
+	if a.heartbeat > 0 {
+		ticker := time.NewTicker(a.heartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	var flush <-chan time.Time
+	if a.batchSize > 1 {
+		ticker := time.NewTicker(a.flushInterval)
+		defer ticker.Stop()
+		flush = ticker.C
+		defer a.flush()
+	}
+	for {
+		select {
+		case f, ok := <-queue:
+			if !ok {
+				return
+			}
+			if a.batchSize > 1 {
+				a.batch.Write(f.buf.Bytes())
+				a.batched = append(a.batched, f)
+				if len(a.batched) >= a.batchSize || f.message.Exiting {
+					a.flush()
 				}
+				continue
+			}
+			router.Receipts.ReportSize(a.route, f.message, f.buf.Len(), a.write(f.buf.Bytes(), f))
+			router.PutBuffer(f.buf)
+		case <-flush:
+			a.flush()
+		case <-heartbeat:
+			if time.Since(a.lastWrite) >= a.heartbeat {
+				debug("syslog: sending heartbeat")
+				a.write(a.heartbeatMessage())
 			}
 		}
 	}
 }
 
+// Close closes the adapter's connections
+func (a *Adapter) Close() error {
+	err := a.conn.Close()
+	for _, worker := range a.workers {
+		if worker != a {
+			worker.conn.Close()
+		}
+	}
+	return err
+}
+
+// flush writes all batched frames to the connection in a single write
+func (a *Adapter) flush() {
+	if len(a.batched) == 0 {
+		return
+	}
+	err := a.write(a.batch.Bytes(), a.batched...)
+	a.batch.Reset()
+	for i, f := range a.batched {
+		router.Receipts.ReportSize(a.route, f.message, f.buf.Len(), err)
+		router.PutBuffer(f.buf)
+		a.batched[i] = nil
+	}
+	a.batched = a.batched[:0]
+}
+
+// write writes buf, the frames rendered, to the connection, retrying and
+// reconnecting on errors other than those of datagram connections, which are
+// returned
+func (a *Adapter) write(buf []byte, frames ...*frame) error {
+	defer router.ObserveWrite(a.route, time.Now())
+	if !router.Datagram(a.conn) && a.idleTimeout > 0 && time.Since(a.lastWrite) > a.idleTimeout {
+		logger.Info("connection idle, reconnecting", "route", a.route.ID, "idle_timeout", a.idleTimeout)
+		a.conn.Close()
+		if err := a.reconnect(); err != nil {
+			log.Panicf("syslog reconnect err: %+v", err)
+		}
+	}
+	if _, err := a.conn.Write(buf); err != nil {
+		router.LogDeliveryError("syslog", err)
+		// messages the receiver refused, e.g. over RELP, aren't retried
+		if router.Datagram(a.conn) || router.ErrorCategory(err) == router.ErrorDropped {
+			return err
+		}
+		for _, f := range frames {
+			router.Retries.Hold(a.route, f.message)
+		}
+		router.Budgets.Retried(a.route)
+		if err = a.retry(buf, err); err != nil {
+			log.Panicf("syslog retry err: %+v", err)
+			return err
+		}
+	}
+	a.lastWrite = time.Now()
+	return nil
+}
+
+// heartbeatMessage returns the keepalive written to an otherwise quiet connection
+func (a *Adapter) heartbeatMessage() []byte {
+	if a.heartbeatMode == "noop" {
+		return []byte("\n")
+	}
+	priority := logSyslog | logDebug
+	now := a.clock.in(time.Now())
+	// the configured hostname is usually a per-container template
+	host := getHostname()
+	if strings.Contains(host, "{{") {
+		host, _ = os.Hostname()
+	}
+	if a.format == "rfc3164" {
+		return []byte(fmt.Sprintf("<%d>%s %s logspout: heartbeat\n",
+			priority, a.clock.stamp(now), host))
+	}
+	return []byte(fmt.Sprintf("<%d>1 %s %s logspout - heartbeat - heartbeat\n",
+		priority, a.clock.format(now), host))
+}
+
 func (a *Adapter) retry(buf []byte, err error) error {
 	if opError, ok := err.(*net.OpError); ok {
 		if (opError.Temporary() && opError.Err.Error() != econnResetErrStr) || opError.Timeout() {
@@ -170,19 +616,19 @@ func (a *Adapter) retry(buf []byte, err error) error {
 		return reconnErr
 	}
 	if _, err = a.conn.Write(buf); err != nil {
-		log.Println("syslog: reconnect failed")
+		logger.Warn("reconnect failed", "route", a.route.ID)
 		return err
 	}
-	log.Println("syslog: reconnect successful")
+	logger.Info("reconnect successful", "route", a.route.ID)
 	return nil
 }
 
 func (a *Adapter) retryTemporary(buf []byte) error {
-	log.Printf("syslog: retrying tcp up to %v times\n", retryCount)
+	logger.Info("retrying", "route", a.route.ID, "retries", retryCount)
 	err := retryExp(func() error {
 		_, err := a.conn.Write(buf)
 		if err == nil {
-			log.Println("syslog: retry successful")
+			logger.Info("retry successful", "route", a.route.ID)
 			return nil
 		}
 
@@ -190,7 +636,7 @@ func (a *Adapter) retryTemporary(buf []byte) error {
 	}, retryCount)
 
 	if err != nil {
-		log.Println("syslog: retry failed")
+		logger.Warn("retry failed", "route", a.route.ID)
 		return err
 	}
 
@@ -198,9 +644,9 @@ func (a *Adapter) retryTemporary(buf []byte) error {
 }
 
 func (a *Adapter) reconnect() error {
-	log.Printf("syslog: reconnecting up to %v times\n", retryCount)
+	logger.Info("reconnecting", "route", a.route.ID, "retries", retryCount)
 	err := retryExp(func() error {
-		conn, err := a.transport.Dial(a.route.Address, a.route.Options)
+		conn, err := router.Dial(a.transport, a.route.Address, a.route.Options)
 		if err != nil {
 			return err
 		}
@@ -234,6 +680,7 @@ func retryExp(fun func() error, tries uint) error {
 // Message extends router.Message for the syslog standard
 type Message struct {
 	*router.Message
+	clock *clock
 }
 
 // Render transforms the log message using the Syslog template
@@ -246,16 +693,62 @@ func (m *Message) Render(tmpl *template.Template) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// Priority returns a syslog.Priority based on the message source
-func (m *Message) Priority() syslog.Priority {
+// Priority returns a syslog Priority based on the message source, in the
+// facility of the container's logspout.syslog.facility label if it has one
+func (m *Message) Priority() Priority {
+	if f, ok := m.labelled(facilityLabel, facilities); ok {
+		return f | m.Severity()
+	}
 	switch m.Message.Source {
-	case "stdout":
-		return syslog.LOG_USER | syslog.LOG_INFO
-	case "stderr":
-		return syslog.LOG_USER | syslog.LOG_ERR
+	case "stdout", "stderr":
+		return logUser | m.Severity()
 	default:
-		return syslog.LOG_DAEMON | syslog.LOG_INFO
+		return logDaemon | m.Severity()
+	}
+}
+
+// PriorityFor returns the syslog Priority of the message source in a facility
+// like local0, unless the container's logspout.syslog.facility label sets
+// another
+func (m *Message) PriorityFor(facility string) (Priority, error) {
+	f, ok := facilities[facility]
+	if !ok {
+		return 0, errors.New("syslog: invalid value for facility: " + facility)
+	}
+	if labelled, ok := m.labelled(facilityLabel, facilities); ok {
+		f = labelled
+	}
+	return f | m.Severity(), nil
+}
+
+// Severity returns the syslog severity of the message source: err for
+// stderr, and otherwise info or the severity of the container's
+// logspout.syslog.severity label
+func (m *Message) Severity() Priority {
+	if m.Message.Source == "stderr" {
+		return logErr
+	}
+	if severity, ok := m.labelled(severityLabel, severities); ok {
+		return severity
+	}
+	return logInfo
+}
+
+// labelled returns the value in names of the container label, ignoring
+// labels with values not in names
+func (m *Message) labelled(label string, names map[string]Priority) (Priority, bool) {
+	if m.Message.Container == nil || m.Message.Container.Config == nil {
+		return 0, false
+	}
+	value, ok := m.Message.Container.Config.Labels[label]
+	if !ok {
+		return 0, false
+	}
+	p, ok := names[strings.ToLower(value)]
+	if !ok {
+		debug("syslog: ignoring invalid label", label+"="+value)
 	}
+	return p, ok
 }
 
 // Hostname returns the os hostname
@@ -263,9 +756,17 @@ func (m *Message) Hostname() string {
 	return hostname
 }
 
-// Timestamp returns the message's syslog formatted timestamp
+// Timestamp returns the message's syslog formatted timestamp, in the
+// route's timestamp_format and timezone
 func (m *Message) Timestamp() string {
-	return m.Message.Time.Format(time.RFC3339)
+	return m.clock.format(m.Message.Time)
+}
+
+// TimestampRFC3164 returns the message's timestamp in the RFC 3164 format,
+// e.g. "Jan  2 15:04:05", in the route's timezone and with the year if
+// rfc3164_year is set
+func (m *Message) TimestampRFC3164() string {
+	return m.clock.stamp(m.Message.Time)
 }
 
 // ContainerName returns the message's container name
@@ -2,6 +2,8 @@ package syslog
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -14,7 +16,7 @@ import (
 	"testing"
 	"text/template"
 	"time"
-	"bytes"
+	"unicode/utf8"
 
 	docker "github.com/fsouza/go-dockerclient"
 	"github.com/gliderlabs/logspout/router"
@@ -143,7 +145,7 @@ func TestSyslogJoinFunc(t *testing.T) {
 		log.Fatalf("template error: %s\n", err)
 	}
 
-	expected :=  "<PRIORITY>1 TIMESTAMP HOSTNAME TAG PID - [foo-bar] DATA\n"
+	expected := "<PRIORITY>1 TIMESTAMP HOSTNAME TAG PID - [foo-bar] DATA\n"
 	check(t, adapter.(*Adapter).tmpl, expected, out.String())
 }
 
@@ -166,6 +168,195 @@ func TestSyslogSplitFunc(t *testing.T) {
 	check(t, adapter.(*Adapter).tmpl, expected, out.String())
 }
 
+func TestSyslogHeartbeat(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	route := &router.Route{
+		Adapter: "syslog+tcp",
+		Address: l.Addr().String(),
+		Options: map[string]string{"heartbeat": "50ms"},
+	}
+	adapter, err := NewSyslogAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stream := make(chan *router.Message)
+	defer close(stream)
+	go adapter.Stream(stream)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(line, "heartbeat") {
+		t.Errorf("expected heartbeat message got: %s", line)
+	}
+}
+
+func TestSyslogIdleReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	route := &router.Route{
+		Adapter: "syslog+tcp",
+		Address: l.Addr().String(),
+		Options: map[string]string{"idle_timeout": "50ms"},
+	}
+	adapter, err := NewSyslogAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := make(chan *router.Message)
+	defer close(stream)
+	go adapter.Stream(stream)
+
+	msg := &router.Message{Container: container, Data: "test", Time: time.Now(), Source: "stdout"}
+	stream <- msg
+	time.Sleep(100 * time.Millisecond)
+	stream <- msg
+
+	for i := 0; i < 2; i++ {
+		select {
+		case conn := <-accepted:
+			conn.Close()
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected 2 connections got %v", i)
+		}
+	}
+}
+
+func TestSyslogBatch(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	route := &router.Route{
+		Adapter: "syslog+tcp",
+		Address: l.Addr().String(),
+		Options: map[string]string{"batch_size": "3", "flush_interval": "100ms"},
+	}
+	adapter, err := NewSyslogAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stream := make(chan *router.Message)
+	defer close(stream)
+	go adapter.Stream(stream)
+
+	for i := 1; i <= 4; i++ {
+		stream <- &router.Message{Container: container, Data: "test " + strconv.Itoa(i), Time: time.Now(), Source: "stdout"}
+	}
+
+	// the fourth message is only written once the flush interval passes
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b := bufio.NewReader(conn)
+	for i := 1; i <= 4; i++ {
+		if _, err := b.ReadString('\n'); err != nil {
+			t.Fatalf("expected 4 messages got %v: %s", i-1, err)
+		}
+	}
+}
+
+func TestSyslogQueueFull(t *testing.T) {
+	// nothing reads from the other end of the pipe, so the first write blocks
+	conn, _ := net.Pipe()
+	route := &router.Route{Adapter: "syslog+tcp"}
+	tmpl, err := template.New("syslog").Parse(testTmplStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter := &Adapter{
+		route:     route,
+		conn:      conn,
+		tmpl:      tmpl,
+		batchSize: 1,
+		batch:     new(bytes.Buffer),
+		queueSize: 1,
+	}
+	stream := make(chan *router.Message)
+	go adapter.Stream(stream)
+
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			stream <- &router.Message{Container: container, Data: "test", Time: time.Now(), Source: "stdout"}
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected messages to be dropped instead of stalling the stream")
+	}
+}
+
+func TestSyslogRenderErrorKeepsConnection(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// the template fails for messages with the data "bad"
+	tmpl := base64.StdEncoding.EncodeToString([]byte(`{{if eq .Data "bad"}}{{.Bogus}}{{end}}{{.Data}}` + "\n"))
+	route := &router.Route{ID: "render", Adapter: "syslog+tcp", Address: l.Addr().String(), Options: map[string]string{"template": tmpl}}
+	adapter, err := NewSyslogAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stream := make(chan *router.Message)
+	defer close(stream)
+	go adapter.Stream(stream)
+	for _, data := range []string{"one", "bad", "two"} {
+		stream <- &router.Message{Container: container, Data: data, Time: time.Now(), Source: "stdout"}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b := bufio.NewReader(conn)
+	for _, expected := range []string{"one\n", "two\n"} {
+		if line, err := b.ReadString('\n'); err != nil || line != expected {
+			t.Fatalf("expected %q on the same connection got %q: %v", expected, line, err)
+		}
+	}
+	if errors := router.Counters.Get(route, "syslog.render_errors"); errors != 1 {
+		t.Errorf("expected 1 render error counted got %v", errors)
+	}
+}
+
 func TestHostnameDoesNotHaveLineFeed(t *testing.T) {
 	if err := ioutil.WriteFile(hostHostnameFilename, []byte(badHostnameContent), 0777); err != nil {
 		t.Fatal(err)
@@ -176,7 +367,7 @@ func TestHostnameDoesNotHaveLineFeed(t *testing.T) {
 	}
 }
 
-func newDummyAdapter()(router.LogAdapter, error) {
+func newDummyAdapter() (router.LogAdapter, error) {
 	os.Setenv("SYSLOG_PRIORITY", "PRIORITY")
 	os.Setenv("SYSLOG_TIMESTAMP", "TIMESTAMP")
 	os.Setenv("SYSLOG_PID", "PID")
@@ -263,3 +454,292 @@ func check(t *testing.T, tmpl *template.Template, in string, out string) {
 		t.Errorf("expected: %s\ngot: %s\n", in, out)
 	}
 }
+
+func TestSyslogFormatAndFacility(t *testing.T) {
+	for _, env := range []string{"SYSLOG_PRIORITY", "SYSLOG_TIMESTAMP", "SYSLOG_PID", "SYSLOG_HOSTNAME", "SYSLOG_TAG", "SYSLOG_DATA", "SYSLOG_STRUCTURED_DATA"} {
+		os.Unsetenv(env)
+	}
+	os.Setenv("SYSLOG_FACILITY", "local3")
+	defer os.Unsetenv("SYSLOG_FACILITY")
+	message := &Message{Message: &router.Message{
+		Container: &docker.Container{
+			Name:   "/a-container-name-longer-than-thirty-two-characters",
+			Config: &docker.Config{Hostname: "8dfafdbc3a40"},
+			State:  docker.State{Pid: 42},
+		},
+		Data:   "test",
+		Time:   time.Date(2018, time.March, 5, 9, 8, 7, 0, time.UTC),
+		Source: "stderr",
+	}}
+	// a /etc/host_hostname left by another test takes precedence
+	host := "8dfafdbc3a40"
+	if h := getHostname(); !strings.Contains(h, "{{") {
+		host = h
+	}
+
+	for _, test := range []struct {
+		options  map[string]string
+		expected string
+	}{
+		{map[string]string{"format": "rfc3164"},
+			"<155>Mar  5 09:08:07 " + host + " a-container-name-longer-than-thi[42]: test\n"},
+		{map[string]string{"format": "rfc5424", "facility": "daemon"},
+			"<27>1 2018-03-05T09:08:07Z " + host + " a-container-name-longer-than-thirty-two-characte 42 - - test\n"},
+		{map[string]string{"add_field": `env:production,note:{{a "quoted"] value}}`},
+			"<155>1 2018-03-05T09:08:07Z " + host + " a-container-name-longer-than-thirty-two-characte 42 - [fields@32473 env=\"production\" note=\"{{a \\\"quoted\\\"\\] value}}\"] test\n"},
+		{map[string]string{"add_field": "env:production", "structured_data": "meta language=\"en\""},
+			"<155>1 2018-03-05T09:08:07Z " + host + " a-container-name-longer-than-thirty-two-characte 42 - [meta language=\"en\"][fields@32473 env=\"production\"] test\n"},
+		{map[string]string{"add_field": "env:production", "format": "rfc3164"},
+			"<155>Mar  5 09:08:07 " + host + " a-container-name-longer-than-thi[42]: test\n"},
+	} {
+		tmpl, err := NewTemplate(&router.Route{Options: test.options})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := message.Render(tmpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, tmpl, test.expected, string(out))
+	}
+
+	for _, options := range []map[string]string{{"format": "rfc9999"}, {"facility": "local8"}, {"add_field": "env"}, {"add_field": "a]b:c"}} {
+		if _, err := NewTemplate(&router.Route{Options: options}); err == nil {
+			t.Errorf("expected error for %v", options)
+		}
+	}
+}
+
+func TestSyslogTagMultibyte(t *testing.T) {
+	for _, env := range []string{"SYSLOG_PRIORITY", "SYSLOG_TIMESTAMP", "SYSLOG_PID", "SYSLOG_HOSTNAME", "SYSLOG_TAG", "SYSLOG_DATA", "SYSLOG_STRUCTURED_DATA", "SYSLOG_FACILITY"} {
+		os.Unsetenv(env)
+	}
+	// the byte limits fall in the middle of an "é"
+	message := &Message{Message: &router.Message{
+		Container: &docker.Container{
+			Name:   "/x" + strings.Repeat("é", 30),
+			Config: &docker.Config{Hostname: "8dfafdbc3a40"},
+			State:  docker.State{Pid: 42},
+		},
+		Data: "test",
+		Time: time.Date(2018, time.March, 5, 9, 8, 7, 0, time.UTC),
+	}}
+
+	for _, test := range []struct {
+		format string
+		tag    string
+	}{
+		{"rfc3164", "x" + strings.Repeat("é", 15) + "[42]: "},
+		{"rfc5424", " x" + strings.Repeat("é", 23) + " 42 "},
+	} {
+		tmpl, err := NewTemplate(&router.Route{Options: map[string]string{"format": test.format}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := message.Render(tmpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !utf8.Valid(out) {
+			t.Errorf("%s: invalid UTF-8 in %q", test.format, out)
+		}
+		if !strings.Contains(string(out), test.tag) {
+			t.Errorf("%s: expected tag %q in %q", test.format, test.tag, out)
+		}
+	}
+}
+
+func TestSyslogLabelPriority(t *testing.T) {
+	labelled := func(source string, labels map[string]string) *Message {
+		return &Message{Message: &router.Message{
+			Container: &docker.Container{Config: &docker.Config{Labels: labels}},
+			Source:    source,
+		}}
+	}
+	for _, test := range []struct {
+		message  *Message
+		expected Priority
+	}{
+		{labelled("stdout", nil), logUser | logInfo},
+		{labelled("stdout", map[string]string{facilityLabel: "local3"}), logLocal3 | logInfo},
+		{labelled("stderr", map[string]string{facilityLabel: "local3", severityLabel: "notice"}), logLocal3 | logErr},
+		{labelled("stdout", map[string]string{severityLabel: "DEBUG"}), logUser | logDebug},
+		{labelled("stdout", map[string]string{facilityLabel: "local9", severityLabel: "loud"}), logUser | logInfo},
+	} {
+		if p := test.message.Priority(); p != test.expected {
+			t.Errorf("expected %v for %v got %v", test.expected, test.message.Container.Config.Labels, p)
+		}
+	}
+
+	// the label overrides the facility of the route
+	p, err := labelled("stdout", map[string]string{facilityLabel: "local3"}).PriorityFor("daemon")
+	if err != nil || p != logLocal3|logInfo {
+		t.Errorf("expected %v got %v %v", logLocal3|logInfo, p, err)
+	}
+	if p, _ := labelled("stdout", nil).PriorityFor("daemon"); p != logDaemon|logInfo {
+		t.Errorf("expected %v got %v", logDaemon|logInfo, p)
+	}
+}
+
+func TestSyslogConns(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	type line struct {
+		conn int
+		text string
+	}
+	lines := make(chan line, 100)
+	go func() {
+		for conn := 0; ; conn++ {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn int, c net.Conn) {
+				defer c.Close()
+				scanner := bufio.NewScanner(c)
+				for scanner.Scan() {
+					lines <- line{conn, scanner.Text()}
+				}
+			}(conn, c)
+		}
+	}()
+
+	route := &router.Route{Adapter: "syslog+tcp", Address: ln.Addr().String(), Options: map[string]string{
+		"conns": "3", "template": base64.StdEncoding.EncodeToString([]byte("{{.Container.ID}} {{.Data}}\n")),
+	}}
+	adapter, err := NewSyslogAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adapter.(*Adapter).Close()
+	if n := len(adapter.(*Adapter).workers); n != 3 {
+		t.Fatalf("expected 3 workers got %v", n)
+	}
+	stream := make(chan *router.Message)
+	go adapter.Stream(stream)
+	ids := []string{"a1", "b2", "c3", "d4", "e5", "f6"}
+	for i := 0; i < 5; i++ {
+		for _, id := range ids {
+			stream <- &router.Message{Container: &docker.Container{ID: id}, Data: strconv.Itoa(i), Time: time.Now()}
+		}
+	}
+	close(stream)
+
+	conns := make(map[string]int)
+	next := make(map[string]int)
+	for i := 0; i < 5*len(ids); i++ {
+		var l line
+		select {
+		case l = <-lines:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected %v lines got %v", 5*len(ids), i)
+		}
+		parts := strings.SplitN(l.text, " ", 2)
+		id, seq := parts[0], parts[1]
+		if conn, seen := conns[id]; seen && conn != l.conn {
+			t.Errorf("%s was sent over connections %v and %v", id, conn, l.conn)
+		}
+		conns[id] = l.conn
+		if seq != strconv.Itoa(next[id]) {
+			t.Errorf("%s: expected message %v got %s", id, next[id], seq)
+		}
+		next[id]++
+	}
+	used := make(map[int]bool)
+	for _, conn := range conns {
+		used[conn] = true
+	}
+	if len(used) < 2 {
+		t.Errorf("expected the containers to be spread over the connections got %v", conns)
+	}
+}
+
+func TestSyslogTimestampFormat(t *testing.T) {
+	message := &router.Message{Time: time.Date(2018, time.March, 5, 9, 8, 7, 123456789, time.UTC)}
+	for _, test := range []struct {
+		options            map[string]string
+		timestamp, rfc3164 string
+	}{
+		{map[string]string{}, "2018-03-05T09:08:07Z", "Mar  5 09:08:07"},
+		{map[string]string{"timestamp_format": "2006-01-02T15:04:05.000Z07:00"}, "2018-03-05T09:08:07.123Z", "Mar  5 09:08:07"},
+		{map[string]string{"timezone": "America/New_York"}, "2018-03-05T04:08:07-05:00", "Mar  5 04:08:07"},
+		{map[string]string{"rfc3164_year": "true", "timezone": "Asia/Tokyo"}, "2018-03-05T18:08:07+09:00", "Mar  5 2018 18:08:07"},
+	} {
+		clock, err := newClock(&router.Route{Options: test.options})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := &Message{Message: message, clock: clock}
+		if m.Timestamp() != test.timestamp || m.TimestampRFC3164() != test.rfc3164 {
+			t.Errorf("%v: expected %s and %s got %s and %s", test.options, test.timestamp, test.rfc3164, m.Timestamp(), m.TimestampRFC3164())
+		}
+	}
+	for _, options := range []map[string]string{
+		{"timestamp_format": "iso"}, {"timezone": "Mars/Olympus_Mons"}, {"rfc3164_year": "sometimes"},
+	} {
+		if _, err := newClock(&router.Route{Options: options}); err == nil {
+			t.Errorf("expected error for %v", options)
+		}
+	}
+}
+
+func BenchmarkSyslogRender(b *testing.B) {
+	route := &router.Route{Options: map[string]string{"add_field": "env:production"}}
+	tmpl, err := NewTemplate(route)
+	if err != nil {
+		b.Fatal(err)
+	}
+	clock, err := newClock(route)
+	if err != nil {
+		b.Fatal(err)
+	}
+	m := &Message{Message: &router.Message{Container: container, Data: "test", Time: time.Now(), Source: "stdout"}, clock: clock}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, err := router.Render(tmpl, m)
+		if err != nil {
+			b.Fatal(err)
+		}
+		router.PutBuffer(buf)
+	}
+}
+
+func TestSyslogNewline(t *testing.T) {
+	for _, test := range []struct {
+		newline, in, expected string
+	}{
+		{"keep", "<14>1 - a\nb\n", "<14>1 - a\nb\n"},
+		{"escape", "<14>1 - a\r\nb\n", "<14>1 - a\\r\\nb\n"},
+		{"space", "<14>1 - a\r\nb\nc\n", "<14>1 - a b c\n"},
+		{"octet", "<14>1 - a\nb\n", "11 <14>1 - a\nb"},
+		{"octet", "<14>1 - ab\n", "<14>1 - ab\n"},
+	} {
+		buf := router.GetBuffer()
+		buf.WriteString(test.in)
+		if out := frameNewlines(buf, test.newline).String(); out != test.expected {
+			t.Errorf("expected %q with newline=%s got %q", test.expected, test.newline, out)
+		}
+	}
+
+	for _, test := range []struct {
+		adapter  string
+		datagram bool
+		expected string
+	}{
+		{"syslog+tcp", false, "octet"},
+		{"syslog+udp", true, "keep"},
+		{"syslog+relp", false, "escape"},
+	} {
+		newline, err := getNewline(&router.Route{Adapter: test.adapter, Options: map[string]string{"newline": "octet"}}, test.datagram)
+		if err != nil || newline != test.expected {
+			t.Errorf("expected %s for %s got %s %v", test.expected, test.adapter, newline, err)
+		}
+	}
+	if _, err := getNewline(&router.Route{Options: map[string]string{"newline": "drop"}}, false); err == nil {
+		t.Error("expected an error for newline=drop")
+	}
+}
@@ -0,0 +1,24 @@
+package capabilities
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/gorilla/mux"
+)
+
+func init() {
+	router.HttpHandlers.Register(Capabilities, "capabilities")
+}
+
+// Capabilities returns a http.Handler listing the adapters, transports and
+// processors compiled into this build with their options
+func Capabilities() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/capabilities", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(router.Capabilities.Catalog())
+	}).Methods("GET")
+	return r
+}
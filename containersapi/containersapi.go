@@ -0,0 +1,46 @@
+package containersapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/gorilla/mux"
+)
+
+func init() {
+	router.HttpHandlers.Register(ContainersAPI, "containers")
+}
+
+// ContainersAPI returns a handler for the containers API
+func ContainersAPI() http.Handler {
+	r := mux.NewRouter()
+
+	r.HandleFunc("/containers/{id}/restart-pump", func(w http.ResponseWriter, req *http.Request) {
+		params := mux.Vars(req)
+		var backlog time.Duration
+		if value := req.URL.Query().Get("backlog"); value != "" {
+			var err error
+			if backlog, err = time.ParseDuration(value); err != nil || backlog < 0 {
+				http.Error(w, "Bad request: invalid value for backlog: "+value, http.StatusBadRequest)
+				return
+			}
+		}
+		switch err := router.RestartPump(params["id"], backlog); err {
+		case nil:
+			w.WriteHeader(http.StatusAccepted)
+		case router.ErrNotPumping:
+			http.Error(w, "Not found: "+err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}).Methods("POST")
+
+	r.HandleFunc("/containers/unstreamable", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(router.Unstreamable.All())
+	}).Methods("GET")
+
+	return r
+}
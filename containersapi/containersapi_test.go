@@ -0,0 +1,31 @@
+package containersapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRestartPump(t *testing.T) {
+	api := ContainersAPI()
+	for path, code := range map[string]int{
+		"/containers/abc/restart-pump":             http.StatusNotFound,
+		"/containers/abc/restart-pump?backlog=5m":  http.StatusNotFound,
+		"/containers/abc/restart-pump?backlog=5":   http.StatusBadRequest,
+		"/containers/abc/restart-pump?backlog=-5m": http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		if w.Code != code {
+			t.Errorf("%s: expected %v got %v", path, code, w.Code)
+		}
+	}
+}
+
+func TestUnstreamable(t *testing.T) {
+	w := httptest.NewRecorder()
+	ContainersAPI().ServeHTTP(w, httptest.NewRequest("GET", "/containers/unstreamable", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "[]\n" {
+		t.Errorf("expected no containers got %v %q", w.Code, w.Body.String())
+	}
+}
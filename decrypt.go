@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdh"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gliderlabs/logspout/transports/encrypt"
+)
+
+const decryptUsage = "usage: logspout decrypt --key <private key file> [--listen udp://:5140|tcp://:5140] [<encrypted file>|-]\n       logspout decrypt --genkey <private key file>"
+
+// decrypt writes the messages of routes with encrypt_key to stdout and
+// returns the exit status
+func decrypt(args []string) int {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	keyPath := fs.String("key", "", "file with the base64 private key messages were encrypted for")
+	genKey := fs.String("genkey", "", "write a new private key to this file and print its public key")
+	listen := fs.String("listen", "", "receive encrypted messages at a udp:// or tcp:// address instead of reading them")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, decryptUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *genKey != "" {
+		key, err := encrypt.GenerateKey()
+		if err == nil {
+			err = ioutil.WriteFile(*genKey, []byte(encrypt.EncodeKey(key.Bytes())+"\n"), 0600)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "!!", err)
+			return 1
+		}
+		fmt.Println(encrypt.EncodeKey(key.PublicKey().Bytes()))
+		return 0
+	}
+	if *keyPath == "" || fs.NArg() > 1 || *listen != "" && fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	content, err := ioutil.ReadFile(*keyPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	key, err := encrypt.ParsePrivateKey(string(content))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+
+	if *listen != "" {
+		err = decryptListen(key, *listen, os.Stdout)
+	} else {
+		var in io.Reader = os.Stdin
+		if path := fs.Arg(0); path != "" && path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "!!", err)
+				return 1
+			}
+			defer f.Close()
+			in = f
+		}
+		err = decryptLines(key, in, os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	return 0
+}
+
+// decryptLines writes the messages of the base64 lines of in, sent over
+// streams, to out. Lines that can't be decrypted are reported and skipped.
+func decryptLines(key *ecdh.PrivateKey, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		box, err := base64.StdEncoding.DecodeString(line)
+		if err == nil {
+			var message []byte
+			if message, err = encrypt.Open(key, box); err == nil {
+				if _, err := out.Write(message); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		fmt.Fprintf(os.Stderr, "!! line %d: %s\n", n, err)
+	}
+	return scanner.Err()
+}
+
+// decryptListen writes the messages received at addr to out: each datagram
+// over udp, or each line of the connections over tcp
+func decryptListen(key *ecdh.PrivateKey, addr string, out io.Writer) error {
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid value for listen (must be udp://host:port or tcp://host:port): %s", addr)
+	}
+	switch u.Scheme {
+	case "udp":
+		conn, err := net.ListenPacket("udp", u.Host)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		buf := make([]byte, 65536)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return err
+			}
+			message, err := encrypt.Open(key, buf[:n])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "!! datagram from %s: %s\n", from, err)
+				continue
+			}
+			if _, err := out.Write(message); err != nil {
+				return err
+			}
+		}
+	case "tcp":
+		ln, err := net.Listen("tcp", u.Host)
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		messages := make(chan []byte)
+		go func() {
+			for message := range messages {
+				out.Write(message)
+			}
+		}()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return err
+			}
+			go func() {
+				defer conn.Close()
+				decryptLines(key, conn, writerFunc(func(p []byte) (int, error) {
+					messages <- append([]byte(nil), p...)
+					return len(p), nil
+				}))
+			}()
+		}
+	}
+	return fmt.Errorf("invalid value for listen (must be udp://host:port or tcp://host:port): %s", addr)
+}
+
+// writerFunc writes with a function, here serializing the writes of
+// several connections to stdout
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -2,6 +2,7 @@ package healthcheck
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gliderlabs/logspout/router"
 	"github.com/gorilla/mux"
@@ -9,6 +10,7 @@ import (
 
 func init() {
 	router.HttpHandlers.Register(HealthCheck, "health")
+	router.HttpHandlers.Register(ReadyCheck, "ready")
 }
 
 // HealthCheck returns a http.Handler for the health check
@@ -19,3 +21,19 @@ func HealthCheck() http.Handler {
 	})
 	return r
 }
+
+// ReadyCheck returns a http.Handler answering whether logspout is ready to
+// ship logs, with 503 and the reasons it isn't
+func ReadyCheck() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/ready", func(w http.ResponseWriter, req *http.Request) {
+		ready, reasons := router.Ready.Ready()
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("Not ready: " + strings.Join(reasons, ", ") + "\n"))
+			return
+		}
+		w.Write([]byte("Ready!\n"))
+	})
+	return r
+}
@@ -1,12 +1,20 @@
 package httpstream
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gliderlabs/logspout/router"
 	"github.com/gorilla/mux"
@@ -17,58 +25,241 @@ func init() {
 	router.HttpHandlers.Register(LogStreamer, "logs")
 }
 
+var logger = router.NewLogger("httpstream")
+
 func debug(v ...interface{}) {
-	if os.Getenv("DEBUG") != "" {
-		log.Println(v...)
+	logger.Debugln(v...)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
 	}
+	return value
 }
 
 // LogStreamer returns a http.Handler that can stream logs
 func LogStreamer() http.Handler {
+	maxClients, err := strconv.Atoi(getopt("HTTPSTREAM_MAX_CLIENTS", "0"))
+	if err != nil || maxClients < 0 {
+		logger.Error("invalid value for HTTPSTREAM_MAX_CLIENTS, streaming to any number of clients", "value", getopt("HTTPSTREAM_MAX_CLIENTS", ""))
+		maxClients = 0
+	}
+	buffer, err := strconv.Atoi(getopt("HTTPSTREAM_BUFFER", "1000"))
+	if err != nil || buffer < 1 {
+		logger.Error("invalid value for HTTPSTREAM_BUFFER, buffering 1000 messages", "value", getopt("HTTPSTREAM_BUFFER", ""))
+		buffer = 1000
+	}
+	var clients int32
 	logs := mux.NewRouter()
 	logsHandler := func(w http.ResponseWriter, req *http.Request) {
-		params := mux.Vars(req)
-		route := new(router.Route)
-
-		if params["value"] != "" {
-			switch params["predicate"] {
-			case "id":
-				route.FilterID = params["value"]
-				if len(route.ID) > 12 {
-					route.FilterID = route.FilterID[:12]
-				}
-			case "name":
-				route.FilterName = params["value"]
-			}
+		s, err := newStream(mux.Vars(req), req)
+		if err != nil {
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
 		}
-
-		if route.FilterID != "" && !router.Routes.RoutingFrom(route.FilterID) {
+		if s.route.FilterID != "" && !router.Routes.RoutingFrom(s.route.FilterID) {
 			http.NotFound(w, req)
 			return
 		}
+		if n := atomic.AddInt32(&clients, 1); maxClients > 0 && int(n) > maxClients {
+			atomic.AddInt32(&clients, -1)
+			http.Error(w, "Too many clients streaming logs (HTTPSTREAM_MAX_CLIENTS)", http.StatusServiceUnavailable)
+			return
+		}
+		defer atomic.AddInt32(&clients, -1)
 
+		websocketClient := req.Header.Get("Upgrade") == "websocket"
+		debug("http: logs streamer connected, websocket:", websocketClient, "clients:", atomic.LoadInt32(&clients))
 		defer debug("http: logs streamer disconnected")
-		logstream := make(chan *router.Message)
-		defer close(logstream)
-
-		var closer <-chan bool
-		if req.Header.Get("Upgrade") == "websocket" {
-			debug("http: logs streamer connected [websocket]")
-			closerBi := make(chan bool)
-			defer websocketStreamer(w, req, logstream, closerBi)
-			closer = closerBi
+		queue := s.start(buffer)
+		defer s.stop()
+		if websocketClient {
+			websocketStreamer(w, req, queue)
 		} else {
-			debug("http: logs streamer connected [http]")
-			defer httpStreamer(w, req, logstream, route.MultiContainer())
-			closer = w.(http.CloseNotifier).CloseNotify()
+			httpStreamer(w, req, s, queue)
 		}
-		route.OverrideCloser(closer)
-
-		router.Routes.Route(route, logstream)
 	}
 	logs.HandleFunc("/logs/{predicate:[a-zA-Z]+}:{value}", logsHandler).Methods("GET")
 	logs.HandleFunc("/logs", logsHandler).Methods("GET")
-	return logs
+	logs.HandleFunc("/logs/{container}", tailHandler(Tails)).Methods("GET")
+
+	var h http.Handler = logs
+	if token := os.Getenv("HTTPSTREAM_TOKEN"); token != "" {
+		h = authorized(h, token)
+	}
+	return h
+}
+
+// authorized rejects requests to h without the bearer token, which can
+// also be given as the token query parameter for browser websocket clients
+func authorized(h http.Handler, token string) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		given := req.Header.Get("Authorization")
+		if given == "" && req.URL.Query().Get("token") != "" {
+			given = "Bearer " + req.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="logspout"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// stream is the logs a client streams, and the filters they are selected by
+type stream struct {
+	route     *router.Route
+	match     *regexp.Regexp
+	json      bool
+	logstream chan *router.Message
+	closer    chan bool
+	routing   sync.WaitGroup
+	dropped   int64
+}
+
+// newStream returns the stream selected by the /logs/<predicate>:<value> path
+// and the query parameters of req
+func newStream(params map[string]string, req *http.Request) (*stream, error) {
+	query := req.URL.Query()
+	route := new(router.Route)
+	if params["value"] != "" {
+		switch params["predicate"] {
+		case "id":
+			route.FilterID = params["value"]
+		case "name":
+			route.FilterName = params["value"]
+		default:
+			return nil, errors.New("unknown predicate: " + params["predicate"])
+		}
+	}
+	if id := query.Get("id"); id != "" {
+		route.FilterID = id
+	}
+	if len(route.FilterID) > 12 {
+		route.FilterID = route.FilterID[:12]
+	}
+	if name := query.Get("name"); name != "" {
+		route.FilterName = name
+	}
+	for _, param := range []string{"image", "label", "source", "sources"} {
+		var values []string
+		for _, value := range query[param] {
+			values = append(values, strings.Split(value, ",")...)
+		}
+		switch param {
+		case "image":
+			route.FilterImages = values
+		case "label":
+			route.FilterLabels = values
+		default:
+			route.FilterSources = append(route.FilterSources, values...)
+		}
+	}
+	s := &stream{
+		route:     route,
+		json:      query.Get("format") == "json" || req.Header.Get("Accept") == "application/json",
+		logstream: make(chan *router.Message),
+		closer:    make(chan bool),
+	}
+	if match := query.Get("match"); match != "" {
+		var err error
+		if s.match, err = regexp.Compile(match); err != nil {
+			return nil, errors.New("invalid value for match: " + err.Error())
+		}
+	}
+	route.OverrideCloser(s.closer)
+	return s, nil
+}
+
+// start routes the stream's logs and returns the queue of up to buffer
+// messages they are sent to the client from. Messages are dropped while the
+// queue is full, so slow clients don't hold up the containers' other routes,
+// and the queue is closed once the stream is no longer routed.
+func (s *stream) start(buffer int) chan *router.Message {
+	for _, r := range router.LogRouters.All() {
+		s.routing.Add(1)
+		go func(r router.LogRouter) {
+			r.Route(s.route, s.logstream)
+			s.routing.Done()
+		}(r)
+	}
+	routed := make(chan struct{})
+	go func() {
+		s.routing.Wait()
+		close(routed)
+	}()
+	queue := make(chan *router.Message, buffer)
+	go func() {
+		defer close(queue)
+		for {
+			select {
+			case message := <-s.logstream:
+				if s.match != nil && !s.match.MatchString(message.Data) {
+					continue
+				}
+				select {
+				case queue <- message:
+				default:
+					atomic.AddInt64(&s.dropped, 1)
+				}
+			case <-routed:
+				return
+			}
+		}
+	}()
+	return queue
+}
+
+// stop stops routing the stream's logs once its client is gone
+func (s *stream) stop() {
+	close(s.closer)
+	if dropped := atomic.LoadInt64(&s.dropped); dropped > 0 {
+		logger.Warn("dropped messages for a slow client", "dropped", dropped)
+	}
+}
+
+// Frame is a message as streamed in JSON, with the metadata of its container
+type Frame struct {
+	Time      time.Time         `json:"time"`
+	Source    string            `json:"source"`
+	Data      string            `json:"data"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	Container *FrameContainer   `json:"container,omitempty"`
+}
+
+// FrameContainer is the metadata of a message's container in a Frame
+type FrameContainer struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Image    string            `json:"image"`
+	Hostname string            `json:"hostname,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// NewFrame returns the JSON frame of message
+func NewFrame(message *router.Message) *Frame {
+	frame := &Frame{Time: message.Time, Source: message.Source, Data: message.Data, Fields: message.Fields}
+	if c := message.Container; c != nil {
+		frame.Container = &FrameContainer{ID: c.ID, Name: strings.TrimPrefix(c.Name, "/")}
+		if c.Config != nil {
+			frame.Container.Image = c.Config.Image
+			frame.Container.Hostname = c.Config.Hostname
+			frame.Container.Labels = c.Config.Labels
+		}
+	}
+	return frame
+}
+
+func marshal(obj interface{}) []byte {
+	bytes, err := json.Marshal(obj)
+	if err != nil {
+		logger.Error("marshalling failed", "error", err)
+	}
+	return bytes
 }
 
 // Colorizer adds some color to the log stream
@@ -88,72 +279,77 @@ func (c Colorizer) Get(key string) string {
 	return "\x1b[" + bright + "3" + strconv.Itoa(7-(i%7)) + "m"
 }
 
-func marshal(obj interface{}) []byte {
-	bytes, err := json.MarshalIndent(obj, "", "  ")
-	if err != nil {
-		log.Println("marshal:", err)
-	}
-	return bytes
-}
-
 func normalName(name string) string {
-	return name[1:]
+	return strings.TrimPrefix(name, "/")
 }
 
-func websocketStreamer(w http.ResponseWriter, req *http.Request, logstream chan *router.Message, closer chan bool) {
+// websocketStreamer sends each message as a JSON frame in its own websocket
+// message
+func websocketStreamer(w http.ResponseWriter, req *http.Request, queue chan *router.Message) {
 	websocket.Handler(func(conn *websocket.Conn) {
-		for logline := range logstream {
-			if req.URL.Query().Get("source") != "" && logline.Source != req.URL.Query().Get("source") {
-				continue
-			}
-			_, err := conn.Write(append(marshal(logline), '\n'))
-			if err != nil {
-				closer <- true
+		// clients send nothing, so reading only notices them leaving
+		gone := make(chan struct{})
+		go func() {
+			io.Copy(ioutil.Discard, conn)
+			close(gone)
+		}()
+		for {
+			select {
+			case message, ok := <-queue:
+				if !ok {
+					return
+				}
+				if _, err := conn.Write(marshal(NewFrame(message))); err != nil {
+					return
+				}
+			case <-gone:
 				return
 			}
 		}
 	}).ServeHTTP(w, req)
 }
 
-func httpStreamer(w http.ResponseWriter, req *http.Request, logstream chan *router.Message, multi bool) {
+func httpStreamer(w http.ResponseWriter, req *http.Request, s *stream, queue chan *router.Message) {
 	var colors Colorizer
-	var usecolor, usejson bool
+	var usecolor bool
 	nameWidth := 16
+	multi := s.route.MultiContainer()
 	if req.URL.Query().Get("colors") != "off" {
 		colors = make(Colorizer)
 		usecolor = true
 	}
-	if req.Header.Get("Accept") == "application/json" {
-		w.Header().Add("Content-Type", "application/json")
-		usejson = true
+	if s.json {
+		w.Header().Add("Content-Type", "application/x-ndjson")
 	} else {
 		w.Header().Add("Content-Type", "text/plain")
 	}
-	for logline := range logstream {
-		if req.URL.Query().Get("sources") != "" && logline.Source != req.URL.Query().Get("sources") {
-			continue
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	for {
+		var message *router.Message
+		var ok bool
+		select {
+		case message, ok = <-queue:
+			if !ok {
+				return
+			}
+		case <-req.Context().Done():
+			return
 		}
-		if usejson {
-			w.Write(append(marshal(logline), '\n'))
-		} else {
-			if multi {
-				name := normalName(logline.Container.Name)
-				if len(name) > nameWidth {
-					nameWidth = len(name)
-				}
-				if usecolor {
-					w.Write([]byte(fmt.Sprintf(
-						"%s%"+strconv.Itoa(nameWidth)+"s|%s\x1b[0m\n",
-						colors.Get(name), name, logline.Data,
-					)))
-				} else {
-					w.Write([]byte(fmt.Sprintf(
-						"%"+strconv.Itoa(nameWidth)+"s|%s\n", name, logline.Data,
-					)))
-				}
+		if s.json {
+			w.Write(append(marshal(NewFrame(message)), '\n'))
+		} else if multi && message.Container != nil {
+			name := normalName(message.Container.Name)
+			if len(name) > nameWidth {
+				nameWidth = len(name)
+			}
+			if usecolor {
+				fmt.Fprintf(w, "%s%"+strconv.Itoa(nameWidth)+"s|%s\x1b[0m\n", colors.Get(name), name, message.Data)
 			} else {
-				w.Write(append([]byte(logline.Data), '\n'))
+				fmt.Fprintf(w, "%"+strconv.Itoa(nameWidth)+"s|%s\n", name, message.Data)
 			}
+		} else {
+			w.Write(append([]byte(message.Data), '\n'))
 		}
 		w.(http.Flusher).Flush()
 	}
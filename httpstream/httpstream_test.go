@@ -0,0 +1,146 @@
+package httpstream
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// fakeRouter sends the messages given to it to a stream, then waits for the
+// stream to be closed
+type fakeRouter struct {
+	messages chan []*router.Message
+}
+
+func (f *fakeRouter) RoutingFrom(id string) bool {
+	return true
+}
+
+func (f *fakeRouter) Route(route *router.Route, logstream chan *router.Message) {
+	select {
+	case messages := <-f.messages:
+		for _, message := range messages {
+			logstream <- message
+		}
+	case <-route.Closer():
+		return
+	}
+	<-route.Closer()
+}
+
+var fake = &fakeRouter{messages: make(chan []*router.Message)}
+
+func init() {
+	router.LogRouters.Register(fake, "fake")
+}
+
+var container = &docker.Container{
+	ID:     "3b6ba57db54a0000",
+	Name:   "/web",
+	Config: &docker.Config{Image: "acme/web:1.2", Hostname: "3b6ba57db54a", Labels: map[string]string{"team": "web"}},
+}
+
+func TestNewStream(t *testing.T) {
+	req := httptest.NewRequest("GET", "/logs/name:web*?source=stdout&image=acme/*,!acme/debug&label=team:web&match=^GET&format=json", nil)
+	s, err := newStream(map[string]string{"predicate": "name", "value": "web*"}, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route := s.route
+	if route.FilterName != "web*" || len(route.FilterImages) != 2 || route.FilterLabels[0] != "team:web" ||
+		route.FilterSources[0] != "stdout" || !s.json || !s.match.MatchString("GET /") {
+		t.Errorf("unexpected filters %+v of stream %+v", route, s)
+	}
+	for _, bad := range []string{"/logs?match=(", "/logs/image:acme"} {
+		req := httptest.NewRequest("GET", bad, nil)
+		params := map[string]string{}
+		if strings.Contains(bad, ":") {
+			params = map[string]string{"predicate": "image", "value": "acme"}
+		}
+		if _, err := newStream(params, req); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	h := authorized(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}), "secret")
+	for _, test := range []struct {
+		header, query string
+		status        int
+	}{
+		{"Bearer secret", "", http.StatusOK},
+		{"", "?token=secret", http.StatusOK},
+		{"", "", http.StatusUnauthorized},
+		{"Bearer wrong", "?token=secret", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("GET", "/logs"+test.query, nil)
+		if test.header != "" {
+			req.Header.Set("Authorization", test.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("expected %v for %q %q got %v", test.status, test.header, test.query, w.Code)
+		}
+	}
+}
+
+func TestStreamDropsForSlowClients(t *testing.T) {
+	s, err := newStream(nil, httptest.NewRequest("GET", "/logs", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []*router.Message
+	for i := 0; i < 5; i++ {
+		messages = append(messages, &router.Message{Container: container, Data: "line"})
+	}
+	queue := s.start(2)
+	fake.messages <- messages
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt64(&s.dropped) < 3 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	s.stop()
+	received := 0
+	for range queue {
+		received++
+	}
+	if received != 2 || s.dropped != 3 {
+		t.Errorf("expected 2 messages queued and 3 dropped got %v and %v", received, s.dropped)
+	}
+}
+
+func TestHTTPStreamJSONFrames(t *testing.T) {
+	server := httptest.NewServer(LogStreamer())
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/logs?format=json&match=" + url.QueryEscape("^GET /$"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	fake.messages <- []*router.Message{
+		{Container: container, Source: "stdout", Data: "GET /health", Time: time.Now()},
+		{Container: container, Source: "stdout", Data: "GET /"},
+	}
+	line, err := bufio.NewReader(resp.Body).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frame Frame
+	if err := json.Unmarshal(line, &frame); err != nil {
+		t.Fatal(err)
+	}
+	if frame.Data != "GET /" || frame.Container == nil || frame.Container.Name != "web" ||
+		frame.Container.Image != "acme/web:1.2" || frame.Container.Labels["team"] != "web" {
+		t.Errorf("unexpected frame %s", line)
+	}
+}
@@ -0,0 +1,180 @@
+package httpstream
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/gorilla/mux"
+)
+
+// tailIdle is how long the recent messages of a container that stopped
+// logging, e.g. because it crashed, are kept
+const tailIdle = 24 * time.Hour
+
+// Tails keeps the recent messages of each container for the tail endpoint
+var Tails = &TailBuffer{rings: make(map[string]*ring)}
+
+func init() {
+	router.Jobs.Register(Tails, "tail")
+}
+
+// TailBuffer keeps the last messages of each container in memory, so they
+// can be fetched from the node while the destinations of routes are down
+type TailBuffer struct {
+	mu    sync.Mutex
+	lines int
+	rings map[string]*ring
+}
+
+// ring is the last messages of a container, oldest first from next once
+// full
+type ring struct {
+	name     string
+	messages []*router.Message
+	next     int
+	full     bool
+	last     time.Time
+}
+
+// Name returns the name of the job, if it's enabled
+func (tb *TailBuffer) Name() string {
+	if tb.lines == 0 {
+		return ""
+	}
+	return "tail"
+}
+
+// Setup configures the lines kept per container from HTTPSTREAM_TAIL_BUFFER
+func (tb *TailBuffer) Setup() error {
+	value := getopt("HTTPSTREAM_TAIL_BUFFER", "0")
+	lines, err := strconv.Atoi(value)
+	if err != nil || lines < 0 {
+		return errors.New("invalid value for HTTPSTREAM_TAIL_BUFFER: " + value)
+	}
+	tb.lines = lines
+	return nil
+}
+
+// Run keeps the messages of all containers logspout routes
+func (tb *TailBuffer) Run() error {
+	if tb.lines == 0 {
+		select {}
+	}
+	logstream := make(chan *router.Message)
+	for _, r := range router.LogRouters.All() {
+		go r.Route(new(router.Route), logstream)
+	}
+	sweep := time.NewTicker(time.Hour)
+	defer sweep.Stop()
+	for {
+		select {
+		case message := <-logstream:
+			tb.add(message)
+		case now := <-sweep.C:
+			tb.expire(now)
+		}
+	}
+}
+
+// add keeps message, replacing the oldest message of its container once
+// its ring is full
+func (tb *TailBuffer) add(message *router.Message) {
+	if message.Container == nil {
+		return
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	r, ok := tb.rings[message.Container.ID]
+	if !ok {
+		r = &ring{messages: make([]*router.Message, tb.lines)}
+		tb.rings[message.Container.ID] = r
+	}
+	// containers can be renamed
+	r.name = normalName(message.Container.Name)
+	r.last = time.Now()
+	r.messages[r.next] = message
+	r.next = (r.next + 1) % len(r.messages)
+	r.full = r.full || r.next == 0
+}
+
+// expire forgets the messages of containers that haven't logged for
+// tailIdle
+func (tb *TailBuffer) expire(now time.Time) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	for id, r := range tb.rings {
+		if now.Sub(r.last) > tailIdle {
+			delete(tb.rings, id)
+		}
+	}
+}
+
+// Get returns up to the last n messages of the container with the name or
+// ID, or ID prefix, oldest first, and whether messages of it are kept
+func (tb *TailBuffer) Get(container string, n int) ([]*router.Message, bool) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	var found *ring
+	for id, r := range tb.rings {
+		if r.name == container {
+			found = r
+			break
+		}
+		if strings.HasPrefix(id, container) {
+			found = r
+		}
+	}
+	if found == nil {
+		return nil, false
+	}
+	var messages []*router.Message
+	if found.full {
+		messages = append(messages, found.messages[found.next:]...)
+	}
+	messages = append(messages, found.messages[:found.next]...)
+	if n < len(messages) {
+		messages = messages[len(messages)-n:]
+	}
+	return messages, true
+}
+
+// tailHandler answers GET /logs/<container>?lines=<n> with the last
+// messages kept of the container
+func tailHandler(tb *TailBuffer) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if tb.lines == 0 {
+			http.Error(w, "Not found: recent messages aren't kept (HTTPSTREAM_TAIL_BUFFER)", http.StatusNotFound)
+			return
+		}
+		n := tb.lines
+		if value := req.URL.Query().Get("lines"); value != "" {
+			var err error
+			if n, err = strconv.Atoi(value); err != nil || n < 0 {
+				http.Error(w, "Bad request: invalid value for lines: "+value, http.StatusBadRequest)
+				return
+			}
+		}
+		container := mux.Vars(req)["container"]
+		messages, ok := tb.Get(container, n)
+		if !ok {
+			http.Error(w, "Not found: no messages of container "+container, http.StatusNotFound)
+			return
+		}
+		if req.URL.Query().Get("format") == "json" || req.Header.Get("Accept") == "application/json" {
+			w.Header().Add("Content-Type", "application/x-ndjson")
+			for _, message := range messages {
+				w.Write(append(marshal(NewFrame(message)), '\n'))
+			}
+			return
+		}
+		w.Header().Add("Content-Type", "text/plain")
+		for _, message := range messages {
+			w.Write(append([]byte(message.Data), '\n'))
+		}
+	}
+}
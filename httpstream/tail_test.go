@@ -0,0 +1,86 @@
+package httpstream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestTailBuffer(t *testing.T) {
+	tb := &TailBuffer{lines: 3, rings: make(map[string]*ring)}
+	db := &docker.Container{ID: "9c1d5b3e2f6a0000", Name: "/db"}
+	for i := 0; i < 5; i++ {
+		tb.add(&router.Message{Container: container, Data: "web " + strconv.Itoa(i)})
+	}
+	tb.add(&router.Message{Container: db, Data: "db 0"})
+
+	for _, test := range []struct {
+		container string
+		n         int
+		expected  string
+	}{
+		{"web", 10, "web 2,web 3,web 4"},
+		{"3b6ba57d", 2, "web 3,web 4"},
+		{"db", 3, "db 0"},
+		{"db", 0, ""},
+	} {
+		messages, ok := tb.Get(test.container, test.n)
+		var lines []string
+		for _, message := range messages {
+			lines = append(lines, message.Data)
+		}
+		if !ok || strings.Join(lines, ",") != test.expected {
+			t.Errorf("%s: expected %q got %q", test.container, test.expected, lines)
+		}
+	}
+	if _, ok := tb.Get("api", 3); ok {
+		t.Error("expected no messages of an unknown container")
+	}
+
+	tb.expire(time.Now().Add(tailIdle + time.Minute))
+	if _, ok := tb.Get("web", 3); ok {
+		t.Error("expected the messages of idle containers forgotten")
+	}
+}
+
+func TestTailEndpoint(t *testing.T) {
+	os.Setenv("HTTPSTREAM_TAIL_BUFFER", "100")
+	defer os.Unsetenv("HTTPSTREAM_TAIL_BUFFER")
+	if err := Tails.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { Tails.lines = 0 }()
+	Tails.add(&router.Message{Container: container, Source: "stdout", Data: "first", Time: time.Unix(1500000000, 0)})
+	Tails.add(&router.Message{Container: container, Source: "stdout", Data: "second", Time: time.Unix(1500000001, 0)})
+	logs := LogStreamer()
+
+	for _, test := range []struct {
+		path     string
+		status   int
+		expected string
+	}{
+		{"/logs/web", http.StatusOK, "first\nsecond\n"},
+		{"/logs/web?lines=1", http.StatusOK, "second\n"},
+		{"/logs/3b6ba57db54a?lines=1&format=json", http.StatusOK, `"data":"second"`},
+		{"/logs/api", http.StatusNotFound, ""},
+		{"/logs/web?lines=-1", http.StatusBadRequest, ""},
+	} {
+		w := httptest.NewRecorder()
+		logs.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.status || !strings.Contains(w.Body.String(), test.expected) {
+			t.Errorf("%s: expected %v %q got %v %q", test.path, test.status, test.expected, w.Code, w.Body)
+		}
+	}
+
+	os.Setenv("HTTPSTREAM_TAIL_BUFFER", "lots")
+	if err := Tails.Setup(); err == nil {
+		t.Error("expected an error for an invalid HTTPSTREAM_TAIL_BUFFER")
+	}
+}
@@ -0,0 +1,128 @@
+package aws
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// Client calls AWS APIs that use the JSON protocol
+type Client struct {
+	Service     string
+	Region      string
+	Endpoint    string
+	JSONVersion string
+	Credentials *CredentialsProvider
+	HTTPClient  *http.Client
+}
+
+// NewClient returns a Client for a service in a region. An empty region
+// falls back to AWS_REGION and AWS_DEFAULT_REGION.
+func NewClient(service, region string) (*Client, error) {
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		return nil, fmt.Errorf("aws: no region configured for %s", service)
+	}
+	return &Client{
+		Service:     service,
+		Region:      region,
+		Endpoint:    "https://" + service + "." + region + ".amazonaws.com",
+		JSONVersion: "1.1",
+		Credentials: NewCredentialsProvider(),
+		HTTPClient:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Error is an error returned by an AWS API
+type Error struct {
+	StatusCode int
+	Type       string `json:"__type"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("aws: %s (%d): %s", e.Type, e.StatusCode, e.Message)
+}
+
+// Category returns the delivery error category of the error
+func (e *Error) Category() string {
+	switch e.Type {
+	case "ThrottlingException", "Throttling", "RequestLimitExceeded", "LimitExceededException":
+		return router.ErrorThrottle
+	case "AccessDeniedException", "UnrecognizedClientException", "InvalidSignatureException",
+		"ExpiredTokenException", "IncompleteSignature", "MissingAuthenticationToken":
+		return router.ErrorAuth
+	case "InvalidParameterException", "SerializationException", "ValidationException":
+		return router.ErrorSerialization
+	}
+	return router.HTTPErrorCategory(e.StatusCode)
+}
+
+// Retryable returns whether the request may succeed if sent again
+func (e *Error) Retryable() bool {
+	if e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500 {
+		return true
+	}
+	switch e.Type {
+	case "ThrottlingException", "Throttling", "ServiceUnavailableException",
+		"RequestLimitExceeded", "LimitExceededException":
+		return true
+	}
+	return false
+}
+
+// Call invokes target (e.g. Logs_20140328.PutLogEvents) with in marshalled
+// as the request body, and decodes the response into out if it's not nil
+func (c *Client) Call(target string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.Endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-"+c.JSONVersion)
+	req.Header.Set("X-Amz-Target", target)
+	creds, err := c.Credentials.Get()
+	if err != nil {
+		return router.NewDeliveryError(router.ErrorAuth, err)
+	}
+	Sign(req, body, creds, c.Region, c.Service, time.Now())
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		// field matching is case insensitive, so this handles both "message" and "Message"
+		json.Unmarshal(respBody, apiErr)
+		// __type may be prefixed with a namespace, e.g. "com.amazonaws...#ThrottlingException"
+		if i := strings.LastIndex(apiErr.Type, "#"); i >= 0 {
+			apiErr.Type = apiErr.Type[i+1:]
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
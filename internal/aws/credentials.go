@@ -0,0 +1,182 @@
+package aws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	containerCredentialsHost = "http://169.254.170.2"
+	instanceMetadataHost     = "http://169.254.169.254"
+	// refresh temporary credentials this long before they expire
+	expiryWindow = 5 * time.Minute
+)
+
+// Credentials are the keys used to sign requests to AWS
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+func (c *Credentials) expired(now time.Time) bool {
+	return !c.Expiration.IsZero() && now.Add(expiryWindow).After(c.Expiration)
+}
+
+// CredentialsProvider resolves and caches credentials from, in order, the
+// AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY environment variables, the ECS
+// task role endpoint and the EC2 instance profile
+type CredentialsProvider struct {
+	mu         sync.Mutex
+	creds      *Credentials
+	HTTPClient *http.Client
+}
+
+// NewCredentialsProvider returns a CredentialsProvider using the default chain
+func NewCredentialsProvider() *CredentialsProvider {
+	return &CredentialsProvider{
+		HTTPClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// NewStaticCredentialsProvider returns a CredentialsProvider for the given
+// keys instead of the default chain
+func NewStaticCredentialsProvider(id, secret, token string) *CredentialsProvider {
+	return &CredentialsProvider{
+		creds: &Credentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: token},
+	}
+}
+
+// Get returns valid credentials, refreshing them when they are about to expire
+func (p *CredentialsProvider) Get() (*Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.creds != nil && !p.creds.expired(time.Now()) {
+		return p.creds, nil
+	}
+	creds, err := p.retrieve()
+	if err != nil {
+		return nil, err
+	}
+	p.creds = creds
+	return creds, nil
+}
+
+func (p *CredentialsProvider) retrieve() (*Credentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return &Credentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		return p.containerCredentials(containerCredentialsHost + uri)
+	}
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); uri != "" {
+		return p.containerCredentials(uri)
+	}
+	return p.instanceCredentials()
+}
+
+type metadataCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+func (m *metadataCredentials) credentials() (*Credentials, error) {
+	if m.AccessKeyID == "" {
+		return nil, errors.New("aws: no credentials in metadata response")
+	}
+	return &Credentials{
+		AccessKeyID:     m.AccessKeyID,
+		SecretAccessKey: m.SecretAccessKey,
+		SessionToken:    m.Token,
+		Expiration:      m.Expiration,
+	}, nil
+}
+
+func (p *CredentialsProvider) containerCredentials(uri string) (*Credentials, error) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	body, err := p.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("aws: container credentials: %s", err)
+	}
+	m := new(metadataCredentials)
+	if err := json.Unmarshal(body, m); err != nil {
+		return nil, err
+	}
+	return m.credentials()
+}
+
+func (p *CredentialsProvider) instanceCredentials() (*Credentials, error) {
+	token, err := p.instanceToken()
+	if err != nil {
+		return nil, fmt.Errorf("aws: no credentials found: %s", err)
+	}
+	get := func(path string) ([]byte, error) {
+		req, err := http.NewRequest("GET", instanceMetadataHost+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+		return p.do(req)
+	}
+	const rolePath = "/latest/meta-data/iam/security-credentials/"
+	role, err := get(rolePath)
+	if err != nil {
+		return nil, fmt.Errorf("aws: instance profile: %s", err)
+	}
+	body, err := get(rolePath + strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0]))
+	if err != nil {
+		return nil, fmt.Errorf("aws: instance profile: %s", err)
+	}
+	m := new(metadataCredentials)
+	if err := json.Unmarshal(body, m); err != nil {
+		return nil, err
+	}
+	return m.credentials()
+}
+
+// instanceToken fetches an IMDSv2 session token
+func (p *CredentialsProvider) instanceToken() (string, error) {
+	req, err := http.NewRequest("PUT", instanceMetadataHost+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := p.do(req)
+	return string(token), err
+}
+
+func (p *CredentialsProvider) do(req *http.Request) ([]byte, error) {
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", req.URL, resp.Status)
+	}
+	return body, nil
+}
@@ -0,0 +1,38 @@
+package aws
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSignGetVanilla(t *testing.T) {
+	// get-vanilla from the AWS Signature Version 4 test suite
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := &Credentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	Sign(req, nil, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if actual := req.Header.Get("Authorization"); actual != expected {
+		t.Errorf("expected %s got %s", expected, actual)
+	}
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/?b=2&a=x y&a=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "a=1&a=x%20y&b=2"
+	if actual := canonicalQuery(req.URL.Query()); actual != expected {
+		t.Errorf("expected %s got %s", expected, actual)
+	}
+}
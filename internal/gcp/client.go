@@ -0,0 +1,97 @@
+package gcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// Client calls Google Cloud REST APIs
+type Client struct {
+	Tokens     *TokenProvider
+	HTTPClient *http.Client
+}
+
+// NewClient returns a Client authorized for scope, with credentials read
+// from file if it's not empty
+func NewClient(scope, file string) *Client {
+	return &Client{
+		Tokens:     NewTokenProvider(scope, file),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Error is an error returned by a Google Cloud API
+type Error struct {
+	StatusCode int
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("gcp: %s (%d): %s", e.Status, e.StatusCode, e.Message)
+}
+
+// Category returns the delivery error category of the error
+func (e *Error) Category() string {
+	switch e.Status {
+	case "RESOURCE_EXHAUSTED":
+		return router.ErrorThrottle
+	case "UNAUTHENTICATED", "PERMISSION_DENIED":
+		return router.ErrorAuth
+	case "INVALID_ARGUMENT":
+		return router.ErrorSerialization
+	}
+	return router.HTTPErrorCategory(e.StatusCode)
+}
+
+// Retryable returns whether the request may succeed if sent again
+func (e *Error) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Post sends in marshalled as the request body to url, and decodes the
+// response into out if it's not nil
+func (c *Client) Post(url string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	token, err := c.Tokens.Get()
+	if err != nil {
+		return router.NewDeliveryError(router.ErrorAuth, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &Error{}
+		json.Unmarshal(respBody, &struct {
+			Error *Error `json:"error"`
+		}{apiErr})
+		apiErr.StatusCode = resp.StatusCode
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
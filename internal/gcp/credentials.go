@@ -0,0 +1,240 @@
+package gcp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTokenURI     = "https://oauth2.googleapis.com/token"
+	defaultMetadataHost = "metadata.google.internal"
+	// refresh access tokens this long before they expire
+	expiryWindow = 5 * time.Minute
+)
+
+// Token is an OAuth2 access token
+type Token struct {
+	AccessToken string
+	Expiration  time.Time
+}
+
+func (t *Token) expired(now time.Time) bool {
+	return !t.Expiration.IsZero() && now.Add(expiryWindow).After(t.Expiration)
+}
+
+// credentialsFile is a service account key or the authorized user
+// credentials written by gcloud auth application-default login
+type credentialsFile struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenProvider resolves and caches access tokens from, in order, the
+// credentials file it was created with, the file named by
+// GOOGLE_APPLICATION_CREDENTIALS, the gcloud application default credentials
+// and the metadata server of GCE, GKE and Cloud Run
+type TokenProvider struct {
+	mu         sync.Mutex
+	token      *Token
+	scope      string
+	file       string
+	HTTPClient *http.Client
+}
+
+// NewTokenProvider returns a TokenProvider for scope, reading credentials
+// from file if it's not empty
+func NewTokenProvider(scope, file string) *TokenProvider {
+	return &TokenProvider{
+		scope:      scope,
+		file:       file,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Get returns a valid access token, refreshing it when it's about to expire
+func (p *TokenProvider) Get() (*Token, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token != nil && !p.token.expired(time.Now()) {
+		return p.token, nil
+	}
+	token, err := p.retrieve()
+	if err != nil {
+		return nil, err
+	}
+	p.token = token
+	return token, nil
+}
+
+func (p *TokenProvider) retrieve() (*Token, error) {
+	file := p.file
+	if file == "" {
+		file = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if file == "" {
+		wellKnown := filepath.Join(os.Getenv("HOME"), ".config", "gcloud", "application_default_credentials.json")
+		if _, err := os.Stat(wellKnown); err == nil {
+			file = wellKnown
+		}
+	}
+	if file == "" {
+		return p.metadataToken()
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("gcp: credentials: %s", err)
+	}
+	creds := new(credentialsFile)
+	if err := json.Unmarshal(content, creds); err != nil {
+		return nil, fmt.Errorf("gcp: credentials %s: %s", file, err)
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = defaultTokenURI
+	}
+	switch creds.Type {
+	case "service_account":
+		return p.serviceAccountToken(creds, time.Now())
+	case "authorized_user":
+		return p.exchange(creds.TokenURI, url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {creds.ClientID},
+			"client_secret": {creds.ClientSecret},
+			"refresh_token": {creds.RefreshToken},
+		})
+	}
+	return nil, fmt.Errorf("gcp: credentials %s: unsupported type %q", file, creds.Type)
+}
+
+// serviceAccountToken exchanges a JWT signed with the service account key
+// for an access token
+func (p *TokenProvider) serviceAccountToken(creds *credentialsFile, now time.Time) (*Token, error) {
+	assertion, err := signJWT(creds, p.scope, now)
+	if err != nil {
+		return nil, err
+	}
+	return p.exchange(creds.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+}
+
+func signJWT(creds *credentialsFile, scope string, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return "", errors.New("gcp: credentials: invalid private key")
+	}
+	var key *rsa.PrivateKey
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		var ok bool
+		if key, ok = parsed.(*rsa.PrivateKey); !ok {
+			return "", errors.New("gcp: credentials: private key is not RSA")
+		}
+	} else if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return "", fmt.Errorf("gcp: credentials: %s", err)
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   creds.ClientEmail,
+		"scope": scope,
+		"aud":   creds.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (r *tokenResponse) token() (*Token, error) {
+	if r.AccessToken == "" {
+		return nil, errors.New("gcp: no access token in response")
+	}
+	return &Token{
+		AccessToken: r.AccessToken,
+		Expiration:  time.Now().Add(time.Duration(r.ExpiresIn) * time.Second),
+	}, nil
+}
+
+func (p *TokenProvider) exchange(tokenURI string, form url.Values) (*Token, error) {
+	req, err := http.NewRequest("POST", tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := p.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("gcp: token exchange: %s", err)
+	}
+	r := new(tokenResponse)
+	if err := json.Unmarshal(body, r); err != nil {
+		return nil, err
+	}
+	return r.token()
+}
+
+func (p *TokenProvider) metadataToken() (*Token, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = defaultMetadataHost
+	}
+	req, err := http.NewRequest("GET", "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token?scopes="+url.QueryEscape(p.scope), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	body, err := p.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("gcp: no credentials found: %s", err)
+	}
+	r := new(tokenResponse)
+	if err := json.Unmarshal(body, r); err != nil {
+		return nil, err
+	}
+	return r.token()
+}
+
+func (p *TokenProvider) do(req *http.Request) ([]byte, error) {
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", req.URL, resp.Status)
+	}
+	return body, nil
+}
@@ -0,0 +1,477 @@
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// refs deeper than this are taken to be a cycle
+const maxDepth = 64
+
+// Schema validates JSON documents against a JSON Schema. It implements the
+// validation keywords of draft 7 and later, except format, which is only an
+// annotation, and $ref to the schema's own definitions.
+type Schema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+	refs     map[string]interface{}
+}
+
+// ValidationError is a document not matching a schema, at Path as a JSON
+// pointer into the document
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+	return "jsonschema: " + path + ": " + e.Message
+}
+
+// Load reads the schema in the file at path
+func Load(path string) (*Schema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse returns the schema in data
+func Parse(data []byte) (*Schema, error) {
+	s := &Schema{patterns: make(map[string]*regexp.Regexp), refs: make(map[string]interface{})}
+	if err := json.Unmarshal(data, &s.root); err != nil {
+		return nil, errors.New("jsonschema: invalid schema: " + err.Error())
+	}
+	if err := s.compile(s.root); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// compile checks the subschemas of schema, compiling their patterns and
+// resolving their refs
+func (s *Schema) compile(schema interface{}) error {
+	if _, ok := schema.(bool); ok {
+		return nil
+	}
+	keywords, ok := schema.(map[string]interface{})
+	if !ok {
+		return errors.New("jsonschema: invalid schema: must be an object or a boolean")
+	}
+	if ref, ok := keywords["$ref"].(string); ok {
+		subschema, err := s.resolve(ref)
+		if err != nil {
+			return err
+		}
+		s.refs[ref] = subschema
+	}
+	if pattern, ok := keywords["pattern"].(string); ok {
+		if err := s.compilePattern(pattern); err != nil {
+			return err
+		}
+	}
+	for _, keyword := range []string{"properties", "patternProperties", "definitions", "$defs"} {
+		schemas, _ := keywords[keyword].(map[string]interface{})
+		for name, subschema := range schemas {
+			if keyword == "patternProperties" {
+				if err := s.compilePattern(name); err != nil {
+					return err
+				}
+			}
+			if err := s.compile(subschema); err != nil {
+				return err
+			}
+		}
+	}
+	for _, keyword := range []string{"items", "additionalItems", "additionalProperties", "contains", "not", "if", "then", "else"} {
+		subschema, ok := keywords[keyword]
+		if !ok {
+			continue
+		}
+		if items, ok := subschema.([]interface{}); ok && keyword == "items" {
+			for _, item := range items {
+				if err := s.compile(item); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := s.compile(subschema); err != nil {
+			return err
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		subschemas, _ := keywords[keyword].([]interface{})
+		for _, subschema := range subschemas {
+			if err := s.compile(subschema); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) compilePattern(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("jsonschema: invalid pattern %q: %v", pattern, err)
+	}
+	s.patterns[pattern] = re
+	return nil
+}
+
+// resolve returns the subschema at ref, a JSON pointer fragment into the
+// schema such as #/definitions/level
+func (s *Schema) resolve(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, errors.New("jsonschema: unsupported $ref (must be within the schema): " + ref)
+	}
+	pointer, err := url.PathUnescape(ref[1:])
+	if err != nil {
+		return nil, errors.New("jsonschema: invalid $ref: " + ref)
+	}
+	node := s.root
+	if pointer == "" {
+		return node, nil
+	}
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		var ok bool
+		switch parent := node.(type) {
+		case map[string]interface{}:
+			node, ok = parent[token]
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if ok = err == nil && i >= 0 && i < len(parent); ok {
+				node = parent[i]
+			}
+		}
+		if !ok {
+			return nil, errors.New("jsonschema: unresolved $ref: " + ref)
+		}
+	}
+	return node, nil
+}
+
+func escape(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
+
+// Validate returns a ValidationError if the JSON document isn't valid
+// against the schema
+func (s *Schema) Validate(document []byte) error {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	if err := decoder.Decode(&value); err != nil {
+		return &ValidationError{Message: "invalid JSON: " + err.Error()}
+	}
+	if decoder.More() {
+		return &ValidationError{Message: "invalid JSON: more than one value"}
+	}
+	return s.validate(s.root, value, "", 0)
+}
+
+func (s *Schema) validate(schema, value interface{}, path string, depth int) error {
+	if depth > maxDepth {
+		return &ValidationError{Path: path, Message: "$ref nested too deep"}
+	}
+	invalid := func(format string, args ...interface{}) error {
+		return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+	if allowed, ok := schema.(bool); ok {
+		if !allowed {
+			return invalid("not allowed")
+		}
+		return nil
+	}
+	keywords := schema.(map[string]interface{})
+	if ref, ok := keywords["$ref"].(string); ok {
+		if err := s.validate(s.refs[ref], value, path, depth+1); err != nil {
+			return err
+		}
+	}
+	if types, ok := keywords["type"]; ok && !hasType(value, types) {
+		return invalid("must be of type %s, not %s", typeNames(types), typeOf(value))
+	}
+	if enum, ok := keywords["enum"].([]interface{}); ok && !member(enum, value) {
+		return invalid("must be one of %s", marshal(enum))
+	}
+	if constant, ok := keywords["const"]; ok && !reflect.DeepEqual(constant, value) {
+		return invalid("must be %s", marshal(constant))
+	}
+	switch value := value.(type) {
+	case float64:
+		if err := s.validateNumber(keywords, value, invalid); err != nil {
+			return err
+		}
+	case string:
+		length := float64(utf8.RuneCountInString(value))
+		if min, ok := keywords["minLength"].(float64); ok && length < min {
+			return invalid("must be at least %v characters", min)
+		}
+		if max, ok := keywords["maxLength"].(float64); ok && length > max {
+			return invalid("must be at most %v characters", max)
+		}
+		if pattern, ok := keywords["pattern"].(string); ok && !s.patterns[pattern].MatchString(value) {
+			return invalid("must match %q", pattern)
+		}
+	case []interface{}:
+		if err := s.validateArray(keywords, value, path, depth, invalid); err != nil {
+			return err
+		}
+	case map[string]interface{}:
+		if err := s.validateObject(keywords, value, path, depth, invalid); err != nil {
+			return err
+		}
+	}
+	return s.validateApplicators(keywords, value, path, depth, invalid)
+}
+
+func (s *Schema) validateNumber(keywords map[string]interface{}, value float64, invalid func(string, ...interface{}) error) error {
+	if min, ok := keywords["minimum"].(float64); ok && value < min {
+		return invalid("must be at least %v", min)
+	}
+	if max, ok := keywords["maximum"].(float64); ok && value > max {
+		return invalid("must be at most %v", max)
+	}
+	if min, ok := keywords["exclusiveMinimum"].(float64); ok && value <= min {
+		return invalid("must be greater than %v", min)
+	}
+	if max, ok := keywords["exclusiveMaximum"].(float64); ok && value >= max {
+		return invalid("must be less than %v", max)
+	}
+	if factor, ok := keywords["multipleOf"].(float64); ok && factor > 0 {
+		if q := value / factor; math.Abs(q-math.Round(q)) > 1e-9 {
+			return invalid("must be a multiple of %v", factor)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateArray(keywords map[string]interface{}, value []interface{}, path string, depth int, invalid func(string, ...interface{}) error) error {
+	length := float64(len(value))
+	if min, ok := keywords["minItems"].(float64); ok && length < min {
+		return invalid("must have at least %v items", min)
+	}
+	if max, ok := keywords["maxItems"].(float64); ok && length > max {
+		return invalid("must have at most %v items", max)
+	}
+	if unique, _ := keywords["uniqueItems"].(bool); unique {
+		for i := range value {
+			for j := i + 1; j < len(value); j++ {
+				if reflect.DeepEqual(value[i], value[j]) {
+					return invalid("must have unique items, %v and %v are equal", i, j)
+				}
+			}
+		}
+	}
+	switch items := keywords["items"].(type) {
+	case []interface{}:
+		// tuple validation, with additionalItems for the rest
+		for i, item := range value {
+			subschema, ok := keywords["additionalItems"]
+			if i < len(items) {
+				subschema, ok = items[i], true
+			}
+			if !ok {
+				break
+			}
+			if err := s.validate(subschema, item, path+"/"+strconv.Itoa(i), depth); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		for i, item := range value {
+			if err := s.validate(items, item, path+"/"+strconv.Itoa(i), depth); err != nil {
+				return err
+			}
+		}
+	}
+	if contains, ok := keywords["contains"]; ok {
+		for _, item := range value {
+			if s.validate(contains, item, path, depth) == nil {
+				return nil
+			}
+		}
+		return invalid("must contain an item matching %s", marshal(contains))
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(keywords map[string]interface{}, value map[string]interface{}, path string, depth int, invalid func(string, ...interface{}) error) error {
+	length := float64(len(value))
+	if min, ok := keywords["minProperties"].(float64); ok && length < min {
+		return invalid("must have at least %v properties", min)
+	}
+	if max, ok := keywords["maxProperties"].(float64); ok && length > max {
+		return invalid("must have at most %v properties", max)
+	}
+	required, _ := keywords["required"].([]interface{})
+	for _, name := range required {
+		if name, ok := name.(string); ok {
+			if _, ok := value[name]; !ok {
+				return invalid("missing required property %q", name)
+			}
+		}
+	}
+	properties, _ := keywords["properties"].(map[string]interface{})
+	patternProperties, _ := keywords["patternProperties"].(map[string]interface{})
+	additional, hasAdditional := keywords["additionalProperties"]
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	// sorted, so the same document always reports the same error
+	sort.Strings(names)
+	for _, name := range names {
+		property := path + "/" + escape(name)
+		matched := false
+		if subschema, ok := properties[name]; ok {
+			matched = true
+			if err := s.validate(subschema, value[name], property, depth); err != nil {
+				return err
+			}
+		}
+		for pattern, subschema := range patternProperties {
+			if s.patterns[pattern].MatchString(name) {
+				matched = true
+				if err := s.validate(subschema, value[name], property, depth); err != nil {
+					return err
+				}
+			}
+		}
+		if !matched && hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				return invalid("unexpected property %q", name)
+			}
+			if err := s.validate(additional, value[name], property, depth); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateApplicators(keywords map[string]interface{}, value interface{}, path string, depth int, invalid func(string, ...interface{}) error) error {
+	if allOf, ok := keywords["allOf"].([]interface{}); ok {
+		for _, subschema := range allOf {
+			if err := s.validate(subschema, value, path, depth); err != nil {
+				return err
+			}
+		}
+	}
+	if anyOf, ok := keywords["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, subschema := range anyOf {
+			if s.validate(subschema, value, path, depth) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return invalid("must match at least one schema of anyOf")
+		}
+	}
+	if oneOf, ok := keywords["oneOf"].([]interface{}); ok {
+		matched := 0
+		for _, subschema := range oneOf {
+			if s.validate(subschema, value, path, depth) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return invalid("must match exactly one schema of oneOf, matched %v", matched)
+		}
+	}
+	if not, ok := keywords["not"]; ok && s.validate(not, value, path, depth) == nil {
+		return invalid("must not match %s", marshal(not))
+	}
+	if condition, ok := keywords["if"]; ok {
+		branch := "else"
+		if s.validate(condition, value, path, depth) == nil {
+			branch = "then"
+		}
+		if subschema, ok := keywords[branch]; ok {
+			return s.validate(subschema, value, path, depth)
+		}
+	}
+	return nil
+}
+
+// hasType returns whether value has the type, or one of the types, of the
+// type keyword
+func hasType(value interface{}, types interface{}) bool {
+	switch types := types.(type) {
+	case string:
+		actual := typeOf(value)
+		if types == "number" && actual == "integer" {
+			return true
+		}
+		return actual == types
+	case []interface{}:
+		for _, t := range types {
+			if hasType(value, t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func typeNames(types interface{}) string {
+	if name, ok := types.(string); ok {
+		return name
+	}
+	return marshal(types)
+}
+
+func typeOf(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func member(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func marshal(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
@@ -0,0 +1,96 @@
+package jsonschema
+
+import (
+	"strings"
+	"testing"
+)
+
+const eventSchema = `{
+	"type": "object",
+	"required": ["time", "level", "message"],
+	"properties": {
+		"time": {"type": "string", "pattern": "^\\d{4}-\\d{2}-\\d{2}T"},
+		"level": {"$ref": "#/definitions/level"},
+		"message": {"type": "string", "minLength": 1},
+		"status": {"type": "integer", "minimum": 100, "exclusiveMaximum": 600},
+		"tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true, "maxItems": 3}
+	},
+	"patternProperties": {"^x-": {"type": "string"}},
+	"additionalProperties": false,
+	"definitions": {
+		"level": {"enum": ["debug", "info", "warn", "error"]}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+	schema, err := Parse([]byte(eventSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		document string
+		err      string
+	}{
+		{`{"time": "2018-03-05T09:08:07Z", "level": "info", "message": "started"}`, ""},
+		{`{"time": "2018-03-05T09:08:07Z", "level": "info", "message": "ok", "status": 200, "tags": ["a"], "x-trace": "1"}` + "\n", ""},
+		{`{"time": "2018-03-05T09:08:07Z", "level": "info"}`, `/: missing required property "message"`},
+		{`{"time": "yesterday", "level": "info", "message": "m"}`, `/time: must match`},
+		{`{"time": "2018-03-05T09:08:07Z", "level": "fatal", "message": "m"}`, `/level: must be one of ["debug","info","warn","error"]`},
+		{`{"time": "2018-03-05T09:08:07Z", "level": "info", "message": "m", "status": 200.5}`, `/status: must be of type integer, not number`},
+		{`{"time": "2018-03-05T09:08:07Z", "level": "info", "message": "m", "status": 600}`, `/status: must be less than 600`},
+		{`{"time": "2018-03-05T09:08:07Z", "level": "info", "message": "m", "tags": ["a", "a"]}`, `/tags: must have unique items`},
+		{`{"time": "2018-03-05T09:08:07Z", "level": "info", "message": "m", "tags": [1]}`, `/tags/0: must be of type string`},
+		{`{"time": "2018-03-05T09:08:07Z", "level": "info", "message": "m", "host": "a"}`, `/: unexpected property "host"`},
+		{`{"time": "2018-03-05T09:08:07Z", "level": "info", "message": "m", "x-trace": 1}`, `/x-trace: must be of type string`},
+		{`["time"]`, `/: must be of type object, not array`},
+		{`started`, `/: invalid JSON`},
+		{`{} {}`, `/: invalid JSON: more than one value`},
+	}
+	for _, c := range cases {
+		err := schema.Validate([]byte(c.document))
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("expected %s valid got %v", c.document, err)
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("expected %s invalid with %q got %v", c.document, c.err, err)
+		}
+	}
+}
+
+func TestValidateApplicators(t *testing.T) {
+	schema, err := Parse([]byte(`{
+		"anyOf": [{"type": "string"}, {"type": "object", "required": ["message"]}],
+		"not": {"const": ""},
+		"if": {"type": "object"}, "then": {"properties": {"message": {"type": "string"}}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for document, valid := range map[string]bool{
+		`"started"`:                true,
+		`""`:                       false,
+		`{"message": "m"}`:         true,
+		`{"message": 1}`:           false,
+		`{"msg": "m"}`:             false,
+		`42`:                       false,
+		`{"message": "m", "n": 1}`: true,
+	} {
+		if err := schema.Validate([]byte(document)); (err == nil) != valid {
+			t.Errorf("expected %s valid %v got %v", document, valid, err)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, schema := range []string{
+		`{"type": "object"`,
+		`[]`,
+		`{"properties": {"time": {"pattern": "("}}}`,
+		`{"$ref": "#/definitions/missing"}`,
+		`{"$ref": "https://example.com/schema.json"}`,
+	} {
+		if _, err := Parse([]byte(schema)); err == nil {
+			t.Errorf("expected an error for %s", schema)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// refresh access tokens this long before they expire
+const expiryWindow = time.Minute
+
+// Options are the route options configuring OAuth2 client credentials
+var Options = []string{
+	"oauth2_token_url", "oauth2_client_id", "oauth2_client_secret",
+	"oauth2_scope", "oauth2_audience", "oauth2_auth_style",
+}
+
+// Config is an OAuth2 client of the client credentials grant
+type Config struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	// Scope is space separated, e.g. api://logs/.default for Azure AD
+	Scope string
+	// Audience is the API tokens are requested for, as Auth0 and Okta expect
+	Audience string
+	// BasicAuth sends the client credentials with HTTP basic auth rather
+	// than in the request body
+	BasicAuth bool
+}
+
+// Token is an OAuth2 access token
+type Token struct {
+	AccessToken string
+	Expiration  time.Time
+}
+
+func (t *Token) expired(now time.Time) bool {
+	return !t.Expiration.IsZero() && now.After(t.Expiration)
+}
+
+func (t *Token) expiring(now time.Time) bool {
+	return !t.Expiration.IsZero() && now.Add(expiryWindow).After(t.Expiration)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+// RouteConfig returns the client configured by the oauth2_* options of a
+// route, or OAUTH2_TOKEN_URL, OAUTH2_CLIENT_ID and so on, or nil when no
+// token URL is set
+func RouteConfig(route *router.Route) (*Config, error) {
+	tokenURL := router.RouteOpt(route, "oauth2_token_url", "OAUTH2_TOKEN_URL", "")
+	if tokenURL == "" {
+		return nil, nil
+	}
+	if u, err := url.Parse(tokenURL); err != nil || u.Host == "" {
+		return nil, errors.New("oauth2: invalid value for oauth2_token_url: " + tokenURL)
+	}
+	c := &Config{
+		TokenURL:     tokenURL,
+		ClientID:     router.RouteOpt(route, "oauth2_client_id", "OAUTH2_CLIENT_ID", ""),
+		ClientSecret: router.RouteOpt(route, "oauth2_client_secret", "OAUTH2_CLIENT_SECRET", ""),
+		Scope:        router.RouteOpt(route, "oauth2_scope", "OAUTH2_SCOPE", ""),
+		Audience:     router.RouteOpt(route, "oauth2_audience", "OAUTH2_AUDIENCE", ""),
+	}
+	if c.ClientID == "" || c.ClientSecret == "" {
+		return nil, errors.New("oauth2: oauth2_client_id and oauth2_client_secret are required with oauth2_token_url")
+	}
+	switch style := router.RouteOpt(route, "oauth2_auth_style", "OAUTH2_AUTH_STYLE", "post"); style {
+	case "post":
+	case "basic":
+		c.BasicAuth = true
+	default:
+		return nil, errors.New("oauth2: invalid value for oauth2_auth_style (must be post or basic): " + style)
+	}
+	return c, nil
+}
+
+var (
+	sourcesMu sync.Mutex
+	sources   = make(map[Config]*TokenSource)
+)
+
+// TokenSource fetches and caches the access tokens of a client, refreshing
+// them shortly before they expire
+type TokenSource struct {
+	mu         sync.Mutex
+	config     Config
+	token      *Token
+	HTTPClient *http.Client
+}
+
+// NewTokenSource returns the TokenSource of config, shared by the routes
+// with the same client so they don't each fetch tokens
+func NewTokenSource(config *Config) *TokenSource {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	if ts, ok := sources[*config]; ok {
+		return ts
+	}
+	ts := &TokenSource{
+		config:     *config,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	sources[*config] = ts
+	return ts
+}
+
+// Get returns a valid access token, fetching a new one when it's about to
+// expire. The cached token is returned while it's still valid if the
+// refresh fails.
+func (ts *TokenSource) Get() (*Token, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	now := time.Now()
+	if ts.token != nil && !ts.token.expiring(now) {
+		return ts.token, nil
+	}
+	token, err := ts.fetch()
+	if err != nil {
+		if ts.token != nil && !ts.token.expired(now) {
+			return ts.token, nil
+		}
+		return nil, router.NewDeliveryError(router.ErrorAuth, err)
+	}
+	ts.token = token
+	return token, nil
+}
+
+// Invalidate drops the cached token if it is token, for destinations that
+// rejected it before it expired
+func (ts *TokenSource) Invalidate(token *Token) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token == token {
+		ts.token = nil
+	}
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (ts *TokenSource) fetch() (*Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if ts.config.Scope != "" {
+		form.Set("scope", ts.config.Scope)
+	}
+	if ts.config.Audience != "" {
+		form.Set("audience", ts.config.Audience)
+	}
+	if !ts.config.BasicAuth {
+		form.Set("client_id", ts.config.ClientID)
+		form.Set("client_secret", ts.config.ClientSecret)
+	}
+	req, err := http.NewRequest("POST", ts.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if ts.config.BasicAuth {
+		req.SetBasicAuth(url.QueryEscape(ts.config.ClientID), url.QueryEscape(ts.config.ClientSecret))
+	}
+	resp, err := ts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oauth2: token request: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("oauth2: token request: %s", err)
+	}
+	r := new(tokenResponse)
+	json.Unmarshal(body, r)
+	if resp.StatusCode != http.StatusOK {
+		if r.Error != "" {
+			return nil, fmt.Errorf("oauth2: token request: %s: %s %s", resp.Status, r.Error, r.Description)
+		}
+		return nil, fmt.Errorf("oauth2: token request: %s", resp.Status)
+	}
+	if r.AccessToken == "" {
+		return nil, errors.New("oauth2: no access token in response")
+	}
+	token := &Token{AccessToken: r.AccessToken}
+	if r.ExpiresIn > 0 {
+		token.Expiration = time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// Transport authorizes requests with the bearer tokens of Source. A request
+// rejected with 401 Unauthorized is sent again once with a new token, for
+// tokens revoked before they expire.
+type Transport struct {
+	Source *TokenSource
+	// Base is the transport requests are sent with, http.DefaultTransport
+	// if nil
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	token, err := t.Source.Get()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := base.RoundTrip(authorize(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.GetBody == nil) {
+		return resp, err
+	}
+	retry := authorize(req, nil)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	t.Source.Invalidate(token)
+	if token, err = t.Source.Get(); err != nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+	return base.RoundTrip(authorize(retry, token))
+}
+
+// authorize returns a copy of req with the token as its bearer token, as
+// round trippers must not modify requests
+func authorize(req *http.Request, token *Token) *http.Request {
+	r := req.Clone(req.Context())
+	if token != nil {
+		r.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	}
+	return r
+}
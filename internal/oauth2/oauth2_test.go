@@ -0,0 +1,139 @@
+package oauth2
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// fakeIssuer issues tokens token-1, token-2 and so on for the client
+// credentials of logspout:secret
+type fakeIssuer struct {
+	sync.Mutex
+	issued    int
+	expiresIn int
+	forms     []string
+	down      bool
+}
+
+func (f *fakeIssuer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if f.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	req.ParseForm()
+	id, secret, basic := req.BasicAuth()
+	if !basic {
+		id, secret = req.Form.Get("client_id"), req.Form.Get("client_secret")
+	}
+	if req.Form.Get("grant_type") != "client_credentials" || id != "logspout" || secret != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": "invalid_client", "error_description": "bad credentials"}`))
+		return
+	}
+	f.forms = append(f.forms, req.Form.Get("scope")+" "+req.Form.Get("audience"))
+	f.issued++
+	fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": %d}`, f.issued, f.expiresIn)
+}
+
+func TestTokenSourceCachesTokens(t *testing.T) {
+	issuer := &fakeIssuer{expiresIn: 3600}
+	server := httptest.NewServer(issuer)
+	defer server.Close()
+	config, err := RouteConfig(&router.Route{Options: map[string]string{
+		"oauth2_token_url":     server.URL,
+		"oauth2_client_id":     "logspout",
+		"oauth2_client_secret": "secret",
+		"oauth2_scope":         "api://logs/.default",
+		"oauth2_auth_style":    "basic",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := NewTokenSource(config)
+	if NewTokenSource(config) != ts {
+		t.Error("expected routes with the same client to share a token source")
+	}
+	for i := 0; i < 3; i++ {
+		token, err := ts.Get()
+		if err != nil || token.AccessToken != "token-1" {
+			t.Fatalf("expected the cached token-1 got %v %v", token, err)
+		}
+	}
+	// refreshed shortly before expiring, keeping it while the issuer is down
+	ts.token.Expiration = time.Now().Add(expiryWindow / 2)
+	issuer.Lock()
+	issuer.down = true
+	issuer.Unlock()
+	if token, err := ts.Get(); err != nil || token.AccessToken != "token-1" {
+		t.Errorf("expected the unexpired token-1 while the issuer is down got %v %v", token, err)
+	}
+	issuer.Lock()
+	issuer.down = false
+	issuer.Unlock()
+	if token, err := ts.Get(); err != nil || token.AccessToken != "token-2" {
+		t.Errorf("expected the refreshed token-2 got %v %v", token, err)
+	}
+	if len(issuer.forms) != 2 || issuer.forms[0] != "api://logs/.default " {
+		t.Errorf("unexpected token requests %v", issuer.forms)
+	}
+}
+
+func TestTokenSourceErrors(t *testing.T) {
+	server := httptest.NewServer(new(fakeIssuer))
+	defer server.Close()
+	ts := NewTokenSource(&Config{TokenURL: server.URL, ClientID: "logspout", ClientSecret: "wrong"})
+	_, err := ts.Get()
+	if err == nil || router.ErrorCategory(err) != router.ErrorAuth || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("expected an auth error got %v", err)
+	}
+	for _, options := range []map[string]string{
+		{"oauth2_token_url": "not a url"},
+		{"oauth2_token_url": server.URL, "oauth2_client_id": "logspout"},
+		{"oauth2_token_url": server.URL, "oauth2_client_id": "logspout", "oauth2_client_secret": "secret", "oauth2_auth_style": "header"},
+	} {
+		if _, err := RouteConfig(&router.Route{Options: options}); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+	if config, err := RouteConfig(&router.Route{Options: map[string]string{}}); config != nil || err != nil {
+		t.Errorf("expected no client without a token url got %v %v", config, err)
+	}
+}
+
+func TestTransportRetriesRevokedTokens(t *testing.T) {
+	issuer := new(fakeIssuer)
+	issuerServer := httptest.NewServer(issuer)
+	defer issuerServer.Close()
+	var bodies []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, req.Header.Get("Authorization")+" "+string(body))
+		// token-1 was revoked
+		if req.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer api.Close()
+
+	client := &http.Client{Transport: &Transport{
+		Source: NewTokenSource(&Config{TokenURL: issuerServer.URL, ClientID: "logspout", ClientSecret: "secret", Audience: "logs"}),
+	}}
+	resp, err := client.Post(api.URL, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || strings.Join(bodies, ", ") != "Bearer token-1 hello, Bearer token-2 hello" {
+		t.Errorf("expected the request sent again with a new token got %v: %v", resp.Status, bodies)
+	}
+}
@@ -0,0 +1,206 @@
+// Package otlp sends OpenTelemetry data to collectors as OTLP/HTTP JSON
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Span kinds
+const (
+	SpanKindInternal = 1
+	SpanKindClient   = 3
+)
+
+// Status codes of spans
+const (
+	StatusUnset = 0
+	StatusOK    = 1
+	StatusError = 2
+)
+
+// KeyValue is an attribute
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue is the value of an attribute, or the body of a log record.
+// 64-bit integers are strings in OTLP JSON.
+type AnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    string  `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+// String returns a string attribute
+func String(key, value string) KeyValue {
+	return KeyValue{Key: key, Value: AnyValue{StringValue: &value}}
+}
+
+// Int returns an integer attribute
+func Int(key string, value int64) KeyValue {
+	return KeyValue{Key: key, Value: AnyValue{IntValue: strconv.FormatInt(value, 10)}}
+}
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) KeyValue {
+	return KeyValue{Key: key, Value: AnyValue{BoolValue: &value}}
+}
+
+// Resource is the entity producing telemetry, e.g. a logspout instance
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+// Scope is the instrumentation producing telemetry
+type Scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// Span is a timed operation of a trace. IDs are hex encoded.
+type Span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []KeyValue `json:"attributes,omitempty"`
+	Status            *Status    `json:"status,omitempty"`
+}
+
+// Status is the outcome of a span
+type Status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// ScopeSpans is the spans of a scope
+type ScopeSpans struct {
+	Scope Scope   `json:"scope"`
+	Spans []*Span `json:"spans"`
+}
+
+// ResourceSpans is the spans of a resource
+type ResourceSpans struct {
+	Resource   Resource     `json:"resource"`
+	ScopeSpans []ScopeSpans `json:"scopeSpans"`
+}
+
+// TracesRequest is the body of a request exporting spans
+type TracesRequest struct {
+	ResourceSpans []ResourceSpans `json:"resourceSpans"`
+}
+
+// UnixNano returns t as the nanoseconds since the epoch of OTLP JSON
+func UnixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// Endpoint returns the URL to export a signal, e.g. traces, to: signalURL
+// as is, the OTEL_EXPORTER_OTLP_<SIGNAL>_ENDPOINT convention, or else the
+// signal's path under base, like OTEL_EXPORTER_OTLP_ENDPOINT
+func Endpoint(base, signalURL, signal string) string {
+	if signalURL != "" {
+		return signalURL
+	}
+	return strings.TrimSuffix(base, "/") + "/v1/" + signal
+}
+
+// ParseHeaders parses headers in the format of OTEL_EXPORTER_OTLP_HEADERS,
+// comma separated key=value pairs with URL encoded values
+func ParseHeaders(value string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("otlp: invalid header: %s", pair)
+		}
+		v, err := url.QueryUnescape(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("otlp: invalid header: %s", pair)
+		}
+		headers[strings.TrimSpace(kv[0])] = v
+	}
+	return headers, nil
+}
+
+// Exporter posts OTLP requests to a collector
+type Exporter struct {
+	URL        string
+	Headers    map[string]string
+	HTTPClient *http.Client
+}
+
+// NewExporter returns an Exporter posting to url with headers
+func NewExporter(url string, headers map[string]string) *Exporter {
+	return &Exporter{
+		URL:        url,
+		Headers:    headers,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Error is a request a collector didn't accept
+type Error struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("otlp: %s: %s", e.Status, e.Message)
+}
+
+// Retryable returns whether the request may succeed if sent again, as the
+// OTLP specification lists
+func (e *Error) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Export sends in marshalled as JSON to the collector
+func (e *Exporter) Export(in interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", e.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "logspout")
+	for key, value := range e.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := e.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &Error{StatusCode: resp.StatusCode, Status: resp.Status, Message: string(bytes.TrimSpace(message))}
+}
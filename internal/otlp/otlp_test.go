@@ -0,0 +1,37 @@
+package otlp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := ParseHeaders("Authorization=Bearer%20token, X-Tenant=acme")
+	if err != nil || headers["Authorization"] != "Bearer token" || headers["X-Tenant"] != "acme" {
+		t.Errorf("unexpected headers %v %v", headers, err)
+	}
+	if _, err := ParseHeaders("nokey"); err == nil {
+		t.Error("expected an error for a header without a value")
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	if url := Endpoint("http://collector:4318/", "", "traces"); url != "http://collector:4318/v1/traces" {
+		t.Errorf("unexpected url %v", url)
+	}
+	if url := Endpoint("http://collector:4318", "http://traces:4318/custom", "traces"); url != "http://traces:4318/custom" {
+		t.Errorf("expected the signal's endpoint as is got %v", url)
+	}
+}
+
+func TestExportError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	err := NewExporter(server.URL, nil).Export(&TracesRequest{})
+	if e, ok := err.(*Error); !ok || !e.Retryable() || e.Message != "slow down" {
+		t.Errorf("expected a retryable error got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package protowire
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// FrameHeader is the size of the header framing gRPC messages, a
+// compressed flag and a 4 byte length
+const FrameHeader = 5
+
+// Frame returns message framed for a gRPC request, uncompressed
+func Frame(message []byte) []byte {
+	frame := make([]byte, FrameHeader, FrameHeader+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...)
+}
+
+// Unframe returns the message of a gRPC response holding a single
+// uncompressed message, and false if it holds anything else
+func Unframe(body []byte) ([]byte, bool) {
+	if len(body) < FrameHeader || body[0] != 0 || int(binary.BigEndian.Uint32(body[1:])) != len(body)-FrameHeader {
+		return nil, false
+	}
+	return body[FrameHeader:], true
+}
+
+// Status is the status of a failed gRPC call
+type Status struct {
+	Code    int
+	Message string
+}
+
+// ParseStatus returns the status in the grpc-status and grpc-message of
+// the headers or trailers of a response, or nil if the call didn't fail.
+// It fails if grpc-status isn't a status code.
+func ParseStatus(header http.Header) (*Status, error) {
+	status := header.Get("Grpc-Status")
+	if status == "" || status == "0" {
+		return nil, nil
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return nil, errors.New("invalid grpc-status: " + status)
+	}
+	// grpc-message is percent encoded
+	message, err := url.PathUnescape(header.Get("Grpc-Message"))
+	if err != nil {
+		message = header.Get("Grpc-Message")
+	}
+	return &Status{Code: code, Message: message}, nil
+}
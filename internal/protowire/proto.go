@@ -0,0 +1,106 @@
+// Package protowire encodes and decodes protobuf messages field by field,
+// and frames them for gRPC, for adapters and transports speaking protobuf
+// without generated code
+package protowire
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// ErrMalformed is returned for messages that aren't valid protobuf
+var ErrMalformed = errors.New("malformed protobuf message")
+
+// AppendVarint appends a varint field
+func AppendVarint(b []byte, field int, v uint64) []byte {
+	b = appendUvarint(b, uint64(field)<<3)
+	return appendUvarint(b, v)
+}
+
+// AppendFixed64 appends a fixed64 or double field
+func AppendFixed64(b []byte, field int, v uint64) []byte {
+	b = appendUvarint(b, uint64(field)<<3|1)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// AppendBytes appends a length delimited field
+func AppendBytes(b []byte, field int, p []byte) []byte {
+	b = appendUvarint(b, uint64(field)<<3|2)
+	b = appendUvarint(b, uint64(len(p)))
+	return append(b, p...)
+}
+
+// AppendString appends a string field, leaving out empty strings like
+// proto3 does
+func AppendString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return AppendBytes(b, field, []byte(s))
+}
+
+// AppendMap appends a map<string, string> field as entries sorted by key
+func AppendMap(b []byte, field int, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		entry := AppendString(nil, 1, key)
+		entry = AppendString(entry, 2, m[key])
+		b = AppendBytes(b, field, entry)
+	}
+	return b
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// Walk calls fn with the number and value of each varint and length
+// delimited field of b, bytes being nil for varints. Fixed width fields are
+// skipped.
+func Walk(b []byte, fn func(field int, varint uint64, bytes []byte)) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return ErrMalformed
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			value, n := binary.Uvarint(b)
+			if n <= 0 {
+				return ErrMalformed
+			}
+			b = b[n:]
+			fn(field, value, nil)
+		case 1:
+			if len(b) < 8 {
+				return ErrMalformed
+			}
+			b = b[8:]
+		case 2:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return ErrMalformed
+			}
+			fn(field, 0, b[n:n+int(size)])
+			b = b[n+int(size):]
+		case 5:
+			if len(b) < 4 {
+				return ErrMalformed
+			}
+			b = b[4:]
+		default:
+			return ErrMalformed
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package protowire
+
+import (
+	"math"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	b := AppendVarint(nil, 1, 300)
+	b = AppendFixed64(b, 2, math.Float64bits(1.5))
+	b = AppendString(b, 3, "")
+	b = AppendString(b, 4, "hello")
+	b = AppendMap(b, 5, map[string]string{"b": "2", "a": "1"})
+
+	var varints []uint64
+	var strings []string
+	if err := Walk(b, func(field int, varint uint64, bytes []byte) {
+		if bytes == nil {
+			varints = append(varints, varint)
+		} else {
+			strings = append(strings, string(bytes))
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"hello", string(AppendString(AppendString(nil, 1, "a"), 2, "1")),
+		string(AppendString(AppendString(nil, 1, "b"), 2, "2"))}
+	if !reflect.DeepEqual(varints, []uint64{300}) || !reflect.DeepEqual(strings, expected) {
+		t.Errorf("unexpected fields %v %q", varints, strings)
+	}
+
+	if err := Walk(b[:len(b)-1], func(int, uint64, []byte) {}); err != ErrMalformed {
+		t.Errorf("expected a truncated message to be malformed got %v", err)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	message, ok := Unframe(Frame([]byte("hello")))
+	if !ok || string(message) != "hello" {
+		t.Errorf("expected the framed message got %q %v", message, ok)
+	}
+	if _, ok := Unframe([]byte{1, 0, 0, 0, 0}); ok {
+		t.Error("expected a compressed message to be refused")
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	status, err := ParseStatus(http.Header{"Grpc-Status": {"3"}, "Grpc-Message": {"bad%20request"}})
+	if err != nil || status.Code != 3 || status.Message != "bad request" {
+		t.Errorf("unexpected status %+v %v", status, err)
+	}
+	if status, err := ParseStatus(http.Header{"Grpc-Status": {"0"}}); status != nil || err != nil {
+		t.Errorf("expected no status for OK got %+v %v", status, err)
+	}
+	if _, err := ParseStatus(http.Header{"Grpc-Status": {"bad"}}); err == nil {
+		t.Error("expected an error for an invalid grpc-status")
+	}
+}
@@ -0,0 +1,51 @@
+package loglevel
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/gorilla/mux"
+)
+
+func init() {
+	router.HttpHandlers.Register(LogLevel, "loglevel")
+}
+
+// LogLevel returns a http.Handler for reading and changing which of
+// logspout's own log records are written at runtime
+func LogLevel() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/loglevel", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(router.CurrentLogConfig())
+	}).Methods("GET")
+	r.HandleFunc("/loglevel", func(w http.ResponseWriter, req *http.Request) {
+		// fields left out keep their current values
+		config := router.CurrentLogConfig()
+		if query := req.URL.Query(); len(query) > 0 {
+			if level := query.Get("level"); level != "" {
+				config.Level = level
+			}
+			if debug, ok := query["debug"]; ok {
+				config.Debug = strings.Split(debug[0], ",")
+			}
+			if format := query.Get("format"); format != "" {
+				config.Format = format
+			}
+		} else if err := json.NewDecoder(req.Body).Decode(&config); err != nil {
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := router.SetLogConfig(config); err != nil {
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		router.NewLogger("loglevel").Info("log level changed", "level", config.Level,
+			"debug", strings.Join(config.Debug, ","), "format", config.Format)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(router.CurrentLogConfig())
+	}).Methods("PUT", "POST")
+	return r
+}
@@ -0,0 +1,45 @@
+package loglevel
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestLogLevel(t *testing.T) {
+	defer router.SetLogConfig(router.CurrentLogConfig())
+	h := LogLevel()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("PUT", "/loglevel?level=warn&debug=router,syslog", nil))
+	var config router.LogConfig
+	if err := json.NewDecoder(w.Body).Decode(&config); err != nil || config.Level != "warn" || strings.Join(config.Debug, ",") != "router,syslog" {
+		t.Errorf("expected the level changed by the query got %+v %v", config, err)
+	}
+	if !router.NewLogger("syslog").Enabled(router.LevelDebug) || router.NewLogger("loki").Enabled(router.LevelInfo) {
+		t.Error("expected debug logs of the listed modules and warnings of the others")
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("PUT", "/loglevel", strings.NewReader(`{"format": "json"}`)))
+	if config := router.CurrentLogConfig(); config.Level != "warn" || config.Format != "json" || len(config.Debug) != 2 {
+		t.Errorf("expected fields left out of the body kept got %+v", config)
+	}
+
+	for _, req := range []string{"/loglevel?level=verbose", "/loglevel?format=xml"} {
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("PUT", req, nil))
+		if w.Code != 400 {
+			t.Errorf("%s: expected 400 got %v", req, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/loglevel", nil))
+	if body := w.Body.String(); !strings.Contains(body, `"level":"warn"`) {
+		t.Errorf("expected the current level in %s", body)
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"sort"
 	"strings"
+	"syscall"
 	"text/tabwriter"
+	"time"
 
 	"github.com/gliderlabs/logspout/router"
 )
@@ -21,14 +25,28 @@ func getopt(name, dfault string) string {
 	return value
 }
 
+// names returns the sorted names of registered extensions
+func names(registered []string) string {
+	sort.Strings(registered)
+	return strings.Join(registered, " ")
+}
+
 func main() {
 	if len(os.Args) == 2 && os.Args[1] == "--version" {
 		fmt.Println(Version)
 		os.Exit(0)
 	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(replay(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "decrypt" {
+		os.Exit(decrypt(os.Args[2:]))
+	}
 
 	fmt.Printf("# logspout %s by gliderlabs\n", Version)
-	fmt.Printf("# adapters: %s\n", strings.Join(router.AdapterFactories.Names(), " "))
+	fmt.Printf("# adapters: %s\n", names(router.AdapterFactories.Names()))
+	fmt.Printf("# transports: %s\n", names(router.AdapterTransports.Names()))
+	fmt.Printf("# processors: %s\n", names(router.ProcessorFactories.Names()))
 	fmt.Printf("# options : ")
 	if getopt("DEBUG", "") != "" {
 		fmt.Printf("debug:%s ", getopt("DEBUG", ""))
@@ -41,6 +59,12 @@ func main() {
 	}
 	fmt.Printf("persist:%s\n", getopt("ROUTESPATH", "/mnt/routes"))
 
+	shutdownTimeout, err := time.ParseDuration(getopt("SHUTDOWN_TIMEOUT", "10s"))
+	if err != nil {
+		fmt.Println("!! invalid value for SHUTDOWN_TIMEOUT:", err)
+		os.Exit(1)
+	}
+
 	var jobs []string
 	for _, job := range router.Jobs.All() {
 		err := job.Setup()
@@ -64,7 +88,7 @@ func main() {
 			fmt.Fprintf(w, "#   %s\t%s\t%s\t%s\t%s\n",
 				route.Adapter,
 				route.Address,
-				route.FilterID+route.FilterName+strings.Join(route.FilterLabels, ","),
+				route.FilterID+route.FilterName+strings.Join(route.FilterLabels, ",")+strings.Join(route.FilterImages, ","),
 				strings.Join(route.FilterSources, ","),
 				route.Options)
 		}
@@ -80,5 +104,11 @@ func main() {
 		}()
 	}
 
-	select {}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	log.Printf("# received %s, flushing routes (timeout %s)", sig, shutdownTimeout)
+	if err := router.Routes.Shutdown(shutdownTimeout); err != nil {
+		log.Fatalf("shutdown: %s", err)
+	}
 }
@@ -2,12 +2,46 @@ package main
 
 import (
 	_ "github.com/gliderlabs/logspout/healthcheck"
+	_ "github.com/gliderlabs/logspout/capabilities"
+	_ "github.com/gliderlabs/logspout/containersapi"
+	_ "github.com/gliderlabs/logspout/adapters/amqp"
+	_ "github.com/gliderlabs/logspout/adapters/cef"
+	_ "github.com/gliderlabs/logspout/adapters/clickhouse"
+	_ "github.com/gliderlabs/logspout/adapters/cloudwatch"
+	_ "github.com/gliderlabs/logspout/adapters/eventlog"
+	_ "github.com/gliderlabs/logspout/adapters/file"
+	_ "github.com/gliderlabs/logspout/adapters/firehose"
+	_ "github.com/gliderlabs/logspout/adapters/grpcplugin"
+	_ "github.com/gliderlabs/logspout/adapters/loki"
+	_ "github.com/gliderlabs/logspout/adapters/mqtt"
+	_ "github.com/gliderlabs/logspout/adapters/otlp"
+	_ "github.com/gliderlabs/logspout/adapters/pubsub"
 	_ "github.com/gliderlabs/logspout/adapters/raw"
+	_ "github.com/gliderlabs/logspout/adapters/sentry"
+	_ "github.com/gliderlabs/logspout/adapters/snmp"
+	_ "github.com/gliderlabs/logspout/adapters/sqs"
+	_ "github.com/gliderlabs/logspout/adapters/stats"
 	_ "github.com/gliderlabs/logspout/adapters/syslog"
 	_ "github.com/gliderlabs/logspout/adapters/multiline"
 	_ "github.com/gliderlabs/logspout/httpstream"
+	_ "github.com/gliderlabs/logspout/loglevel"
+	_ "github.com/gliderlabs/logspout/processors/correlate"
+	_ "github.com/gliderlabs/logspout/processors/dedup"
+	_ "github.com/gliderlabs/logspout/processors/encoding"
+	_ "github.com/gliderlabs/logspout/processors/extract"
+	_ "github.com/gliderlabs/logspout/processors/filter"
+	_ "github.com/gliderlabs/logspout/processors/geoip"
+	_ "github.com/gliderlabs/logspout/processors/guardrails"
+	_ "github.com/gliderlabs/logspout/processors/parse"
+	_ "github.com/gliderlabs/logspout/processors/redact"
+	_ "github.com/gliderlabs/logspout/processors/schema"
+	_ "github.com/gliderlabs/logspout/processors/transform"
+	_ "github.com/gliderlabs/logspout/renderapi"
 	_ "github.com/gliderlabs/logspout/routesapi"
+	_ "github.com/gliderlabs/logspout/stats"
+	_ "github.com/gliderlabs/logspout/transports/relp"
 	_ "github.com/gliderlabs/logspout/transports/tcp"
 	_ "github.com/gliderlabs/logspout/transports/udp"
 	_ "github.com/gliderlabs/logspout/transports/tls"
+	_ "github.com/gliderlabs/logspout/transports/unix"
 )
@@ -0,0 +1,176 @@
+package correlate
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func init() {
+	router.ProcessorFactories.Register(NewCorrelateProcessor, "correlate")
+	router.Capabilities.DescribeProcessor("correlate", []string{"id", "id_field", "trace", "trace_field", "span_field"})
+}
+
+// Generators are the ways of generating message IDs, by name. Modules can
+// add their own before routes are created.
+var Generators = map[string]func() string{
+	"ulid": NewULID,
+	"uuid": newUUID,
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// traceparent matches a W3C trace context header, version-traceid-parentid-flags
+var traceparent = regexp.MustCompile(`\b[0-9a-f]{2}-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}\b`)
+
+// keys commonly used for trace and span IDs in JSON logs, dotted keys are
+// looked up both as is and as nested objects
+var (
+	traceKeys = []string{"trace_id", "traceId", "traceID", "trace.id", "dd.trace_id", "logging.googleapis.com/trace"}
+	spanKeys  = []string{"span_id", "spanId", "spanID", "span.id", "dd.span_id", "logging.googleapis.com/spanId"}
+)
+
+// Processor stamps messages with a unique ID and promotes trace context found
+// in the message to fields
+type Processor struct {
+	generate   func() string
+	idField    string
+	trace      bool
+	traceField string
+	spanField  string
+}
+
+// NewCorrelateProcessor returns a correlate.Processor configured with the
+// options id (the generator of message IDs, ulid (default), uuid or none),
+// id_field (default id), trace (false to skip detecting trace context),
+// trace_field (default trace_id) and span_field (default span_id)
+func NewCorrelateProcessor(route *router.Route, options map[string]string) (router.Processor, error) {
+	p := &Processor{
+		idField:    getopt(options, "id_field", "id"),
+		trace:      true,
+		traceField: getopt(options, "trace_field", "trace_id"),
+		spanField:  getopt(options, "span_field", "span_id"),
+	}
+	switch id := getopt(options, "id", "ulid"); id {
+	case "none":
+	default:
+		generate, ok := Generators[id]
+		if !ok {
+			return nil, errors.New("correlate: invalid value for id: " + id)
+		}
+		p.generate = generate
+	}
+	switch trace := getopt(options, "trace", "true"); trace {
+	case "true":
+	case "false":
+		p.trace = false
+	default:
+		return nil, errors.New("correlate: invalid value for trace: " + trace)
+	}
+	if p.generate == nil && !p.trace {
+		return nil, errors.New("correlate: id and trace are both disabled")
+	}
+	return p, nil
+}
+
+func getopt(options map[string]string, name, dfault string) string {
+	if value := options[name]; value != "" {
+		return value
+	}
+	return dfault
+}
+
+// Process adds the ID and trace fields to each message
+func (p *Processor) Process(in chan *router.Message, out chan *router.Message) {
+	for message := range in {
+		correlated := message.Copy()
+		if correlated.Fields == nil {
+			correlated.Fields = make(map[string]string)
+		}
+		if p.generate != nil {
+			correlated.Fields[p.idField] = p.generate()
+		}
+		if p.trace {
+			traceID, spanID := detect(message)
+			if traceID != "" && correlated.Fields[p.traceField] == "" {
+				correlated.Fields[p.traceField] = traceID
+			}
+			if spanID != "" && correlated.Fields[p.spanField] == "" {
+				correlated.Fields[p.spanField] = spanID
+			}
+		}
+		out <- correlated
+	}
+}
+
+// detect returns the trace and span IDs of a message, from a traceparent in
+// its fields or data, or from the common keys of a JSON message
+func detect(message *router.Message) (traceID, spanID string) {
+	if match := traceparent.FindStringSubmatch(message.Fields["traceparent"]); match != nil {
+		return match[1], match[2]
+	}
+	data := strings.TrimSpace(message.Data)
+	if strings.HasPrefix(data, "{") {
+		decoder := json.NewDecoder(strings.NewReader(data))
+		decoder.UseNumber()
+		var object map[string]interface{}
+		if decoder.Decode(&object) == nil {
+			if match := traceparent.FindStringSubmatch(lookup(object, "traceparent")); match != nil {
+				return match[1], match[2]
+			}
+			for _, key := range traceKeys {
+				if traceID = lookup(object, key); traceID != "" {
+					break
+				}
+			}
+			for _, key := range spanKeys {
+				if spanID = lookup(object, key); spanID != "" {
+					break
+				}
+			}
+			if traceID != "" {
+				return traceID, spanID
+			}
+		}
+	}
+	if match := traceparent.FindStringSubmatch(message.Data); match != nil {
+		return match[1], match[2]
+	}
+	return "", ""
+}
+
+// lookup returns the string or number at key in object, trying dotted keys
+// as nested objects when they aren't found as is
+func lookup(object map[string]interface{}, key string) string {
+	if value, ok := object[key]; ok {
+		return scalar(value)
+	}
+	if i := strings.Index(key, "."); i > 0 {
+		if nested, ok := object[key[:i]].(map[string]interface{}); ok {
+			return lookup(nested, key[i+1:])
+		}
+	}
+	return ""
+}
+
+func scalar(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package correlate
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+var ulidPattern = regexp.MustCompile(`^[0-9A-HJKMNP-TV-Z]{26}$`)
+
+func TestULIDSortable(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	source := &ulidSource{now: func() time.Time { return now }}
+	prev := source.next()
+	if !ulidPattern.MatchString(prev) {
+		t.Fatalf("invalid ULID %q", prev)
+	}
+	if prev[:10] != "01BMZFF600" {
+		t.Errorf("expected timestamp 01BMZFF600 got %s", prev[:10])
+	}
+	for i := 0; i < 100; i++ {
+		if i == 50 {
+			now = now.Add(time.Millisecond)
+		}
+		id := source.next()
+		if id <= prev {
+			t.Fatalf("expected %s to sort after %s", id, prev)
+		}
+		prev = id
+	}
+}
+
+func process(t *testing.T, options map[string]string, data string, fields map[string]string) *router.Message {
+	p, err := NewCorrelateProcessor(&router.Route{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan *router.Message, 1)
+	out := make(chan *router.Message, 1)
+	in <- &router.Message{Data: data, Fields: fields}
+	close(in)
+	p.Process(in, out)
+	return <-out
+}
+
+func TestCorrelateID(t *testing.T) {
+	message := process(t, map[string]string{}, "hello", nil)
+	if !ulidPattern.MatchString(message.Fields["id"]) {
+		t.Errorf("expected ULID in id field got %q", message.Fields["id"])
+	}
+	message = process(t, map[string]string{"id": "uuid", "id_field": "uuid"}, "hello", nil)
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(message.Fields["uuid"]) {
+		t.Errorf("expected UUID in uuid field got %q", message.Fields["uuid"])
+	}
+	message = process(t, map[string]string{"id": "none"}, "hello", nil)
+	if _, ok := message.Fields["id"]; ok {
+		t.Error("expected no id field")
+	}
+}
+
+func TestCorrelateTrace(t *testing.T) {
+	for _, test := range []struct {
+		data, trace, span string
+		fields            map[string]string
+	}{
+		{"GET / traceparent=00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", nil},
+		{`{"msg":"hi","traceId":"abc","spanId":"def"}`, "abc", "def", nil},
+		{`{"msg":"hi","dd":{"trace_id":1234567890123,"span_id":42}}`, "1234567890123", "42", nil},
+		{`{"trace.id":"abc"}`, "abc", "", nil},
+		{"no trace here", "", "", nil},
+		{"hi", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}},
+		{`{"trace_id":"abc"}`, "already", "", map[string]string{"trace_id": "already"}},
+	} {
+		message := process(t, map[string]string{"id": "none"}, test.data, test.fields)
+		if message.Fields["trace_id"] != test.trace || message.Fields["span_id"] != test.span {
+			t.Errorf("%s: expected trace %q span %q got %q %q", test.data, test.trace, test.span, message.Fields["trace_id"], message.Fields["span_id"])
+		}
+	}
+}
+
+func TestCorrelateInvalidOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"id": "snowflake"},
+		{"trace": "yes"},
+		{"id": "none", "trace": "false"},
+	} {
+		if _, err := NewCorrelateProcessor(&router.Route{}, options); err == nil {
+			t.Errorf("expected error for %v", options)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package correlate
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// crockford is the Crockford base32 alphabet used by ULIDs
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var ulids = &ulidSource{now: time.Now}
+
+// ulidSource generates ULIDs that sort in the order they were generated, by
+// incrementing the random part of IDs generated within the same millisecond
+type ulidSource struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	last    uint64
+	entropy [10]byte
+}
+
+// NewULID returns a new ULID, a 26 character, lexically sortable identifier
+// made of a millisecond timestamp and 80 random bits
+func NewULID() string {
+	return ulids.next()
+}
+
+func (s *ulidSource) next() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ms := uint64(s.now().UnixNano() / int64(time.Millisecond))
+	if ms > s.last || !s.increment() {
+		// a new millisecond, or the random part overflowed
+		rand.Read(s.entropy[:])
+		if ms > s.last {
+			s.last = ms
+		}
+	}
+	var id [16]byte
+	for i := 0; i < 6; i++ {
+		id[i] = byte(s.last >> uint(40-8*i))
+	}
+	copy(id[6:], s.entropy[:])
+	return encode(id)
+}
+
+// increment adds one to the random part, returning false on overflow
+func (s *ulidSource) increment() bool {
+	for i := len(s.entropy) - 1; i >= 0; i-- {
+		s.entropy[i]++
+		if s.entropy[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encode writes the 128 bits of id as 26 base32 characters, the first of
+// which only holds 3 bits
+func encode(id [16]byte) string {
+	var out [26]byte
+	hi := uint64(id[0])<<56 | uint64(id[1])<<48 | uint64(id[2])<<40 | uint64(id[3])<<32 |
+		uint64(id[4])<<24 | uint64(id[5])<<16 | uint64(id[6])<<8 | uint64(id[7])
+	lo := uint64(id[8])<<56 | uint64(id[9])<<48 | uint64(id[10])<<40 | uint64(id[11])<<32 |
+		uint64(id[12])<<24 | uint64(id[13])<<16 | uint64(id[14])<<8 | uint64(id[15])
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
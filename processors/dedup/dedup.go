@@ -0,0 +1,222 @@
+package dedup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultWindow     = 10 * time.Second
+	defaultMaxEntries = 100000
+	defaultKey        = "{{.Container.ID}}\x00{{.Source}}\x00{{.Data}}"
+)
+
+func init() {
+	router.ProcessorFactories.Register(NewDedupProcessor, "dedup")
+	router.Capabilities.DescribeProcessor("dedup", []string{"window", "key", "max_entries", "threshold", "summary"})
+}
+
+type hash [sha256.Size]byte
+
+type entry struct {
+	hash hash
+	seen time.Time
+}
+
+// state is what is remembered of a hash within the window
+type state struct {
+	seen    time.Time
+	passed  int
+	dropped int
+	// message is the first message passed, which summaries are made of
+	message *router.Message
+}
+
+// Processor drops messages whose content was already seen within a time window
+type Processor struct {
+	key        *template.Template
+	window     time.Duration
+	maxEntries int
+	threshold  int
+	summary    bool
+	seen       map[hash]*state
+	order      []entry
+	summaries  []*router.Message
+	now        func() time.Time
+}
+
+// NewDedupProcessor returns a dedup.Processor configured with the options
+// window (default 10s), key (the template hashed to compare messages, by
+// default the container id, source and data), max_entries (the most
+// hashes remembered, default 100000), threshold (the identical messages
+// passed within the window before the rest are dropped, default 1) and
+// summary (whether to follow dropped messages with a "last message repeated
+// N times" message)
+func NewDedupProcessor(route *router.Route, options map[string]string) (router.Processor, error) {
+	p := &Processor{
+		window:     defaultWindow,
+		maxEntries: defaultMaxEntries,
+		threshold:  1,
+		seen:       make(map[hash]*state),
+		now:        time.Now,
+	}
+	var err error
+	if value := options["window"]; value != "" {
+		if p.window, err = time.ParseDuration(value); err != nil || p.window <= 0 {
+			return nil, errors.New("dedup: invalid value for window: " + value)
+		}
+	}
+	if value := options["threshold"]; value != "" {
+		if p.threshold, err = strconv.Atoi(value); err != nil || p.threshold <= 0 {
+			return nil, errors.New("dedup: invalid value for threshold: " + value)
+		}
+	}
+	if value := options["summary"]; value != "" {
+		if p.summary, err = strconv.ParseBool(value); err != nil {
+			return nil, errors.New("dedup: invalid value for summary: " + value)
+		}
+	}
+	if value := options["max_entries"]; value != "" {
+		if p.maxEntries, err = strconv.Atoi(value); err != nil || p.maxEntries <= 0 {
+			return nil, errors.New("dedup: invalid value for max_entries: " + value)
+		}
+	}
+	key := defaultKey
+	if options["key"] != "" {
+		key = options["key"]
+	}
+	if p.key, err = template.New("key").Parse(key); err != nil {
+		return nil, errors.New("dedup: invalid value for key: " + err.Error())
+	}
+	return p, nil
+}
+
+// Process passes on the first threshold of identical messages seen within
+// the window. With summary, the messages dropped within a window are
+// reported once it ends, checked at least every second, or when in closes.
+func (p *Processor) Process(in chan *router.Message, out chan *router.Message) {
+	var tick <-chan time.Time
+	if p.summary {
+		ticker := time.NewTicker(summaryInterval(p.window))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case message, ok := <-in:
+			if !ok {
+				p.flush()
+				p.send(out)
+				return
+			}
+			buf := new(bytes.Buffer)
+			if err := p.key.Execute(buf, message); err != nil {
+				router.NewLogger("dedup").Warn("rendering the key failed", "error", err)
+				p.send(out)
+				out <- message
+				continue
+			}
+			duplicate := p.duplicate(sha256.Sum256(buf.Bytes()), message)
+			p.send(out)
+			if !duplicate {
+				out <- message
+			}
+		case <-tick:
+			p.expire(p.now())
+			p.send(out)
+		}
+	}
+}
+
+// summaryInterval returns how often ended windows are checked for summaries
+func summaryInterval(window time.Duration) time.Duration {
+	if window < time.Second {
+		return window
+	}
+	return time.Second
+}
+
+// send passes on the pending summaries
+func (p *Processor) send(out chan *router.Message) {
+	for _, summary := range p.summaries {
+		out <- summary
+	}
+	p.summaries = nil
+}
+
+// duplicate records h of message and returns whether it was already passed
+// threshold times within the window
+func (p *Processor) duplicate(h hash, message *router.Message) bool {
+	now := p.now()
+	p.expire(now)
+	if s, ok := p.seen[h]; ok && now.Sub(s.seen) < p.window {
+		if s.passed < p.threshold {
+			s.passed++
+			return false
+		}
+		s.dropped++
+		return true
+	}
+	s := &state{seen: now, passed: 1}
+	if p.summary {
+		s.message = message
+	}
+	p.seen[h] = s
+	p.order = append(p.order, entry{h, now})
+	if len(p.order) > p.maxEntries {
+		p.forget()
+	}
+	return false
+}
+
+// expire forgets the hashes seen before the window
+func (p *Processor) expire(now time.Time) {
+	for len(p.order) > 0 && now.Sub(p.order[0].seen) >= p.window {
+		p.forget()
+	}
+}
+
+// forget removes the oldest hash, summarizing the messages it dropped
+func (p *Processor) forget() {
+	oldest := p.order[0]
+	p.order = p.order[1:]
+	if s := p.seen[oldest.hash]; s.seen.Equal(oldest.seen) {
+		delete(p.seen, oldest.hash)
+		p.summarize(s)
+	}
+}
+
+// flush summarizes the messages dropped so far within the current windows
+func (p *Processor) flush() {
+	for _, e := range p.order {
+		if s := p.seen[e.hash]; s.seen.Equal(e.seen) {
+			p.summarize(s)
+		}
+	}
+}
+
+// summarize queues a summary of the messages s dropped, as a copy of its
+// first message with the count in the repeated field
+func (p *Processor) summarize(s *state) {
+	if !p.summary || s.dropped == 0 {
+		return
+	}
+	summary := s.message.Copy()
+	if summary.Fields == nil {
+		summary.Fields = make(map[string]string)
+	}
+	summary.Data = fmt.Sprintf("last message repeated %d times", s.dropped)
+	summary.Time = p.now()
+	// the message's delivery was already tracked
+	summary.Fanout = nil
+	summary.Fields["repeated"] = strconv.Itoa(s.dropped)
+	p.summaries = append(p.summaries, summary)
+	s.dropped = 0
+}
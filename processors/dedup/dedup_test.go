@@ -0,0 +1,153 @@
+package dedup
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestDedup(t *testing.T) {
+	processor, err := NewDedupProcessor(&router.Route{}, map[string]string{"window": "1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := processor.(*Processor)
+	now := time.Unix(1500000000, 0)
+	p.now = func() time.Time { return now }
+
+	container := &docker.Container{ID: "abc"}
+	other := &docker.Container{ID: "def"}
+	send := func(container *docker.Container, data string) bool {
+		in := make(chan *router.Message, 1)
+		out := make(chan *router.Message, 1)
+		in <- &router.Message{Container: container, Source: "stdout", Data: data}
+		close(in)
+		p.Process(in, out)
+		return len(out) == 1
+	}
+
+	if !send(container, "hello") {
+		t.Error("expected first message to pass")
+	}
+	if send(container, "hello") {
+		t.Error("expected duplicate to be dropped")
+	}
+	if !send(other, "hello") {
+		t.Error("expected same data from another container to pass")
+	}
+	now = now.Add(time.Minute)
+	if !send(container, "hello") {
+		t.Error("expected message to pass after the window")
+	}
+	if len(p.seen) != 1 || len(p.order) != 1 {
+		t.Errorf("expected expired hashes to be forgotten, got %v seen", len(p.seen))
+	}
+}
+
+func TestDedupSummary(t *testing.T) {
+	processor, err := NewDedupProcessor(&router.Route{}, map[string]string{"window": "1m", "threshold": "2", "summary": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := processor.(*Processor)
+	now := time.Unix(1500000000, 0)
+	p.now = func() time.Time { return now }
+
+	container := &docker.Container{ID: "abc"}
+	send := func(data ...string) []*router.Message {
+		in := make(chan *router.Message, len(data))
+		out := make(chan *router.Message, len(data)+2)
+		for _, d := range data {
+			in <- &router.Message{Container: container, Source: "stdout", Data: d}
+		}
+		close(in)
+		p.Process(in, out)
+		close(out)
+		var messages []*router.Message
+		for message := range out {
+			messages = append(messages, message)
+		}
+		return messages
+	}
+	data := func(messages []*router.Message) (result []string) {
+		for _, message := range messages {
+			result = append(result, message.Data)
+		}
+		return
+	}
+
+	// the repeats so far are summarized when the stream closes
+	messages := send("retrying", "retrying", "retrying", "retrying", "retrying")
+	if got := data(messages); len(got) != 3 || got[0] != "retrying" || got[1] != "retrying" || got[2] != "last message repeated 3 times" {
+		t.Fatalf("expected 2 messages and a summary got %q", got)
+	}
+	if summary := messages[2]; summary.Container != container || summary.Source != "stdout" || summary.Fields["repeated"] != "3" {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	// within an open stream the repeats are summarized once the window ends
+	var mu sync.Mutex
+	p.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	in := make(chan *router.Message)
+	out := make(chan *router.Message)
+	go func() {
+		p.Process(in, out)
+		close(out)
+	}()
+	in <- &router.Message{Container: container, Source: "stdout", Data: "retrying"}
+	in <- &router.Message{Container: container, Source: "stdout", Data: "done"}
+	if message := <-out; message.Data != "done" {
+		t.Errorf("expected the repeat to be dropped got %q", message.Data)
+	}
+	mu.Lock()
+	now = now.Add(time.Minute)
+	mu.Unlock()
+	select {
+	case message := <-out:
+		if message.Data != "last message repeated 1 times" {
+			t.Errorf("expected a summary got %q", message.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected a summary after the window")
+	}
+	close(in)
+	if message, ok := <-out; ok {
+		t.Errorf("expected no summary without repeats got %q", message.Data)
+	}
+}
+
+func TestDedupMaxEntries(t *testing.T) {
+	processor, err := NewDedupProcessor(&router.Route{}, map[string]string{"max_entries": "2", "key": "{{.Data}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := processor.(*Processor)
+	for _, data := range []string{"a", "b", "c"} {
+		p.duplicate(hashOf(data), nil)
+	}
+	if len(p.seen) != 2 {
+		t.Errorf("expected 2 remembered hashes got %v", len(p.seen))
+	}
+	if p.duplicate(hashOf("a"), nil) {
+		t.Error("expected oldest hash to be forgotten")
+	}
+}
+
+func TestDedupOptions(t *testing.T) {
+	for _, options := range []map[string]string{{"window": "soon"}, {"max_entries": "0"}, {"key": "{{.Data"}, {"threshold": "0"}, {"summary": "maybe"}} {
+		if _, err := NewDedupProcessor(&router.Route{}, options); err == nil {
+			t.Errorf("expected error for options %v", options)
+		}
+	}
+}
+
+func hashOf(s string) (h hash) {
+	copy(h[:], s)
+	return
+}
@@ -0,0 +1,64 @@
+package encoding
+
+import (
+	"errors"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/gliderlabs/logspout/router"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+func init() {
+	router.ProcessorFactories.Register(NewEncodingProcessor, "encoding")
+	router.Capabilities.DescribeProcessor("encoding", []string{"from", "always"})
+}
+
+// Processor transcodes message data from a legacy character encoding to UTF-8
+type Processor struct {
+	decoder *encoding.Decoder
+	always  bool
+}
+
+// NewEncodingProcessor returns an encoding.Processor configured with the options
+// from (the source encoding, e.g. latin1 or shift_jis) and always (transcode
+// messages that are already valid UTF-8 too)
+func NewEncodingProcessor(route *router.Route, options map[string]string) (router.Processor, error) {
+	if options["from"] == "" {
+		return nil, errors.New("encoding: from is required")
+	}
+	enc, err := htmlindex.Get(options["from"])
+	if err != nil {
+		return nil, errors.New("encoding: unsupported encoding: " + options["from"])
+	}
+	always := false
+	if options["always"] != "" {
+		if always, err = strconv.ParseBool(options["always"]); err != nil {
+			return nil, errors.New("encoding: invalid value for always (must be true|false): " + options["always"])
+		}
+	}
+	return &Processor{
+		decoder: enc.NewDecoder(),
+		always:  always,
+	}, nil
+}
+
+// Process converts each message's data to UTF-8
+func (p *Processor) Process(in chan *router.Message, out chan *router.Message) {
+	for message := range in {
+		if !p.always && utf8.ValidString(message.Data) {
+			out <- message
+			continue
+		}
+		data, err := p.decoder.String(message.Data)
+		if err != nil {
+			router.NewLogger("encoding").Warn("decoding failed", "error", err)
+			out <- message
+			continue
+		}
+		converted := message.Copy()
+		converted.Data = data
+		out <- converted
+	}
+}
@@ -0,0 +1,40 @@
+package encoding
+
+import (
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestEncoding(t *testing.T) {
+	tests := []struct {
+		options  map[string]string
+		in       string
+		expected string
+	}{
+		{map[string]string{"from": "latin1"}, "caf\xe9", "café"},
+		{map[string]string{"from": "shift_jis"}, "\x83\x65\x83\x58\x83\x67", "テスト"},
+		{map[string]string{"from": "latin1"}, "café", "café"},
+		{map[string]string{"from": "latin1", "always": "true"}, "caf\xc3\xa9", "cafÃ©"},
+	}
+	for _, test := range tests {
+		p, err := NewEncodingProcessor(&router.Route{}, test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := make(chan *router.Message, 1)
+		out := make(chan *router.Message, 1)
+		in <- &router.Message{Data: test.in}
+		close(in)
+		p.Process(in, out)
+		if actual := (<-out).Data; actual != test.expected {
+			t.Errorf("expected %q got %q", test.expected, actual)
+		}
+	}
+}
+
+func TestEncodingUnsupported(t *testing.T) {
+	if _, err := NewEncodingProcessor(&router.Route{}, map[string]string{"from": "klingon"}); err == nil {
+		t.Error("expected error for unsupported encoding")
+	}
+}
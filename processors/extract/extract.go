@@ -0,0 +1,65 @@
+package extract
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func init() {
+	router.ProcessorFactories.Register(NewExtractProcessor, "extract")
+	router.Capabilities.DescribeProcessor("extract", []string{"pattern"})
+}
+
+// Processor extracts fields from message data using a regexp with named groups
+type Processor struct {
+	pattern *regexp.Regexp
+	names   []string
+}
+
+// NewExtractProcessor returns an extract.Processor configured with the option
+// pattern, a regexp whose named groups like (?P<client_ip>\S+) become fields
+func NewExtractProcessor(route *router.Route, options map[string]string) (router.Processor, error) {
+	if options["pattern"] == "" {
+		return nil, errors.New("extract: pattern is required")
+	}
+	pattern, err := regexp.Compile(options["pattern"])
+	if err != nil {
+		return nil, errors.New("extract: invalid value for pattern (must be regexp): " + options["pattern"])
+	}
+	named := false
+	for _, name := range pattern.SubexpNames() {
+		if name != "" {
+			named = true
+		}
+	}
+	if !named {
+		return nil, errors.New("extract: pattern must have named groups: " + options["pattern"])
+	}
+	return &Processor{
+		pattern: pattern,
+		names:   pattern.SubexpNames(),
+	}, nil
+}
+
+// Process adds the fields matched in each message
+func (p *Processor) Process(in chan *router.Message, out chan *router.Message) {
+	for message := range in {
+		match := p.pattern.FindStringSubmatch(message.Data)
+		if match == nil {
+			out <- message
+			continue
+		}
+		extracted := message.Copy()
+		if extracted.Fields == nil {
+			extracted.Fields = make(map[string]string)
+		}
+		for i, name := range p.names {
+			if name != "" && match[i] != "" {
+				extracted.Fields[name] = match[i]
+			}
+		}
+		out <- extracted
+	}
+}
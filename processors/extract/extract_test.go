@@ -0,0 +1,61 @@
+package extract
+
+import (
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func run(t *testing.T, options map[string]string, message *router.Message) *router.Message {
+	p, err := NewExtractProcessor(&router.Route{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan *router.Message, 1)
+	out := make(chan *router.Message, 1)
+	in <- message
+	close(in)
+	p.Process(in, out)
+	return <-out
+}
+
+func TestExtract(t *testing.T) {
+	original := &router.Message{Data: "10.0.0.1 GET /cart 200", Fields: map[string]string{"app": "shop"}}
+	extracted := run(t, map[string]string{
+		"pattern": `^(?P<client_ip>\S+) (?P<method>[A-Z]+) (\S+) (?P<status>\d+)(?P<latency> \d+ms)?$`,
+	}, original)
+	expected := map[string]string{"app": "shop", "client_ip": "10.0.0.1", "method": "GET", "status": "200"}
+	if len(extracted.Fields) != len(expected) {
+		t.Errorf("expected fields %v got %v", expected, extracted.Fields)
+	}
+	for name, value := range expected {
+		if extracted.Fields[name] != value {
+			t.Errorf("expected %s=%s got %v", name, value, extracted.Fields)
+		}
+	}
+	if extracted.Data != original.Data {
+		t.Errorf("expected the data unchanged got %q", extracted.Data)
+	}
+	if len(original.Fields) != 1 {
+		t.Errorf("original message was modified: %v", original.Fields)
+	}
+}
+
+func TestExtractNoMatch(t *testing.T) {
+	original := &router.Message{Data: "starting up"}
+	if passed := run(t, map[string]string{"pattern": `status=(?P<status>\d+)`}, original); passed != original || passed.Fields != nil {
+		t.Errorf("expected the message passed through unchanged got %+v", passed)
+	}
+}
+
+func TestExtractInvalidPattern(t *testing.T) {
+	for _, options := range []map[string]string{
+		{},
+		{"pattern": "(?P<status>"},
+		{"pattern": `status=(\d+)`},
+	} {
+		if _, err := NewExtractProcessor(&router.Route{}, options); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package filter
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func init() {
+	router.ProcessorFactories.Register(NewFilterProcessor, "filter")
+	router.Capabilities.DescribeProcessor("filter", []string{"match", "exclude"})
+}
+
+// Processor drops messages based on their content
+type Processor struct {
+	match   *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+// NewFilterProcessor returns a filter.Processor configured with the options
+// match (only keep messages matching the pattern) and exclude (drop messages
+// matching the pattern)
+func NewFilterProcessor(route *router.Route, options map[string]string) (router.Processor, error) {
+	p := new(Processor)
+	var err error
+	if options["match"] != "" {
+		if p.match, err = regexp.Compile(options["match"]); err != nil {
+			return nil, errors.New("filter: invalid value for match (must be regexp): " + options["match"])
+		}
+	}
+	if options["exclude"] != "" {
+		if p.exclude, err = regexp.Compile(options["exclude"]); err != nil {
+			return nil, errors.New("filter: invalid value for exclude (must be regexp): " + options["exclude"])
+		}
+	}
+	if p.match == nil && p.exclude == nil {
+		return nil, errors.New("filter: one of match or exclude is required")
+	}
+	return p, nil
+}
+
+// Process sends the messages that pass the filter to out
+func (p *Processor) Process(in chan *router.Message, out chan *router.Message) {
+	for message := range in {
+		if p.match != nil && !p.match.MatchString(message.Data) {
+			continue
+		}
+		if p.exclude != nil && p.exclude.MatchString(message.Data) {
+			continue
+		}
+		out <- message
+	}
+}
@@ -0,0 +1,54 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestFilter(t *testing.T) {
+	messages := []string{"GET /health 200", "GET /cart 200", "POST /cart 500", "DEBUG cache miss"}
+	tests := []struct {
+		options  map[string]string
+		expected []string
+	}{
+		{map[string]string{"match": "cart"}, []string{"GET /cart 200", "POST /cart 500"}},
+		{map[string]string{"exclude": "^DEBUG|/health"}, []string{"GET /cart 200", "POST /cart 500"}},
+		{map[string]string{"match": "^(GET|POST)", "exclude": " 200$"}, []string{"POST /cart 500"}},
+		{map[string]string{"match": "nothing"}, nil},
+	}
+	for _, test := range tests {
+		p, err := NewFilterProcessor(&router.Route{}, test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := make(chan *router.Message, len(messages))
+		out := make(chan *router.Message, len(messages))
+		for _, data := range messages {
+			in <- &router.Message{Data: data}
+		}
+		close(in)
+		p.Process(in, out)
+		close(out)
+		var kept []string
+		for message := range out {
+			kept = append(kept, message.Data)
+		}
+		if !reflect.DeepEqual(kept, test.expected) {
+			t.Errorf("%v: expected %q got %q", test.options, test.expected, kept)
+		}
+	}
+}
+
+func TestFilterInvalidOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{},
+		{"match": "("},
+		{"exclude": "[a-"},
+	} {
+		if _, err := NewFilterProcessor(&router.Route{}, options); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package geoip
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func init() {
+	router.ProcessorFactories.Register(NewGeoIPProcessor, "geoip")
+	router.Capabilities.DescribeProcessor("geoip", []string{"database", "fields"})
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+// Processor adds country and ASN fields for IP addresses in message fields
+type Processor struct {
+	databases []*reader
+	fields    []string
+}
+
+// NewGeoIPProcessor returns a geoip.Processor configured with the options
+// database, a comma separated list of mounted MMDB files (default
+// GEOIP_DATABASE), and fields, the extracted fields holding IP addresses
+func NewGeoIPProcessor(route *router.Route, options map[string]string) (router.Processor, error) {
+	databaseStr := getopt("GEOIP_DATABASE", "")
+	if options["database"] != "" {
+		databaseStr = options["database"]
+	}
+	if databaseStr == "" {
+		return nil, errors.New("geoip: database is required")
+	}
+	if options["fields"] == "" {
+		return nil, errors.New("geoip: fields is required")
+	}
+	p := new(Processor)
+	for _, path := range strings.Split(databaseStr, ",") {
+		db, err := openDatabase(strings.TrimSpace(path))
+		if err != nil {
+			return nil, err
+		}
+		p.databases = append(p.databases, db)
+	}
+	for _, field := range strings.Split(options["fields"], ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			p.fields = append(p.fields, field)
+		}
+	}
+	return p, nil
+}
+
+// Process enriches each message that has one of the configured fields
+func (p *Processor) Process(in chan *router.Message, out chan *router.Message) {
+	for message := range in {
+		var enriched *router.Message
+		for _, field := range p.fields {
+			ip := net.ParseIP(message.Fields[field])
+			if ip == nil {
+				continue
+			}
+			for key, value := range p.lookup(ip) {
+				if enriched == nil {
+					enriched = message.Copy()
+				}
+				enriched.Fields[field+"_"+key] = value
+			}
+		}
+		if enriched != nil {
+			message = enriched
+		}
+		out <- message
+	}
+}
+
+// lookup returns the country and ASN fields found for ip in any database
+func (p *Processor) lookup(ip net.IP) map[string]string {
+	result := make(map[string]string)
+	for _, db := range p.databases {
+		record, err := db.lookup(ip)
+		if err != nil {
+			router.NewLogger("geoip").Warn("lookup failed", "ip", ip, "error", err)
+			continue
+		}
+		if country, ok := record["country"].(map[string]interface{}); ok {
+			if code, ok := country["iso_code"].(string); ok {
+				result["country"] = code
+			}
+		}
+		if asn, ok := record["autonomous_system_number"].(uint64); ok {
+			result["asn"] = strconv.FormatUint(asn, 10)
+		}
+		if org, ok := record["autonomous_system_organization"].(string); ok {
+			result["as_org"] = org
+		}
+	}
+	return result
+}
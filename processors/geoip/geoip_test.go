@@ -0,0 +1,126 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func encodeString(s string) []byte {
+	if len(s) >= 29 {
+		return append([]byte{typeString<<5 | 29, byte(len(s) - 29)}, s...)
+	}
+	return append([]byte{typeString<<5 | byte(len(s))}, s...)
+}
+
+func encodeUint32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return append([]byte{typeUint32<<5 | 4}, b...)
+}
+
+func encodeMap(pairs ...[]byte) []byte {
+	buf := []byte{typeMap<<5 | byte(len(pairs)/2)}
+	for _, pair := range pairs {
+		buf = append(buf, pair...)
+	}
+	return buf
+}
+
+// testDatabase builds an IPv4 database mapping 1.0.0.0/8 to record
+func testDatabase(record []byte) []byte {
+	const nodeCount = 8
+	empty := uint32(nodeCount)
+	data := uint32(nodeCount + 16)
+	buf := new(bytes.Buffer)
+	// walk the bits of 00000001, sending every other branch to the empty record
+	for i := 0; i < nodeCount; i++ {
+		next := uint32(i + 1)
+		if i == nodeCount-1 {
+			next = data
+		}
+		left, right := next, empty
+		if i == nodeCount-1 {
+			left, right = empty, next
+		}
+		for _, r := range []uint32{left, right} {
+			buf.Write([]byte{byte(r >> 16), byte(r >> 8), byte(r)})
+		}
+	}
+	buf.Write(make([]byte, 16))
+	buf.Write(record)
+	buf.Write(metadataStart)
+	buf.Write(encodeMap(
+		encodeString("node_count"), encodeUint32(nodeCount),
+		encodeString("record_size"), encodeUint32(24),
+		encodeString("ip_version"), encodeUint32(4),
+	))
+	return buf.Bytes()
+}
+
+func TestGeoIP(t *testing.T) {
+	db := testDatabase(encodeMap(
+		encodeString("country"), encodeMap(encodeString("iso_code"), encodeString("AU")),
+		encodeString("autonomous_system_number"), encodeUint32(13335),
+		encodeString("autonomous_system_organization"), encodeString("Example"),
+	))
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.mmdb")
+	if err := ioutil.WriteFile(path, db, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewGeoIPProcessor(&router.Route{}, map[string]string{"database": path, "fields": "client_ip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan *router.Message, 3)
+	out := make(chan *router.Message, 3)
+	original := &router.Message{Data: "a", Fields: map[string]string{"client_ip": "1.2.3.4"}}
+	in <- original
+	in <- &router.Message{Data: "b", Fields: map[string]string{"client_ip": "2.2.3.4"}}
+	in <- &router.Message{Data: "c"}
+	close(in)
+	p.Process(in, out)
+
+	enriched := <-out
+	expected := map[string]string{
+		"client_ip":         "1.2.3.4",
+		"client_ip_country": "AU",
+		"client_ip_asn":     "13335",
+		"client_ip_as_org":  "Example",
+	}
+	for key, value := range expected {
+		if enriched.Fields[key] != value {
+			t.Errorf("expected %s=%q got %q", key, value, enriched.Fields[key])
+		}
+	}
+	if len(original.Fields) != 1 {
+		t.Errorf("original message was modified: %v", original.Fields)
+	}
+	if msg := <-out; len(msg.Fields) != 1 {
+		t.Errorf("expected no enrichment outside database, got %v", msg.Fields)
+	}
+	if msg := <-out; msg.Fields != nil {
+		t.Errorf("expected no fields, got %v", msg.Fields)
+	}
+}
+
+func TestGeoIPRequiresOptions(t *testing.T) {
+	os.Unsetenv("GEOIP_DATABASE")
+	if _, err := NewGeoIPProcessor(&router.Route{}, map[string]string{"fields": "ip"}); err == nil {
+		t.Error("expected error without database")
+	}
+	if _, err := NewGeoIPProcessor(&router.Route{}, map[string]string{"database": "/nonexistent.mmdb"}); err == nil {
+		t.Error("expected error without fields")
+	}
+}
@@ -0,0 +1,286 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+)
+
+// mmdb data types, see https://maxmind.github.io/MaxMind-DB/
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+var metadataStart = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// reader looks up records in a MaxMind DB file
+type reader struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+}
+
+func openDatabase(path string) (*reader, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newReader(buf)
+}
+
+func newReader(buf []byte) (*reader, error) {
+	i := bytes.LastIndex(buf, metadataStart)
+	if i < 0 {
+		return nil, errors.New("geoip: invalid database: metadata not found")
+	}
+	metaBuf := buf[i+len(metadataStart):]
+	meta, _, err := decode(metaBuf, 0)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: invalid database metadata: %s", err)
+	}
+	metadata, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("geoip: invalid database metadata")
+	}
+	r := &reader{
+		nodeCount:  toUint(metadata["node_count"]),
+		recordSize: toUint(metadata["record_size"]),
+		ipVersion:  toUint(metadata["ip_version"]),
+	}
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("geoip: unsupported record size: %v", r.recordSize)
+	}
+	treeSize := r.nodeCount * r.recordSize * 2 / 8
+	// the data section follows the search tree and 16 bytes of zeros
+	if treeSize+16 > uint(i) {
+		return nil, errors.New("geoip: invalid database: search tree too large")
+	}
+	r.tree = buf[:treeSize]
+	r.data = buf[treeSize+16 : i]
+
+	// IPv4 addresses are stored in IPv6 trees under ::/96
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for j := 0; j < 96 && node < r.nodeCount; j++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a node
+func (r *reader) record(node uint, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		off := node*6 + bit*3
+		b := r.tree[off : off+3]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		off := node * 7
+		b := r.tree[off : off+7]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		off := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(r.tree[off : off+4]))
+	}
+}
+
+// lookup returns the record for ip, or nil if the database has none
+func (r *reader) lookup(ip net.IP) (map[string]interface{}, error) {
+	node := uint(0)
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 32
+		node = r.ipv4Start
+	} else if r.ipVersion == 4 {
+		return nil, nil
+	}
+	for i := 0; i < bits && node < r.nodeCount; i++ {
+		bit := uint(ip[i>>3]>>(7-uint(i&7))) & 1
+		node = r.record(node, bit)
+	}
+	if node <= r.nodeCount {
+		return nil, nil
+	}
+	offset := node - r.nodeCount - 16
+	if offset >= uint(len(r.data)) {
+		return nil, errors.New("geoip: invalid database: bad data pointer")
+	}
+	value, _, err := decode(r.data, offset)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]interface{})
+	return record, nil
+}
+
+// decode decodes the value at offset in buf and returns it with the offset following it
+func decode(buf []byte, offset uint) (interface{}, uint, error) {
+	if offset >= uint(len(buf)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	ctrl := buf[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+	if typ == typePointer {
+		pointer, next, err := decodePointer(buf, ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := decode(buf, pointer)
+		return value, next, err
+	}
+	if typ == typeExtended {
+		if offset >= uint(len(buf)) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		typ = 7 + uint(buf[offset])
+		offset++
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(buf)) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		ext := uint(0)
+		for _, b := range buf[offset : offset+n] {
+			ext = ext<<8 | uint(b)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + ext
+		case 30:
+			size = 285 + ext
+		default:
+			size = 65821 + ext
+		}
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := decode(buf, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := decode(buf, next)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, _ := key.(string)
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := decode(buf, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeEndMarker, typeContainer:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(buf)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	b := buf[offset : offset+size]
+	offset += size
+	switch typ {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return append([]byte(nil), b...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case typeUint16, typeUint32, typeUint64, typeUint128, typeInt32:
+		// uint128 values don't fit and only their low 64 bits are kept
+		v := uint64(0)
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		if typ == typeInt32 {
+			return int64(int32(uint32(v))), offset, nil
+		}
+		return v, offset, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type %v", typ)
+}
+
+func decodePointer(buf []byte, ctrl byte, offset uint) (uint, uint, error) {
+	ss := uint(ctrl>>3) & 0x3
+	n := ss + 1
+	if offset+n > uint(len(buf)) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	p := uint(0)
+	if ss < 3 {
+		p = uint(ctrl & 0x7)
+	}
+	for _, b := range buf[offset : offset+n] {
+		p = p<<8 | uint(b)
+	}
+	switch ss {
+	case 1:
+		p += 2048
+	case 2:
+		p += 526336
+	}
+	return p, offset + n, nil
+}
+
+func toUint(v interface{}) uint {
+	switch n := v.(type) {
+	case uint64:
+		return uint(n)
+	case int64:
+		return uint(n)
+	}
+	return 0
+}
@@ -0,0 +1,219 @@
+package guardrails
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	actionDrop = "drop"
+	actionHash = "hash"
+)
+
+func init() {
+	router.ProcessorFactories.Register(NewGuardrailsProcessor, "guardrails")
+	router.Capabilities.DescribeProcessor("guardrails", []string{
+		"max_fields", "max_key_length", "max_value_length", "max_keys",
+		"max_cardinality", "fields", "action", "hash_buckets",
+	})
+}
+
+// Processor limits the fields of messages so structured adapters don't emit
+// unbounded numbers of labels or label values
+type Processor struct {
+	route          *router.Route
+	maxFields      int
+	maxKeyLength   int
+	maxValueLength int
+	maxKeys        int
+	maxCardinality int
+	fields         map[string]bool
+	action         string
+	hashBuckets    uint32
+	// values seen by field key, up to maxCardinality per key
+	seen map[string]map[string]struct{}
+}
+
+// NewGuardrailsProcessor returns a guardrails.Processor configured with the
+// options max_fields (default 50), max_key_length (default 128),
+// max_value_length (default 1024), max_keys (the most distinct field keys,
+// default 1000), max_cardinality (the most distinct values per key, default
+// 1000), fields (the keys whose cardinality is limited, default all), action
+// (drop or hash excess fields, default drop) and hash_buckets (the values
+// excess values are hashed to, default 16). Limits of 0 are unlimited.
+func NewGuardrailsProcessor(route *router.Route, options map[string]string) (router.Processor, error) {
+	p := &Processor{
+		route:  route,
+		action: actionDrop,
+		seen:   make(map[string]map[string]struct{}),
+	}
+	limits := []struct {
+		name   string
+		dfault int
+		value  *int
+	}{
+		{"max_fields", 50, &p.maxFields},
+		{"max_key_length", 128, &p.maxKeyLength},
+		{"max_value_length", 1024, &p.maxValueLength},
+		{"max_keys", 1000, &p.maxKeys},
+		{"max_cardinality", 1000, &p.maxCardinality},
+	}
+	for _, limit := range limits {
+		*limit.value = limit.dfault
+		if value := options[limit.name]; value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, errors.New("guardrails: invalid value for " + limit.name + ": " + value)
+			}
+			*limit.value = n
+		}
+	}
+	if p.maxKeyLength > 0 && p.maxKeyLength < 16 {
+		return nil, errors.New("guardrails: invalid value for max_key_length (must be at least 16): " + options["max_key_length"])
+	}
+	if value := options["action"]; value != "" {
+		if value != actionDrop && value != actionHash {
+			return nil, errors.New("guardrails: invalid value for action (must be drop|hash): " + value)
+		}
+		p.action = value
+	}
+	p.hashBuckets = 16
+	if value := options["hash_buckets"]; value != "" {
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || n == 0 {
+			return nil, errors.New("guardrails: invalid value for hash_buckets: " + value)
+		}
+		p.hashBuckets = uint32(n)
+	}
+	if options["fields"] != "" {
+		p.fields = make(map[string]bool)
+		for _, key := range strings.Split(options["fields"], ",") {
+			p.fields[strings.TrimSpace(key)] = true
+		}
+	}
+	return p, nil
+}
+
+// Process limits the fields of each message, counting what it changes
+func (p *Processor) Process(in chan *router.Message, out chan *router.Message) {
+	for message := range in {
+		if len(message.Fields) == 0 {
+			out <- message
+			continue
+		}
+		out <- p.limit(message)
+	}
+}
+
+func (p *Processor) count(name string, n int) {
+	if n > 0 {
+		router.Counters.Add(p.route, "guardrails."+name, uint64(n))
+	}
+}
+
+// limit returns message with its fields limited
+func (p *Processor) limit(message *router.Message) *router.Message {
+	keys := make([]string, 0, len(message.Fields))
+	for key := range message.Fields {
+		keys = append(keys, key)
+	}
+	// keep the same fields of messages with too many
+	sort.Strings(keys)
+	fields := make(map[string]string, len(keys))
+	var dropped, hashedKeys, truncated, hashedValues int
+	for _, key := range keys {
+		value := message.Fields[key]
+		if p.maxFields > 0 && len(fields) >= p.maxFields {
+			dropped++
+			continue
+		}
+		if p.maxKeyLength > 0 && len(key) > p.maxKeyLength {
+			if p.action == actionDrop {
+				dropped++
+				continue
+			}
+			key = hashKey(key, p.maxKeyLength)
+			hashedKeys++
+		}
+		if p.maxValueLength > 0 && len(value) > p.maxValueLength {
+			value = value[:p.maxValueLength]
+			truncated++
+		}
+		if !p.track(key) {
+			// excess keys can't be hashed to a bounded set
+			dropped++
+			continue
+		}
+		if !p.allow(key, value) {
+			if p.action == actionDrop {
+				dropped++
+				continue
+			}
+			value = p.bucket(value)
+			hashedValues++
+		}
+		fields[key] = value
+	}
+	if dropped+hashedKeys+truncated+hashedValues == 0 {
+		return message
+	}
+	p.count("fields_dropped", dropped)
+	p.count("keys_hashed", hashedKeys)
+	p.count("values_truncated", truncated)
+	p.count("values_hashed", hashedValues)
+	limited := message.Copy()
+	limited.Fields = fields
+	return limited
+}
+
+// track returns whether key is within the limit of distinct keys, and
+// remembers it if it is
+func (p *Processor) track(key string) bool {
+	if _, ok := p.seen[key]; ok {
+		return true
+	}
+	if p.maxKeys > 0 && len(p.seen) >= p.maxKeys {
+		return false
+	}
+	p.seen[key] = make(map[string]struct{})
+	return true
+}
+
+// allow returns whether value is within the cardinality limit of key, and
+// remembers it if it is
+func (p *Processor) allow(key, value string) bool {
+	values := p.seen[key]
+	if p.maxCardinality == 0 || (p.fields != nil && !p.fields[key]) {
+		return true
+	}
+	if _, ok := values[value]; ok {
+		return true
+	}
+	if len(values) >= p.maxCardinality {
+		return false
+	}
+	values[value] = struct{}{}
+	return true
+}
+
+// bucket returns one of hashBuckets values for an excess value
+func (p *Processor) bucket(value string) string {
+	h := fnv.New32a()
+	h.Write([]byte(value))
+	return fmt.Sprintf("hash-%d", h.Sum32()%p.hashBuckets)
+}
+
+// hashKey shortens key to max characters, keeping distinct keys distinct
+func hashKey(key string, max int) string {
+	sum := sha256.Sum256([]byte(key))
+	suffix := "-" + hex.EncodeToString(sum[:4])
+	return key[:max-len(suffix)] + suffix
+}
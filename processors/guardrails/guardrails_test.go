@@ -0,0 +1,119 @@
+package guardrails
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func process(p router.Processor, messages ...*router.Message) []*router.Message {
+	in := make(chan *router.Message, len(messages))
+	out := make(chan *router.Message, len(messages))
+	for _, message := range messages {
+		in <- message
+	}
+	close(in)
+	p.Process(in, out)
+	close(out)
+	var processed []*router.Message
+	for message := range out {
+		processed = append(processed, message)
+	}
+	return processed
+}
+
+func TestGuardrailsLimits(t *testing.T) {
+	route := &router.Route{ID: "guardrails-limits"}
+	p, err := NewGuardrailsProcessor(route, map[string]string{"max_fields": "2", "max_value_length": "4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := &router.Message{Data: "hello", Fields: map[string]string{"a": "1", "b": "too long", "c": "3"}}
+	limited := process(p, original)[0]
+	if len(limited.Fields) != 2 || limited.Fields["a"] != "1" || limited.Fields["b"] != "too " {
+		t.Errorf("unexpected fields %v", limited.Fields)
+	}
+	if len(original.Fields) != 3 {
+		t.Errorf("original message was modified: %v", original.Fields)
+	}
+	if router.Counters.Get(route, "guardrails.fields_dropped") != 1 || router.Counters.Get(route, "guardrails.values_truncated") != 1 {
+		t.Errorf("unexpected counters %v %v", router.Counters.Get(route, "guardrails.fields_dropped"),
+			router.Counters.Get(route, "guardrails.values_truncated"))
+	}
+
+	unchanged := &router.Message{Data: "hello", Fields: map[string]string{"a": "1"}}
+	if process(p, unchanged)[0] != unchanged {
+		t.Error("expected messages within the limits passed on as they are")
+	}
+}
+
+func TestGuardrailsCardinality(t *testing.T) {
+	route := &router.Route{ID: "guardrails-cardinality"}
+	p, err := NewGuardrailsProcessor(route, map[string]string{"max_cardinality": "2", "fields": "pod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []*router.Message
+	for i := 0; i < 4; i++ {
+		messages = append(messages, &router.Message{Fields: map[string]string{
+			"pod":  "pod-" + strconv.Itoa(i),
+			"user": "user-" + strconv.Itoa(i),
+		}})
+	}
+	limited := process(p, messages...)
+	if limited[0].Fields["pod"] != "pod-0" || limited[1].Fields["pod"] != "pod-1" {
+		t.Errorf("expected the first values kept got %v %v", limited[0].Fields, limited[1].Fields)
+	}
+	if _, ok := limited[2].Fields["pod"]; ok {
+		t.Errorf("expected excess values dropped got %v", limited[2].Fields)
+	}
+	if limited[3].Fields["user"] != "user-3" {
+		t.Errorf("expected fields without a cardinality limit kept got %v", limited[3].Fields)
+	}
+	if n := router.Counters.Get(route, "guardrails.fields_dropped"); n != 2 {
+		t.Errorf("expected 2 dropped fields got %v", n)
+	}
+}
+
+func TestGuardrailsHash(t *testing.T) {
+	route := &router.Route{ID: "guardrails-hash"}
+	p, err := NewGuardrailsProcessor(route, map[string]string{
+		"action": "hash", "max_cardinality": "1", "hash_buckets": "4", "max_key_length": "20",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("k", 30)
+	limited := process(p,
+		&router.Message{Fields: map[string]string{"pod": "a", long: "v"}},
+		&router.Message{Fields: map[string]string{"pod": "b"}},
+		&router.Message{Fields: map[string]string{"pod": "b"}},
+	)
+	for key := range limited[0].Fields {
+		if key != "pod" && (len(key) != 20 || !strings.HasPrefix(key, "kkkkkkkkkkk-")) {
+			t.Errorf("expected the long key hashed got %q", key)
+		}
+	}
+	hashed := limited[1].Fields["pod"]
+	if !strings.HasPrefix(hashed, "hash-") || limited[2].Fields["pod"] != hashed {
+		t.Errorf("expected excess values hashed to the same bucket got %q %q", hashed, limited[2].Fields["pod"])
+	}
+	if n := router.Counters.Get(route, "guardrails.values_hashed"); n != 2 {
+		t.Errorf("expected 2 hashed values got %v", n)
+	}
+}
+
+func TestGuardrailsInvalidOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"max_fields": "-1"},
+		{"max_key_length": "8"},
+		{"action": "truncate"},
+		{"hash_buckets": "0"},
+	} {
+		if _, err := NewGuardrailsProcessor(&router.Route{}, options); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package parse
+
+import (
+	"strconv"
+	"strings"
+)
+
+// parseLogfmt returns the pairs of a logfmt line like
+// level=info msg="request done" took=12ms. Keys without a value are true
+// when bare keys are allowed, otherwise the line isn't taken as logfmt, so
+// plain text isn't mistaken for it.
+func parseLogfmt(data string, bareKeys bool) (map[string]string, bool) {
+	fields := make(map[string]string)
+	for data != "" {
+		end := strings.IndexAny(data, "= ")
+		if end == 0 {
+			return nil, false
+		}
+		if end < 0 || data[end] == ' ' {
+			if !bareKeys {
+				return nil, false
+			}
+			if end < 0 {
+				end = len(data)
+			}
+			fields[data[:end]] = "true"
+			data = strings.TrimLeft(data[end:], " ")
+			continue
+		}
+		key := data[:end]
+		data = data[end+1:]
+		var value string
+		if strings.HasPrefix(data, `"`) {
+			quoted, rest, ok := quotedValue(data)
+			if !ok {
+				return nil, false
+			}
+			if value, ok = unquote(quoted); !ok {
+				return nil, false
+			}
+			data = rest
+		} else {
+			end = strings.IndexByte(data, ' ')
+			if end < 0 {
+				end = len(data)
+			}
+			value = data[:end]
+			data = data[end:]
+		}
+		if data != "" && data[0] != ' ' {
+			return nil, false
+		}
+		fields[key] = value
+		data = strings.TrimLeft(data, " ")
+	}
+	return fields, len(fields) > 0
+}
+
+// quotedValue splits data, starting with a quote, after the closing quote
+func quotedValue(data string) (string, string, bool) {
+	for i := 1; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return data[:i+1], data[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+func unquote(quoted string) (string, bool) {
+	value, err := strconv.Unquote(quoted)
+	if err != nil {
+		// logfmt writers don't all escape like Go
+		return strings.Replace(quoted[1:len(quoted)-1], `\"`, `"`, -1), true
+	}
+	return value, true
+}
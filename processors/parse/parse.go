@@ -0,0 +1,134 @@
+package parse
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	formatAuto   = "auto"
+	formatJSON   = "json"
+	formatLogfmt = "logfmt"
+)
+
+func init() {
+	router.ProcessorFactories.Register(NewParseProcessor, "parse")
+	router.Capabilities.DescribeProcessor("parse", []string{"format", "prefix", "fields", "overwrite"})
+}
+
+// Processor parses JSON or logfmt message data into message fields
+type Processor struct {
+	format    string
+	prefix    string
+	fields    map[string]bool
+	overwrite bool
+}
+
+// NewParseProcessor returns a parse.Processor configured with the options
+// format (json, logfmt or auto to detect either, default auto), prefix
+// (prepended to field names), fields (the comma separated fields to keep,
+// default all) and overwrite (replace fields the message already has)
+func NewParseProcessor(route *router.Route, options map[string]string) (router.Processor, error) {
+	p := &Processor{format: formatAuto, prefix: options["prefix"]}
+	if value := options["format"]; value != "" {
+		if value != formatAuto && value != formatJSON && value != formatLogfmt {
+			return nil, errors.New("parse: invalid value for format (must be auto|json|logfmt): " + value)
+		}
+		p.format = value
+	}
+	if options["fields"] != "" {
+		p.fields = make(map[string]bool)
+		for _, field := range strings.Split(options["fields"], ",") {
+			p.fields[strings.TrimSpace(field)] = true
+		}
+	}
+	switch options["overwrite"] {
+	case "", "false":
+	case "true":
+		p.overwrite = true
+	default:
+		return nil, errors.New("parse: invalid value for overwrite (must be true|false): " + options["overwrite"])
+	}
+	return p, nil
+}
+
+// Process adds the fields parsed from each message's data. Messages that
+// aren't JSON or logfmt are passed on unchanged.
+func (p *Processor) Process(in chan *router.Message, out chan *router.Message) {
+	for message := range in {
+		fields := p.parse(message.Data)
+		if len(fields) == 0 {
+			out <- message
+			continue
+		}
+		parsed := message.Copy()
+		if parsed.Fields == nil {
+			parsed.Fields = make(map[string]string, len(fields))
+		}
+		for key, value := range fields {
+			if p.fields != nil && !p.fields[key] {
+				continue
+			}
+			key = p.prefix + key
+			if _, exists := parsed.Fields[key]; exists && !p.overwrite {
+				continue
+			}
+			parsed.Fields[key] = value
+		}
+		out <- parsed
+	}
+}
+
+func (p *Processor) parse(data string) map[string]string {
+	trimmed := strings.TrimSpace(data)
+	if p.format != formatLogfmt && strings.HasPrefix(trimmed, "{") {
+		if fields, ok := parseJSON(trimmed); ok {
+			return fields
+		}
+	}
+	if p.format != formatJSON {
+		if fields, ok := parseLogfmt(trimmed, p.format == formatLogfmt); ok {
+			return fields
+		}
+	}
+	return nil
+}
+
+// parseJSON returns the values of a JSON object, with nested objects
+// flattened into dotted keys and arrays kept as JSON
+func parseJSON(data string) (map[string]string, bool) {
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	var object map[string]interface{}
+	if err := dec.Decode(&object); err != nil || dec.More() {
+		return nil, false
+	}
+	fields := make(map[string]string)
+	flatten(fields, "", object)
+	return fields, true
+}
+
+func flatten(fields map[string]string, prefix string, object map[string]interface{}) {
+	for key, value := range object {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			flatten(fields, prefix+key+".", value)
+		case string:
+			fields[prefix+key] = value
+		case json.Number:
+			fields[prefix+key] = value.String()
+		case nil:
+			fields[prefix+key] = ""
+		default:
+			buf := new(bytes.Buffer)
+			enc := json.NewEncoder(buf)
+			enc.SetEscapeHTML(false)
+			enc.Encode(value)
+			fields[prefix+key] = strings.TrimSuffix(buf.String(), "\n")
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package parse
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		options  map[string]string
+		data     string
+		expected map[string]string
+	}{
+		{nil, `{"level":"info","status":200,"ok":true,"http":{"method":"GET"},"tags":["a","b"],"trace":null}`,
+			map[string]string{"level": "info", "status": "200", "ok": "true", "http.method": "GET", "tags": `["a","b"]`, "trace": ""}},
+		{nil, `level=warn msg="disk \"full\"" took=12ms`,
+			map[string]string{"level": "warn", "msg": `disk "full"`, "took": "12ms"}},
+		{nil, `just some text`, nil},
+		{nil, `{"broken": `, nil},
+		{map[string]string{"format": "logfmt"}, `level=info cached`,
+			map[string]string{"level": "info", "cached": "true"}},
+		{map[string]string{"format": "json"}, `level=info`, nil},
+		{map[string]string{"prefix": "log.", "fields": "level"}, `level=info user=alice`,
+			map[string]string{"log.level": "info"}},
+		{nil, `key="unterminated`, nil},
+	}
+	for _, test := range tests {
+		p, err := NewParseProcessor(&router.Route{}, test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := make(chan *router.Message, 1)
+		out := make(chan *router.Message, 1)
+		in <- &router.Message{Data: test.data}
+		close(in)
+		p.Process(in, out)
+		if fields := (<-out).Fields; !reflect.DeepEqual(fields, test.expected) {
+			t.Errorf("%s: expected %v got %v", test.data, test.expected, fields)
+		}
+	}
+}
+
+func TestParseKeepsFields(t *testing.T) {
+	for _, test := range []struct {
+		overwrite string
+		expected  string
+	}{{"", "existing"}, {"true", "parsed"}} {
+		p, err := NewParseProcessor(&router.Route{}, map[string]string{"overwrite": test.overwrite})
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := make(chan *router.Message, 1)
+		out := make(chan *router.Message, 1)
+		original := &router.Message{Data: "level=parsed", Fields: map[string]string{"level": "existing"}}
+		in <- original
+		close(in)
+		p.Process(in, out)
+		if level := (<-out).Fields["level"]; level != test.expected {
+			t.Errorf("expected %q got %q", test.expected, level)
+		}
+		if original.Fields["level"] != "existing" {
+			t.Errorf("original message was modified: %v", original.Fields)
+		}
+	}
+}
+
+func TestParseInvalidOptions(t *testing.T) {
+	for _, options := range []map[string]string{{"format": "xml"}, {"overwrite": "yes"}} {
+		if _, err := NewParseProcessor(&router.Route{}, options); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+}
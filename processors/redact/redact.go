@@ -0,0 +1,53 @@
+package redact
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const defaultReplacement = "[REDACTED]"
+
+func init() {
+	router.ProcessorFactories.Register(NewRedactProcessor, "redact")
+	router.Capabilities.DescribeProcessor("redact", []string{"pattern", "replacement"})
+}
+
+// Processor masks sensitive content in messages
+type Processor struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// NewRedactProcessor returns a redact.Processor configured with the options
+// pattern (required) and replacement, which may refer to submatches like $1
+func NewRedactProcessor(route *router.Route, options map[string]string) (router.Processor, error) {
+	if options["pattern"] == "" {
+		return nil, errors.New("redact: pattern is required")
+	}
+	pattern, err := regexp.Compile(options["pattern"])
+	if err != nil {
+		return nil, errors.New("redact: invalid value for pattern (must be regexp): " + options["pattern"])
+	}
+	replacement := defaultReplacement
+	if value, ok := options["replacement"]; ok {
+		replacement = value
+	}
+	return &Processor{
+		pattern:     pattern,
+		replacement: replacement,
+	}, nil
+}
+
+// Process replaces all matches of the pattern in each message
+func (p *Processor) Process(in chan *router.Message, out chan *router.Message) {
+	for message := range in {
+		if p.pattern.MatchString(message.Data) {
+			redacted := message.Copy()
+			redacted.Data = p.pattern.ReplaceAllString(message.Data, p.replacement)
+			message = redacted
+		}
+		out <- message
+	}
+}
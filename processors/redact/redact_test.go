@@ -0,0 +1,43 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		options  map[string]string
+		in       string
+		expected string
+	}{
+		{map[string]string{"pattern": `password=\S+`}, "login password=hunter2 ok", "login [REDACTED] ok"},
+		{map[string]string{"pattern": `(card=)\d+`, "replacement": "${1}****"}, "card=4111111111111111", "card=****"},
+		{map[string]string{"pattern": `secret`}, "nothing to see", "nothing to see"},
+	}
+	for _, test := range tests {
+		p, err := NewRedactProcessor(&router.Route{}, test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := make(chan *router.Message, 1)
+		out := make(chan *router.Message, 1)
+		original := &router.Message{Data: test.in}
+		in <- original
+		close(in)
+		p.Process(in, out)
+		if actual := (<-out).Data; actual != test.expected {
+			t.Errorf("expected %q got %q", test.expected, actual)
+		}
+		if original.Data != test.in {
+			t.Errorf("original message was modified: %q", original.Data)
+		}
+	}
+}
+
+func TestRedactRequiresPattern(t *testing.T) {
+	if _, err := NewRedactProcessor(&router.Route{}, map[string]string{}); err == nil {
+		t.Error("expected error without pattern")
+	}
+}
@@ -0,0 +1,123 @@
+package schema
+
+import (
+	"strings"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// mapping sets the target field from the first of the from fields the
+// message has, converted when convert is set, or else from the container
+type mapping struct {
+	target    string
+	from      []string
+	convert   func(string) string
+	container func(*router.Message) string
+}
+
+// preset is a named field schema
+type preset struct {
+	mappings []mapping
+	// labels is the prefix of container label fields
+	labels string
+}
+
+var (
+	levelFields = []string{"level", "severity", "lvl", "log.level"}
+	traceFields = []string{"trace_id", "traceId", "trace.id"}
+	spanFields  = []string{"span_id", "spanId", "span.id"}
+)
+
+var presets = map[string]*preset{
+	// Elastic Common Schema, https://www.elastic.co/guide/en/ecs/current/
+	"ecs": {
+		mappings: []mapping{
+			{target: "container.id", container: containerID},
+			{target: "container.name", container: containerName},
+			{target: "container.image.name", container: image},
+			{target: "host.hostname", container: hostname},
+			{target: "stream", container: source},
+			{target: "log.level", from: levelFields, convert: strings.ToLower},
+			{target: "trace.id", from: traceFields},
+			{target: "span.id", from: spanFields},
+		},
+		labels: "container.labels.",
+	},
+	// OpenTelemetry log data model with semantic convention attributes,
+	// https://opentelemetry.io/docs/specs/otel/logs/data-model/
+	"otel": {
+		mappings: []mapping{
+			{target: "container.id", container: containerID},
+			{target: "container.name", container: containerName},
+			{target: "container.image.name", container: image},
+			{target: "host.name", container: hostname},
+			{target: "log.iostream", container: source},
+			{target: "severity_text", from: levelFields, convert: strings.ToUpper},
+			{target: "severity_number", from: levelFields, convert: severityNumber},
+			{target: "trace_id", from: traceFields},
+			{target: "span_id", from: spanFields},
+		},
+		labels: "container.label.",
+	},
+	// Splunk Common Information Model, with the container fields of Splunk
+	// Connect for Kubernetes
+	"cim": {
+		mappings: []mapping{
+			{target: "host", container: hostname},
+			{target: "app", container: containerName},
+			{target: "container_id", container: containerID},
+			{target: "container_name", container: containerName},
+			{target: "container_image", container: image},
+			{target: "stream", container: source},
+			{target: "severity", from: levelFields, convert: strings.ToLower},
+			{target: "trace_id", from: traceFields},
+			{target: "span_id", from: spanFields},
+		},
+		labels: "label_",
+	},
+}
+
+func containerID(m *router.Message) string {
+	return m.Container.ID
+}
+
+func containerName(m *router.Message) string {
+	return strings.TrimPrefix(m.Container.Name, "/")
+}
+
+func image(m *router.Message) string {
+	if m.Container.Config == nil {
+		return ""
+	}
+	return m.Container.Config.Image
+}
+
+func hostname(m *router.Message) string {
+	if m.Container.Config == nil {
+		return ""
+	}
+	return m.Container.Config.Hostname
+}
+
+func source(m *router.Message) string {
+	return m.Source
+}
+
+// severityNumber returns the OpenTelemetry severity number of a level
+func severityNumber(level string) string {
+	switch strings.ToLower(level) {
+	case "trace":
+		return "1"
+	case "debug":
+		return "5"
+	case "info", "notice":
+		return "9"
+	case "warn", "warning":
+		return "13"
+	case "error", "err":
+		return "17"
+	case "fatal", "critical", "crit", "panic", "alert", "emerg":
+		return "21"
+	}
+	return ""
+}
@@ -0,0 +1,121 @@
+package schema
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func init() {
+	router.ProcessorFactories.Register(NewSchemaProcessor, "schema")
+	router.Capabilities.DescribeProcessor("schema", []string{"preset", "labels", "keep"})
+}
+
+// Processor renames message fields and adds container metadata following a
+// named schema, so structured adapters emit what the destination expects
+type Processor struct {
+	preset *preset
+	labels bool
+	keep   bool
+}
+
+// NewSchemaProcessor returns a schema.Processor configured with the options
+// preset (ecs, otel or cim), labels (also add container labels, default
+// false) and keep (keep the fields a preset renamed, default false)
+func NewSchemaProcessor(route *router.Route, options map[string]string) (router.Processor, error) {
+	p := &Processor{preset: presets[options["preset"]]}
+	if p.preset == nil {
+		return nil, errors.New("schema: invalid value for preset (must be " + strings.Join(presetNames(), "|") + "): " + options["preset"])
+	}
+	var err error
+	if p.labels, err = parseBool(options, "labels"); err != nil {
+		return nil, err
+	}
+	if p.keep, err = parseBool(options, "keep"); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func parseBool(options map[string]string, name string) (bool, error) {
+	switch options[name] {
+	case "", "false":
+		return false, nil
+	case "true":
+		return true, nil
+	}
+	return false, errors.New("schema: invalid value for " + name + " (must be true|false): " + options[name])
+}
+
+func presetNames() []string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Process maps the fields of each message onto the preset's schema
+func (p *Processor) Process(in chan *router.Message, out chan *router.Message) {
+	for message := range in {
+		out <- p.apply(message)
+	}
+}
+
+func (p *Processor) apply(message *router.Message) *router.Message {
+	mapped := message.Copy()
+	fields := make(map[string]string, len(message.Fields)+len(p.preset.mappings))
+	for key, value := range message.Fields {
+		fields[key] = value
+	}
+	renamed := make(map[string]bool)
+	for _, m := range p.preset.mappings {
+		var value string
+		if m.container != nil {
+			if message.Container != nil {
+				value = m.container(message)
+			}
+		} else {
+			for _, from := range m.from {
+				if v, ok := message.Fields[from]; ok && v != "" {
+					value = v
+					renamed[from] = true
+					break
+				}
+			}
+			if value != "" && m.convert != nil {
+				value = m.convert(value)
+			}
+		}
+		if value != "" {
+			fields[m.target] = value
+		}
+	}
+	if !p.keep {
+		for from := range renamed {
+			if !p.target(from) {
+				delete(fields, from)
+			}
+		}
+	}
+	if p.labels && message.Container != nil && message.Container.Config != nil {
+		for key, value := range message.Container.Config.Labels {
+			fields[p.preset.labels+key] = value
+		}
+	}
+	mapped.Fields = fields
+	return mapped
+}
+
+// target returns whether key is a field the preset sets
+func (p *Processor) target(key string) bool {
+	for _, m := range p.preset.mappings {
+		if m.target == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+var container = &docker.Container{
+	ID:   "8dfafdbc3a40b1b0bd3a0f5c",
+	Name: "/api",
+	Config: &docker.Config{
+		Image:    "api:1.0",
+		Hostname: "8dfafdbc3a40",
+		Labels:   map[string]string{"team": "payments"},
+	},
+}
+
+func process(t *testing.T, options map[string]string, message *router.Message) *router.Message {
+	p, err := NewSchemaProcessor(&router.Route{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan *router.Message, 1)
+	out := make(chan *router.Message, 1)
+	in <- message
+	close(in)
+	p.Process(in, out)
+	return <-out
+}
+
+func TestSchemaPresets(t *testing.T) {
+	tests := []struct {
+		preset   string
+		expected map[string]string
+	}{
+		{"ecs", map[string]string{
+			"container.id": container.ID, "container.name": "api", "container.image.name": "api:1.0",
+			"host.hostname": "8dfafdbc3a40", "stream": "stderr", "log.level": "warn",
+			"trace.id": "4bf92f3577b34da6", "user": "alice",
+		}},
+		{"otel", map[string]string{
+			"container.id": container.ID, "container.name": "api", "container.image.name": "api:1.0",
+			"host.name": "8dfafdbc3a40", "log.iostream": "stderr", "severity_text": "WARN",
+			"severity_number": "13", "trace_id": "4bf92f3577b34da6", "user": "alice",
+		}},
+		{"cim", map[string]string{
+			"host": "8dfafdbc3a40", "app": "api", "container_id": container.ID, "container_name": "api",
+			"container_image": "api:1.0", "stream": "stderr", "severity": "warn",
+			"trace_id": "4bf92f3577b34da6", "user": "alice",
+		}},
+	}
+	for _, test := range tests {
+		original := &router.Message{
+			Container: container,
+			Source:    "stderr",
+			Data:      "slow request",
+			Fields:    map[string]string{"level": "WARN", "traceId": "4bf92f3577b34da6", "user": "alice"},
+		}
+		message := process(t, map[string]string{"preset": test.preset}, original)
+		if !reflect.DeepEqual(message.Fields, test.expected) {
+			t.Errorf("%s: expected %v got %v", test.preset, test.expected, message.Fields)
+		}
+		if original.Fields["level"] != "WARN" || len(original.Fields) != 3 {
+			t.Errorf("%s: original message was modified: %v", test.preset, original.Fields)
+		}
+	}
+}
+
+func TestSchemaLabelsAndKeep(t *testing.T) {
+	message := process(t, map[string]string{"preset": "ecs", "labels": "true", "keep": "true"}, &router.Message{
+		Container: container,
+		Fields:    map[string]string{"level": "info"},
+	})
+	if message.Fields["container.labels.team"] != "payments" {
+		t.Errorf("expected the team label got %v", message.Fields)
+	}
+	if message.Fields["level"] != "info" || message.Fields["log.level"] != "info" {
+		t.Errorf("expected level to be kept got %v", message.Fields)
+	}
+}
+
+func TestSchemaInvalidOptions(t *testing.T) {
+	for _, options := range []map[string]string{{}, {"preset": "gelf"}, {"preset": "ecs", "labels": "yes"}} {
+		if _, err := NewSchemaProcessor(&router.Route{}, options); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const fieldPrefix = "field."
+
+func init() {
+	router.ProcessorFactories.Register(NewTransformProcessor, "transform")
+	router.Capabilities.DescribeProcessor("transform", []string{"drop", "when", "data", "field.<name>"})
+}
+
+var (
+	regexpsMu sync.Mutex
+	regexps   = make(map[string]*regexp.Regexp)
+)
+
+// compile caches the regexps used in expressions, which are evaluated per message
+func compile(pattern string) (*regexp.Regexp, error) {
+	regexpsMu.Lock()
+	defer regexpsMu.Unlock()
+	if re, ok := regexps[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexps[pattern] = re
+	return re, nil
+}
+
+var funcs = template.FuncMap{
+	"contains":  strings.Contains,
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"trim":      strings.TrimSpace,
+	"replace": func(old, new, s string) string {
+		return strings.Replace(s, old, new, -1)
+	},
+	"match": func(pattern, s string) (bool, error) {
+		re, err := compile(pattern)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(s), nil
+	},
+	"replaceRegexp": func(pattern, repl, s string) (string, error) {
+		re, err := compile(pattern)
+		if err != nil {
+			return "", err
+		}
+		return re.ReplaceAllString(s, repl), nil
+	},
+	"toJSON": func(value interface{}) (string, error) {
+		bytes, err := json.Marshal(value)
+		return string(bytes), err
+	},
+}
+
+// Message extends router.Message with helpers for transform expressions
+type Message struct {
+	*router.Message
+}
+
+// ContainerName returns the container name without the leading slash
+func (m *Message) ContainerName() string {
+	return strings.TrimPrefix(m.Message.Container.Name, "/")
+}
+
+// Label returns the value of a container label
+func (m *Message) Label(key string) string {
+	if m.Message.Container.Config == nil {
+		return ""
+	}
+	return m.Message.Container.Config.Labels[key]
+}
+
+// Env returns the value of a container environment variable
+func (m *Message) Env(key string) string {
+	if m.Message.Container.Config == nil {
+		return ""
+	}
+	for _, kv := range m.Message.Container.Config.Env {
+		kvp := strings.SplitN(kv, "=", 2)
+		if len(kvp) == 2 && kvp[0] == key {
+			return kvp[1]
+		}
+	}
+	return ""
+}
+
+// Field returns the value of a message field
+func (m *Message) Field(key string) string {
+	return m.Message.Fields[key]
+}
+
+type fieldExpr struct {
+	name string
+	tmpl *template.Template
+}
+
+// Processor mutates or drops messages using template expressions
+type Processor struct {
+	drop   *template.Template
+	when   *template.Template
+	data   *template.Template
+	fields []fieldExpr
+}
+
+// NewTransformProcessor returns a transform.Processor configured with the
+// options drop (drop messages the template evaluates true for), when (only
+// transform messages it evaluates true for), data (the template replacing
+// Data) and field.<name> (templates setting fields)
+func NewTransformProcessor(route *router.Route, options map[string]string) (router.Processor, error) {
+	p := new(Processor)
+	var err error
+	parse := func(name string) (*template.Template, error) {
+		if options[name] == "" {
+			return nil, nil
+		}
+		tmpl, err := template.New(name).Funcs(funcs).Parse(options[name])
+		if err != nil {
+			return nil, errors.New("transform: invalid value for " + name + ": " + err.Error())
+		}
+		return tmpl, nil
+	}
+	if p.drop, err = parse("drop"); err != nil {
+		return nil, err
+	}
+	if p.when, err = parse("when"); err != nil {
+		return nil, err
+	}
+	if p.data, err = parse("data"); err != nil {
+		return nil, err
+	}
+	var names []string
+	for key := range options {
+		if strings.HasPrefix(key, fieldPrefix) && len(key) > len(fieldPrefix) {
+			names = append(names, key)
+		}
+	}
+	sort.Strings(names)
+	for _, key := range names {
+		tmpl, err := parse(key)
+		if err != nil {
+			return nil, err
+		}
+		if tmpl != nil {
+			p.fields = append(p.fields, fieldExpr{strings.TrimPrefix(key, fieldPrefix), tmpl})
+		}
+	}
+	if p.drop == nil && p.data == nil && len(p.fields) == 0 {
+		return nil, errors.New("transform: one of drop, data or field.<name> is required")
+	}
+	return p, nil
+}
+
+func render(tmpl *template.Template, m *Message) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, m); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func evaluate(tmpl *template.Template, m *Message) (bool, error) {
+	result, err := render(tmpl, m)
+	return strings.TrimSpace(result) == "true", err
+}
+
+// Process applies the expressions to each message. Messages that fail to
+// evaluate are passed on unchanged.
+func (p *Processor) Process(in chan *router.Message, out chan *router.Message) {
+	for message := range in {
+		transformed, err := p.transform(message)
+		if err != nil {
+			router.NewLogger("transform").Warn("transforming failed", "error", err)
+			transformed = message
+		}
+		if transformed != nil {
+			out <- transformed
+		}
+	}
+}
+
+// transform returns the transformed message, or nil if it should be dropped
+func (p *Processor) transform(message *router.Message) (*router.Message, error) {
+	m := &Message{message}
+	if p.drop != nil {
+		drop, err := evaluate(p.drop, m)
+		if err != nil || drop {
+			return nil, err
+		}
+	}
+	if p.when != nil {
+		when, err := evaluate(p.when, m)
+		if err != nil || !when {
+			return message, err
+		}
+	}
+	if p.data == nil && len(p.fields) == 0 {
+		return message, nil
+	}
+	// expressions all see the original message
+	transformed := message.Copy()
+	if len(p.fields) > 0 && transformed.Fields == nil {
+		transformed.Fields = make(map[string]string)
+	}
+	for _, field := range p.fields {
+		value, err := render(field.tmpl, m)
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			delete(transformed.Fields, field.name)
+		} else {
+			transformed.Fields[field.name] = value
+		}
+	}
+	if p.data != nil {
+		data, err := render(p.data, m)
+		if err != nil {
+			return nil, err
+		}
+		transformed.Data = data
+	}
+	return transformed, nil
+}
@@ -0,0 +1,98 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+func run(t *testing.T, options map[string]string, messages ...*router.Message) []*router.Message {
+	p, err := NewTransformProcessor(&router.Route{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan *router.Message, len(messages))
+	out := make(chan *router.Message, len(messages))
+	for _, message := range messages {
+		in <- message
+	}
+	close(in)
+	p.Process(in, out)
+	close(out)
+	var result []*router.Message
+	for message := range out {
+		result = append(result, message)
+	}
+	return result
+}
+
+func testMessage(data, source string) *router.Message {
+	return &router.Message{
+		Data:   data,
+		Source: source,
+		Container: &docker.Container{
+			Name: "/web",
+			Config: &docker.Config{
+				Labels: map[string]string{"team": "payments"},
+				Env:    []string{"APP_ENV=staging"},
+			},
+		},
+	}
+}
+
+func TestTransformDrop(t *testing.T) {
+	result := run(t, map[string]string{
+		"drop": `{{ and (eq .Source "stdout") (match "^DEBUG" .Data) }}`,
+	}, testMessage("DEBUG noisy", "stdout"), testMessage("DEBUG kept", "stderr"), testMessage("INFO kept", "stdout"))
+	if len(result) != 2 || result[0].Data != "DEBUG kept" || result[1].Data != "INFO kept" {
+		t.Errorf("unexpected messages %+v", result)
+	}
+}
+
+func TestTransformDataAndFields(t *testing.T) {
+	original := testMessage("card=4111111111111111 ok", "stdout")
+	result := run(t, map[string]string{
+		"when":          `{{ eq (.Label "team") "payments" }}`,
+		"data":          `{{ .ContainerName }}: {{ .Data | replaceRegexp "card=\\d+" "card=****" }}`,
+		"field.env":     `{{ .Env "APP_ENV" | upper }}`,
+		"field.missing": `{{ .Label "nope" }}`,
+	}, original, testMessage("left alone", "stdout"))
+	transformed := result[0]
+	if transformed.Data != "web: card=**** ok" {
+		t.Errorf("unexpected data %q", transformed.Data)
+	}
+	if transformed.Fields["env"] != "STAGING" {
+		t.Errorf("unexpected fields %v", transformed.Fields)
+	}
+	if _, ok := transformed.Fields["missing"]; ok {
+		t.Error("expected empty fields to be removed")
+	}
+	if original.Data != "card=4111111111111111 ok" || original.Fields != nil {
+		t.Errorf("original message was modified: %+v", original)
+	}
+}
+
+func TestTransformWhen(t *testing.T) {
+	message := testMessage("hello", "stdout")
+	message.Container.Config.Labels["team"] = "search"
+	result := run(t, map[string]string{
+		"when": `{{ eq (.Label "team") "payments" }}`,
+		"data": "changed",
+	}, message)
+	if result[0] != message {
+		t.Errorf("expected message to pass through unchanged, got %+v", result[0])
+	}
+}
+
+func TestTransformErrors(t *testing.T) {
+	result := run(t, map[string]string{"data": `{{ .Data | replaceRegexp "(" "" }}`}, testMessage("kept", "stdout"))
+	if len(result) != 1 || result[0].Data != "kept" {
+		t.Errorf("expected message that fails to evaluate to pass unchanged, got %+v", result)
+	}
+	for _, options := range []map[string]string{{}, {"when": "{{ true }}"}, {"data": "{{ .Data"}} {
+		if _, err := NewTransformProcessor(&router.Route{}, options); err == nil {
+			t.Errorf("expected error for options %v", options)
+		}
+	}
+}
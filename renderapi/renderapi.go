@@ -0,0 +1,200 @@
+package renderapi
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/adapters/raw"
+	"github.com/gliderlabs/logspout/adapters/syslog"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/gorilla/mux"
+)
+
+func init() {
+	router.HttpHandlers.Register(RenderAPI, "render")
+}
+
+// RenderRequest is a template dry-run request
+type RenderRequest struct {
+	Adapter     string            `json:"adapter"`
+	Template    string            `json:"template,omitempty"`
+	Options     map[string]string `json:"options,omitempty"`
+	ContainerID string            `json:"container_id,omitempty"`
+	Container   *docker.Container `json:"container,omitempty"`
+	Source      string            `json:"source,omitempty"`
+	Data        string            `json:"data"`
+	Time        time.Time         `json:"time,omitempty"`
+}
+
+// RenderResult is the outcome of a template dry-run
+type RenderResult struct {
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// RenderAPI returns a handler for the render API. It is guarded like the
+// routes API, and renders against live containers only behind a token.
+func RenderAPI() http.Handler {
+	token := os.Getenv("ROUTES_API_TOKEN")
+	r := mux.NewRouter()
+
+	r.HandleFunc("/render", func(w http.ResponseWriter, req *http.Request) {
+		renderReq := new(RenderRequest)
+		if err := unmarshal(req.Body, renderReq); err != nil {
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// without a token anyone could read other containers' details
+		if token == "" {
+			renderReq.ContainerID = ""
+		}
+		container, err := renderContainer(renderReq)
+		if err != nil {
+			http.Error(w, "Bad container: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		result := new(RenderResult)
+		result.Output, err = render(renderReq, container)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(append(marshal(result), '\n'))
+	}).Methods("POST")
+
+	var h http.Handler = r
+	if os.Getenv("ROUTES_READONLY") == "true" {
+		h = http.HandlerFunc(readOnly)
+	}
+	if token != "" {
+		h = authorized(h, token)
+	}
+	return h
+}
+
+// authorized rejects requests to h without the bearer token
+func authorized(h http.Handler, token string) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="logspout"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// readOnly rejects renders when the routes API is read-only
+func readOnly(w http.ResponseWriter, req *http.Request) {
+	http.Error(w, "Forbidden: routes are read-only (ROUTES_READONLY)", http.StatusForbidden)
+}
+
+func render(renderReq *RenderRequest, container *docker.Container) (string, error) {
+	route := &router.Route{
+		Adapter: renderReq.Adapter,
+		Options: renderReq.Options,
+	}
+	if route.Options == nil {
+		route.Options = make(map[string]string)
+	}
+	message := &router.Message{
+		Container: container,
+		Source:    renderReq.Source,
+		Data:      renderReq.Data,
+		Time:      renderReq.Time,
+	}
+	if message.Source == "" {
+		message.Source = "stdout"
+	}
+	if message.Time.IsZero() {
+		message.Time = time.Now()
+	}
+
+	var tmpl *template.Template
+	var err error
+	switch route.AdapterType() {
+	case "syslog":
+		if renderReq.Template != "" {
+			tmpl, err = syslog.ParseTemplate(renderReq.Template)
+		} else {
+			tmpl, err = syslog.NewTemplate(route)
+		}
+		if err != nil {
+			return "", err
+		}
+		buf, err := (&syslog.Message{Message: message}).Render(tmpl)
+		return string(buf), err
+	case "raw", "tcp", "udp", "tls", "":
+		if renderReq.Template != "" {
+			tmpl, err = raw.ParseTemplate(renderReq.Template)
+		} else {
+			tmpl, err = raw.NewTemplate(route)
+		}
+		if err != nil {
+			return "", err
+		}
+		buf := new(bytes.Buffer)
+		err = tmpl.Execute(buf, message)
+		return buf.String(), err
+	default:
+		return "", errors.New("unsupported adapter: " + route.Adapter)
+	}
+}
+
+// renderContainer returns the container to render against: a live container
+// when an ID is given, the supplied sample, or a placeholder otherwise
+func renderContainer(renderReq *RenderRequest) (*docker.Container, error) {
+	if renderReq.ContainerID != "" {
+		client, err := docker.NewClientFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return client.InspectContainer(renderReq.ContainerID)
+	}
+	container := renderReq.Container
+	if container == nil {
+		container = &docker.Container{
+			ID:   "000000000000",
+			Name: "/sample",
+		}
+	}
+	if container.Name == "" {
+		container.Name = "/sample"
+	}
+	if container.Config == nil {
+		container.Config = &docker.Config{Hostname: normalID(container.ID)}
+	}
+	if container.HostConfig == nil {
+		container.HostConfig = &docker.HostConfig{}
+	}
+	return container, nil
+}
+
+func normalID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+func marshal(obj interface{}) []byte {
+	bytes, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		router.NewLogger("renderapi").Error("marshalling failed", "error", err)
+	}
+	return bytes
+}
+
+func unmarshal(input io.Reader, obj interface{}) error {
+	dec := json.NewDecoder(input)
+	return dec.Decode(obj)
+}
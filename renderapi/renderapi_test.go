@@ -0,0 +1,123 @@
+package renderapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestRenderRaw(t *testing.T) {
+	renderReq := &RenderRequest{
+		Adapter:  "raw",
+		Template: "{{ .Container.Name }} {{ .Source }} {{ toJSON .Data }}\n",
+		Data:     "hello \"world\"",
+	}
+	container, err := renderContainer(renderReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := render(renderReq, container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "/sample stdout \"hello \\\"world\\\"\"\n"
+	if out != expected {
+		t.Errorf("expected %q got %q", expected, out)
+	}
+}
+
+func TestRenderSyslog(t *testing.T) {
+	renderReq := &RenderRequest{
+		Adapter:  "syslog",
+		Template: "<{{.Priority}}>{{.Timestamp}} {{.ContainerName}}: {{.Data}}\n",
+		Container: &docker.Container{
+			ID:   "8dfafdbc3a40",
+			Name: "/container",
+		},
+		Source: "stderr",
+		Data:   "oops",
+		Time:   time.Date(2018, 11, 7, 13, 11, 54, 0, time.UTC),
+	}
+	container, err := renderContainer(renderReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := render(renderReq, container)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "<11>2018-11-07T13:11:54Z container: oops\n"
+	if out != expected {
+		t.Errorf("expected %q got %q", expected, out)
+	}
+}
+
+func TestRenderAPITemplateError(t *testing.T) {
+	body := strings.NewReader(`{"adapter": "raw", "template": "{{ .Nope }", "data": "x"}`)
+	req := httptest.NewRequest("POST", "/render", body)
+	rec := httptest.NewRecorder()
+	RenderAPI().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected %v got %v", http.StatusOK, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"error"`) {
+		t.Errorf("expected template error in response, got %s", rec.Body.String())
+	}
+}
+
+func TestRenderAPIAuth(t *testing.T) {
+	os.Setenv("ROUTES_API_TOKEN", "s3cret")
+	defer os.Unsetenv("ROUTES_API_TOKEN")
+	h := RenderAPI()
+	for _, test := range []struct {
+		auth   string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer s3cret", http.StatusOK},
+	} {
+		req := httptest.NewRequest("POST", "/render", strings.NewReader(`{"data": "x"}`))
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%q: expected %v got %v", test.auth, test.status, rec.Code)
+		}
+	}
+}
+
+func TestRenderAPIReadOnly(t *testing.T) {
+	os.Setenv("ROUTES_READONLY", "true")
+	defer os.Unsetenv("ROUTES_READONLY")
+	req := httptest.NewRequest("POST", "/render", strings.NewReader(`{"data": "x"}`))
+	rec := httptest.NewRecorder()
+	RenderAPI().ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected %v got %v", http.StatusForbidden, rec.Code)
+	}
+}
+
+func TestRenderAPIContainerIDWithoutToken(t *testing.T) {
+	os.Unsetenv("ROUTES_API_TOKEN")
+	// no docker daemon is reached: the id is ignored for the sample container
+	os.Setenv("DOCKER_HOST", "tcp://127.0.0.1:1")
+	defer os.Unsetenv("DOCKER_HOST")
+	body := strings.NewReader(`{"template": "{{ .Container.Name }}", "container_id": "a9efd0aeb470", "data": "x"}`)
+	req := httptest.NewRequest("POST", "/render", body)
+	rec := httptest.NewRecorder()
+	RenderAPI().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected %v got %v: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"output": "/sample"`) {
+		t.Errorf("expected the sample container, got %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const replayUsage = "usage: logspout replay <fixtures.ndjson|-> --route <uri> [--rate <messages/s>] [--keep-time]"
+
+// replay sends recorded messages through a route and returns the exit status
+func replay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	uri := fs.String("route", "", "route URI to replay the messages to")
+	opts := router.ReplayOptions{}
+	fs.Float64Var(&opts.Rate, "rate", 0, "maximum messages per second, 0 for unlimited")
+	fs.BoolVar(&opts.KeepTime, "keep-time", false, "send messages with their recorded time")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, replayUsage)
+		fs.PrintDefaults()
+	}
+
+	// allow flags before and after the fixtures file
+	var path string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return 2
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		if path != "" {
+			fs.Usage()
+			return 2
+		}
+		path = fs.Arg(0)
+		args = fs.Args()[1:]
+	}
+	if path == "" || *uri == "" {
+		fs.Usage()
+		return 2
+	}
+
+	var fixtures io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Println("!!", err)
+			return 1
+		}
+		defer f.Close()
+		fixtures = f
+	}
+	sent, err := router.Replay(*uri, fixtures, opts)
+	fmt.Printf("# replayed %v messages to %s\n", sent, *uri)
+	if err != nil {
+		fmt.Println("!!", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,54 @@
+package router
+
+import (
+	"sort"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// admitContainer returns the decision of the registered container filters,
+// run in order of their names, on container. It is ignored if any filter
+// ignores it, and limited to the routes all filters with route hints agree
+// on. Ignored containers are returned with the name of the filter that
+// ignored them.
+func admitContainer(container *docker.Container) (Admission, string) {
+	filters := ContainerFilters.All()
+	names := make([]string, 0, len(filters))
+	for name := range filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var admitted Admission
+	hinted := false
+	for _, name := range names {
+		admission := filters[name](container)
+		if admission.Ignore {
+			return Admission{Ignore: true}, name
+		}
+		if len(admission.Routes) == 0 {
+			continue
+		}
+		if !hinted {
+			admitted.Routes, hinted = admission.Routes, true
+			continue
+		}
+		var routes []string
+		for _, id := range admitted.Routes {
+			if contains(admission.Routes, id) {
+				routes = append(routes, id)
+			}
+		}
+		if len(routes) == 0 {
+			// filters that agree on no route leave the container to none
+			return Admission{Ignore: true}, name
+		}
+		admitted.Routes = routes
+	}
+	return admitted, ""
+}
+
+// admits returns whether the container filters let the pump's container be
+// sent to route
+func (cp *containerPump) admits(route *Route) bool {
+	return len(cp.routes) == 0 || contains(cp.routes, route.ID)
+}
@@ -0,0 +1,52 @@
+package router
+
+import (
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestAdmitContainer(t *testing.T) {
+	ContainerFilters.Register(func(container *docker.Container) Admission {
+		return Admission{Ignore: container.Config.Labels["team"] == "batch"}
+	}, "a-batch")
+	ContainerFilters.Register(func(container *docker.Container) Admission {
+		if container.Config.Labels["team"] == "payments" {
+			return Admission{Routes: []string{"pci", "audit"}}
+		}
+		return Admission{}
+	}, "b-payments")
+	ContainerFilters.Register(func(container *docker.Container) Admission {
+		if container.Config.Labels["audited"] == "false" {
+			return Admission{Routes: []string{"pci"}}
+		}
+		return Admission{}
+	}, "c-audit")
+	defer func() {
+		for _, name := range []string{"a-batch", "b-payments", "c-audit"} {
+			ContainerFilters.Unregister(name)
+		}
+	}()
+	labelled := func(labels map[string]string) *docker.Container {
+		return &docker.Container{ID: "8dfafdbc3a40", Config: &docker.Config{Labels: labels}}
+	}
+
+	if admission, filter := admitContainer(labelled(map[string]string{"team": "batch"})); !admission.Ignore || filter != "a-batch" {
+		t.Errorf("expected the container ignored by a-batch got %+v %s", admission, filter)
+	}
+	if admission, _ := admitContainer(labelled(nil)); admission.Ignore || len(admission.Routes) != 0 {
+		t.Errorf("expected the container admitted to all routes got %+v", admission)
+	}
+	admission, _ := admitContainer(labelled(map[string]string{"team": "payments", "audited": "false"}))
+	if admission.Ignore || len(admission.Routes) != 1 || admission.Routes[0] != "pci" {
+		t.Errorf("expected the route hints of the filters intersected got %+v", admission)
+	}
+
+	cp := &containerPump{routes: admission.Routes}
+	if !cp.admits(&Route{ID: "pci"}) || cp.admits(&Route{ID: "audit"}) {
+		t.Error("expected the pump limited to the hinted routes")
+	}
+	if !(&containerPump{}).admits(&Route{ID: "audit"}) {
+		t.Error("expected a pump without route hints admitted to every route")
+	}
+}
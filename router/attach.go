@@ -0,0 +1,146 @@
+package router
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// an attachment holds its slot until its log stream delivers a line,
+	// or for this long
+	attachSettle = time.Second
+	// first delay before re-attaching to a container restarting in a loop
+	minReattachBackoff = time.Second
+)
+
+// attacher stages attaching to containers, so hosts with thousands of them
+// don't overwhelm the Docker daemon with requests at once
+type attacher struct {
+	slots      chan struct{}
+	jitter     time.Duration
+	backoffMax time.Duration
+	mu         sync.Mutex
+	reattaches map[string]*reattach
+}
+
+type reattach struct {
+	last  time.Time
+	delay time.Duration
+}
+
+// newAttacher returns the attacher configured by ATTACH_CONCURRENCY, the
+// attachments in flight at once, ATTACH_JITTER, the longest random delay
+// before each, and ATTACH_BACKOFF_MAX, the longest delay before attaching
+// again to a container restarting in a loop
+func newAttacher() (*attacher, error) {
+	a := &attacher{reattaches: make(map[string]*reattach)}
+	value := getopt("ATTACH_CONCURRENCY", "0")
+	concurrency, err := strconv.Atoi(value)
+	if err != nil || concurrency < 0 {
+		return nil, errors.New("invalid value for ATTACH_CONCURRENCY: " + value)
+	}
+	if concurrency > 0 {
+		a.slots = make(chan struct{}, concurrency)
+	}
+	value = getopt("ATTACH_JITTER", "0")
+	if a.jitter, err = time.ParseDuration(value); err != nil || a.jitter < 0 {
+		return nil, errors.New("invalid value for ATTACH_JITTER: " + value)
+	}
+	value = getopt("ATTACH_BACKOFF_MAX", "0")
+	if a.backoffMax, err = time.ParseDuration(value); err != nil || a.backoffMax < 0 {
+		return nil, errors.New("invalid value for ATTACH_BACKOFF_MAX: " + value)
+	}
+	return a, nil
+}
+
+// staged returns whether attachments are limited or spread out, so the
+// containers found at startup can be attached to concurrently
+func (a *attacher) staged() bool {
+	return a != nil && (a.slots != nil || a.jitter > 0)
+}
+
+// acquire waits for the jitter and an attach slot, returning the function
+// releasing the slot
+func (a *attacher) acquire() func() {
+	if a == nil {
+		return func() {}
+	}
+	if a.jitter > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(a.jitter))))
+	}
+	if a.slots == nil {
+		return func() {}
+	}
+	a.slots <- struct{}{}
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-a.slots })
+	}
+}
+
+// backoff returns how long to wait before attaching to the container with
+// id, doubling from a second up to ATTACH_BACKOFF_MAX each time it starts
+// again within the backoff of its last attachment
+func (a *attacher) backoff(id string, now time.Time) time.Duration {
+	if a == nil || a.backoffMax == 0 {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r, ok := a.reattaches[id]
+	if !ok {
+		a.reattaches[id] = &reattach{last: now}
+		return 0
+	}
+	// a container that ran for longer than its backoff isn't crash looping
+	limit := 2 * r.delay
+	if limit < minReattachBackoff {
+		limit = minReattachBackoff
+	}
+	if now.Sub(r.last) > limit {
+		r.last, r.delay = now, 0
+		return 0
+	}
+	r.delay *= 2
+	if r.delay < minReattachBackoff {
+		r.delay = minReattachBackoff
+	}
+	if r.delay > a.backoffMax {
+		r.delay = a.backoffMax
+	}
+	r.last = now.Add(r.delay)
+	return r.delay
+}
+
+// forget drops the restart history of a removed container
+func (a *attacher) forget(id string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.reattaches, id)
+}
+
+// settled calls release once stdout or stderr is first written to, or
+// after attachSettle, returning the writers to read the log stream into
+func settled(stdout, stderr io.Writer, release func()) (io.Writer, io.Writer) {
+	var once sync.Once
+	done := func() { once.Do(release) }
+	time.AfterFunc(attachSettle, done)
+	return &firstWrite{Writer: stdout, done: done}, &firstWrite{Writer: stderr, done: done}
+}
+
+type firstWrite struct {
+	io.Writer
+	done func()
+}
+
+func (w *firstWrite) Write(p []byte) (int, error) {
+	w.done()
+	return w.Writer.Write(p)
+}
@@ -0,0 +1,77 @@
+package router
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAttacherLimitsConcurrency(t *testing.T) {
+	os.Setenv("ATTACH_CONCURRENCY", "2")
+	defer os.Unsetenv("ATTACH_CONCURRENCY")
+	a, err := newAttacher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.staged() {
+		t.Error("expected limited attachments to be staged")
+	}
+	first, second := a.acquire(), a.acquire()
+	acquired := make(chan func())
+	go func() { acquired <- a.acquire() }()
+	select {
+	case <-acquired:
+		t.Fatal("expected a third attachment to wait for a slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// a slot is released once the log stream is written to
+	stdout, _ := settled(ioutil.Discard, ioutil.Discard, first)
+	stdout.Write([]byte("line\n"))
+	stdout.Write([]byte("line\n"))
+	select {
+	case third := <-acquired:
+		third()
+	case <-time.After(time.Second):
+		t.Fatal("expected a slot released by the first write")
+	}
+	second()
+	if len(a.slots) != 0 {
+		t.Errorf("expected all slots released got %v in use", len(a.slots))
+	}
+}
+
+func TestAttacherBackoff(t *testing.T) {
+	a := &attacher{backoffMax: 4 * time.Second, reattaches: make(map[string]*reattach)}
+	now := time.Now()
+	expected := []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}
+	for i, delay := range expected {
+		if got := a.backoff("8dfafdbc3a40", now); got != delay {
+			t.Errorf("restart %v: expected a delay of %s got %s", i, delay, got)
+		}
+		// restarting right after each attachment
+		now = now.Add(delay + 100*time.Millisecond)
+	}
+	if got := a.backoff("8dfafdbc3a40", now.Add(time.Minute)); got != 0 {
+		t.Errorf("expected no delay after running for a while got %s", got)
+	}
+	a.forget("8dfafdbc3a40")
+	if len(a.reattaches) != 0 {
+		t.Error("expected the restarts of a removed container forgotten")
+	}
+	var unset *attacher
+	if unset.backoff("8dfafdbc3a40", now) != 0 || unset.staged() {
+		t.Error("expected no staging without an attacher")
+	}
+	unset.acquire()()
+}
+
+func TestAttacherInvalid(t *testing.T) {
+	for _, env := range []string{"ATTACH_CONCURRENCY", "ATTACH_JITTER", "ATTACH_BACKOFF_MAX"} {
+		os.Setenv(env, "-1")
+		if _, err := newAttacher(); err == nil {
+			t.Errorf("expected an error for %s=-1", env)
+		}
+		os.Unsetenv(env)
+	}
+}
@@ -0,0 +1,51 @@
+package router
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// backfill throttles the lines containers logged before logspout attached
+// to their log streams, shared by all containers, or is nil when
+// BACKFILL_RATE is unset
+var backfill *rate.Limiter
+
+// newBackfillLimiter returns the limiter of BACKFILL_RATE, the lines per
+// second read from the backlog of all containers together, so replaying the
+// backlog of a busy host at startup doesn't overwhelm routes or hold up the
+// live lines of other containers
+func newBackfillLimiter() (*rate.Limiter, error) {
+	value := getopt("BACKFILL_RATE", "")
+	if value == "" {
+		return nil, nil
+	}
+	limit, err := strconv.ParseFloat(value, 64)
+	if err != nil || limit <= 0 {
+		return nil, errors.New("invalid value for BACKFILL_RATE: " + value)
+	}
+	// allow 10ms worth of lines at once to smooth over sleep granularity
+	burst := int(limit / 100)
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(limit), burst), nil
+}
+
+// attach records that the container's log stream was opened at t, reading
+// the lines logged before as backfill
+func (cp *containerPump) attach(t time.Time) {
+	atomic.StoreInt64(&cp.attached, t.UnixNano())
+}
+
+// throttleBackfill waits for the backfill limiter if the line logged at t
+// was logged before the log stream was opened
+func (cp *containerPump) throttleBackfill(t time.Time) {
+	if backfill == nil || t.UnixNano() >= atomic.LoadInt64(&cp.attached) {
+		return
+	}
+	time.Sleep(backfill.Reserve().Delay())
+}
@@ -0,0 +1,65 @@
+package router
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"golang.org/x/time/rate"
+)
+
+func TestBackfillThrottlesBacklog(t *testing.T) {
+	backfill = rate.NewLimiter(20, 1)
+	defer func() { backfill = nil }()
+	container := &docker.Container{ID: "8dfafdbc3a40", Config: &docker.Config{}}
+	outrd, outwr := io.Pipe()
+	errrd, errwr := io.Pipe()
+	defer errwr.Close()
+	pump := newContainerPump(container, outrd, errrd, nil)
+	logstream := make(chan *Message, 100)
+	pump.add(logstream, &Route{})
+
+	read := func(lines []string) time.Duration {
+		start := time.Now()
+		go io.WriteString(outwr, strings.Join(lines, "\n")+"\n")
+		for range lines {
+			select {
+			case <-logstream:
+			case <-time.After(time.Second):
+				t.Fatal("timeout waiting for message")
+			}
+		}
+		return time.Since(start)
+	}
+	var backlog, live []string
+	for i := 0; i < 5; i++ {
+		backlog = append(backlog, "2018-03-05T09:08:07Z backlog")
+	}
+	for i := 0; i < 50; i++ {
+		live = append(live, time.Now().Add(time.Minute).UTC().Format(time.RFC3339Nano)+" live")
+	}
+	if elapsed := read(backlog); elapsed < 150*time.Millisecond {
+		t.Errorf("expected the backlog throttled to 20 lines per second, read 5 lines in %s", elapsed)
+	}
+	if elapsed := read(live); elapsed > 150*time.Millisecond {
+		t.Errorf("expected live lines not to be throttled, read 50 lines in %s", elapsed)
+	}
+}
+
+func TestBackfillRate(t *testing.T) {
+	defer os.Unsetenv("BACKFILL_RATE")
+	if limiter, err := newBackfillLimiter(); limiter != nil || err != nil {
+		t.Errorf("expected no limiter by default got %v %v", limiter, err)
+	}
+	os.Setenv("BACKFILL_RATE", "500")
+	if limiter, err := newBackfillLimiter(); err != nil || limiter.Limit() != 500 || limiter.Burst() != 5 {
+		t.Errorf("expected 500 lines per second in bursts of 5 got %v", err)
+	}
+	os.Setenv("BACKFILL_RATE", "fast")
+	if _, err := newBackfillLimiter(); err == nil {
+		t.Error("expected an error for an invalid rate")
+	}
+}
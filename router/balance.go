@@ -0,0 +1,308 @@
+package router
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var balanceLog = NewLogger("balance")
+
+var (
+	balancedGroupsMu sync.Mutex
+	balancedGroups   = make(map[string]*balancedGroup)
+)
+
+// EndpointStatus is the health of an endpoint of a load-balanced route
+type EndpointStatus struct {
+	Address string    `json:"address"`
+	Healthy bool      `json:"healthy"`
+	Active  int       `json:"active"`
+	Writes  uint64    `json:"writes"`
+	Errors  uint64    `json:"errors"`
+	Down    time.Time `json:"down_since,omitempty"`
+}
+
+type endpoint struct {
+	addr        string
+	consecutive int
+	active      int
+	writes      uint64
+	errors      uint64
+	down        time.Time
+	probed      time.Time
+}
+
+// balancedGroup spreads the writes of the routes to a list of addresses
+// over the endpoints that are up. Routes to the same addresses share it, so
+// health and leastconn counts cover all of them, if they also share the
+// transport and options.
+type balancedGroup struct {
+	mu            sync.Mutex
+	transport     AdapterTransport
+	options       map[string]string
+	mode          string
+	endpoints     []*endpoint
+	next          int
+	threshold     int
+	probeInterval time.Duration
+}
+
+func dialBalanced(transport AdapterTransport, addr string, options map[string]string) (net.Conn, error) {
+	key := endpointGroupKey(transport, addr, options)
+	balancedGroupsMu.Lock()
+	g, ok := balancedGroups[key]
+	if !ok {
+		g = &balancedGroup{transport: transport, options: options, mode: options["mode"]}
+		for _, endpointAddr := range strings.Split(addr, ",") {
+			g.endpoints = append(g.endpoints, &endpoint{addr: endpointAddr})
+		}
+		var err error
+		if g.threshold, g.probeInterval, err = endpointHealthOptions(options); err != nil {
+			balancedGroupsMu.Unlock()
+			return nil, err
+		}
+		balancedGroups[key] = g
+	}
+	balancedGroupsMu.Unlock()
+
+	c := &balancedConn{group: g, conns: make([]net.Conn, len(g.endpoints))}
+	// fail like a single address route would if no endpoint can be reached
+	tried := make([]bool, len(g.endpoints))
+	var err error
+	for i := g.pick(tried); i >= 0; i = g.pick(tried) {
+		tried[i] = true
+		if _, err = c.conn(i); err == nil {
+			g.release(i, nil)
+			return c, nil
+		}
+		g.release(i, err)
+	}
+	return nil, err
+}
+
+// EndpointHealth returns the health of the endpoints of load-balanced
+// routes, by mode, transport, address list and options
+func EndpointHealth() map[string][]EndpointStatus {
+	balancedGroupsMu.Lock()
+	defer balancedGroupsMu.Unlock()
+	health := make(map[string][]EndpointStatus, len(balancedGroups))
+	for key, g := range balancedGroups {
+		g.mu.Lock()
+		for _, e := range g.endpoints {
+			health[key] = append(health[key], EndpointStatus{
+				Address: e.addr,
+				Healthy: e.down.IsZero(),
+				Active:  e.active,
+				Writes:  e.writes,
+				Errors:  e.errors,
+				Down:    e.down,
+			})
+		}
+		g.mu.Unlock()
+	}
+	return health
+}
+
+// pick returns the endpoint to write to next among those not tried, or -1.
+// Endpoints that are down are only picked once per probe interval, unless
+// all endpoints are down.
+func (g *balancedGroup) pick(tried []bool) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	best := -1
+	for _, probing := range []bool{false, true} {
+		for n := 0; n < len(g.endpoints); n++ {
+			i := (g.next + n) % len(g.endpoints)
+			e := g.endpoints[i]
+			if tried[i] {
+				continue
+			}
+			if !e.down.IsZero() && !probing && now.Sub(e.probed) < g.probeInterval {
+				continue
+			}
+			if best < 0 || (g.mode == "leastconn" && e.active < g.endpoints[best].active) {
+				best = i
+			}
+			if g.mode == "roundrobin" {
+				break
+			}
+		}
+		if best >= 0 {
+			break
+		}
+	}
+	if best >= 0 {
+		g.next = (best + 1) % len(g.endpoints)
+		g.endpoints[best].probed = now
+		g.endpoints[best].active++
+	}
+	return best
+}
+
+// release returns the endpoint i picked to write to, recording whether
+// dialing or writing to it failed
+func (g *balancedGroup) release(i int, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	e := g.endpoints[i]
+	e.active--
+	if err == nil {
+		e.consecutive = 0
+		if !e.down.IsZero() {
+			balanceLog.Info("endpoint is back up", "endpoint", e.addr)
+			e.down = time.Time{}
+		}
+		return
+	}
+	e.errors++
+	e.consecutive++
+	if e.down.IsZero() && e.consecutive >= g.threshold {
+		balanceLog.Warn("endpoint is down", "endpoint", e.addr, "error", err)
+		e.down = time.Now()
+	}
+}
+
+func (g *balancedGroup) wrote(i int) {
+	g.mu.Lock()
+	g.endpoints[i].writes++
+	g.mu.Unlock()
+}
+
+// balancedConn writes each frame to one endpoint of a balanced group
+type balancedConn struct {
+	mu    sync.Mutex
+	group *balancedGroup
+	conns []net.Conn
+}
+
+// conn returns the connection to the endpoint i, dialing it if needed
+func (c *balancedConn) conn(i int) (net.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conns[i] != nil {
+		return c.conns[i], nil
+	}
+	conn, err := c.group.transport.Dial(c.group.endpoints[i].addr, c.group.options)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[i] = conn
+	return conn, nil
+}
+
+// drop closes the connection to the endpoint i after a failed write
+func (c *balancedConn) drop(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conns[i] != nil {
+		c.conns[i].Close()
+		c.conns[i] = nil
+	}
+}
+
+// Write writes b to the next endpoint, trying the others if that fails
+func (c *balancedConn) Write(b []byte) (int, error) {
+	tried := make([]bool, len(c.conns))
+	err := errors.New("balance: no endpoint to write to")
+	for i := c.group.pick(tried); i >= 0; i = c.group.pick(tried) {
+		tried[i] = true
+		var conn net.Conn
+		if conn, err = c.conn(i); err != nil {
+			c.group.release(i, err)
+			continue
+		}
+		var n int
+		n, err = conn.Write(b)
+		if err == nil {
+			c.group.wrote(i)
+			c.group.release(i, nil)
+			return n, nil
+		}
+		c.group.release(i, err)
+		c.drop(i)
+	}
+	return 0, err
+}
+
+// Read is not supported, as connections to endpoints are only written to
+func (c *balancedConn) Read(b []byte) (int, error) {
+	return 0, errors.New("balance: reading is not supported")
+}
+
+// Close closes the connections to all endpoints
+func (c *balancedConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	for i, conn := range c.conns {
+		if conn != nil {
+			if closeErr := conn.Close(); closeErr != nil {
+				err = closeErr
+			}
+			c.conns[i] = nil
+		}
+	}
+	return err
+}
+
+// first returns the first open connection, as the connection to describe
+// the group by
+func (c *balancedConn) first() net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, conn := range c.conns {
+		if conn != nil {
+			return conn
+		}
+	}
+	return nil
+}
+
+// LocalAddr returns the local address of the first open connection
+func (c *balancedConn) LocalAddr() net.Addr {
+	if conn := c.first(); conn != nil {
+		return conn.LocalAddr()
+	}
+	return nil
+}
+
+// RemoteAddr returns the remote address of the first open connection
+func (c *balancedConn) RemoteAddr() net.Addr {
+	if conn := c.first(); conn != nil {
+		return conn.RemoteAddr()
+	}
+	return nil
+}
+
+// SetDeadline sets the deadline of all open connections
+func (c *balancedConn) SetDeadline(t time.Time) error {
+	return c.each(func(conn net.Conn) error { return conn.SetDeadline(t) })
+}
+
+// SetReadDeadline sets the read deadline of all open connections
+func (c *balancedConn) SetReadDeadline(t time.Time) error {
+	return c.each(func(conn net.Conn) error { return conn.SetReadDeadline(t) })
+}
+
+// SetWriteDeadline sets the write deadline of all open connections
+func (c *balancedConn) SetWriteDeadline(t time.Time) error {
+	return c.each(func(conn net.Conn) error { return conn.SetWriteDeadline(t) })
+}
+
+func (c *balancedConn) each(fn func(net.Conn) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	for _, conn := range c.conns {
+		if conn != nil {
+			if connErr := fn(conn); connErr != nil {
+				err = connErr
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package router
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoundRobin(t *testing.T) {
+	endpoints := &fakeEndpoints{down: make(map[string]bool), written: make(map[string]int)}
+	options := map[string]string{"mode": "roundrobin", "failover_errors": "1", "failover_probe_interval": "20ms"}
+	conn, err := Dial(endpoints, "rr-a:514,rr-b:514,rr-c:514", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		conn.Write([]byte("hello"))
+	}
+	for _, addr := range []string{"rr-a:514", "rr-b:514", "rr-c:514"} {
+		if endpoints.written[addr] != 2 {
+			t.Errorf("expected 2 writes to each endpoint got %v", endpoints.written)
+		}
+	}
+
+	// writes to a broken endpoint go to the next one, and it is left out
+	endpoints.set("rr-b:514", true)
+	for i := 0; i < 6; i++ {
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if endpoints.written["rr-a:514"]+endpoints.written["rr-c:514"] != 10 || endpoints.written["rr-b:514"] != 2 {
+		t.Errorf("expected the writes on the healthy endpoints got %v", endpoints.written)
+	}
+	health := EndpointHealth()[endpointGroupKey(endpoints, "rr-a:514,rr-b:514,rr-c:514", options)]
+	if len(health) != 3 || !health[0].Healthy || health[1].Healthy || health[1].Errors != 1 {
+		t.Errorf("unexpected health %+v", health)
+	}
+
+	// it is probed again after the probe interval
+	endpoints.set("rr-b:514", false)
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		conn.Write([]byte("hello"))
+	}
+	if endpoints.written["rr-b:514"] != 3 {
+		t.Errorf("expected the endpoint back after probing got %v", endpoints.written)
+	}
+	if !EndpointHealth()[endpointGroupKey(endpoints, "rr-a:514,rr-b:514,rr-c:514", options)][1].Healthy {
+		t.Error("expected the endpoint healthy again")
+	}
+}
+
+func TestLeastConn(t *testing.T) {
+	endpoints := &fakeEndpoints{down: make(map[string]bool), written: make(map[string]int)}
+	options := map[string]string{"mode": "leastconn"}
+	conn, err := Dial(endpoints, "lc-a:514,lc-b:514", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := balancedGroups[endpointGroupKey(endpoints, "lc-a:514,lc-b:514", options)]
+	// another route is busy writing to the first endpoint
+	g.mu.Lock()
+	g.endpoints[0].active++
+	g.mu.Unlock()
+	for i := 0; i < 3; i++ {
+		conn.Write([]byte("hello"))
+	}
+	if endpoints.written["lc-b:514"] != 3 {
+		t.Errorf("expected the writes on the idle endpoint got %v", endpoints.written)
+	}
+}
+
+func TestBalancedDialAllDown(t *testing.T) {
+	endpoints := &fakeEndpoints{down: map[string]bool{"down-a:514": true, "down-b:514": true}, written: make(map[string]int)}
+	if _, err := Dial(endpoints, "down-a:514,down-b:514", map[string]string{"mode": "roundrobin"}); err == nil {
+		t.Error("expected an error when no endpoint can be dialed")
+	}
+	if _, err := Dial(endpoints, "a:514,b:514", map[string]string{"mode": "random"}); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
@@ -0,0 +1,83 @@
+package router
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BatchSizer sizes the batches of an adapter. With the batch_adaptive route
+// option or BATCH_ADAPTIVE it adapts the size like TCP congestion control:
+// batches that were full and written within the target latency grow it
+// additively up to the adapter's batch size, and slow or failed writes halve
+// it, down to the minimum size.
+type BatchSizer struct {
+	mu       sync.Mutex
+	adaptive bool
+	min      int
+	max      int
+	size     int
+	step     int
+	target   time.Duration
+}
+
+// NewBatchSizer returns a BatchSizer for route's batches of at most max
+func NewBatchSizer(route *Route, max int) (*BatchSizer, error) {
+	b := &BatchSizer{min: max, max: max, size: max}
+	value := RouteOpt(route, "batch_adaptive", "BATCH_ADAPTIVE", "false")
+	var err error
+	if b.adaptive, err = strconv.ParseBool(value); err != nil {
+		return nil, errors.New("invalid value for batch_adaptive (must be true|false): " + value)
+	}
+	if !b.adaptive {
+		return b, nil
+	}
+	value = RouteOpt(route, "batch_min_size", "BATCH_MIN_SIZE", "1")
+	if b.min, err = strconv.Atoi(value); err != nil || b.min < 1 {
+		return nil, errors.New("invalid value for batch_min_size: " + value)
+	}
+	if b.min > max {
+		b.min = max
+	}
+	value = RouteOpt(route, "batch_target_latency", "BATCH_TARGET_LATENCY", "1s")
+	if b.target, err = time.ParseDuration(value); err != nil || b.target <= 0 {
+		return nil, errors.New("invalid value for batch_target_latency: " + value)
+	}
+	b.size = b.min
+	// grow from the minimum to the maximum size in about 16 batches
+	b.step = (max - b.min) / 16
+	if b.step < 1 {
+		b.step = 1
+	}
+	return b, nil
+}
+
+// Size returns how many messages the next batch may hold
+func (b *BatchSizer) Size() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.size
+}
+
+// Observe adapts the size to a batch of n messages written in latency
+func (b *BatchSizer) Observe(n int, latency time.Duration, err error) {
+	if !b.adaptive {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case err != nil || latency > b.target:
+		b.size /= 2
+		if b.size < b.min {
+			b.size = b.min
+		}
+	case n >= b.size:
+		// only grow batches that the load filled
+		b.size += b.step
+		if b.size > b.max {
+			b.size = b.max
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package router
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBatchSizerStatic(t *testing.T) {
+	b, err := NewBatchSizer(&Route{}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Observe(100, time.Minute, errors.New("throttled"))
+	if b.Size() != 100 {
+		t.Errorf("expected a static size of 100 got %v", b.Size())
+	}
+}
+
+func TestBatchSizerAdaptive(t *testing.T) {
+	route := &Route{Options: map[string]string{
+		"batch_adaptive":       "true",
+		"batch_min_size":       "4",
+		"batch_target_latency": "100ms",
+	}}
+	b, err := NewBatchSizer(route, 68)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Size() != 4 {
+		t.Errorf("expected to start at the minimum size got %v", b.Size())
+	}
+	// partial batches don't grow the size
+	b.Observe(2, time.Millisecond, nil)
+	if b.Size() != 4 {
+		t.Errorf("expected size 4 after a partial batch got %v", b.Size())
+	}
+	for i := 0; i < 20; i++ {
+		b.Observe(b.Size(), time.Millisecond, nil)
+	}
+	if b.Size() != 68 {
+		t.Errorf("expected to grow to the maximum size got %v", b.Size())
+	}
+	b.Observe(68, time.Second, nil)
+	if b.Size() != 34 {
+		t.Errorf("expected a slow batch to halve the size got %v", b.Size())
+	}
+	b.Observe(34, time.Millisecond, nil)
+	if b.Size() != 38 {
+		t.Errorf("expected to grow by 4 got %v", b.Size())
+	}
+	for i := 0; i < 10; i++ {
+		b.Observe(1, time.Millisecond, errors.New("unavailable"))
+	}
+	if b.Size() != 4 {
+		t.Errorf("expected errors to shrink to the minimum size got %v", b.Size())
+	}
+}
+
+func TestBatchSizerInvalidOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"batch_adaptive": "maybe"},
+		{"batch_adaptive": "true", "batch_min_size": "0"},
+		{"batch_adaptive": "true", "batch_target_latency": "fast"},
+	} {
+		if _, err := NewBatchSizer(&Route{Options: options}, 10); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var breakerLog = NewLogger("breaker")
+
+// Breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+const (
+	breakerDrop        = "drop"
+	breakerBuffer      = "buffer"
+	breakerTick        = time.Second
+	breakerTransitions = 100
+)
+
+// ErrBreakerOpen is reported for the messages a route's circuit breaker drops
+var ErrBreakerOpen = errors.New("circuit breaker open")
+
+// RouteBreaker is the circuit breaker of a route. It opens after threshold
+// consecutive failed deliveries and holds messages back from the adapter
+// for the cooldown, then lets a single message through to probe the
+// endpoint: the breaker closes if it is delivered and opens again if not.
+type RouteBreaker struct {
+	State      string    `json:"state"`
+	Failures   int       `json:"failures"`
+	Opened     uint64    `json:"opened"`
+	Dropped    uint64    `json:"dropped"`
+	Buffered   int       `json:"buffered"`
+	Since      time.Time `json:"since"`
+	route      string
+	threshold  int
+	cooldown   time.Duration
+	policy     string
+	bufferSize int
+}
+
+// BreakerTransition records a change of state of a route's breaker
+type BreakerTransition struct {
+	Route string    `json:"route"`
+	From  string    `json:"from"`
+	To    string    `json:"to"`
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// BreakerRegistry holds the circuit breakers of routes and their recent
+// transitions
+type BreakerRegistry struct {
+	mu          sync.Mutex
+	routes      map[string]*RouteBreaker
+	transitions []*BreakerTransition
+}
+
+// Breakers holds the circuit breakers of routes with breaker_failures set
+var Breakers = &BreakerRegistry{routes: make(map[string]*RouteBreaker)}
+
+// newRouteBreaker returns the breaker configured by the breaker_* options of
+// route, falling back to BREAKER_* env vars, or nil if it has none
+func newRouteBreaker(route *Route) (*RouteBreaker, error) {
+	value := RouteOpt(route, "breaker_failures", "BREAKER_FAILURES", "0")
+	threshold, err := strconv.Atoi(value)
+	if err != nil || threshold < 0 {
+		return nil, errors.New("invalid value for breaker_failures: " + value)
+	}
+	if threshold == 0 {
+		return nil, nil
+	}
+	rb := &RouteBreaker{State: BreakerClosed, Since: time.Now(), threshold: threshold}
+	value = RouteOpt(route, "breaker_cooldown", "BREAKER_COOLDOWN", "30s")
+	if rb.cooldown, err = time.ParseDuration(value); err != nil || rb.cooldown <= 0 {
+		return nil, errors.New("invalid value for breaker_cooldown: " + value)
+	}
+	rb.policy = RouteOpt(route, "breaker_policy", "BREAKER_POLICY", breakerDrop)
+	if rb.policy != breakerDrop && rb.policy != breakerBuffer {
+		return nil, errors.New("invalid value for breaker_policy (must be drop or buffer): " + rb.policy)
+	}
+	value = RouteOpt(route, "breaker_buffer", "BREAKER_BUFFER", "1000")
+	if rb.bufferSize, err = strconv.Atoi(value); err != nil || rb.bufferSize < 1 {
+		return nil, errors.New("invalid value for breaker_buffer: " + value)
+	}
+	return rb, nil
+}
+
+// register makes rb the breaker reported for the route with id
+func (br *BreakerRegistry) register(id string, rb *RouteBreaker) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	rb.route = id
+	br.routes[id] = rb
+}
+
+// remove stops reporting the breaker of the route with id
+func (br *BreakerRegistry) remove(id string) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	delete(br.routes, id)
+}
+
+// allow returns whether a message may be passed to the adapter, moving an
+// open breaker whose cooldown passed to half-open for a probe
+func (br *BreakerRegistry) allow(rb *RouteBreaker) bool {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	switch rb.State {
+	case BreakerClosed:
+		return true
+	case BreakerOpen:
+		if time.Since(rb.Since) < rb.cooldown {
+			return false
+		}
+		br.transition(rb, BreakerHalfOpen, nil)
+		return true
+	}
+	// a probe that was never reported, e.g. dropped by a processor, is
+	// given up on after another cooldown
+	if time.Since(rb.Since) >= rb.cooldown {
+		rb.Since = time.Now()
+		return true
+	}
+	return false
+}
+
+// observe counts the outcome of delivering a message on route
+func (br *BreakerRegistry) observe(route *Route, err error) {
+	rb := route.breaker
+	if rb == nil || err == ErrBreakerOpen {
+		return
+	}
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	switch {
+	case err == nil && rb.State == BreakerHalfOpen:
+		rb.Failures = 0
+		br.transition(rb, BreakerClosed, nil)
+	case err == nil:
+		rb.Failures = 0
+	case rb.State == BreakerHalfOpen:
+		rb.Failures++
+		br.transition(rb, BreakerOpen, err)
+	case rb.State == BreakerClosed:
+		rb.Failures++
+		if rb.Failures >= rb.threshold {
+			br.transition(rb, BreakerOpen, err)
+		}
+	}
+}
+
+// dropped counts n messages dropped while the breaker is open
+func (br *BreakerRegistry) dropped(rb *RouteBreaker, n int) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	rb.Dropped += uint64(n)
+}
+
+// buffered records the number of messages held back while the breaker is open
+func (br *BreakerRegistry) buffered(rb *RouteBreaker, n int) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	rb.Buffered = n
+}
+
+// transition changes the state of rb, logging and notifying the change.
+// It must be called with br.mu held.
+func (br *BreakerRegistry) transition(rb *RouteBreaker, state string, err error) {
+	t := &BreakerTransition{Route: rb.route, From: rb.State, To: state, Time: time.Now()}
+	if err != nil {
+		t.Error = err.Error()
+	}
+	rb.State, rb.Since = state, t.Time
+	if state == BreakerOpen {
+		rb.Opened++
+	}
+	br.transitions = append(br.transitions, t)
+	if len(br.transitions) > breakerTransitions {
+		br.transitions = br.transitions[1:]
+	}
+	message := fmt.Sprintf("route %s circuit breaker %s", rb.route, state)
+	switch state {
+	case BreakerOpen:
+		message += fmt.Sprintf(" after %v failures, retrying in %s: %s", rb.Failures, rb.cooldown, t.Error)
+	case BreakerHalfOpen:
+		message += ", probing the endpoint"
+	}
+	breakerLog.Warn(message, "route", rb.route)
+	if state != BreakerHalfOpen {
+		Notify(&Notification{Event: "breaker_" + state, Route: rb.route, Message: message, Data: *rb})
+	}
+}
+
+// MarshalJSON writes the breakers of each route by route ID, and their
+// recent transitions
+func (br *BreakerRegistry) MarshalJSON() ([]byte, error) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	return json.Marshal(struct {
+		Routes      map[string]*RouteBreaker `json:"routes"`
+		Transitions []*BreakerTransition     `json:"transitions"`
+	}{br.routes, br.transitions})
+}
+
+// breaker passes messages from logstream to out while the route's circuit
+// breaker lets them through, and buffers or drops them while it is open.
+// It closes out once logstream is closed.
+func (rm *RouteManager) breaker(route *Route, logstream, out chan *Message) {
+	rb := route.breaker
+	drop := func(message *Message) {
+		Breakers.dropped(rb, 1)
+		Receipts.Report(route, message, ErrBreakerOpen)
+	}
+	tick := time.NewTicker(breakerTick)
+	defer tick.Stop()
+	var buffered []*Message
+	flush := func() {
+		for len(buffered) > 0 && Breakers.allow(rb) {
+			out <- buffered[0]
+			buffered[0] = nil
+			buffered = buffered[1:]
+		}
+		Breakers.buffered(rb, len(buffered))
+	}
+	for {
+		flush()
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				flush()
+				for _, message := range buffered {
+					drop(message)
+				}
+				Breakers.buffered(rb, 0)
+				close(out)
+				return
+			}
+			if len(buffered) == 0 && Breakers.allow(rb) {
+				out <- message
+				continue
+			}
+			if rb.policy == breakerDrop {
+				drop(message)
+				continue
+			}
+			buffered = append(buffered, message)
+			if len(buffered) > rb.bufferSize {
+				drop(buffered[0])
+				buffered[0] = nil
+				buffered = buffered[1:]
+			}
+		case <-tick.C:
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package router
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerStates(t *testing.T) {
+	br := &BreakerRegistry{routes: make(map[string]*RouteBreaker)}
+	route := &Route{ID: "breaker", Options: map[string]string{"breaker_failures": "2", "breaker_cooldown": "50ms"}}
+	rb, err := newRouteBreaker(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.breaker = rb
+	br.register(route.ID, rb)
+
+	failed := errors.New("refused")
+	br.observe(route, failed)
+	br.observe(route, nil)
+	br.observe(route, failed)
+	if rb.State != BreakerClosed || !br.allow(rb) {
+		t.Fatalf("expected a success to reset the failures got %+v", rb)
+	}
+	br.observe(route, failed)
+	if rb.State != BreakerOpen || br.allow(rb) {
+		t.Fatalf("expected the breaker open after 2 failures got %+v", rb)
+	}
+	// messages dropped by the breaker itself are not failures
+	br.observe(route, ErrBreakerOpen)
+
+	time.Sleep(60 * time.Millisecond)
+	if !br.allow(rb) || rb.State != BreakerHalfOpen {
+		t.Fatalf("expected a probe after the cooldown got %+v", rb)
+	}
+	if br.allow(rb) {
+		t.Error("expected a single probe while half-open")
+	}
+	br.observe(route, failed)
+	if rb.State != BreakerOpen || rb.Opened != 2 {
+		t.Fatalf("expected a failed probe to open the breaker again got %+v", rb)
+	}
+	time.Sleep(60 * time.Millisecond)
+	br.allow(rb)
+	br.observe(route, nil)
+	if rb.State != BreakerClosed || rb.Failures != 0 {
+		t.Fatalf("expected a delivered probe to close the breaker got %+v", rb)
+	}
+	var states []string
+	for _, transition := range br.transitions {
+		states = append(states, transition.To)
+	}
+	if len(states) != 5 || states[0] != BreakerOpen || states[4] != BreakerClosed {
+		t.Errorf("unexpected transitions %v", states)
+	}
+}
+
+func TestBreakerBuffer(t *testing.T) {
+	route := &Route{ID: "buffered", Options: map[string]string{
+		"breaker_failures": "1",
+		"breaker_cooldown": "10ms",
+		"breaker_policy":   "buffer",
+		"breaker_buffer":   "2",
+	}}
+	rb, err := newRouteBreaker(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.breaker = rb
+	Breakers.register(route.ID, rb)
+	defer Breakers.remove(route.ID)
+	Breakers.observe(route, errors.New("refused"))
+	// keep the breaker open until the buffer overflows
+	rb.cooldown = time.Hour
+
+	rm := &RouteManager{}
+	logstream := make(chan *Message)
+	out := make(chan *Message)
+	go rm.breaker(route, logstream, out)
+	for _, data := range []string{"1", "2", "3"} {
+		logstream <- &Message{Data: data}
+	}
+	Breakers.mu.Lock()
+	rb.cooldown = 10 * time.Millisecond
+	Breakers.mu.Unlock()
+
+	message := <-out
+	if message.Data != "2" {
+		t.Fatalf("expected the oldest message dropped got %q", message.Data)
+	}
+	// the probe closes the breaker, releasing the rest of the buffer
+	Breakers.observe(route, nil)
+	if message := <-out; message.Data != "3" {
+		t.Fatalf("expected the buffered message got %q", message.Data)
+	}
+	close(logstream)
+	if _, ok := <-out; ok {
+		t.Error("expected out closed")
+	}
+	if rb.Dropped != 1 || rb.State != BreakerClosed {
+		t.Errorf("unexpected breaker %+v", rb)
+	}
+}
+
+func TestBreakerInvalidOptions(t *testing.T) {
+	for option, value := range map[string]string{
+		"breaker_failures": "-1",
+		"breaker_cooldown": "soon",
+		"breaker_policy":   "block",
+		"breaker_buffer":   "0",
+	} {
+		options := map[string]string{"breaker_failures": "3", option: value}
+		if _, err := newRouteBreaker(&Route{Options: options}); err == nil {
+			t.Errorf("%s: expected an error", option)
+		}
+	}
+	if rb, err := newRouteBreaker(&Route{}); rb != nil || err != nil {
+		t.Errorf("expected no breaker by default got %v %v", rb, err)
+	}
+}
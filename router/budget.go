@@ -0,0 +1,188 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var budgetLog = NewLogger("budget")
+
+// RouteBudget tracks the messages, failures and retries of a route
+type RouteBudget struct {
+	Messages  uint64  `json:"messages"`
+	Failed    uint64  `json:"failed"`
+	Retries   uint64  `json:"retries"`
+	ErrorRate float64 `json:"error_rate"`
+	RetryRate float64 `json:"retry_rate"`
+	Healthy   bool    `json:"healthy"`
+	// Errors counts the failed messages by error category
+	Errors    map[string]uint64 `json:"errors,omitempty"`
+	window    struct{ messages, failed, retries uint64 }
+	errBudget float64
+	retBudget float64
+}
+
+// BudgetTracker flips the health of routes whose share of failed messages
+// or retries per message over a window exceeds their error or retry budget,
+// and notifies NOTIFY_WEBHOOK when it does
+type BudgetTracker struct {
+	mu          sync.Mutex
+	routes      map[string]*RouteBudget
+	errorBudget float64
+	retryBudget float64
+	window      time.Duration
+}
+
+// Budgets tracks the error and retry budgets of routes
+var Budgets = &BudgetTracker{
+	routes: make(map[string]*RouteBudget),
+	window: 5 * time.Minute,
+}
+
+func init() {
+	Jobs.Register(Budgets, "budget")
+}
+
+func parseBudget(name, value string) (float64, error) {
+	budget, err := strconv.ParseFloat(value, 64)
+	if err != nil || budget < 0 || budget > 1 {
+		return 0, errors.New("invalid value for " + name + " (must be between 0 and 1): " + value)
+	}
+	return budget, nil
+}
+
+func (bt *BudgetTracker) get(route *Route) *RouteBudget {
+	rb, ok := bt.routes[route.ID]
+	if !ok {
+		rb = &RouteBudget{Healthy: true, errBudget: bt.errorBudget, retBudget: bt.retryBudget}
+		for _, opt := range []struct {
+			name   string
+			budget *float64
+		}{{"error_budget", &rb.errBudget}, {"retry_budget", &rb.retBudget}} {
+			if value := route.Options[opt.name]; value != "" {
+				budget, err := parseBudget(opt.name, value)
+				if err != nil {
+					budgetLog.Error("invalid route option", "route", route.ID, "error", err)
+					continue
+				}
+				*opt.budget = budget
+			}
+		}
+		bt.routes[route.ID] = rb
+	}
+	return rb
+}
+
+// observe counts the outcome of delivering a message on route
+func (bt *BudgetTracker) observe(route *Route, err error) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	rb := bt.get(route)
+	rb.Messages++
+	rb.window.messages++
+	if err != nil {
+		rb.Failed++
+		rb.window.failed++
+		if rb.Errors == nil {
+			rb.Errors = make(map[string]uint64)
+		}
+		rb.Errors[ErrorCategory(err)]++
+	}
+}
+
+// Retried counts a retried write on route
+func (bt *BudgetTracker) Retried(route *Route) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	rb := bt.get(route)
+	rb.Retries++
+	rb.window.retries++
+}
+
+// Healthy returns whether route was within its budgets at the last check
+func (bt *BudgetTracker) Healthy(route *Route) bool {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	rb, ok := bt.routes[route.ID]
+	return !ok || rb.Healthy
+}
+
+// MarshalJSON writes the budgets of each route by route ID
+func (bt *BudgetTracker) MarshalJSON() ([]byte, error) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	return json.Marshal(bt.routes)
+}
+
+// check computes the rates of the last window and flips the health of
+// routes that went over or back within their budgets. Windows without
+// messages leave the health as it is.
+func (bt *BudgetTracker) check() {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	for id, rb := range bt.routes {
+		if rb.window.messages == 0 {
+			rb.window.retries = 0
+			continue
+		}
+		rb.ErrorRate = float64(rb.window.failed) / float64(rb.window.messages)
+		rb.RetryRate = float64(rb.window.retries) / float64(rb.window.messages)
+		rb.window.messages, rb.window.failed, rb.window.retries = 0, 0, 0
+		healthy := (rb.errBudget == 0 || rb.ErrorRate <= rb.errBudget) &&
+			(rb.retBudget == 0 || rb.RetryRate <= rb.retBudget)
+		if healthy == rb.Healthy {
+			continue
+		}
+		rb.Healthy = healthy
+		message := fmt.Sprintf("route %s is within its budgets: error rate %.4f, retry rate %.4f", id, rb.ErrorRate, rb.RetryRate)
+		event := "route_healthy"
+		if !healthy {
+			message = fmt.Sprintf("route %s exceeded its budgets: error rate %.4f (budget %g), retry rate %.4f (budget %g)",
+				id, rb.ErrorRate, rb.errBudget, rb.RetryRate, rb.retBudget)
+			event = "route_unhealthy"
+		}
+		budgetLog.Warn(message, "route", id)
+		Notify(&Notification{Event: event, Route: id, Message: message, Data: *rb})
+	}
+}
+
+// Name returns the name of the budget job, empty unless a budget is set
+func (bt *BudgetTracker) Name() string {
+	if bt.errorBudget == 0 && bt.retryBudget == 0 {
+		return ""
+	}
+	return "budget"
+}
+
+// Setup configures the default budgets from ERROR_BUDGET and RETRY_BUDGET,
+// and the window from BUDGET_WINDOW
+func (bt *BudgetTracker) Setup() error {
+	errorBudget, err := parseBudget("ERROR_BUDGET", getopt("ERROR_BUDGET", "0"))
+	if err != nil {
+		return err
+	}
+	retryBudget, err := parseBudget("RETRY_BUDGET", getopt("RETRY_BUDGET", "0"))
+	if err != nil {
+		return err
+	}
+	window, err := time.ParseDuration(getopt("BUDGET_WINDOW", "5m"))
+	if err != nil || window <= 0 {
+		return errors.New("invalid value for BUDGET_WINDOW: " + getopt("BUDGET_WINDOW", ""))
+	}
+	bt.mu.Lock()
+	bt.errorBudget, bt.retryBudget, bt.window = errorBudget, retryBudget, window
+	bt.mu.Unlock()
+	return nil
+}
+
+// Run checks the budgets of routes every window
+func (bt *BudgetTracker) Run() error {
+	for range time.Tick(bt.window) {
+		bt.check()
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBudgetHealth(t *testing.T) {
+	notifications := make(chan *Notification, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := new(Notification)
+		if err := json.NewDecoder(req.Body).Decode(n); err != nil {
+			t.Error(err)
+		}
+		notifications <- n
+	}))
+	defer server.Close()
+	os.Setenv("NOTIFY_WEBHOOK", server.URL)
+	defer os.Unsetenv("NOTIFY_WEBHOOK")
+
+	bt := &BudgetTracker{routes: make(map[string]*RouteBudget), errorBudget: 0.02}
+	route := &Route{ID: "budget"}
+	lenient := &Route{ID: "lenient", Options: map[string]string{"error_budget": "0.5", "retry_budget": "1"}}
+	for i := 0; i < 100; i++ {
+		var err error
+		if i < 5 {
+			err = errors.New("rejected")
+		}
+		bt.observe(route, err)
+		bt.observe(lenient, err)
+	}
+	bt.Retried(lenient)
+	bt.check()
+	if bt.Healthy(route) || !bt.Healthy(lenient) {
+		t.Errorf("expected only the route over its budget unhealthy, got %v %v", bt.Healthy(route), bt.Healthy(lenient))
+	}
+	if rb := bt.routes["lenient"]; rb.ErrorRate != 0.05 || rb.RetryRate != 0.01 {
+		t.Errorf("unexpected rates %+v", rb)
+	}
+	select {
+	case n := <-notifications:
+		if n.Event != "route_unhealthy" || n.Route != "budget" {
+			t.Errorf("unexpected notification %+v", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a notification")
+	}
+
+	// an idle window keeps the health, a window within budget restores it
+	bt.check()
+	if bt.Healthy(route) {
+		t.Error("expected an idle window to keep the route unhealthy")
+	}
+	for i := 0; i < 100; i++ {
+		bt.observe(route, nil)
+	}
+	bt.check()
+	if !bt.Healthy(route) {
+		t.Error("expected the route healthy again")
+	}
+	select {
+	case n := <-notifications:
+		if n.Event != "route_healthy" {
+			t.Errorf("unexpected notification %+v", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a notification")
+	}
+}
@@ -0,0 +1,93 @@
+package router
+
+import (
+	"sort"
+	"sync"
+	"text/template"
+)
+
+// Capability describes the route options and template functions a module
+// accepts
+type Capability struct {
+	Options       []string `json:"options"`
+	TemplateFuncs []string `json:"template_funcs,omitempty"`
+}
+
+// CapabilityRegistry holds the descriptions modules give of their options
+type CapabilityRegistry struct {
+	mu          sync.Mutex
+	adapters    map[string]*Capability
+	transports  map[string]*Capability
+	processors  map[string]*Capability
+	routeOption []string
+}
+
+// Capabilities describes the compiled in modules
+var Capabilities = &CapabilityRegistry{
+	adapters:   make(map[string]*Capability),
+	transports: make(map[string]*Capability),
+	processors: make(map[string]*Capability),
+	// options handled by the router for every route
+	routeOption: []string{
+		"id", "filter.id", "filter.name", "filter.labels", "filter.images", "filter.sources",
+		"processors", "processor.<type>.<option>", "template", "dial_timeout",
+		"mode", "failover_errors", "failover_probe_interval",
+		"slow_write_threshold", "standby", "error_budget", "retry_budget",
+		"breaker_failures", "breaker_cooldown", "breaker_policy", "breaker_buffer",
+		"max_message_size", "max_message_policy", "residency", "dead_letter",
+		"price_per_gb", "add_field", "sequence", "schedule", "schedule_timezone",
+		"schedule_fallback",
+	},
+}
+
+// DescribeAdapter records the route options of an adapter, and the
+// functions of its templates if it has any
+func (c *CapabilityRegistry) DescribeAdapter(name string, options []string, funcs template.FuncMap) {
+	c.describe(c.adapters, name, options, funcs)
+}
+
+// DescribeTransport records the route options of a transport
+func (c *CapabilityRegistry) DescribeTransport(name string, options []string) {
+	c.describe(c.transports, name, options, nil)
+}
+
+// DescribeProcessor records the options of a processor
+func (c *CapabilityRegistry) DescribeProcessor(name string, options []string) {
+	c.describe(c.processors, name, options, nil)
+}
+
+func (c *CapabilityRegistry) describe(kind map[string]*Capability, name string, options []string, funcs template.FuncMap) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	capability := &Capability{Options: options}
+	for fn := range funcs {
+		capability.TemplateFuncs = append(capability.TemplateFuncs, fn)
+	}
+	sort.Strings(capability.TemplateFuncs)
+	kind[name] = capability
+}
+
+// Catalog lists the registered adapters, transports and processors with the
+// options they describe. Modules without a description are listed with no
+// options.
+func (c *CapabilityRegistry) Catalog() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	catalog := func(names []string, described map[string]*Capability) map[string]*Capability {
+		modules := make(map[string]*Capability, len(names))
+		for _, name := range names {
+			if capability, ok := described[name]; ok {
+				modules[name] = capability
+			} else {
+				modules[name] = &Capability{Options: []string{}}
+			}
+		}
+		return modules
+	}
+	return map[string]interface{}{
+		"adapters":      catalog(AdapterFactories.Names(), c.adapters),
+		"transports":    catalog(AdapterTransports.Names(), c.transports),
+		"processors":    catalog(ProcessorFactories.Names(), c.processors),
+		"route_options": c.routeOption,
+	}
+}
@@ -0,0 +1,33 @@
+package router
+
+import (
+	"reflect"
+	"testing"
+	"text/template"
+)
+
+func TestCapabilitiesCatalog(t *testing.T) {
+	AdapterFactories.Register(newDummyAdapter, "dummy")
+	defer AdapterFactories.Unregister("dummy")
+	AdapterFactories.Register(newDummyAdapter, "undescribed")
+	defer AdapterFactories.Unregister("undescribed")
+	c := &CapabilityRegistry{
+		adapters:   make(map[string]*Capability),
+		transports: make(map[string]*Capability),
+		processors: make(map[string]*Capability),
+	}
+	c.DescribeAdapter("dummy", []string{"format"}, template.FuncMap{"upper": nil, "lower": nil})
+	c.DescribeAdapter("unregistered", []string{"format"}, nil)
+
+	adapters := c.Catalog()["adapters"].(map[string]*Capability)
+	if dummy := adapters["dummy"]; dummy == nil || !reflect.DeepEqual(dummy.Options, []string{"format"}) ||
+		!reflect.DeepEqual(dummy.TemplateFuncs, []string{"lower", "upper"}) {
+		t.Errorf("unexpected capability %+v", adapters["dummy"])
+	}
+	if undescribed := adapters["undescribed"]; undescribed == nil || len(undescribed.Options) != 0 {
+		t.Errorf("expected an undescribed adapter with no options got %+v", undescribed)
+	}
+	if _, ok := adapters["unregistered"]; ok {
+		t.Error("expected adapters that aren't registered to be left out")
+	}
+}
@@ -0,0 +1,136 @@
+package router
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const checkpointFile = "checkpoints.json"
+
+// checkpoints records the Docker timestamp of the last line read from each
+// stream of each container, so a restarted logspout can resume where it
+// stopped instead of from now
+type checkpoints struct {
+	mu    sync.Mutex
+	path  string
+	times map[string]map[string]time.Time
+	dirty bool
+}
+
+// newCheckpoints returns the checkpoints stored under CHECKPOINT_PATH, or nil
+// if checkpointing isn't enabled
+func newCheckpoints() (*checkpoints, error) {
+	dir := getopt("CHECKPOINT_PATH", "")
+	if dir == "" {
+		return nil, nil
+	}
+	c := &checkpoints{
+		path:  filepath.Join(dir, checkpointFile),
+		times: make(map[string]map[string]time.Time),
+	}
+	content, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &c.times); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// since returns the time to resume reading a container from, the oldest
+// checkpoint of its streams
+func (c *checkpoints) since(id string) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var since time.Time
+	for _, t := range c.times[id] {
+		if since.IsZero() || t.Before(since) {
+			since = t
+		}
+	}
+	return since, !since.IsZero()
+}
+
+// advance records t as read from a container stream, returning false if it
+// isn't after the stream's checkpoint and so was read before
+func (c *checkpoints) advance(id, source string, t time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	streams, ok := c.times[id]
+	if !ok {
+		streams = make(map[string]time.Time)
+		c.times[id] = streams
+	}
+	if !t.After(streams[source]) {
+		return false
+	}
+	streams[source] = t
+	c.dirty = true
+	return true
+}
+
+// remove forgets the checkpoints of a container
+func (c *checkpoints) remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.times[id]; ok {
+		delete(c.times, id)
+		c.dirty = true
+	}
+}
+
+// save writes the checkpoints if they changed, replacing the file atomically
+func (c *checkpoints) save() error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	content, err := json.Marshal(c.times)
+	c.dirty = false
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// run saves the checkpoints every interval
+func (c *checkpoints) run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := c.save(); err != nil {
+			debug("pump.checkpoints:", err)
+		}
+	}
+}
+
+// splitTimestamp splits the RFC3339Nano timestamp the Docker logs API
+// prefixes lines with when asked for timestamps from the line
+func splitTimestamp(line string) (time.Time, string, bool) {
+	i := strings.IndexByte(line, ' ')
+	if i < 0 {
+		// empty lines have no space after the timestamp
+		i = len(strings.TrimSuffix(line, "\n"))
+	}
+	t, err := time.Parse(time.RFC3339Nano, line[:i])
+	if err != nil {
+		return time.Time{}, line, false
+	}
+	if i < len(line) && line[i] == ' ' {
+		i++
+	}
+	return t, line[i:], true
+}
@@ -0,0 +1,116 @@
+package router
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestCheckpointsSaveAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("CHECKPOINT_PATH", dir)
+	defer os.Unsetenv("CHECKPOINT_PATH")
+
+	c, err := newCheckpoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t1 := time.Date(2018, time.March, 5, 9, 8, 7, 100, time.UTC)
+	t2 := t1.Add(time.Second)
+	if !c.advance("abc", "stdout", t2) || !c.advance("abc", "stderr", t1) {
+		t.Fatal("expected new timestamps to advance the checkpoints")
+	}
+	if c.advance("abc", "stdout", t1) || c.advance("abc", "stdout", t2) {
+		t.Error("expected timestamps already read not to advance the checkpoint")
+	}
+	c.advance("gone", "stdout", t1)
+	c.remove("gone")
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := newCheckpoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if since, ok := loaded.since("abc"); !ok || !since.Equal(t1) {
+		t.Errorf("expected to resume from the oldest stream checkpoint %s got %s", t1, since)
+	}
+	if _, ok := loaded.since("gone"); ok {
+		t.Error("expected removed container to have no checkpoint")
+	}
+}
+
+func TestSplitTimestamp(t *testing.T) {
+	ts, rest, ok := splitTimestamp("2018-03-05T09:08:07.000000100Z hello world")
+	if !ok || rest != "hello world" || !ts.Equal(time.Date(2018, time.March, 5, 9, 8, 7, 100, time.UTC)) {
+		t.Errorf("unexpected split %s %q %v", ts, rest, ok)
+	}
+	if _, rest, ok = splitTimestamp("2018-03-05T09:08:07Z"); !ok || rest != "" {
+		t.Errorf("expected empty line got %q %v", rest, ok)
+	}
+	if _, rest, ok = splitTimestamp("no timestamp"); ok || rest != "no timestamp" {
+		t.Errorf("expected line without timestamp to be unchanged got %q %v", rest, ok)
+	}
+}
+
+func TestContainerPumpSkipsCheckpointed(t *testing.T) {
+	c := &checkpoints{times: make(map[string]map[string]time.Time)}
+	c.advance("8dfafdbc3a40", "stdout", time.Date(2018, time.March, 5, 9, 8, 7, 0, time.UTC))
+	container := &docker.Container{ID: "8dfafdbc3a40", Config: &docker.Config{}}
+	outrd, outwr := io.Pipe()
+	errrd, errwr := io.Pipe()
+	defer errwr.Close()
+	pump := newContainerPump(container, outrd, errrd, c)
+	logstream := make(chan *Message, 10)
+	pump.add(logstream, &Route{})
+	io.WriteString(outwr, strings.Join([]string{
+		"2018-03-05T09:08:06Z before",
+		"2018-03-05T09:08:07Z checkpointed",
+		"2018-03-05T09:08:08Z after",
+		"",
+	}, "\n"))
+	outwr.Close()
+	select {
+	case message := <-logstream:
+		if message.Data != "after" {
+			t.Errorf("expected only the line after the checkpoint got %q", message.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+	if since, _ := c.since("8dfafdbc3a40"); !since.Equal(time.Date(2018, time.March, 5, 9, 8, 8, 0, time.UTC)) {
+		t.Errorf("expected checkpoint to advance got %s", since)
+	}
+}
+
+func TestContainerPumpDockerTime(t *testing.T) {
+	os.Setenv("MESSAGE_TIME", "docker")
+	defer os.Unsetenv("MESSAGE_TIME")
+	container := &docker.Container{ID: "8dfafdbc3a40", Config: &docker.Config{}}
+	outrd, outwr := io.Pipe()
+	errrd, errwr := io.Pipe()
+	defer errwr.Close()
+	pump := newContainerPump(container, outrd, errrd, nil)
+	logstream := make(chan *Message, 10)
+	pump.add(logstream, &Route{})
+	io.WriteString(outwr, "2018-03-05T09:08:07.5Z buffered\n")
+	outwr.Close()
+	select {
+	case message := <-logstream:
+		if message.Data != "buffered" || !message.Time.Equal(time.Date(2018, time.March, 5, 9, 8, 7, 5e8, time.UTC)) {
+			t.Errorf("expected the Docker timestamp got %q at %s", message.Data, message.Time)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+}
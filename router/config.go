@@ -0,0 +1,50 @@
+package router
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envNamespaceOption is the route option naming the prefix of the env vars
+// that only configure that route
+const envNamespaceOption = "env_namespace"
+
+// RouteOpt returns a setting of route, from the first of these that is set:
+//
+//  1. the route option, e.g. ?batch_size=100
+//  2. the env var in the route's namespace, e.g. ROUTE_1_SYSLOG_BATCH_SIZE
+//  3. the env var, e.g. SYSLOG_BATCH_SIZE
+//  4. dfault
+//
+// Settings without a route option pass "" as option, and are still
+// namespaced.
+func RouteOpt(route *Route, option, env, dfault string) string {
+	if option != "" && route.Options[option] != "" {
+		return route.Options[option]
+	}
+	if env == "" {
+		return dfault
+	}
+	if namespace := RouteEnvNamespace(route); namespace != "" {
+		if value := os.Getenv(namespace + "_" + env); value != "" {
+			return value
+		}
+	}
+	return getopt(env, dfault)
+}
+
+// RouteEnvNamespace returns the prefix of the env vars that only configure
+// route: its env_namespace option, e.g. EU for EU_SYSLOG_TAG, or "" if it
+// has none
+func RouteEnvNamespace(route *Route) string {
+	return strings.TrimSuffix(strings.ToUpper(route.Options[envNamespaceOption]), "_")
+}
+
+// setEnvNamespace gives a route the namespace ROUTE_<n> of its position in
+// ROUTE_URIS, counting from 1, unless it has one
+func setEnvNamespace(route *Route, n int) {
+	if route.Options[envNamespaceOption] == "" {
+		route.Options[envNamespaceOption] = "ROUTE_" + strconv.Itoa(n)
+	}
+}
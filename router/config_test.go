@@ -0,0 +1,39 @@
+package router
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRouteOpt(t *testing.T) {
+	os.Setenv("TEST_TAG", "global")
+	defer os.Unsetenv("TEST_TAG")
+	os.Setenv("ROUTE_2_TEST_TAG", "second")
+	defer os.Unsetenv("ROUTE_2_TEST_TAG")
+
+	first := &Route{Options: map[string]string{}}
+	setEnvNamespace(first, 1)
+	second := &Route{Options: map[string]string{}}
+	setEnvNamespace(second, 2)
+	named := &Route{Options: map[string]string{"env_namespace": "route_2", "tag": "option"}}
+	setEnvNamespace(named, 3)
+
+	for _, test := range []struct {
+		route    *Route
+		option   string
+		env      string
+		expected string
+	}{
+		{first, "tag", "TEST_TAG", "global"},
+		{second, "tag", "TEST_TAG", "second"},
+		{second, "", "TEST_TAG", "second"},
+		{named, "tag", "TEST_TAG", "option"},
+		{named, "", "TEST_TAG", "second"},
+		{first, "tag", "TEST_UNSET", "default"},
+		{first, "tag", "", "default"},
+	} {
+		if value := RouteOpt(test.route, test.option, test.env, "default"); value != test.expected {
+			t.Errorf("expected %s for %s %s of %v got %s", test.expected, test.option, test.env, test.route.Options, value)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package router
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// CounterRegistry counts events of processors and adapters by route
+type CounterRegistry struct {
+	mu     sync.Mutex
+	routes map[string]map[string]uint64
+}
+
+// Counters counts events by route, for the stats endpoint
+var Counters = &CounterRegistry{routes: make(map[string]map[string]uint64)}
+
+// Add adds delta to the counter name of route
+func (c *CounterRegistry) Add(route *Route, name string, delta uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counters, ok := c.routes[route.ID]
+	if !ok {
+		counters = make(map[string]uint64)
+		c.routes[route.ID] = counters
+	}
+	counters[name] += delta
+}
+
+// Get returns the counter name of route
+func (c *CounterRegistry) Get(route *Route, name string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.routes[route.ID][name]
+}
+
+// MarshalJSON writes the counters of each route by route ID
+func (c *CounterRegistry) MarshalJSON() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.Marshal(c.routes)
+}
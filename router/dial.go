@@ -0,0 +1,96 @@
+package router
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// DialTimeout returns how long a transport may take to resolve and connect
+// to a route's address, from the dial_timeout route option or DIAL_TIMEOUT
+func DialTimeout(options map[string]string) (time.Duration, error) {
+	value := getopt("DIAL_TIMEOUT", "10s")
+	if options["dial_timeout"] != "" {
+		value = options["dial_timeout"]
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, errors.New("invalid value for dial_timeout: " + value)
+	}
+	return timeout, nil
+}
+
+// KeepAlive returns the keep-alive period of a route's tcp and tls
+// connections for net.Dialer, from the keepalive route option or
+// TCP_KEEPALIVE, negative when 0 disables keep-alives and 0, Go's default of
+// 15s, when unset
+func KeepAlive(options map[string]string) (time.Duration, error) {
+	value := getopt("TCP_KEEPALIVE", "")
+	if options["keepalive"] != "" {
+		value = options["keepalive"]
+	}
+	if value == "" {
+		return 0, nil
+	}
+	period, err := time.ParseDuration(value)
+	if err != nil || period < 0 {
+		return 0, errors.New("invalid value for keepalive: " + value)
+	}
+	if period == 0 {
+		return -1, nil
+	}
+	return period, nil
+}
+
+// WriteTimeout returns how long a write to a route's tcp or tls connection
+// may block, from the write_timeout route option or WRITE_TIMEOUT, where 0
+// is no limit
+func WriteTimeout(options map[string]string) (time.Duration, error) {
+	value := getopt("WRITE_TIMEOUT", "0")
+	if options["write_timeout"] != "" {
+		value = options["write_timeout"]
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, errors.New("invalid value for write_timeout: " + value)
+	}
+	return timeout, nil
+}
+
+// TimeoutWrites returns conn failing writes that block for longer than
+// timeout with a timeout error, or conn itself when timeout is 0, so
+// adapters reconnect to receivers that stopped reading rather than hang
+func TimeoutWrites(conn net.Conn, timeout time.Duration) net.Conn {
+	if timeout == 0 {
+		return conn
+	}
+	return &writeTimeoutConn{Conn: conn, timeout: timeout}
+}
+
+type writeTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *writeTimeoutConn) Write(p []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+// Datagram returns whether each write to conn is sent as a single datagram,
+// as on udp and unixgram connections, which can't batch or stream messages.
+// Connections wrapping a datagram connection say so with a Datagram method.
+func Datagram(conn net.Conn) bool {
+	switch c := conn.(type) {
+	case interface{ Datagram() bool }:
+		return c.Datagram()
+	case *net.UDPConn:
+		return true
+	case *net.UnixConn:
+		addr, ok := c.RemoteAddr().(*net.UnixAddr)
+		return ok && addr != nil && addr.Net == "unixgram"
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package router
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDialTimeout(t *testing.T) {
+	os.Unsetenv("DIAL_TIMEOUT")
+	if timeout, err := DialTimeout(map[string]string{}); err != nil || timeout != 10*time.Second {
+		t.Errorf("expected default of 10s got %s %v", timeout, err)
+	}
+	os.Setenv("DIAL_TIMEOUT", "3s")
+	defer os.Unsetenv("DIAL_TIMEOUT")
+	if timeout, _ := DialTimeout(map[string]string{}); timeout != 3*time.Second {
+		t.Errorf("expected DIAL_TIMEOUT of 3s got %s", timeout)
+	}
+	if timeout, _ := DialTimeout(map[string]string{"dial_timeout": "500ms"}); timeout != 500*time.Millisecond {
+		t.Errorf("expected route option of 500ms got %s", timeout)
+	}
+	if _, err := DialTimeout(map[string]string{"dial_timeout": "later"}); err == nil {
+		t.Error("expected error for invalid dial_timeout")
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	cases := map[string]time.Duration{"": 0, "30s": 30 * time.Second, "0": -1}
+	for value, expected := range cases {
+		if period, err := KeepAlive(map[string]string{"keepalive": value}); err != nil || period != expected {
+			t.Errorf("expected keepalive=%s to be %s got %s %v", value, expected, period, err)
+		}
+	}
+	if _, err := KeepAlive(map[string]string{"keepalive": "-1s"}); err == nil {
+		t.Error("expected error for invalid keepalive")
+	}
+}
+
+func TestTimeoutWrites(t *testing.T) {
+	timeout, err := WriteTimeout(map[string]string{"write_timeout": "50ms"})
+	if err != nil || timeout != 50*time.Millisecond {
+		t.Fatalf("expected route option of 50ms got %s %v", timeout, err)
+	}
+	if _, err := WriteTimeout(map[string]string{"write_timeout": "soon"}); err == nil {
+		t.Error("expected error for invalid write_timeout")
+	}
+	// nothing reads from the other end, as with a black-holed receiver
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := TimeoutWrites(client, timeout)
+	defer conn.Close()
+	start := time.Now()
+	if _, err := conn.Write([]byte("hello\n")); ErrorCategory(err) != ErrorTimeout {
+		t.Errorf("expected a timeout error got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the write to time out after 50ms, took %s", elapsed)
+	}
+	if TimeoutWrites(client, 0) != client {
+		t.Error("expected writes without a timeout left alone")
+	}
+}
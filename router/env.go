@@ -0,0 +1,55 @@
+package router
+
+import (
+	"strings"
+	"sync"
+)
+
+// exposed caches the names of EXPOSE_CONTAINER_ENV, parsed again only
+// when it changes
+var exposed struct {
+	sync.Mutex
+	value string
+	names map[string]bool
+}
+
+// Env returns the container environment variables listed in
+// EXPOSE_CONTAINER_ENV, for templates as {{.Env.SERVICE_NAME}}. Listed
+// variables the container doesn't set are empty, while other variables,
+// which may hold secrets, are never exposed.
+func (m *Message) Env() map[string]string {
+	names := exposedEnv()
+	env := make(map[string]string, len(names))
+	for name := range names {
+		env[name] = ""
+	}
+	if m.Container == nil || m.Container.Config == nil {
+		return env
+	}
+	for _, kv := range m.Container.Config.Env {
+		kvp := strings.SplitN(kv, "=", 2)
+		if len(kvp) == 2 && names[kvp[0]] {
+			env[kvp[0]] = kvp[1]
+		}
+	}
+	return env
+}
+
+// exposedEnv returns the names of the comma separated EXPOSE_CONTAINER_ENV.
+// The map is shared, so it must not be modified.
+func exposedEnv() map[string]bool {
+	value := getopt("EXPOSE_CONTAINER_ENV", "")
+	exposed.Lock()
+	defer exposed.Unlock()
+	if value == exposed.value {
+		return exposed.names
+	}
+	names := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names[name] = true
+		}
+	}
+	exposed.value, exposed.names = value, names
+	return names
+}
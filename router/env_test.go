@@ -0,0 +1,50 @@
+package router
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"text/template"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestMessageEnv(t *testing.T) {
+	message := &Message{Container: &docker.Container{Config: &docker.Config{
+		Env: []string{"SERVICE_NAME=checkout", "VERSION=1.4.2", "DB_PASSWORD=secret"},
+	}}}
+	tmpl := template.Must(template.New("").Parse("{{.Env.SERVICE_NAME}}@{{.Env.VERSION}}{{.Env.REGION}}|{{index .Env \"DB_PASSWORD\"}}"))
+	render := func() string {
+		buf := new(bytes.Buffer)
+		if err := tmpl.Execute(buf, message); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	if out := render(); out != "<no value>@<no value><no value>|" {
+		t.Errorf("expected no env vars without EXPOSE_CONTAINER_ENV got %q", out)
+	}
+	os.Setenv("EXPOSE_CONTAINER_ENV", "SERVICE_NAME, VERSION,REGION")
+	defer os.Unsetenv("EXPOSE_CONTAINER_ENV")
+	if out := render(); out != "checkout@1.4.2|" {
+		t.Errorf("expected the listed env vars got %q", out)
+	}
+	if env := (&Message{Container: &docker.Container{}}).Env(); len(env) != 3 || env["VERSION"] != "" {
+		t.Errorf("expected empty env vars without a config got %v", env)
+	}
+}
+
+func TestExposedEnvCached(t *testing.T) {
+	os.Setenv("EXPOSE_CONTAINER_ENV", "SERVICE_NAME")
+	defer os.Unsetenv("EXPOSE_CONTAINER_ENV")
+	names := exposedEnv()
+	names["cached"] = true
+	if !exposedEnv()["cached"] {
+		t.Error("expected EXPOSE_CONTAINER_ENV parsed once")
+	}
+	os.Setenv("EXPOSE_CONTAINER_ENV", "VERSION")
+	if names := exposedEnv(); len(names) != 1 || !names["VERSION"] {
+		t.Errorf("expected EXPOSE_CONTAINER_ENV parsed again once changed got %v", names)
+	}
+}
@@ -0,0 +1,80 @@
+package router
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"text/template"
+)
+
+// cefEscaper escapes the characters of CEF and LEEF header fields
+var cefEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`, "\r", " ", "\n", " ")
+
+// cefValueEscaper escapes the characters of CEF extension values
+var cefValueEscaper = strings.NewReplacer(`\`, `\\`, "=", `\=`, "\r", `\r`, "\n", `\n`)
+
+// envelopeFuncs are the functions of envelope templates, for SIEM formats
+var envelopeFuncs = template.FuncMap{
+	"cef":      cefEscaper.Replace,
+	"cefValue": cefValueEscaper.Replace,
+}
+
+// Envelope wraps the payloads an adapter renders for a route in the outer
+// format of the route's envelope template, e.g. a tenant header line or a
+// CEF or LEEF prefix, without changing the adapter's own template
+type Envelope struct {
+	tmpl *template.Template
+}
+
+// EnvelopeData is what envelope templates are executed with: the message,
+// and the payload the adapter rendered for it as Body
+type EnvelopeData struct {
+	*Message
+	Body string
+}
+
+// NewEnvelope returns the envelope of route, or nil if it has none: the
+// base64 encoded template of its envelope option, like the template option,
+// or ENVELOPE_FORMAT
+func NewEnvelope(route *Route) (*Envelope, error) {
+	format := RouteOpt(route, "", "ENVELOPE_FORMAT", "")
+	if value := route.Options["envelope"]; value != "" {
+		var err error
+		if format, err = decodeTemplate(value); err != nil {
+			return nil, errors.New("invalid envelope option (must be base64): " + value)
+		}
+	}
+	if format == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("envelope").Funcs(envelopeFuncs).Parse(format)
+	if err != nil {
+		return nil, errors.New("invalid envelope: " + err.Error())
+	}
+	return &Envelope{tmpl: tmpl}, nil
+}
+
+// Wrap returns payload, rendered for message, wrapped in the envelope, in
+// a buffer from the render pool that replaces payload. The body is the
+// payload without its trailing newline, which is added back after the
+// envelope, so envelopes of line based payloads don't have to frame them.
+// Without an envelope payload is returned as is.
+func (e *Envelope) Wrap(message *Message, payload *bytes.Buffer) (*bytes.Buffer, error) {
+	if e == nil {
+		return payload, nil
+	}
+	body := payload.Bytes()
+	newline := bytes.HasSuffix(body, []byte("\n"))
+	if newline {
+		body = body[:len(body)-1]
+	}
+	buf, err := Render(e.tmpl, &EnvelopeData{Message: message, Body: string(body)})
+	PutBuffer(payload)
+	if err != nil {
+		return nil, NewDeliveryError(ErrorSerialization, err)
+	}
+	if newline && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	return buf, nil
+}
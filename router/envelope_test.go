@@ -0,0 +1,60 @@
+package router
+
+import (
+	"encoding/base64"
+	"os"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestEnvelopeWrap(t *testing.T) {
+	format := `CEF:0|Acme|logspout|1.0|{{ cef .Source }}|{{ cef .Container.Name }}|5|msg={{ cefValue .Body }}`
+	route := &Route{Options: map[string]string{"envelope": base64.StdEncoding.EncodeToString([]byte(format))}}
+	envelope, err := NewEnvelope(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := &Message{Container: &docker.Container{Name: "/web|1"}, Source: "stdout", Data: "a=b"}
+	payload := GetBuffer()
+	payload.WriteString("user=root\\n path=/\n")
+	buf, err := envelope.Wrap(message, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `CEF:0|Acme|logspout|1.0|stdout|/web\|1|5|msg=user\=root\\n path\=/` + "\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q got %q", expected, buf)
+	}
+
+	var none *Envelope
+	payload = GetBuffer()
+	payload.WriteString("line\n")
+	if buf, err := none.Wrap(message, payload); err != nil || buf.String() != "line\n" {
+		t.Errorf("expected the payload unwrapped got %q %v", buf, err)
+	}
+}
+
+func TestEnvelopeOptions(t *testing.T) {
+	os.Setenv("ENVELOPE_FORMAT", "tenant=acme {{.Body}}")
+	defer os.Unsetenv("ENVELOPE_FORMAT")
+	envelope, err := NewEnvelope(&Route{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := GetBuffer()
+	payload.WriteString("line")
+	if buf, err := envelope.Wrap(&Message{}, payload); err != nil || buf.String() != "tenant=acme line" {
+		t.Errorf("expected the payload wrapped got %q %v", buf, err)
+	}
+
+	os.Unsetenv("ENVELOPE_FORMAT")
+	if envelope, err := NewEnvelope(&Route{}); envelope != nil || err != nil {
+		t.Errorf("expected no envelope got %v %v", envelope, err)
+	}
+	for _, value := range []string{"%%%", base64.StdEncoding.EncodeToString([]byte("{{ .Body"))} {
+		if _, err := NewEnvelope(&Route{Options: map[string]string{"envelope": value}}); err == nil {
+			t.Errorf("expected an error for %s", value)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package router
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"text/template"
+)
+
+// Delivery error categories
+const (
+	ErrorDNS           = "dns"
+	ErrorConnect       = "connect"
+	ErrorTLS           = "tls"
+	ErrorAuth          = "auth"
+	ErrorThrottle      = "throttle"
+	ErrorSerialization = "serialization"
+	ErrorTimeout       = "timeout"
+	// ErrorDropped is the category of messages logspout dropped itself,
+	// such as on a full send queue or an open circuit breaker
+	ErrorDropped = "dropped"
+	ErrorOther   = "other"
+)
+
+// DeliveryError is an error of an adapter delivering messages with the
+// category of its cause
+type DeliveryError struct {
+	Category string
+	Err      error
+}
+
+func (e *DeliveryError) Error() string {
+	return e.Err.Error()
+}
+
+// NewDeliveryError returns err with category, or nil if err is nil
+func NewDeliveryError(category string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &DeliveryError{Category: category, Err: err}
+}
+
+// HTTPErrorCategory returns the category of a failed HTTP response status
+func HTTPErrorCategory(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrorAuth
+	case status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable:
+		return ErrorThrottle
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return ErrorTimeout
+	case status == http.StatusBadRequest || status == http.StatusRequestEntityTooLarge:
+		return ErrorSerialization
+	}
+	return ErrorOther
+}
+
+// ErrorCategory returns the category of a delivery error, or "" for nil.
+// Errors can give their category with a Category method, otherwise it is
+// told from the type of network, TLS and encoding errors.
+func ErrorCategory(err error) string {
+	switch e := err.(type) {
+	case nil:
+		return ""
+	case *DeliveryError:
+		return e.Category
+	case interface{ Category() string }:
+		return e.Category()
+	case *url.Error:
+		return ErrorCategory(e.Err)
+	case *net.DNSError:
+		return ErrorDNS
+	case x509.UnknownAuthorityError, x509.HostnameError, x509.CertificateInvalidError:
+		return ErrorTLS
+	case *json.MarshalerError, *json.UnsupportedTypeError, *json.UnsupportedValueError, template.ExecError:
+		return ErrorSerialization
+	}
+	if err == ErrBreakerOpen {
+		return ErrorDropped
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return ErrorTimeout
+	}
+	message := err.Error()
+	if strings.HasPrefix(message, "tls: ") || strings.HasPrefix(message, "x509: ") ||
+		strings.Contains(message, ": tls: ") || strings.Contains(message, ": x509: ") {
+		return ErrorTLS
+	}
+	if e, ok := err.(*net.OpError); ok {
+		if category := ErrorCategory(e.Err); category != ErrorOther {
+			return category
+		}
+		return ErrorConnect
+	}
+	if e, ok := err.(*os.SyscallError); ok {
+		err = e.Err
+	}
+	switch err {
+	case syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.EPIPE, syscall.ENETUNREACH, syscall.EHOSTUNREACH:
+		return ErrorConnect
+	}
+	return ErrorOther
+}
+
+// LogDeliveryError logs an adapter's delivery error with its category
+func LogDeliveryError(adapter string, err error) {
+	NewLogger(adapter).Error("delivery failed", "category", ErrorCategory(err), "error", err)
+}
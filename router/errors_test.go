@@ -0,0 +1,79 @@
+package router
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+type categorized struct{}
+
+func (categorized) Error() string    { return "429 too many requests" }
+func (categorized) Category() string { return ErrorThrottle }
+
+func TestErrorCategory(t *testing.T) {
+	_, marshalErr := json.Marshal(make(chan int))
+	for _, test := range []struct {
+		err      error
+		category string
+	}{
+		{&net.DNSError{Err: "no such host", Name: "logs.example.com"}, ErrorDNS},
+		{&url.Error{Op: "Post", URL: "https://logs", Err: &net.DNSError{Err: "no such host"}}, ErrorDNS},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, ErrorConnect},
+		{&net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}, ErrorConnect},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}, ErrorTimeout},
+		{x509.UnknownAuthorityError{}, ErrorTLS},
+		{errors.New("remote error: tls: bad certificate"), ErrorTLS},
+		{marshalErr, ErrorSerialization},
+		{NewDeliveryError(ErrorAuth, errors.New("invalid token")), ErrorAuth},
+		{categorized{}, ErrorThrottle},
+		{ErrBreakerOpen, ErrorDropped},
+		{errors.New("something else"), ErrorOther},
+	} {
+		if got := ErrorCategory(test.err); got != test.category {
+			t.Errorf("%v: expected %s got %s", test.err, test.category, got)
+		}
+	}
+	if ErrorCategory(nil) != "" || NewDeliveryError(ErrorAuth, nil) != nil {
+		t.Error("expected no category for no error")
+	}
+}
+
+func TestHTTPErrorCategory(t *testing.T) {
+	for status, category := range map[int]string{
+		401: ErrorAuth,
+		403: ErrorAuth,
+		429: ErrorThrottle,
+		503: ErrorThrottle,
+		504: ErrorTimeout,
+		413: ErrorSerialization,
+		500: ErrorOther,
+	} {
+		if got := HTTPErrorCategory(status); got != category {
+			t.Errorf("%v: expected %s got %s", status, category, got)
+		}
+	}
+}
+
+func TestBudgetErrorCategories(t *testing.T) {
+	bt := &BudgetTracker{routes: make(map[string]*RouteBudget)}
+	route := &Route{ID: "categories"}
+	bt.observe(route, &net.DNSError{Err: "no such host"})
+	bt.observe(route, &net.DNSError{Err: "no such host"})
+	bt.observe(route, NewDeliveryError(ErrorAuth, errors.New("denied")))
+	bt.observe(route, nil)
+	if errs := bt.routes["categories"].Errors; errs[ErrorDNS] != 2 || errs[ErrorAuth] != 1 || len(errs) != 2 {
+		t.Errorf("unexpected errors by category %v", errs)
+	}
+}
@@ -0,0 +1,99 @@
+package router
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// EventSource is the source of the messages annotating container lifecycle
+// events with EVENTS_TO_LOGS
+const EventSource = "event"
+
+const eventBuffer = 256
+
+// lifecycleEvents are the container events EVENTS_TO_LOGS can annotate
+var lifecycleEvents = []string{"start", "stop", "die", "oom"}
+
+// eventsToLogs returns the container events EVENTS_TO_LOGS sends as log
+// messages: all of them for true, or a comma separated list of events
+func eventsToLogs() (map[string]bool, error) {
+	value := getopt("EVENTS_TO_LOGS", "false")
+	events := make(map[string]bool)
+	switch value {
+	case "false":
+		return events, nil
+	case "true":
+		value = strings.Join(lifecycleEvents, ",")
+	}
+	for _, event := range strings.Split(value, ",") {
+		if !contains(lifecycleEvents, event) {
+			return nil, errors.New("invalid value for EVENTS_TO_LOGS (must be true or some of " + strings.Join(lifecycleEvents, ",") + "): " + value)
+		}
+		events[event] = true
+	}
+	return events, nil
+}
+
+// annotate queues a message for event when EVENTS_TO_LOGS includes it.
+// Messages are sent in the order of the events by annotations.
+func (p *LogsPump) annotate(event *docker.APIEvents) {
+	if !p.annotated[event.Status] {
+		return
+	}
+	select {
+	case p.events <- event:
+	default:
+		debug("pump.annotate():", normalID(event.ID), "dropped", event.Status, "event: too many pending")
+	}
+}
+
+// annotations sends the queued event messages to the routes of the
+// containers they are about
+func (p *LogsPump) annotations() {
+	for event := range p.events {
+		p.mu.Lock()
+		pump, pumping := p.pumps[normalID(event.ID)]
+		p.mu.Unlock()
+		if !pumping {
+			debug("pump.annotations():", normalID(event.ID), "ignored", event.Status, "event: not pumping")
+			continue
+		}
+		pump.send(eventMessage(pump.container, event))
+	}
+}
+
+// eventMessage returns the message for a container event, like
+// "container died (exit 137)", with the event and its exit code as fields
+func eventMessage(container *docker.Container, event *docker.APIEvents) *Message {
+	msg := &Message{
+		Container: container,
+		Source:    EventSource,
+		Time:      time.Now(),
+		Fields:    map[string]string{"event": event.Status},
+	}
+	if event.TimeNano != 0 {
+		msg.Time = time.Unix(0, event.TimeNano)
+	} else if event.Time != 0 {
+		msg.Time = time.Unix(event.Time, 0)
+	}
+	switch event.Status {
+	case "start":
+		msg.Data = "container started"
+	case "stop":
+		msg.Data = "container stopped"
+	case "die":
+		msg.Data = "container died"
+		if code := event.Actor.Attributes["exitCode"]; code != "" {
+			msg.Data += " (exit " + code + ")"
+			msg.Fields["exit_code"] = code
+		}
+	case "oom":
+		msg.Data = "container ran out of memory"
+	default:
+		msg.Data = "container " + event.Status
+	}
+	return msg
+}
@@ -0,0 +1,67 @@
+package router
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestEventsToLogs(t *testing.T) {
+	defer os.Unsetenv("EVENTS_TO_LOGS")
+	os.Unsetenv("EVENTS_TO_LOGS")
+	if events, err := eventsToLogs(); err != nil || len(events) != 0 {
+		t.Errorf("expected no events by default got %v %v", events, err)
+	}
+	os.Setenv("EVENTS_TO_LOGS", "true")
+	if events, _ := eventsToLogs(); len(events) != len(lifecycleEvents) {
+		t.Errorf("expected all events got %v", events)
+	}
+	os.Setenv("EVENTS_TO_LOGS", "die,oom")
+	if events, _ := eventsToLogs(); !events["die"] || !events["oom"] || events["start"] {
+		t.Errorf("expected die and oom got %v", events)
+	}
+	os.Setenv("EVENTS_TO_LOGS", "die,pause")
+	if _, err := eventsToLogs(); err == nil {
+		t.Error("expected an error for pause")
+	}
+}
+
+func TestEventAnnotations(t *testing.T) {
+	container := &docker.Container{ID: "8dfafdbc3a40", Name: "/job", Config: &docker.Config{}}
+	cp := newContainerPump(container, strings.NewReader(""), strings.NewReader(""), nil)
+	logstream := make(chan *Message, 2)
+	cp.add(logstream, &Route{})
+	p := &LogsPump{
+		pumps:     map[string]*containerPump{"8dfafdbc3a40": cp},
+		annotated: map[string]bool{"die": true, "oom": true},
+		events:    make(chan *docker.APIEvents, eventBuffer),
+	}
+	go p.annotations()
+	defer close(p.events)
+
+	at := time.Date(2018, time.March, 5, 9, 8, 7, 0, time.UTC)
+	p.annotate(&docker.APIEvents{Status: "start", ID: "8dfafdbc3a40"})
+	p.annotate(&docker.APIEvents{Status: "oom", ID: "8dfafdbc3a40"})
+	p.annotate(&docker.APIEvents{
+		Status:   "die",
+		ID:       "8dfafdbc3a40",
+		TimeNano: at.UnixNano(),
+		Actor:    docker.APIActor{Attributes: map[string]string{"exitCode": "137"}},
+	})
+	for _, expected := range []string{"container ran out of memory", "container died (exit 137)"} {
+		select {
+		case message := <-logstream:
+			if message.Data != expected || message.Source != EventSource {
+				t.Errorf("expected %q got %+v", expected, message)
+			}
+			if message.Fields["event"] == "die" && (message.Fields["exit_code"] != "137" || !message.Time.Equal(at)) {
+				t.Errorf("unexpected die message: %+v", message)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %q", expected)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package router
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ExitSource is the source of the marker sent when a container exits
+const ExitSource = "exit"
+
+// exitFlushTimeout returns EXIT_FLUSH_TIMEOUT, how long the remaining
+// output of exited containers goes ahead of other containers' messages
+func exitFlushTimeout() time.Duration {
+	timeout, err := parseExitFlushTimeout()
+	if err != nil {
+		return 0
+	}
+	return timeout
+}
+
+func parseExitFlushTimeout() (time.Duration, error) {
+	value := getopt("EXIT_FLUSH_TIMEOUT", "0")
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, errors.New("invalid value for EXIT_FLUSH_TIMEOUT: " + value)
+	}
+	return timeout, nil
+}
+
+// exitMarker returns whether EXIT_MARKER sends a message when containers exit
+func exitMarker() bool {
+	return getopt("EXIT_MARKER", "false") == "true"
+}
+
+// exiting marks the container as exited, sending its messages ahead of
+// other containers' until the deadline. It doesn't wait for sends in
+// progress, so the docker event loop isn't held up by slow routes.
+func (cp *containerPump) exiting(deadline time.Time) {
+	atomic.CompareAndSwapInt64(&cp.deadline, 0, deadline.UnixNano())
+}
+
+// exitDeadline returns the exit flush deadline and whether it is pending
+func (cp *containerPump) exitDeadline() (time.Time, bool) {
+	nanos := atomic.LoadInt64(&cp.deadline)
+	if nanos == 0 {
+		return time.Time{}, false
+	}
+	deadline := time.Unix(0, nanos)
+	return deadline, time.Now().Before(deadline)
+}
+
+// exited waits for the container's output to be sent and sends the exit
+// marker, with the exit code when it is known
+func (cp *containerPump) exited(code int, known bool) {
+	cp.readers.Wait()
+	msg := &Message{
+		Data:      "container exited",
+		Container: cp.container,
+		Time:      time.Now(),
+		Source:    ExitSource,
+		Exiting:   true,
+	}
+	if known {
+		msg.Data += " with code " + strconv.Itoa(code)
+		msg.Fields = map[string]string{"exit_code": strconv.Itoa(code)}
+	}
+	cp.send(msg)
+}
+
+// sendPriority sends msg on the route's priority input until the deadline,
+// returning false if it wasn't sent
+func sendPriority(route *Route, msg *Message, deadline time.Time) bool {
+	wait := time.Until(deadline)
+	if route.priority == nil || wait <= 0 {
+		return false
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case route.priority <- msg:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// prioritize passes messages from priority and logstream to out, those from
+// priority first. It closes out once logstream is closed.
+func prioritize(priority, logstream, out chan *Message) {
+	defer close(out)
+	for {
+		select {
+		case message := <-priority:
+			out <- message
+			continue
+		default:
+		}
+		select {
+		case message := <-priority:
+			out <- message
+		case message, ok := <-logstream:
+			if !ok {
+				return
+			}
+			out <- message
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package router
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestPrioritize(t *testing.T) {
+	priority, logstream, out := make(chan *Message), make(chan *Message), make(chan *Message)
+	go func() { logstream <- &Message{Data: "steady"} }()
+	go func() { priority <- &Message{Data: "final"} }()
+	// let both sends block before anything is received
+	time.Sleep(50 * time.Millisecond)
+	go prioritize(priority, logstream, out)
+	for _, expected := range []string{"final", "steady"} {
+		if message := <-out; message.Data != expected {
+			t.Errorf("expected %q got %q", expected, message.Data)
+		}
+	}
+	close(logstream)
+	if _, ok := <-out; ok {
+		t.Error("expected out to be closed")
+	}
+}
+
+func TestContainerPumpExiting(t *testing.T) {
+	container := &docker.Container{ID: "8dfafdbc3a40", Config: &docker.Config{}}
+	cp := newContainerPump(container, strings.NewReader(""), strings.NewReader(""), nil)
+	logstream := make(chan *Message, 1)
+	route := &Route{priority: make(chan *Message, 1)}
+	cp.add(logstream, route)
+
+	cp.send(&Message{Data: "steady"})
+	if message := <-logstream; message.Exiting {
+		t.Error("expected a steady message")
+	}
+
+	cp.exiting(time.Now().Add(time.Minute))
+	cp.exiting(time.Now().Add(-time.Minute)) // the first deadline holds
+	cp.send(&Message{Data: "final"})
+	select {
+	case message := <-route.priority:
+		if !message.Exiting {
+			t.Error("expected an exiting message")
+		}
+	default:
+		t.Fatal("expected the message on the priority input")
+	}
+
+	cp.exited(3, true)
+	marker := <-route.priority
+	if marker.Source != ExitSource || marker.Data != "container exited with code 3" || marker.Fields["exit_code"] != "3" {
+		t.Errorf("unexpected exit marker: %+v", marker)
+	}
+}
+
+func TestContainerPumpExitDeadline(t *testing.T) {
+	container := &docker.Container{ID: "8dfafdbc3a40", Config: &docker.Config{}}
+	cp := newContainerPump(container, strings.NewReader(""), strings.NewReader(""), nil)
+	logstream := make(chan *Message, 1)
+	route := &Route{priority: make(chan *Message)}
+	cp.add(logstream, route)
+	cp.exiting(time.Now().Add(20 * time.Millisecond))
+	// nothing receives the priority input, so the message waits out the
+	// deadline and goes to the logstream
+	cp.send(&Message{Data: "final"})
+	if message := <-logstream; message.Data != "final" || !message.Exiting {
+		t.Errorf("unexpected message: %+v", message)
+	}
+}
+
+func TestParseExitFlushTimeout(t *testing.T) {
+	defer os.Unsetenv("EXIT_FLUSH_TIMEOUT")
+	os.Setenv("EXIT_FLUSH_TIMEOUT", "-1s")
+	if _, err := parseExitFlushTimeout(); err == nil {
+		t.Error("expected an error for a negative timeout")
+	}
+	os.Setenv("EXIT_FLUSH_TIMEOUT", "5s")
+	if timeout := exitFlushTimeout(); timeout != 5*time.Second {
+		t.Errorf("expected 5s got %s", timeout)
+	}
+}
@@ -334,3 +334,89 @@ func (ep *logRouterExt) Names() []string {
 }
 
 
+// ProcessorFactory
+
+var ProcessorFactories = &processorFactoryExt{
+	newExtensionPoint(new(ProcessorFactory)),
+}
+
+type processorFactoryExt struct {
+	*extensionPoint
+}
+
+func (ep *processorFactoryExt) Unregister(name string) bool {
+	return ep.unregister(name)
+}
+
+func (ep *processorFactoryExt) Register(component ProcessorFactory, name string) bool {
+	return ep.register(component, name)
+}
+
+func (ep *processorFactoryExt) Lookup(name string) (ProcessorFactory, bool) {
+	ext, ok := ep.lookup(name)
+	if !ok {
+		return nil, ok
+	}
+	return ext.(ProcessorFactory), ok
+}
+
+func (ep *processorFactoryExt) All() map[string]ProcessorFactory {
+	all := make(map[string]ProcessorFactory)
+	for k, v := range ep.all() {
+		all[k] = v.(ProcessorFactory)
+	}
+	return all
+}
+
+func (ep *processorFactoryExt) Names() []string {
+	var names []string
+	for k := range ep.all() {
+		names = append(names, k)
+	}
+	return names
+}
+
+
+// ContainerFilter
+
+var ContainerFilters = &containerFilterExt{
+	newExtensionPoint(new(ContainerFilter)),
+}
+
+type containerFilterExt struct {
+	*extensionPoint
+}
+
+func (ep *containerFilterExt) Unregister(name string) bool {
+	return ep.unregister(name)
+}
+
+func (ep *containerFilterExt) Register(component ContainerFilter, name string) bool {
+	return ep.register(component, name)
+}
+
+func (ep *containerFilterExt) Lookup(name string) (ContainerFilter, bool) {
+	ext, ok := ep.lookup(name)
+	if !ok {
+		return nil, ok
+	}
+	return ext.(ContainerFilter), ok
+}
+
+func (ep *containerFilterExt) All() map[string]ContainerFilter {
+	all := make(map[string]ContainerFilter)
+	for k, v := range ep.all() {
+		all[k] = v.(ContainerFilter)
+	}
+	return all
+}
+
+func (ep *containerFilterExt) Names() []string {
+	var names []string
+	for k := range ep.all() {
+		names = append(names, k)
+	}
+	return names
+}
+
+
@@ -0,0 +1,240 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var failoverLog = NewLogger("failover")
+
+var (
+	failoverGroupsMu sync.Mutex
+	failoverGroups   = make(map[string]*failoverGroup)
+)
+
+// failoverGroup tracks which endpoint of a failover route is in use. It is
+// shared by reconnects, so consecutive errors are counted across them.
+type failoverGroup struct {
+	mu            sync.Mutex
+	transport     AdapterTransport
+	options       map[string]string
+	endpoints     []string
+	current       int
+	errors        int
+	threshold     int
+	probeInterval time.Duration
+	lastProbe     time.Time
+}
+
+// Dial connects to addr with transport. With the mode=failover option addr
+// is a comma separated list of endpoints: the first that can be dialed is
+// used, the next one once failover_errors consecutive writes fail, and the
+// first one is probed every failover_probe_interval to fail back to it.
+// With mode=roundrobin or mode=leastconn writes are spread over the
+// endpoints instead.
+func Dial(transport AdapterTransport, addr string, options map[string]string) (net.Conn, error) {
+	switch options["mode"] {
+	case "":
+		return transport.Dial(addr, options)
+	case "failover":
+	case "roundrobin", "leastconn":
+		return dialBalanced(transport, addr, options)
+	default:
+		return nil, errors.New("invalid value for mode: " + options["mode"])
+	}
+	g, err := getFailoverGroup(transport, addr, options)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	start := g.current
+	g.mu.Unlock()
+	conn, index, err := g.dial(start)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	g.use(index)
+	g.mu.Unlock()
+	return &failoverConn{Conn: conn, group: g, index: index}, nil
+}
+
+func getFailoverGroup(transport AdapterTransport, addr string, options map[string]string) (*failoverGroup, error) {
+	key := endpointGroupKey(transport, addr, options)
+	failoverGroupsMu.Lock()
+	defer failoverGroupsMu.Unlock()
+	if g, ok := failoverGroups[key]; ok {
+		return g, nil
+	}
+	g := &failoverGroup{
+		transport: transport,
+		options:   options,
+		endpoints: strings.Split(addr, ","),
+		lastProbe: time.Now(),
+	}
+	var err error
+	if g.threshold, g.probeInterval, err = endpointHealthOptions(options); err != nil {
+		return nil, err
+	}
+	failoverGroups[key] = g
+	return g, nil
+}
+
+// endpointGroupKey identifies the endpoints of routes dialing addr with
+// transport and options, as routes only share them if they connect the same
+// way, e.g. with the same TLS options. Options are hashed, since keys are
+// reported in /stats and options may hold credentials.
+func endpointGroupKey(transport AdapterTransport, addr string, options map[string]string) string {
+	// transports not registered, as in tests, are told apart by address
+	name := fmt.Sprintf("%T@%p", transport, transport)
+	for registered, t := range AdapterTransports.All() {
+		if t == transport {
+			name = registered
+			break
+		}
+	}
+	values := make(url.Values, len(options))
+	for k, v := range options {
+		if k != "mode" {
+			values.Set(k, v)
+		}
+	}
+	key := options["mode"] + "+" + name + "://" + addr
+	if len(values) > 0 {
+		hash := fnv.New32a()
+		hash.Write([]byte(values.Encode()))
+		key += fmt.Sprintf("#%08x", hash.Sum32())
+	}
+	return key
+}
+
+// endpointHealthOptions returns the consecutive errors after which an
+// endpoint of a multi-address route is considered down, and how often it is
+// probed after, from the failover_errors and failover_probe_interval options
+func endpointHealthOptions(options map[string]string) (int, time.Duration, error) {
+	value := getopt("FAILOVER_ERRORS", "3")
+	if options["failover_errors"] != "" {
+		value = options["failover_errors"]
+	}
+	threshold, err := strconv.Atoi(value)
+	if err != nil || threshold < 1 {
+		return 0, 0, errors.New("invalid value for failover_errors: " + value)
+	}
+	value = getopt("FAILOVER_PROBE_INTERVAL", "30s")
+	if options["failover_probe_interval"] != "" {
+		value = options["failover_probe_interval"]
+	}
+	probeInterval, err := time.ParseDuration(value)
+	if err != nil || probeInterval <= 0 {
+		return 0, 0, errors.New("invalid value for failover_probe_interval: " + value)
+	}
+	return threshold, probeInterval, nil
+}
+
+// dial connects to the first endpoint that can be dialed, starting at start,
+// returning its index. It doesn't lock the group, so writes of other
+// connections aren't held up while dialing.
+func (g *failoverGroup) dial(start int) (net.Conn, int, error) {
+	var err error
+	for i := 0; i < len(g.endpoints); i++ {
+		index := (start + i) % len(g.endpoints)
+		var conn net.Conn
+		if conn, err = g.transport.Dial(g.endpoints[index], g.options); err == nil {
+			return conn, index, nil
+		}
+		failoverLog.Warn("dialing failed", "endpoint", g.endpoints[index], "error", err)
+	}
+	return nil, 0, err
+}
+
+// use makes the endpoint at index the current one. The group must be locked.
+func (g *failoverGroup) use(index int) {
+	if index != g.current {
+		failoverLog.Warn("switching endpoints", "from", g.endpoints[g.current], "to", g.endpoints[index])
+		g.current = index
+		g.errors = 0
+		g.lastProbe = time.Now()
+	}
+}
+
+// failoverConn writes to the current endpoint of a failover group. The
+// group is only locked to read and update which endpoint is current, and
+// mu keeps Close from racing with a write swapping connections.
+type failoverConn struct {
+	mu sync.Mutex
+	net.Conn
+	group *failoverGroup
+	index int
+}
+
+// Write writes b to the current endpoint, switching endpoints first if the
+// primary endpoint is back or the current one failed too often
+func (c *failoverConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g := c.group
+	g.mu.Lock()
+	current := g.current
+	probe := c.index == current && current != 0 && time.Since(g.lastProbe) >= g.probeInterval
+	if probe {
+		g.lastProbe = time.Now()
+	}
+	g.mu.Unlock()
+	if c.index != current {
+		// another connection of the group switched endpoints
+		c.swap(current)
+	} else if probe {
+		if conn, err := g.transport.Dial(g.endpoints[0], g.options); err == nil {
+			c.Conn.Close()
+			c.Conn = conn
+			c.index = 0
+			g.mu.Lock()
+			g.use(0)
+			g.mu.Unlock()
+		}
+	}
+	n, err := c.Conn.Write(b)
+	g.mu.Lock()
+	if err == nil {
+		g.errors = 0
+		g.mu.Unlock()
+		return n, nil
+	}
+	g.errors++
+	failed := g.errors >= g.threshold && len(g.endpoints) > 1 && c.index == g.current
+	g.mu.Unlock()
+	if failed {
+		c.swap(c.index + 1)
+	}
+	return n, err
+}
+
+// swap replaces the connection with one to the first endpoint that can be
+// dialed from index, keeping the current connection if none can
+func (c *failoverConn) swap(index int) {
+	g := c.group
+	conn, index, err := g.dial(index % len(g.endpoints))
+	if err != nil {
+		return
+	}
+	c.Conn.Close()
+	c.Conn = conn
+	c.index = index
+	g.mu.Lock()
+	g.use(index)
+	g.mu.Unlock()
+}
+
+// Close closes the connection to the current endpoint
+func (c *failoverConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.Close()
+}
@@ -0,0 +1,135 @@
+package router
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEndpoints is a transport to endpoints that can be made unreachable
+// or to fail writes
+type fakeEndpoints struct {
+	mu      sync.Mutex
+	down    map[string]bool
+	written map[string]int
+}
+
+type fakeConn struct {
+	net.Conn
+	endpoints *fakeEndpoints
+	addr      string
+}
+
+func (c *fakeConn) Write(b []byte) (int, error) {
+	c.endpoints.mu.Lock()
+	defer c.endpoints.mu.Unlock()
+	if c.endpoints.down[c.addr] {
+		return 0, errors.New("broken pipe")
+	}
+	c.endpoints.written[c.addr]++
+	return len(b), nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (e *fakeEndpoints) Dial(addr string, options map[string]string) (net.Conn, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.down[addr] {
+		return nil, errors.New("connection refused")
+	}
+	return &fakeConn{endpoints: e, addr: addr}, nil
+}
+
+func (e *fakeEndpoints) set(addr string, down bool) {
+	e.mu.Lock()
+	e.down[addr] = down
+	e.mu.Unlock()
+}
+
+func TestFailoverDial(t *testing.T) {
+	endpoints := &fakeEndpoints{down: map[string]bool{"a:514": true}, written: make(map[string]int)}
+	options := map[string]string{"mode": "failover"}
+	conn, err := Dial(endpoints, "a:514,b:514,c:514", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello"))
+	if endpoints.written["b:514"] != 1 {
+		t.Errorf("expected a write to the first reachable endpoint got %v", endpoints.written)
+	}
+
+	endpoints.set("a:514", false)
+	endpoints.set("b:514", false)
+	endpoints.set("c:514", true)
+	if _, err := Dial(endpoints, "c:514", options); err == nil {
+		t.Error("expected an error when no endpoint can be dialed")
+	}
+}
+
+func TestFailoverWrites(t *testing.T) {
+	endpoints := &fakeEndpoints{down: make(map[string]bool), written: make(map[string]int)}
+	options := map[string]string{"mode": "failover", "failover_errors": "2", "failover_probe_interval": "10ms"}
+	conn, err := Dial(endpoints, "primary:514,secondary:514", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("one"))
+	endpoints.set("primary:514", true)
+	if _, err := conn.Write([]byte("two")); err == nil {
+		t.Error("expected the write to the broken primary to fail")
+	}
+	conn.Write([]byte("three"))
+	conn.Write([]byte("four"))
+	if endpoints.written["primary:514"] != 1 || endpoints.written["secondary:514"] != 1 {
+		t.Errorf("expected a failover after 2 errors got %v", endpoints.written)
+	}
+
+	// the primary is probed until it's back
+	time.Sleep(20 * time.Millisecond)
+	conn.Write([]byte("five"))
+	endpoints.set("primary:514", false)
+	time.Sleep(20 * time.Millisecond)
+	conn.Write([]byte("six"))
+	if endpoints.written["primary:514"] != 2 || endpoints.written["secondary:514"] != 2 {
+		t.Errorf("expected a fail back after probing got %v", endpoints.written)
+	}
+}
+
+func TestFailoverInvalidOptions(t *testing.T) {
+	endpoints := &fakeEndpoints{down: make(map[string]bool), written: make(map[string]int)}
+	if _, err := Dial(endpoints, "x:514,y:514", map[string]string{"mode": "failover", "failover_errors": "0"}); err == nil {
+		t.Error("expected an error for failover_errors 0")
+	}
+}
+
+func TestFailoverGroupsByOptions(t *testing.T) {
+	endpoints := &fakeEndpoints{down: make(map[string]bool), written: make(map[string]int)}
+	plain, err := getFailoverGroup(endpoints, "g-a:514,g-b:514", map[string]string{"mode": "failover"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secure, err := getFailoverGroup(endpoints, "g-a:514,g-b:514", map[string]string{"mode": "failover", "tls.ca": "/ca.pem"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := getFailoverGroup(&fakeEndpoints{}, "g-a:514,g-b:514", map[string]string{"mode": "failover"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := getFailoverGroup(endpoints, "g-a:514,g-b:514", map[string]string{"mode": "failover"})
+	if plain == secure || plain == other || plain != again {
+		t.Error("expected routes to share endpoints only with the same transport and options")
+	}
+}
+
+func TestSplitRouteURIs(t *testing.T) {
+	uris := splitRouteURIs("syslog+tcp://a:514,b:514?mode=failover,raw://logs:5000?filter.labels=a:1,b:2")
+	expected := []string{"syslog+tcp://a:514,b:514?mode=failover", "raw://logs:5000?filter.labels=a:1,b:2"}
+	if !reflect.DeepEqual(uris, expected) {
+		t.Errorf("expected %v got %v", expected, uris)
+	}
+}
@@ -0,0 +1,131 @@
+package router
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fanout records the routes a message was sent to, so their delivery
+// outcomes can be joined
+type Fanout struct {
+	ID       string
+	Matched  []string
+	routes   []*Route
+	reported []bool
+	created  time.Time
+	// the message without its data, for dropped receipts
+	message *Message
+}
+
+// FanoutTracker tags messages with the routes they matched and reports the
+// routes that never reported an outcome for a message as having dropped it
+type FanoutTracker struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	prefix  string
+	seq     uint64
+	pending []*Fanout
+}
+
+// Fanouts tracks which routes messages were sent to
+var Fanouts = &FanoutTracker{prefix: strconv.FormatInt(time.Now().UnixNano(), 36)}
+
+func init() {
+	Jobs.Register(Fanouts, "fanout")
+}
+
+// Enabled returns whether messages are tagged with the routes they matched
+func (ft *FanoutTracker) Enabled() bool {
+	return ft.timeout > 0
+}
+
+// Track tags message with routes, the routes it is sent to
+func (ft *FanoutTracker) Track(message *Message, routes []*Route) {
+	f := &Fanout{
+		ID:       ft.prefix + "-" + strconv.FormatUint(atomic.AddUint64(&ft.seq, 1), 36),
+		routes:   routes,
+		reported: make([]bool, len(routes)),
+		created:  time.Now(),
+	}
+	f.message = &Message{Container: message.Container, Source: message.Source, Time: message.Time, Fanout: f}
+	for _, route := range routes {
+		f.Matched = append(f.Matched, route.ID)
+	}
+	message.Fanout = f
+	ft.mu.Lock()
+	ft.pending = append(ft.pending, f)
+	ft.mu.Unlock()
+}
+
+// reported records that route reported the outcome of f's message
+func (ft *FanoutTracker) reported(f *Fanout, route *Route) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	for i, r := range f.routes {
+		if r == route {
+			f.reported[i] = true
+		}
+	}
+}
+
+// expire reports the routes that haven't reported the outcome of messages
+// tracked before the timeout as having dropped them
+func (ft *FanoutTracker) expire(now time.Time) {
+	ft.mu.Lock()
+	var expired []*Fanout
+	for len(ft.pending) > 0 && now.Sub(ft.pending[0].created) >= ft.timeout {
+		expired = append(expired, ft.pending[0])
+		ft.pending[0] = nil
+		ft.pending = ft.pending[1:]
+	}
+	var dropped []*Route
+	var fanouts []*Fanout
+	for _, f := range expired {
+		for i, route := range f.routes {
+			if !f.reported[i] {
+				dropped = append(dropped, route)
+				fanouts = append(fanouts, f)
+			}
+		}
+	}
+	ft.mu.Unlock()
+	for i, route := range dropped {
+		Receipts.report(route, fanouts[i].message, StatusDropped, nil)
+	}
+}
+
+// Name returns the name of the fan-out tracking job, empty unless enabled
+func (ft *FanoutTracker) Name() string {
+	if !ft.Enabled() {
+		return ""
+	}
+	return "fanout"
+}
+
+// Setup enables fan-out tracking if FANOUT_TIMEOUT is set
+func (ft *FanoutTracker) Setup() error {
+	timeout, err := time.ParseDuration(getopt("FANOUT_TIMEOUT", "0"))
+	if err != nil || timeout < 0 {
+		return errors.New("invalid value for FANOUT_TIMEOUT: " + getopt("FANOUT_TIMEOUT", ""))
+	}
+	ft.timeout = timeout
+	return nil
+}
+
+// Run reports dropped messages until the process exits
+func (ft *FanoutTracker) Run() error {
+	if !ft.Enabled() {
+		select {}
+	}
+	interval := ft.timeout / 10
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	for now := range time.Tick(interval) {
+		ft.expire(now)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package router
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestFanoutOutcomes(t *testing.T) {
+	Fanouts.timeout = time.Minute
+	defer func() { Fanouts.timeout = 0 }()
+	syslog := &Route{ID: "syslog"}
+	http := &Route{ID: "http"}
+	stderr := &Route{ID: "stderr", FilterSources: []string{"stderr"}}
+	logstreams := make(map[*Route]chan *Message)
+	cp := &containerPump{
+		container:  &docker.Container{ID: "8dfafdbc3a40e88e750c4fa10e5cf4d1a6b322a4"},
+		logstreams: make(map[chan *Message]*Route),
+	}
+	for _, route := range []*Route{syslog, http, stderr} {
+		logstreams[route] = make(chan *Message, 1)
+		cp.add(logstreams[route], route)
+	}
+
+	receipts := Receipts.Subscribe()
+	defer Receipts.Unsubscribe(receipts)
+	cp.send(&Message{Container: cp.container, Source: "stdout", Data: "hello", Time: time.Now()})
+	message := <-logstreams[syslog]
+	if message.Fanout == nil || len(logstreams[stderr]) != 0 {
+		t.Fatalf("expected a tagged message for the matching routes only, got %+v", message)
+	}
+	matched := append([]string(nil), message.Fanout.Matched...)
+	if len(matched) != 2 || !(reflect.DeepEqual(matched, []string{"syslog", "http"}) || reflect.DeepEqual(matched, []string{"http", "syslog"})) {
+		t.Errorf("expected the syslog and http routes matched got %v", matched)
+	}
+
+	// the http route's processors drop its copy
+	Receipts.Report(syslog, message.Copy(), nil)
+	delivered := <-receipts
+	if delivered.Message != message.Fanout.ID || delivered.Status != StatusDelivered || delivered.Route != "syslog" {
+		t.Errorf("unexpected receipt %+v", delivered)
+	}
+	Fanouts.expire(time.Now())
+	select {
+	case receipt := <-receipts:
+		t.Errorf("expected no receipt before the timeout got %+v", receipt)
+	default:
+	}
+	Fanouts.expire(time.Now().Add(time.Minute))
+	dropped := <-receipts
+	if dropped.Message != message.Fanout.ID || dropped.Status != StatusDropped || dropped.Route != "http" || dropped.Container != "8dfafdbc3a40" {
+		t.Errorf("unexpected receipt %+v", dropped)
+	}
+	select {
+	case receipt := <-receipts:
+		t.Errorf("expected a single dropped receipt got %+v", receipt)
+	default:
+	}
+}
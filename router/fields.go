@@ -0,0 +1,52 @@
+package router
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// StaticField is a key and value a route adds to the fields of its messages
+type StaticField struct {
+	Key   string
+	Value string
+}
+
+// StaticFields returns the fields route adds to its messages, set with
+// add_field options like env:production, falling back to ADD_FIELDS. Several
+// are separated by commas, or given as repeated add_field options.
+func StaticFields(route *Route) ([]StaticField, error) {
+	value := RouteOpt(route, "add_field", "ADD_FIELDS", "")
+	var fields []StaticField
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" || strings.IndexFunc(key, unicode.IsSpace) >= 0 {
+			return nil, errors.New("invalid value for add_field (must be key:value pairs like env:production): " + pair)
+		}
+		fields = append(fields, StaticField{key, parts[1]})
+	}
+	return fields, nil
+}
+
+// addFields passes messages from logstream to out with the route's static
+// fields added, keeping fields the messages already have. It closes out once
+// logstream is closed.
+func addFields(fields []StaticField, logstream, out chan *Message) {
+	for message := range logstream {
+		message = message.Copy()
+		if message.Fields == nil {
+			message.Fields = make(map[string]string, len(fields))
+		}
+		for _, field := range fields {
+			if _, ok := message.Fields[field.Key]; !ok {
+				message.Fields[field.Key] = field.Value
+			}
+		}
+		out <- message
+	}
+	close(out)
+}
@@ -0,0 +1,45 @@
+package router
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStaticFields(t *testing.T) {
+	route, err := parseRouteURI("syslog://logs:514?add_field=env:production&add_field=dc:us-east-1,url:http://x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, err := StaticFields(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []StaticField{{"env", "production"}, {"dc", "us-east-1"}, {"url", "http://x"}}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected %v got %v", expected, fields)
+	}
+	for _, value := range []string{"env", ":production", "my env:production"} {
+		if _, err := StaticFields(&Route{Options: map[string]string{"add_field": value}}); err == nil {
+			t.Errorf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestAddFields(t *testing.T) {
+	original := &Message{Data: "a", Fields: map[string]string{"env": "staging"}}
+	in, out := make(chan *Message, 2), make(chan *Message, 2)
+	in <- original
+	in <- &Message{Data: "b"}
+	close(in)
+	addFields([]StaticField{{"env", "production"}, {"dc", "us-east-1"}}, in, out)
+	got := []*Message{<-out, <-out}
+	if expected := map[string]string{"env": "staging", "dc": "us-east-1"}; !reflect.DeepEqual(got[0].Fields, expected) {
+		t.Errorf("expected fields of messages kept, got %v", got[0].Fields)
+	}
+	if expected := map[string]string{"env": "production", "dc": "us-east-1"}; !reflect.DeepEqual(got[1].Fields, expected) {
+		t.Errorf("expected %v got %v", expected, got[1].Fields)
+	}
+	if len(original.Fields) != 1 {
+		t.Errorf("expected the shared message unchanged got %v", original.Fields)
+	}
+}
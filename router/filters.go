@@ -0,0 +1,148 @@
+package router
+
+import (
+	"errors"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+var (
+	filterRegexpsMu sync.Mutex
+	filterRegexps   = make(map[string]*regexp.Regexp)
+)
+
+// filterRegexp compiles the regexp of a ~ pattern once, as patterns are
+// matched against every container attached to
+func filterRegexp(expr string) (*regexp.Regexp, error) {
+	filterRegexpsMu.Lock()
+	defer filterRegexpsMu.Unlock()
+	if re, ok := filterRegexps[expr]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	filterRegexps[expr] = re
+	return re, nil
+}
+
+// matchPattern returns whether value matches pattern, a glob like *_db, or a
+// regexp after ~ like ~^web-[0-9]+$
+func matchPattern(pattern, value string) (bool, error) {
+	if strings.HasPrefix(pattern, "~") {
+		re, err := filterRegexp(pattern[1:])
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(value), nil
+	}
+	return path.Match(pattern, value)
+}
+
+// matchAny returns whether any of values matches pattern
+func matchAny(pattern string, values ...string) bool {
+	for _, value := range values {
+		if match, err := matchPattern(pattern, value); err == nil && match {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPatterns returns whether values are allowed by patterns: matching
+// none of those excluding values after !, and any of the others, unless all
+// of them exclude
+func matchPatterns(patterns []string, values ...string) bool {
+	allowing, allowed := false, false
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			if matchAny(pattern[1:], values...) {
+				return false
+			}
+			continue
+		}
+		allowing = true
+		allowed = allowed || matchAny(pattern, values...)
+	}
+	return allowed || !allowing
+}
+
+// validPatterns returns an error for the first of patterns that isn't a
+// valid glob or regexp
+func validPatterns(option string, patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := matchPattern(strings.TrimPrefix(pattern, "!"), ""); err != nil {
+			return errors.New("invalid value for " + option + ": " + pattern + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// validFilters returns an error if the name or image filters of route
+// aren't valid patterns
+func validFilters(route *Route) error {
+	if route.FilterName != "" {
+		if err := validPatterns("filter.name", []string{route.FilterName}); err != nil {
+			return err
+		}
+	}
+	return validPatterns("filter.images", route.FilterImages)
+}
+
+// imageNames returns the image a container was created from, as given, and
+// without its tag or digest, so patterns like ghcr.io/acme/app match any of
+// its versions
+func imageNames(image string) []string {
+	name := image
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	if name == image {
+		return []string{image}
+	}
+	return []string{image, name}
+}
+
+// excludePatterns returns the comma separated patterns of env
+func excludePatterns(env string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(getopt(env, ""), ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// validExcludes returns an error if EXCLUDE_IMAGES or EXCLUDE_NAMES have a
+// pattern that is neither a valid glob nor regexp
+func validExcludes() error {
+	if err := validPatterns("EXCLUDE_IMAGES", excludePatterns("EXCLUDE_IMAGES")); err != nil {
+		return err
+	}
+	return validPatterns("EXCLUDE_NAMES", excludePatterns("EXCLUDE_NAMES"))
+}
+
+// excluded returns whether container's image matches EXCLUDE_IMAGES or its
+// name EXCLUDE_NAMES, keeping its logs from every route
+func excluded(container *docker.Container) bool {
+	for _, pattern := range excludePatterns("EXCLUDE_IMAGES") {
+		if container.Config != nil && matchAny(pattern, imageNames(container.Config.Image)...) {
+			return true
+		}
+	}
+	for _, pattern := range excludePatterns("EXCLUDE_NAMES") {
+		if matchAny(pattern, strings.TrimPrefix(container.Name, "/")) {
+			return true
+		}
+	}
+	return false
+}
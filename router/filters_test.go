@@ -0,0 +1,79 @@
+package router
+
+import (
+	"os"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestRouteMatchImage(t *testing.T) {
+	for _, test := range []struct {
+		images []string
+		image  string
+		match  bool
+	}{
+		{nil, "nginx:1.25", true},
+		{[]string{"ghcr.io/acme/*"}, "ghcr.io/acme/api:1.2", true},
+		{[]string{"ghcr.io/acme/*"}, "docker.io/library/nginx", false},
+		{[]string{"nginx"}, "nginx:1.25", true},
+		{[]string{"nginx"}, "nginx@sha256:0123", true},
+		{[]string{"localhost:5000/app"}, "localhost:5000/app", true},
+		{[]string{"~^ghcr\\.io/(acme|corp)/"}, "ghcr.io/corp/web/frontend:2", true},
+		{[]string{"!*/pause*", "!istio/proxyv2"}, "k8s.gcr.io/pause:3.9", false},
+		{[]string{"!*/pause*", "!istio/proxyv2"}, "istio/proxyv2:1.20", false},
+		{[]string{"!*/pause*", "!istio/proxyv2"}, "acme/api", true},
+		{[]string{"acme/*", "!acme/debug"}, "acme/debug:latest", false},
+	} {
+		route := &Route{FilterImages: test.images}
+		if match := route.MatchImage(test.image); match != test.match {
+			t.Errorf("expected %v matching %q with %v got %v", test.match, test.image, test.images, match)
+		}
+	}
+}
+
+func TestRouteMatchContainerNamePatterns(t *testing.T) {
+	for _, test := range []struct {
+		filter string
+		name   string
+		match  bool
+	}{
+		{"*_db", "app_db", true},
+		{"~^web-[0-9]+$", "web-12", true},
+		{"~^web-[0-9]+$", "web-a", false},
+		{"!istio-*", "istio-proxy", false},
+		{"!istio-*", "app", true},
+	} {
+		route := &Route{FilterName: test.filter}
+		if match := route.MatchContainer("", test.name, nil); match != test.match {
+			t.Errorf("expected %v matching %q with %q got %v", test.match, test.name, test.filter, match)
+		}
+	}
+	if err := validFilters(&Route{FilterName: "~web-("}); err == nil {
+		t.Error("expected an error for an invalid filter.name regexp")
+	}
+	if err := validFilters(&Route{FilterImages: []string{"!acme/[a-"}}); err == nil {
+		t.Error("expected an error for an invalid filter.images glob")
+	}
+}
+
+func TestExcludedContainers(t *testing.T) {
+	os.Setenv("EXCLUDE_IMAGES", "*/pause,istio/proxyv2")
+	os.Setenv("EXCLUDE_NAMES", "~^k8s_POD_")
+	defer os.Unsetenv("EXCLUDE_IMAGES")
+	defer os.Unsetenv("EXCLUDE_NAMES")
+	for _, test := range []struct {
+		name, image string
+		ignored     bool
+	}{
+		{"/app", "k8s.gcr.io/pause:3.9", true},
+		{"/sidecar", "istio/proxyv2:1.20", true},
+		{"/k8s_POD_web", "acme/web", true},
+		{"/app", "acme/app", false},
+	} {
+		container := &docker.Container{Name: test.name, Config: &docker.Config{Image: test.image}}
+		if ignored := ignoreContainer(container); ignored != test.ignored {
+			t.Errorf("expected %v ignoring %s from %s got %v", test.ignored, test.name, test.image, ignored)
+		}
+	}
+}
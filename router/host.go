@@ -0,0 +1,310 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// metadata endpoints of the instance identity of cloud providers
+var (
+	awsMetadataURL   = "http://169.254.169.254"
+	gcpMetadataURL   = "http://metadata.google.internal"
+	azureMetadataURL = "http://169.254.169.254"
+)
+
+// HostMetadata describes the host logspout runs on, for templates as
+// {{.Host.InstanceID}}, {{.Host.Zone}} or {{index .Host.Labels "env"}}
+type HostMetadata struct {
+	// Provider is aws, gcp or azure when the cloud instance identity is known
+	Provider     string `json:"provider,omitempty"`
+	InstanceID   string `json:"instance_id,omitempty"`
+	InstanceType string `json:"instance_type,omitempty"`
+	Region       string `json:"region,omitempty"`
+	Zone         string `json:"zone,omitempty"`
+	// Account is the AWS account, GCP project or Azure subscription
+	Account string `json:"account,omitempty"`
+	// Name and Labels are the name and labels of the Docker node
+	Name   string            `json:"name,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// HostProvider fetches the metadata of the host from the sources listed in
+// HOST_METADATA at startup, and again every HOST_METADATA_REFRESH
+type HostProvider struct {
+	mu      sync.RWMutex
+	host    *HostMetadata
+	sources []string
+	refresh time.Duration
+	client  *http.Client
+}
+
+// Hosts provides the host metadata of messages
+var Hosts = &HostProvider{host: new(HostMetadata)}
+
+func init() {
+	Jobs.Register(Hosts, "hostmeta")
+}
+
+// Host returns the metadata of the host the message was read on
+func (m *Message) Host() *HostMetadata {
+	return Hosts.Get()
+}
+
+// Get returns the last fetched host metadata
+func (hp *HostProvider) Get() *HostMetadata {
+	hp.mu.RLock()
+	defer hp.mu.RUnlock()
+	return hp.host
+}
+
+// Name returns the name of the job, empty unless HOST_METADATA is set
+func (hp *HostProvider) Name() string {
+	if len(hp.sources) == 0 {
+		return ""
+	}
+	return "hostmeta"
+}
+
+// Setup fetches the host metadata from the comma separated sources in
+// HOST_METADATA: aws, gcp or azure for the cloud instance identity, auto
+// for the first of them that answers, and docker for the node's labels
+func (hp *HostProvider) Setup() error {
+	value := getopt("HOST_METADATA", "")
+	if value == "" {
+		return nil
+	}
+	var sources []string
+	for _, source := range strings.Split(value, ",") {
+		switch source = strings.TrimSpace(source); source {
+		case "auto", "aws", "gcp", "azure", "docker":
+			sources = append(sources, source)
+		default:
+			return errors.New("invalid value for HOST_METADATA (must be auto, aws, gcp, azure or docker): " + source)
+		}
+	}
+	timeout, err := time.ParseDuration(getopt("HOST_METADATA_TIMEOUT", "2s"))
+	if err != nil || timeout <= 0 {
+		return errors.New("invalid value for HOST_METADATA_TIMEOUT: " + getopt("HOST_METADATA_TIMEOUT", ""))
+	}
+	refresh, err := time.ParseDuration(getopt("HOST_METADATA_REFRESH", "0"))
+	if err != nil || refresh < 0 {
+		return errors.New("invalid value for HOST_METADATA_REFRESH: " + getopt("HOST_METADATA_REFRESH", ""))
+	}
+	hp.sources, hp.refresh = sources, refresh
+	hp.client = &http.Client{Timeout: timeout}
+	hp.update()
+	return nil
+}
+
+// Run fetches the host metadata again every HOST_METADATA_REFRESH
+func (hp *HostProvider) Run() error {
+	if len(hp.sources) == 0 || hp.refresh == 0 {
+		select {}
+	}
+	for range time.Tick(hp.refresh) {
+		hp.update()
+	}
+	return nil
+}
+
+// update replaces the host metadata with a fresh fetch, keeping what failed
+// to be fetched from the last one
+func (hp *HostProvider) update() {
+	host := *hp.Get()
+	for _, source := range hp.sources {
+		var err error
+		switch source {
+		case "auto":
+			err = hp.fetchCloud(&host, "aws", "gcp", "azure")
+		case "docker":
+			err = dockerHost(&host)
+		default:
+			err = hp.fetchCloud(&host, source)
+		}
+		if err != nil {
+			NewLogger("hostmeta").Warn("fetching host metadata failed", "source", source, "error", err)
+		}
+	}
+	hp.mu.Lock()
+	hp.host = &host
+	hp.mu.Unlock()
+}
+
+// fetchCloud sets the instance identity from the first of providers whose
+// metadata endpoint answers
+func (hp *HostProvider) fetchCloud(host *HostMetadata, providers ...string) error {
+	var errs []string
+	for _, provider := range providers {
+		var identity *HostMetadata
+		var err error
+		switch provider {
+		case "aws":
+			identity, err = hp.awsIdentity()
+		case "gcp":
+			identity, err = hp.gcpIdentity()
+		case "azure":
+			identity, err = hp.azureIdentity()
+		}
+		if err == nil {
+			identity.Name, identity.Labels = host.Name, host.Labels
+			*host = *identity
+			return nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", provider, err))
+	}
+	return errors.New("no instance identity: " + strings.Join(errs, ", "))
+}
+
+// get returns the body of a metadata request with headers
+func (hp *HostProvider) get(method, url string, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := hp.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return body, nil
+}
+
+// awsIdentity reads the EC2 instance identity document with an IMDSv2 token
+func (hp *HostProvider) awsIdentity() (*HostMetadata, error) {
+	token, err := hp.get("PUT", awsMetadataURL+"/latest/api/token",
+		map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "300"})
+	if err != nil {
+		return nil, err
+	}
+	body, err := hp.get("GET", awsMetadataURL+"/latest/dynamic/instance-identity/document",
+		map[string]string{"X-aws-ec2-metadata-token": string(token)})
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		InstanceID       string `json:"instanceId"`
+		InstanceType     string `json:"instanceType"`
+		Region           string `json:"region"`
+		AvailabilityZone string `json:"availabilityZone"`
+		AccountID        string `json:"accountId"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	return &HostMetadata{
+		Provider:     "aws",
+		InstanceID:   doc.InstanceID,
+		InstanceType: doc.InstanceType,
+		Region:       doc.Region,
+		Zone:         doc.AvailabilityZone,
+		Account:      doc.AccountID,
+	}, nil
+}
+
+// gcpIdentity reads the GCE instance metadata, honouring GCE_METADATA_HOST
+func (hp *HostProvider) gcpIdentity() (*HostMetadata, error) {
+	base := gcpMetadataURL
+	if host := os.Getenv("GCE_METADATA_HOST"); host != "" {
+		base = "http://" + host
+	}
+	headers := map[string]string{"Metadata-Flavor": "Google"}
+	body, err := hp.get("GET", base+"/computeMetadata/v1/instance/?recursive=true", headers)
+	if err != nil {
+		return nil, err
+	}
+	var instance struct {
+		ID          json.Number `json:"id"`
+		Zone        string      `json:"zone"`
+		MachineType string      `json:"machineType"`
+	}
+	if err := json.Unmarshal(body, &instance); err != nil {
+		return nil, err
+	}
+	project, err := hp.get("GET", base+"/computeMetadata/v1/project/project-id", headers)
+	if err != nil {
+		return nil, err
+	}
+	// zone and machine type are paths like projects/123/zones/us-central1-a
+	zone := instance.Zone[strings.LastIndex(instance.Zone, "/")+1:]
+	region := zone
+	if i := strings.LastIndex(zone, "-"); i >= 0 {
+		region = zone[:i]
+	}
+	return &HostMetadata{
+		Provider:     "gcp",
+		InstanceID:   instance.ID.String(),
+		InstanceType: instance.MachineType[strings.LastIndex(instance.MachineType, "/")+1:],
+		Region:       region,
+		Zone:         zone,
+		Account:      string(project),
+	}, nil
+}
+
+// azureIdentity reads the compute metadata of the Azure Instance Metadata Service
+func (hp *HostProvider) azureIdentity() (*HostMetadata, error) {
+	body, err := hp.get("GET", azureMetadataURL+"/metadata/instance/compute?api-version=2021-02-01",
+		map[string]string{"Metadata": "true"})
+	if err != nil {
+		return nil, err
+	}
+	var compute struct {
+		VMID           string `json:"vmId"`
+		VMSize         string `json:"vmSize"`
+		Location       string `json:"location"`
+		Zone           string `json:"zone"`
+		SubscriptionID string `json:"subscriptionId"`
+	}
+	if err := json.Unmarshal(body, &compute); err != nil {
+		return nil, err
+	}
+	return &HostMetadata{
+		Provider:     "azure",
+		InstanceID:   compute.VMID,
+		InstanceType: compute.VMSize,
+		Region:       compute.Location,
+		Zone:         compute.Zone,
+		Account:      compute.SubscriptionID,
+	}, nil
+}
+
+// dockerHost sets the name and labels of the Docker node
+func dockerHost(host *HostMetadata) error {
+	client, err := docker.NewClientFromEnv()
+	if err != nil {
+		return err
+	}
+	info, err := client.Info()
+	if err != nil {
+		return fmt.Errorf("docker: %s", err)
+	}
+	host.Name = info.Name
+	host.Labels = make(map[string]string, len(info.Labels))
+	for _, label := range info.Labels {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) == 2 {
+			host.Labels[parts[0]] = parts[1]
+		} else {
+			host.Labels[parts[0]] = ""
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package router
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"text/template"
+)
+
+func TestHostMetadata(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("token"))
+	})
+	mux.HandleFunc("/latest/dynamic/instance-identity/document", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-aws-ec2-metadata-token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"instanceId":"i-0abc","instanceType":"m5.large","region":"us-east-1","availabilityZone":"us-east-1b","accountId":"123456789012"}`))
+	})
+	mux.HandleFunc("/computeMetadata/v1/instance/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"id":8174853047593878806,"zone":"projects/123/zones/europe-west1-c","machineType":"projects/123/machineTypes/e2-medium"}`))
+	})
+	mux.HandleFunc("/computeMetadata/v1/project/project-id", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("my-project"))
+	})
+	mux.HandleFunc("/metadata/instance/compute", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"vmId":"02aab8a4","vmSize":"Standard_D2s_v3","location":"westeurope","zone":"2","subscriptionId":"8d10da13"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	saved := []string{awsMetadataURL, gcpMetadataURL, azureMetadataURL}
+	defer func() { awsMetadataURL, gcpMetadataURL, azureMetadataURL = saved[0], saved[1], saved[2] }()
+	awsMetadataURL, gcpMetadataURL, azureMetadataURL = server.URL, server.URL, server.URL
+
+	for source, expected := range map[string]HostMetadata{
+		"aws":   {Provider: "aws", InstanceID: "i-0abc", InstanceType: "m5.large", Region: "us-east-1", Zone: "us-east-1b", Account: "123456789012"},
+		"gcp":   {Provider: "gcp", InstanceID: "8174853047593878806", InstanceType: "e2-medium", Region: "europe-west1", Zone: "europe-west1-c", Account: "my-project"},
+		"azure": {Provider: "azure", InstanceID: "02aab8a4", InstanceType: "Standard_D2s_v3", Region: "westeurope", Zone: "2", Account: "8d10da13"},
+	} {
+		os.Setenv("HOST_METADATA", source)
+		hp := &HostProvider{host: new(HostMetadata)}
+		if err := hp.Setup(); err != nil {
+			t.Fatal(err)
+		}
+		if host := *hp.Get(); host.Provider != expected.Provider || host.InstanceID != expected.InstanceID ||
+			host.InstanceType != expected.InstanceType || host.Region != expected.Region ||
+			host.Zone != expected.Zone || host.Account != expected.Account {
+			t.Errorf("%s: expected %+v got %+v", source, expected, host)
+		}
+	}
+
+	// auto uses the first provider that answers
+	awsMetadataURL = "http://127.0.0.1:1"
+	os.Setenv("HOST_METADATA", "auto")
+	defer os.Unsetenv("HOST_METADATA")
+	hp := &HostProvider{host: new(HostMetadata)}
+	if err := hp.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if hp.Get().Provider != "gcp" || hp.Name() != "hostmeta" {
+		t.Errorf("expected the gcp identity got %+v", hp.Get())
+	}
+}
+
+func TestHostTemplate(t *testing.T) {
+	saved := Hosts.Get()
+	defer func() { Hosts.host = saved }()
+	Hosts.host = &HostMetadata{InstanceID: "i-0abc", Zone: "us-east-1b", Labels: map[string]string{"env": "prod"}}
+	tmpl := template.Must(template.New("").Parse(`{{.Host.InstanceID}} {{.Host.Zone}} {{index .Host.Labels "env"}} {{.Data}}`))
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, &Message{Data: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "i-0abc us-east-1b prod hello" {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+func TestHostMetadataInvalid(t *testing.T) {
+	defer os.Unsetenv("HOST_METADATA")
+	os.Setenv("HOST_METADATA", "aws,openstack")
+	if err := (&HostProvider{host: new(HostMetadata)}).Setup(); err == nil {
+		t.Error("expected an error for an unknown source")
+	}
+	os.Unsetenv("HOST_METADATA")
+	hp := &HostProvider{host: new(HostMetadata)}
+	if err := hp.Setup(); err != nil || hp.Name() != "" {
+		t.Errorf("expected host metadata disabled by default got %v", err)
+	}
+}
@@ -0,0 +1,298 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostnameSources are the names of the Docker host each source of
+// HOSTNAME_SOURCES found, for templates as {{.Hostnames.Metadata}}
+type HostnameSources struct {
+	// Env is the value of the variable named by HOSTNAME_ENV, e.g. the node
+	// name a Kubernetes DaemonSet sets from spec.nodeName
+	Env string `json:"env,omitempty"`
+	// File is the first line of HOSTNAME_FILE, e.g. the host's
+	// /etc/hostname mounted at /etc/host_hostname
+	File string `json:"file,omitempty"`
+	// Metadata is the hostname of the instance from the metadata service of
+	// its cloud provider
+	Metadata string `json:"metadata,omitempty"`
+	// DNS is the name the host's address resolves to
+	DNS string `json:"dns,omitempty"`
+	// OS is the hostname of logspout's container
+	OS string `json:"os,omitempty"`
+}
+
+// source returns the name the source found
+func (s *HostnameSources) source(source string) string {
+	switch source {
+	case "env":
+		return s.Env
+	case "file":
+		return s.File
+	case "metadata":
+		return s.Metadata
+	case "dns":
+		return s.DNS
+	case "os":
+		return s.OS
+	}
+	return ""
+}
+
+// keep sets the names sources didn't find to those of last
+func (s *HostnameSources) keep(last *HostnameSources) {
+	if s.Env == "" {
+		s.Env = last.Env
+	}
+	if s.File == "" {
+		s.File = last.File
+	}
+	if s.Metadata == "" {
+		s.Metadata = last.Metadata
+	}
+	if s.DNS == "" {
+		s.DNS = last.DNS
+	}
+	if s.OS == "" {
+		s.OS = last.OS
+	}
+}
+
+// HostnameResolver finds the name of the Docker host with the first source
+// of the chain in HOSTNAME_SOURCES that has one. Names are cached once
+// resolved, and resolved again every HOSTNAME_REFRESH.
+type HostnameResolver struct {
+	mu      sync.Mutex
+	name    string
+	found   *HostnameSources
+	chain   []string
+	timeout time.Duration
+	refresh time.Duration
+}
+
+// Hostnames resolves the name of the Docker host for adapters and templates
+var Hostnames = &HostnameResolver{}
+
+func init() {
+	Jobs.Register(Hostnames, "hostname")
+}
+
+// Hostname returns the name of the Docker host the message was read on
+func (m *Message) Hostname() string {
+	return Hostnames.Get()
+}
+
+// Hostnames returns the names of the Docker host each source found
+func (m *Message) Hostnames() *HostnameSources {
+	return Hostnames.Sources()
+}
+
+// Get returns the name of the first source of the chain that found one, or
+// "" if none did, resolving the chain on first use
+func (hr *HostnameResolver) Get() string {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	hr.cached()
+	return hr.name
+}
+
+// Sources returns the names each source of the chain found
+func (hr *HostnameResolver) Sources() *HostnameSources {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	return hr.cached()
+}
+
+// cached returns the names resolved last, resolving them if there are none.
+// Adapters may ask before Setup ran, so it reads the configuration itself.
+func (hr *HostnameResolver) cached() *HostnameSources {
+	if hr.found == nil {
+		if hr.chain == nil {
+			hr.chain, hr.timeout, _, _ = hostnameConfig()
+		}
+		hr.name, hr.found = resolveHostname(hr.chain, hr.timeout)
+	}
+	return hr.found
+}
+
+// Name returns the name of the job, empty unless HOSTNAME_REFRESH is set
+func (hr *HostnameResolver) Name() string {
+	if hr.refresh == 0 {
+		return ""
+	}
+	return "hostname"
+}
+
+// Setup reads the chain of sources in HOSTNAME_SOURCES and resolves it
+func (hr *HostnameResolver) Setup() error {
+	chain, timeout, refresh, err := hostnameConfig()
+	if err != nil {
+		return err
+	}
+	name, found := resolveHostname(chain, timeout)
+	hr.mu.Lock()
+	hr.chain, hr.timeout, hr.refresh = chain, timeout, refresh
+	hr.name, hr.found = name, found
+	hr.mu.Unlock()
+	return nil
+}
+
+// Run resolves the chain again every HOSTNAME_REFRESH, keeping the names
+// of sources that fail
+func (hr *HostnameResolver) Run() error {
+	if hr.refresh == 0 {
+		select {}
+	}
+	for range time.Tick(hr.refresh) {
+		_, found := resolveHostname(hr.chain, hr.timeout)
+		hr.mu.Lock()
+		found.keep(hr.found)
+		hr.name, hr.found = firstHostname(hr.chain, found), found
+		hr.mu.Unlock()
+	}
+	return nil
+}
+
+// hostnameConfig returns the chain of sources of HOSTNAME_SOURCES, the
+// timeout of metadata requests and DNS lookups, and the refresh interval
+func hostnameConfig() ([]string, time.Duration, time.Duration, error) {
+	var chain []string
+	for _, source := range strings.Split(getopt("HOSTNAME_SOURCES", "file"), ",") {
+		switch source = strings.TrimSpace(source); source {
+		case "env", "file", "metadata", "dns", "os":
+			chain = append(chain, source)
+		case "":
+		default:
+			return nil, 0, 0, errors.New("invalid value for HOSTNAME_SOURCES (must be env, file, metadata, dns or os): " + source)
+		}
+	}
+	timeout, err := time.ParseDuration(getopt("HOSTNAME_TIMEOUT", "2s"))
+	if err != nil || timeout <= 0 {
+		return nil, 0, 0, errors.New("invalid value for HOSTNAME_TIMEOUT: " + getopt("HOSTNAME_TIMEOUT", ""))
+	}
+	refresh, err := time.ParseDuration(getopt("HOSTNAME_REFRESH", "0"))
+	if err != nil || refresh < 0 {
+		return nil, 0, 0, errors.New("invalid value for HOSTNAME_REFRESH: " + getopt("HOSTNAME_REFRESH", ""))
+	}
+	return chain, timeout, refresh, nil
+}
+
+// resolveHostname asks every source of chain for the name of the host,
+// returning the first name found and the names of each source
+func resolveHostname(chain []string, timeout time.Duration) (string, *HostnameSources) {
+	found := new(HostnameSources)
+	for _, source := range chain {
+		var err error
+		switch source {
+		case "env":
+			found.Env = strings.TrimSpace(os.Getenv(getopt("HOSTNAME_ENV", "NODE_NAME")))
+		case "file":
+			found.File, err = fileHostname(getopt("HOSTNAME_FILE", "/etc/host_hostname"))
+		case "metadata":
+			found.Metadata, err = metadataHostname(&HostProvider{client: &http.Client{Timeout: timeout}})
+		case "dns":
+			found.DNS, err = dnsHostname(timeout)
+		case "os":
+			found.OS, err = os.Hostname()
+		}
+		if err != nil {
+			debug("hostname:", source+":", err)
+		}
+	}
+	return firstHostname(chain, found), found
+}
+
+// firstHostname returns the name of the first source of chain with one
+func firstHostname(chain []string, found *HostnameSources) string {
+	for _, source := range chain {
+		if name := found.source(source); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// fileHostname returns the first line of the file at path. A missing file
+// isn't an error, since it's only there when mounted.
+func fileHostname(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.SplitN(string(content), "\n", 2)[0]), nil
+}
+
+// metadataHostname returns the hostname of the instance from the first
+// cloud metadata service that answers
+func metadataHostname(hp *HostProvider) (string, error) {
+	var errs []string
+	if token, err := hp.get("PUT", awsMetadataURL+"/latest/api/token",
+		map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "300"}); err == nil {
+		name, err := hp.get("GET", awsMetadataURL+"/latest/meta-data/local-hostname",
+			map[string]string{"X-aws-ec2-metadata-token": string(token)})
+		if err == nil {
+			return strings.TrimSpace(string(name)), nil
+		}
+		errs = append(errs, "aws: "+err.Error())
+	} else {
+		errs = append(errs, "aws: "+err.Error())
+	}
+	base := gcpMetadataURL
+	if host := os.Getenv("GCE_METADATA_HOST"); host != "" {
+		base = "http://" + host
+	}
+	name, err := hp.get("GET", base+"/computeMetadata/v1/instance/hostname", map[string]string{"Metadata-Flavor": "Google"})
+	if err == nil {
+		return strings.TrimSpace(string(name)), nil
+	}
+	errs = append(errs, "gcp: "+err.Error())
+	name, err = hp.get("GET", azureMetadataURL+"/metadata/instance/compute/name?api-version=2021-02-01&format=text",
+		map[string]string{"Metadata": "true"})
+	if err == nil {
+		return strings.TrimSpace(string(name)), nil
+	}
+	errs = append(errs, "azure: "+err.Error())
+	return "", errors.New("no instance hostname: " + strings.Join(errs, ", "))
+}
+
+// interfaceAddrs returns the addresses of the network interfaces
+var interfaceAddrs = net.InterfaceAddrs
+
+// lookupAddr returns the names an address resolves to
+var lookupAddr = net.DefaultResolver.LookupAddr
+
+// dnsHostname returns the name the first global address of the host's
+// interfaces resolves to, which is the node's with host networking
+func dnsHostname(timeout time.Duration) (string, error) {
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || !ipnet.IP.IsGlobalUnicast() {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		names, err := lookupAddr(ctx, ipnet.IP.String())
+		cancel()
+		if err != nil {
+			return "", err
+		}
+		if len(names) > 0 {
+			return strings.TrimSuffix(names[0], "."), nil
+		}
+	}
+	return "", errors.New("no address resolves to a name")
+}
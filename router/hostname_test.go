@@ -0,0 +1,128 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestHostnameChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostname")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "host_hostname")
+	if err := ioutil.WriteFile(path, []byte("docker-host-1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("HOSTNAME_FILE", path)
+	defer os.Unsetenv("HOSTNAME_FILE")
+	os.Setenv("HOSTNAME_ENV", "TEST_NODE_NAME")
+	defer os.Unsetenv("HOSTNAME_ENV")
+	defer os.Unsetenv("HOSTNAME_SOURCES")
+
+	for _, sources := range []string{"env,file", "file,env", "env, file"} {
+		os.Setenv("HOSTNAME_SOURCES", sources)
+		hr := &HostnameResolver{}
+		if err := hr.Setup(); err != nil {
+			t.Fatal(err)
+		}
+		if name := hr.Get(); name != "docker-host-1" {
+			t.Errorf("%s: expected docker-host-1 got %s", sources, name)
+		}
+	}
+	os.Setenv("HOSTNAME_SOURCES", "nothing,env")
+	if err := (&HostnameResolver{}).Setup(); err == nil {
+		t.Error("expected an unknown source to fail")
+	}
+
+	// a DaemonSet's node name set from the downward API wins
+	os.Setenv("TEST_NODE_NAME", "node-7")
+	defer os.Unsetenv("TEST_NODE_NAME")
+	os.Setenv("HOSTNAME_SOURCES", "env,file")
+	hr := &HostnameResolver{}
+	if err := hr.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if hr.Get() != "node-7" || hr.Sources().File != "docker-host-1" {
+		t.Errorf("expected the node name first and the file's name kept got %s %+v", hr.Get(), hr.Sources())
+	}
+
+	// names are cached until resolved again
+	os.Setenv("TEST_NODE_NAME", "node-8")
+	if hr.Get() != "node-7" {
+		t.Errorf("expected the cached name got %s", hr.Get())
+	}
+}
+
+func TestHostnameResolvedOnFirstUse(t *testing.T) {
+	os.Setenv("HOSTNAME_SOURCES", "env")
+	defer os.Unsetenv("HOSTNAME_SOURCES")
+	os.Setenv("NODE_NAME", "node-1")
+	defer os.Unsetenv("NODE_NAME")
+
+	hr := &HostnameResolver{}
+	if hr.Get() != "node-1" {
+		t.Errorf("expected the chain resolved before Setup got %s", hr.Get())
+	}
+	if hr.Name() != "" {
+		t.Error("expected the job disabled without HOSTNAME_REFRESH")
+	}
+}
+
+func TestHostnameMetadata(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/computeMetadata/v1/instance/hostname", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("gke-pool-1-abcd.c.my-project.internal"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	saved := []string{awsMetadataURL, gcpMetadataURL, azureMetadataURL}
+	defer func() { awsMetadataURL, gcpMetadataURL, azureMetadataURL = saved[0], saved[1], saved[2] }()
+	awsMetadataURL, gcpMetadataURL, azureMetadataURL = server.URL, server.URL, server.URL
+
+	defer func() { interfaceAddrs, lookupAddr = net.InterfaceAddrs, net.DefaultResolver.LookupAddr }()
+	interfaceAddrs = func() ([]net.Addr, error) {
+		return []net.Addr{
+			&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+			&net.IPNet{IP: net.ParseIP("10.0.3.7"), Mask: net.CIDRMask(24, 32)},
+		}, nil
+	}
+	lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		if addr != "10.0.3.7" {
+			return nil, errors.New("no such host")
+		}
+		return []string{"node-3.example.com."}, nil
+	}
+
+	name, found := resolveHostname([]string{"metadata", "dns"}, time.Second)
+	if name != "gke-pool-1-abcd.c.my-project.internal" || found.DNS != "node-3.example.com" {
+		t.Errorf("expected the metadata hostname first got %s %+v", name, found)
+	}
+
+	tmpl := template.Must(template.New("").Parse("{{.Hostnames.DNS}}"))
+	resolver := Hostnames
+	defer func() { Hostnames = resolver }()
+	Hostnames = &HostnameResolver{name: name, found: found}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, new(Message)); err != nil || buf.String() != "node-3.example.com" {
+		t.Errorf("expected the DNS name in templates got %q %v", buf.String(), err)
+	}
+}
@@ -0,0 +1,147 @@
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	fallbackLogs     = "logs"
+	fallbackJournald = "journald"
+)
+
+// logDriverFallback returns whether mode is listed in LOG_DRIVER_FALLBACK
+func logDriverFallback(mode string) bool {
+	for _, m := range strings.Split(getopt("LOG_DRIVER_FALLBACK", ""), ",") {
+		if strings.TrimSpace(m) == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// journalctlFound fails when LOG_DRIVER_FALLBACK allows journald but the
+// journalctl binary, which the alpine image lacks, can't be found
+func journalctlFound() error {
+	if !logDriverFallback(fallbackJournald) {
+		return nil
+	}
+	journalctl := getopt("JOURNALCTL", "journalctl")
+	if _, err := exec.LookPath(journalctl); err != nil {
+		return errors.New("LOG_DRIVER_FALLBACK=journald needs journalctl, set JOURNALCTL or install it in the image: " + err.Error())
+	}
+	return nil
+}
+
+// readingUnsupported returns whether err is the daemon refusing to read logs
+// for a container's log driver
+func readingUnsupported(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "does not support reading")
+}
+
+// journalEntry holds the fields of a journalctl --output=json entry written
+// by the journald log driver
+type journalEntry struct {
+	Message  json.RawMessage `json:"MESSAGE"`
+	Priority string          `json:"PRIORITY"`
+	Partial  string          `json:"CONTAINER_PARTIAL_MESSAGE"`
+	Realtime string          `json:"__REALTIME_TIMESTAMP"`
+}
+
+// text returns the entry's message, which journalctl encodes as an array of
+// bytes when it isn't valid UTF-8
+func (e *journalEntry) text() string {
+	var s string
+	if err := json.Unmarshal(e.Message, &s); err == nil {
+		return s
+	}
+	var b []byte
+	var ints []int
+	if err := json.Unmarshal(e.Message, &ints); err == nil {
+		for _, i := range ints {
+			b = append(b, byte(i))
+		}
+	}
+	return string(b)
+}
+
+func journalArgs(id, tail string, since time.Time) []string {
+	args := []string{
+		"--follow",
+		"--all",
+		"--output=json",
+		"--lines=" + tail,
+	}
+	if since.Unix() > 0 {
+		args = append(args, "--since=@"+strconv.FormatInt(since.Unix(), 10))
+	}
+	return append(args, "CONTAINER_ID_FULL="+id)
+}
+
+// journalLogs follows the journal entries of a container using the journald
+// log driver, writing them to stdout and stderr like the Docker logs API.
+// It returns when stop is closed or journalctl exits. With timestamps, lines
+// are prefixed with their time as the Docker logs API does.
+func journalLogs(id, tail string, since time.Time, stdout, stderr io.Writer, stop <-chan struct{}, timestamps bool) error {
+	cmd := exec.Command(getopt("JOURNALCTL", "journalctl"), journalArgs(id, tail, since)...)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		<-stop
+		cmd.Process.Kill()
+	}()
+	err = copyJournal(out, stdout, stderr, timestamps)
+	if waitErr := cmd.Wait(); err == nil {
+		select {
+		case <-stop:
+		default:
+			err = waitErr
+		}
+	}
+	return err
+}
+
+// copyJournal writes the messages of journal entries read from r to stdout,
+// or stderr for entries logged at error priority
+func copyJournal(r io.Reader, stdout, stderr io.Writer, timestamps bool) error {
+	// whether the last entry written to each stream was a partial message
+	partial := make(map[io.Writer]bool)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		entry := new(journalEntry)
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			debug("pump.journalLogs(): skipping entry:", err)
+			continue
+		}
+		w := stdout
+		if entry.Priority == "3" {
+			w = stderr
+		}
+		line := entry.text()
+		if timestamps && !partial[w] {
+			if usec, err := strconv.ParseInt(entry.Realtime, 10, 64); err == nil {
+				line = time.Unix(0, usec*int64(time.Microsecond)).UTC().Format(time.RFC3339Nano) + " " + line
+			}
+		}
+		partial[w] = entry.Partial == "true"
+		if !partial[w] {
+			line += "\n"
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
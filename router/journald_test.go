@@ -0,0 +1,93 @@
+package router
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopyJournal(t *testing.T) {
+	journal := strings.Join([]string{
+		`{"MESSAGE":"hello","PRIORITY":"6","CONTAINER_ID_FULL":"abc"}`,
+		`{"MESSAGE":"oops","PRIORITY":"3"}`,
+		`not json`,
+		`{"MESSAGE":[104,105],"PRIORITY":"6"}`,
+		`{"MESSAGE":"part","PRIORITY":"6","CONTAINER_PARTIAL_MESSAGE":"true"}`,
+		`{"MESSAGE":"ial","PRIORITY":"6"}`,
+	}, "\n")
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	if err := copyJournal(strings.NewReader(journal), stdout, stderr, false); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "hello\nhi\npartial\n"; stdout.String() != expected {
+		t.Errorf("expected stdout %q got %q", expected, stdout.String())
+	}
+	if expected := "oops\n"; stderr.String() != expected {
+		t.Errorf("expected stderr %q got %q", expected, stderr.String())
+	}
+}
+
+func TestCopyJournalTimestamps(t *testing.T) {
+	journal := strings.Join([]string{
+		`{"MESSAGE":"hello","PRIORITY":"6","__REALTIME_TIMESTAMP":"1520240887000001"}`,
+		`{"MESSAGE":"part","PRIORITY":"6","CONTAINER_PARTIAL_MESSAGE":"true","__REALTIME_TIMESTAMP":"1520240888000000"}`,
+		`{"MESSAGE":"ial","PRIORITY":"6","__REALTIME_TIMESTAMP":"1520240888000002"}`,
+	}, "\n")
+	stdout := new(bytes.Buffer)
+	if err := copyJournal(strings.NewReader(journal), stdout, new(bytes.Buffer), true); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "2018-03-05T09:08:07.000001Z hello\n2018-03-05T09:08:08Z partial\n"; stdout.String() != expected {
+		t.Errorf("expected stdout %q got %q", expected, stdout.String())
+	}
+}
+
+func TestJournalArgs(t *testing.T) {
+	args := journalArgs("abc", "10", time.Unix(1500000000, 0))
+	expected := []string{"--follow", "--all", "--output=json", "--lines=10", "--since=@1500000000", "CONTAINER_ID_FULL=abc"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %v got %v", expected, args)
+	}
+	args = journalArgs("abc", "all", time.Unix(0, 0))
+	expected = []string{"--follow", "--all", "--output=json", "--lines=all", "CONTAINER_ID_FULL=abc"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %v got %v", expected, args)
+	}
+}
+
+func TestLogDriverFallback(t *testing.T) {
+	os.Setenv("LOG_DRIVER_FALLBACK", "logs, journald")
+	defer os.Unsetenv("LOG_DRIVER_FALLBACK")
+	if !logDriverFallback(fallbackLogs) || !logDriverFallback(fallbackJournald) {
+		t.Error("expected logs and journald fallbacks to be enabled")
+	}
+	os.Setenv("LOG_DRIVER_FALLBACK", "")
+	if logDriverFallback(fallbackLogs) {
+		t.Error("expected logs fallback to be disabled")
+	}
+	if !readingUnsupported(errors.New(`API error (501): configured logging driver does not support reading`)) {
+		t.Error("expected unsupported reading error to be detected")
+	}
+}
+
+func TestJournalctlFound(t *testing.T) {
+	defer os.Unsetenv("LOG_DRIVER_FALLBACK")
+	defer os.Unsetenv("JOURNALCTL")
+	os.Setenv("JOURNALCTL", "/nonexistent/journalctl")
+	if err := journalctlFound(); err != nil {
+		t.Errorf("expected journalctl not needed without the fallback got %v", err)
+	}
+	os.Setenv("LOG_DRIVER_FALLBACK", "logs,journald")
+	if err := journalctlFound(); err == nil || !strings.Contains(err.Error(), "needs journalctl") {
+		t.Errorf("expected a missing journalctl to fail got %v", err)
+	}
+	os.Setenv("JOURNALCTL", "sh")
+	if err := journalctlFound(); err != nil {
+		t.Errorf("expected journalctl found on the path got %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+var latencyLog = NewLogger("latency")
+
+// latencyBuckets are the upper bounds of histogram buckets, doubling from
+// 1ms to about a minute. Slower writes fall in a final overflow bucket.
+var latencyBuckets = func() []time.Duration {
+	var buckets []time.Duration
+	for d := time.Millisecond; d <= time.Minute; d *= 2 {
+		buckets = append(buckets, d)
+	}
+	return buckets
+}()
+
+// Histogram counts write latencies in exponential buckets
+type Histogram struct {
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+func newHistogram() *Histogram {
+	return &Histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+}
+
+// Observe counts one write taking d
+func (h *Histogram) Observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Quantile returns the upper bound of the bucket holding the q quantile, or
+// the slowest write seen if that's in the overflow bucket
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(h.count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			if i < len(latencyBuckets) && latencyBuckets[i] < h.max {
+				return latencyBuckets[i]
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+// MarshalJSON writes the histogram's count, mean, quantiles and the
+// cumulative count of writes at or below each bucket bound
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	buckets := make(map[string]uint64, len(latencyBuckets))
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		cumulative += h.counts[i]
+		buckets[bound.String()] = cumulative
+	}
+	var mean time.Duration
+	if h.count > 0 {
+		mean = h.sum / time.Duration(h.count)
+	}
+	return json.Marshal(map[string]interface{}{
+		"count":   h.count,
+		"mean":    mean,
+		"max":     h.max,
+		"p50":     h.Quantile(0.5),
+		"p90":     h.Quantile(0.9),
+		"p99":     h.Quantile(0.99),
+		"buckets": buckets,
+	})
+}
+
+// RouteLatency tracks the write latency of a route's adapter
+type RouteLatency struct {
+	Total     *Histogram    `json:"total"`
+	Window    *Histogram    `json:"window"`
+	WindowP99 time.Duration `json:"window_p99"`
+	Slow      bool          `json:"slow"`
+	threshold time.Duration
+}
+
+// LatencyStats holds the write latency histograms of all routes, and flags
+// routes whose p99 write latency over a window exceeds a threshold as slow
+type LatencyStats struct {
+	mu        sync.Mutex
+	routes    map[string]*RouteLatency
+	threshold time.Duration
+	window    time.Duration
+}
+
+// Latencies records the write latency of adapters
+var Latencies = &LatencyStats{
+	routes: make(map[string]*RouteLatency),
+	window: time.Minute,
+}
+
+func init() {
+	Jobs.Register(Latencies, "latency")
+}
+
+// ObserveWrite records an adapter write for route that started at start
+func ObserveWrite(route *Route, start time.Time) {
+	end := time.Now()
+	Latencies.Observe(route, end.Sub(start))
+	Traces.wrote(route, start, end)
+}
+
+// Observe records an adapter write for route taking d
+func (ls *LatencyStats) Observe(route *Route, d time.Duration) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	rl, ok := ls.routes[route.ID]
+	if !ok {
+		rl = &RouteLatency{Total: newHistogram(), Window: newHistogram(), threshold: ls.threshold}
+		if value := route.Options["slow_write_threshold"]; value != "" {
+			if threshold, err := time.ParseDuration(value); err == nil {
+				rl.threshold = threshold
+			} else {
+				latencyLog.Error("invalid value for slow_write_threshold", "route", route.ID, "value", value)
+			}
+		}
+		ls.routes[route.ID] = rl
+	}
+	rl.Total.Observe(d)
+	rl.Window.Observe(d)
+}
+
+// Slow returns whether route was flagged slow by the last check
+func (ls *LatencyStats) Slow(route *Route) bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	rl, ok := ls.routes[route.ID]
+	return ok && rl.Slow
+}
+
+// MarshalJSON writes the latency of each route by route ID
+func (ls *LatencyStats) MarshalJSON() ([]byte, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return json.Marshal(ls.routes)
+}
+
+// check flags the routes whose p99 write latency over the last window
+// exceeds their threshold, and starts a new window. Routes without writes in
+// the window are no longer slow, so a standby route is only used until the
+// next check.
+func (ls *LatencyStats) check() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	for id, rl := range ls.routes {
+		rl.WindowP99 = rl.Window.Quantile(0.99)
+		slow := rl.threshold > 0 && rl.WindowP99 > rl.threshold
+		if slow && !rl.Slow {
+			latencyLog.Warn("p99 write latency exceeds the slow write threshold", "route", id, "p99", rl.WindowP99, "threshold", rl.threshold)
+		} else if !slow && rl.Slow {
+			latencyLog.Info("p99 write latency recovered", "route", id, "p99", rl.WindowP99)
+		}
+		rl.Slow = slow
+		rl.Window = newHistogram()
+	}
+}
+
+// Name returns the name of the slow write watchdog job, empty unless it is
+// enabled
+func (ls *LatencyStats) Name() string {
+	if ls.threshold == 0 {
+		return ""
+	}
+	return "latency"
+}
+
+// Setup configures the slow write watchdog from SLOW_WRITE_THRESHOLD and
+// SLOW_WRITE_WINDOW
+func (ls *LatencyStats) Setup() error {
+	threshold, err := time.ParseDuration(getopt("SLOW_WRITE_THRESHOLD", "0"))
+	if err != nil || threshold < 0 {
+		return errors.New("invalid value for SLOW_WRITE_THRESHOLD: " + getopt("SLOW_WRITE_THRESHOLD", ""))
+	}
+	window, err := time.ParseDuration(getopt("SLOW_WRITE_WINDOW", "1m"))
+	if err != nil || window <= 0 {
+		return errors.New("invalid value for SLOW_WRITE_WINDOW: " + getopt("SLOW_WRITE_WINDOW", ""))
+	}
+	ls.mu.Lock()
+	ls.threshold, ls.window = threshold, window
+	ls.mu.Unlock()
+	return nil
+}
+
+// Run checks the write latency of routes every window
+func (ls *LatencyStats) Run() error {
+	for range time.Tick(ls.window) {
+		ls.check()
+	}
+	return nil
+}
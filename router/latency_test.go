@@ -0,0 +1,90 @@
+package router
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	h := newHistogram()
+	if h.Quantile(0.99) != 0 {
+		t.Errorf("expected 0 for an empty histogram got %s", h.Quantile(0.99))
+	}
+	for i := 0; i < 98; i++ {
+		h.Observe(3 * time.Millisecond)
+	}
+	h.Observe(100 * time.Millisecond)
+	h.Observe(2 * time.Minute)
+	if q := h.Quantile(0.5); q != 4*time.Millisecond {
+		t.Errorf("expected p50 4ms got %s", q)
+	}
+	if q := h.Quantile(0.99); q != 128*time.Millisecond {
+		t.Errorf("expected p99 128ms got %s", q)
+	}
+	if q := h.Quantile(1); q != 2*time.Minute {
+		t.Errorf("expected p100 2m got %s", q)
+	}
+}
+
+func TestLatencySlowWrites(t *testing.T) {
+	ls := &LatencyStats{routes: make(map[string]*RouteLatency), threshold: 50 * time.Millisecond}
+	fast := &Route{ID: "fast"}
+	slow := &Route{ID: "slow"}
+	lenient := &Route{ID: "lenient", Options: map[string]string{"slow_write_threshold": "1s"}}
+	for i := 0; i < 10; i++ {
+		ls.Observe(fast, time.Millisecond)
+		ls.Observe(slow, 200*time.Millisecond)
+		ls.Observe(lenient, 200*time.Millisecond)
+	}
+	ls.check()
+	if ls.Slow(fast) || !ls.Slow(slow) || ls.Slow(lenient) {
+		t.Errorf("expected only the slow route flagged, got fast %v slow %v lenient %v",
+			ls.Slow(fast), ls.Slow(slow), ls.Slow(lenient))
+	}
+	if p99 := ls.routes["slow"].WindowP99; p99 != 200*time.Millisecond {
+		t.Errorf("expected window p99 200ms got %s", p99)
+	}
+	// a window without writes clears the flag
+	ls.check()
+	if ls.Slow(slow) {
+		t.Error("expected the slow route to recover after an empty window")
+	}
+	if ls.routes["slow"].Total.count != 10 {
+		t.Errorf("expected 10 writes in total got %v", ls.routes["slow"].Total.count)
+	}
+}
+
+func TestFailoverToStandby(t *testing.T) {
+	rm := &RouteManager{routes: make(map[string]*Route)}
+	sb := rm.addStandby(&Route{ID: "standby"})
+	primary := &Route{ID: "failover-primary", Options: map[string]string{"standby": "standby"}}
+	Latencies.Observe(primary, time.Minute)
+	Latencies.mu.Lock()
+	Latencies.routes[primary.ID].Slow = true
+	Latencies.mu.Unlock()
+	defer func() {
+		Latencies.mu.Lock()
+		delete(Latencies.routes, primary.ID)
+		Latencies.mu.Unlock()
+	}()
+
+	logstream := make(chan *Message)
+	out := make(chan *Message, 1)
+	go rm.failover(primary, logstream, out)
+	message := &Message{Data: "hello"}
+	logstream <- message
+	if got := <-sb.messages; got != message {
+		t.Errorf("expected the message on the standby got %+v", got)
+	}
+
+	// once the standby stops the primary gets its messages back
+	rm.removeStandby("standby", sb)
+	logstream <- message
+	if got := <-out; got != message {
+		t.Errorf("expected the message on the primary got %+v", got)
+	}
+	close(logstream)
+	if _, ok := <-out; ok {
+		t.Error("expected out to be closed")
+	}
+}
@@ -0,0 +1,283 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// LogLevel is the severity of a record of logspout's own logs
+type LogLevel int
+
+// Levels of logspout's own log records, in increasing severity
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	if l < LevelDebug || l > LevelError {
+		return strconv.Itoa(int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLogLevel returns the level named value
+func ParseLogLevel(value string) (LogLevel, error) {
+	switch strings.ToLower(value) {
+	case "warning":
+		return LevelWarn, nil
+	case "err":
+		return LevelError, nil
+	}
+	for level, name := range levelNames {
+		if strings.EqualFold(value, name) {
+			return LogLevel(level), nil
+		}
+	}
+	return 0, errors.New("invalid value for LOG_LEVEL (must be debug, info, warn or error): " + value)
+}
+
+// Formats of logspout's own logs
+const (
+	LogFormatText   = "text"
+	LogFormatLogfmt = "logfmt"
+	LogFormatJSON   = "json"
+)
+
+// LogConfig is which of logspout's own log records are written, and how
+type LogConfig struct {
+	// Level is the least severe level logged by every module
+	Level string `json:"level"`
+	// Debug lists the modules also logging at debug level, or all for every
+	// module
+	Debug []string `json:"debug"`
+	// Format is text, logfmt or json
+	Format string `json:"format"`
+}
+
+// selfLog writes the records of logspout's own logs
+type selfLog struct {
+	mu       sync.Mutex
+	out      io.Writer
+	format   string
+	level    LogLevel
+	debug    map[string]bool
+	debugAll bool
+	now      func() time.Time
+}
+
+var selflog = &selfLog{out: os.Stderr, format: LogFormatText, level: LevelInfo, now: time.Now}
+
+func init() {
+	config := LogConfig{
+		Level:  getopt("LOG_LEVEL", "info"),
+		Format: getopt("LOG_FORMAT", LogFormatText),
+	}
+	if value := os.Getenv("DEBUG"); value != "" {
+		config.Debug = strings.Split(value, ",")
+	}
+	if err := SetLogConfig(config); err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+	}
+	// records logged with the log package, e.g. by third party modules, are
+	// written like those of a Logger of the module in their prefix
+	log.SetFlags(0)
+	log.SetOutput(stdlog{})
+}
+
+// SetLogConfig changes which of logspout's own log records are written, and
+// how. An empty Format keeps the current format.
+func SetLogConfig(config LogConfig) error {
+	level, err := ParseLogLevel(config.Level)
+	if err != nil {
+		return err
+	}
+	format := config.Format
+	switch format {
+	case "":
+	case LogFormatText, LogFormatLogfmt, LogFormatJSON:
+	default:
+		return errors.New("invalid value for LOG_FORMAT (must be text, logfmt or json): " + format)
+	}
+	debug := make(map[string]bool)
+	all := false
+	for _, module := range config.Debug {
+		switch module = strings.TrimSpace(module); module {
+		case "":
+		// DEBUG=1 and the like enabled debug logs of every module before
+		// they could be enabled by module
+		case "all", "*", "1", "true":
+			all = true
+		default:
+			debug[module] = true
+		}
+	}
+	selflog.mu.Lock()
+	defer selflog.mu.Unlock()
+	selflog.level, selflog.debug, selflog.debugAll = level, debug, all
+	if format != "" {
+		selflog.format = format
+	}
+	return nil
+}
+
+// CurrentLogConfig returns which of logspout's own log records are written,
+// and how
+func CurrentLogConfig() LogConfig {
+	selflog.mu.Lock()
+	defer selflog.mu.Unlock()
+	config := LogConfig{Level: selflog.level.String(), Format: selflog.format, Debug: []string{}}
+	if selflog.debugAll {
+		config.Debug = append(config.Debug, "all")
+	}
+	for module := range selflog.debug {
+		config.Debug = append(config.Debug, module)
+	}
+	sort.Strings(config.Debug)
+	return config
+}
+
+func (s *selfLog) enabled(module string, level LogLevel) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return level >= s.level || level == LevelDebug && (s.debugAll || s.debug[module])
+}
+
+func (s *selfLog) write(module string, level LogLevel, msg string, fields []interface{}) {
+	if !s.enabled(module, level) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf := new(bytes.Buffer)
+	now := s.now()
+	switch s.format {
+	case LogFormatJSON:
+		record := map[string]interface{}{
+			"time":   now.UTC().Format(time.RFC3339Nano),
+			"level":  level.String(),
+			"module": module,
+			"msg":    msg,
+		}
+		for i := 0; i < len(fields); i += 2 {
+			record[fieldKey(fields, i)] = fieldValue(fields, i)
+		}
+		json.NewEncoder(buf).Encode(record)
+	case LogFormatLogfmt:
+		fmt.Fprintf(buf, "time=%s level=%s module=%s msg=%s", now.UTC().Format(time.RFC3339Nano), level, logfmtValue(module), logfmtValue(msg))
+		for i := 0; i < len(fields); i += 2 {
+			fmt.Fprintf(buf, " %s=%s", fieldKey(fields, i), logfmtValue(fmt.Sprint(fieldValue(fields, i))))
+		}
+		buf.WriteByte('\n')
+	default:
+		// the format of the log package logspout logged with before
+		fmt.Fprintf(buf, "%s %s: %s", now.Format("2006/01/02 15:04:05"), module, msg)
+		for i := 0; i < len(fields); i += 2 {
+			fmt.Fprintf(buf, " %s=%s", fieldKey(fields, i), logfmtValue(fmt.Sprint(fieldValue(fields, i))))
+		}
+		buf.WriteByte('\n')
+	}
+	s.out.Write(buf.Bytes())
+}
+
+func fieldKey(fields []interface{}, i int) string {
+	if key, ok := fields[i].(string); ok {
+		return key
+	}
+	return fmt.Sprint(fields[i])
+}
+
+func fieldValue(fields []interface{}, i int) interface{} {
+	if i+1 >= len(fields) {
+		return nil
+	}
+	if err, ok := fields[i+1].(error); ok {
+		return err.Error()
+	}
+	return fields[i+1]
+}
+
+// logfmtValue quotes value if it is empty or has spaces, quotes or =
+func logfmtValue(value string) string {
+	if value == "" || strings.IndexFunc(value, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r)
+	}) >= 0 {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// Logger writes the records of a module to logspout's own logs, configured
+// by LOG_LEVEL, LOG_FORMAT and DEBUG
+type Logger struct {
+	module string
+}
+
+// NewLogger returns the Logger of module
+func NewLogger(module string) *Logger {
+	return &Logger{module}
+}
+
+// Enabled returns whether records of level are written for the module, to
+// skip preparing debug records that wouldn't be
+func (l *Logger) Enabled(level LogLevel) bool {
+	return selflog.enabled(l.module, level)
+}
+
+// Debug writes msg with fields, pairs of keys and values, if debug logs of
+// the module are enabled
+func (l *Logger) Debug(msg string, fields ...interface{}) {
+	selflog.write(l.module, LevelDebug, msg, fields)
+}
+
+// Info writes msg with fields, pairs of keys and values
+func (l *Logger) Info(msg string, fields ...interface{}) {
+	selflog.write(l.module, LevelInfo, msg, fields)
+}
+
+// Warn writes msg with fields, pairs of keys and values
+func (l *Logger) Warn(msg string, fields ...interface{}) {
+	selflog.write(l.module, LevelWarn, msg, fields)
+}
+
+// Error writes msg with fields, pairs of keys and values
+func (l *Logger) Error(msg string, fields ...interface{}) {
+	selflog.write(l.module, LevelError, msg, fields)
+}
+
+// Debugln writes its operands like fmt.Println if debug logs of the module
+// are enabled, for the debug helpers of modules
+func (l *Logger) Debugln(v ...interface{}) {
+	if l.Enabled(LevelDebug) {
+		selflog.write(l.module, LevelDebug, strings.TrimSuffix(fmt.Sprintln(v...), "\n"), nil)
+	}
+}
+
+// stdlog writes the records logged with the log package at info level, for
+// the module in their prefix like syslog: or logspout
+type stdlog struct{}
+
+func (stdlog) Write(p []byte) (int, error) {
+	line := strings.TrimSuffix(string(p), "\n")
+	module, msg := "logspout", line
+	if i := strings.Index(line, ": "); i > 0 && strings.IndexFunc(line[:i], unicode.IsSpace) < 0 {
+		module, msg = line[:i], line[i+2:]
+	}
+	selflog.write(module, LevelInfo, msg, nil)
+	return len(p), nil
+}
@@ -0,0 +1,81 @@
+package router
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLogs writes logspout's own logs to the returned buffer until the
+// returned func is called
+func captureLogs(t *testing.T, config LogConfig) (*bytes.Buffer, func()) {
+	previous := CurrentLogConfig()
+	if err := SetLogConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	selflog.mu.Lock()
+	out, now := selflog.out, selflog.now
+	selflog.out = buf
+	selflog.now = func() time.Time { return time.Date(2018, 3, 5, 9, 8, 7, 0, time.UTC) }
+	selflog.mu.Unlock()
+	return buf, func() {
+		selflog.mu.Lock()
+		selflog.out, selflog.now = out, now
+		selflog.mu.Unlock()
+		SetLogConfig(previous)
+	}
+}
+
+func TestLogFormats(t *testing.T) {
+	for format, expected := range map[string]string{
+		LogFormatText:   "2018/03/05 09:08:07 syslog: send queue full route=abc dropped=1000 error=\"broken pipe\"\n",
+		LogFormatLogfmt: "time=2018-03-05T09:08:07Z level=warn module=syslog msg=\"send queue full\" route=abc dropped=1000 error=\"broken pipe\"\n",
+		LogFormatJSON:   `{"dropped":1000,"error":"broken pipe","level":"warn","module":"syslog","msg":"send queue full","route":"abc","time":"2018-03-05T09:08:07Z"}` + "\n",
+	} {
+		buf, restore := captureLogs(t, LogConfig{Level: "info", Format: format})
+		NewLogger("syslog").Warn("send queue full", "route", "abc", "dropped", 1000, "error", errors.New("broken pipe"))
+		restore()
+		if buf.String() != expected {
+			t.Errorf("%s: expected %q got %q", format, expected, buf.String())
+		}
+	}
+}
+
+func TestLogLevels(t *testing.T) {
+	buf, restore := captureLogs(t, LogConfig{Level: "warn", Debug: []string{"router"}, Format: LogFormatLogfmt})
+	defer restore()
+	NewLogger("syslog").Info("reconnect successful")
+	NewLogger("syslog").Debug("sending heartbeat")
+	NewLogger("router").Debugln("pump.pumpLogs():", "8dfafdbc3a40", "started")
+	NewLogger("loki").Error("dropping messages")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `module=router msg="pump.pumpLogs(): 8dfafdbc3a40 started"`) || !strings.Contains(lines[1], "level=error module=loki") {
+		t.Errorf("expected debug records of router and errors only got %q", lines)
+	}
+
+	if err := SetLogConfig(LogConfig{Level: "info", Debug: []string{"1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !NewLogger("syslog").Enabled(LevelDebug) || CurrentLogConfig().Debug[0] != "all" {
+		t.Error("expected DEBUG=1 to enable the debug logs of every module")
+	}
+	if err := SetLogConfig(LogConfig{Level: "verbose"}); err == nil {
+		t.Error("expected an error for an invalid level")
+	}
+}
+
+func TestLogPackage(t *testing.T) {
+	buf, restore := captureLogs(t, LogConfig{Level: "info", Format: LogFormatLogfmt})
+	defer restore()
+	log.Println("logstash: could not write: broken pipe")
+	log.Println("# received interrupt, flushing routes")
+	expected := `time=2018-03-05T09:08:07Z level=info module=logstash msg="could not write: broken pipe"` + "\n" +
+		`time=2018-03-05T09:08:07Z level=info module=logspout msg="# received interrupt, flushing routes"` + "\n"
+	if buf.String() != expected {
+		t.Errorf("expected records of the log package with the module of their prefix got %q", buf.String())
+	}
+}
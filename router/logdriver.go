@@ -0,0 +1,152 @@
+package router
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// LogDriverSource is the source of the warning sent when logspout can't
+// read the logs of a container because of its log driver
+const LogDriverSource = "logdriver"
+
+// UnstreamableContainer is a running container logspout doesn't read the
+// logs of, because its log driver doesn't keep them, like none, or doesn't
+// let the Docker logs API read them back
+type UnstreamableContainer struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Image     string    `json:"image"`
+	LogDriver string    `json:"log_driver"`
+	Since     time.Time `json:"since"`
+}
+
+// UnstreamableRegistry tracks the running containers whose logs can't be
+// streamed
+type UnstreamableRegistry struct {
+	mu         sync.Mutex
+	containers map[string]*UnstreamableContainer
+	// warned is the containers warned about, until they are destroyed, so
+	// restarts don't warn again
+	warned map[string]bool
+}
+
+// Unstreamable is the running containers whose logs can't be streamed
+var Unstreamable = &UnstreamableRegistry{
+	containers: make(map[string]*UnstreamableContainer),
+	warned:     make(map[string]bool),
+}
+
+// logDriverStreamable returns whether logspout can read the logs of
+// container, with its log driver and LOG_DRIVER_FALLBACK. Containers
+// logging to none have no logs to read, whatever the fallback.
+func logDriverStreamable(container *docker.Container) bool {
+	if container.HostConfig.LogConfig.Type == "none" {
+		return false
+	}
+	return logDriverSupported(container) || logDriverFallback(fallbackLogs)
+}
+
+// logDriverWarning returns whether LOG_DRIVER_WARNING sends a message to
+// the routes of containers whose logs can't be streamed
+func logDriverWarning() bool {
+	return getopt("LOG_DRIVER_WARNING", "false") == "true"
+}
+
+// All returns the containers whose logs can't be streamed, by name
+func (u *UnstreamableRegistry) All() []*UnstreamableContainer {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	containers := make([]*UnstreamableContainer, 0, len(u.containers))
+	for _, c := range u.containers {
+		containers = append(containers, c)
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Name < containers[j].Name
+	})
+	return containers
+}
+
+// Len returns how many running containers' logs can't be streamed
+func (u *UnstreamableRegistry) Len() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.containers)
+}
+
+// add records container as unstreamable, returning whether it wasn't
+// warned about before
+func (u *UnstreamableRegistry) add(container *docker.Container, now time.Time) bool {
+	id := normalID(container.ID)
+	c := &UnstreamableContainer{
+		ID:        id,
+		Name:      normalName(container.Name),
+		LogDriver: container.HostConfig.LogConfig.Type,
+		Since:     now,
+	}
+	if container.Config != nil {
+		c.Image = container.Config.Image
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.containers[id] = c
+	if u.warned[id] {
+		return false
+	}
+	u.warned[id] = true
+	return true
+}
+
+// stopped forgets a container that stopped running, or was destroyed and
+// can be warned about again if its ID comes back
+func (u *UnstreamableRegistry) stopped(id string, destroyed bool) {
+	id = normalID(id)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.containers, id)
+	if destroyed {
+		delete(u.warned, id)
+	}
+}
+
+// unstreamable records a container whose logs can't be streamed, logging
+// it and, with LOG_DRIVER_WARNING, sending a warning to the routes of the
+// container once
+func (p *LogsPump) unstreamable(container *docker.Container, admission Admission) {
+	now := time.Now()
+	if !Unstreamable.add(container, now) {
+		return
+	}
+	driver := container.HostConfig.LogConfig.Type
+	logger.Warn("can't stream logs of container because of its log driver",
+		"container", normalID(container.ID), "name", normalName(container.Name), "log_driver", driver)
+	if !logDriverWarning() {
+		return
+	}
+	message := &Message{
+		Data:      "logs of this container can't be read with the " + driver + " log driver",
+		Container: container,
+		Time:      now,
+		Source:    LogDriverSource,
+		Fields:    map[string]string{"log_driver": driver},
+	}
+	cp := &containerPump{container: container, routes: admission.Routes}
+	routes, _ := Routes.GetAll()
+	for _, route := range routes {
+		if !cp.admits(route) || !route.MatchMessage(message) ||
+			!route.MatchContainer(normalID(container.ID), normalName(container.Name), container.Config.Labels) ||
+			!route.MatchImage(container.Config.Image) {
+			continue
+		}
+		sb := Routes.getStandby(route.ID)
+		if sb == nil || !residencyAllows(route, container) {
+			continue
+		}
+		select {
+		case sb.messages <- message:
+		case <-sb.done:
+		}
+	}
+}
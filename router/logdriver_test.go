@@ -0,0 +1,70 @@
+package router
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func loggingTo(driver string) *docker.Container {
+	return &docker.Container{
+		ID:         "6f9c2a1b3d4e5f60",
+		Name:       "/batch",
+		Config:     &docker.Config{Image: "acme/batch:1.0"},
+		HostConfig: &docker.HostConfig{LogConfig: docker.LogConfig{Type: driver}},
+	}
+}
+
+func TestLogDriverStreamable(t *testing.T) {
+	for driver, streamable := range map[string]bool{"json-file": true, "local": true, "none": false, "awslogs": false} {
+		if logDriverStreamable(loggingTo(driver)) != streamable {
+			t.Errorf("%s: expected streamable %v", driver, streamable)
+		}
+	}
+	os.Setenv("LOG_DRIVER_FALLBACK", "logs")
+	defer os.Unsetenv("LOG_DRIVER_FALLBACK")
+	if !logDriverStreamable(loggingTo("awslogs")) || logDriverStreamable(loggingTo("none")) {
+		t.Error("expected awslogs streamable with the logs fallback, and none not")
+	}
+}
+
+func TestUnstreamableWarning(t *testing.T) {
+	os.Setenv("LOG_DRIVER_WARNING", "true")
+	defer os.Unsetenv("LOG_DRIVER_WARNING")
+	saved, savedUnstreamable := Routes, Unstreamable
+	Routes = &RouteManager{routes: map[string]*Route{
+		"all":    {ID: "all"},
+		"stdout": {ID: "stdout", FilterSources: []string{"stdout"}},
+	}}
+	Unstreamable = &UnstreamableRegistry{containers: make(map[string]*UnstreamableContainer), warned: make(map[string]bool)}
+	defer func() { Routes, Unstreamable = saved, savedUnstreamable }()
+	all := Routes.addStandby(Routes.routes["all"])
+	Routes.addStandby(Routes.routes["stdout"])
+
+	p := &LogsPump{}
+	container := loggingTo("none")
+	go p.unstreamable(container, Admission{})
+	select {
+	case message := <-all.messages:
+		if message.Source != LogDriverSource || message.Fields["log_driver"] != "none" {
+			t.Errorf("unexpected warning %+v", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a warning sent to the route")
+	}
+	if containers := Unstreamable.All(); len(containers) != 1 || containers[0].Name != "batch" || containers[0].LogDriver != "none" {
+		t.Errorf("expected the container listed got %+v", containers)
+	}
+
+	// restarts aren't warned about again, until the container is destroyed
+	Unstreamable.stopped(container.ID, false)
+	if Unstreamable.Len() != 0 || Unstreamable.add(container, time.Now()) {
+		t.Error("expected a restarted container listed again without a warning")
+	}
+	Unstreamable.stopped(container.ID, true)
+	if !Unstreamable.add(container, time.Now()) {
+		t.Error("expected a warning once the container was destroyed")
+	}
+}
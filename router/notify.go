@@ -0,0 +1,49 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+var notifyLog = NewLogger("notify")
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// Notification is posted as JSON to NOTIFY_WEBHOOK
+type Notification struct {
+	Event   string      `json:"event"`
+	Route   string      `json:"route,omitempty"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+	Time    time.Time   `json:"time"`
+}
+
+// Notify posts n to the NOTIFY_WEBHOOK URL, if one is set, without waiting
+// for the response
+func Notify(n *Notification) {
+	url := getopt("NOTIFY_WEBHOOK", "")
+	if url == "" {
+		return
+	}
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		notifyLog.Error("encoding notification failed", "event", n.Event, "error", err)
+		return
+	}
+	go func() {
+		resp, err := notifyClient.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			notifyLog.Warn("posting notification failed", "event", n.Event, "error", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			notifyLog.Warn("posting notification failed", "event", n.Event, "url", url, "status", resp.Status)
+		}
+	}()
+}
@@ -0,0 +1,43 @@
+package router
+
+import (
+	"strings"
+)
+
+const (
+	// partialSize is the size of the fragments the Docker daemon splits
+	// lines longer than 16KB into
+	partialSize = 16 * 1024
+	// maxTimestamp is the length of the longest RFC3339Nano timestamp the
+	// Docker logs API prefixes fragments with, and the space after it
+	maxTimestamp = len("2006-01-02T15:04:05.999999999-07:00 ")
+)
+
+// joinPartials reassembles a line longer than 16KB read from the Docker
+// logs API with timestamps. The daemon logs such lines as fragments, which
+// the API writes without newlines in between but each with its timestamp,
+// so every 16KB of the line is followed by the timestamp of the next
+// fragment, which is removed.
+func joinPartials(line string) string {
+	if len(line) <= partialSize {
+		return line
+	}
+	var joined strings.Builder
+	for len(line) > partialSize {
+		end := partialSize + maxTimestamp
+		if end > len(line) {
+			end = len(line)
+		}
+		_, rest, ok := splitTimestamp(line[partialSize:end])
+		if !ok {
+			break
+		}
+		joined.WriteString(line[:partialSize])
+		line = line[end-len(rest):]
+	}
+	if joined.Len() == 0 {
+		return line
+	}
+	joined.WriteString(line)
+	return joined.String()
+}
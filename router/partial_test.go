@@ -0,0 +1,54 @@
+package router
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestJoinPartials(t *testing.T) {
+	first, second := strings.Repeat("a", partialSize), strings.Repeat("b", partialSize)
+	for _, test := range []struct {
+		line, expected string
+	}{
+		{"short", "short"},
+		{first + "2018-03-05T09:08:07.000000100Z " + second + "2018-03-05T09:08:07.000000200Z end", first + second + "end"},
+		// an empty last fragment of a line that ended at the fragment size
+		{first + "2018-03-05T09:08:07.000000100Z ", first},
+		{first + "2018-03-05T09:08:07.000000100+02:00 end", first + "end"},
+		{first + "not a timestamp", first + "not a timestamp"},
+	} {
+		if joined := joinPartials(test.line); joined != test.expected {
+			t.Errorf("expected %d bytes got %d for %.40q", len(test.expected), len(joined), test.line[len(test.line)-40:])
+		}
+	}
+}
+
+func TestContainerPumpJoinsPartials(t *testing.T) {
+	os.Setenv("MESSAGE_TIME", "docker")
+	defer os.Unsetenv("MESSAGE_TIME")
+	container := &docker.Container{ID: "8dfafdbc3a40", Config: &docker.Config{}}
+	outrd, outwr := io.Pipe()
+	errrd, errwr := io.Pipe()
+	defer errwr.Close()
+	pump := newContainerPump(container, outrd, errrd, nil)
+	logstream := make(chan *Message, 10)
+	pump.add(logstream, &Route{})
+	fragment := strings.Repeat("x", partialSize)
+	io.WriteString(outwr, "2018-03-05T09:08:07Z "+fragment+"2018-03-05T09:08:08Z end\n2018-03-05T09:08:09Z next\n")
+	outwr.Close()
+	for _, expected := range []string{fragment + "end", "next"} {
+		select {
+		case message := <-logstream:
+			if message.Data != expected {
+				t.Errorf("expected %d bytes got %d", len(expected), len(message.Data))
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for message")
+		}
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"encoding/json"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 )
 
+var persistLog = NewLogger("persistor")
+
 // RouteFileStore represents a directory for storing routes
 type RouteFileStore string
 
@@ -64,10 +67,123 @@ func (fs RouteFileStore) Remove(id string) bool {
 	return false
 }
 
+// RouteJSONFile stores routes as a JSON list in a single file, like a
+// mounted routes.json
+type RouteJSONFile struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewRouteJSONFile returns a RouteJSONFile for the file at path, which is
+// created when the first route is added
+func NewRouteJSONFile(path string) *RouteJSONFile {
+	return &RouteJSONFile{path: path}
+}
+
+// Get returns the stored route with id
+func (f *RouteJSONFile) Get(id string) (*Route, error) {
+	routes, err := f.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, route := range routes {
+		if route.ID == id {
+			return route, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// GetAll returns all stored routes
+func (f *RouteJSONFile) GetAll() ([]*Route, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.read()
+}
+
+func (f *RouteJSONFile) read() ([]*Route, error) {
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var routes []*Route
+	if err = unmarshal(file, &routes); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return routes, nil
+}
+
+// write replaces the file with routes, through a temporary file so a crash
+// doesn't leave it truncated
+func (f *RouteJSONFile) write(routes []*Route) error {
+	if routes == nil {
+		routes = []*Route{}
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(marshal(routes), '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Add stores route, replacing a stored route with the same id
+func (f *RouteJSONFile) Add(route *Route) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	routes, err := f.read()
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i, r := range routes {
+		if r.ID == route.ID {
+			routes[i], replaced = route, true
+		}
+	}
+	if !replaced {
+		routes = append(routes, route)
+	}
+	return f.write(routes)
+}
+
+// Remove removes the stored route with id, returning whether it was stored
+func (f *RouteJSONFile) Remove(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	routes, err := f.read()
+	if err != nil {
+		persistLog.Error("reading routes failed", "path", f.path, "error", err)
+		return false
+	}
+	for i, route := range routes {
+		if route.ID == id {
+			if err := f.write(append(routes[:i], routes[i+1:]...)); err != nil {
+				persistLog.Error("writing routes failed", "path", f.path, "error", err)
+			}
+			return true
+		}
+	}
+	return false
+}
+
 func marshal(obj interface{}) []byte {
 	bytes, err := json.MarshalIndent(obj, "", "  ")
 	if err != nil {
-		log.Println("marshal:", err)
+		persistLog.Error("encoding failed", "error", err)
 	}
 	return bytes
 }
@@ -0,0 +1,45 @@
+package router
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRouteJSONFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logspout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := NewRouteJSONFile(filepath.Join(dir, "routes.json"))
+	if routes, err := f.GetAll(); err != nil || len(routes) != 0 {
+		t.Fatalf("expected no routes before the file exists got %v %v", routes, err)
+	}
+	for _, route := range []*Route{
+		{ID: "a", Adapter: "syslog", Address: "logs.example.com:514"},
+		{ID: "b", Adapter: "raw", Address: "logs.example.com:5000"},
+		{ID: "a", Adapter: "syslog", Address: "new.example.com:514"},
+	} {
+		if err := f.Add(route); err != nil {
+			t.Fatal(err)
+		}
+	}
+	routes, err := NewRouteJSONFile(f.path).GetAll()
+	if err != nil || len(routes) != 2 {
+		t.Fatalf("expected 2 routes got %v %v", routes, err)
+	}
+	if route, _ := f.Get("a"); route == nil || route.Address != "new.example.com:514" {
+		t.Errorf("expected route a replaced got %+v", route)
+	}
+	if !f.Remove("a") || f.Remove("a") {
+		t.Error("expected route a removed once")
+	}
+	if _, err := f.Get("a"); err == nil {
+		t.Error("expected route a to be gone")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expected only routes.json in %s got %v files", dir, len(files))
+	}
+}
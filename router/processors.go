@@ -0,0 +1,87 @@
+package router
+
+import (
+	"errors"
+	"strings"
+)
+
+// ProcessorConfig configures one stage of a route's processor chain
+type ProcessorConfig struct {
+	Type    string            `json:"type"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+func newProcessors(route *Route) ([]Processor, error) {
+	var processors []Processor
+	for _, conf := range route.Processors {
+		factory, found := ProcessorFactories.Lookup(conf.Type)
+		if !found {
+			return nil, errors.New("bad processor: " + conf.Type)
+		}
+		options := conf.Options
+		if options == nil {
+			options = make(map[string]string)
+		}
+		processor, err := factory(route, options)
+		if err != nil {
+			return nil, err
+		}
+		processors = append(processors, processor)
+	}
+	return processors, nil
+}
+
+// parseProcessors adds the stages listed in a route URI, e.g. processors=filter,redact
+func parseProcessors(r *Route, value string) {
+	for _, typ := range strings.Split(value, ",") {
+		if typ == "" {
+			continue
+		}
+		r.Processors = append(r.Processors, &ProcessorConfig{
+			Type:    typ,
+			Options: make(map[string]string),
+		})
+	}
+}
+
+// parseProcessorOptions moves route URI options like processor.filter.exclude=^DEBUG
+// to the options of their stage
+func parseProcessorOptions(r *Route) {
+	for key, value := range r.Options {
+		parts := strings.SplitN(key, ".", 3)
+		if len(parts) != 3 || parts[0] != "processor" {
+			continue
+		}
+		for _, conf := range r.Processors {
+			if conf.Type == parts[1] {
+				conf.Options[parts[2]] = value
+			}
+		}
+		delete(r.Options, key)
+	}
+}
+
+// Process passes a logstream through the route's processor chain, after
+// adding its static fields, and returns the stream of processed messages,
+// numbered if the route has sequence set
+func (r *Route) Process(logstream chan *Message) chan *Message {
+	if len(r.fields) > 0 {
+		out := make(chan *Message)
+		go addFields(r.fields, logstream, out)
+		logstream = out
+	}
+	for _, processor := range r.processors {
+		out := make(chan *Message)
+		go func(processor Processor, in, out chan *Message) {
+			processor.Process(in, out)
+			close(out)
+		}(processor, logstream, out)
+		logstream = out
+	}
+	if r.sequence {
+		out := make(chan *Message)
+		go sequence(logstream, out)
+		logstream = out
+	}
+	return logstream
+}
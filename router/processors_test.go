@@ -0,0 +1,66 @@
+package router
+
+import (
+	"testing"
+)
+
+type suffixProcessor struct {
+	suffix string
+}
+
+func (p *suffixProcessor) Process(in chan *Message, out chan *Message) {
+	for message := range in {
+		out <- &Message{Data: message.Data + p.suffix}
+	}
+}
+
+func newSuffixProcessor(route *Route, options map[string]string) (Processor, error) {
+	return &suffixProcessor{options["suffix"]}, nil
+}
+
+func TestRouteProcessorsFromURI(t *testing.T) {
+	AdapterFactories.Register(newDummyAdapter, "dummy")
+	ProcessorFactories.Register(newSuffixProcessor, "suffix")
+	rm := &RouteManager{routes: make(map[string]*Route)}
+
+	err := rm.AddFromURI("dummy://host:514?processors=suffix,suffix&processor.suffix.suffix=!&append_tag=.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, _ := rm.GetAll()
+	route := routes[0]
+	if len(route.Processors) != 2 || len(route.processors) != 2 {
+		t.Fatalf("expected 2 processors got %v", len(route.Processors))
+	}
+	if route.Processors[1].Options["suffix"] != "!" {
+		t.Errorf("expected processor option suffix=! got %v", route.Processors[1].Options)
+	}
+	if len(route.Options) != 1 || route.Options["append_tag"] != ".db" {
+		t.Errorf("expected only append_tag in route options got %v", route.Options)
+	}
+
+	logstream := make(chan *Message)
+	processed := route.Process(logstream)
+	go func() {
+		logstream <- &Message{Data: "hello"}
+		close(logstream)
+	}()
+	if message := <-processed; message.Data != "hello!!" {
+		t.Errorf("expected hello!! got %s", message.Data)
+	}
+	if _, ok := <-processed; ok {
+		t.Error("expected processed stream to be closed")
+	}
+}
+
+func TestRouteBadProcessor(t *testing.T) {
+	AdapterFactories.Register(newDummyAdapter, "dummy")
+	rm := &RouteManager{routes: make(map[string]*Route)}
+	route := &Route{
+		Adapter:    "dummy",
+		Processors: []*ProcessorConfig{{Type: "nope"}},
+	}
+	if err := rm.Add(route); err == nil {
+		t.Error("expected error for unknown processor")
+	}
+}
@@ -2,13 +2,14 @@ package router
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"io"
-	"log"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsouza/go-dockerclient"
@@ -17,14 +18,25 @@ import (
 
 var allowTTY bool
 
+// ErrNotPumping is returned for containers logspout isn't reading logs from
+var ErrNotPumping = errors.New("container is not being pumped")
+
+var logsPump *LogsPump
+
 func init() {
-	pump := &LogsPump{
+	logsPump = &LogsPump{
 		pumps:  make(map[string]*containerPump),
 		routes: make(map[chan *update]struct{}),
 	}
 	setAllowTTY()
-	LogRouters.Register(pump, "pump")
-	Jobs.Register(pump, "pump")
+	LogRouters.Register(logsPump, "pump")
+	Jobs.Register(logsPump, "pump")
+}
+
+// RestartPump re-attaches to the log stream of the container with id,
+// reading it again from backlog ago
+func RestartPump(id string, backlog time.Duration) error {
+	return logsPump.Restart(id, backlog)
 }
 
 func getopt(name, dfault string) string {
@@ -35,10 +47,10 @@ func getopt(name, dfault string) string {
 	return value
 }
 
+var logger = NewLogger("router")
+
 func debug(v ...interface{}) {
-	if os.Getenv("DEBUG") != "" {
-		log.Println(v...)
-	}
+	logger.Debugln(v...)
 }
 
 func backlog() bool {
@@ -48,6 +60,12 @@ func backlog() bool {
 	return true
 }
 
+// dockerTime returns whether messages are timestamped with the time Docker
+// logged the line, rather than the time logspout read it, from MESSAGE_TIME
+func dockerTime() bool {
+	return getopt("MESSAGE_TIME", "read") == "docker"
+}
+
 func setAllowTTY() {
 	if t := getopt("ALLOW_TTY", ""); t == "true" {
 		allowTTY = true
@@ -69,7 +87,8 @@ func rateLimit() rate.Limit {
 
 func assert(err error, context string) {
 	if err != nil {
-		log.Fatal(context+": ", err)
+		logger.Error(context+" failed", "error", err)
+		os.Exit(1)
 	}
 }
 
@@ -86,7 +105,7 @@ func normalID(id string) string {
 
 func logDriverSupported(container *docker.Container) bool {
 	switch container.HostConfig.LogConfig.Type {
-	case "json-file", "journald":
+	case "json-file", "journald", "local":
 		return true
 	default:
 		return false
@@ -110,10 +129,10 @@ func ignoreContainer(container *docker.Container) bool {
 		excludeLabel = excludeLabelArr[0]
 	}
 
-	if value, ok := container.Config.Labels[excludeLabel]; ok {
-		return len(excludeLabel) > 0 && strings.ToLower(value) == strings.ToLower(excludeValue)
+	if value, ok := container.Config.Labels[excludeLabel]; ok && len(excludeLabel) > 0 && strings.ToLower(value) == strings.ToLower(excludeValue) {
+		return true
 	}
-	return false
+	return excluded(container)
 }
 
 func ignoreContainerTTY(container *docker.Container) bool {
@@ -136,10 +155,14 @@ type update struct {
 
 // LogsPump is responsible for "pumping" logs to their configured destinations
 type LogsPump struct {
-	mu     sync.Mutex
-	pumps  map[string]*containerPump
-	routes map[chan *update]struct{}
-	client *docker.Client
+	mu          sync.Mutex
+	pumps       map[string]*containerPump
+	routes      map[chan *update]struct{}
+	client      *docker.Client
+	checkpoints *checkpoints
+	annotated   map[string]bool
+	events      chan *docker.APIEvents
+	attacher    *attacher
 }
 
 // Name returns the name of the pump
@@ -151,6 +174,43 @@ func (p *LogsPump) Name() string {
 func (p *LogsPump) Setup() error {
 	var err error
 	p.client, err = docker.NewClientFromEnv()
+	if err != nil {
+		return err
+	}
+	if _, err = parseExitFlushTimeout(); err != nil {
+		return err
+	}
+	if t := getopt("MESSAGE_TIME", "read"); t != "read" && t != "docker" {
+		return errors.New("invalid value for MESSAGE_TIME (must be read or docker): " + t)
+	}
+	if _, err = silenceTimeout(); err != nil {
+		return err
+	}
+	if backfill, err = newBackfillLimiter(); err != nil {
+		return err
+	}
+	if p.attacher, err = newAttacher(); err != nil {
+		return err
+	}
+	if err = validExcludes(); err != nil {
+		return err
+	}
+	if err = journalctlFound(); err != nil {
+		return err
+	}
+	if tenants, err = newTenantRoutes(Routes); err != nil {
+		return err
+	}
+	if p.annotated, err = eventsToLogs(); err != nil {
+		return err
+	}
+	if err = Ready.setup(); err != nil {
+		return err
+	}
+	if len(p.annotated) > 0 {
+		p.events = make(chan *docker.APIEvents, eventBuffer)
+	}
+	p.checkpoints, err = newCheckpoints()
 	return err
 }
 
@@ -171,22 +231,46 @@ func (p *LogsPump) rename(event *docker.APIEvents) {
 func (p *LogsPump) Run() error {
 	inactivityTimeout := getInactivityTimeoutFromEnv()
 	debug("pump.Run(): using inactivity timeout: ", inactivityTimeout)
+	if p.checkpoints != nil {
+		interval, err := time.ParseDuration(getopt("CHECKPOINT_INTERVAL", "5s"))
+		if err != nil || interval <= 0 {
+			return errors.New("invalid value for CHECKPOINT_INTERVAL: " + getopt("CHECKPOINT_INTERVAL", ""))
+		}
+		go p.checkpoints.run(interval)
+	}
+	if p.events != nil {
+		go p.annotations()
+	}
+	if timeout, _ := silenceTimeout(); timeout > 0 {
+		go p.watchSilence(timeout)
+	}
+
+	// routes connect before messages are read for them
+	routes, _ := Routes.GetAll()
+	Ready.warm(routes)
 
 	containers, err := p.client.ListContainers(docker.ListContainersOptions{})
 	if err != nil {
 		return err
 	}
 	for _, listing := range containers {
-		p.pumpLogs(&docker.APIEvents{
+		event := &docker.APIEvents{
 			ID:     normalID(listing.ID),
 			Status: "start",
-		}, false, inactivityTimeout)
+		}
+		// staged attachments wait for their turn concurrently
+		if p.attacher.staged() {
+			go p.pumpLogs(event, false, inactivityTimeout)
+		} else {
+			p.pumpLogs(event, false, inactivityTimeout)
+		}
 	}
 	events := make(chan *docker.APIEvents)
 	err = p.client.AddEventListener(events)
 	if err != nil {
 		return err
 	}
+	Ready.start()
 	for event := range events {
 		debug("pump.Run() event:", normalID(event.ID), event.Status)
 		switch event.Status {
@@ -195,7 +279,18 @@ func (p *LogsPump) Run() error {
 		case "rename":
 			go p.rename(event)
 		case "die":
+			Unstreamable.stopped(event.ID, false)
+			p.exiting(event.ID)
+			p.annotate(event)
 			go p.update(event)
+		case "stop", "oom":
+			p.annotate(event)
+		case "destroy":
+			Unstreamable.stopped(event.ID, true)
+			if p.checkpoints != nil {
+				p.checkpoints.remove(event.ID)
+			}
+			p.attacher.forget(normalID(event.ID))
 		}
 	}
 	return errors.New("docker event stream closed")
@@ -203,6 +298,27 @@ func (p *LogsPump) Run() error {
 
 func (p *LogsPump) pumpLogs(event *docker.APIEvents, backlog bool, inactivityTimeout time.Duration) {
 	id := normalID(event.ID)
+	if Routes.ShuttingDown() {
+		debug("pump.pumpLogs():", id, "ignored: shutting down")
+		return
+	}
+	// containers found running at startup have no event to annotate
+	started := event.Time != 0
+	delayed := false
+	if started {
+		if delay := p.attacher.backoff(id, time.Now()); delay > 0 {
+			debug("pump.pumpLogs():", id, "restarting in a loop, attaching in", delay)
+			time.Sleep(delay)
+			delayed = true
+		}
+	}
+	release := p.attacher.acquire()
+	attaching := false
+	defer func() {
+		if !attaching {
+			release()
+		}
+	}()
 	container, err := p.client.InspectContainer(id)
 	assert(err, "pump")
 	if ignoreContainerTTY(container) {
@@ -213,8 +329,14 @@ func (p *LogsPump) pumpLogs(event *docker.APIEvents, backlog bool, inactivityTim
 		debug("pump.pumpLogs():", id, "ignored: environ ignore")
 		return
 	}
-	if !logDriverSupported(container) {
+	admission, filter := admitContainer(container)
+	if admission.Ignore {
+		debug("pump.pumpLogs():", id, "ignored: container filter", filter)
+		return
+	}
+	if !logDriverStreamable(container) {
 		debug("pump.pumpLogs():", id, "ignored: log driver not supported")
+		p.unstreamable(container, admission)
 		return
 	}
 
@@ -222,14 +344,29 @@ func (p *LogsPump) pumpLogs(event *docker.APIEvents, backlog bool, inactivityTim
 	var sinceTime time.Time
 	if backlog {
 		sinceTime = time.Unix(0, 0)
+	} else if delayed {
+		// read the lines logged while the attachment was delayed
+		sinceTime = time.Unix(event.Time, 0)
 	} else {
 		sinceTime = time.Now()
 	}
+	if p.checkpoints != nil {
+		if since, ok := p.checkpoints.since(container.ID); ok {
+			// resume where logspout last read the container, lines read
+			// before are skipped by the container pump
+			debug("pump.pumpLogs():", id, "resuming from checkpoint", since)
+			sinceTime = since
+			tail = "all"
+		}
+	}
 
 	p.mu.Lock()
 	if _, exists := p.pumps[id]; exists {
 		p.mu.Unlock()
 		debug("pump.pumpLogs():", id, "pump exists")
+		if started {
+			p.annotate(event)
+		}
 		return
 	}
 
@@ -241,56 +378,154 @@ func (p *LogsPump) pumpLogs(event *docker.APIEvents, backlog bool, inactivityTim
 	}
 	outrd, outwr := io.Pipe()
 	errrd, errwr := io.Pipe()
-	p.pumps[id] = newContainerPump(container, outrd, errrd)
+	cp := newContainerPump(container, outrd, errrd, p.checkpoints)
+	cp.routes = admission.Routes
+	p.pumps[id] = cp
 	p.mu.Unlock()
+	tenants.attached(container)
 	p.update(event)
+	if started {
+		p.annotate(event)
+	}
+	fullID := container.ID
+	logDriver := container.HostConfig.LogConfig.Type
+	timestamps := p.checkpoints != nil || dockerTime() || backfill != nil
+	// the attach slot is released once the log stream delivers
+	attaching = true
+	stdout, stderr := settled(outwr, errwr, release)
 	go func() {
+		journal := false
+		exitCode, exitKnown := 0, false
 		for {
 			debug("pump.pumpLogs():", id, "started, tail:", tail)
-			err := p.client.Logs(docker.LogsOptions{
-				Container:         id,
-				OutputStream:      outwr,
-				ErrorStream:       errwr,
-				Stdout:            true,
-				Stderr:            true,
-				Follow:            true,
-				Tail:              tail,
-				Since:             sinceTime.Unix(),
-				InactivityTimeout: inactivityTimeout,
-				RawTerminal:       rawTerminal,
-			})
+			ctx, cancel := context.WithCancel(context.Background())
+			restarted := make(chan time.Time, 1)
+			watched := make(chan struct{})
+			go func() {
+				defer close(watched)
+				select {
+				case since := <-cp.restarts:
+					restarted <- since
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			cp.attach(time.Now())
+			var err error
+			if journal {
+				stop := make(chan struct{})
+				go func() {
+					p.client.WaitContainerWithContext(id, ctx)
+					cancel()
+				}()
+				go func() {
+					<-ctx.Done()
+					close(stop)
+				}()
+				err = journalLogs(fullID, tail, sinceTime, stdout, stderr, stop, timestamps)
+			} else {
+				err = p.client.Logs(docker.LogsOptions{
+					Context:           ctx,
+					Container:         id,
+					OutputStream:      stdout,
+					ErrorStream:       stderr,
+					Stdout:            true,
+					Stderr:            true,
+					Follow:            true,
+					Tail:              tail,
+					Since:             sinceTime.Unix(),
+					InactivityTimeout: inactivityTimeout,
+					RawTerminal:       rawTerminal,
+					Timestamps:        timestamps,
+				})
+			}
+			cancel()
+			<-watched
+			select {
+			case since := <-restarted:
+				debug("pump.pumpLogs():", id, "restarting from", since)
+				sinceTime, tail = since, "all"
+				if p.checkpoints != nil {
+					if checkpoint, ok := p.checkpoints.since(fullID); ok && checkpoint.Before(since) {
+						sinceTime = checkpoint
+					}
+				}
+				continue
+			default:
+			}
 			if err != nil {
 				debug("pump.pumpLogs():", id, "stopped with error:", err)
 			} else {
 				debug("pump.pumpLogs():", id, "stopped")
 			}
 
-			sinceTime = time.Now()
-			if err == docker.ErrInactivityTimeout {
-				sinceTime = sinceTime.Add(-inactivityTimeout)
-			}
+			if !journal && readingUnsupported(err) {
+				// the daemon can't read this log driver back
+				if logDriver == "journald" && logDriverFallback(fallbackJournald) {
+					debug("pump.pumpLogs():", id, "falling back to journald")
+					journal = true
+					continue
+				}
+			} else {
+				sinceTime = time.Now()
+				if err == docker.ErrInactivityTimeout {
+					sinceTime = sinceTime.Add(-inactivityTimeout)
+				}
+				if p.checkpoints != nil {
+					if since, ok := p.checkpoints.since(fullID); ok {
+						sinceTime = since
+						tail = "all"
+					}
+				}
 
-			container, err := p.client.InspectContainer(id)
-			if err != nil {
-				_, four04 := err.(*docker.NoSuchContainer)
-				if !four04 {
-					assert(err, "pump")
+				container, err := p.client.InspectContainer(id)
+				if err == nil {
+					exitCode, exitKnown = container.State.ExitCode, !container.State.Running
+				}
+				if err != nil {
+					_, four04 := err.(*docker.NoSuchContainer)
+					if !four04 {
+						assert(err, "pump")
+					}
+					if p.checkpoints != nil {
+						p.checkpoints.remove(fullID)
+					}
+				} else if container.State.Running {
+					continue
 				}
-			} else if container.State.Running {
-				continue
 			}
 
 			debug("pump.pumpLogs():", id, "dead")
+			p.exiting(id)
 			outwr.Close()
 			errwr.Close()
+			if exitMarker() {
+				cp.exited(exitCode, exitKnown)
+			}
 			p.mu.Lock()
 			delete(p.pumps, id)
 			p.mu.Unlock()
+			tenants.detached(container)
 			return
 		}
 	}()
 }
 
+// exiting sends the remaining output of the container with id ahead of
+// other containers' for EXIT_FLUSH_TIMEOUT
+func (p *LogsPump) exiting(id string) {
+	timeout := exitFlushTimeout()
+	if timeout <= 0 {
+		return
+	}
+	p.mu.Lock()
+	pump, pumping := p.pumps[normalID(id)]
+	p.mu.Unlock()
+	if pumping {
+		pump.exiting(time.Now().Add(timeout))
+	}
+}
+
 func (p *LogsPump) update(event *docker.APIEvents) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -307,6 +542,23 @@ func (p *LogsPump) update(event *docker.APIEvents) {
 	}
 }
 
+// Restart re-attaches to the log stream of the container with id, reading
+// it again from backlog ago. With CHECKPOINTS lines already read are skipped.
+func (p *LogsPump) Restart(id string, backlog time.Duration) error {
+	p.mu.Lock()
+	pump, pumping := p.pumps[normalID(id)]
+	p.mu.Unlock()
+	if !pumping {
+		return ErrNotPumping
+	}
+	select {
+	case pump.restarts <- time.Now().Add(-backlog):
+	default:
+		debug("pump.Restart():", normalID(id), "restart already pending")
+	}
+	return nil
+}
+
 // RoutingFrom returns whether a container id is routing from this pump
 func (p *LogsPump) RoutingFrom(id string) bool {
 	p.mu.Lock()
@@ -319,11 +571,7 @@ func (p *LogsPump) RoutingFrom(id string) bool {
 func (p *LogsPump) Route(route *Route, logstream chan *Message) {
 	p.mu.Lock()
 	for _, pump := range p.pumps {
-		if route.MatchContainer(
-			normalID(pump.container.ID),
-			normalName(pump.container.Name),
-			pump.container.Config.Labels) {
-
+		if pump.admits(route) && routable(route, pump.container) {
 			pump.add(logstream, route)
 			defer pump.remove(logstream)
 		}
@@ -342,11 +590,7 @@ func (p *LogsPump) Route(route *Route, logstream chan *Message) {
 		case event := <-updates:
 			switch event.Status {
 			case "start", "restart":
-				if route.MatchContainer(
-					normalID(event.pump.container.ID),
-					normalName(event.pump.container.Name),
-					event.pump.container.Config.Labels) {
-
+				if event.pump.admits(route) && routable(route, event.pump.container) {
 					event.pump.add(logstream, route)
 					defer event.pump.remove(logstream)
 				}
@@ -364,17 +608,38 @@ func (p *LogsPump) Route(route *Route, logstream chan *Message) {
 }
 
 type containerPump struct {
+	// exit flush deadline, the time the log stream was opened, the time
+	// the last line was read and the time of the last line before the
+	// container was reported silent in unix nanoseconds, first for 64-bit
+	// alignment
+	deadline int64
+	attached int64
+	logged   int64
+	silent   int64
 	sync.Mutex
 	container  *docker.Container
 	logstreams map[chan *Message]*Route
+	readers    sync.WaitGroup
+	restarts   chan time.Time
+	routes     []string // the container filters' route hints, if any
 }
 
-func newContainerPump(container *docker.Container, stdout, stderr io.Reader) *containerPump {
+// newContainerPump returns a containerPump sending the lines read from stdout
+// and stderr. With checkpoints, MESSAGE_TIME=docker or BACKFILL_RATE, lines
+// are expected to be prefixed with their Docker timestamp. Lines read before
+// are skipped with checkpoints, messages take the Docker timestamp with
+// MESSAGE_TIME, and lines logged before the log stream was opened are
+// throttled with BACKFILL_RATE.
+func newContainerPump(container *docker.Container, stdout, stderr io.Reader, checkpoints *checkpoints) *containerPump {
 	cp := &containerPump{
 		container:  container,
 		logstreams: make(map[chan *Message]*Route),
+		restarts:   make(chan time.Time, 1),
+		attached:   time.Now().UnixNano(),
 	}
+	stamped, logged := checkpoints != nil || dockerTime() || backfill != nil, dockerTime()
 	pump := func(source string, input io.Reader) {
+		defer cp.readers.Done()
 		rateLimit := rateLimit()
 		burstLimit := int(rateLimit) * 2
 		limiter := rate.NewLimiter(rateLimit, burstLimit)
@@ -387,18 +652,38 @@ func newContainerPump(container *docker.Container, stdout, stderr io.Reader) *co
 				}
 				return
 			}
-			cp.send(&Message{
-				Data:      strings.TrimSuffix(line, "\n"),
+			data := strings.TrimSuffix(line, "\n")
+			now := time.Now()
+			read, written := now, time.Time{}
+			atomic.StoreInt64(&cp.logged, now.UnixNano())
+			if stamped {
+				if t, rest, ok := splitTimestamp(data); ok {
+					if checkpoints != nil && !checkpoints.advance(container.ID, source, t) {
+						continue
+					}
+					cp.throttleBackfill(t)
+					data = joinPartials(rest)
+					written = t
+					if logged {
+						now = t.Local()
+					}
+				}
+			}
+			message := &Message{
+				Data:      data,
 				Container: container,
-				Time:      time.Now(),
+				Time:      now,
 				Source:    source,
-			})
+			}
+			Traces.Sample(message, written, read)
+			cp.send(message)
 			if rateLimit != rate.Inf {
 				reserve := limiter.Reserve()
 				time.Sleep(reserve.Delay())
 			}
 		}
 	}
+	cp.readers.Add(2)
 	go pump("stdout", stdout)
 	go pump("stderr", stderr)
 	return cp
@@ -407,12 +692,38 @@ func newContainerPump(container *docker.Container, stdout, stderr io.Reader) *co
 func (cp *containerPump) send(msg *Message) {
 	cp.Lock()
 	defer cp.Unlock()
+	deadline, exiting := cp.exitDeadline()
+	if exiting {
+		msg.Exiting = true
+	}
+	if Fanouts.Enabled() {
+		// tag the message with every route it matches before any of them
+		// can report its outcome
+		var routes []*Route
+		for _, route := range cp.logstreams {
+			if route.MatchMessage(msg) {
+				routes = append(routes, route)
+			}
+		}
+		if len(routes) > 0 {
+			Fanouts.Track(msg, routes)
+		}
+	}
 	for logstream, route := range cp.logstreams {
 		if !route.MatchMessage(msg) {
 			continue
 		}
+		if msg.Trace != nil && route.ID != "" {
+			Traces.sent(msg.Trace, route)
+		}
+		if exiting && sendPriority(route, msg, deadline) {
+			continue
+		}
 		logstream <- msg
 	}
+	if msg.Trace != nil {
+		Traces.dispatched(msg.Trace)
+	}
 }
 
 func (cp *containerPump) add(logstream chan *Message, route *Route) {
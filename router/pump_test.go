@@ -3,12 +3,14 @@ package router
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"testing"
+	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
 )
@@ -153,7 +155,7 @@ func TestPumpContainerRename(t *testing.T) {
 		Name:   "foo",
 		Config: config,
 	}
-	p.pumps["8dfafdbc3a40"] = newContainerPump(container, os.Stdout, os.Stderr)
+	p.pumps["8dfafdbc3a40"] = newContainerPump(container, os.Stdout, os.Stderr, nil)
 	if name := p.pumps["8dfafdbc3a40"].container.Name; name != "foo" {
 		t.Errorf("containerPump should have name: 'foo' got name: '%s'", name)
 	}
@@ -171,7 +173,7 @@ func TestPumpNewContainerPump(t *testing.T) {
 		ID:     "8dfafdbc3a40",
 		Config: config,
 	}
-	pump := newContainerPump(container, os.Stdout, os.Stderr)
+	pump := newContainerPump(container, os.Stdout, os.Stderr, nil)
 	if pump == nil {
 		t.Error("pump nil")
 		return
@@ -186,7 +188,7 @@ func TestPumpContainerPump(t *testing.T) {
 		ID:     "8dfafdbc3a40",
 		Config: config,
 	}
-	pump := newContainerPump(container, os.Stdout, os.Stderr)
+	pump := newContainerPump(container, os.Stdout, os.Stderr, nil)
 	logstream, route := make(chan *Message), &Route{}
 	go func() {
 		for msg := range logstream {
@@ -229,3 +231,28 @@ func TestPumpRoutingFrom(t *testing.T) {
 		t.Errorf("expected RoutingFrom to return 'false'")
 	}
 }
+
+func TestPumpRestart(t *testing.T) {
+	container := &docker.Container{ID: "8dfafdbc3a40", Config: &docker.Config{}}
+	outrd, outwr := io.Pipe()
+	errrd, errwr := io.Pipe()
+	defer outwr.Close()
+	defer errwr.Close()
+	p := &LogsPump{pumps: map[string]*containerPump{
+		"8dfafdbc3a40": newContainerPump(container, outrd, errrd, nil),
+	}}
+	if err := p.Restart("0123456789ab", 0); err != ErrNotPumping {
+		t.Errorf("expected ErrNotPumping got %v", err)
+	}
+	if err := p.Restart("8dfafdbc3a40e2f1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// a restart already pending isn't queued twice
+	if err := p.Restart("8dfafdbc3a40", 0); err != nil {
+		t.Fatal(err)
+	}
+	since := <-p.pumps["8dfafdbc3a40"].restarts
+	if ago := time.Since(since); ago < time.Minute || ago > 2*time.Minute {
+		t.Errorf("expected a restart from a minute ago got %s", since)
+	}
+}
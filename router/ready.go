@@ -0,0 +1,148 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxWarmDelay is the longest wait between attempts to warm a route that
+// failed to connect
+const maxWarmDelay = 30 * time.Second
+
+// Warmer is implemented by adapters connecting to their destination lazily,
+// on the first write, to connect before the pump attaches to containers.
+// Adapters that connect when they are created are warm once added.
+type Warmer interface {
+	Warm() error
+}
+
+// Readiness tracks the startup of logspout: the pump warms the connections
+// of routes for up to PREWARM_TIMEOUT before attaching to containers, and
+// logspout is ready once it has attached to the running containers and no
+// route failed to connect
+type Readiness struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	started bool
+	failed  map[string]error
+}
+
+// Ready tracks whether logspout is ready to ship logs, for /ready
+var Ready = &Readiness{failed: make(map[string]error)}
+
+// Ready returns whether logspout has attached to the running containers
+// and every route warmed, and if not, why
+func (r *Readiness) Ready() (bool, []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var reasons []string
+	if !r.started {
+		reasons = append(reasons, "attaching to containers")
+	}
+	for _, id := range sortedRouteIDs(r.failed) {
+		reasons = append(reasons, fmt.Sprintf("route %s: %s", id, r.failed[id]))
+	}
+	return len(reasons) == 0, reasons
+}
+
+// setup reads PREWARM_TIMEOUT, the longest the pump waits for routes to
+// connect before attaching to containers, 0 not to warm them
+func (r *Readiness) setup() error {
+	timeout, err := time.ParseDuration(getopt("PREWARM_TIMEOUT", "0"))
+	if err != nil || timeout < 0 {
+		return errors.New("invalid value for PREWARM_TIMEOUT: " + getopt("PREWARM_TIMEOUT", ""))
+	}
+	r.mu.Lock()
+	r.timeout = timeout
+	r.mu.Unlock()
+	return nil
+}
+
+// warm connects the adapters of routes, waiting up to PREWARM_TIMEOUT.
+// Routes that fail, or are still connecting when it times out, keep
+// logspout from being ready until they connect, trying again with backoff.
+func (r *Readiness) warm(routes []*Route) {
+	r.mu.Lock()
+	timeout := r.timeout
+	r.mu.Unlock()
+	if timeout == 0 {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, route := range routes {
+		warmer, ok := route.adapter.(Warmer)
+		if !ok {
+			continue
+		}
+		r.fail(route.ID, errors.New("connecting"))
+		wg.Add(1)
+		go func(route *Route, warmer Warmer) {
+			err := r.warmRoute(route, warmer)
+			wg.Done()
+			for try := 0; err != nil; try++ {
+				delay := maxWarmDelay
+				if try < 5 {
+					delay = (1 << uint(try)) * time.Second
+				}
+				time.Sleep(delay)
+				if _, gone := Routes.Get(route.ID); gone != nil {
+					// the route was removed
+					r.fail(route.ID, nil)
+					return
+				}
+				err = r.warmRoute(route, warmer)
+			}
+		}(route, warmer)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logger.Warn("routes not warmed, attaching to containers anyway", "timeout", timeout)
+	}
+}
+
+// warmRoute connects the adapter of route, recording whether it failed
+func (r *Readiness) warmRoute(route *Route, warmer Warmer) error {
+	err := warmer.Warm()
+	if err != nil {
+		logger.Warn("warming route failed", "route", route.ID, "adapter", route.Adapter, "error", err)
+	}
+	r.fail(route.ID, err)
+	return err
+}
+
+// fail records why route id isn't warm, or that it is if err is nil
+func (r *Readiness) fail(id string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		delete(r.failed, id)
+	} else {
+		r.failed[id] = err
+	}
+}
+
+// start marks the pump attached to the containers running at startup
+func (r *Readiness) start() {
+	r.mu.Lock()
+	r.started = true
+	r.mu.Unlock()
+}
+
+// sortedRouteIDs returns the route IDs of m in order
+func sortedRouteIDs(m map[string]error) []string {
+	ids := make([]string, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
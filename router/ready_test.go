@@ -0,0 +1,71 @@
+package router
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type warmAdapter struct {
+	delay time.Duration
+	err   error
+}
+
+func (a *warmAdapter) Stream(logstream chan *Message) {}
+
+func (a *warmAdapter) Warm() error {
+	time.Sleep(a.delay)
+	return a.err
+}
+
+func TestReadinessWarmsRoutes(t *testing.T) {
+	os.Setenv("PREWARM_TIMEOUT", "200ms")
+	defer os.Unsetenv("PREWARM_TIMEOUT")
+	r := &Readiness{failed: make(map[string]error)}
+	if err := r.setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	r.warm([]*Route{
+		{ID: "warm", adapter: &warmAdapter{}},
+		{ID: "refused", adapter: &warmAdapter{err: errors.New("connection refused")}},
+		{ID: "slow", adapter: &warmAdapter{delay: time.Hour}},
+		{ID: "dialed", adapter: &DummyAdapter{}},
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected warming to give up after PREWARM_TIMEOUT got %s", elapsed)
+	}
+	ready, reasons := r.Ready()
+	if ready || len(reasons) != 3 || reasons[0] != "attaching to containers" ||
+		reasons[1] != "route refused: connection refused" || reasons[2] != "route slow: connecting" {
+		t.Errorf("expected unwarmed routes keeping logspout from being ready got %v", reasons)
+	}
+
+	r.start()
+	r.fail("refused", nil)
+	r.fail("slow", nil)
+	if ready, reasons := r.Ready(); !ready {
+		t.Errorf("expected ready once routes warmed got %v", reasons)
+	}
+}
+
+func TestReadinessWithoutPrewarm(t *testing.T) {
+	r := &Readiness{failed: make(map[string]error)}
+	if err := r.setup(); err != nil {
+		t.Fatal(err)
+	}
+	r.warm([]*Route{{ID: "refused", adapter: &warmAdapter{err: errors.New("connection refused")}}})
+	r.start()
+	if ready, reasons := r.Ready(); !ready {
+		t.Errorf("expected routes not warmed without PREWARM_TIMEOUT got %v", reasons)
+	}
+
+	os.Setenv("PREWARM_TIMEOUT", "soon")
+	defer os.Unsetenv("PREWARM_TIMEOUT")
+	if err := r.setup(); err == nil || !strings.Contains(err.Error(), "PREWARM_TIMEOUT") {
+		t.Errorf("expected an invalid PREWARM_TIMEOUT to fail got %v", err)
+	}
+}
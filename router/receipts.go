@@ -0,0 +1,171 @@
+package router
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// ReceiptsSource is the message source of routed delivery receipts.
+	// Routes with filter.sources=receipts receive them.
+	ReceiptsSource = "receipts"
+
+	receiptBuffer = 1024
+)
+
+// Receipts is the stream of delivery receipts reported by adapters
+var Receipts = &ReceiptStream{subs: make(map[chan *Receipt]struct{})}
+
+// Receipt records the outcome of delivering one message on a route
+type Receipt struct {
+	Sequence  uint64        `json:"sequence"`
+	Route     string        `json:"route"`
+	Container string        `json:"container,omitempty"`
+	Source    string        `json:"source,omitempty"`
+	Message   string        `json:"message,omitempty"`
+	Matched   []string      `json:"matched,omitempty"`
+	Status    string        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	Category  string        `json:"category,omitempty"`
+	Latency   time.Duration `json:"latency"`
+	Time      time.Time     `json:"time"`
+}
+
+// Receipt statuses
+const (
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+	// StatusDropped is reported for a route that never reported the outcome
+	// of a message it matched within FANOUT_TIMEOUT, such as a message a
+	// processor dropped
+	StatusDropped = "dropped"
+)
+
+// ReceiptStream fans delivery receipts out to its subscribers. Receipts are
+// only built while there are subscribers, and are dropped for subscribers
+// that fall behind rather than slowing down delivery.
+type ReceiptStream struct {
+	mu   sync.RWMutex
+	subs map[chan *Receipt]struct{}
+}
+
+// Subscribe returns a channel receiving all future receipts
+func (rs *ReceiptStream) Subscribe() chan *Receipt {
+	ch := make(chan *Receipt, receiptBuffer)
+	rs.mu.Lock()
+	rs.subs[ch] = struct{}{}
+	rs.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe stops sending receipts to ch
+func (rs *ReceiptStream) Unsubscribe(ch chan *Receipt) {
+	rs.mu.Lock()
+	delete(rs.subs, ch)
+	rs.mu.Unlock()
+}
+
+// Report records that message was delivered on route, or failed with err,
+// counting the message as len(message.Data) bytes
+func (rs *ReceiptStream) Report(route *Route, message *Message, err error) {
+	rs.ReportSize(route, message, len(message.Data), err)
+}
+
+// ReportSize records that message, rendered as size bytes by the adapter,
+// was delivered on route, or failed with err
+func (rs *ReceiptStream) ReportSize(route *Route, message *Message, size int, err error) {
+	Usage.observe(route, message, size, err)
+	if message.Fanout != nil {
+		Fanouts.reported(message.Fanout, route)
+	}
+	Budgets.observe(route, err)
+	Breakers.observe(route, err)
+	Retries.release(route, message)
+	if message.Trace != nil {
+		Traces.reported(message.Trace, route, err)
+	}
+	status := StatusDelivered
+	if err != nil {
+		status = StatusFailed
+	}
+	rs.report(route, message, status, err)
+}
+
+func (rs *ReceiptStream) report(route *Route, message *Message, status string, err error) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	if len(rs.subs) == 0 || message.Source == ReceiptsSource {
+		return
+	}
+	now := time.Now()
+	receipt := &Receipt{
+		Sequence: atomic.AddUint64(&route.receipts, 1),
+		Route:    route.ID,
+		Source:   message.Source,
+		Status:   status,
+		Latency:  now.Sub(message.Time),
+		Time:     now,
+	}
+	if message.Container != nil {
+		receipt.Container = normalID(message.Container.ID)
+	}
+	if message.Fanout != nil {
+		receipt.Message = message.Fanout.ID
+		receipt.Matched = message.Fanout.Matched
+	}
+	if err != nil {
+		receipt.Error = err.Error()
+		receipt.Category = ErrorCategory(err)
+	}
+	for ch := range rs.subs {
+		select {
+		case ch <- receipt:
+		default:
+		}
+	}
+}
+
+// receiptsContainer stands in for a container on receipt messages so
+// adapter templates referring to container fields still render
+func receiptsContainer() *docker.Container {
+	hostname, _ := os.Hostname()
+	return &docker.Container{
+		ID:     hostname,
+		Name:   "/logspout",
+		Config: &docker.Config{Hostname: hostname},
+	}
+}
+
+// routeReceipts sends receipts as JSON messages to logstream until stop is closed
+func routeReceipts(logstream chan *Message, stop <-chan struct{}) {
+	receipts := Receipts.Subscribe()
+	defer Receipts.Unsubscribe(receipts)
+	container := receiptsContainer()
+	for {
+		select {
+		case receipt := <-receipts:
+			data, err := json.Marshal(receipt)
+			if err != nil {
+				continue
+			}
+			message := &Message{
+				Container: container,
+				Source:    ReceiptsSource,
+				Data:      string(data),
+				Time:      receipt.Time,
+			}
+			select {
+			case logstream <- message:
+			case <-stop:
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package router
+
+import (
+	"encoding/json"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestReceiptsReport(t *testing.T) {
+	route := &Route{ID: "abc"}
+	message := &Message{
+		Container: &docker.Container{ID: "8dfafdbc3a40e88e750c4fa10e5cf4d1a6b322a4"},
+		Source:    "stdout",
+		Time:      time.Now(),
+	}
+	// without subscribers no receipts are built
+	Receipts.Report(route, message, nil)
+
+	receipts := Receipts.Subscribe()
+	defer Receipts.Unsubscribe(receipts)
+	Receipts.Report(route, message, nil)
+	Receipts.Report(route, message, os.NewSyscallError("write", syscall.EPIPE))
+	Receipts.Report(route, &Message{Source: ReceiptsSource}, nil)
+
+	delivered := <-receipts
+	if delivered.Sequence != 1 || delivered.Route != "abc" || delivered.Container != "8dfafdbc3a40" || delivered.Status != StatusDelivered {
+		t.Errorf("unexpected receipt %+v", delivered)
+	}
+	failed := <-receipts
+	if failed.Sequence != 2 || failed.Status != StatusFailed || failed.Error != "write: broken pipe" || failed.Category != ErrorConnect {
+		t.Errorf("unexpected receipt %+v", failed)
+	}
+	select {
+	case receipt := <-receipts:
+		t.Errorf("expected no receipt for a receipt message, got %+v", receipt)
+	default:
+	}
+}
+
+func TestRouteReceipts(t *testing.T) {
+	logstream := make(chan *Message)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		routeReceipts(logstream, stop)
+		close(done)
+	}()
+	route := &Route{ID: "abc"}
+	// wait for routeReceipts to subscribe
+	for {
+		Receipts.mu.RLock()
+		subscribed := len(Receipts.subs) > 0
+		Receipts.mu.RUnlock()
+		if subscribed {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	Receipts.Report(route, &Message{Source: "stderr", Time: time.Now()}, nil)
+	message := <-logstream
+	if message.Source != ReceiptsSource || message.Container.Config == nil {
+		t.Errorf("unexpected receipt message %+v", message)
+	}
+	receipt := new(Receipt)
+	if err := json.Unmarshal([]byte(message.Data), receipt); err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Route != "abc" || receipt.Source != "stderr" {
+		t.Errorf("unexpected receipt %+v", receipt)
+	}
+	close(stop)
+	<-done
+}
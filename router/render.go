@@ -0,0 +1,45 @@
+package router
+
+import (
+	"bytes"
+	"sync"
+	"text/template"
+)
+
+// largest buffer kept for reuse, so a few huge messages don't hold on to
+// their memory
+const maxPooledBuffer = 64 * 1024
+
+// renderBuffers are reused by the render paths of adapters, which would
+// otherwise allocate a buffer for every message
+var renderBuffers = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// GetBuffer returns an empty buffer to render a message into, which should
+// be passed to PutBuffer once its bytes are no longer used
+func GetBuffer() *bytes.Buffer {
+	return renderBuffers.Get().(*bytes.Buffer)
+}
+
+// PutBuffer returns buf to the pool of render buffers
+func PutBuffer(buf *bytes.Buffer) {
+	if buf == nil || buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	renderBuffers.Put(buf)
+}
+
+// Render executes tmpl with data into a buffer from the pool, which should
+// be passed to PutBuffer once its bytes are no longer used
+func Render(tmpl *template.Template, data interface{}) (*bytes.Buffer, error) {
+	buf := GetBuffer()
+	if err := tmpl.Execute(buf, data); err != nil {
+		PutBuffer(buf)
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,63 @@
+package router
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+var benchTemplate = template.Must(template.New("bench").Parse("{{.Source}} {{.Time.Unix}} {{.Data}}\n"))
+
+func TestRender(t *testing.T) {
+	buf, err := Render(benchTemplate, &Message{Source: "stdout", Data: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(buf.String(), " hello\n") {
+		t.Errorf("unexpected render %q", buf.String())
+	}
+	PutBuffer(buf)
+	if buf.Len() != 0 {
+		t.Error("expected buffers reset when returned to the pool")
+	}
+	if _, err := Render(template.Must(template.New("bad").Parse("{{.Missing}}")), &Message{}); err == nil {
+		t.Error("expected template errors returned")
+	}
+	// huge buffers aren't kept, nor reset for reuse
+	large := bytes.NewBuffer(make([]byte, 0, 2*maxPooledBuffer))
+	large.WriteString("kept")
+	PutBuffer(large)
+	if large.String() != "kept" {
+		t.Error("expected buffers over the pooled size left alone")
+	}
+}
+
+func benchmarkMessage() *Message {
+	return &Message{Source: "stdout", Data: strings.Repeat("a log line of some length ", 8)}
+}
+
+// BenchmarkRenderAllocating renders like adapters did before render buffers
+// were pooled, for comparison with BenchmarkRender
+func BenchmarkRenderAllocating(b *testing.B) {
+	message := benchmarkMessage()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := new(bytes.Buffer)
+		if err := benchTemplate.Execute(buf, message); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRender(b *testing.B) {
+	message := benchmarkMessage()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, err := Render(benchTemplate, message)
+		if err != nil {
+			b.Fatal(err)
+		}
+		PutBuffer(buf)
+	}
+}
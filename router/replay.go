@@ -0,0 +1,104 @@
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"golang.org/x/time/rate"
+)
+
+// ReplayOptions controls how recorded messages are replayed
+type ReplayOptions struct {
+	// Rate limits replayed messages per second, zero for unlimited
+	Rate float64
+	// KeepTime sends messages with their recorded time instead of now
+	KeepTime bool
+}
+
+// replayContainer fills in recorded messages that have no container
+func replayContainer() *docker.Container {
+	return &docker.Container{
+		ID:     "000000000000",
+		Name:   "/replay",
+		Config: &docker.Config{Hostname: "replay"},
+	}
+}
+
+// Replay feeds newline delimited JSON messages, as written by the raw adapter
+// with RAW_FORMAT='{{ toJSON . }}\n', through the processors and adapter of
+// the route at uri. It returns the number of messages sent once the adapter
+// has finished with them.
+func Replay(uri string, fixtures io.Reader, opts ReplayOptions) (int, error) {
+	rm := &RouteManager{routes: make(map[string]*Route)}
+	if err := rm.AddFromURI(uri); err != nil {
+		return 0, err
+	}
+	routes, _ := rm.GetAll()
+	if len(routes) != 1 {
+		return 0, errors.New("replay: expected a single route: " + uri)
+	}
+	route := routes[0]
+
+	logstream := make(chan *Message)
+	done := make(chan struct{})
+	go func() {
+		route.adapter.Stream(route.Process(logstream))
+		close(done)
+	}()
+
+	var limiter *rate.Limiter
+	if opts.Rate > 0 {
+		// allow 10ms worth of messages at once to smooth over sleep granularity
+		burst := int(opts.Rate / 100)
+		if burst < 1 {
+			burst = 1
+		}
+		limiter = rate.NewLimiter(rate.Limit(opts.Rate), burst)
+	}
+	container := replayContainer()
+	scanner := bufio.NewScanner(fixtures)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	sent, line := 0, 0
+	var err error
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		message := new(Message)
+		if err = json.Unmarshal(scanner.Bytes(), message); err != nil {
+			err = fmt.Errorf("replay: line %v: %s", line, err)
+			break
+		}
+		if message.Container == nil {
+			message.Container = container
+		} else if message.Container.Config == nil {
+			message.Container.Config = container.Config
+		}
+		if message.Source == "" {
+			message.Source = "stdout"
+		}
+		if !opts.KeepTime || message.Time.IsZero() {
+			message.Time = time.Now()
+		}
+		if limiter != nil {
+			time.Sleep(limiter.Reserve().Delay())
+		}
+		logstream <- message
+		sent++
+	}
+	if err == nil {
+		err = scanner.Err()
+	}
+	close(logstream)
+	<-done
+	if closer, ok := route.adapter.(io.Closer); ok {
+		closer.Close()
+	}
+	return sent, err
+}
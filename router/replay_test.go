@@ -0,0 +1,74 @@
+package router
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type collectingAdapter struct {
+	messages []*Message
+}
+
+func (a *collectingAdapter) Stream(logstream chan *Message) {
+	for message := range logstream {
+		a.messages = append(a.messages, message)
+	}
+}
+
+var replayAdapter *collectingAdapter
+
+func TestReplay(t *testing.T) {
+	AdapterFactories.Register(func(route *Route) (LogAdapter, error) {
+		replayAdapter = new(collectingAdapter)
+		return replayAdapter, nil
+	}, "collect")
+	ProcessorFactories.Register(newSuffixProcessor, "suffix")
+	fixtures := strings.Join([]string{
+		`{"Container":{"Name":"/web","Config":{"Hostname":"web1"}},"Source":"stderr","Data":"first","Time":"2017-01-02T15:04:05Z"}`,
+		``,
+		`{"data":"second"}`,
+	}, "\n")
+
+	sent, err := Replay("collect://?processors=suffix&processor.suffix.suffix=!", strings.NewReader(fixtures), ReplayOptions{KeepTime: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 2 || len(replayAdapter.messages) != 2 {
+		t.Fatalf("expected 2 messages got %v sent, %v received", sent, len(replayAdapter.messages))
+	}
+	first, second := replayAdapter.messages[0], replayAdapter.messages[1]
+	if first.Data != "first!" || second.Data != "second!" {
+		t.Errorf("expected processed data got %q and %q", first.Data, second.Data)
+	}
+}
+
+func TestReplayDefaults(t *testing.T) {
+	AdapterFactories.Register(func(route *Route) (LogAdapter, error) {
+		replayAdapter = new(collectingAdapter)
+		return replayAdapter, nil
+	}, "collect")
+	start := time.Now()
+	fixtures := `{"Container":{"Name":"/web"},"Data":"a","Time":"2017-01-02T15:04:05Z"}` + "\n" + `{"Data":"b"}` + "\n" + `{"Data":"c"}`
+	if _, err := Replay("collect://", strings.NewReader(fixtures), ReplayOptions{Rate: 20}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected rate limited replay, took %s", elapsed)
+	}
+	for _, message := range replayAdapter.messages {
+		if message.Container == nil || message.Container.Config == nil || message.Source != "stdout" {
+			t.Errorf("expected container and source defaults, got %+v", message)
+		}
+		if message.Time.Before(start) {
+			t.Errorf("expected message to be sent with the current time, got %s", message.Time)
+		}
+	}
+}
+
+func TestReplayBadFixture(t *testing.T) {
+	AdapterFactories.Register(newDummyAdapter, "dummy")
+	if _, err := Replay("dummy://", strings.NewReader("{\"Data\":\"ok\"}\nnot json"), ReplayOptions{}); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected error on line 2, got %v", err)
+	}
+}
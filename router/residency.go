@@ -0,0 +1,79 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const defaultResidencyLabel = "logspout.residency"
+
+// residencies returns the comma separated values of value, lower cased
+func residencies(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// routeResidencies returns the residencies route is tagged with by its
+// residency option, falling back to RESIDENCY
+func routeResidencies(route *Route) []string {
+	if value, ok := route.Options["residency"]; ok {
+		return residencies(value)
+	}
+	return residencies(getopt("RESIDENCY", ""))
+}
+
+// containerResidencies returns the residencies the logs of container must
+// stay within, from its RESIDENCY_LABEL label, or none if it can be sent
+// anywhere
+func containerResidencies(container *docker.Container) []string {
+	if container == nil || container.Config == nil {
+		return nil
+	}
+	return residencies(container.Config.Labels[getopt("RESIDENCY_LABEL", defaultResidencyLabel)])
+}
+
+// residencyAllows returns whether route may receive the logs of container,
+// which it may unless the container requires residencies the route isn't
+// tagged with any of
+func residencyAllows(route *Route, container *docker.Container) bool {
+	required := containerResidencies(container)
+	if len(required) == 0 {
+		return true
+	}
+	for _, tag := range routeResidencies(route) {
+		if contains(required, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// routable returns whether route matches container and may receive its
+// logs. A match that residency doesn't allow is blocked, logged, counted as
+// residency.blocked and posted to NOTIFY_WEBHOOK.
+func routable(route *Route, container *docker.Container) bool {
+	if !route.MatchContainer(normalID(container.ID), normalName(container.Name), container.Config.Labels) ||
+		!route.MatchImage(container.Config.Image) {
+		return false
+	}
+	if residencyAllows(route, container) {
+		return true
+	}
+	tags := strings.Join(routeResidencies(route), ",")
+	if tags == "" {
+		tags = "none"
+	}
+	message := fmt.Sprintf("blocked routing container %s requiring residency %s to route %s with residency %s",
+		normalID(container.ID), strings.Join(containerResidencies(container), ","), route.ID, tags)
+	NewLogger("residency").Warn(message, "route", route.ID, "container", normalID(container.ID))
+	Counters.Add(route, "residency.blocked", 1)
+	Notify(&Notification{Event: "residency_violation", Route: route.ID, Message: message})
+	return false
+}
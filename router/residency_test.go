@@ -0,0 +1,93 @@
+package router
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func residentContainer(residency string) *docker.Container {
+	labels := map[string]string{}
+	if residency != "" {
+		labels["logspout.residency"] = residency
+	}
+	return &docker.Container{ID: "8dfafdbc3a40", Name: "/app", Config: &docker.Config{Labels: labels}}
+}
+
+func TestResidencyAllows(t *testing.T) {
+	eu := &Route{ID: "eu", Options: map[string]string{"residency": "eu, eea"}}
+	us := &Route{ID: "us", Options: map[string]string{"residency": "us"}}
+	untagged := &Route{ID: "untagged"}
+	cases := []struct {
+		container *docker.Container
+		route     *Route
+		allowed   bool
+	}{
+		{residentContainer(""), untagged, true},
+		{residentContainer(""), us, true},
+		{residentContainer("EU"), eu, true},
+		{residentContainer("ch,eea"), eu, true},
+		{residentContainer("eu"), us, false},
+		{residentContainer("eu"), untagged, false},
+		{nil, us, true},
+	}
+	for _, c := range cases {
+		if got := residencyAllows(c.route, c.container); got != c.allowed {
+			t.Errorf("expected route %s allowed %v for %+v got %v", c.route.ID, c.allowed, c.container, got)
+		}
+	}
+	os.Setenv("RESIDENCY", "eu")
+	defer os.Unsetenv("RESIDENCY")
+	if !residencyAllows(untagged, residentContainer("eu")) {
+		t.Error("expected untagged routes tagged with RESIDENCY")
+	}
+}
+
+func TestResidencyBlocksRouting(t *testing.T) {
+	route := &Route{ID: t.Name(), Options: map[string]string{"residency": "us"}}
+	if routable(route, residentContainer("eu")) {
+		t.Error("expected a container requiring another residency blocked")
+	}
+	if !routable(route, residentContainer("us")) {
+		t.Error("expected a container requiring the route's residency routed")
+	}
+	if n := Counters.Get(route, "residency.blocked"); n != 1 {
+		t.Errorf("expected 1 blocked container counted got %v", n)
+	}
+	filtered := &Route{ID: t.Name() + "-filtered", FilterName: "web*"}
+	if routable(filtered, residentContainer("eu")) || Counters.Get(filtered, "residency.blocked") != 0 {
+		t.Error("expected containers the route doesn't match not to count as violations")
+	}
+}
+
+func TestResidencyKeepsMessagesFromStandby(t *testing.T) {
+	rm := &RouteManager{routes: make(map[string]*Route)}
+	sb := rm.addStandby(&Route{ID: "standby", Options: map[string]string{"residency": "us"}})
+	primary := &Route{ID: "residency-primary", Options: map[string]string{"standby": "standby"}}
+	Latencies.Observe(primary, time.Minute)
+	Latencies.mu.Lock()
+	Latencies.routes[primary.ID].Slow = true
+	Latencies.mu.Unlock()
+	defer func() {
+		Latencies.mu.Lock()
+		delete(Latencies.routes, primary.ID)
+		Latencies.mu.Unlock()
+	}()
+
+	logstream := make(chan *Message)
+	out := make(chan *Message, 1)
+	go rm.failover(primary, logstream, out)
+	message := &Message{Data: "hello", Container: residentContainer("eu")}
+	logstream <- message
+	select {
+	case <-sb.messages:
+		t.Error("expected the message kept from a standby in another residency")
+	case got := <-out:
+		if got != message {
+			t.Errorf("expected the message on the primary got %+v", got)
+		}
+	}
+	close(logstream)
+}
@@ -0,0 +1,190 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var resolveLog = NewLogger("resolve")
+
+// lookupIPAddr looks up the addresses of a host name, replaced by tests
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// DNSRefresh returns how often the host name of a route's address is looked
+// up again while connected, from the dns_refresh route option or
+// DNS_REFRESH, where 0 only looks it up when connecting
+func DNSRefresh(options map[string]string) (time.Duration, error) {
+	value := getopt("DNS_REFRESH", "0")
+	if options["dns_refresh"] != "" {
+		value = options["dns_refresh"]
+	}
+	refresh, err := time.ParseDuration(value)
+	if err != nil || refresh < 0 {
+		return 0, errors.New("invalid value for dns_refresh: " + value)
+	}
+	return refresh, nil
+}
+
+// IPNetwork returns network, tcp or udp, limited to the IP version in the
+// ip_version route option or IP_VERSION, e.g. tcp6 for 6. Without one both
+// IPv4 and IPv6 addresses are dialed.
+func IPNetwork(network string, options map[string]string) (string, error) {
+	value := getopt("IP_VERSION", "")
+	if options["ip_version"] != "" {
+		value = options["ip_version"]
+	}
+	switch value {
+	case "":
+		return network, nil
+	case "4", "6":
+		return network + value, nil
+	}
+	return "", errors.New("invalid value for ip_version (must be 4 or 6): " + value)
+}
+
+// DefaultPort returns addr with port added if it has none. IPv6 addresses
+// must be in brackets, like [2001:db8::1] or [2001:db8::1]:514.
+func DefaultPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	if len(addr) > 1 && addr[0] == '[' && addr[len(addr)-1] == ']' {
+		addr = addr[1 : len(addr)-1]
+	}
+	return net.JoinHostPort(addr, port)
+}
+
+// errAddressChanged fails the writes to connections whose host name no
+// longer resolves to the address they are connected to
+var errAddressChanged = NewDeliveryError(ErrorConnect, errors.New("host name resolves to new addresses"))
+
+// FollowDNS returns conn, looking the host name of addr up again on the
+// first write after every refresh, and reconnecting when it no longer
+// resolves to the address conn is connected to. Datagram connections are
+// redialed with dial, while stream connections are closed and fail the
+// write, so adapters reconnect and resend as after any broken connection.
+// conn is returned as is when refresh is 0 or addr has an IP address.
+func FollowDNS(conn net.Conn, addr string, network string, refresh, timeout time.Duration, dial func() (net.Conn, error)) net.Conn {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || refresh == 0 || net.ParseIP(host) != nil {
+		return conn
+	}
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	return &dnsConn{
+		conn:     conn,
+		host:     host,
+		network:  network,
+		refresh:  refresh,
+		timeout:  timeout,
+		dial:     dial,
+		datagram: Datagram(conn),
+		next:     time.Now().Add(refresh),
+	}
+}
+
+// dnsConn is a connection following the addresses of a host name
+type dnsConn struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	host     string
+	network  string
+	refresh  time.Duration
+	timeout  time.Duration
+	dial     func() (net.Conn, error)
+	datagram bool
+	next     time.Time
+}
+
+// moved returns whether the host no longer resolves to the address the
+// connection is connected to, keeping the connection when the lookup fails
+func (c *dnsConn) moved(remote net.Addr) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	addrs, err := lookupIPAddr(ctx, c.host)
+	if err != nil {
+		resolveLog.Debug("lookup failed, keeping connection", "host", c.host, "error", err)
+		return false
+	}
+	current, _, _ := net.SplitHostPort(remote.String())
+	ip := net.ParseIP(current)
+	found := false
+	for _, addr := range addrs {
+		switch {
+		case c.network[len(c.network)-1] == '4' && addr.IP.To4() == nil:
+		case c.network[len(c.network)-1] == '6' && addr.IP.To4() != nil:
+		default:
+			found = true
+			if addr.IP.Equal(ip) {
+				return false
+			}
+		}
+	}
+	// keep the connection rather than lose the endpoint if no address of the
+	// right version is left
+	return found
+}
+
+// current returns the connection, reconnecting first if the host name was
+// due to be looked up and moved
+func (c *dnsConn) current() (net.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Before(c.next) {
+		return c.conn, nil
+	}
+	c.next = now.Add(c.refresh)
+	if !c.moved(c.conn.RemoteAddr()) {
+		return c.conn, nil
+	}
+	resolveLog.Info("host moved, reconnecting", "host", c.host, "from", c.conn.RemoteAddr())
+	if !c.datagram {
+		c.conn.Close()
+		return nil, errAddressChanged
+	}
+	conn, err := c.dial()
+	if err != nil {
+		// keep sending to the old address until the new ones can be dialed
+		resolveLog.Warn("dialing failed", "host", c.host, "error", err)
+		return c.conn, nil
+	}
+	c.conn.Close()
+	c.conn = conn
+	return conn, nil
+}
+
+func (c *dnsConn) Write(p []byte) (int, error) {
+	conn, err := c.current()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Write(p)
+}
+
+func (c *dnsConn) Read(p []byte) (int, error) {
+	return c.get().Read(p)
+}
+
+// get returns the connection without looking the host name up
+func (c *dnsConn) get() net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+// Datagram reports whether each write is sent as a single datagram
+func (c *dnsConn) Datagram() bool {
+	return c.datagram
+}
+
+func (c *dnsConn) Close() error                       { return c.get().Close() }
+func (c *dnsConn) LocalAddr() net.Addr                { return c.get().LocalAddr() }
+func (c *dnsConn) RemoteAddr() net.Addr               { return c.get().RemoteAddr() }
+func (c *dnsConn) SetDeadline(t time.Time) error      { return c.get().SetDeadline(t) }
+func (c *dnsConn) SetReadDeadline(t time.Time) error  { return c.get().SetReadDeadline(t) }
+func (c *dnsConn) SetWriteDeadline(t time.Time) error { return c.get().SetWriteDeadline(t) }
@@ -0,0 +1,114 @@
+package router
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDefaultPort(t *testing.T) {
+	for addr, expected := range map[string]string{
+		"example.com":          "example.com:514",
+		"example.com:6514":     "example.com:6514",
+		"10.0.0.1":             "10.0.0.1:514",
+		"[2001:db8::1]":        "[2001:db8::1]:514",
+		"[2001:db8::1]:6514":   "[2001:db8::1]:6514",
+		"[fe80::1%eth0]":       "[fe80::1%eth0]:514",
+		"[fe80::1%eth0]:10514": "[fe80::1%eth0]:10514",
+	} {
+		if value := DefaultPort(addr, "514"); value != expected {
+			t.Errorf("expected %s for %s got %s", expected, addr, value)
+		}
+	}
+}
+
+func TestResolveOptions(t *testing.T) {
+	if network, err := IPNetwork("tcp", map[string]string{"ip_version": "6"}); err != nil || network != "tcp6" {
+		t.Errorf("expected tcp6 got %s %v", network, err)
+	}
+	if network, err := IPNetwork("udp", map[string]string{}); err != nil || network != "udp" {
+		t.Errorf("expected udp got %s %v", network, err)
+	}
+	if _, err := IPNetwork("tcp", map[string]string{"ip_version": "5"}); err == nil {
+		t.Error("expected an error for ip_version 5")
+	}
+	if _, err := DNSRefresh(map[string]string{"dns_refresh": "-1s"}); err == nil {
+		t.Error("expected an error for a negative dns_refresh")
+	}
+}
+
+func TestFollowDNS(t *testing.T) {
+	defer func() { lookupIPAddr = net.DefaultResolver.LookupIPAddr }()
+	first, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	ip := net.ParseIP("127.0.0.1")
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+	dialed := first.LocalAddr().String()
+	dial := func() (net.Conn, error) { return net.Dial("udp", dialed) }
+	c, _ := dial()
+	conn := FollowDNS(c, "logs.example.com:514", "udp", time.Hour, 0, dial)
+	if conn == c {
+		t.Fatal("expected the connection to follow the host name")
+	}
+	if !Datagram(conn) {
+		t.Error("expected a datagram connection")
+	}
+
+	// the host still resolves to the address connected to
+	conn.(*dnsConn).next = time.Time{}
+	dialed = second.LocalAddr().String()
+	conn.Write([]byte("one"))
+	// the host moved
+	ip = net.ParseIP("10.0.0.1")
+	conn.(*dnsConn).next = time.Time{}
+	conn.Write([]byte("two"))
+
+	buf := make([]byte, 16)
+	for _, test := range []struct {
+		listener net.PacketConn
+		expected string
+	}{{first, "one"}, {second, "two"}} {
+		test.listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := test.listener.ReadFrom(buf)
+		if err != nil || string(buf[:n]) != test.expected {
+			t.Errorf("expected %s got %q %v", test.expected, buf[:n], err)
+		}
+	}
+
+	if FollowDNS(c, "127.0.0.1:514", "udp", time.Hour, 0, dial) != c {
+		t.Error("expected IP addresses not to be followed")
+	}
+}
+
+func TestFollowDNSStream(t *testing.T) {
+	defer func() { lookupIPAddr = net.DefaultResolver.LookupIPAddr }()
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}}, nil
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := FollowDNS(c, "logs.example.com:514", "tcp", time.Hour, 0, nil)
+	conn.(*dnsConn).next = time.Time{}
+	if _, err := conn.Write([]byte("line\n")); ErrorCategory(err) != ErrorConnect {
+		t.Errorf("expected a connect error got %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package router
+
+import "time"
+
+const (
+	minRetryDelay = 100 * time.Millisecond
+	maxRetryDelay = 10 * time.Second
+)
+
+// RetryDelay returns how long adapters wait before retrying a failed write
+// for the try-th time, counting from 0: 100ms, doubling up to 10s
+func RetryDelay(try int) time.Duration {
+	delay := minRetryDelay
+	for i := 0; i < try && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// WaitRetry waits delay before route's adapter writes messages again,
+// holding them in the retry WAL and counting the retry in the route's
+// retry budget
+func WaitRetry(route *Route, delay time.Duration, messages ...*Message) {
+	Retries.Hold(route, messages...)
+	Budgets.Retried(route)
+	time.Sleep(delay)
+}
@@ -0,0 +1,20 @@
+package router
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	for try, expected := range map[int]time.Duration{
+		0:   100 * time.Millisecond,
+		1:   200 * time.Millisecond,
+		6:   6400 * time.Millisecond,
+		7:   10 * time.Second,
+		100: 10 * time.Second,
+	} {
+		if delay := RetryDelay(try); delay != expected {
+			t.Errorf("try %d: expected %s got %s", try, expected, delay)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// ops of retry WAL records
+const (
+	walHold = "hold"
+	walDone = "done"
+)
+
+var (
+	walLog = NewLogger("retrywal")
+
+	errWALFull = errors.New("retry WAL is full")
+)
+
+// walRecord is a line of the retry WAL: a message held for a route while its
+// adapter retries it, or the end of the hold with the same ID
+type walRecord struct {
+	Op      string   `json:"op"`
+	ID      uint64   `json:"id"`
+	Route   string   `json:"route,omitempty"`
+	Message *Message `json:"message,omitempty"`
+}
+
+// RetryWAL journals the messages adapters are retrying to a write-ahead log
+// on disk, so those a restart interrupts are sent to their routes again once
+// logspout is back. Messages are held from an adapter's first retry until
+// the route reports their outcome.
+type RetryWAL struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+	next    uint64
+	dirty   bool
+	// held is the ID of each message held, by route
+	held map[string]map[*Message]uint64
+	// records is the holds not ended yet, kept when the WAL is compacted
+	records map[uint64]*walRecord
+	// pending is the holds of the last run not replayed yet, by route
+	pending map[string][]*walRecord
+}
+
+// Retries is the retry WAL, enabled with RETRY_WAL_PATH
+var Retries = &RetryWAL{}
+
+func init() {
+	Jobs.Register(Retries, "retrywal")
+}
+
+// Enabled returns whether retried messages are journaled
+func (w *RetryWAL) Enabled() bool {
+	return w.path != ""
+}
+
+// Name returns the name of the job, if it's enabled
+func (w *RetryWAL) Name() string {
+	if !w.Enabled() {
+		return ""
+	}
+	return "retrywal"
+}
+
+// Setup opens the WAL at RETRY_WAL_PATH, recovering the holds the last run
+// didn't end to replay them once their routes start
+func (w *RetryWAL) Setup() error {
+	path := getopt("RETRY_WAL_PATH", "")
+	if path == "" {
+		return nil
+	}
+	value := getopt("RETRY_WAL_MAX_SIZE", "64M")
+	maxSize, err := parseSize(value)
+	if err != nil || maxSize == 0 {
+		return errors.New("invalid value for RETRY_WAL_MAX_SIZE: " + value)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.path, w.maxSize = path, maxSize
+	w.held = make(map[string]map[*Message]uint64)
+	w.records = make(map[uint64]*walRecord)
+	w.pending = make(map[string][]*walRecord)
+	if err := w.recover(); err != nil {
+		return errors.New("retry WAL: " + err.Error())
+	}
+	if err := w.compact(); err != nil {
+		return errors.New("retry WAL: " + err.Error())
+	}
+	return nil
+}
+
+// Run syncs the WAL to disk every second it was written to
+func (w *RetryWAL) Run() error {
+	if !w.Enabled() {
+		select {}
+	}
+	for range time.Tick(time.Second) {
+		w.mu.Lock()
+		if w.dirty {
+			if err := w.file.Sync(); err != nil {
+				walLog.Error("can't sync", "path", w.path, "error", err)
+			}
+			w.dirty = false
+		}
+		w.mu.Unlock()
+	}
+	return nil
+}
+
+// Hold journals messages route's adapter is about to retry, until the route
+// reports their outcome. Messages already held are left as they are. While
+// the WAL is full, messages are retried without being held.
+func (w *RetryWAL) Hold(route *Route, messages ...*Message) {
+	if !w.Enabled() {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	held, ok := w.held[route.ID]
+	if !ok {
+		held = make(map[*Message]uint64)
+		w.held[route.ID] = held
+	}
+	for _, message := range messages {
+		if _, ok := held[message]; ok {
+			continue
+		}
+		record := &walRecord{Op: walHold, ID: w.next, Route: route.ID, Message: walMessage(message)}
+		if err := w.append(record); err != nil {
+			if err == errWALFull {
+				Counters.Add(route, "retrywal.full", 1)
+			} else {
+				walLog.Error("can't hold message", "route", route.ID, "error", err)
+			}
+			continue
+		}
+		w.next++
+		held[message] = record.ID
+		w.records[record.ID] = record
+		Counters.Add(route, "retrywal.held", 1)
+	}
+}
+
+// release ends the hold of message for route, if it's held
+func (w *RetryWAL) release(route *Route, message *Message) {
+	if !w.Enabled() {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	id, ok := w.held[route.ID][message]
+	if !ok {
+		return
+	}
+	delete(w.held[route.ID], message)
+	delete(w.records, id)
+	if err := w.append(&walRecord{Op: walDone, ID: id}); err != nil {
+		walLog.Error("can't release message", "route", route.ID, "error", err)
+	}
+}
+
+// replay returns the messages the last run held for route, holding them
+// again until the route reports their outcome
+func (w *RetryWAL) replay(route *Route) []*Message {
+	if !w.Enabled() {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	records := w.pending[route.ID]
+	if len(records) == 0 {
+		return nil
+	}
+	delete(w.pending, route.ID)
+	held, ok := w.held[route.ID]
+	if !ok {
+		held = make(map[*Message]uint64)
+		w.held[route.ID] = held
+	}
+	messages := make([]*Message, len(records))
+	for i, record := range records {
+		held[record.Message] = record.ID
+		messages[i] = record.Message
+	}
+	Counters.Add(route, "retrywal.replayed", uint64(len(messages)))
+	walLog.Info("replaying held messages", "route", route.ID, "messages", len(messages))
+	return messages
+}
+
+// drop ends the holds of the last run for routes not in routes, like those
+// removed, or with IDs that changed, while logspout was down
+func (w *RetryWAL) drop(routes map[string]*Route) {
+	if !w.Enabled() {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id, records := range w.pending {
+		if _, ok := routes[id]; ok {
+			continue
+		}
+		delete(w.pending, id)
+		for _, record := range records {
+			delete(w.records, record.ID)
+			if err := w.append(&walRecord{Op: walDone, ID: record.ID}); err != nil {
+				walLog.Error("can't drop message", "route", id, "error", err)
+			}
+		}
+		walLog.Warn("dropped held messages of a route that no longer exists", "route", id, "messages", len(records))
+	}
+}
+
+// recover reads the holds of the last run that weren't ended. Records that
+// don't match their checksum or don't parse, like the one a crash cut short,
+// are skipped.
+func (w *RetryWAL) recover() error {
+	file, err := os.Open(w.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var order []uint64
+	corrupted := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		record, ok := parseWALRecord(scanner.Bytes())
+		if !ok {
+			corrupted++
+			continue
+		}
+		if record.ID >= w.next {
+			w.next = record.ID + 1
+		}
+		switch {
+		case record.Op == walHold && record.Message != nil:
+			w.records[record.ID] = record
+			order = append(order, record.ID)
+		case record.Op == walDone:
+			delete(w.records, record.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		// an overlong line ends the WAL like a corrupted one
+		walLog.Warn("can't read the rest of the WAL", "path", w.path, "error", err)
+	}
+	if corrupted > 0 {
+		walLog.Warn("skipped corrupted records", "path", w.path, "records", corrupted)
+	}
+	for _, id := range order {
+		if record, ok := w.records[id]; ok {
+			w.pending[record.Route] = append(w.pending[record.Route], record)
+		}
+	}
+	if len(w.records) > 0 {
+		walLog.Info("recovered held messages", "path", w.path, "messages", len(w.records), "routes", len(w.pending))
+	}
+	return nil
+}
+
+// compact rewrites the WAL with only the holds not ended, replacing it
+// once the new one is on disk
+func (w *RetryWAL) compact() error {
+	tmp := w.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	ids := make([]uint64, 0, len(w.records))
+	for id := range w.records {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	var size int64
+	for _, id := range ids {
+		line, err := formatWALRecord(w.records[id])
+		if err != nil {
+			continue
+		}
+		writer.Write(line)
+		size += int64(len(line))
+	}
+	if err = writer.Flush(); err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, w.path); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.file, w.size, w.dirty = file, size, false
+	return nil
+}
+
+// append writes record to the WAL, compacting it first if the record would
+// take it over its maximum size. Ends of holds compaction drops need no
+// record.
+func (w *RetryWAL) append(record *walRecord) error {
+	line, err := formatWALRecord(record)
+	if err != nil {
+		return err
+	}
+	if w.size+int64(len(line)) > w.maxSize {
+		if err := w.compact(); err != nil {
+			return err
+		}
+		if record.Op == walDone {
+			return nil
+		}
+		if w.size+int64(len(line)) > w.maxSize {
+			return errWALFull
+		}
+	}
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	w.dirty = true
+	return err
+}
+
+// formatWALRecord returns the line of record: the CRC-32 of its JSON in hex,
+// and the JSON
+func formatWALRecord(record *walRecord) ([]byte, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(data), data)), nil
+}
+
+// parseWALRecord returns the record of a line, and whether it's intact
+func parseWALRecord(line []byte) (*walRecord, bool) {
+	if len(line) < 10 || line[8] != ' ' {
+		return nil, false
+	}
+	sum, err := strconv.ParseUint(string(line[:8]), 16, 32)
+	if err != nil || uint32(sum) != crc32.ChecksumIEEE(line[9:]) {
+		return nil, false
+	}
+	record := new(walRecord)
+	if err := json.Unmarshal(line[9:], record); err != nil {
+		return nil, false
+	}
+	return record, true
+}
+
+// walMessage returns message with only the container metadata adapters
+// render, so records stay small. Of the container's environment, which may
+// hold secrets, only the variables of EXPOSE_CONTAINER_ENV are kept.
+func walMessage(message *Message) *Message {
+	held := *message
+	if c := message.Container; c != nil {
+		held.Container = &docker.Container{
+			ID:      c.ID,
+			Name:    c.Name,
+			Image:   c.Image,
+			Created: c.Created,
+			Node:    c.Node,
+		}
+		if c.Config != nil {
+			config := &docker.Config{
+				Hostname: c.Config.Hostname,
+				Image:    c.Config.Image,
+				Labels:   c.Config.Labels,
+			}
+			names := exposedEnv()
+			for _, kv := range c.Config.Env {
+				if names[strings.SplitN(kv, "=", 2)[0]] {
+					config.Env = append(config.Env, kv)
+				}
+			}
+			held.Container.Config = config
+		}
+	}
+	return &held
+}
+
+// replayHeld passes the messages the last run held for route to out before
+// those of logstream. It closes out once logstream is closed.
+func replayHeld(held []*Message, logstream, out chan *Message) {
+	for _, message := range held {
+		out <- message
+	}
+	for message := range logstream {
+		out <- message
+	}
+	close(out)
+}
+
+// parseSize parses a size in bytes, with an optional K, M or G suffix for
+// KiB, MiB or GiB
+func parseSize(value string) (int64, error) {
+	if value == "" {
+		return 0, errors.New("invalid size")
+	}
+	multiplier := int64(1)
+	switch strings.ToUpper(value[len(value)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0, errors.New("invalid size")
+	}
+	return size * multiplier, nil
+}
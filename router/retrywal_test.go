@@ -0,0 +1,254 @@
+package router
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func openRetryWAL(t *testing.T, path string) *RetryWAL {
+	os.Setenv("RETRY_WAL_PATH", path)
+	defer os.Unsetenv("RETRY_WAL_PATH")
+	w := &RetryWAL{}
+	if err := w.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func heldMessage(data string) *Message {
+	return &Message{
+		Container: &docker.Container{ID: "abc", Name: "/app", Config: &docker.Config{
+			Image: "app:1",
+			Env:   []string{"SERVICE=checkout", "DB_PASSWORD=hunter2"},
+		}},
+		Source: "stdout",
+		Data:   data,
+		Time:   time.Date(2018, time.March, 5, 9, 8, 7, 0, time.UTC),
+	}
+}
+
+func TestRetryWALReplaysAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrywal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "retry.wal")
+	route := &Route{ID: "abc123"}
+
+	w := openRetryWAL(t, path)
+	delivered, retrying := heldMessage("one"), heldMessage("two")
+	w.Hold(route, delivered, retrying)
+	w.Hold(route, retrying)
+	w.release(route, delivered)
+	w.file.Close()
+
+	w = openRetryWAL(t, path)
+	if held := w.replay(&Route{ID: "other"}); len(held) != 0 {
+		t.Errorf("expected nothing held for another route got %v", len(held))
+	}
+	held := w.replay(route)
+	if len(held) != 1 || held[0].Data != "two" || held[0].Container.Config.Image != "app:1" || !held[0].Time.Equal(retrying.Time) {
+		t.Fatalf("expected the message still retried replayed got %+v", held)
+	}
+	if len(w.replay(route)) != 0 {
+		t.Error("expected messages replayed once")
+	}
+	// the replayed message is held until the route reports it
+	w.file.Close()
+	w = openRetryWAL(t, path)
+	if len(w.pending[route.ID]) != 1 {
+		t.Fatal("expected the replayed message held until reported")
+	}
+	held = w.replay(route)
+	w.release(route, held[0])
+	w.file.Close()
+	if w = openRetryWAL(t, path); len(w.pending) != 0 {
+		t.Errorf("expected nothing held once reported got %v", w.pending)
+	}
+	w.file.Close()
+}
+
+func TestRetryWALReplaysURIRouteAfterRestart(t *testing.T) {
+	AdapterFactories.Register(newDummyAdapter, "dummy")
+	dir, err := ioutil.TempDir("", "retrywal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "retry.wal")
+	defer func(args []string) { os.Args = args }(os.Args)
+	os.Args = os.Args[:1]
+	os.Setenv("ROUTE_URIS", "dummy://localhost:1234,dummy://localhost:1234")
+	defer os.Unsetenv("ROUTE_URIS")
+	os.Setenv("ROUTESPATH", filepath.Join(dir, "routes"))
+	defer os.Unsetenv("ROUTESPATH")
+	start := func() []*Route {
+		rm := &RouteManager{routes: make(map[string]*Route)}
+		if err := rm.Setup(); err != nil {
+			t.Fatal(err)
+		}
+		routes, _ := rm.GetAll()
+		if len(routes) != 2 {
+			t.Fatalf("expected a route for each URI got %v", len(routes))
+		}
+		return routes
+	}
+
+	routes := start()
+	w := openRetryWAL(t, path)
+	for _, route := range routes {
+		w.Hold(route, heldMessage(route.Options[envNamespaceOption]))
+	}
+	w.file.Close()
+
+	w = openRetryWAL(t, path)
+	defer w.file.Close()
+	for _, route := range start() {
+		held := w.replay(route)
+		if len(held) != 1 || held[0].Data != route.Options[envNamespaceOption] {
+			t.Errorf("expected the message held for %s replayed got %+v", route.Options[envNamespaceOption], held)
+		}
+	}
+}
+
+func TestRetryWALDropsRemovedRoutes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrywal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "retry.wal")
+	kept, removed := &Route{ID: "kept"}, &Route{ID: "removed"}
+
+	w := openRetryWAL(t, path)
+	w.Hold(kept, heldMessage("one"))
+	w.Hold(removed, heldMessage("two"))
+	w.file.Close()
+
+	w = openRetryWAL(t, path)
+	w.drop(map[string]*Route{kept.ID: kept})
+	if len(w.pending) != 1 || len(w.pending[kept.ID]) != 1 {
+		t.Errorf("expected only the held messages of existing routes kept got %v", w.pending)
+	}
+	w.file.Close()
+	w = openRetryWAL(t, path)
+	defer w.file.Close()
+	if len(w.records) != 1 || len(w.pending[removed.ID]) != 0 {
+		t.Errorf("expected dropped messages gone after restart got %v", w.pending)
+	}
+}
+
+func TestRetryWALKeepsSecretsOut(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrywal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "retry.wal")
+	os.Setenv("EXPOSE_CONTAINER_ENV", "SERVICE")
+	defer os.Unsetenv("EXPOSE_CONTAINER_ENV")
+	route := &Route{ID: "abc123"}
+
+	w := openRetryWAL(t, path)
+	w.Hold(route, heldMessage("one"))
+	w.file.Close()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("hunter2")) || !bytes.Contains(data, []byte("SERVICE=checkout")) {
+		t.Errorf("expected only exposed env vars in the WAL got %s", data)
+	}
+}
+
+func TestRetryWALSkipsCorruptedRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrywal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "retry.wal")
+	route := &Route{ID: "abc123"}
+
+	w := openRetryWAL(t, path)
+	w.Hold(route, heldMessage("one"), heldMessage("two"))
+	w.file.Close()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a flipped byte in the first record, garbage, and a record cut short
+	data[20] ^= 1
+	data = append(data, "garbage\n"...)
+	data = append(data, data[:30]...)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	w = openRetryWAL(t, path)
+	held := w.replay(route)
+	if len(held) != 1 || held[0].Data != "two" {
+		t.Fatalf("expected the intact record recovered got %+v", held)
+	}
+	// holds after recovery don't reuse the IDs of recovered ones
+	w.Hold(route, heldMessage("three"))
+	w.file.Close()
+	w = openRetryWAL(t, path)
+	if held = w.replay(route); len(held) != 2 || held[0].Data != "two" || held[1].Data != "three" {
+		t.Errorf("expected both holds recovered got %+v", held)
+	}
+	w.file.Close()
+}
+
+func TestRetryWALMaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retrywal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "retry.wal")
+	os.Setenv("RETRY_WAL_MAX_SIZE", "1K")
+	defer os.Unsetenv("RETRY_WAL_MAX_SIZE")
+	route := &Route{ID: "walfull"}
+
+	w := openRetryWAL(t, path)
+	defer w.file.Close()
+	var messages []*Message
+	for i := 0; i < 20; i++ {
+		messages = append(messages, heldMessage("message"))
+	}
+	w.Hold(route, messages...)
+	full := Counters.Get(route, "retrywal.full")
+	if full == 0 || Counters.Get(route, "retrywal.held")+full != 20 {
+		t.Fatalf("expected holds refused once full got %v held %v full",
+			Counters.Get(route, "retrywal.held"), full)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() > 1024 {
+		t.Fatalf("expected the WAL kept under its maximum size got %v", info.Size())
+	}
+	// released holds are compacted away to make room
+	for _, message := range messages {
+		w.release(route, message)
+	}
+	w.Hold(route, heldMessage("again"))
+	if Counters.Get(route, "retrywal.full") != full {
+		t.Error("expected room once holds were released")
+	}
+
+	os.Setenv("RETRY_WAL_MAX_SIZE", "lots")
+	if err := (&RetryWAL{}).Setup(); err != nil {
+		t.Error("expected RETRY_WAL_MAX_SIZE ignored without RETRY_WAL_PATH")
+	}
+	os.Setenv("RETRY_WAL_PATH", path)
+	defer os.Unsetenv("RETRY_WAL_PATH")
+	if err := (&RetryWAL{}).Setup(); err == nil {
+		t.Error("expected an invalid RETRY_WAL_MAX_SIZE to fail")
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/url"
 	"os"
 	"strconv"
@@ -18,7 +17,10 @@ import (
 var Routes *RouteManager
 
 func init() {
-	Routes = &RouteManager{routes: make(map[string]*Route)}
+	Routes = &RouteManager{
+		routes: make(map[string]*Route),
+		stop:   make(chan struct{}),
+	}
 	Jobs.Register(Routes, "routes")
 }
 
@@ -28,7 +30,10 @@ type RouteManager struct {
 	persistor RouteStore
 	routes    map[string]*Route
 	routing   bool
+	stopping  bool
+	stop      chan struct{}
 	wg        sync.WaitGroup
+	standbys  map[string]*standby
 }
 
 // Load loads all route from a RouteStore
@@ -71,10 +76,11 @@ func (rm *RouteManager) Remove(id string) bool {
 	rm.Lock()
 	defer rm.Unlock()
 	route, ok := rm.routes[id]
-	if ok && route.closer != nil {
-		route.closer <- true
+	if ok && route.stop != nil {
+		close(route.stop)
 	}
 	delete(rm.routes, id)
+	Breakers.remove(id)
 	if rm.persistor != nil {
 		rm.persistor.Remove(id)
 	}
@@ -83,48 +89,108 @@ func (rm *RouteManager) Remove(id string) bool {
 
 // AddFromURI creates a new route from an URI string and adds it to the RouteManager
 func (rm *RouteManager) AddFromURI(uri string) error {
+	r, err := parseRouteURI(uri)
+	if err != nil {
+		return err
+	}
+	return rm.Add(r)
+}
+
+// parseRouteURI returns the route configured by an URI string
+func parseRouteURI(uri string) (*Route, error) {
 	expandedRoute := os.ExpandEnv(uri)
 	u, err := url.Parse(expandedRoute)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	r := &Route{
 		Address: u.Host,
 		Adapter: u.Scheme,
 		Options: make(map[string]string),
 	}
+	// adapters like pubsub://project/topic address more than a host
+	if u.Path != "" && u.Path != "/" {
+		r.Address += u.Path
+	}
 	if u.RawQuery != "" {
 		params, err := url.ParseQuery(u.RawQuery)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		for key := range params {
 			value := params.Get(key)
 			switch key {
+			case "add_field":
+				// e.g. add_field=env:production&add_field=dc:us-east-1
+				r.Options[key] = strings.Join(params[key], ",")
+			case "id":
+				r.ID = value
 			case "filter.id":
 				r.FilterID = value
 			case "filter.name":
 				r.FilterName = value
 			case "filter.labels":
 				r.FilterLabels = strings.Split(value, ",")
+			case "filter.images":
+				r.FilterImages = strings.Split(value, ",")
 			case "filter.sources":
 				r.FilterSources = strings.Split(value, ",")
+			case "processors":
+				parseProcessors(r, value)
 			default:
 				r.Options[key] = value
 			}
 		}
+		parseProcessorOptions(r)
 	}
-	return rm.Add(r)
+	return r, nil
 }
 
 // Add adds a route to the RouteManager
 func (rm *RouteManager) Add(route *Route) error {
+	return rm.add(route, true)
+}
+
+// add adds a route to the RouteManager, and to its persistor if persist is
+// set and it has one
+func (rm *RouteManager) add(route *Route, persist bool) error {
 	rm.Lock()
 	defer rm.Unlock()
+	if rm.stopping {
+		return errors.New("shutting down")
+	}
 	factory, found := AdapterFactories.Lookup(route.AdapterType())
 	if !found {
 		return errors.New("bad adapter: " + route.Adapter)
 	}
+	// validate the route before the factory connects its adapter
+	processors, err := newProcessors(route)
+	if err != nil {
+		return err
+	}
+	breaker, err := newRouteBreaker(route)
+	if err != nil {
+		return err
+	}
+	size, err := newSizeLimit(route)
+	if err != nil {
+		return err
+	}
+	fields, err := StaticFields(route)
+	if err != nil {
+		return err
+	}
+	sequence, err := sequenceEnabled(route)
+	if err != nil {
+		return err
+	}
+	schedule, err := newSchedule(route)
+	if err != nil {
+		return err
+	}
+	if err := validFilters(route); err != nil {
+		return err
+	}
 	adapter, err := factory(route)
 	if err != nil {
 		return err
@@ -135,29 +201,143 @@ func (rm *RouteManager) Add(route *Route) error {
 		route.ID = fmt.Sprintf("%x", h.Sum(nil))[:12]
 	}
 	route.closer = make(chan bool)
+	route.stop = make(chan struct{})
 	route.adapter = adapter
+	route.processors = processors
+	route.breaker = breaker
+	route.size = size
+	route.fields = fields
+	route.sequence = sequence
+	route.schedule = schedule
+	if breaker != nil {
+		Breakers.register(route.ID, breaker)
+	}
 	//Stop any existing route with this ID:
-	if rm.routes[route.ID] != nil {
-		rm.routes[route.ID].closer <- true
+	if existing := rm.routes[route.ID]; existing != nil && existing.stop != nil {
+		close(existing.stop)
 	}
 
 	rm.routes[route.ID] = route
-	if rm.persistor != nil {
+	if persist && rm.persistor != nil {
 		if err := rm.persistor.Add(route); err != nil {
-			log.Println("persistor:", err)
+			persistLog.Error("storing route failed", "route", route.ID, "error", err)
 		}
 	}
 	if rm.routing {
-		go rm.route(route)
+		rm.wg.Add(1)
+		go func() {
+			rm.route(route)
+			rm.wg.Done()
+		}()
 	}
 	return nil
 }
 
 func (rm *RouteManager) route(route *Route) {
 	logstream := make(chan *Message)
-	defer route.Close()
-	rm.Route(route, logstream)
-	route.adapter.Stream(logstream)
+	if exitFlushTimeout() > 0 {
+		route.priority = make(chan *Message)
+	}
+	routed := make(chan struct{})
+	var routers sync.WaitGroup
+	for _, router := range LogRouters.All() {
+		routers.Add(1)
+		go func(router LogRouter) {
+			router.Route(route, logstream)
+			routers.Done()
+		}(router)
+	}
+	streamed := make(chan struct{})
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-rm.stop:
+		case <-route.stop:
+		case <-streamed:
+		}
+		close(stop)
+	}()
+	if contains(route.FilterSources, ReceiptsSource) {
+		routers.Add(1)
+		go func() {
+			routeReceipts(logstream, stop)
+			routers.Done()
+		}()
+	}
+	sb := rm.addStandby(route)
+	routers.Add(1)
+	go func() {
+		routeStandby(logstream, sb, stop)
+		rm.removeStandby(route.ID, sb)
+		routers.Done()
+	}()
+	go func() {
+		routers.Wait()
+		close(routed)
+	}()
+	go func() {
+		// the route stops when logspout shuts down or it is removed
+		select {
+		case <-rm.stop:
+		case <-route.stop:
+		}
+		// once the routers have stopped sending, closing the logstream
+		// lets the processors and adapter flush what they have buffered
+		select {
+		case route.closer <- true:
+			<-routed
+		case <-routed:
+		}
+		close(logstream)
+	}()
+	input := logstream
+	if route.priority != nil {
+		input = make(chan *Message)
+		go prioritize(route.priority, logstream, input)
+	}
+	if route.schedule != nil {
+		scheduled := make(chan *Message)
+		go rm.schedule(route, input, scheduled)
+		input = scheduled
+	}
+	if route.Options["standby"] != "" {
+		failover := make(chan *Message)
+		go rm.failover(route, input, failover)
+		input = failover
+	}
+	if route.breaker != nil {
+		breaker := make(chan *Message)
+		go rm.breaker(route, input, breaker)
+		input = breaker
+	}
+	output := route.Process(input)
+	if route.size != nil {
+		limited := make(chan *Message)
+		go limitSize(route, output, limited)
+		output = limited
+	}
+	// messages the last run was retrying go to the adapter first
+	if held := Retries.replay(route); len(held) > 0 {
+		replayed := make(chan *Message)
+		go replayHeld(held, output, replayed)
+		output = replayed
+	}
+	if Traces.Enabled() {
+		traced := make(chan *Message)
+		go traceReceived(route, output, traced)
+		output = traced
+	}
+	route.adapter.Stream(output)
+	close(streamed)
+	if closer, ok := route.adapter.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			debug("routes: closing adapter:", err)
+		}
+	}
+	select {
+	case route.closer <- true:
+	case <-routed:
+	}
 }
 
 // Route takes a logstream and route and passes them off to all configure LogRouters
@@ -180,6 +360,8 @@ func (rm *RouteManager) RoutingFrom(containerID string) bool {
 // Run executes the RouteManager
 func (rm *RouteManager) Run() error {
 	rm.Lock()
+	// held messages of routes that are gone would never be replayed
+	Retries.drop(rm.routes)
 	for _, route := range rm.routes {
 		rm.wg.Add(1)
 		go func(route *Route) {
@@ -191,17 +373,70 @@ func (rm *RouteManager) Run() error {
 	rm.Unlock()
 	rm.wg.Wait()
 	// Temp fix to allow logspout to run without routes defined.
-	if len(rm.routes) == 0 {
+	if len(rm.routes) == 0 || rm.ShuttingDown() {
 		select {}
 	}
 	return nil
 }
 
+// ShuttingDown returns whether Shutdown has been called
+func (rm *RouteManager) ShuttingDown() bool {
+	rm.Lock()
+	defer rm.Unlock()
+	return rm.stopping
+}
+
+// Shutdown stops routing and waits up to timeout for every route to flush
+// its buffered messages to its adapter
+func (rm *RouteManager) Shutdown(timeout time.Duration) error {
+	rm.Lock()
+	if !rm.stopping {
+		rm.stopping = true
+		close(rm.stop)
+	}
+	rm.Unlock()
+	done := make(chan struct{})
+	go func() {
+		rm.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("routes not flushed after %s", timeout)
+	}
+}
+
 // Name returns the name of the RouteManager
 func (rm *RouteManager) Name() string {
 	return "routes"
 }
 
+// splitRouteURIs splits a comma separated list of route URIs. Parts without
+// a scheme continue the previous URI, so routes can list several addresses
+// like syslog+tcp://a:514,b:514?mode=failover.
+func splitRouteURIs(uris string) []string {
+	var split []string
+	for _, part := range strings.Split(uris, ",") {
+		if len(split) > 0 && !strings.Contains(part, "://") {
+			split[len(split)-1] += "," + part
+			continue
+		}
+		split = append(split, part)
+	}
+	return split
+}
+
+// uriRouteID returns the ID of the route at position n in ROUTE_URIS,
+// derived from its URI so that it, and the messages the retry WAL holds for
+// it, are the same across restarts
+func uriRouteID(uri string, n int) string {
+	h := sha1.New()
+	io.WriteString(h, strconv.Itoa(n)+" "+uri)
+	return fmt.Sprintf("%x", h.Sum(nil))[:12]
+}
+
 // Setup configures the RouteManager
 func (rm *RouteManager) Setup() error {
 	var uris string
@@ -212,14 +447,24 @@ func (rm *RouteManager) Setup() error {
 		uris = os.Args[1]
 	}
 	if uris != "" {
-		for _, uri := range strings.Split(uris, ",") {
-			err := rm.AddFromURI(uri)
+		for i, uri := range splitRouteURIs(uris) {
+			route, err := parseRouteURI(uri)
 			if err != nil {
 				return err
 			}
+			setEnvNamespace(route, i+1)
+			if route.ID == "" {
+				route.ID = uriRouteID(uri, i+1)
+			}
+			if err := rm.Add(route); err != nil {
+				return err
+			}
 		}
 	}
 
+	if routesFile := getopt("ROUTES_FILE", ""); routesFile != "" {
+		return rm.Load(NewRouteJSONFile(routesFile))
+	}
 	persistPath := getopt("ROUTESPATH", "/mnt/routes")
 	if _, err := os.Stat(persistPath); err == nil {
 		return rm.Load(RouteFileStore(persistPath))
@@ -3,6 +3,7 @@ package router
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 type DummyAdapter struct{}
@@ -53,7 +54,142 @@ func TestRouterNoDuplicateIds(t *testing.T) {
 	}
 	Routes.Add(route2)
 
+	// the replaced route stops in the background
+	for deadline := time.Now().Add(time.Second); !route1.closed && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
 	if !route1.closed {
 		t.Errorf("route1 was not closed after route2 added.")
 	}
 }
+
+func TestRouteInvalidOptionsNotDialed(t *testing.T) {
+	dialed := 0
+	AdapterFactories.Register(func(route *Route) (LogAdapter, error) {
+		dialed++
+		return &DummyAdapter{}, nil
+	}, "dialing")
+	rm := &RouteManager{routes: make(map[string]*Route)}
+	for _, options := range []map[string]string{
+		{"max_message_size": "8k"},
+		{"breaker_failures": "none"},
+		{"sequence": "maybe"},
+	} {
+		if err := rm.Add(&Route{Adapter: "dialing", Options: options}); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+	if dialed != 0 {
+		t.Errorf("expected invalid routes not to create their adapter got %d", dialed)
+	}
+}
+
+func TestRouteAddressFromURI(t *testing.T) {
+	AdapterFactories.Register(newDummyAdapter, "dummy")
+	for uri, expected := range map[string]string{
+		"dummy://host:514":          "host:514",
+		"dummy://host:514/":         "host:514",
+		"dummy://project/topic?x=y": "project/topic",
+	} {
+		rm := &RouteManager{routes: make(map[string]*Route)}
+		if err := rm.AddFromURI(uri); err != nil {
+			t.Fatal(err)
+		}
+		routes, _ := rm.GetAll()
+		if routes[0].Address != expected {
+			t.Errorf("%s: expected address %s got %s", uri, expected, routes[0].Address)
+		}
+	}
+}
+
+func TestRouteTemplateOverride(t *testing.T) {
+	tests := []struct {
+		option   string
+		expected string
+	}{
+		{"", ""},
+		// {{.Data}}\n
+		{"e3suRGF0YX19Cg==", "{{.Data}}\n"},
+		{"e3suRGF0YX19Cg", "{{.Data}}\n"},
+		// "{{.Data}} >>" with the + decoded to a space by the query parser
+		{"e3suRGF0YX19ID4 ", "{{.Data}} >>"},
+	}
+	for _, test := range tests {
+		route := &Route{Options: map[string]string{"template": test.option}}
+		tmpl, err := route.TemplateOverride()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tmpl != test.expected {
+			t.Errorf("expected %q got %q", test.expected, tmpl)
+		}
+	}
+
+	route := &Route{Options: map[string]string{"template": "{{.Data}}"}}
+	if _, err := route.TemplateOverride(); err == nil {
+		t.Error("expected error for template that isn't base64")
+	}
+}
+
+type closingAdapter struct {
+	streamed chan bool
+	closed   chan bool
+}
+
+func (a *closingAdapter) Stream(logstream chan *Message) {
+	for range logstream {
+	}
+	a.streamed <- true
+}
+
+func (a *closingAdapter) Close() error {
+	a.closed <- true
+	return nil
+}
+
+func TestRouteManagerShutdown(t *testing.T) {
+	AdapterFactories.Register(func(route *Route) (LogAdapter, error) {
+		return &closingAdapter{make(chan bool, 1), make(chan bool, 1)}, nil
+	}, "closing")
+	rm := &RouteManager{routes: make(map[string]*Route), stop: make(chan struct{}), routing: true}
+	route := &Route{Adapter: "closing"}
+	if err := rm.Add(route); err != nil {
+		t.Fatal(err)
+	}
+	adapter := route.adapter.(*closingAdapter)
+	if err := rm.Shutdown(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-adapter.streamed:
+	default:
+		t.Error("expected adapter logstream to be closed")
+	}
+	select {
+	case <-adapter.closed:
+	default:
+		t.Error("expected adapter to be closed")
+	}
+	if err := rm.Add(&Route{Adapter: "closing"}); err == nil {
+		t.Error("expected error adding a route while shutting down")
+	}
+}
+
+type stuckAdapter struct{}
+
+func (a *stuckAdapter) Stream(logstream chan *Message) {
+	select {}
+}
+
+func TestRouteManagerShutdownTimeout(t *testing.T) {
+	AdapterFactories.Register(func(route *Route) (LogAdapter, error) {
+		return &stuckAdapter{}, nil
+	}, "stuck")
+	rm := &RouteManager{routes: make(map[string]*Route), stop: make(chan struct{}), routing: true}
+	if err := rm.Add(&Route{Adapter: "stuck"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rm.Shutdown(10 * time.Millisecond); err == nil {
+		t.Error("expected timeout error")
+	}
+}
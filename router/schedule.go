@@ -0,0 +1,232 @@
+package router
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// the states a route can be switched to through the routes API, overriding
+// its schedule
+const (
+	ScheduleAuto     = "auto"
+	ScheduleActive   = "active"
+	ScheduleInactive = "inactive"
+)
+
+var scheduleStates = []string{ScheduleAuto, ScheduleActive, ScheduleInactive}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// schedule is when a route sends messages, and where the messages outside
+// its windows go
+type schedule struct {
+	windows  []window // none for always
+	location *time.Location
+	fallback string
+	// state is the index in scheduleStates the route was switched to
+	state int32
+}
+
+// window is a daily span of minutes on some days of the week. Spans ending
+// before they start run past midnight, into the next day.
+type window struct {
+	days       [7]bool
+	start, end int
+}
+
+// newSchedule returns the schedule of a route, or nil if it has none: the
+// comma separated windows of the schedule option, falling back to SCHEDULE,
+// like Mon-Fri 09:00-17:00, in the timezone of schedule_timezone or
+// SCHEDULE_TIMEZONE, with the messages outside them sent to the route with
+// the ID in schedule_fallback or SCHEDULE_FALLBACK, or dropped
+func newSchedule(route *Route) (*schedule, error) {
+	value := RouteOpt(route, "schedule", "SCHEDULE", "")
+	if value == "" {
+		return nil, nil
+	}
+	s := &schedule{
+		location: time.Local,
+		fallback: RouteOpt(route, "schedule_fallback", "SCHEDULE_FALLBACK", ""),
+	}
+	if value != "always" {
+		for _, spec := range strings.Split(value, ",") {
+			w, err := parseWindow(strings.TrimSpace(spec))
+			if err != nil {
+				return nil, errors.New("invalid value for schedule (must be windows like Mon-Fri 09:00-17:00, or always): " + spec)
+			}
+			s.windows = append(s.windows, w)
+		}
+	}
+	if timezone := RouteOpt(route, "schedule_timezone", "SCHEDULE_TIMEZONE", ""); timezone != "" {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, errors.New("invalid value for schedule_timezone: " + timezone)
+		}
+		s.location = location
+	}
+	if s.fallback == route.ID && s.fallback != "" {
+		return nil, errors.New("invalid value for schedule_fallback: a route can't fall back to itself")
+	}
+	return s, nil
+}
+
+// parseWindow parses a window like 09:00-17:00 every day, or Mon-Fri
+// 09:00-17:00 or Sat 10:00-14:00
+func parseWindow(spec string) (w window, err error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return w, errors.New("empty window")
+	}
+	span := fields[len(fields)-1]
+	switch len(fields) {
+	case 1:
+		for i := range w.days {
+			w.days[i] = true
+		}
+	case 2:
+		days := strings.SplitN(strings.ToLower(fields[0]), "-", 2)
+		first, ok := weekdays[days[0]]
+		last := first
+		if len(days) == 2 {
+			var found bool
+			last, found = weekdays[days[1]]
+			ok = ok && found
+		}
+		if !ok {
+			return w, errors.New("invalid days: " + fields[0])
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			w.days[day] = true
+			if day == last {
+				break
+			}
+		}
+	default:
+		return w, errors.New("invalid window: " + spec)
+	}
+	times := strings.SplitN(span, "-", 2)
+	if len(times) != 2 {
+		return w, errors.New("invalid span: " + span)
+	}
+	if w.start, err = parseClock(times[0]); err != nil {
+		return w, err
+	}
+	if w.end, err = parseClock(times[1]); err != nil {
+		return w, err
+	}
+	if w.start == w.end {
+		return w, errors.New("empty span: " + span)
+	}
+	return w, nil
+}
+
+// parseClock returns the minutes after midnight of a time like 09:30, or
+// 24:00 for the end of a day
+func parseClock(s string) (int, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, errors.New("invalid time: " + s)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, errors.New("invalid time: " + s)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || hours < 0 || minutes < 0 || minutes > 59 || hours*60+minutes > 24*60 {
+		return 0, errors.New("invalid time: " + s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// contains returns whether the window includes the minute of the day and
+// weekday
+func (w window) contains(day time.Weekday, minute int) bool {
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	// the part before midnight, or the part after it of the day before
+	return w.days[day] && minute >= w.start || w.days[(day+6)%7] && minute < w.end
+}
+
+// active returns whether the route sends its messages at t, as switched
+// through the routes API or else as its windows say
+func (s *schedule) active(t time.Time) bool {
+	switch scheduleStates[atomic.LoadInt32(&s.state)] {
+	case ScheduleActive:
+		return true
+	case ScheduleInactive:
+		return false
+	}
+	if len(s.windows) == 0 {
+		return true
+	}
+	t = t.In(s.location)
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range s.windows {
+		if w.contains(t.Weekday(), minute) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScheduleState returns the state the route was switched to through the
+// routes API, and whether it is active now, or an error if it has no
+// schedule
+func (r *Route) ScheduleState() (string, bool, error) {
+	if r.schedule == nil {
+		return "", false, errors.New("route has no schedule")
+	}
+	return scheduleStates[atomic.LoadInt32(&r.schedule.state)], r.schedule.active(time.Now()), nil
+}
+
+// SetScheduleState switches a route with a schedule active or inactive
+// regardless of its windows, or back to following them with auto. The
+// state isn't persisted, so routes follow their windows again on restart.
+func (r *Route) SetScheduleState(state string) error {
+	if r.schedule == nil {
+		return errors.New("route has no schedule")
+	}
+	for i, s := range scheduleStates {
+		if s == state {
+			atomic.StoreInt32(&r.schedule.state, int32(i))
+			return nil
+		}
+	}
+	return errors.New("invalid schedule state (must be auto, active or inactive): " + state)
+}
+
+var errOutsideSchedule = NewDeliveryError(ErrorDropped, errors.New("message outside the schedule of the route"))
+
+// schedule passes messages from logstream to out while the route is active,
+// and otherwise to its fallback route, unless the fallback's residency
+// doesn't allow the message, or drops them. It closes out once logstream is
+// closed.
+func (rm *RouteManager) schedule(route *Route, logstream, out chan *Message) {
+	s := route.schedule
+	for message := range logstream {
+		if s.active(time.Now()) {
+			out <- message
+			continue
+		}
+		if s.fallback != "" {
+			if sb := rm.getStandby(s.fallback); sb != nil && residencyAllows(sb.route, message.Container) {
+				select {
+				case sb.messages <- message:
+					Counters.Add(route, "schedule.redirected", 1)
+					continue
+				case <-sb.done:
+				}
+			}
+		}
+		Counters.Add(route, "schedule.dropped", 1)
+		Receipts.Report(route, message, errOutsideSchedule)
+	}
+	close(out)
+}
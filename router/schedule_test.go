@@ -0,0 +1,98 @@
+package router
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	w, err := parseWindow("Fri-Mon 22:00-06:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.days != [7]bool{true, true, false, false, false, true, true} || w.start != 22*60 || w.end != 6*60+30 {
+		t.Errorf("unexpected window %+v", w)
+	}
+	for _, spec := range []string{"", "09:00", "Mon", "Mon-Funday 09:00-17:00", "09:00-09:00", "09:00-25:00", "9-17", "Mon Tue 09:00-17:00"} {
+		if _, err := parseWindow(spec); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
+
+func TestScheduleActive(t *testing.T) {
+	route := &Route{ID: "scheduled", Options: map[string]string{
+		"schedule":          "Mon-Fri 09:00-17:00, Sat 22:00-02:00",
+		"schedule_timezone": "America/New_York",
+	}}
+	s, err := newSchedule(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.schedule = s
+	ny, _ := time.LoadLocation("America/New_York")
+	for _, test := range []struct {
+		time   time.Time
+		active bool
+	}{
+		{time.Date(2024, 1, 8, 9, 0, 0, 0, ny), true},   // Monday
+		{time.Date(2024, 1, 8, 17, 0, 0, 0, ny), false}, // Monday
+		{time.Date(2024, 1, 8, 13, 59, 0, 0, time.UTC), false},
+		{time.Date(2024, 1, 8, 14, 0, 0, 0, time.UTC), true},
+		{time.Date(2024, 1, 13, 23, 0, 0, 0, ny), true}, // Saturday
+		{time.Date(2024, 1, 14, 1, 0, 0, 0, ny), true},  // Sunday
+		{time.Date(2024, 1, 14, 3, 0, 0, 0, ny), false},
+	} {
+		if active := s.active(test.time); active != test.active {
+			t.Errorf("%v: expected %v got %v", test.time, test.active, active)
+		}
+	}
+
+	monday := time.Date(2024, 1, 8, 12, 0, 0, 0, ny)
+	if err := route.SetScheduleState(ScheduleInactive); err != nil || s.active(monday) {
+		t.Errorf("expected the route switched inactive got %v", err)
+	}
+	if err := route.SetScheduleState(ScheduleAuto); err != nil || !s.active(monday) {
+		t.Errorf("expected the route to follow its windows got %v", err)
+	}
+	if err := route.SetScheduleState("paused"); err == nil {
+		t.Error("expected an error for an invalid state")
+	}
+	if err := (&Route{}).SetScheduleState(ScheduleActive); err == nil {
+		t.Error("expected an error for a route without a schedule")
+	}
+}
+
+func TestScheduleOptions(t *testing.T) {
+	if s, err := newSchedule(&Route{}); s != nil || err != nil {
+		t.Errorf("expected no schedule got %v %v", s, err)
+	}
+	if s, err := newSchedule(&Route{Options: map[string]string{"schedule": "always"}}); err != nil || !s.active(time.Now()) {
+		t.Errorf("expected an always active schedule got %v", err)
+	}
+	for _, options := range []map[string]string{
+		{"schedule": "weekdays"},
+		{"schedule": "09:00-17:00", "schedule_timezone": "Mars/Olympus"},
+		{"schedule": "09:00-17:00", "schedule_fallback": "scheduled"},
+	} {
+		if _, err := newSchedule(&Route{ID: "scheduled", Options: options}); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+}
+
+func TestScheduleRedirects(t *testing.T) {
+	route := &Route{ID: "scheduled", Options: map[string]string{"schedule": "always"}}
+	route.schedule, _ = newSchedule(route)
+	route.schedule.fallback = "cheap"
+	route.SetScheduleState(ScheduleInactive)
+	rm := &RouteManager{}
+	logstream := make(chan *Message, 1)
+	out := make(chan *Message, 1)
+	logstream <- &Message{Data: "line"}
+	close(logstream)
+	rm.schedule(route, logstream, out)
+	if _, ok := <-out; ok {
+		t.Error("expected the message dropped without a fallback route")
+	}
+}
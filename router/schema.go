@@ -0,0 +1,87 @@
+package router
+
+import (
+	"errors"
+
+	"github.com/gliderlabs/logspout/internal/jsonschema"
+)
+
+// RouteSchema validates the JSON payloads an adapter renders for a route
+// against the JSON Schema file in the route's json_schema option, or
+// JSON_SCHEMA
+type RouteSchema struct {
+	route  *Route
+	schema *jsonschema.Schema
+}
+
+// NewRouteSchema returns the schema of route, or nil if it has none
+func NewRouteSchema(route *Route) (*RouteSchema, error) {
+	path := route.Options["json_schema"]
+	if path == "" {
+		path = getopt("JSON_SCHEMA", "")
+	}
+	if path == "" {
+		return nil, nil
+	}
+	schema, err := jsonschema.Load(path)
+	if err != nil {
+		return nil, errors.New("invalid json_schema: " + err.Error())
+	}
+	return &RouteSchema{route: route, schema: schema}, nil
+}
+
+// Valid returns whether payload, rendered for message, is valid against the
+// schema. Invalid messages are counted as schema.invalid, reported as failed
+// with the serialization category, and sent to the dead letter route.
+func (rs *RouteSchema) Valid(message *Message, payload []byte) bool {
+	if rs == nil {
+		return true
+	}
+	err := rs.schema.Validate(payload)
+	if err == nil {
+		return true
+	}
+	err = NewDeliveryError(ErrorSerialization, err)
+	Counters.Add(rs.route, "schema.invalid", 1)
+	if !DeadLetter(rs.route, message, err) {
+		LogDeliveryError("schema", err)
+	}
+	Receipts.Report(rs.route, message, err)
+	return false
+}
+
+// DeadLetter sends message, which route failed to deliver with err, to the
+// route named by its dead_letter option, or DEAD_LETTER, with the error in
+// the dead_letter_error field. It returns whether the message was sent,
+// which it isn't without a running dead letter route, or one whose residency
+// doesn't allow it.
+func DeadLetter(route *Route, message *Message, err error) bool {
+	id := route.Options["dead_letter"]
+	if id == "" {
+		id = getopt("DEAD_LETTER", "")
+	}
+	if id == "" || id == route.ID || Routes == nil {
+		return false
+	}
+	sb := Routes.getStandby(id)
+	if sb == nil || !residencyAllows(sb.route, message.Container) {
+		debug("dead letter: route", id, "not found for", route.ID)
+		return false
+	}
+	dead := message.Copy()
+	// the dead letter route reports its own receipts
+	dead.Fanout = nil
+	if dead.Fields == nil {
+		dead.Fields = make(map[string]string, 2)
+	}
+	dead.Fields["dead_letter_route"] = route.ID
+	dead.Fields["dead_letter_error"] = err.Error()
+	select {
+	case sb.messages <- dead:
+		Counters.Add(route, "dead_letter.sent", 1)
+		return true
+	case <-sb.done:
+		NewLogger("deadletter").Warn("dead letter route stopped", "route", id)
+		return false
+	}
+}
@@ -0,0 +1,70 @@
+package router
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRouteSchemaDeadLetters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "schema.json")
+	ioutil.WriteFile(path, []byte(`{"type": "object", "required": ["level"]}`), 0644)
+
+	saved := Routes
+	Routes = &RouteManager{routes: make(map[string]*Route)}
+	defer func() { Routes = saved }()
+	sb := Routes.addStandby(&Route{ID: "dead"})
+
+	route := &Route{ID: t.Name(), Options: map[string]string{"json_schema": path, "dead_letter": "dead"}}
+	schema, err := NewRouteSchema(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := &Message{Data: `{"message": "started"}`}
+	if !schema.Valid(message, []byte(`{"level": "info"}`)) {
+		t.Error("expected a payload matching the schema valid")
+	}
+	invalid := make(chan bool)
+	go func() { invalid <- !schema.Valid(message, []byte(message.Data)) }()
+	dead := <-sb.messages
+	if !<-invalid {
+		t.Error("expected a payload missing a required property invalid")
+	}
+	if dead.Data != message.Data || dead.Fields["dead_letter_route"] != route.ID ||
+		dead.Fields["dead_letter_error"] != `jsonschema: /: missing required property "level"` {
+		t.Errorf("unexpected dead letter %+v", dead)
+	}
+	if message.Fields != nil {
+		t.Error("expected the original message, shared by routes, left unchanged")
+	}
+	if Counters.Get(route, "schema.invalid") != 1 || Counters.Get(route, "dead_letter.sent") != 1 {
+		t.Error("expected the invalid and dead lettered message counted")
+	}
+
+	// without a running dead letter route the message is only dropped
+	route.Options["dead_letter"] = "missing"
+	if schema.Valid(message, []byte(message.Data)) || Counters.Get(route, "dead_letter.sent") != 1 {
+		t.Error("expected an invalid message dropped without a dead letter route")
+	}
+	var none *RouteSchema
+	if !none.Valid(message, []byte("not json")) {
+		t.Error("expected everything valid without a schema")
+	}
+}
+
+func TestRouteSchemaInvalid(t *testing.T) {
+	for _, path := range []string{"/does/not/exist.json", os.DevNull} {
+		if _, err := NewRouteSchema(&Route{Options: map[string]string{"json_schema": path}}); err == nil {
+			t.Errorf("expected an error for json_schema=%s", path)
+		}
+	}
+	if schema, err := NewRouteSchema(&Route{}); schema != nil || err != nil {
+		t.Errorf("expected no schema by default got %v %v", schema, err)
+	}
+}
@@ -0,0 +1,68 @@
+package router
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// SequenceField is the message field sequence numbers are set in
+const SequenceField = "seq"
+
+// sequenceIdle is how long the sequence of a container without messages is
+// remembered, after which it starts from 1 again
+const sequenceIdle = 24 * time.Hour
+
+// sequenceEnabled returns whether route numbers its messages, set with the
+// sequence option, falling back to SEQUENCE
+func sequenceEnabled(route *Route) (bool, error) {
+	value := RouteOpt(route, "sequence", "SEQUENCE", "false")
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New("invalid value for sequence: " + value)
+	}
+	return enabled, nil
+}
+
+type sequenceCounter struct {
+	last uint64
+	seen time.Time
+}
+
+// sequence passes messages from logstream to out with the seq field set to
+// the number of messages of their container the route has passed, so
+// receivers can tell messages lost or reordered on the way from gaps and
+// out of order numbers. It closes out once logstream is closed.
+func sequence(logstream, out chan *Message) {
+	counters := make(map[string]*sequenceCounter)
+	swept := time.Now()
+	for message := range logstream {
+		now := time.Now()
+		if now.Sub(swept) >= time.Hour {
+			for id, counter := range counters {
+				if now.Sub(counter.seen) >= sequenceIdle {
+					delete(counters, id)
+				}
+			}
+			swept = now
+		}
+		var id string
+		if message.Container != nil {
+			id = message.Container.ID
+		}
+		counter := counters[id]
+		if counter == nil {
+			counter = new(sequenceCounter)
+			counters[id] = counter
+		}
+		counter.last++
+		counter.seen = now
+		message = message.Copy()
+		if message.Fields == nil {
+			message.Fields = make(map[string]string, 1)
+		}
+		message.Fields[SequenceField] = strconv.FormatUint(counter.last, 10)
+		out <- message
+	}
+	close(out)
+}
@@ -0,0 +1,38 @@
+package router
+
+import (
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestSequenceEnabled(t *testing.T) {
+	if enabled, err := sequenceEnabled(&Route{Options: map[string]string{"sequence": "true"}}); err != nil || !enabled {
+		t.Errorf("expected sequence enabled got %v %v", enabled, err)
+	}
+	if enabled, err := sequenceEnabled(&Route{Options: map[string]string{}}); err != nil || enabled {
+		t.Errorf("expected sequence disabled by default got %v %v", enabled, err)
+	}
+	if _, err := sequenceEnabled(&Route{Options: map[string]string{"sequence": "maybe"}}); err == nil {
+		t.Error("expected an error for sequence=maybe")
+	}
+}
+
+func TestSequence(t *testing.T) {
+	a, b := &docker.Container{ID: "a"}, &docker.Container{ID: "b"}
+	original := &Message{Container: a, Data: "1"}
+	in, out := make(chan *Message, 4), make(chan *Message, 4)
+	for _, message := range []*Message{original, {Container: a}, {Container: b}, {Container: a}} {
+		in <- message
+	}
+	close(in)
+	sequence(in, out)
+	for _, expected := range []string{"1", "2", "1", "3"} {
+		if message := <-out; message.Fields[SequenceField] != expected {
+			t.Errorf("expected seq %s got %v", expected, message.Fields)
+		}
+	}
+	if original.Fields != nil {
+		t.Errorf("expected the shared message unchanged got %v", original.Fields)
+	}
+}
@@ -0,0 +1,89 @@
+package router
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// SilenceSource is the source of the warning sent when a running container
+// stops logging for longer than SILENCE_TIMEOUT
+const SilenceSource = "silence"
+
+// silenceTimeout returns SILENCE_TIMEOUT, how long a running container that
+// logged before can go without logging before it is reported as silent
+func silenceTimeout() (time.Duration, error) {
+	value := getopt("SILENCE_TIMEOUT", "0")
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, errors.New("invalid value for SILENCE_TIMEOUT: " + value)
+	}
+	return timeout, nil
+}
+
+// watchSilence reports the containers that went silent for longer than
+// timeout, checking a few times within it
+func (p *LogsPump) watchSilence(timeout time.Duration) {
+	interval := timeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		p.checkSilence(timeout, now)
+	}
+}
+
+// checkSilence warns about each container that logged before but not for
+// longer than timeout while Docker still reports it running, which is what
+// a log stream that silently died looks like. A container is reported once
+// until it logs again.
+func (p *LogsPump) checkSilence(timeout time.Duration, now time.Time) {
+	p.mu.Lock()
+	var silent []*containerPump
+	for _, cp := range p.pumps {
+		if cp.silentFor(now) > timeout {
+			silent = append(silent, cp)
+		}
+	}
+	p.mu.Unlock()
+	for _, cp := range silent {
+		container, err := p.client.InspectContainer(cp.container.ID)
+		if err != nil || !container.State.Running {
+			continue
+		}
+		cp.silenced(now)
+	}
+}
+
+// silentFor returns how long the container hasn't logged for, or 0 if it
+// never logged or was reported since it last logged
+func (cp *containerPump) silentFor(now time.Time) time.Duration {
+	logged := atomic.LoadInt64(&cp.logged)
+	if logged == 0 || atomic.LoadInt64(&cp.silent) == logged {
+		return 0
+	}
+	return now.Sub(time.Unix(0, logged))
+}
+
+// silenced sends the warning that the container has gone silent, counting
+// it as pump.silent on each of its routes
+func (cp *containerPump) silenced(now time.Time) {
+	logged := atomic.LoadInt64(&cp.logged)
+	atomic.StoreInt64(&cp.silent, logged)
+	silence := now.Sub(time.Unix(0, logged)).Round(time.Second)
+	logger.Warn("container stopped logging while running", "container", normalID(cp.container.ID), "silent_for", silence)
+	cp.Lock()
+	for _, route := range cp.logstreams {
+		Counters.Add(route, "pump.silent", 1)
+	}
+	cp.Unlock()
+	cp.send(&Message{
+		Data:      "no logs for " + silence.String() + " while the container is running",
+		Container: cp.container,
+		Time:      now,
+		Source:    SilenceSource,
+		Fields:    map[string]string{"silent_for": silence.String()},
+	})
+}
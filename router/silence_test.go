@@ -0,0 +1,78 @@
+package router
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestCheckSilence(t *testing.T) {
+	running := &docker.Container{ID: "8dfafdbc3a40", State: docker.State{Running: true}}
+	client := newTestClient(&FakeRoundTripper{message: running, status: http.StatusOK})
+	p := &LogsPump{client: &client, pumps: make(map[string]*containerPump)}
+	container := &docker.Container{ID: "8dfafdbc3a40", Config: &docker.Config{}}
+	cp := newContainerPump(container, strings.NewReader(""), strings.NewReader(""), nil)
+	p.pumps["8dfafdbc3a40"] = cp
+	logstream := make(chan *Message, 2)
+	route := &Route{ID: "silence"}
+	cp.add(logstream, route)
+
+	now := time.Now()
+	// containers that never logged aren't reported
+	p.checkSilence(time.Minute, now)
+	atomic.StoreInt64(&cp.logged, now.Add(-2*time.Minute).UnixNano())
+	p.checkSilence(time.Minute, now)
+	p.checkSilence(time.Minute, now.Add(time.Minute))
+	if len(logstream) != 1 {
+		t.Fatalf("expected one warning got %v", len(logstream))
+	}
+	warning := <-logstream
+	if warning.Source != SilenceSource || warning.Data != "no logs for 2m0s while the container is running" || warning.Fields["silent_for"] != "2m0s" {
+		t.Errorf("unexpected warning: %+v", warning)
+	}
+	if n := Counters.Get(route, "pump.silent"); n != 1 {
+		t.Errorf("expected the silence to be counted once got %v", n)
+	}
+
+	// containers are reported again once they logged and went silent again
+	atomic.StoreInt64(&cp.logged, now.UnixNano())
+	p.checkSilence(time.Minute, now.Add(2*time.Minute))
+	if len(logstream) != 1 {
+		t.Errorf("expected a warning after logging again got %v", len(logstream))
+	}
+}
+
+func TestCheckSilenceStopped(t *testing.T) {
+	stopped := &docker.Container{ID: "8dfafdbc3a40", State: docker.State{Running: false}}
+	client := newTestClient(&FakeRoundTripper{message: stopped, status: http.StatusOK})
+	p := &LogsPump{client: &client, pumps: make(map[string]*containerPump)}
+	container := &docker.Container{ID: "8dfafdbc3a40", Config: &docker.Config{}}
+	cp := newContainerPump(container, strings.NewReader(""), strings.NewReader(""), nil)
+	p.pumps["8dfafdbc3a40"] = cp
+	logstream := make(chan *Message, 1)
+	cp.add(logstream, &Route{})
+
+	now := time.Now()
+	atomic.StoreInt64(&cp.logged, now.Add(-2*time.Minute).UnixNano())
+	p.checkSilence(time.Minute, now)
+	if len(logstream) != 0 {
+		t.Error("expected no warning for a container that isn't running")
+	}
+}
+
+func TestSilenceTimeout(t *testing.T) {
+	defer os.Unsetenv("SILENCE_TIMEOUT")
+	os.Setenv("SILENCE_TIMEOUT", "-1m")
+	if _, err := silenceTimeout(); err == nil {
+		t.Error("expected an error for a negative timeout")
+	}
+	os.Setenv("SILENCE_TIMEOUT", "10m")
+	if timeout, err := silenceTimeout(); err != nil || timeout != 10*time.Minute {
+		t.Errorf("expected 10m got %v %v", timeout, err)
+	}
+}
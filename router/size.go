@@ -0,0 +1,140 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// Policies for messages larger than max_message_size
+const (
+	oversizeTruncate = "truncate"
+	oversizeSplit    = "split"
+	oversizeDrop     = "drop"
+)
+
+const (
+	// appended to truncated messages, within the size limit
+	truncateMarker = " [truncated]"
+	minMessageSize = 64
+)
+
+// sizeLimit keeps the messages of a route under max_message_size bytes
+type sizeLimit struct {
+	max    int
+	policy string
+	logged sync.Once
+}
+
+// newSizeLimit returns the limit configured by the max_message_size and
+// max_message_policy options of route, falling back to MAX_MESSAGE_SIZE and
+// MAX_MESSAGE_POLICY, or nil if it has none
+func newSizeLimit(route *Route) (*sizeLimit, error) {
+	value := RouteOpt(route, "max_message_size", "MAX_MESSAGE_SIZE", "0")
+	max, err := strconv.Atoi(value)
+	if err != nil || max < 0 || max > 0 && max < minMessageSize {
+		return nil, errors.New("invalid value for max_message_size (must be at least 64 bytes): " + value)
+	}
+	if max == 0 {
+		return nil, nil
+	}
+	l := &sizeLimit{max: max, policy: RouteOpt(route, "max_message_policy", "MAX_MESSAGE_POLICY", oversizeTruncate)}
+	switch l.policy {
+	case oversizeTruncate, oversizeSplit, oversizeDrop:
+	default:
+		return nil, errors.New("invalid value for max_message_policy (must be truncate, split or drop): " + l.policy)
+	}
+	return l, nil
+}
+
+// limitSize passes messages from logstream to out, truncating, splitting or
+// dropping those with data over the route's size limit, and counting them
+// as size.truncated, size.split and size.dropped. It closes out once
+// logstream is closed.
+func limitSize(route *Route, logstream, out chan *Message) {
+	l := route.size
+	for message := range logstream {
+		if len(message.Data) <= l.max {
+			out <- message
+			continue
+		}
+		l.logged.Do(func() {
+			NewLogger("size").Warn("messages over the size limit are "+oversized[l.policy]+", see the size."+oversized[l.policy]+" counter",
+				"route", route.ID, "max_message_size", l.max)
+		})
+		switch l.policy {
+		case oversizeDrop:
+			Counters.Add(route, "size.dropped", 1)
+			Receipts.Report(route, message, NewDeliveryError(ErrorDropped,
+				fmt.Errorf("message of %v bytes over max_message_size of %v bytes", len(message.Data), l.max)))
+		case oversizeSplit:
+			Counters.Add(route, "size.split", 1)
+			parts := split(message.Data, l.max)
+			for i, data := range parts {
+				part := message.Copy()
+				part.Data = data
+				if part.Fields == nil {
+					part.Fields = make(map[string]string, 1)
+				}
+				part.Fields["part"] = fmt.Sprintf("%v/%v", i+1, len(parts))
+				out <- part
+			}
+		default:
+			Counters.Add(route, "size.truncated", 1)
+			truncated := message.Copy()
+			truncated.Data = message.Data[:runeStart(message.Data, l.max-len(truncateMarker))] + truncateMarker
+			out <- truncated
+		}
+	}
+	close(out)
+}
+
+var oversized = map[string]string{
+	oversizeTruncate: "truncated",
+	oversizeSplit:    "split",
+	oversizeDrop:     "dropped",
+}
+
+// split returns data in parts of at most max bytes, not splitting runes
+func split(data string, max int) []string {
+	var parts []string
+	for len(data) > max {
+		i := runeStart(data, max)
+		parts = append(parts, data[:i])
+		data = data[i:]
+	}
+	return append(parts, data)
+}
+
+// runeStart returns the largest index of s up to i that starts a rune
+func runeStart(s string, i int) int {
+	for j := i; j > 0 && i-j < utf8.UTFMax; j-- {
+		if utf8.RuneStart(s[j]) {
+			return j
+		}
+	}
+	return i
+}
+
+// Truncate shortens s to at most n bytes without splitting a rune, for
+// adapters with limits on the size of messages or fields
+func Truncate(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(s) <= n {
+		return s
+	}
+	return s[:runeStart(s, n)]
+}
+
+// TruncateRunes shortens s to at most n runes
+func TruncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:n])
+}
@@ -0,0 +1,120 @@
+package router
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func limited(t *testing.T, options map[string]string, messages ...*Message) []*Message {
+	route := &Route{ID: t.Name(), Options: options}
+	size, err := newSizeLimit(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.size = size
+	in, out := make(chan *Message, len(messages)), make(chan *Message, 100)
+	for _, message := range messages {
+		in <- message
+	}
+	close(in)
+	limitSize(route, in, out)
+	var got []*Message
+	for message := range out {
+		got = append(got, message)
+	}
+	return got
+}
+
+func TestSizeLimitTruncates(t *testing.T) {
+	// a multibyte rune straddles the limit
+	long := &Message{Data: strings.Repeat("a", 51) + strings.Repeat("é", 50)}
+	got := limited(t, map[string]string{"max_message_size": "64"}, &Message{Data: "short"}, long)
+	if len(got) != 2 || got[0].Data != "short" {
+		t.Fatalf("expected short messages unchanged got %v", got)
+	}
+	data := got[1].Data
+	if len(data) > 64 || !strings.HasSuffix(data, truncateMarker) || !utf8.ValidString(data) {
+		t.Errorf("expected valid UTF-8 of at most 64 bytes with a marker got %q (%v bytes)", data, len(data))
+	}
+	if long.Data == data {
+		t.Error("expected the original message, shared by routes, left unchanged")
+	}
+	if n := Counters.Get(&Route{ID: t.Name()}, "size.truncated"); n != 1 {
+		t.Errorf("expected 1 truncated message counted got %v", n)
+	}
+}
+
+func TestSizeLimitSplits(t *testing.T) {
+	data := strings.Repeat("0123456789", 20)
+	got := limited(t, map[string]string{"max_message_size": "64", "max_message_policy": "split"},
+		&Message{Data: data, Fields: map[string]string{"level": "info"}})
+	if len(got) != 4 {
+		t.Fatalf("expected 4 parts got %v", len(got))
+	}
+	var joined string
+	for i, part := range got {
+		if len(part.Data) > 64 || part.Fields["level"] != "info" || part.Fields["part"] != []string{"1/4", "2/4", "3/4", "4/4"}[i] {
+			t.Errorf("unexpected part %v: %q %v", i, part.Data, part.Fields)
+		}
+		joined += part.Data
+	}
+	if joined != data {
+		t.Errorf("expected the parts to join to the message got %q", joined)
+	}
+}
+
+func TestSizeLimitDrops(t *testing.T) {
+	receipts := Receipts.Subscribe()
+	defer Receipts.Unsubscribe(receipts)
+	got := limited(t, map[string]string{"max_message_size": "64", "max_message_policy": "drop"},
+		&Message{Data: strings.Repeat("x", 65)}, &Message{Data: "kept"})
+	if len(got) != 1 || got[0].Data != "kept" {
+		t.Errorf("expected the oversize message dropped got %v", got)
+	}
+	if n := Counters.Get(&Route{ID: t.Name()}, "size.dropped"); n != 1 {
+		t.Errorf("expected 1 dropped message counted got %v", n)
+	}
+	if receipt := <-receipts; receipt.Status != StatusFailed || receipt.Category != ErrorDropped {
+		t.Errorf("expected a failed receipt for the dropped message got %+v", receipt)
+	}
+}
+
+func TestSizeLimitInvalid(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"max_message_size": "8k"},
+		{"max_message_size": "10"},
+		{"max_message_size": "8192", "max_message_policy": "wrap"},
+	} {
+		if _, err := newSizeLimit(&Route{Options: options}); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+	if size, err := newSizeLimit(&Route{Options: map[string]string{}}); size != nil || err != nil {
+		t.Errorf("expected no limit by default got %v %v", size, err)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	for _, test := range []struct {
+		s        string
+		n        int
+		expected string
+	}{
+		{"héllo", 2, "h"},
+		{"héllo", 3, "hé"},
+		{"hello", 10, "hello"},
+		{"hello", 0, ""},
+		{"hello", -1, ""},
+	} {
+		if s := Truncate(test.s, test.n); s != test.expected {
+			t.Errorf("%q to %d bytes: expected %q got %q", test.s, test.n, test.expected, s)
+		}
+	}
+	if s := TruncateRunes("héllo", 2); s != "hé" {
+		t.Errorf("expected hé got %q", s)
+	}
+	if s := TruncateRunes("short", 10); s != "short" {
+		t.Errorf("expected short unchanged got %q", s)
+	}
+}
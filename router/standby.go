@@ -0,0 +1,74 @@
+package router
+
+// standby receives the messages of routes failing over, or sending dead
+// letters, to a route
+type standby struct {
+	route    *Route
+	messages chan *Message
+	done     chan struct{}
+}
+
+// addStandby registers the standby input of route
+func (rm *RouteManager) addStandby(route *Route) *standby {
+	rm.Lock()
+	defer rm.Unlock()
+	if rm.standbys == nil {
+		rm.standbys = make(map[string]*standby)
+	}
+	sb := &standby{route: route, messages: make(chan *Message), done: make(chan struct{})}
+	rm.standbys[route.ID] = sb
+	return sb
+}
+
+// removeStandby unregisters sb and stops it accepting messages
+func (rm *RouteManager) removeStandby(id string, sb *standby) {
+	rm.Lock()
+	if rm.standbys[id] == sb {
+		delete(rm.standbys, id)
+	}
+	rm.Unlock()
+	close(sb.done)
+}
+
+func (rm *RouteManager) getStandby(id string) *standby {
+	rm.Lock()
+	defer rm.Unlock()
+	return rm.standbys[id]
+}
+
+// routeStandby passes the messages other routes fail over to this route on
+// to its logstream until stop is closed
+func routeStandby(logstream chan *Message, sb *standby, stop <-chan struct{}) {
+	for {
+		select {
+		case message := <-sb.messages:
+			select {
+			case logstream <- message:
+			case <-stop:
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// failover passes messages from logstream to out, or to the route's standby
+// route while its writes are slow, unless the standby's residency doesn't
+// allow the message. It closes out once logstream is closed.
+func (rm *RouteManager) failover(route *Route, logstream, out chan *Message) {
+	id := route.Options["standby"]
+	for message := range logstream {
+		if Latencies.Slow(route) {
+			if sb := rm.getStandby(id); sb != nil && residencyAllows(sb.route, message.Container) {
+				select {
+				case sb.messages <- message:
+					continue
+				case <-sb.done:
+				}
+			}
+		}
+		out <- message
+	}
+	close(out)
+}
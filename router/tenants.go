@@ -0,0 +1,172 @@
+package router
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+var tenantsLog = NewLogger("tenants")
+
+// tenants routes the containers of each tenant to their own endpoint when
+// TENANTS_FILE is set
+var tenants *tenantRoutes
+
+// Tenant is the endpoint of a tenant in TENANTS_FILE. Options, such as
+// credentials, are added to the options of the route URI, with environment
+// variables expanded in their values.
+type Tenant struct {
+	URI     string            `json:"uri"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// tenantRoutes adds a route for each tenant with running containers, and
+// removes it TENANT_IDLE_TIMEOUT after the tenant's last container exits
+type tenantRoutes struct {
+	mu         sync.Mutex
+	routes     *RouteManager
+	label      string
+	idle       time.Duration
+	tenants    map[string]*Tenant
+	containers map[string]int
+	removals   map[string]*time.Timer
+	unmapped   map[string]bool
+}
+
+func newTenantRoutes(routes *RouteManager) (*tenantRoutes, error) {
+	path := getopt("TENANTS_FILE", "")
+	if path == "" {
+		return nil, nil
+	}
+	idle, err := time.ParseDuration(getopt("TENANT_IDLE_TIMEOUT", "1m"))
+	if err != nil || idle < 0 {
+		return nil, errors.New("invalid value for TENANT_IDLE_TIMEOUT: " + getopt("TENANT_IDLE_TIMEOUT", ""))
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mapping := make(map[string]*Tenant)
+	if err := unmarshal(f, &mapping); err != nil {
+		return nil, errors.New("invalid TENANTS_FILE " + path + ": " + err.Error())
+	}
+	for tenant, endpoint := range mapping {
+		if endpoint == nil || endpoint.URI == "" {
+			return nil, errors.New("invalid TENANTS_FILE " + path + ": no uri for tenant " + tenant)
+		}
+		if _, err := parseRouteURI(endpoint.URI); err != nil {
+			return nil, errors.New("invalid TENANTS_FILE " + path + ": " + err.Error())
+		}
+	}
+	return &tenantRoutes{
+		routes:     routes,
+		label:      getopt("TENANT_LABEL", "logspout.tenant"),
+		idle:       idle,
+		tenants:    mapping,
+		containers: make(map[string]int),
+		removals:   make(map[string]*time.Timer),
+		unmapped:   make(map[string]bool),
+	}, nil
+}
+
+// tenant returns the tenant of container, if it is labelled with one
+func (t *tenantRoutes) tenant(container *docker.Container) string {
+	if t == nil || container.Config == nil {
+		return ""
+	}
+	return container.Config.Labels[t.label]
+}
+
+// route returns the route of tenant, sending the containers labelled with it
+// to its endpoint
+func (t *tenantRoutes) route(tenant string) (*Route, error) {
+	endpoint := t.tenants[tenant]
+	route, err := parseRouteURI(endpoint.URI)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range endpoint.Options {
+		route.Options[key] = os.ExpandEnv(value)
+	}
+	route.ID = tenantRouteID(tenant)
+	route.FilterLabels = append(route.FilterLabels, t.label+":"+tenant)
+	return route, nil
+}
+
+func tenantRouteID(tenant string) string {
+	return "tenant-" + tenant
+}
+
+// attached adds the route of container's tenant if it is the tenant's first
+// running container
+func (t *tenantRoutes) attached(container *docker.Container) {
+	tenant := t.tenant(container)
+	if tenant == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, mapped := t.tenants[tenant]; !mapped {
+		if !t.unmapped[tenant] {
+			t.unmapped[tenant] = true
+			tenantsLog.Warn("no endpoint in TENANTS_FILE", "tenant", tenant)
+		}
+		return
+	}
+	t.containers[tenant]++
+	if removal, ok := t.removals[tenant]; ok {
+		removal.Stop()
+		delete(t.removals, tenant)
+	}
+	if _, err := t.routes.Get(tenantRouteID(tenant)); err == nil {
+		return
+	}
+	route, err := t.route(tenant)
+	if err == nil {
+		// tenant routes come and go with their containers, so they're
+		// left out of the persisted routes
+		err = t.routes.add(route, false)
+	}
+	if err != nil {
+		tenantsLog.Error("adding route failed", "tenant", tenant, "error", err)
+		return
+	}
+	tenantsLog.Debug("added route", "tenant", tenant, "route", route.ID)
+}
+
+// detached removes the route of container's tenant once it has had no
+// running containers for TENANT_IDLE_TIMEOUT
+func (t *tenantRoutes) detached(container *docker.Container) {
+	tenant := t.tenant(container)
+	if tenant == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.containers[tenant] == 0 {
+		return
+	}
+	t.containers[tenant]--
+	if t.containers[tenant] > 0 {
+		return
+	}
+	delete(t.containers, tenant)
+	var removal *time.Timer
+	removal = time.AfterFunc(t.idle, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		// a container started since, and maybe exited again
+		if t.removals[tenant] != removal {
+			return
+		}
+		delete(t.removals, tenant)
+		if t.routes.Remove(tenantRouteID(tenant)) {
+			tenantsLog.Debug("removed route", "tenant", tenant, "route", tenantRouteID(tenant))
+		}
+	})
+	t.removals[tenant] = removal
+}
@@ -0,0 +1,141 @@
+package router
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestTenantRoutes(t *testing.T) {
+	AdapterFactories.Register(newDummyAdapter, "dummy")
+	dir, err := ioutil.TempDir("", "logspout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mapping := filepath.Join(dir, "tenants.json")
+	ioutil.WriteFile(mapping, []byte(`{
+		"acme": {"uri": "dummy://logs.acme.example.com:514?format=rfc5424", "options": {"token": "${ACME_TOKEN}"}},
+		"globex": {"uri": "dummy://logs.globex.example.com:514"}
+	}`), 0644)
+	for key, value := range map[string]string{"TENANTS_FILE": mapping, "TENANT_IDLE_TIMEOUT": "10ms", "ACME_TOKEN": "s3cret"} {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+	rm := &RouteManager{routes: make(map[string]*Route), persistor: NewRouteJSONFile(filepath.Join(dir, "routes.json"))}
+	tr, err := newTenantRoutes(rm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := func(tenant string) *docker.Container {
+		return &docker.Container{ID: "8dfafdbc3a40", Config: &docker.Config{Labels: map[string]string{"logspout.tenant": tenant}}}
+	}
+
+	tr.attached(container("acme"))
+	tr.attached(container("acme"))
+	tr.attached(container("initech"))
+	route, err := rm.Get("tenant-acme")
+	if err != nil {
+		t.Fatal("expected a route for the tenant")
+	}
+	if route.Address != "logs.acme.example.com:514" || route.Options["format"] != "rfc5424" || route.Options["token"] != "s3cret" {
+		t.Errorf("expected the tenant's endpoint and credentials got %s %v", route.Address, route.Options)
+	}
+	if !route.MatchContainer("8dfafdbc3a40", "app", map[string]string{"logspout.tenant": "acme"}) ||
+		route.MatchContainer("8dfafdbc3a40", "app", map[string]string{"logspout.tenant": "globex"}) {
+		t.Error("expected the route limited to the tenant's containers")
+	}
+	if routes, _ := rm.GetAll(); len(routes) != 1 {
+		t.Errorf("expected no route for tenants without an endpoint got %v routes", len(routes))
+	}
+	if persisted, _ := rm.persistor.GetAll(); len(persisted) != 0 {
+		t.Errorf("expected tenant routes left out of the persisted routes got %v", persisted)
+	}
+
+	tr.detached(container("acme"))
+	time.Sleep(50 * time.Millisecond)
+	if _, err := rm.Get("tenant-acme"); err != nil {
+		t.Error("expected the route kept while the tenant has running containers")
+	}
+	tr.detached(container("acme"))
+	tr.attached(container("acme"))
+	time.Sleep(50 * time.Millisecond)
+	if _, err := rm.Get("tenant-acme"); err != nil {
+		t.Error("expected the route kept when a container started within the idle timeout")
+	}
+	tr.detached(container("acme"))
+	time.Sleep(50 * time.Millisecond)
+	if _, err := rm.Get("tenant-acme"); err == nil {
+		t.Error("expected the route removed after the idle timeout")
+	}
+}
+
+func TestTenantRouteRemovedStopsAdapter(t *testing.T) {
+	AdapterFactories.Register(func(route *Route) (LogAdapter, error) {
+		return &closingAdapter{make(chan bool, 1), make(chan bool, 1)}, nil
+	}, "closing")
+	dir, err := ioutil.TempDir("", "logspout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mapping := filepath.Join(dir, "tenants.json")
+	ioutil.WriteFile(mapping, []byte(`{"acme": {"uri": "closing://logs.acme.example.com:514"}}`), 0644)
+	for key, value := range map[string]string{"TENANTS_FILE": mapping, "TENANT_IDLE_TIMEOUT": "10ms"} {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+	rm := &RouteManager{routes: make(map[string]*Route), stop: make(chan struct{}), routing: true}
+	defer rm.Shutdown(time.Second)
+	tr, err := newTenantRoutes(rm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := &docker.Container{ID: "8dfafdbc3a40", Config: &docker.Config{Labels: map[string]string{"logspout.tenant": "acme"}}}
+
+	tr.attached(container)
+	route, err := rm.Get("tenant-acme")
+	if err != nil {
+		t.Fatal("expected a route for the tenant")
+	}
+	adapter := route.adapter.(*closingAdapter)
+	tr.detached(container)
+	select {
+	case <-adapter.streamed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the adapter's Stream to return once the route was removed")
+	}
+	select {
+	case <-adapter.closed:
+	case <-time.After(time.Second):
+		t.Error("expected the adapter closed once the route was removed")
+	}
+}
+
+func TestTenantsFileInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logspout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mapping := filepath.Join(dir, "tenants.json")
+	os.Setenv("TENANTS_FILE", mapping)
+	defer os.Unsetenv("TENANTS_FILE")
+	for _, contents := range []string{`{"acme": "dummy://host:514"}`, `{"acme": {"options": {"token": "x"}}}`, `{"acme": {"uri": "%zz"}}`} {
+		ioutil.WriteFile(mapping, []byte(contents), 0644)
+		if _, err := newTenantRoutes(Routes); err == nil {
+			t.Errorf("expected an error for %s", contents)
+		}
+	}
+	os.Unsetenv("TENANTS_FILE")
+	tr, err := newTenantRoutes(Routes)
+	if tr != nil || err != nil {
+		t.Errorf("expected no tenant routes without TENANTS_FILE got %v %v", tr, err)
+	}
+	// the pump calls a nil tenantRoutes for every container
+	tr.attached(&docker.Container{Config: &docker.Config{Labels: map[string]string{"logspout.tenant": "acme"}}})
+}
@@ -0,0 +1,417 @@
+package router
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math"
+	mathrand "math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/internal/otlp"
+)
+
+const (
+	// traceTimeout is how long a trace waits for its routes to report the
+	// outcome of its message, e.g. one a processor dropped, before it's
+	// exported without them
+	traceTimeout = time.Minute
+
+	traceBatch    = 512
+	traceMaxSpans = 8 * traceBatch
+	traceInterval = 5 * time.Second
+)
+
+var traceLog = NewLogger("trace")
+
+// Tracer samples messages and exports spans of their way from the pump to
+// the adapters of their routes to an OTLP collector, to show where latency
+// accumulates
+type Tracer struct {
+	mu       sync.Mutex
+	rate     float64
+	exporter *otlp.Exporter
+	resource otlp.Resource
+	traces   map[*Trace]struct{}
+	spans    []*otlp.Span
+	dropped  int
+	flush    chan struct{}
+	// writes is the start and end of the last write of each route
+	writes map[string][2]time.Time
+}
+
+// Trace is the spans of a sampled message
+type Trace struct {
+	id         string
+	span       string
+	read       time.Time
+	dispatched time.Time
+	pending    int
+	routes     map[*Route]*routeTrace
+	message    []otlp.KeyValue
+	spans      []*otlp.Span
+}
+
+// routeTrace is the way of a traced message through a route
+type routeTrace struct {
+	span string
+	sent time.Time
+	// queued and received are when the message was offered to and taken by
+	// the route's adapter
+	queued   time.Time
+	received time.Time
+	reported time.Time
+	write    [2]time.Time
+	err      error
+}
+
+// Traces samples the messages traced, enabled with TRACE_SAMPLE_RATE
+var Traces = &Tracer{
+	traces: make(map[*Trace]struct{}),
+	writes: make(map[string][2]time.Time),
+	flush:  make(chan struct{}, 1),
+}
+
+func init() {
+	Jobs.Register(Traces, "trace")
+}
+
+// Enabled returns whether messages are sampled
+func (t *Tracer) Enabled() bool {
+	return t.rate > 0
+}
+
+// Name returns the name of the job, if it's enabled
+func (t *Tracer) Name() string {
+	if !t.Enabled() {
+		return ""
+	}
+	return "trace"
+}
+
+// Setup configures the sample rate from TRACE_SAMPLE_RATE, and the collector
+// spans are exported to from the OTEL_EXPORTER_OTLP_* env vars
+func (t *Tracer) Setup() error {
+	value := getopt("TRACE_SAMPLE_RATE", "0")
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || rate > 1 || math.IsNaN(rate) {
+		return errors.New("invalid value for TRACE_SAMPLE_RATE (must be between 0 and 1): " + value)
+	}
+	if rate == 0 {
+		return nil
+	}
+	headers, err := otlp.ParseHeaders(getopt("OTEL_EXPORTER_OTLP_TRACES_HEADERS", getopt("OTEL_EXPORTER_OTLP_HEADERS", "")))
+	if err != nil {
+		return errors.New("invalid value for OTEL_EXPORTER_OTLP_HEADERS: " + err.Error())
+	}
+	url := otlp.Endpoint(getopt("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318"),
+		getopt("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", ""), "traces")
+	hostname, _ := os.Hostname()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rate = rate
+	t.exporter = otlp.NewExporter(url, headers)
+	t.resource = otlp.Resource{Attributes: []otlp.KeyValue{
+		otlp.String("service.name", getopt("OTEL_SERVICE_NAME", "logspout")),
+		otlp.String("host.name", hostname),
+	}}
+	return nil
+}
+
+// Run exports spans in batches, and traces whose routes didn't report in
+// time without them
+func (t *Tracer) Run() error {
+	if !t.Enabled() {
+		select {}
+	}
+	ticker := time.NewTicker(traceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			t.expire(now)
+		case <-t.flush:
+		}
+		t.export()
+	}
+}
+
+// Sample starts a trace of message, read from the log stream at read, with
+// the rate sampled. written is when Docker logged it, if that's known.
+func (t *Tracer) Sample(message *Message, written, read time.Time) {
+	if !t.Enabled() || mathrand.Float64() >= t.rate {
+		return
+	}
+	trace := &Trace{
+		id:     newTraceID(16),
+		span:   newTraceID(8),
+		read:   read,
+		routes: make(map[*Route]*routeTrace),
+		message: []otlp.KeyValue{
+			otlp.String("container.id", normalID(message.Container.ID)),
+			otlp.String("container.name", normalName(message.Container.Name)),
+			otlp.String("logspout.source", message.Source),
+			otlp.Int("logspout.message.size", int64(len(message.Data))),
+		},
+	}
+	if !written.IsZero() && written.Before(read) {
+		trace.add(trace.span, "pump.read", otlp.SpanKindInternal, written, read)
+	}
+	message.Trace = trace
+	t.mu.Lock()
+	t.traces[trace] = struct{}{}
+	t.mu.Unlock()
+}
+
+// sent records that the pump passed the traced message to route
+func (t *Tracer) sent(trace *Trace, route *Route) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := trace.routes[route]; !ok {
+		trace.routes[route] = &routeTrace{span: newTraceID(8), sent: time.Now()}
+		trace.pending++
+	}
+}
+
+// dispatched records that the pump passed the traced message to all the
+// routes it matched
+func (t *Tracer) dispatched(trace *Trace) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trace.dispatched = time.Now()
+	trace.add(trace.span, "pump.dispatch", otlp.SpanKindInternal, trace.read, trace.dispatched)
+	if trace.pending == 0 {
+		t.end(trace, trace.dispatched)
+	}
+}
+
+// queued records that the traced message is offered to the adapter of
+// route, and received that the adapter took it
+func (t *Tracer) queued(trace *Trace, route *Route) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if rt, ok := trace.routes[route]; ok {
+		rt.queued = time.Now()
+	}
+}
+
+func (t *Tracer) received(trace *Trace, route *Route) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rt, ok := trace.routes[route]
+	if !ok || rt.span == "" {
+		return
+	}
+	rt.received = time.Now()
+	// adapters can report the message before this is recorded
+	if !rt.reported.IsZero() {
+		t.finish(trace, route, rt)
+	}
+}
+
+// wrote records a write of route's adapter, which the messages reported
+// next were sent with
+func (t *Tracer) wrote(route *Route, start, end time.Time) {
+	if !t.Enabled() {
+		return
+	}
+	t.mu.Lock()
+	t.writes[route.ID] = [2]time.Time{start, end}
+	t.mu.Unlock()
+}
+
+// reported records the outcome of the traced message on route, ending the
+// route's span unless its adapter's taking the message is still to be
+// recorded
+func (t *Tracer) reported(trace *Trace, route *Route, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rt, ok := trace.routes[route]
+	if !ok || rt.span == "" || !rt.reported.IsZero() {
+		return
+	}
+	rt.reported, rt.err = time.Now(), err
+	if write, ok := t.writes[route.ID]; ok && !rt.queued.IsZero() && !write[0].Before(rt.queued) {
+		rt.write = write
+	}
+	// messages a stage before the adapter reported, like those dropped
+	// outside the route's schedule, are never queued
+	if rt.queued.IsZero() || !rt.received.IsZero() {
+		t.finish(trace, route, rt)
+	}
+}
+
+// finish ends the span of route for the traced message, with the spans of
+// its wait for the adapter, the adapter rendering or batching it, and the
+// adapter's write
+func (t *Tracer) finish(trace *Trace, route *Route, rt *routeTrace) {
+	if received := rt.received; !received.IsZero() {
+		// taking the message is recorded after the adapter already has it
+		rendered := rt.reported
+		if !rt.write[0].IsZero() {
+			rendered = rt.write[0]
+			trace.add(rt.span, "adapter.write", otlp.SpanKindClient, rt.write[0], rt.write[1])
+		}
+		if rendered.Before(received) {
+			received = rendered
+		}
+		trace.add(rt.span, "route.queue", otlp.SpanKindInternal, rt.sent, received)
+		trace.add(rt.span, "adapter.render", otlp.SpanKindInternal, received, rendered)
+	}
+	attributes := []otlp.KeyValue{
+		otlp.String("logspout.route.id", route.ID),
+		otlp.String("logspout.adapter", route.Adapter),
+	}
+	status := &otlp.Status{Code: otlp.StatusOK}
+	if rt.err != nil {
+		attributes = append(attributes, otlp.String("logspout.error.category", ErrorCategory(rt.err)))
+		status = &otlp.Status{Code: otlp.StatusError, Message: rt.err.Error()}
+	}
+	trace.addSpan(&otlp.Span{
+		TraceID:           trace.id,
+		SpanID:            rt.span,
+		ParentSpanID:      trace.span,
+		Name:              "logspout.route",
+		Kind:              otlp.SpanKindInternal,
+		StartTimeUnixNano: otlp.UnixNano(rt.sent),
+		EndTimeUnixNano:   otlp.UnixNano(rt.reported),
+		Attributes:        attributes,
+		Status:            status,
+	})
+	rt.span = ""
+	trace.pending--
+	if trace.pending == 0 && !trace.dispatched.IsZero() {
+		t.end(trace, rt.reported)
+	}
+}
+
+// expire ends the traces whose routes haven't all reported within
+// traceTimeout
+func (t *Tracer) expire(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for trace := range t.traces {
+		if now.Sub(trace.read) < traceTimeout {
+			continue
+		}
+		for route, rt := range trace.routes {
+			if rt.span == "" {
+				continue
+			}
+			trace.addSpan(&otlp.Span{
+				TraceID:           trace.id,
+				SpanID:            rt.span,
+				ParentSpanID:      trace.span,
+				Name:              "logspout.route",
+				Kind:              otlp.SpanKindInternal,
+				StartTimeUnixNano: otlp.UnixNano(rt.sent),
+				EndTimeUnixNano:   otlp.UnixNano(now),
+				Attributes: []otlp.KeyValue{
+					otlp.String("logspout.route.id", route.ID),
+					otlp.String("logspout.adapter", route.Adapter),
+					otlp.Bool("logspout.unreported", true),
+				},
+			})
+		}
+		t.end(trace, now)
+	}
+}
+
+// end queues the spans of trace, with its root span ending at end, for
+// export
+func (t *Tracer) end(trace *Trace, end time.Time) {
+	delete(t.traces, trace)
+	trace.addSpan(&otlp.Span{
+		TraceID:           trace.id,
+		SpanID:            trace.span,
+		Name:              "logspout.message",
+		Kind:              otlp.SpanKindInternal,
+		StartTimeUnixNano: otlp.UnixNano(trace.read),
+		EndTimeUnixNano:   otlp.UnixNano(end),
+		Attributes:        trace.message,
+	})
+	if len(t.spans)+len(trace.spans) > traceMaxSpans {
+		// spans are dropped rather than slowing down delivery while the
+		// collector is slow or down
+		t.dropped += len(trace.spans)
+		return
+	}
+	t.spans = append(t.spans, trace.spans...)
+	if len(t.spans) >= traceBatch {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// export sends the spans queued to the collector in batches
+func (t *Tracer) export() {
+	t.mu.Lock()
+	spans, dropped := t.spans, t.dropped
+	t.spans, t.dropped = nil, 0
+	t.mu.Unlock()
+	if dropped > 0 {
+		traceLog.Warn("dropped spans", "spans", dropped)
+	}
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > traceBatch {
+			n = traceBatch
+		}
+		err := t.exporter.Export(&otlp.TracesRequest{ResourceSpans: []otlp.ResourceSpans{{
+			Resource:   t.resource,
+			ScopeSpans: []otlp.ScopeSpans{{Scope: otlp.Scope{Name: "logspout"}, Spans: spans[:n]}},
+		}}})
+		if err != nil {
+			traceLog.Warn("can't export spans", "spans", n, "error", err)
+		}
+		spans = spans[n:]
+	}
+}
+
+// add adds a span of trace under parent
+func (trace *Trace) add(parent, name string, kind int, start, end time.Time) {
+	if end.Before(start) {
+		end = start
+	}
+	trace.addSpan(&otlp.Span{
+		TraceID:           trace.id,
+		SpanID:            newTraceID(8),
+		ParentSpanID:      parent,
+		Name:              name,
+		Kind:              kind,
+		StartTimeUnixNano: otlp.UnixNano(start),
+		EndTimeUnixNano:   otlp.UnixNano(end),
+	})
+}
+
+func (trace *Trace) addSpan(span *otlp.Span) {
+	trace.spans = append(trace.spans, span)
+}
+
+// newTraceID returns a random hex encoded ID of n bytes
+func newTraceID(n int) string {
+	id := make([]byte, n)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// traceReceived passes messages from logstream to out, recording when the
+// traced ones are offered to and taken by the adapter of route. It closes out once logstream is
+// closed.
+func traceReceived(route *Route, logstream, out chan *Message) {
+	for message := range logstream {
+		if message.Trace == nil {
+			out <- message
+			continue
+		}
+		Traces.queued(message.Trace, route)
+		out <- message
+		Traces.received(message.Trace, route)
+	}
+	close(out)
+}
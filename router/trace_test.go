@@ -0,0 +1,128 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/internal/otlp"
+)
+
+func TestTraceSpans(t *testing.T) {
+	exported := make(chan *otlp.TracesRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/traces" || req.Header.Get("X-Tenant") != "acme" {
+			t.Errorf("unexpected export to %v with headers %v", req.URL.Path, req.Header)
+		}
+		request := new(otlp.TracesRequest)
+		json.NewDecoder(req.Body).Decode(request)
+		exported <- request
+	}))
+	defer server.Close()
+	os.Setenv("TRACE_SAMPLE_RATE", "1")
+	defer os.Unsetenv("TRACE_SAMPLE_RATE")
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", server.URL)
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	os.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "X-Tenant=acme")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_HEADERS")
+
+	tracer := &Tracer{traces: make(map[*Trace]struct{}), writes: make(map[string][2]time.Time), flush: make(chan struct{}, 1)}
+	if err := tracer.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	delivered, failed := &Route{ID: "delivered", Adapter: "syslog"}, &Route{ID: "failed", Adapter: "raw"}
+	message := &Message{Container: &docker.Container{ID: "abc", Name: "/app"}, Source: "stdout", Data: "hello"}
+	read := time.Now()
+	tracer.Sample(message, read.Add(-time.Millisecond), read)
+	if message.Trace == nil {
+		t.Fatal("expected the message sampled")
+	}
+	tracer.sent(message.Trace, delivered)
+	tracer.sent(message.Trace, failed)
+	tracer.dispatched(message.Trace)
+	tracer.queued(message.Trace, delivered)
+	tracer.received(message.Trace, delivered)
+	tracer.wrote(delivered, time.Now(), time.Now())
+	tracer.reported(message.Trace, delivered, nil)
+	// reported before its taking by the adapter is recorded
+	tracer.queued(message.Trace, failed)
+	tracer.reported(message.Trace, failed, errors.New("refused"))
+	if len(tracer.spans) != 0 {
+		t.Fatal("expected the trace to wait for the adapter taking the message")
+	}
+	tracer.received(message.Trace, failed)
+	tracer.export()
+
+	request := <-exported
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	byName := make(map[string][]*otlp.Span)
+	for _, span := range spans {
+		if span.TraceID != message.Trace.id || len(span.TraceID) != 32 || len(span.SpanID) != 16 {
+			t.Errorf("unexpected ids of %+v", span)
+		}
+		byName[span.Name] = append(byName[span.Name], span)
+	}
+	for name, count := range map[string]int{
+		"logspout.message": 1, "pump.read": 1, "pump.dispatch": 1, "logspout.route": 2,
+		"route.queue": 2, "adapter.render": 2, "adapter.write": 1,
+	} {
+		if len(byName[name]) != count {
+			t.Errorf("expected %v %s spans got %v", count, name, len(byName[name]))
+		}
+	}
+	root := byName["logspout.message"][0]
+	for _, span := range byName["logspout.route"] {
+		if span.ParentSpanID != root.SpanID {
+			t.Errorf("expected route spans under the message span got %+v", span)
+		}
+		routeID := *span.Attributes[0].Value.StringValue
+		if (span.Status.Code == otlp.StatusError) != (routeID == "failed") {
+			t.Errorf("unexpected status of %+v", span)
+		}
+	}
+	if len(tracer.traces) != 0 {
+		t.Error("expected the trace ended")
+	}
+}
+
+func TestTraceExpires(t *testing.T) {
+	tracer := &Tracer{rate: 1, traces: make(map[*Trace]struct{}), writes: make(map[string][2]time.Time), flush: make(chan struct{}, 1)}
+	message := &Message{Container: &docker.Container{ID: "abc", Name: "/app"}, Source: "stdout"}
+	read := time.Now()
+	tracer.Sample(message, time.Time{}, read)
+	tracer.sent(message.Trace, &Route{ID: "dropped"})
+	tracer.dispatched(message.Trace)
+	tracer.expire(read.Add(traceTimeout / 2))
+	if len(tracer.spans) != 0 {
+		t.Fatal("expected the trace to wait for its route")
+	}
+	tracer.expire(read.Add(traceTimeout))
+	// the dispatch span, the unreported route span and the message span
+	if len(tracer.spans) != 3 || len(tracer.traces) != 0 {
+		t.Errorf("expected the trace ended without its route got %v spans", len(tracer.spans))
+	}
+}
+
+func TestTraceSampleRate(t *testing.T) {
+	for _, value := range []string{"-1", "1.5", "often"} {
+		os.Setenv("TRACE_SAMPLE_RATE", value)
+		if err := (&Tracer{}).Setup(); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+	os.Unsetenv("TRACE_SAMPLE_RATE")
+	tracer := &Tracer{}
+	if err := tracer.Setup(); err != nil || tracer.Enabled() {
+		t.Error("expected tracing disabled by default")
+	}
+	message := &Message{}
+	tracer.Sample(message, time.Time{}, time.Now())
+	if message.Trace != nil {
+		t.Error("expected no message sampled while disabled")
+	}
+}
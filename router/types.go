@@ -1,7 +1,9 @@
-//go:generate go-extpoints . AdapterFactory HttpHandler AdapterTransport LogRouter Job
+//go:generate go-extpoints . AdapterFactory HttpHandler AdapterTransport LogRouter Job ProcessorFactory ContainerFilter
 package router
 
 import (
+	"encoding/base64"
+	"errors"
 	"net"
 	"net/http"
 	"path"
@@ -27,6 +29,28 @@ type LogAdapter interface {
 	Stream(logstream chan *Message)
 }
 
+// ProcessorFactory is an extension type for adding message processing stages
+type ProcessorFactory func(route *Route, options map[string]string) (Processor, error)
+
+// Processor is a stage in a route's processor chain. Process reads messages
+// from in until it is closed, sending zero or more messages to out
+type Processor interface {
+	Process(in chan *Message, out chan *Message)
+}
+
+// ContainerFilter is an extension type for deciding whether the pump attaches
+// to a container, and which routes it is sent to
+type ContainerFilter func(container *docker.Container) Admission
+
+// Admission is a ContainerFilter's decision about a container
+type Admission struct {
+	// Ignore skips the container's logs
+	Ignore bool
+	// Routes, when not empty, limits the container to the routes with these
+	// IDs, in addition to the routes' own filters
+	Routes []string
+}
+
 // Job is a thing to be done
 type Job interface {
 	Run() error
@@ -54,22 +78,87 @@ type Message struct {
 	Source    string
 	Data      string
 	Time      time.Time
+	Fields    map[string]string `json:",omitempty"`
+	// Fanout is set when FANOUT_TIMEOUT tracks the routes messages match
+	Fanout *Fanout `json:"-"`
+	// Exiting is set on the remaining messages of exited containers within
+	// EXIT_FLUSH_TIMEOUT, which adapters should flush without delay
+	Exiting bool `json:"-"`
+	// Trace is set on the messages TRACE_SAMPLE_RATE samples
+	Trace *Trace `json:"-"`
+}
+
+// Copy returns a copy of the message with its own Fields. Messages are shared
+// by all routes, so processors must modify a copy instead of the original
+func (m *Message) Copy() *Message {
+	copied := *m
+	if m.Fields != nil {
+		copied.Fields = make(map[string]string, len(m.Fields))
+		for key, value := range m.Fields {
+			copied.Fields[key] = value
+		}
+	}
+	return &copied
 }
 
 // Route represents what subset of logs should go where
 type Route struct {
-	ID            string            `json:"id"`
-	FilterID      string            `json:"filter_id,omitempty"`
-	FilterName    string            `json:"filter_name,omitempty"`
-	FilterSources []string          `json:"filter_sources,omitempty"`
-	FilterLabels  []string          `json:"filter_labels,omitempty"`
-	Adapter       string            `json:"adapter"`
-	Address       string            `json:"address"`
-	Options       map[string]string `json:"options,omitempty"`
+	receipts      uint64             // first for 64-bit alignment on 32-bit platforms
+	ID            string             `json:"id"`
+	FilterID      string             `json:"filter_id,omitempty"`
+	FilterName    string             `json:"filter_name,omitempty"`
+	FilterSources []string           `json:"filter_sources,omitempty"`
+	FilterLabels  []string           `json:"filter_labels,omitempty"`
+	FilterImages  []string           `json:"filter_images,omitempty"`
+	Adapter       string             `json:"adapter"`
+	Address       string             `json:"address"`
+	Options       map[string]string  `json:"options,omitempty"`
+	Processors    []*ProcessorConfig `json:"processors,omitempty"`
 	adapter       LogAdapter
-	closed	      bool
+	processors    []Processor
+	closed        bool
 	closer        chan bool
 	closerRcv     <-chan bool // used instead of closer when set
+	stop          chan struct{}
+	priority      chan *Message
+	breaker       *RouteBreaker
+	size          *sizeLimit
+	fields        []StaticField
+	sequence      bool
+	schedule      *schedule
+}
+
+// Copy returns a copy of the route's configuration, without its ID, that
+// can be changed and added as a new route
+func (r *Route) Copy() *Route {
+	copied := &Route{
+		FilterID:      r.FilterID,
+		FilterName:    r.FilterName,
+		FilterSources: append([]string(nil), r.FilterSources...),
+		FilterLabels:  append([]string(nil), r.FilterLabels...),
+		FilterImages:  append([]string(nil), r.FilterImages...),
+		Adapter:       r.Adapter,
+		Address:       r.Address,
+		Options:       copyOptions(r.Options),
+	}
+	for _, processor := range r.Processors {
+		copied.Processors = append(copied.Processors, &ProcessorConfig{
+			Type:    processor.Type,
+			Options: copyOptions(processor.Options),
+		})
+	}
+	return copied
+}
+
+func copyOptions(options map[string]string) map[string]string {
+	if options == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(options))
+	for key, value := range options {
+		copied[key] = value
+	}
+	return copied
 }
 
 // AdapterType returns a route's adapter type string
@@ -86,6 +175,34 @@ func (r *Route) AdapterTransport(dfault string) string {
 	return dfault
 }
 
+// TemplateOverride returns the route's base64 encoded template option decoded,
+// or an empty string if it has none
+func (r *Route) TemplateOverride() (string, error) {
+	value := r.Options["template"]
+	if value == "" {
+		return "", nil
+	}
+	tmpl, err := decodeTemplate(value)
+	if err != nil {
+		return "", errors.New("invalid template option (must be base64): " + value)
+	}
+	return tmpl, nil
+}
+
+// decodeTemplate returns the template of a base64 encoded route option
+func decodeTemplate(value string) (string, error) {
+	// an unescaped + in a route URI query is decoded as a space
+	value = strings.Replace(value, " ", "+", -1)
+	var err error
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		var tmpl []byte
+		if tmpl, err = enc.DecodeString(value); err == nil {
+			return string(tmpl), nil
+		}
+	}
+	return "", err
+}
+
 // Closer returns a route's closerRcv
 func (r *Route) Closer() <-chan bool {
 	if r.closerRcv != nil {
@@ -105,7 +222,7 @@ func (r *Route) Close() {
 }
 
 func (r *Route) matchAll() bool {
-	if r.FilterID == "" && r.FilterName == "" && len(r.FilterSources) == 0 && len(r.FilterLabels) == 0 {
+	if r.FilterID == "" && r.FilterName == "" && len(r.FilterSources) == 0 && len(r.FilterLabels) == 0 && len(r.FilterImages) == 0 {
 		return true
 	}
 	return false
@@ -113,7 +230,8 @@ func (r *Route) matchAll() bool {
 
 // MultiContainer returns whether the Route is matching multiple containers or not
 func (r *Route) MultiContainer() bool {
-	return r.matchAll() || strings.Contains(r.FilterName, "*")
+	return r.matchAll() || strings.Contains(r.FilterName, "*") || strings.HasPrefix(r.FilterName, "~") ||
+		strings.HasPrefix(r.FilterName, "!") || len(r.FilterImages) > 0
 }
 
 // MatchContainer returns whether the Route is responsible for a given container
@@ -124,8 +242,7 @@ func (r *Route) MatchContainer(id, name string, labels map[string]string) bool {
 	if r.FilterID != "" && !strings.HasPrefix(id, r.FilterID) {
 		return false
 	}
-	match, err := path.Match(r.FilterName, name)
-	if err != nil || (r.FilterName != "" && !match) {
+	if r.FilterName != "" && !matchPatterns([]string{r.FilterName}, name) {
 		return false
 	}
 	for _, label := range r.FilterLabels {
@@ -143,6 +260,12 @@ func (r *Route) MatchContainer(id, name string, labels map[string]string) bool {
 	return true
 }
 
+// MatchImage returns whether the Route is responsible for containers created
+// from image
+func (r *Route) MatchImage(image string) bool {
+	return len(r.FilterImages) == 0 || matchPatterns(r.FilterImages, imageNames(image)...)
+}
+
 // MatchMessage returns whether the Route is responsible for a given Message
 func (r *Route) MatchMessage(message *Message) bool {
 	if r.matchAll() {
@@ -0,0 +1,87 @@
+package router
+
+import (
+	"strings"
+	"sync"
+)
+
+// containers of a route beyond this are counted together as other, so hosts
+// churning through containers don't grow the usage without bound
+const (
+	maxUsageContainers = 10000
+	otherContainers    = "other"
+)
+
+// ByteUsage counts the bytes of the messages reported on a route. Rendered
+// counts every reported message, Sent only those delivered.
+type ByteUsage struct {
+	Messages uint64 `json:"messages"`
+	Rendered uint64 `json:"rendered_bytes"`
+	Sent     uint64 `json:"sent_bytes"`
+}
+
+func (u *ByteUsage) add(size int, delivered bool) {
+	u.Messages++
+	u.Rendered += uint64(size)
+	if delivered {
+		u.Sent += uint64(size)
+	}
+}
+
+// UsageRegistry counts the bytes of the messages each route reports receipts
+// for, by container
+type UsageRegistry struct {
+	mu     sync.Mutex
+	routes map[string]map[string]*ByteUsage
+}
+
+// Usage counts the bytes of messages by route and container, for the costs
+// endpoint of the stats module
+var Usage = &UsageRegistry{routes: make(map[string]map[string]*ByteUsage)}
+
+// observe counts size bytes of message on route, grouping containers by the
+// COSTS_GROUP_LABEL label, or their name, and other messages by source
+func (u *UsageRegistry) observe(route *Route, message *Message, size int, err error) {
+	group := message.Source
+	if message.Container != nil {
+		group = strings.TrimPrefix(message.Container.Name, "/")
+		if label := getopt("COSTS_GROUP_LABEL", ""); label != "" && message.Container.Config != nil {
+			if value := message.Container.Config.Labels[label]; value != "" {
+				group = value
+			}
+		}
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	containers, ok := u.routes[route.ID]
+	if !ok {
+		containers = make(map[string]*ByteUsage)
+		u.routes[route.ID] = containers
+	}
+	usage, ok := containers[group]
+	if !ok {
+		if len(containers) >= maxUsageContainers {
+			group = otherContainers
+		}
+		if usage, ok = containers[group]; !ok {
+			usage = new(ByteUsage)
+			containers[group] = usage
+		}
+	}
+	usage.add(size, err == nil)
+}
+
+// Snapshot returns a copy of the usage of each route by container
+func (u *UsageRegistry) Snapshot() map[string]map[string]ByteUsage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	snapshot := make(map[string]map[string]ByteUsage, len(u.routes))
+	for id, containers := range u.routes {
+		copied := make(map[string]ByteUsage, len(containers))
+		for group, usage := range containers {
+			copied[group] = *usage
+		}
+		snapshot[id] = copied
+	}
+	return snapshot
+}
@@ -0,0 +1,45 @@
+package router
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestUsage(t *testing.T) {
+	os.Setenv("COSTS_GROUP_LABEL", "team")
+	defer os.Unsetenv("COSTS_GROUP_LABEL")
+	route := &Route{ID: "usage"}
+	container := func(name string, labels map[string]string) *docker.Container {
+		return &docker.Container{ID: "8dfafdbc3a40", Name: "/" + name, Config: &docker.Config{Labels: labels}}
+	}
+	Receipts.ReportSize(route, &Message{Container: container("web", nil), Data: "hello"}, 40, nil)
+	Receipts.ReportSize(route, &Message{Container: container("web", nil), Data: "hello"}, 40, errors.New("broken pipe"))
+	Receipts.Report(route, &Message{Container: container("api", map[string]string{"team": "payments"}), Data: "hello"}, nil)
+	Receipts.Report(route, &Message{Source: "receipts", Data: "{}"}, nil)
+
+	usage := Usage.Snapshot()["usage"]
+	if web := usage["web"]; web.Messages != 2 || web.Rendered != 80 || web.Sent != 40 {
+		t.Errorf("expected the rendered size counted and only delivered bytes sent got %+v", web)
+	}
+	if payments := usage["payments"]; payments.Messages != 1 || payments.Sent != 5 {
+		t.Errorf("expected containers grouped by label and the data counted got %+v", payments)
+	}
+	if receipts := usage["receipts"]; receipts.Sent != 2 {
+		t.Errorf("expected messages without a container grouped by source got %+v", receipts)
+	}
+}
+
+func TestUsageBounded(t *testing.T) {
+	route := &Route{ID: "usage-bounded"}
+	for i := 0; i <= maxUsageContainers; i++ {
+		Usage.observe(route, &Message{Source: strconv.Itoa(i)}, 1, nil)
+	}
+	usage := Usage.Snapshot()["usage-bounded"]
+	if len(usage) != maxUsageContainers+1 || usage[otherContainers].Messages != 1 {
+		t.Errorf("expected containers beyond the limit counted as other got %v containers %+v", len(usage), usage[otherContainers])
+	}
+}
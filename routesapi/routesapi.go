@@ -1,15 +1,21 @@
 package routesapi
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"io"
-	"log"
 	"net/http"
+	"os"
+	"regexp"
 
 	"github.com/gliderlabs/logspout/router"
 	"github.com/gorilla/mux"
 )
 
+// route ids name the files routes are persisted in
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
 func init() {
 	router.HttpHandlers.Register(RoutesAPI, "routes")
 }
@@ -29,6 +35,34 @@ func RoutesAPI() http.Handler {
 		w.Write(append(marshal(route), '\n'))
 	}).Methods("GET")
 
+	r.HandleFunc("/routes/{id}", func(w http.ResponseWriter, req *http.Request) {
+		params := mux.Vars(req)
+		route := new(router.Route)
+		if err := unmarshalRoute(req.Body, route); err != nil {
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if route.ID != "" && route.ID != params["id"] {
+			http.Error(w, "Bad request: id must match the path", http.StatusBadRequest)
+			return
+		}
+		route.ID = params["id"]
+		if err := validate(route); err != nil {
+			http.Error(w, "Bad route: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		existing, _ := routes.Get(route.ID)
+		if err := routes.Add(route); err != nil {
+			http.Error(w, "Bad route: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		if existing == nil {
+			w.WriteHeader(http.StatusCreated)
+		}
+		w.Write(append(marshal(route), '\n'))
+	}).Methods("PUT")
+
 	r.HandleFunc("/routes/{id}", func(w http.ResponseWriter, req *http.Request) {
 		params := mux.Vars(req)
 		if ok := routes.Remove(params["id"]); !ok {
@@ -36,6 +70,58 @@ func RoutesAPI() http.Handler {
 		}
 	}).Methods("DELETE")
 
+	r.HandleFunc("/routes/{id}/clone", func(w http.ResponseWriter, req *http.Request) {
+		params := mux.Vars(req)
+		route, _ := routes.Get(params["id"])
+		if route == nil {
+			http.NotFound(w, req)
+			return
+		}
+		clone, err := cloneRoute(route, req.Body)
+		if err != nil {
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validate(clone); err != nil {
+			http.Error(w, "Bad route: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := routes.Add(clone); err != nil {
+			http.Error(w, "Bad route: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(append(marshal(clone), '\n'))
+	}).Methods("POST")
+
+	r.HandleFunc("/routes/{id}/schedule", func(w http.ResponseWriter, req *http.Request) {
+		params := mux.Vars(req)
+		route, _ := routes.Get(params["id"])
+		if route == nil {
+			http.NotFound(w, req)
+			return
+		}
+		if req.Method == "PUT" {
+			var body scheduleState
+			if err := unmarshal(req.Body, &body); err != nil {
+				http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := route.SetScheduleState(body.State); err != nil {
+				http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		state, active, err := route.ScheduleState()
+		if err != nil {
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(append(marshal(scheduleState{State: state, Active: active}), '\n'))
+	}).Methods("GET", "PUT")
+
 	r.HandleFunc("/routes", func(w http.ResponseWriter, req *http.Request) {
 		w.Header().Add("Content-Type", "application/json")
 		rts, _ := routes.GetAll()
@@ -45,10 +131,14 @@ func RoutesAPI() http.Handler {
 
 	r.HandleFunc("/routes", func(w http.ResponseWriter, req *http.Request) {
 		route := new(router.Route)
-		if err := unmarshal(req.Body, route); err != nil {
+		if err := unmarshalRoute(req.Body, route); err != nil {
 			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
 			return
 		}
+		if err := validate(route); err != nil {
+			http.Error(w, "Bad route: "+err.Error(), http.StatusBadRequest)
+			return
+		}
 		err := routes.Add(route)
 		if err != nil {
 			http.Error(w, "Bad route: "+err.Error(), http.StatusBadRequest)
@@ -59,13 +149,87 @@ func RoutesAPI() http.Handler {
 		w.Write(append(marshal(route), '\n'))
 	}).Methods("POST")
 
-	return r
+	var h http.Handler = r
+	if os.Getenv("ROUTES_READONLY") == "true" {
+		h = readOnly(h)
+	}
+	if token := os.Getenv("ROUTES_API_TOKEN"); token != "" {
+		h = authorized(h, token)
+	}
+	return h
+}
+
+// scheduleState is the state a route with a schedule was switched to, auto,
+// active or inactive, and whether it is active now
+type scheduleState struct {
+	State  string `json:"state"`
+	Active bool   `json:"active"`
+}
+
+// authorized rejects requests to h without the bearer token
+func authorized(h http.Handler, token string) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="logspout"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// readOnly rejects requests to h that could change routes, including
+// switching their schedules
+func readOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			http.Error(w, "Forbidden: routes are read-only (ROUTES_READONLY)", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// cloneRoute copies route and applies the fields in overrides to the copy.
+// Options are merged into the route's options, and removed when set empty.
+// Other fields replace the route's. The clone gets a new ID unless one is given.
+func cloneRoute(route *router.Route, overrides io.Reader) (*router.Route, error) {
+	clone := route.Copy()
+	if err := unmarshal(overrides, clone); err != nil && err != io.EOF {
+		return nil, err
+	}
+	for key, value := range clone.Options {
+		if value == "" {
+			delete(clone.Options, key)
+		}
+	}
+	if clone.ID == route.ID {
+		return nil, errors.New("clone must not have the id of the route it clones")
+	}
+	return clone, nil
+}
+
+// validate checks the fields of a route that Add doesn't
+func validate(route *router.Route) error {
+	if route.Adapter == "" {
+		return errors.New("adapter is required")
+	}
+	if route.ID != "" && !validID.MatchString(route.ID) {
+		return errors.New("invalid id (must be letters, digits, '_', '.' or '-'): " + route.ID)
+	}
+	for _, processor := range route.Processors {
+		if processor == nil || processor.Type == "" {
+			return errors.New("processors must have a type")
+		}
+	}
+	return nil
 }
 
 func marshal(obj interface{}) []byte {
 	bytes, err := json.MarshalIndent(obj, "", "  ")
 	if err != nil {
-		log.Println("marshal:", err)
+		router.NewLogger("routesapi").Error("marshalling failed", "error", err)
 	}
 	return bytes
 }
@@ -74,3 +238,11 @@ func unmarshal(input io.Reader, obj interface{}) error {
 	dec := json.NewDecoder(input)
 	return dec.Decode(obj)
 }
+
+// unmarshalRoute decodes a route, rejecting unknown fields so misspelt
+// filters don't go unnoticed
+func unmarshalRoute(input io.Reader, route *router.Route) error {
+	dec := json.NewDecoder(input)
+	dec.DisallowUnknownFields()
+	return dec.Decode(route)
+}
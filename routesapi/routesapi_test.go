@@ -0,0 +1,176 @@
+package routesapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestCloneRoute(t *testing.T) {
+	route := &router.Route{
+		ID:            "abc",
+		FilterName:    "*_db",
+		FilterSources: []string{"stderr"},
+		Adapter:       "syslog",
+		Address:       "old.example.com:514",
+		Options:       map[string]string{"append_tag": ".db", "format": "rfc3164"},
+		Processors: []*router.ProcessorConfig{
+			{Type: "filter", Options: map[string]string{"exclude": "^DEBUG"}},
+		},
+	}
+	clone, err := cloneRoute(route, strings.NewReader(`{"address": "new.example.com:514", "options": {"append_tag": "", "facility": "local0"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clone.ID != "" || clone.Address != "new.example.com:514" || clone.FilterName != "*_db" || clone.FilterSources[0] != "stderr" {
+		t.Errorf("unexpected clone %+v", clone)
+	}
+	if len(clone.Options) != 2 || clone.Options["format"] != "rfc3164" || clone.Options["facility"] != "local0" {
+		t.Errorf("expected merged options got %v", clone.Options)
+	}
+	if len(clone.Processors) != 1 || clone.Processors[0].Options["exclude"] != "^DEBUG" {
+		t.Errorf("expected the processors copied got %+v", clone.Processors)
+	}
+
+	clone.Processors[0].Options["exclude"] = "^INFO"
+	if route.Options["append_tag"] != ".db" || route.Address != "old.example.com:514" || route.Processors[0].Options["exclude"] != "^DEBUG" {
+		t.Errorf("expected the cloned route unchanged got %+v", route)
+	}
+}
+
+func TestCloneRouteEmptyBody(t *testing.T) {
+	route := &router.Route{ID: "abc", Adapter: "raw", Address: "logs.example.com:5000"}
+	clone, err := cloneRoute(route, strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clone.ID != "" || clone.Address != route.Address {
+		t.Errorf("unexpected clone %+v", clone)
+	}
+	if _, err := cloneRoute(route, strings.NewReader(`{"id": "abc"}`)); err == nil {
+		t.Error("expected an error cloning to the same id")
+	}
+	if _, err := cloneRoute(route, strings.NewReader(`{"address": `)); err == nil {
+		t.Error("expected an error for invalid overrides")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	os.Setenv("ROUTES_READONLY", "true")
+	defer os.Unsetenv("ROUTES_READONLY")
+	api := RoutesAPI()
+	for _, test := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/routes", http.StatusOK},
+		{"POST", "/routes", http.StatusForbidden},
+		{"DELETE", "/routes/abc", http.StatusForbidden},
+		{"POST", "/routes/abc/clone", http.StatusForbidden},
+		{"PUT", "/routes/abc/schedule", http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, httptest.NewRequest(test.method, test.path, strings.NewReader(`{"adapter": "raw"}`)))
+		if w.Code != test.status {
+			t.Errorf("%s %s: expected %v got %v", test.method, test.path, test.status, w.Code)
+		}
+	}
+}
+
+type fakeAdapter struct{}
+
+func (fakeAdapter) Stream(logstream chan *router.Message) {}
+
+func init() {
+	router.AdapterFactories.Register(func(route *router.Route) (router.LogAdapter, error) {
+		return fakeAdapter{}, nil
+	}, "fake")
+}
+
+func request(api http.Handler, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	api.ServeHTTP(w, req)
+	return w
+}
+
+func TestPutRoute(t *testing.T) {
+	api := RoutesAPI()
+	defer router.Routes.Remove("put-test")
+	if w := request(api, "PUT", "/routes/put-test", `{"adapter": "fake", "address": "a:1"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected the route created got %v %s", w.Code, w.Body)
+	}
+	if w := request(api, "PUT", "/routes/put-test", `{"adapter": "fake", "address": "b:2"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected the route replaced got %v %s", w.Code, w.Body)
+	}
+	if route, _ := router.Routes.Get("put-test"); route == nil || route.Address != "b:2" {
+		t.Errorf("expected the replaced route got %+v", route)
+	}
+	for _, body := range []string{
+		`{"id": "other", "adapter": "fake"}`,
+		`{"adapter": "fake", "filter_names": "*_db"}`,
+		`{"address": "a:1"}`,
+		`{"adapter": "fake", "processors": [{"options": {}}]}`,
+	} {
+		if w := request(api, "PUT", "/routes/put-test", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected a bad request got %v", body, w.Code)
+		}
+	}
+	if w := request(api, "POST", "/routes", `{"id": "../etc", "adapter": "fake"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid id rejected got %v", w.Code)
+	}
+}
+
+func TestRoutesAPIToken(t *testing.T) {
+	os.Setenv("ROUTES_API_TOKEN", "secret")
+	defer os.Unsetenv("ROUTES_API_TOKEN")
+	api := RoutesAPI()
+	if w := request(api, "GET", "/routes", ""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("expected unauthorized got %v", w.Code)
+	}
+	if w := request(api, "GET", "/routes", "", "Authorization", "Bearer wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized for a wrong token got %v", w.Code)
+	}
+	if w := request(api, "GET", "/routes", "", "Authorization", "Bearer secret"); w.Code != http.StatusOK {
+		t.Errorf("expected ok got %v", w.Code)
+	}
+}
+
+func TestRouteSchedule(t *testing.T) {
+	api := RoutesAPI()
+	defer router.Routes.Remove("schedule-test")
+	defer router.Routes.Remove("unscheduled-test")
+	router.Routes.Add(&router.Route{ID: "schedule-test", Adapter: "fake", Options: map[string]string{"schedule": "Mon 09:00-10:00"}})
+	router.Routes.Add(&router.Route{ID: "unscheduled-test", Adapter: "fake"})
+	var state scheduleState
+	w := request(api, "GET", "/routes/schedule-test/schedule", "")
+	if json.Unmarshal(w.Body.Bytes(), &state); w.Code != http.StatusOK || state.State != "auto" {
+		t.Errorf("expected the auto state got %v %s", w.Code, w.Body)
+	}
+	w = request(api, "PUT", "/routes/schedule-test/schedule", `{"state": "active"}`)
+	if json.Unmarshal(w.Body.Bytes(), &state); w.Code != http.StatusOK || state.State != "active" || !state.Active {
+		t.Errorf("expected the route switched active got %v %s", w.Code, w.Body)
+	}
+	for _, test := range []struct {
+		path   string
+		body   string
+		status int
+	}{
+		{"/routes/schedule-test/schedule", `{"state": "paused"}`, http.StatusBadRequest},
+		{"/routes/unscheduled-test/schedule", `{"state": "active"}`, http.StatusBadRequest},
+		{"/routes/missing/schedule", `{"state": "active"}`, http.StatusNotFound},
+	} {
+		if w := request(api, "PUT", test.path, test.body); w.Code != test.status {
+			t.Errorf("%s %s: expected %v got %v", test.path, test.body, test.status, w.Code)
+		}
+	}
+}
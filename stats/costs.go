@@ -0,0 +1,76 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const bytesPerGB = 1e9
+
+// PricePerGB returns the price of sending a GB on the route with id. It can
+// be replaced by custom modules with pricing of their own, and defaults to
+// the route's price_per_gb option, or COSTS_PRICE_PER_GB.
+var PricePerGB = defaultPricePerGB
+
+func defaultPricePerGB(id string) float64 {
+	value := getopt("COSTS_PRICE_PER_GB", "0")
+	if route, err := router.Routes.Get(id); err == nil && route.Options["price_per_gb"] != "" {
+		value = route.Options["price_per_gb"]
+	}
+	price, err := strconv.ParseFloat(value, 64)
+	if err != nil || price < 0 {
+		logger.Error("invalid value for price_per_gb (must be a non-negative number)", "route", id, "value", value)
+		return 0
+	}
+	return price
+}
+
+// UsageCost is the usage of a route or container with its estimated cost
+type UsageCost struct {
+	router.ByteUsage
+	Cost float64 `json:"cost"`
+}
+
+func (c *UsageCost) add(usage router.ByteUsage, price float64) {
+	c.Messages += usage.Messages
+	c.Rendered += usage.Rendered
+	c.Sent += usage.Sent
+	c.Cost += float64(usage.Sent) / bytesPerGB * price
+}
+
+// RouteCosts is the usage and estimated cost of a route and its containers
+type RouteCosts struct {
+	UsageCost
+	PricePerGB float64               `json:"price_per_gb"`
+	Containers map[string]*UsageCost `json:"containers"`
+}
+
+// Costs estimates the cost of the bytes each route has sent, by container
+func Costs(usage map[string]map[string]router.ByteUsage) map[string]interface{} {
+	routes := make(map[string]*RouteCosts, len(usage))
+	total := new(UsageCost)
+	for id, containers := range usage {
+		costs := &RouteCosts{PricePerGB: PricePerGB(id), Containers: make(map[string]*UsageCost, len(containers))}
+		for group, u := range containers {
+			cost := new(UsageCost)
+			cost.add(u, costs.PricePerGB)
+			costs.Containers[group] = cost
+			costs.add(u, costs.PricePerGB)
+			total.add(u, costs.PricePerGB)
+		}
+		routes[id] = costs
+	}
+	return map[string]interface{}{
+		"currency": getopt("COSTS_CURRENCY", "USD"),
+		"routes":   routes,
+		"total":    total,
+	}
+}
+
+func serveCosts(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Costs(router.Usage.Snapshot()))
+}
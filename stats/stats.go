@@ -0,0 +1,177 @@
+package stats
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/gorilla/mux"
+)
+
+const recentReceipts = 100
+
+var logger = router.NewLogger("stats")
+
+func init() {
+	router.HttpHandlers.Register(Stats, "stats")
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+// RouteReceipts summarizes the delivery receipts of a route
+type RouteReceipts struct {
+	Delivered  uint64            `json:"delivered"`
+	Failed     uint64            `json:"failed"`
+	Dropped    uint64            `json:"dropped"`
+	LastError  string            `json:"last_error,omitempty"`
+	Errors     map[string]uint64 `json:"errors,omitempty"`
+	MaxLatency time.Duration     `json:"max_latency"`
+	latency    time.Duration
+}
+
+// AvgLatency returns the mean latency of delivered messages
+func (r *RouteReceipts) AvgLatency() time.Duration {
+	if r.Delivered == 0 {
+		return 0
+	}
+	return r.latency / time.Duration(r.Delivered)
+}
+
+// MarshalJSON adds the average latency to the summary
+func (r *RouteReceipts) MarshalJSON() ([]byte, error) {
+	type summary RouteReceipts
+	return json.Marshal(struct {
+		*summary
+		AvgLatency time.Duration `json:"avg_latency"`
+	}{(*summary)(r), r.AvgLatency()})
+}
+
+// Receipts aggregates the delivery receipt stream
+type Receipts struct {
+	mu     sync.Mutex
+	sample float64
+	routes map[string]*RouteReceipts
+	recent []*router.Receipt
+}
+
+// NewReceipts returns Receipts keeping a sample fraction of receipts
+func NewReceipts(sample float64) *Receipts {
+	return &Receipts{
+		sample: sample,
+		routes: make(map[string]*RouteReceipts),
+	}
+}
+
+// Add counts a receipt and keeps it if it is sampled
+func (r *Receipts) Add(receipt *router.Receipt) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	summary, ok := r.routes[receipt.Route]
+	if !ok {
+		summary = new(RouteReceipts)
+		r.routes[receipt.Route] = summary
+	}
+	switch receipt.Status {
+	case router.StatusDelivered:
+		summary.Delivered++
+		summary.latency += receipt.Latency
+		if receipt.Latency > summary.MaxLatency {
+			summary.MaxLatency = receipt.Latency
+		}
+	case router.StatusDropped:
+		summary.Dropped++
+	default:
+		summary.Failed++
+		summary.LastError = receipt.Error
+		if summary.Errors == nil {
+			summary.Errors = make(map[string]uint64)
+		}
+		summary.Errors[receipt.Category]++
+	}
+	if r.sample >= 1 || rand.Float64() < r.sample {
+		r.recent = append(r.recent, receipt)
+		if len(r.recent) > recentReceipts {
+			r.recent = r.recent[1:]
+		}
+	}
+}
+
+func (r *Receipts) run(receipts chan *router.Receipt) {
+	for receipt := range receipts {
+		r.Add(receipt)
+	}
+}
+
+// ServeHTTP writes the receipt summaries and recently sampled receipts
+func (r *Receipts) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"routes": r.routes,
+		"recent": r.recent,
+	})
+}
+
+// Stats returns a http.Handler for the stats endpoint. Delivery receipts are
+// only collected when RECEIPTS_SAMPLE is set.
+func Stats() http.Handler {
+	r := mux.NewRouter()
+	receipts := NewReceipts(0)
+	if sampleStr := getopt("RECEIPTS_SAMPLE", ""); sampleStr != "" {
+		sample, err := strconv.ParseFloat(sampleStr, 64)
+		if err != nil || sample < 0 || sample > 1 {
+			logger.Error("invalid value for RECEIPTS_SAMPLE (must be between 0 and 1)", "value", sampleStr)
+		} else {
+			receipts.sample = sample
+			go receipts.run(router.Receipts.Subscribe())
+		}
+	}
+	r.Handle("/stats/receipts", receipts).Methods("GET")
+	r.HandleFunc("/stats/budgets", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(router.Budgets)
+	}).Methods("GET")
+	r.HandleFunc("/stats/breakers", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(router.Breakers)
+	}).Methods("GET")
+	r.HandleFunc("/stats/counters", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(router.Counters)
+	}).Methods("GET")
+	r.HandleFunc("/stats/latency", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(router.Latencies)
+	}).Methods("GET")
+	r.HandleFunc("/stats/costs", serveCosts).Methods("GET")
+	r.HandleFunc("/stats", func(w http.ResponseWriter, req *http.Request) {
+		receipts.mu.Lock()
+		defer receipts.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"receipts":  receipts.routes,
+			"latency":   router.Latencies,
+			"endpoints": router.EndpointHealth(),
+			"counters":  router.Counters,
+			"budgets":   router.Budgets,
+			"breakers":  router.Breakers,
+			"costs":     Costs(router.Usage.Snapshot()),
+			// running containers whose log driver keeps logspout from
+			// reading their logs
+			"unstreamable": router.Unstreamable.Len(),
+		})
+	}).Methods("GET")
+	return r
+}
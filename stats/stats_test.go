@@ -0,0 +1,69 @@
+package stats
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestReceipts(t *testing.T) {
+	r := NewReceipts(1)
+	r.Add(&router.Receipt{Route: "abc", Status: router.StatusDelivered, Latency: 10 * time.Millisecond})
+	r.Add(&router.Receipt{Route: "abc", Status: router.StatusDelivered, Latency: 30 * time.Millisecond})
+	r.Add(&router.Receipt{Route: "abc", Status: router.StatusFailed, Error: "broken pipe", Category: router.ErrorConnect})
+	r.Add(&router.Receipt{Route: "abc", Status: router.StatusDropped})
+	summary := r.routes["abc"]
+	if summary.Delivered != 2 || summary.Failed != 1 || summary.Dropped != 1 || summary.LastError != "broken pipe" || summary.Errors[router.ErrorConnect] != 1 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if summary.AvgLatency() != 20*time.Millisecond || summary.MaxLatency != 30*time.Millisecond {
+		t.Errorf("unexpected latencies avg %s max %s", summary.AvgLatency(), summary.MaxLatency)
+	}
+	if len(r.recent) != 4 {
+		t.Errorf("expected 4 sampled receipts got %v", len(r.recent))
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/stats/receipts", nil))
+	if body := w.Body.String(); !strings.Contains(body, `"avg_latency":20000000`) {
+		t.Errorf("expected avg_latency in %s", body)
+	}
+}
+
+func TestReceiptsUnsampled(t *testing.T) {
+	r := NewReceipts(0)
+	for i := 0; i < 10; i++ {
+		r.Add(&router.Receipt{Route: "abc", Status: router.StatusDelivered})
+	}
+	if r.routes["abc"].Delivered != 10 || len(r.recent) != 0 {
+		t.Errorf("expected 10 counted and no sampled receipts, got %+v %v", r.routes["abc"], len(r.recent))
+	}
+}
+
+func TestCosts(t *testing.T) {
+	os.Setenv("COSTS_PRICE_PER_GB", "0.5")
+	defer os.Unsetenv("COSTS_PRICE_PER_GB")
+	costs := Costs(map[string]map[string]router.ByteUsage{
+		"abc": {
+			"web": {Messages: 10, Rendered: 3e9, Sent: 2e9},
+			"api": {Messages: 5, Rendered: 1e9, Sent: 1e9},
+		},
+	})
+	route := costs["routes"].(map[string]*RouteCosts)["abc"]
+	if route.PricePerGB != 0.5 || route.Sent != 3e9 || route.Cost != 1.5 || route.Containers["web"].Cost != 1 {
+		t.Errorf("expected the sent bytes priced per GB got %+v %+v", route, route.Containers["web"])
+	}
+	if total := costs["total"].(*UsageCost); total.Messages != 15 || total.Rendered != 4e9 || total.Cost != 1.5 {
+		t.Errorf("unexpected total %+v", total)
+	}
+
+	PricePerGB = func(id string) float64 { return 2 }
+	defer func() { PricePerGB = defaultPricePerGB }()
+	if route := Costs(map[string]map[string]router.ByteUsage{"abc": {"web": {Sent: 1e9}}})["routes"].(map[string]*RouteCosts)["abc"]; route.Cost != 2 {
+		t.Errorf("expected custom pricing used got %+v", route)
+	}
+}
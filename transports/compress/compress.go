@@ -0,0 +1,193 @@
+package compress
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+const defaultFlushInterval = time.Second
+
+var errClosed = errors.New("compress: use of closed connection")
+
+// Options are the route options of connections that can be compressed
+var Options = []string{"compress", "compress_level", "compress_flush_interval"}
+
+type writer interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Wrap returns a connection compressing everything written to conn with the
+// algorithm named by the compress option, either gzip, zstd or snappy for
+// the snappy framing format, or conn itself
+// when the option is unset. The compress_level option sets the algorithm's
+// level and compress_flush_interval how long compressed data may be held
+// before it is flushed to the receiver (default 1s, 0 flushes every write).
+// conn is closed if the options are invalid.
+func Wrap(conn net.Conn, options map[string]string) (net.Conn, error) {
+	c, err := wrap(conn, options)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func wrap(conn net.Conn, options map[string]string) (net.Conn, error) {
+	algorithm := options["compress"]
+	if algorithm == "" {
+		return conn, nil
+	}
+	interval := defaultFlushInterval
+	if value := options["compress_flush_interval"]; value != "" {
+		var err error
+		if interval, err = time.ParseDuration(value); err != nil || interval < 0 {
+			return nil, errors.New("compress: invalid value for compress_flush_interval: " + value)
+		}
+	}
+	level, err := parseLevel(options["compress_level"])
+	if err != nil {
+		return nil, err
+	}
+	var w writer
+	switch algorithm {
+	case "gzip":
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		if w, err = gzip.NewWriterLevel(conn, level); err != nil {
+			return nil, errors.New("compress: invalid value for compress_level: " + options["compress_level"])
+		}
+	case "zstd":
+		encoderLevel := zstd.SpeedDefault
+		if level != 0 {
+			if level < 1 || level > 22 {
+				return nil, errors.New("compress: invalid value for compress_level: " + options["compress_level"])
+			}
+			encoderLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		if w, err = zstd.NewWriter(conn, zstd.WithEncoderLevel(encoderLevel), zstd.WithEncoderConcurrency(1)); err != nil {
+			return nil, err
+		}
+	case "snappy":
+		opts := []s2.WriterOption{s2.WriterSnappyCompat(), s2.WriterConcurrency(1)}
+		switch level {
+		case 0, 1:
+		case 2:
+			opts = append(opts, s2.WriterBetterCompression())
+		case 3:
+			opts = append(opts, s2.WriterBestCompression())
+		default:
+			return nil, errors.New("compress: invalid value for compress_level: " + options["compress_level"])
+		}
+		w = s2.NewWriter(conn, opts...)
+	default:
+		return nil, errors.New("compress: invalid value for compress: " + algorithm)
+	}
+	c := &Conn{Conn: conn, w: w, interval: interval, stop: make(chan struct{})}
+	if interval > 0 {
+		go c.flusher()
+	}
+	return c, nil
+}
+
+func parseLevel(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	level, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("compress: invalid value for compress_level: " + value)
+	}
+	return level, nil
+}
+
+// Conn is a net.Conn compressing its writes as a single stream
+type Conn struct {
+	net.Conn
+	mu       sync.Mutex
+	w        writer
+	interval time.Duration
+	pending  bool
+	err      error
+	stop     chan struct{}
+	closed   bool
+}
+
+// Write compresses p. Errors flushing earlier writes in the background are
+// returned by the next Write.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	if err != nil {
+		c.err = err
+		return n, err
+	}
+	if c.interval == 0 {
+		if err = c.w.Flush(); err != nil {
+			c.err = err
+		}
+		return n, err
+	}
+	c.pending = true
+	return n, nil
+}
+
+// Flush writes any compressed data held back to the connection
+func (c *Conn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flush()
+}
+
+func (c *Conn) flush() error {
+	if c.err != nil || !c.pending {
+		return c.err
+	}
+	c.pending = false
+	c.err = c.w.Flush()
+	return c.err
+}
+
+// flusher flushes pending data every interval so the receiver isn't starved
+// of messages on quiet connections
+func (c *Conn) flusher() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.Flush()
+		}
+	}
+}
+
+// Close ends the compressed stream and closes the connection
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.stop)
+		if c.err == nil {
+			if c.err = c.w.Close(); c.err == nil {
+				c.err = errClosed
+			}
+		}
+	}
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
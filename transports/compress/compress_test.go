@@ -0,0 +1,104 @@
+package compress
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+func readLines(t *testing.T, algorithm string, options map[string]string) {
+	client, server := net.Pipe()
+	defer server.Close()
+	options["compress"] = algorithm
+	conn, err := Wrap(client, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	lines := make(chan string)
+	go func() {
+		var r io.Reader
+		switch algorithm {
+		case "gzip":
+			gz, err := gzip.NewReader(server)
+			if err != nil {
+				close(lines)
+				return
+			}
+			r = gz
+		case "zstd":
+			zr, err := zstd.NewReader(server)
+			if err != nil {
+				close(lines)
+				return
+			}
+			defer zr.Close()
+			r = zr
+		case "snappy":
+			r = snappy.NewReader(server)
+		}
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	for _, expected := range []string{"first message", "second message"} {
+		if _, err := conn.Write([]byte(expected + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case line := <-lines:
+			if line != expected {
+				t.Errorf("expected %q got %q", expected, line)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: %q was not flushed", algorithm, expected)
+		}
+	}
+}
+
+func TestCompressFlushInterval(t *testing.T) {
+	for _, algorithm := range []string{"gzip", "zstd", "snappy"} {
+		readLines(t, algorithm, map[string]string{"compress_flush_interval": "10ms"})
+	}
+}
+
+func TestCompressFlushEveryWrite(t *testing.T) {
+	for _, algorithm := range []string{"gzip", "zstd", "snappy"} {
+		readLines(t, algorithm, map[string]string{"compress_flush_interval": "0", "compress_level": "3"})
+	}
+}
+
+func TestCompressUnset(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn, err := Wrap(client, map[string]string{})
+	if err != nil || conn != client {
+		t.Errorf("expected connection to be returned unwrapped, got %v %v", conn, err)
+	}
+}
+
+func TestCompressInvalidOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"compress": "lz4"},
+		{"compress": "gzip", "compress_level": "10"},
+		{"compress": "zstd", "compress_level": "fast"},
+		{"compress": "snappy", "compress_level": "4"},
+		{"compress": "zstd", "compress_flush_interval": "-1s"},
+	} {
+		client, server := net.Pipe()
+		if _, err := Wrap(client, options); err == nil {
+			t.Errorf("expected error for %v", options)
+		}
+		server.Close()
+	}
+}
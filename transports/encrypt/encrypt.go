@@ -0,0 +1,179 @@
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// Options are the route options of connections that can be encrypted
+var Options = []string{"encrypt_key"}
+
+// version is the first byte of a sealed box, for the scheme below
+const version = 1
+
+// overhead is the bytes a sealed box adds to its message: the version, the
+// ephemeral public key and the GCM tag
+const overhead = 1 + 32 + 16
+
+// info binds the derived keys to this scheme
+var info = []byte("logspout sealed box v1")
+
+var errInvalid = errors.New("encrypt: invalid sealed box")
+
+// ParsePublicKey parses a base64 encoded X25519 public key. An unescaped +
+// in a route URI query is decoded as a space, so spaces are read as +.
+func ParsePublicKey(s string) (*ecdh.PublicKey, error) {
+	key, err := decodeKey(s)
+	if err != nil {
+		return nil, errors.New("encrypt: invalid value for encrypt_key (must be a base64 X25519 public key): " + s)
+	}
+	return ecdh.X25519().NewPublicKey(key)
+}
+
+// ParsePrivateKey parses a base64 encoded X25519 private key
+func ParsePrivateKey(s string) (*ecdh.PrivateKey, error) {
+	key, err := decodeKey(s)
+	if err != nil {
+		return nil, errors.New("encrypt: invalid private key (must be a base64 X25519 key)")
+	}
+	return ecdh.X25519().NewPrivateKey(key)
+}
+
+func decodeKey(s string) ([]byte, error) {
+	s = strings.Replace(strings.TrimSpace(s), " ", "+", -1)
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if key, err := enc.DecodeString(s); err == nil && len(key) == 32 {
+			return key, nil
+		}
+	}
+	return nil, errInvalid
+}
+
+// EncodeKey returns the base64 encoding of a public or private key
+func EncodeKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// GenerateKey returns a new private key, whose PublicKey messages are
+// encrypted for
+func GenerateKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// Seal encrypts message for the holder of the private key of recipient, as
+// a sealed box that can only be opened with that key and doesn't reveal
+// its sender. Each box has a new ephemeral X25519 key, whose shared secret
+// with recipient derives an AES-256-GCM key with HKDF-SHA256:
+//
+//	version (1) | ephemeral public key (32) | ciphertext | tag (16)
+func Seal(recipient *ecdh.PublicKey, message []byte) ([]byte, error) {
+	ephemeral, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := boxCipher(ephemeral, recipient, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return nil, err
+	}
+	box := make([]byte, 0, len(message)+overhead)
+	box = append(append(box, version), ephemeral.PublicKey().Bytes()...)
+	// the key is only ever used for this box, so the nonce can be fixed
+	return aead.Seal(box, make([]byte, aead.NonceSize()), message, nil), nil
+}
+
+// Open decrypts a box sealed for the public key of key
+func Open(key *ecdh.PrivateKey, box []byte) ([]byte, error) {
+	if len(box) < overhead || box[0] != version {
+		return nil, errInvalid
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(box[1:33])
+	if err != nil {
+		return nil, errInvalid
+	}
+	aead, err := boxCipher(key, ephemeral, ephemeral, key.PublicKey())
+	if err != nil {
+		return nil, errInvalid
+	}
+	message, err := aead.Open(nil, make([]byte, aead.NonceSize()), box[33:], nil)
+	if err != nil {
+		return nil, errInvalid
+	}
+	return message, nil
+}
+
+// boxCipher returns the AES-256-GCM cipher of the shared secret of private
+// and public, salted with the ephemeral and recipient public keys
+func boxCipher(private *ecdh.PrivateKey, public, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	secret, err := private.ECDH(public)
+	if err != nil {
+		return nil, err
+	}
+	// HKDF-SHA256 of RFC 5869, expanded to a single block
+	extract := hmac.New(sha256.New, append(ephemeral.Bytes(), recipient.Bytes()...))
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+	block, err := aes.NewCipher(expand.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Wrap returns a connection encrypting each write to conn as a sealed box
+// for the public key in the encrypt_key option, or conn itself when the
+// option is unset. Over datagram connections each box is a datagram, while
+// over streams each is a line of base64, so the boxes can be told apart
+// and relayed as text. conn is closed if the option is invalid.
+func Wrap(conn net.Conn, options map[string]string) (net.Conn, error) {
+	if options["encrypt_key"] == "" {
+		return conn, nil
+	}
+	recipient, err := ParsePublicKey(options["encrypt_key"])
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{Conn: conn, recipient: recipient, datagram: router.Datagram(conn)}, nil
+}
+
+// Conn is a connection encrypting what is written to it
+type Conn struct {
+	net.Conn
+	recipient *ecdh.PublicKey
+	datagram  bool
+}
+
+// Datagram reports whether each write is sent as a single datagram
+func (c *Conn) Datagram() bool {
+	return c.datagram
+}
+
+// Write sends p, a rendered message or batch, as a sealed box
+func (c *Conn) Write(p []byte) (int, error) {
+	box, err := Seal(c.recipient, p)
+	if err != nil {
+		return 0, err
+	}
+	if !c.datagram {
+		line := make([]byte, base64.StdEncoding.EncodedLen(len(box))+1)
+		base64.StdEncoding.Encode(line, box)
+		line[len(line)-1] = '\n'
+		box = line
+	}
+	if _, err := c.Conn.Write(box); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
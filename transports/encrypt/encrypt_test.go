@@ -0,0 +1,90 @@
+package encrypt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := GenerateKey()
+	box, err := Seal(key.PublicKey(), []byte("secret line\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(box) != len("secret line\n")+overhead || bytes.Contains(box, []byte("secret")) {
+		t.Errorf("unexpected box %q", box)
+	}
+	again, _ := Seal(key.PublicKey(), []byte("secret line\n"))
+	if bytes.Equal(box, again) {
+		t.Error("expected boxes of the same message to differ")
+	}
+	if message, err := Open(key, box); err != nil || string(message) != "secret line\n" {
+		t.Errorf("expected the message got %q %v", message, err)
+	}
+	if _, err := Open(other, box); err == nil {
+		t.Error("expected another key not to open the box")
+	}
+	box[len(box)-1] ^= 1
+	if _, err := Open(key, box); err == nil {
+		t.Error("expected a modified box not to open")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	key, _ := GenerateKey()
+	public := EncodeKey(key.PublicKey().Bytes())
+	// as decoded from an unescaped route URI query
+	parsed, err := ParsePublicKey(string(bytes.Replace([]byte(public), []byte("+"), []byte(" "), -1)))
+	if err != nil || !parsed.Equal(key.PublicKey()) {
+		t.Errorf("expected the public key got %v", err)
+	}
+	if parsed, err := ParsePrivateKey(EncodeKey(key.Bytes()) + "\n"); err != nil || !parsed.Equal(key) {
+		t.Errorf("expected the private key got %v", err)
+	}
+	for _, value := range []string{"key", base64.StdEncoding.EncodeToString(make([]byte, 16))} {
+		if _, err := ParsePublicKey(value); err == nil {
+			t.Errorf("expected an error for %s", value)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	key, _ := GenerateKey()
+	options := map[string]string{"encrypt_key": EncodeKey(key.PublicKey().Bytes())}
+
+	client, server := net.Pipe()
+	defer server.Close()
+	conn, err := Wrap(client, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go conn.Write([]byte("<14>1 - host app - - - line\n"))
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(server).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	box, err := base64.StdEncoding.DecodeString(line[:len(line)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message, err := Open(key, box); err != nil || string(message) != "<14>1 - host app - - - line\n" {
+		t.Errorf("expected the message got %q %v", message, err)
+	}
+
+	if c, _ := Wrap(client, map[string]string{}); c != client {
+		t.Error("expected connections without encrypt_key not to be wrapped")
+	}
+	if _, err := Wrap(client, map[string]string{"encrypt_key": "invalid"}); err == nil {
+		t.Error("expected an error for an invalid key")
+	}
+}
@@ -0,0 +1,294 @@
+package relp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	// largest transaction number, after which they start again at 1
+	maxTxnr = 999999999
+	// largest response data accepted from a receiver
+	maxResponse = 128 * 1024
+	// how long closing waits for the receiver to confirm
+	closeTimeout = time.Second
+)
+
+var options = []string{"relp_window", "relp_timeout"}
+
+func init() {
+	router.AdapterTransports.Register(&relpTransport{"tcp"}, "relp")
+	router.AdapterTransports.Register(&relpTransport{"tls"}, "relp-tls")
+	router.Capabilities.DescribeTransport("relp", options)
+	router.Capabilities.DescribeTransport("relp-tls", options)
+}
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+// getOpt returns a route option, falling back to an env var
+func getOpt(opts map[string]string, option, env, dfault string) string {
+	if opts[option] != "" {
+		return opts[option]
+	}
+	return getopt(env, dfault)
+}
+
+// relpTransport speaks RELP over the connections of another transport
+type relpTransport struct {
+	transport string
+}
+
+func (t *relpTransport) Dial(addr string, opts map[string]string) (net.Conn, error) {
+	value := getOpt(opts, "relp_window", "RELP_WINDOW", "128")
+	window, err := strconv.Atoi(value)
+	if err != nil || window < 1 {
+		return nil, errors.New("relp: invalid value for relp_window (must be at least 1): " + value)
+	}
+	value = getOpt(opts, "relp_timeout", "RELP_TIMEOUT", "10s")
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return nil, errors.New("relp: invalid value for relp_timeout: " + value)
+	}
+	transport, found := router.AdapterTransports.Lookup(t.transport)
+	if !found {
+		return nil, errors.New("relp: transport not available: " + t.transport)
+	}
+	conn, err := transport.Dial(addr, opts)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{Conn: conn, r: bufio.NewReader(conn), window: window, timeout: timeout}
+	if err := c.open(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Conn sends each line written to it as a RELP syslog command, and only
+// returns from a write once the receiver acknowledged all of its lines
+type Conn struct {
+	net.Conn
+	mu      sync.Mutex
+	r       *bufio.Reader
+	txnr    int
+	window  int
+	timeout time.Duration
+	broken  error
+}
+
+// open starts the RELP session, making sure the receiver accepts syslog
+// commands
+func (c *Conn) open() error {
+	offers := "relp_version=0\nrelp_software=logspout,,https://github.com/gliderlabs/logspout\ncommands=syslog"
+	txnr, err := c.send("open", []byte(offers))
+	if err != nil {
+		return err
+	}
+	data, err := c.response(txnr)
+	if err != nil {
+		return err
+	}
+	for _, offer := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(offer, "commands=") && strings.Contains(","+offer[len("commands="):]+",", ",syslog,") {
+			return nil
+		}
+	}
+	return errors.New("relp: the receiver does not accept syslog commands")
+}
+
+// Write sends each line of p as a syslog command, up to relp_window at a
+// time, and waits until the receiver acknowledged every one. An error is
+// returned if any was not acknowledged within relp_timeout, in which case
+// all of p should be sent again after reconnecting, or if any was rejected by
+// the receiver, with the dropped error category.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.broken != nil {
+		return 0, c.broken
+	}
+	var pending []int
+	var rejected error
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		if len(line) == 0 {
+			// e.g. noop heartbeats
+			continue
+		}
+		if len(pending) == c.window {
+			if err := c.acknowledged(pending[0]); err != nil {
+				if !isRejected(err) {
+					return 0, err
+				}
+				rejected = err
+			}
+			pending = pending[1:]
+		}
+		txnr, err := c.send("syslog", line)
+		if err != nil {
+			return 0, err
+		}
+		pending = append(pending, txnr)
+	}
+	for _, txnr := range pending {
+		if err := c.acknowledged(txnr); err != nil {
+			if !isRejected(err) {
+				return 0, err
+			}
+			rejected = err
+		}
+	}
+	if rejected != nil {
+		// sending the lines again would only have them rejected again
+		return 0, router.NewDeliveryError(router.ErrorDropped, rejected)
+	}
+	return len(p), nil
+}
+
+// Close ends the RELP session and closes the connection
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.broken == nil {
+		c.timeout = closeTimeout
+		if txnr, err := c.send("close", nil); err == nil {
+			c.response(txnr)
+		}
+		c.broken = errors.New("relp: connection closed")
+	}
+	return c.Conn.Close()
+}
+
+// send writes a command frame, returning its transaction number
+func (c *Conn) send(command string, data []byte) (int, error) {
+	if c.txnr++; c.txnr > maxTxnr {
+		c.txnr = 1
+	}
+	frame := make([]byte, 0, len(data)+32)
+	frame = append(frame, strconv.Itoa(c.txnr)...)
+	frame = append(frame, ' ')
+	frame = append(frame, command...)
+	frame = append(frame, ' ')
+	frame = strconv.AppendInt(frame, int64(len(data)), 10)
+	if len(data) > 0 {
+		frame = append(frame, ' ')
+		frame = append(frame, data...)
+	}
+	frame = append(frame, '\n')
+	if _, err := c.Conn.Write(frame); err != nil {
+		c.broken = err
+		return 0, err
+	}
+	return c.txnr, nil
+}
+
+// rejectedError is a negative response of the receiver, after which the
+// session can go on
+type rejectedError struct {
+	response string
+}
+
+func (e *rejectedError) Error() string {
+	return "relp: receiver rejected message: " + e.response
+}
+
+func isRejected(err error) bool {
+	_, ok := err.(*rejectedError)
+	return ok
+}
+
+// acknowledged waits for the response to txnr, returning an error if it
+// isn't positive
+func (c *Conn) acknowledged(txnr int) error {
+	data, err := c.response(txnr)
+	if err == nil && !bytes.HasPrefix(data, []byte("200")) {
+		err = &rejectedError{strings.SplitN(string(data), "\n", 2)[0]}
+	}
+	return err
+}
+
+// response reads the response to the command with txnr, returning its data.
+// Responses arrive in the order of their commands, so any other frame ends
+// the session.
+func (c *Conn) response(txnr int) ([]byte, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	gotTxnr, command, data, err := c.readFrame()
+	if err != nil {
+		if opError, ok := err.(net.Error); ok && opError.Timeout() {
+			err = router.NewDeliveryError(router.ErrorTimeout,
+				fmt.Errorf("relp: no response to transaction %v within %s", txnr, c.timeout))
+		}
+		c.broken = err
+		return nil, err
+	}
+	if command == "serverclose" {
+		c.broken = errors.New("relp: receiver closed the session")
+		return nil, c.broken
+	}
+	if command != "rsp" || gotTxnr != txnr {
+		c.broken = fmt.Errorf("relp: unexpected %s %v in response to transaction %v", command, gotTxnr, txnr)
+		return nil, c.broken
+	}
+	return data, nil
+}
+
+// readFrame reads a frame like TXNR SP COMMAND SP DATALEN [SP DATA] LF
+func (c *Conn) readFrame() (int, string, []byte, error) {
+	field, err := c.r.ReadString(' ')
+	if err != nil {
+		return 0, "", nil, err
+	}
+	txnr, err := strconv.Atoi(strings.TrimSuffix(field, " "))
+	if err != nil {
+		return 0, "", nil, fmt.Errorf("relp: invalid transaction number %q", field)
+	}
+	command, err := c.r.ReadString(' ')
+	if err != nil {
+		return 0, "", nil, err
+	}
+	command = strings.TrimSuffix(command, " ")
+	size := 0
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return 0, "", nil, err
+		}
+		if b == ' ' || b == '\n' {
+			if b == '\n' {
+				// frames without data may end right after DATALEN
+				return txnr, command, nil, nil
+			}
+			break
+		}
+		if b < '0' || b > '9' || size > maxResponse {
+			return 0, "", nil, fmt.Errorf("relp: invalid data length in the frame of transaction %v", txnr)
+		}
+		size = size*10 + int(b-'0')
+	}
+	data := make([]byte, size+1)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return 0, "", nil, err
+	}
+	if data[size] != '\n' {
+		return 0, "", nil, fmt.Errorf("relp: frame of transaction %v without trailer", txnr)
+	}
+	return txnr, command, data[:size], nil
+}
@@ -0,0 +1,138 @@
+package relp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+	_ "github.com/gliderlabs/logspout/transports/tcp"
+)
+
+// receiver is a RELP receiver acknowledging syslog commands unless their
+// message contains reject, and dropping the connection on drop
+type receiver struct {
+	ln       net.Listener
+	messages chan string
+	commands string
+}
+
+func newReceiver(t *testing.T, commands string) *receiver {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &receiver{ln: ln, messages: make(chan string, 100), commands: commands}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *receiver) serve(conn net.Conn) {
+	defer conn.Close()
+	c := &Conn{Conn: conn, r: bufio.NewReader(conn)}
+	for {
+		txnr, command, data, err := c.readFrame()
+		if err != nil {
+			return
+		}
+		response := "200 OK"
+		switch command {
+		case "open":
+			response += "\nrelp_version=0\ncommands=" + r.commands
+		case "syslog":
+			if strings.Contains(string(data), "drop") {
+				return
+			}
+			if strings.Contains(string(data), "reject") {
+				response = "500 rejected"
+			} else {
+				r.messages <- string(data)
+			}
+		case "close":
+			fmt.Fprintf(conn, "%v rsp 0\n0 serverclose 0\n", txnr)
+			return
+		}
+		fmt.Fprintf(conn, "%v rsp %v %s\n", txnr, len(response), response)
+	}
+}
+
+func TestRELPAcknowledged(t *testing.T) {
+	r := newReceiver(t, "syslog")
+	defer r.ln.Close()
+	conn, err := dial(r.ln.Addr().String(), map[string]string{"relp_window": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	batch := "<14>1 - host app - - - one\n<14>1 - host app - - - two\n<14>1 - host app - - - three\n"
+	if n, err := conn.Write([]byte(batch)); err != nil || n != len(batch) {
+		t.Fatalf("expected the batch acknowledged got %v %v", n, err)
+	}
+	for _, expected := range []string{"one", "two", "three"} {
+		if message := <-r.messages; !strings.HasSuffix(message, expected) {
+			t.Errorf("expected %s got %q", expected, message)
+		}
+	}
+	if _, err := conn.Write([]byte("<14>1 - host app - - - reject\n<14>1 - host app - - - four\n")); err == nil || !strings.Contains(err.Error(), "500 rejected") {
+		t.Errorf("expected the rejection returned got %v", err)
+	} else if router.ErrorCategory(err) != router.ErrorDropped {
+		t.Errorf("expected rejections in the dropped category got %v", router.ErrorCategory(err))
+	}
+	if _, err := conn.Write([]byte("<14>1 - host app - - - five\n")); err != nil {
+		t.Errorf("expected the session kept after a rejection got %v", err)
+	}
+	if _, err := conn.Write([]byte("<14>1 - host app - - - drop\n")); err == nil {
+		t.Error("expected an error when the receiver drops the connection before acknowledging")
+	}
+	if _, err := conn.Write([]byte("<14>1 - host app - - - six\n")); err == nil {
+		t.Error("expected writes to a broken session to fail")
+	}
+}
+
+func TestRELPTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			// never responds to the open command
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+	_, err = dial(ln.Addr().String(), map[string]string{"relp_timeout": "50ms"})
+	if router.ErrorCategory(err) != router.ErrorTimeout {
+		t.Errorf("expected a timeout error got %v", err)
+	}
+}
+
+func TestRELPOpen(t *testing.T) {
+	r := newReceiver(t, "eventlog")
+	defer r.ln.Close()
+	if _, err := dial(r.ln.Addr().String(), nil); err == nil {
+		t.Error("expected an error for a receiver not accepting syslog commands")
+	}
+	for _, options := range []map[string]string{{"relp_window": "0"}, {"relp_timeout": "soon"}} {
+		if _, err := dial(r.ln.Addr().String(), options); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+}
+
+func dial(addr string, options map[string]string) (net.Conn, error) {
+	return (&relpTransport{"tcp"}).Dial(addr, options)
+}
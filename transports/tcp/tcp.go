@@ -5,10 +5,13 @@ import (
 
 	"github.com/gliderlabs/logspout/adapters/raw"
 	"github.com/gliderlabs/logspout/router"
+	"github.com/gliderlabs/logspout/transports/compress"
+	"github.com/gliderlabs/logspout/transports/encrypt"
 )
 
 func init() {
 	router.AdapterTransports.Register(new(tcpTransport), "tcp")
+	router.Capabilities.DescribeTransport("tcp", append([]string{"keepalive", "write_timeout", "ip_version", "dns_refresh", "proxy"}, append(compress.Options, encrypt.Options...)...))
 	// convenience adapters around raw adapter
 	router.AdapterFactories.Register(rawTCPAdapter, "tcp")
 }
@@ -21,13 +24,42 @@ func rawTCPAdapter(route *router.Route) (router.LogAdapter, error) {
 type tcpTransport int
 
 func (t *tcpTransport) Dial(addr string, options map[string]string) (net.Conn, error) {
-	raddr, err := net.ResolveTCPAddr("tcp", addr)
+	timeout, err := router.DialTimeout(options)
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.DialTCP("tcp", nil, raddr)
+	keepAlive, err := router.KeepAlive(options)
 	if err != nil {
 		return nil, err
 	}
-	return conn, nil
+	writeTimeout, err := router.WriteTimeout(options)
+	if err != nil {
+		return nil, err
+	}
+	network, err := router.IPNetwork("tcp", options)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := router.DNSRefresh(options)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := router.ProxyFor(addr, options)
+	if err != nil {
+		return nil, err
+	}
+	// the timeout covers resolving addr as well as connecting
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: keepAlive}
+	conn, err := router.DialProxy(proxy, dialer, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if proxy == nil {
+		conn = router.FollowDNS(conn, addr, network, refresh, timeout, nil)
+	}
+	conn, err = encrypt.Wrap(router.TimeoutWrites(conn, writeTimeout), options)
+	if err != nil {
+		return nil, err
+	}
+	return compress.Wrap(conn, options)
 }
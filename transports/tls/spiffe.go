@@ -0,0 +1,255 @@
+// +build go1.8
+
+package tls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/internal/protowire"
+	"github.com/gliderlabs/logspout/router"
+	"golang.org/x/net/http2"
+)
+
+const (
+	// the Workload API is served by the SPIRE agent over gRPC
+	fetchX509SVIDURL = "http://localhost/SPIFFE.SpiffeWorkloadAPI/FetchX509SVID"
+	maxSVIDResponse  = 16 * 1024 * 1024
+
+	// how long a handshake waits for the first SVID
+	svidWait        = 10 * time.Second
+	svidRetryMin    = time.Second
+	svidRetryMax    = 30 * time.Second
+	errMalformedMsg = "spiffe: malformed workload API response"
+)
+
+// svidSource keeps the X509-SVID streamed by the SPIFFE Workload API,
+// which rotates it before it expires
+type svidSource struct {
+	path   string
+	id     string
+	client *http.Client
+
+	mu    sync.RWMutex
+	cert  *tls.Certificate
+	err   error
+	ready chan struct{}
+	once  sync.Once
+}
+
+// newSVIDSource watches the Workload API at socket, a unix socket path or
+// unix:// URI like SPIFFE_ENDPOINT_SOCKET, for the SVID with id, or the
+// first SVID when id is empty
+func newSVIDSource(socket, id string) (*svidSource, error) {
+	path := strings.TrimPrefix(socket, "unix://")
+	if path == "" || strings.Contains(path, "://") {
+		return nil, fmt.Errorf("tls: invalid value for %s (must be a unix socket): %s", envSpiffeSocket, socket)
+	}
+	s := &svidSource{
+		path:  path,
+		id:    id,
+		ready: make(chan struct{}),
+	}
+	s.client = &http.Client{Transport: &http2.Transport{
+		// the agent speaks cleartext HTTP/2 on its socket
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial("unix", s.path)
+		},
+	}}
+	go s.watch()
+	return s, nil
+}
+
+// GetClientCertificate returns the current SVID, waiting for the first
+func (s *svidSource) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	select {
+	case <-s.ready:
+	case <-time.After(svidWait):
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cert != nil {
+		return s.cert, nil
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return nil, errors.New("spiffe: no SVID received from the workload API")
+}
+
+// watch streams SVID updates, reconnecting when the stream ends
+func (s *svidSource) watch() {
+	retry := svidRetryMin
+	for {
+		err := s.fetch()
+		if s.received() {
+			retry = svidRetryMin
+		}
+		router.NewLogger("tls").Warn("fetching the SPIFFE SVID failed", "error", err, "retry", retry)
+		time.Sleep(retry)
+		if retry *= 2; retry > svidRetryMax {
+			retry = svidRetryMax
+		}
+	}
+}
+
+func (s *svidSource) received() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert != nil
+}
+
+// fetch reads SVID responses until the stream fails
+func (s *svidSource) fetch() error {
+	// an empty X509SVIDRequest
+	req, err := http.NewRequest("POST", fetchX509SVIDURL, bytes.NewReader(protowire.Frame(nil)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	// required by the Workload API to tell workloads from other callers
+	req.Header.Set("workload.spiffe.io", "true")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.failed(err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("spiffe: workload API returned %s", resp.Status)
+		s.failed(err)
+		return err
+	}
+	if err = grpcStatus(resp.Header); err != nil {
+		s.failed(err)
+		return err
+	}
+
+	header := make([]byte, protowire.FrameHeader)
+	for {
+		if _, err = io.ReadFull(resp.Body, header); err != nil {
+			if err == io.EOF {
+				if err = grpcStatus(resp.Trailer); err == nil {
+					err = errors.New("spiffe: workload API stream ended")
+				}
+			}
+			s.failed(err)
+			return err
+		}
+		size := binary.BigEndian.Uint32(header[1:])
+		if header[0] != 0 || size > maxSVIDResponse {
+			err = errors.New(errMalformedMsg)
+			s.failed(err)
+			return err
+		}
+		message := make([]byte, size)
+		if _, err = io.ReadFull(resp.Body, message); err != nil {
+			s.failed(err)
+			return err
+		}
+		cert, err := parseSVIDResponse(message, s.id)
+		if err != nil {
+			s.failed(err)
+			return err
+		}
+		s.mu.Lock()
+		s.cert, s.err = cert, nil
+		s.mu.Unlock()
+		s.once.Do(func() { close(s.ready) })
+	}
+}
+
+// failed records err for handshakes while there is no SVID, a rotated SVID
+// is kept until it is replaced
+func (s *svidSource) failed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+	if s.cert == nil {
+		s.once.Do(func() { close(s.ready) })
+	}
+}
+
+// grpcStatus returns the error in the grpc-status of headers or trailers
+func grpcStatus(header http.Header) error {
+	status, err := protowire.ParseStatus(header)
+	if err != nil {
+		return errors.New("spiffe: workload API returned an " + err.Error())
+	}
+	if status == nil {
+		return nil
+	}
+	return fmt.Errorf("spiffe: workload API error %d: %s", status.Code, status.Message)
+}
+
+// parseSVIDResponse returns the certificate of the SVID with id, or the first
+// SVID, of an X509SVIDResponse:
+//
+//	message X509SVIDResponse { repeated X509SVID svids = 1; ... }
+//	message X509SVID {
+//		string spiffe_id = 1;
+//		bytes x509_svid = 2;     // ASN.1 DER certificate chain
+//		bytes x509_svid_key = 3; // PKCS#8 DER private key
+//		...
+//	}
+func parseSVIDResponse(message []byte, id string) (*tls.Certificate, error) {
+	var svids [][]byte
+	if err := protowire.Walk(message, func(field int, _ uint64, value []byte) {
+		if field == 1 && value != nil {
+			svids = append(svids, value)
+		}
+	}); err != nil {
+		return nil, errors.New(errMalformedMsg)
+	}
+	for _, svid := range svids {
+		var spiffeID string
+		var chain, key []byte
+		if err := protowire.Walk(svid, func(field int, _ uint64, value []byte) {
+			if value == nil {
+				return
+			}
+			switch field {
+			case 1:
+				spiffeID = string(value)
+			case 2:
+				chain = value
+			case 3:
+				key = value
+			}
+		}); err != nil {
+			return nil, errors.New(errMalformedMsg)
+		}
+		if id != "" && spiffeID != id {
+			continue
+		}
+		certs, err := x509.ParseCertificates(chain)
+		if err != nil || len(certs) == 0 {
+			return nil, fmt.Errorf("spiffe: invalid SVID certificate for %s", spiffeID)
+		}
+		privateKey, err := x509.ParsePKCS8PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("spiffe: invalid SVID key for %s", spiffeID)
+		}
+		cert := &tls.Certificate{PrivateKey: privateKey, Leaf: certs[0]}
+		for _, c := range certs {
+			cert.Certificate = append(cert.Certificate, c.Raw)
+		}
+		return cert, nil
+	}
+	if id != "" {
+		return nil, fmt.Errorf("spiffe: no SVID for %s", id)
+	}
+	return nil, errors.New("spiffe: no SVID in workload API response")
+}
@@ -0,0 +1,171 @@
+// +build go1.8
+
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/internal/protowire"
+	"golang.org/x/net/http2"
+)
+
+// testSVID returns an X509SVID message for a new self-signed certificate
+func testSVID(t *testing.T, id string, serial int64) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri, _ := url.Parse(id)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "logspout"},
+		URIs:         []*url.URL{uri},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svid := protowire.AppendBytes(nil, 1, []byte(id))
+	svid = protowire.AppendBytes(svid, 2, der)
+	return protowire.AppendBytes(svid, 3, pkcs8)
+}
+
+// fakeWorkloadAPI streams each of responses on the Workload API socket in dir
+func fakeWorkloadAPI(t *testing.T, dir string, responses chan []byte) (net.Listener, string) {
+	path := filepath.Join(dir, "agent.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/SPIFFE.SpiffeWorkloadAPI/FetchX509SVID" || r.Header.Get("workload.spiffe.io") != "true" {
+			w.Header().Set("Grpc-Status", "3")
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		for response := range responses {
+			w.Write(protowire.Frame(response))
+			w.(http.Flusher).Flush()
+		}
+	})
+	go func() {
+		server := new(http2.Server)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeConn(conn, &http2.ServeConnOpts{Handler: handler})
+		}
+	}()
+	return ln, "unix://" + path
+}
+
+func TestSVIDSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spiffe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	responses := make(chan []byte, 2)
+	defer close(responses)
+	ln, socket := fakeWorkloadAPI(t, dir, responses)
+	defer ln.Close()
+
+	responses <- protowire.AppendBytes(protowire.AppendBytes(nil, 1, testSVID(t, "spiffe://example.org/other", 1)),
+		1, testSVID(t, "spiffe://example.org/logspout", 2))
+	source, err := newSVIDSource(socket, "spiffe://example.org/logspout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := source.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Leaf.SerialNumber.Int64() != 2 || len(cert.Certificate) != 1 || cert.PrivateKey == nil {
+		t.Errorf("expected the SVID for the id got serial %v", cert.Leaf.SerialNumber)
+	}
+
+	// rotated SVIDs replace the certificate
+	responses <- protowire.AppendBytes(nil, 1, testSVID(t, "spiffe://example.org/logspout", 3))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cert, _ = source.GetClientCertificate(nil)
+		if cert.Leaf.SerialNumber.Int64() == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the rotated SVID")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSVIDSourceInvalidSocket(t *testing.T) {
+	for _, socket := range []string{"unix://", "tcp://127.0.0.1:8081"} {
+		if _, err := newSVIDSource(socket, ""); err == nil {
+			t.Errorf("%s: expected an error", socket)
+		}
+	}
+}
+
+func TestParseSVIDResponseMalformed(t *testing.T) {
+	for _, message := range [][]byte{
+		{0x0a, 0x05, 0x01},
+		protowire.AppendBytes(nil, 1, protowire.AppendBytes(nil, 2, []byte("not a certificate"))),
+		protowire.AppendBytes(nil, 2, []byte("crl")),
+	} {
+		if _, err := parseSVIDResponse(message, ""); err == nil {
+			t.Errorf("%x: expected an error", message)
+		}
+	}
+}
+
+func TestSpiffeTLSConfig(t *testing.T) {
+	os.Setenv(envSpiffeSocket, "/run/spire/sockets/agent.sock")
+	defer os.Unsetenv(envSpiffeSocket)
+	os.Setenv(envClientCert, clientCertFileLocation)
+	os.Setenv(envClientKey, clientKeyFileLocation)
+	if _, err := createTLSConfig(); err == nil {
+		t.Error("expected an error with a client certificate")
+	}
+	os.Unsetenv(envClientCert)
+	os.Unsetenv(envClientKey)
+
+	tlsConfig := createTestTLSConfig(t)
+	if tlsConfig.GetClientCertificate == nil {
+		t.Fatal("expected the client certificate from the workload API")
+	}
+	saved := clientTLSConfig
+	defer func() { clientTLSConfig = saved }()
+	clientTLSConfig = tlsConfig
+	routeConfig, err := routeTLSConfig(map[string]string{
+		optClientCert: clientCertFileLocation,
+		optClientKey:  clientKeyFileLocation,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routeConfig.GetClientCertificate != nil || len(routeConfig.Certificates) != 1 {
+		t.Error("expected the route's client certificate")
+	}
+}
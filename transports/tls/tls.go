@@ -3,17 +3,25 @@
 package tls
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gliderlabs/logspout/adapters/raw"
 	"github.com/gliderlabs/logspout/router"
+	"github.com/gliderlabs/logspout/transports/compress"
+	"github.com/gliderlabs/logspout/transports/encrypt"
 )
 
 const (
@@ -23,6 +31,20 @@ const (
 	envClientCert         = "LOGSPOUT_TLS_CLIENT_CERT"
 	envClientKey          = "LOGSPOUT_TLS_CLIENT_KEY"
 	envTLSHardening       = "LOGSPOUT_TLS_HARDENING"
+	envSpiffeSocket       = "LOGSPOUT_TLS_SPIFFE_SOCKET"
+	envSpiffeID           = "LOGSPOUT_TLS_SPIFFE_ID"
+
+	// route options overriding the environment for a single route
+	optDisableSystemRoots = "tls.disable_system_roots"
+	optCaCerts            = "tls.ca_certs"
+	optClientCert         = "tls.client_cert"
+	optClientKey          = "tls.client_key"
+	optServerName         = "tls.server_name"
+	optMinVersion         = "tls.min_version"
+	optALPN               = "tls.alpn"
+	optInsecureSkipVerify = "tls.insecure_skip_verify"
+	optVerifyName         = "tls.verify_name"
+	optPinSHA256          = "tls.pin_sha256"
 )
 
 var (
@@ -60,6 +82,11 @@ type tlsTransport int
 
 func init() {
 	router.AdapterTransports.Register(new(tlsTransport), "tls")
+	router.Capabilities.DescribeTransport("tls", append([]string{
+		optCaCerts, optClientCert, optClientKey, optDisableSystemRoots,
+		optInsecureSkipVerify, optServerName, optMinVersion, optVerifyName, optPinSHA256,
+		"keepalive", "write_timeout", "ip_version", "dns_refresh", "proxy",
+	}, append(compress.Options, encrypt.Options...)...))
 	// convenience adapters around raw adapter
 	router.AdapterFactories.Register(rawTLSAdapter, "tls")
 
@@ -79,18 +106,253 @@ func rawTLSAdapter(route *router.Route) (r router.LogAdapter, err error) {
 }
 
 func (t *tlsTransport) Dial(addr string, options map[string]string) (conn net.Conn, err error) {
+	tlsConfig, err := routeTLSConfig(options)
+	if err != nil {
+		return
+	}
+
 	// at this point, if our trust store is empty, there is no point of continuing
 	// since it would be impossible to successfully validate any x509 server certificates
-	if len(clientTLSConfig.RootCAs.Subjects()) < 1 {
+	if !tlsConfig.InsecureSkipVerify && len(tlsConfig.RootCAs.Subjects()) < 1 {
 		err = fmt.Errorf("FATAL: TLS CA trust store is empty! Can not trust any TLS endpoints: tls://%s", addr)
 		return
 	}
 
-	// attempt to establish the TLS connection
-	conn, err = tls.Dial("tcp", addr, clientTLSConfig)
+	timeout, err := router.DialTimeout(options)
+	if err != nil {
+		return
+	}
+	keepAlive, err := router.KeepAlive(options)
+	if err != nil {
+		return
+	}
+	writeTimeout, err := router.WriteTimeout(options)
+	if err != nil {
+		return
+	}
+
+	network, err := router.IPNetwork("tcp", options)
+	if err != nil {
+		return
+	}
+	refresh, err := router.DNSRefresh(options)
+	if err != nil {
+		return
+	}
+	proxy, err := router.ProxyFor(addr, options)
+	if err != nil {
+		return
+	}
+
+	// attempt to establish the TLS connection, the timeout covers
+	// resolving addr, connecting and the handshake
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: keepAlive}
+	if proxy == nil {
+		conn, err = tls.DialWithDialer(dialer, network, addr, tlsConfig)
+		if err != nil {
+			return
+		}
+		conn = router.FollowDNS(conn, addr, network, refresh, timeout, nil)
+	} else if conn, err = dialTLSProxy(proxy, dialer, network, addr, tlsConfig); err != nil {
+		return
+	}
+	if conn, err = encrypt.Wrap(router.TimeoutWrites(conn, writeTimeout), options); err != nil {
+		return
+	}
+	return compress.Wrap(conn, options)
+}
+
+// dialTLSProxy establishes a TLS connection to addr through a tunnel of
+// proxy, verifying the certificate of addr's host
+func dialTLSProxy(proxy *url.URL, dialer *net.Dialer, network, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	raw, err := router.DialProxy(proxy, dialer, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			raw.Close()
+			return nil, err
+		}
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = host
+	}
+	if dialer.Timeout > 0 {
+		raw.SetDeadline(time.Now().Add(dialer.Timeout))
+	}
+	conn := tls.Client(raw, tlsConfig)
+	if err := conn.Handshake(); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	raw.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// routeTLSConfig returns the package wide TLS config, or a copy of it with
+// the tls.* options of a route applied
+func routeTLSConfig(options map[string]string) (tlsConfig *tls.Config, err error) {
+	overridden := false
+	for key := range options {
+		if strings.HasPrefix(key, "tls.") {
+			overridden = true
+		}
+	}
+	if !overridden {
+		return clientTLSConfig, nil
+	}
+	tlsConfig = clientTLSConfig.Clone()
+
+	// a route's CA certificates replace those of LOGSPOUT_TLS_CA_CERTS
+	disableSystemRoots := os.Getenv(envDisableSystemRoots) == "true"
+	if value, ok := options[optDisableSystemRoots]; ok {
+		if disableSystemRoots, err = strconv.ParseBool(value); err != nil {
+			err = fmt.Errorf("tls: invalid value for %s: %s", optDisableSystemRoots, value)
+			return
+		}
+	}
+	if _, ok := options[optDisableSystemRoots]; ok || options[optCaCerts] != "" {
+		caCerts := os.Getenv(envCaCerts)
+		if options[optCaCerts] != "" {
+			caCerts = options[optCaCerts]
+		}
+		if tlsConfig.RootCAs, err = loadRootCAs(disableSystemRoots, caCerts); err != nil {
+			return
+		}
+	}
+
+	if options[optClientCert] != "" || options[optClientKey] != "" {
+		if options[optClientCert] == "" || options[optClientKey] == "" {
+			err = fmt.Errorf("tls: %s and %s must be set together", optClientCert, optClientKey)
+			return
+		}
+		var clientCert tls.Certificate
+		clientCert, err = tls.LoadX509KeyPair(options[optClientCert], options[optClientKey])
+		if err != nil {
+			return
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+		// GetClientCertificate would take precedence over the route's certificate
+		tlsConfig.GetClientCertificate = nil
+	}
+
+	if value := options[optServerName]; value != "" {
+		tlsConfig.ServerName = value
+	}
+
+	if value := options[optALPN]; value != "" {
+		tlsConfig.NextProtos = nil
+		for _, proto := range strings.Split(value, ",") {
+			if proto = strings.TrimSpace(proto); proto != "" {
+				tlsConfig.NextProtos = append(tlsConfig.NextProtos, proto)
+			}
+		}
+	}
+
+	if value := options[optMinVersion]; value != "" {
+		version, ok := tlsVersions[value]
+		if !ok {
+			err = fmt.Errorf("tls: invalid value for %s (must be 1.0, 1.1, 1.2 or 1.3): %s", optMinVersion, value)
+			return
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if value, ok := options[optInsecureSkipVerify]; ok {
+		if tlsConfig.InsecureSkipVerify, err = strconv.ParseBool(value); err != nil {
+			err = fmt.Errorf("tls: invalid value for %s: %s", optInsecureSkipVerify, value)
+			return
+		}
+	}
+
+	pins, err := parsePins(options[optPinSHA256])
+	if err != nil {
+		return
+	}
+	if verifyName := options[optVerifyName]; verifyName != "" || len(pins) > 0 {
+		verifyChain := verifyName != "" && !tlsConfig.InsecureSkipVerify
+		tlsConfig.VerifyConnection = verifyConnection(tlsConfig.RootCAs, verifyName, verifyChain, pins)
+		if verifyChain {
+			// the chain is verified against tls.verify_name by
+			// VerifyConnection instead of the server name sent with SNI
+			tlsConfig.InsecureSkipVerify = true
+		}
+	}
 	return
 }
 
+// parsePins returns the SHA-256 hashes of the comma separated, base64
+// encoded subject public key infos of tls.pin_sha256
+func parsePins(value string) (map[[sha256.Size]byte]bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	pins := make(map[[sha256.Size]byte]bool)
+	for _, pin := range strings.Split(value, ",") {
+		// as given to curl --pinnedpubkey
+		pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256//")
+		hash, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("tls: invalid value for %s (must be base64 encoded SHA-256 hashes): %s", optPinSHA256, pin)
+		}
+		var key [sha256.Size]byte
+		copy(key[:], hash)
+		pins[key] = true
+	}
+	return pins, nil
+}
+
+// verifyConnection verifies the server's certificate chain against
+// verifyName, if verifyChain is set, and that a certificate of the chain has
+// a subject public key info pinned by pins, if any
+func verifyConnection(roots *x509.CertPool, verifyName string, verifyChain bool, pins map[[sha256.Size]byte]bool) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("tls: the server sent no certificate")
+		}
+		chains := state.VerifiedChains
+		if verifyChain {
+			intermediates := x509.NewCertPool()
+			for _, cert := range state.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			var err error
+			chains, err = state.PeerCertificates[0].Verify(x509.VerifyOptions{
+				Roots:         roots,
+				DNSName:       verifyName,
+				Intermediates: intermediates,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if len(pins) == 0 {
+			return nil
+		}
+		if len(chains) == 0 {
+			// unverified with tls.insecure_skip_verify
+			chains = [][]*x509.Certificate{state.PeerCertificates}
+		}
+		for _, chain := range chains {
+			for _, cert := range chain {
+				if pins[sha256.Sum256(cert.RawSubjectPublicKeyInfo)] {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("tls: no certificate of %s matches %s", state.ServerName, optPinSHA256)
+	}
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	// tls.VersionTLS13, which needs go 1.12+
+	"1.3": 0x0304,
+}
+
 // createTLSConfig creates the required TLS configuration that we need to establish a TLS connection
 func createTLSConfig() (tlsConfig *tls.Config, err error) {
 	tlsConfig = &tls.Config{}
@@ -105,15 +367,56 @@ func createTLSConfig() (tlsConfig *tls.Config, err error) {
 	}
 
 	// load possible TLS CA chain(s) for server certificate validation
+	tlsConfig.RootCAs, err = loadRootCAs(os.Getenv(envDisableSystemRoots) == "true", os.Getenv(envCaCerts))
+	if err != nil {
+		return
+	}
+
+	// load a client certificate and key if enabled
+	// we should only attempt this if BOTH cert and key are defined
+	clientCertFilePath := os.Getenv(envClientCert)
+	clientKeyFilePath := os.Getenv(envClientKey)
+	if clientCertFilePath != "" && clientKeyFilePath != "" {
+		var clientCert tls.Certificate
+		clientCert, err = tls.LoadX509KeyPair(clientCertFilePath, clientKeyFilePath)
+		// we should fail if unable to load the keypair since the user intended mutual authentication
+		if err != nil {
+			return
+		}
+		// according to TLS spec (RFC 5246 appendix F.1.1) the certificate message
+		// must provide a valid certificate chain leading to an acceptable certificate authority.
+		// We will make this optional; the client cert pem file can contain more than one certificate
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+
+	// or get the client certificate from the SPIFFE Workload API, which
+	// rotates it without a restart
+	if socket := os.Getenv(envSpiffeSocket); socket != "" {
+		if clientCertFilePath != "" || clientKeyFilePath != "" {
+			err = fmt.Errorf("tls: %s can't be used with %s and %s", envSpiffeSocket, envClientCert, envClientKey)
+			return
+		}
+		var source *svidSource
+		if source, err = newSVIDSource(socket, os.Getenv(envSpiffeID)); err != nil {
+			return
+		}
+		tlsConfig.GetClientCertificate = source.GetClientCertificate
+	}
+	return
+}
+
+// loadRootCAs creates a trust store from the system roots, unless disabled,
+// and a comma separated list of pem files
+func loadRootCAs(disableSystemRoots bool, caCerts string) (pool *x509.CertPool, err error) {
 	// starting with an empty pool
-	tlsConfig.RootCAs = x509.NewCertPool()
+	pool = x509.NewCertPool()
 
 	// load system root CA trust store by default, unless configured not to
 	// if we cannot, then it's fatal.
 	// NOTE that we ONLY fail if SystemCertPool returns an error,
 	// not if our system trust store is empty or doesn't exist!
-	if os.Getenv(envDisableSystemRoots) != "true" {
-		tlsConfig.RootCAs, err = x509.SystemCertPool()
+	if !disableSystemRoots {
+		pool, err = x509.SystemCertPool()
 		if err != nil {
 			return
 		}
@@ -125,8 +428,8 @@ func createTLSConfig() (tlsConfig *tls.Config, err error) {
 	// as the user may not wish to send logs through an untrusted TLS connection
 	// also note that each file specified above can contain one or more certificates
 	// and we also _DO NOT_ check if they are CA certificates (in case of self-signed)
-	if certsEnv := os.Getenv(envCaCerts); certsEnv != "" {
-		certFilePaths := strings.Split(certsEnv, ",")
+	if caCerts != "" {
+		certFilePaths := strings.Split(caCerts, ",")
 		for _, certFilePath := range certFilePaths {
 			// each pem file may contain more than one certficate
 			var certBytes []byte
@@ -134,28 +437,11 @@ func createTLSConfig() (tlsConfig *tls.Config, err error) {
 			if err != nil {
 				return
 			}
-			if !tlsConfig.RootCAs.AppendCertsFromPEM(certBytes) {
+			if !pool.AppendCertsFromPEM(certBytes) {
 				err = fmt.Errorf("failed to load CA certificate(s): %s", certFilePath)
 				return
 			}
 		}
 	}
-
-	// load a client certificate and key if enabled
-	// we should only attempt this if BOTH cert and key are defined
-	clientCertFilePath := os.Getenv(envClientCert)
-	clientKeyFilePath := os.Getenv(envClientKey)
-	if clientCertFilePath != "" && clientKeyFilePath != "" {
-		var clientCert tls.Certificate
-		clientCert, err = tls.LoadX509KeyPair(clientCertFilePath, clientKeyFilePath)
-		// we should fail if unable to load the keypair since the user intended mutual authentication
-		if err != nil {
-			return
-		}
-		// according to TLS spec (RFC 5246 appendix F.1.1) the certificate message
-		// must provide a valid certificate chain leading to an acceptable certificate authority.
-		// We will make this optional; the client cert pem file can contain more than one certificate
-		tlsConfig.Certificates = []tls.Certificate{clientCert}
-	}
 	return
 }
@@ -2,7 +2,10 @@ package tls
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"os"
 	"testing"
 )
@@ -147,3 +150,173 @@ func TestTLSHardening(t *testing.T) {
 		}
 	}
 }
+
+// TestRouteTLSConfigDefault should test that routes without tls options
+// share the package wide TLS config
+func TestRouteTLSConfigDefault(t *testing.T) {
+	testTLSConfig, err := routeTLSConfig(map[string]string{"append_tag": ".db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if testTLSConfig != clientTLSConfig {
+		t.Error("expected package wide TLS config")
+	}
+}
+
+// TestRouteTLSConfigOptions should test the behaviour of per-route TLS options
+func TestRouteTLSConfigOptions(t *testing.T) {
+	os.Unsetenv(envCaCerts)
+	testTLSConfig, err := routeTLSConfig(map[string]string{
+		optDisableSystemRoots: "true",
+		optCaCerts:            caRootCertFileLocation + "," + caIntCertFileLocation,
+		optClientCert:         clientCertFileLocation,
+		optClientKey:          clientKeyFileLocation,
+		optServerName:         "logs.test.linuxctl.com",
+		optMinVersion:         "1.2",
+		optALPN:               "h2, http/1.1",
+		optInsecureSkipVerify: "false",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if testTLSConfig == clientTLSConfig {
+		t.Fatal("expected the package wide TLS config to be copied")
+	}
+	if count := len(testTLSConfig.RootCAs.Subjects()); count != 2 {
+		t.Errorf("expected 2 certs in trust store but got %d", count)
+	}
+	if len(testTLSConfig.Certificates) != 1 {
+		t.Error("failed to load client certficate and key")
+	}
+	if testTLSConfig.ServerName != "logs.test.linuxctl.com" || testTLSConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("unexpected server name %q or min version %x", testTLSConfig.ServerName, testTLSConfig.MinVersion)
+	}
+	if len(testTLSConfig.NextProtos) != 2 || testTLSConfig.NextProtos[0] != "h2" {
+		t.Errorf("unexpected ALPN protocols %v", testTLSConfig.NextProtos)
+	}
+
+	bad := []map[string]string{
+		{optMinVersion: "1.9"},
+		{optInsecureSkipVerify: "maybe"},
+		{optClientCert: clientCertFileLocation},
+		{optCaCerts: "./testdata/missing.pem"},
+		{optPinSHA256: "not-a-hash"},
+		{optPinSHA256: "c2hvcnQ="},
+	}
+	for _, options := range bad {
+		if _, err := routeTLSConfig(options); err == nil {
+			t.Errorf("expected error for options %v", options)
+		}
+	}
+}
+
+// TestRouteTLSDial should test establishing a connection trusting only a route's CAs
+func TestRouteTLSDial(t *testing.T) {
+	serverCert, err := tls.LoadX509KeyPair("./testdata/server_loggingEndpoint.pem", "./testdata/server_loggingEndpoint-key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	transport := new(tlsTransport)
+	options := map[string]string{
+		optDisableSystemRoots: "true",
+		optCaCerts:            caRootCertFileLocation + "," + caIntCertFileLocation,
+		optServerName:         "logs.test.linuxctl.com",
+	}
+	conn, err := transport.Dial(ln.Addr().String(), options)
+	if err != nil {
+		t.Fatalf("expected connection trusting route CAs, got: %s", err)
+	}
+	conn.Close()
+
+	options[optServerName] = "other.example.com"
+	if conn, err := transport.Dial(ln.Addr().String(), options); err == nil {
+		conn.Close()
+		t.Error("expected certificate verification to fail for another server name")
+	}
+}
+
+// TestRouteTLSVerifyNameAndPins should test verifying the server certificate
+// against another name than the one sent with SNI, and pinning its key
+func TestRouteTLSVerifyNameAndPins(t *testing.T) {
+	serverCert, err := tls.LoadX509KeyPair("./testdata/server_loggingEndpoint.pem", "./testdata/server_loggingEndpoint-key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(serverCert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(sum[:])
+	sni := make(chan string, 10)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			sni <- hello.ServerName
+			return nil, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	transport := new(tlsTransport)
+	dial := func(options map[string]string) error {
+		options[optDisableSystemRoots] = "true"
+		options[optCaCerts] = caRootCertFileLocation + "," + caIntCertFileLocation
+		conn, err := transport.Dial(ln.Addr().String(), options)
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	// the load balancer's name is sent, while the certificate is valid for another
+	if err := dial(map[string]string{optServerName: "lb.example.com", optVerifyName: "logs.test.linuxctl.com"}); err != nil {
+		t.Fatalf("expected the certificate verified against %s, got: %s", optVerifyName, err)
+	}
+	if name := <-sni; name != "lb.example.com" {
+		t.Errorf("expected SNI lb.example.com got %q", name)
+	}
+	if err := dial(map[string]string{optServerName: "lb.example.com", optVerifyName: "other.example.com"}); err == nil {
+		t.Error("expected certificate verification to fail for another verify name")
+	}
+	if err := dial(map[string]string{optServerName: "logs.test.linuxctl.com", optPinSHA256: "sha256//" + pin}); err != nil {
+		t.Errorf("expected the pinned key accepted, got: %s", err)
+	}
+	other := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	if err := dial(map[string]string{optServerName: "logs.test.linuxctl.com", optPinSHA256: other}); err == nil {
+		t.Error("expected keys that aren't pinned rejected")
+	}
+	// pins are checked on certificates that aren't verified otherwise
+	if err := dial(map[string]string{optInsecureSkipVerify: "true", optPinSHA256: other + "," + pin}); err != nil {
+		t.Errorf("expected the pinned key of an unverified certificate accepted, got: %s", err)
+	}
+}
@@ -0,0 +1,211 @@
+package udp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	// headers the path MTU has to leave room for besides the payload
+	udpHeader  = 8
+	ipv4Header = 20
+	ipv6Header = 40
+	// the path MTU is measured again this often, as routes change
+	mtuRefresh = time.Minute
+	// smallest MTU every IPv4 host must accept
+	minMTU = 576
+)
+
+var mtuOptions = []string{"udp_mtu", "udp_oversize"}
+
+// Policies for datagrams larger than the path MTU allows
+const (
+	oversizeTruncate = "truncate"
+	oversizeCompress = "compress"
+	oversizeDrop     = "drop"
+)
+
+func getopt(name, dfault string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = dfault
+	}
+	return value
+}
+
+// getOpt returns a route option, falling back to an env var
+func getOpt(options map[string]string, option, env, dfault string) string {
+	if options[option] != "" {
+		return options[option]
+	}
+	return getopt(env, dfault)
+}
+
+// mtuConn keeps the datagrams written to a udp connection small enough to
+// reach the receiver without being fragmented, truncating, compressing or
+// dropping those that are not
+type mtuConn struct {
+	*net.UDPConn
+	mu       sync.Mutex
+	mtu      int
+	auto     bool
+	measured time.Time
+	header   int
+	oversize string
+}
+
+// wrapMTU returns conn limiting datagrams to the udp_mtu route option, a
+// number of bytes or auto to measure the path MTU, or conn itself when the
+// option is unset. conn is closed if the options are invalid.
+func wrapMTU(conn *net.UDPConn, options map[string]string) (net.Conn, error) {
+	c, err := newMTUConn(conn, options)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if c == nil {
+		return conn, nil
+	}
+	return c, nil
+}
+
+func newMTUConn(conn *net.UDPConn, options map[string]string) (*mtuConn, error) {
+	value := getOpt(options, "udp_mtu", "UDP_MTU", "")
+	if value == "" {
+		return nil, nil
+	}
+	c := &mtuConn{UDPConn: conn, header: udpHeader + ipv4Header}
+	if addr, ok := conn.RemoteAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		c.header = udpHeader + ipv6Header
+	}
+	c.oversize = getOpt(options, "udp_oversize", "UDP_OVERSIZE", oversizeTruncate)
+	switch c.oversize {
+	case oversizeTruncate, oversizeCompress, oversizeDrop:
+	default:
+		return nil, errors.New("udp: invalid value for udp_oversize (must be truncate, compress or drop): " + c.oversize)
+	}
+	if value == "auto" {
+		c.auto = true
+		if err := setDontFragment(conn); err != nil {
+			return nil, fmt.Errorf("udp: measuring the path MTU: %s", err)
+		}
+		c.measure()
+		return c, nil
+	}
+	mtu, err := strconv.Atoi(value)
+	if err != nil || mtu < minMTU {
+		return nil, errors.New("udp: invalid value for udp_mtu (must be auto or at least 576): " + value)
+	}
+	c.mtu = mtu
+	return c, nil
+}
+
+// measure updates the path MTU, falling back to the MTU of the interface
+// the connection goes out on, and to the minimum MTU
+func (c *mtuConn) measure() {
+	mtu, err := pathMTU(c.UDPConn)
+	if err != nil || mtu <= 0 {
+		mtu = interfaceMTU(c.LocalAddr())
+	}
+	if mtu < minMTU {
+		mtu = minMTU
+	}
+	c.mtu, c.measured = mtu, time.Now()
+}
+
+// interfaceMTU returns the MTU of the interface with addr, or 0
+func interfaceMTU(addr net.Addr) int {
+	local, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return 0
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(local.IP) {
+				return iface.MTU
+			}
+		}
+	}
+	return 0
+}
+
+// MTU returns the largest payload a datagram may have
+func (c *mtuConn) MTU() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.auto && time.Since(c.measured) > mtuRefresh {
+		c.measure()
+	}
+	return c.mtu - c.header
+}
+
+// Datagram reports that each write is sent as a single datagram
+func (c *mtuConn) Datagram() bool {
+	return true
+}
+
+// Write sends p as a single datagram, applying the oversize policy if it
+// is larger than the path MTU allows. A write rejected because the path
+// MTU shrank is retried once at the new MTU.
+func (c *mtuConn) Write(p []byte) (int, error) {
+	n, err := c.write(p)
+	if err != nil && c.auto && isMsgSize(err) {
+		c.mu.Lock()
+		c.measure()
+		c.mu.Unlock()
+		n, err = c.write(p)
+	}
+	return n, err
+}
+
+func (c *mtuConn) write(p []byte) (int, error) {
+	limit := c.MTU()
+	datagram := p
+	if len(p) > limit {
+		switch c.oversize {
+		case oversizeDrop:
+			return 0, router.NewDeliveryError(router.ErrorDropped,
+				fmt.Errorf("udp: dropping a datagram of %v bytes over the MTU payload of %v bytes", len(p), limit))
+		case oversizeCompress:
+			if compressed := compress(p); len(compressed) <= limit {
+				datagram = compressed
+				break
+			}
+			// too large even compressed
+			datagram = p[:limit]
+		default:
+			datagram = p[:limit]
+		}
+	}
+	if _, err := c.UDPConn.Write(datagram); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// compress returns p compressed as gzip, which GELF receivers detect by the
+// magic bytes of each datagram
+func compress(p []byte) []byte {
+	buf := new(bytes.Buffer)
+	w, _ := gzip.NewWriterLevel(buf, gzip.BestCompression)
+	w.Write(p)
+	w.Close()
+	return buf.Bytes()
+}
@@ -0,0 +1,62 @@
+// +build linux
+
+package udp
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// setDontFragment has the kernel discover the path MTU of conn by sending
+// datagrams with the don't fragment bit set
+func setDontFragment(conn *net.UDPConn) error {
+	return control(conn, func(fd int, ipv6 bool) error {
+		if ipv6 {
+			return syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO)
+		}
+		return syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+	})
+}
+
+// pathMTU returns the path MTU the kernel discovered for conn
+func pathMTU(conn *net.UDPConn) (int, error) {
+	var mtu int
+	err := control(conn, func(fd int, ipv6 bool) error {
+		var err error
+		if ipv6 {
+			mtu, err = syscall.GetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MTU)
+		} else {
+			mtu, err = syscall.GetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_MTU)
+		}
+		return err
+	})
+	return mtu, err
+}
+
+func control(conn *net.UDPConn, f func(fd int, ipv6 bool) error) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	addr, _ := conn.RemoteAddr().(*net.UDPAddr)
+	ipv6 := addr != nil && addr.IP.To4() == nil
+	var ferr error
+	if err := raw.Control(func(fd uintptr) {
+		ferr = f(int(fd), ipv6)
+	}); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// isMsgSize returns whether a write failed for exceeding the path MTU
+func isMsgSize(err error) bool {
+	if opError, ok := err.(*net.OpError); ok {
+		err = opError.Err
+	}
+	if syscallError, ok := err.(*os.SyscallError); ok {
+		err = syscallError.Err
+	}
+	return err == syscall.EMSGSIZE
+}
@@ -0,0 +1,22 @@
+// +build !linux
+
+package udp
+
+import (
+	"errors"
+	"net"
+)
+
+// setDontFragment is only supported on linux, elsewhere the MTU of the
+// outgoing interface is used
+func setDontFragment(conn *net.UDPConn) error {
+	return nil
+}
+
+func pathMTU(conn *net.UDPConn) (int, error) {
+	return 0, errors.New("path MTU discovery not supported")
+}
+
+func isMsgSize(err error) bool {
+	return false
+}
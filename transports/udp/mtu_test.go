@@ -0,0 +1,107 @@
+package udp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// dialMTU returns a connection to a new udp listener dialed with options
+func dialMTU(t *testing.T, options map[string]string) (net.Conn, net.PacketConn) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := new(udpTransport).Dial(ln.LocalAddr().String(), options)
+	if err != nil {
+		ln.Close()
+		t.Fatal(err)
+	}
+	return conn, ln
+}
+
+func read(t *testing.T, ln net.PacketConn) []byte {
+	buf := make([]byte, 65536)
+	ln.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := ln.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:n]
+}
+
+func TestMTUTruncate(t *testing.T) {
+	conn, ln := dialMTU(t, map[string]string{"udp_mtu": "600"})
+	defer ln.Close()
+	defer conn.Close()
+	if !router.Datagram(conn) {
+		t.Error("expected a datagram connection")
+	}
+	big := strings.Repeat("x", 1000)
+	if n, err := conn.Write([]byte(big)); err != nil || n != len(big) {
+		t.Fatalf("unexpected write %v %v", n, err)
+	}
+	if datagram := read(t, ln); len(datagram) != 600-ipv4Header-udpHeader {
+		t.Errorf("expected the datagram truncated to the MTU got %v bytes", len(datagram))
+	}
+	conn.Write([]byte("small"))
+	if datagram := read(t, ln); string(datagram) != "small" {
+		t.Errorf("expected a small datagram as is got %q", datagram)
+	}
+}
+
+func TestMTUCompress(t *testing.T) {
+	conn, ln := dialMTU(t, map[string]string{"udp_mtu": "600", "udp_oversize": "compress"})
+	defer ln.Close()
+	defer conn.Close()
+	big := strings.Repeat("compressible ", 100)
+	conn.Write([]byte(big))
+	r, err := gzip.NewReader(bytes.NewReader(read(t, ln)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(r); string(data) != big {
+		t.Errorf("expected the compressed datagram got %q", data)
+	}
+}
+
+func TestMTUDrop(t *testing.T) {
+	conn, ln := dialMTU(t, map[string]string{"udp_mtu": "600", "udp_oversize": "drop"})
+	defer ln.Close()
+	defer conn.Close()
+	if _, err := conn.Write(make([]byte, 1000)); err == nil {
+		t.Error("expected an error dropping the datagram")
+	}
+}
+
+func TestMTUAuto(t *testing.T) {
+	conn, ln := dialMTU(t, map[string]string{"udp_mtu": "auto"})
+	defer ln.Close()
+	defer conn.Close()
+	mtu := conn.(*mtuConn).MTU()
+	if runtime.GOOS == "linux" && mtu <= 1500 {
+		t.Errorf("expected the loopback path MTU got a payload of %v", mtu)
+	}
+	if mtu < minMTU-ipv4Header-udpHeader {
+		t.Errorf("unexpected payload %v", mtu)
+	}
+}
+
+func TestMTUInvalidOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"udp_mtu": "100"},
+		{"udp_mtu": "large"},
+		{"udp_mtu": "1500", "udp_oversize": "split"},
+	} {
+		if _, err := new(udpTransport).Dial("127.0.0.1:514", options); err == nil {
+			t.Errorf("%v: expected an error", options)
+		}
+	}
+}
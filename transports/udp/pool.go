@@ -0,0 +1,110 @@
+package udp
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var poolOptions = []string{"udp_sockets", "udp_source_ports"}
+
+// sourcePorts returns the number of sockets a route sends from, and the
+// first of the consecutive source ports they are bound to, or 0 for
+// ephemeral ports
+func sourcePorts(options map[string]string) (int, int, error) {
+	value := getOpt(options, "udp_sockets", "UDP_SOCKETS", "")
+	sockets := 1
+	if value != "" {
+		var err error
+		if sockets, err = strconv.Atoi(value); err != nil || sockets < 1 {
+			return 0, 0, errors.New("udp: invalid value for udp_sockets (must be at least 1): " + value)
+		}
+	}
+	ports := getOpt(options, "udp_source_ports", "UDP_SOURCE_PORTS", "")
+	if ports == "" {
+		return sockets, 0, nil
+	}
+	bounds := strings.SplitN(ports, "-", 2)
+	if len(bounds) == 1 {
+		bounds = append(bounds, bounds[0])
+	}
+	first, err := strconv.Atoi(bounds[0])
+	last, lastErr := strconv.Atoi(bounds[1])
+	if err != nil || lastErr != nil || first < 1 || last > 65535 || last < first {
+		return 0, 0, errors.New("udp: invalid value for udp_source_ports (must be a port or a range like 40000-40015): " + ports)
+	}
+	if value == "" {
+		// a socket for every port of the range
+		sockets = last - first + 1
+	} else if sockets > last-first+1 {
+		return 0, 0, errors.New("udp: udp_source_ports " + ports + " has fewer ports than udp_sockets " + value)
+	}
+	return sockets, first, nil
+}
+
+// reusePort lets other sockets, of other routes or logspout processes,
+// bind the same source port
+func reusePort(network, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = setReusePort(fd)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// poolConn spreads the datagrams written to it over several sockets with
+// their own source ports, so receivers don't rate limit them as a single
+// source, and writes aren't serialized on one socket
+type poolConn struct {
+	net.Conn // the first socket
+	conns    []net.Conn
+	next     uint32
+}
+
+// Datagram reports that each write is sent as a single datagram
+func (c *poolConn) Datagram() bool {
+	return true
+}
+
+// Write sends p over the next socket of the pool
+func (c *poolConn) Write(p []byte) (int, error) {
+	next := atomic.AddUint32(&c.next, 1) - 1
+	return c.conns[next%uint32(len(c.conns))].Write(p)
+}
+
+// Close closes every socket of the pool
+func (c *poolConn) Close() error {
+	var err error
+	for _, conn := range c.conns {
+		if cerr := conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// SetDeadline sets the deadlines of every socket of the pool
+func (c *poolConn) SetDeadline(t time.Time) error {
+	for _, conn := range c.conns {
+		if err := conn.SetDeadline(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline of every socket of the pool
+func (c *poolConn) SetWriteDeadline(t time.Time) error {
+	for _, conn := range c.conns {
+		if err := conn.SetWriteDeadline(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package udp
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestPoolSpreadsSourcePorts(t *testing.T) {
+	conn, ln := dialMTU(t, map[string]string{"udp_sockets": "4"})
+	defer ln.Close()
+	defer conn.Close()
+	if !router.Datagram(conn) {
+		t.Error("expected the pool to send datagrams")
+	}
+	sources := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 16)
+		n, addr, err := ln.ReadFrom(buf)
+		if err != nil || string(buf[:n]) != "hello" {
+			t.Fatalf("expected each write as a datagram got %q %v", buf[:n], err)
+		}
+		sources[addr.String()]++
+	}
+	if len(sources) != 4 {
+		t.Errorf("expected datagrams from 4 source ports got %v", sources)
+	}
+	for source, n := range sources {
+		if n != 2 {
+			t.Errorf("expected the writes spread evenly got %v from %s", n, source)
+		}
+	}
+}
+
+func TestPoolSourcePorts(t *testing.T) {
+	free, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := free.LocalAddr().(*net.UDPAddr).Port
+	free.Close()
+
+	ports := strconv.Itoa(port)
+	conn, ln := dialMTU(t, map[string]string{"udp_source_ports": ports})
+	defer ln.Close()
+	defer conn.Close()
+	// another route sharing the source port
+	other, err := new(udpTransport).Dial(ln.LocalAddr().String(), map[string]string{"udp_source_ports": ports})
+	if err != nil {
+		t.Fatal("expected the source port reused", err)
+	}
+	defer other.Close()
+	conn.Write([]byte("hello"))
+	_, addr, err := ln.ReadFrom(make([]byte, 16))
+	if err != nil || addr.(*net.UDPAddr).Port != port {
+		t.Errorf("expected the datagram from source port %v got %v %v", port, addr, err)
+	}
+}
+
+func TestPoolOptions(t *testing.T) {
+	if sockets, port, err := sourcePorts(map[string]string{"udp_source_ports": "40000-40015"}); err != nil || sockets != 16 || port != 40000 {
+		t.Errorf("expected a socket for each port of the range got %v %v %v", sockets, port, err)
+	}
+	if sockets, port, err := sourcePorts(map[string]string{"udp_sockets": "2", "udp_source_ports": "40000-40015"}); err != nil || sockets != 2 || port != 40000 {
+		t.Errorf("expected 2 sockets from the range got %v %v %v", sockets, port, err)
+	}
+	for _, options := range []map[string]string{
+		{"udp_sockets": "0"},
+		{"udp_source_ports": "40015-40000"},
+		{"udp_source_ports": "65536"},
+		{"udp_sockets": "4", "udp_source_ports": "40000-40001"},
+	} {
+		if _, _, err := sourcePorts(options); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+}
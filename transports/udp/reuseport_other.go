@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package udp
+
+// setReusePort is only supported on linux, elsewhere each source port can
+// only be bound by one socket
+func setReusePort(fd uintptr) error {
+	return nil
+}
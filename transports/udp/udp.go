@@ -2,9 +2,11 @@ package udp
 
 import (
 	"net"
+	"time"
 
 	"github.com/gliderlabs/logspout/adapters/raw"
 	"github.com/gliderlabs/logspout/router"
+	"github.com/gliderlabs/logspout/transports/encrypt"
 )
 
 const (
@@ -14,6 +16,7 @@ const (
 
 func init() {
 	router.AdapterTransports.Register(new(udpTransport), "udp")
+	router.Capabilities.DescribeTransport("udp", append(append(append(mtuOptions, poolOptions...), "ip_version", "dns_refresh"), encrypt.Options...))
 	// convenience adapters around raw adapter
 	router.AdapterFactories.Register(rawUDPAdapter, "udp")
 }
@@ -26,18 +29,69 @@ func rawUDPAdapter(route *router.Route) (router.LogAdapter, error) {
 type udpTransport int
 
 func (t *udpTransport) Dial(addr string, options map[string]string) (net.Conn, error) {
-	raddr, err := net.ResolveUDPAddr("udp", addr)
+	timeout, err := router.DialTimeout(options)
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.DialUDP("udp", nil, raddr)
+	network, err := router.IPNetwork("udp", options)
 	if err != nil {
 		return nil, err
 	}
+	refresh, err := router.DNSRefresh(options)
+	if err != nil {
+		return nil, err
+	}
+	sockets, port, err := sourcePorts(options)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialPool(network, addr, timeout, sockets, port, options)
+	if err != nil {
+		return nil, err
+	}
+	// nothing is lost redialing datagram sockets, so they follow the host
+	// name to its new addresses
+	conn = router.FollowDNS(conn, addr, network, refresh, timeout, func() (net.Conn, error) {
+		return dialPool(network, addr, timeout, sockets, port, options)
+	})
+	return encrypt.Wrap(conn, options)
+}
+
+// dialPool dials the sockets a route sends from
+func dialPool(network, addr string, timeout time.Duration, sockets, port int, options map[string]string) (net.Conn, error) {
+	pool := &poolConn{}
+	for i := 0; i < sockets; i++ {
+		// the timeout covers resolving addr
+		dialer := &net.Dialer{Timeout: timeout}
+		if port > 0 {
+			dialer.LocalAddr = &net.UDPAddr{Port: port + i}
+			dialer.Control = reusePort
+		}
+		conn, err := dial(dialer, network, addr, options)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		pool.conns = append(pool.conns, conn)
+	}
+	if sockets == 1 {
+		return pool.conns[0], nil
+	}
+	pool.Conn = pool.conns[0]
+	return pool, nil
+}
+
+func dial(dialer *net.Dialer, network, addr string, options map[string]string) (net.Conn, error) {
+	c, err := dialer.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := c.(*net.UDPConn)
 	// bump up the packet size for large log lines
 	err = conn.SetWriteBuffer(writeBuffer)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
-	return conn, nil
+	return wrapMTU(conn, options)
 }
@@ -0,0 +1,61 @@
+package unix
+
+import (
+	"net"
+
+	"github.com/gliderlabs/logspout/adapters/raw"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/gliderlabs/logspout/transports/compress"
+)
+
+const (
+	// datagrams are limited by the socket's send buffer
+	writeBuffer = 1024 * 1024
+)
+
+func init() {
+	router.AdapterTransports.Register(&unixTransport{"unix"}, "unix")
+	router.AdapterTransports.Register(&unixTransport{"unixgram"}, "unixgram")
+	router.Capabilities.DescribeTransport("unix", compress.Options)
+	router.Capabilities.DescribeTransport("unixgram", []string{})
+	// convenience adapters around raw adapter
+	router.AdapterFactories.Register(rawUnixAdapter, "unix")
+	router.AdapterFactories.Register(rawUnixgramAdapter, "unixgram")
+}
+
+func rawUnixAdapter(route *router.Route) (router.LogAdapter, error) {
+	route.Adapter = "raw+unix"
+	return raw.NewRawAdapter(route)
+}
+
+func rawUnixgramAdapter(route *router.Route) (router.LogAdapter, error) {
+	route.Adapter = "raw+unixgram"
+	return raw.NewRawAdapter(route)
+}
+
+// unixTransport connects to the local socket at a route's address, e.g.
+// syslog+unixgram:///dev/log
+type unixTransport struct {
+	network string
+}
+
+func (t *unixTransport) Dial(addr string, options map[string]string) (net.Conn, error) {
+	timeout, err := router.DialTimeout(options)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.Dial(t.network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if t.network == "unixgram" {
+		// bump up the datagram size for large log lines
+		if err = conn.(*net.UnixConn).SetWriteBuffer(writeBuffer); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	return compress.Wrap(conn, options)
+}
@@ -0,0 +1,94 @@
+package unix
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func tempSocket(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "logspout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "log.sock"), func() { os.RemoveAll(dir) }
+}
+
+func TestUnixStream(t *testing.T) {
+	path, cleanup := tempSocket(t)
+	defer cleanup()
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		if scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	conn, err := (&unixTransport{"unix"}).Dial(path, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if router.Datagram(conn) {
+		t.Error("expected a stream connection")
+	}
+	conn.Write([]byte("hello\n"))
+	if line := <-lines; line != "hello" {
+		t.Errorf("expected hello got %q", line)
+	}
+}
+
+func TestUnixDatagram(t *testing.T) {
+	path, cleanup := tempSocket(t)
+	defer cleanup()
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conn, err := (&unixTransport{"unixgram"}).Dial(path, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !router.Datagram(conn) {
+		t.Error("expected a datagram connection")
+	}
+	conn.Write([]byte("first"))
+	conn.Write([]byte("second"))
+	buf := make([]byte, 64)
+	for _, expected := range []string{"first", "second"} {
+		n, err := l.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != expected {
+			t.Errorf("expected %q got %q", expected, buf[:n])
+		}
+	}
+}
+
+func TestUnixMissingSocket(t *testing.T) {
+	path, cleanup := tempSocket(t)
+	defer cleanup()
+	if _, err := (&unixTransport{"unixgram"}).Dial(path, map[string]string{}); err == nil {
+		t.Error("expected an error dialing a missing socket")
+	}
+}